@@ -0,0 +1,287 @@
+// loadtest drives provision, bind, update and deprovision workloads against a
+// running broker and reports the latency and error distribution of each
+// operation. Run the broker against LocalStack (see AWS_ES_ENDPOINT) or a
+// test account, never against production.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/akkeris/elasticsearch-broker/internal/osbclient"
+)
+
+const apiVersion = "2.13"
+
+var options struct {
+	Url          string
+	Username     string
+	Password     string
+	ServiceId    string
+	PlanId       string
+	UpdatePlanId string
+	Concurrency  int
+	Iterations   int
+	Duration     time.Duration
+	PollInterval time.Duration
+	Timeout      time.Duration
+	SkipBind     bool
+	Json         bool
+}
+
+type Client struct {
+	*osbclient.Client
+}
+
+type Result struct {
+	Operation string
+	Latency   time.Duration
+	Err       error
+}
+
+type Summary struct {
+	Operation string  `json:"operation"`
+	Count     int     `json:"count"`
+	Errors    int     `json:"errors"`
+	P50       float64 `json:"p50_ms"`
+	P90       float64 `json:"p90_ms"`
+	P99       float64 `json:"p99_ms"`
+	Max       float64 `json:"max_ms"`
+	Mean      float64 `json:"mean_ms"`
+}
+
+func init() {
+	flag.StringVar(&options.Url, "url", "http://localhost:8443", "The url of the broker to test.")
+	flag.StringVar(&options.Username, "username", os.Getenv("BROKER_USERNAME"), "The basic auth username of the broker.")
+	flag.StringVar(&options.Password, "password", os.Getenv("BROKER_PASSWORD"), "The basic auth password of the broker.")
+	flag.StringVar(&options.ServiceId, "service", "", "The service id to provision, defaults to the service that offers the plan.")
+	flag.StringVar(&options.PlanId, "plan", "", "The plan id to provision.")
+	flag.StringVar(&options.UpdatePlanId, "update-plan", "", "A plan id to update each instance to, updates are skipped if not set.")
+	flag.IntVar(&options.Concurrency, "concurrency", 1, "The number of workloads to run in parallel.")
+	flag.IntVar(&options.Iterations, "iterations", 1, "The number of workloads each worker runs.")
+	flag.DurationVar(&options.Duration, "duration", 0, "Keep starting workloads until this much time has passed (a soak test), overrides -iterations.")
+	flag.DurationVar(&options.PollInterval, "poll-interval", time.Second*5, "How often to poll the last operation of asynchronous requests.")
+	flag.DurationVar(&options.Timeout, "timeout", time.Minute*60, "How long to wait for an asynchronous operation before it is counted as an error.")
+	flag.BoolVar(&options.SkipBind, "skip-bind", false, "Do not bind or unbind the instances.")
+	flag.BoolVar(&options.Json, "json", false, "Print the report as json.")
+}
+
+func timed(results chan<- Result, operation string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	results <- Result{Operation: operation, Latency: time.Since(start), Err: err}
+	return err
+}
+
+// workload runs one instance through its lifecycle, the instance is always
+// deprovisioned if it was created even if an earlier step failed.
+func (c *Client) workload(results chan<- Result) {
+	instanceId := osbclient.UUID()
+	bindingId := osbclient.UUID()
+	instancePath := "/v2/service_instances/" + instanceId
+	bindingPath := instancePath + "/service_bindings/" + bindingId
+	planId := options.PlanId
+
+	err := timed(results, "provision", func() error {
+		status, _, err := c.Expect("PUT", instancePath+"?accepts_incomplete=true", map[string]interface{}{
+			"service_id":        options.ServiceId,
+			"plan_id":           options.PlanId,
+			"organization_guid": "loadtest",
+			"space_guid":        "loadtest",
+			"context":           map[string]interface{}{"platform": "loadtest"},
+		}, http.StatusOK, http.StatusCreated, http.StatusAccepted)
+		if err != nil || status != http.StatusAccepted {
+			return err
+		}
+		return c.Wait(instanceId, options.ServiceId, options.PlanId, false)
+	})
+	if err != nil {
+		return
+	}
+
+	if !options.SkipBind {
+		err = timed(results, "bind", func() error {
+			_, _, err := c.Expect("PUT", bindingPath, map[string]interface{}{
+				"service_id": options.ServiceId,
+				"plan_id":    planId,
+			}, http.StatusOK, http.StatusCreated)
+			return err
+		})
+		if err == nil {
+			timed(results, "get-binding", func() error {
+				_, _, err := c.Expect("GET", bindingPath, nil, http.StatusOK)
+				return err
+			})
+		}
+	}
+
+	if options.UpdatePlanId != "" {
+		err = timed(results, "update", func() error {
+			status, _, err := c.Expect("PATCH", instancePath+"?accepts_incomplete=true", map[string]interface{}{
+				"service_id": options.ServiceId,
+				"plan_id":    options.UpdatePlanId,
+			}, http.StatusOK, http.StatusAccepted)
+			if err != nil || status != http.StatusAccepted {
+				return err
+			}
+			return c.Wait(instanceId, options.ServiceId, options.PlanId, false)
+		})
+		if err == nil {
+			planId = options.UpdatePlanId
+		}
+	}
+
+	if !options.SkipBind {
+		timed(results, "unbind", func() error {
+			_, _, err := c.Expect("DELETE", bindingPath+"?service_id="+options.ServiceId+"&plan_id="+planId, nil, http.StatusOK, http.StatusGone)
+			return err
+		})
+	}
+
+	timed(results, "deprovision", func() error {
+		status, _, err := c.Expect("DELETE", instancePath+"?accepts_incomplete=true&service_id="+options.ServiceId+"&plan_id="+planId, nil, http.StatusOK, http.StatusAccepted, http.StatusGone)
+		if err != nil || status != http.StatusAccepted {
+			return err
+		}
+		return c.Wait(instanceId, options.ServiceId, options.PlanId, true)
+	})
+}
+
+func percentile(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted)-1) * p)
+	return float64(sorted[i]) / float64(time.Millisecond)
+}
+
+func summarize(results []Result) []Summary {
+	latencies := make(map[string][]time.Duration)
+	errs := make(map[string]int)
+	operations := make([]string, 0)
+	for _, result := range results {
+		if _, ok := latencies[result.Operation]; !ok {
+			operations = append(operations, result.Operation)
+		}
+		latencies[result.Operation] = append(latencies[result.Operation], result.Latency)
+		if result.Err != nil {
+			errs[result.Operation]++
+		}
+	}
+	summaries := make([]Summary, 0)
+	for _, operation := range operations {
+		l := latencies[operation]
+		sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+		var total time.Duration
+		for _, d := range l {
+			total += d
+		}
+		summaries = append(summaries, Summary{
+			Operation: operation,
+			Count:     len(l),
+			Errors:    errs[operation],
+			P50:       percentile(l, 0.50),
+			P90:       percentile(l, 0.90),
+			P99:       percentile(l, 0.99),
+			Max:       percentile(l, 1),
+			Mean:      float64(total) / float64(len(l)) / float64(time.Millisecond),
+		})
+	}
+	return summaries
+}
+
+func main() {
+	flag.Parse()
+	if options.PlanId == "" {
+		fmt.Fprintln(os.Stderr, "The -plan option is required.")
+		os.Exit(2)
+	}
+	client := &Client{&osbclient.Client{
+		Url:          options.Url,
+		Username:     options.Username,
+		Password:     options.Password,
+		ApiVersion:   apiVersion,
+		PollInterval: options.PollInterval,
+		Timeout:      options.Timeout,
+		HTTP:         &http.Client{Timeout: time.Second * 60},
+	}}
+	if options.ServiceId == "" {
+		serviceId, err := client.FindService(options.PlanId)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to get the catalog: %s\n", err.Error())
+			os.Exit(1)
+		}
+		options.ServiceId = serviceId
+	}
+
+	results := make(chan Result, 100)
+	collected := make([]Result, 0)
+	errorCounts := make(map[string]int)
+	done := make(chan bool)
+	go (func() {
+		for result := range results {
+			collected = append(collected, result)
+			if result.Err != nil {
+				errorCounts[result.Err.Error()]++
+				fmt.Fprintf(os.Stderr, "%s failed after %s: %s\n", result.Operation, result.Latency, result.Err.Error())
+			}
+		}
+		done <- true
+	})()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < options.Concurrency; i++ {
+		wg.Add(1)
+		go (func() {
+			defer wg.Done()
+			for n := 0; ; n++ {
+				if options.Duration > 0 && time.Since(start) > options.Duration {
+					return
+				}
+				if options.Duration == 0 && n >= options.Iterations {
+					return
+				}
+				client.workload(results)
+			}
+		})()
+	}
+	wg.Wait()
+	close(results)
+	<-done
+
+	summaries := summarize(collected)
+	failed := 0
+	for _, summary := range summaries {
+		failed += summary.Errors
+	}
+	if options.Json {
+		out, _ := json.MarshalIndent(map[string]interface{}{
+			"elapsed_ms": float64(time.Since(start)) / float64(time.Millisecond),
+			"operations": summaries,
+			"errors":     errorCounts,
+		}, "", "  ")
+		fmt.Println(string(out))
+	} else {
+		fmt.Printf("Ran for %s with a concurrency of %d\n\n", time.Since(start).Round(time.Second), options.Concurrency)
+		fmt.Printf("%-12s %8s %8s %12s %12s %12s %12s %12s\n", "OPERATION", "COUNT", "ERRORS", "MEAN(ms)", "P50(ms)", "P90(ms)", "P99(ms)", "MAX(ms)")
+		for _, s := range summaries {
+			fmt.Printf("%-12s %8d %8d %12.0f %12.0f %12.0f %12.0f %12.0f\n", s.Operation, s.Count, s.Errors, s.Mean, s.P50, s.P90, s.P99, s.Max)
+		}
+		if len(errorCounts) > 0 {
+			fmt.Printf("\nErrors:\n")
+			for err, count := range errorCounts {
+				fmt.Printf("%6d  %s\n", count, err)
+			}
+		}
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"path"
@@ -11,7 +13,7 @@ import (
 	"syscall"
 
 	"github.com/golang/glog"
-	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/gorilla/mux"
 	"github.com/shawn-hurley/osb-broker-k8s-lib/middleware"
 	clientset "k8s.io/client-go/kubernetes"
 	clientrest "k8s.io/client-go/rest"
@@ -19,9 +21,6 @@ import (
 	"github.com/stackimpact/stackimpact-go"
 
 	"github.com/akkeris/elasticsearch-broker/pkg/broker"
-	"github.com/pmorie/osb-broker-lib/pkg/metrics"
-	"github.com/pmorie/osb-broker-lib/pkg/rest"
-	"github.com/pmorie/osb-broker-lib/pkg/server"
 )
 
 var options struct {
@@ -69,6 +68,7 @@ func run() error {
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	defer cancelFunc()
 	go cancelOnInterrupt(ctx, cancelFunc)
+	go refreshOnHangup(ctx)
 
 	return runWithContext(ctx)
 }
@@ -78,6 +78,18 @@ func runWithContext(ctx context.Context) error {
 		fmt.Printf("%s/%s\n", path.Base(os.Args[0]), "0.1.0")
 		return nil
 	}
+	if flag.Arg(0) == "replay" {
+		return broker.RunReplay(ctx, options.Options, flag.Arg(1), flag.Arg(2) == "repair")
+	}
+	if flag.Arg(0) == "encrypt" {
+		return broker.RunEncryptValue()
+	}
+	if flag.Arg(0) == "rotate-credentials" {
+		return broker.RunRotateCredentials(ctx, options.Options)
+	}
+	if flag.Arg(0) == "iam-policy" {
+		return broker.RunIAMPolicy(ctx, options.Options)
+	}
 	if options.RunBackgroundTasks {
 		return broker.RunBackgroundTasks(ctx, options.Options)
 		// The above will never return expect on fatal errors
@@ -93,42 +105,38 @@ func runWithContext(ctx context.Context) error {
 		addr = ":" + os.Getenv("PORT")
 	}
 
-	businessLogic, err := broker.NewBusinessLogic(ctx, options.Options)
-	if err != nil {
-		glog.Errorln("Error starting provision logic")
-		return err
+	if os.Getenv("FEDERATION_BROKERS") != "" {
+		return runFederation(ctx, addr)
 	}
 
-	// Prom. metrics
-	reg := prom.NewRegistry()
-	osbMetrics := metrics.New()
-	reg.MustRegister(osbMetrics)
-
-	api, err := rest.NewAPISurface(businessLogic, osbMetrics)
+	s, businessLogic, err := broker.NewServer(ctx, options.Options)
 	if err != nil {
+		glog.Errorln("Error starting provision logic")
 		return err
 	}
+	businessLogic.RunReconciler(ctx)
 
-	s := server.New(api, reg)
+	if os.Getenv("KIBANA_PROXY_PORT") != "" {
+		go (func() {
+			if err := businessLogic.RunKibanaProxy(":" + os.Getenv("KIBANA_PROXY_PORT")); err != nil {
+				glog.Errorf("Kibana proxy stopped: %s\n", err.Error())
+			}
+		})()
+	}
 
-	businessLogic.RouteActions(s.Router)
-	broker.CrudeOSBIHacks(s.Router, businessLogic)
+	if os.Getenv("GRPC_PORT") != "" {
+		go (func() {
+			if err := businessLogic.RunGRPC(":" + os.Getenv("GRPC_PORT")); err != nil {
+				glog.Errorf("The grpc api stopped: %s\n", err.Error())
+			}
+		})()
+	}
 
 	if options.AuthenticateK8SToken {
-		// get k8s client
-		k8sClient, err := getKubernetesClient(options.KubeConfig)
+		tr, err := getTokenReviewMiddleware(options.KubeConfig)
 		if err != nil {
 			return err
 		}
-		// Create a User Info Authorizer.
-		authz := middleware.SARUserInfoAuthorizer{
-			SAR: k8sClient.AuthorizationV1().SubjectAccessReviews(),
-		}
-		// create TokenReviewMiddleware
-		tr := middleware.TokenReviewMiddleware{
-			TokenReview: k8sClient.Authentication().TokenReviews(),
-			Authorizer:  authz,
-		}
 		// Use TokenReviewMiddleware.
 		s.Router.Use(tr.Middleware)
 	}
@@ -153,6 +161,54 @@ func runWithContext(ctx context.Context) error {
 	return err
 }
 
+// In federation mode the broker only routes requests to the brokers listed in FEDERATION_BROKERS.
+func runFederation(ctx context.Context, addr string) error {
+	federation, err := broker.NewFederation(ctx, options.Options)
+	if err != nil {
+		return err
+	}
+	router := mux.NewRouter()
+	// The router forwards requests with the credentials of each broker, so it must authenticate
+	// the requests it gets itself.
+	if options.AuthenticateK8SToken {
+		tr, err := getTokenReviewMiddleware(options.KubeConfig)
+		if err != nil {
+			return err
+		}
+		router.Use(tr.Middleware)
+	} else if federation.HasCredentials() {
+		router.Use(federation.Authenticate)
+	} else {
+		return errors.New("unable to run federation without authentication, use --authenticate-k8s-token or set FEDERATION_USERNAME and FEDERATION_PASSWORD")
+	}
+	federation.Routes(router)
+	glog.Infof("Starting federation router!")
+	if options.Insecure {
+		return http.ListenAndServe(addr, router)
+	}
+	if options.TLSCertFile == "" || options.TLSKeyFile == "" {
+		return errors.New("unable to run federation securely without --tls-cert-file and --tls-private-key-file")
+	}
+	return http.ListenAndServeTLS(addr, options.TLSCertFile, options.TLSKeyFile, router)
+}
+
+func getTokenReviewMiddleware(kubeConfigPath string) (*middleware.TokenReviewMiddleware, error) {
+	// get k8s client
+	k8sClient, err := getKubernetesClient(kubeConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	// Create a User Info Authorizer.
+	authz := middleware.SARUserInfoAuthorizer{
+		SAR: k8sClient.AuthorizationV1().SubjectAccessReviews(),
+	}
+	// create TokenReviewMiddleware
+	return &middleware.TokenReviewMiddleware{
+		TokenReview: k8sClient.Authentication().TokenReviews(),
+		Authorizer:  authz,
+	}, nil
+}
+
 func getKubernetesClient(kubeConfigPath string) (clientset.Interface, error) {
 	var clientConfig *clientrest.Config
 	var err error
@@ -175,6 +231,22 @@ func getKubernetesClient(kubeConfigPath string) (clientset.Interface, error) {
 	return clientset.NewForConfig(clientConfig)
 }
 
+// refreshOnHangup re-creates the AWS session on SIGHUP, e.g., after the brokers keys were swapped.
+func refreshOnHangup(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for {
+		select {
+		case <-hup:
+			glog.Infof("Received SIGHUP, refreshing the AWS session...")
+			broker.RefreshAWSSession()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
 func cancelOnInterrupt(ctx context.Context, f context.CancelFunc) {
 	term := make(chan os.Signal)
 	signal.Notify(term, os.Interrupt, syscall.SIGTERM)
@@ -0,0 +1,370 @@
+// smoketest verifies a deployed broker end to end, it provisions an instance on a (small)
+// plan, waits for it to become available, binds it, indexes and queries a document through
+// its endpoint, takes a snapshot and deprovisions it. It exits non-zero if any step fails,
+// so it can be ran after each deploy.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/akkeris/elasticsearch-broker/internal/osbclient"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+)
+
+const apiVersion = "2.14"
+
+var options struct {
+	Url                string
+	Username           string
+	Password           string
+	ServiceId          string
+	PlanId             string
+	SnapshotRepository string
+	Sign               bool
+	Keep               bool
+	PollInterval       time.Duration
+	Timeout            time.Duration
+	Json               bool
+}
+
+type Step struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	Message  string  `json:"message,omitempty"`
+	Duration float64 `json:"duration_ms"`
+}
+
+type Client struct {
+	*osbclient.Client
+	steps []Step
+}
+
+func init() {
+	flag.StringVar(&options.Url, "url", "http://localhost:8443", "The url of the broker to test.")
+	flag.StringVar(&options.Username, "username", os.Getenv("BROKER_USERNAME"), "The basic auth username of the broker.")
+	flag.StringVar(&options.Password, "password", os.Getenv("BROKER_PASSWORD"), "The basic auth password of the broker.")
+	flag.StringVar(&options.ServiceId, "service", "", "The service id to provision, defaults to the service that offers the plan.")
+	flag.StringVar(&options.PlanId, "plan", "", "The plan id to provision, use the smallest plan available.")
+	flag.StringVar(&options.SnapshotRepository, "snapshot-repository", os.Getenv("SMOKETEST_SNAPSHOT_REPOSITORY"), "A snapshot repository registered on new instances (e.g., by a post-provision hook), the snapshot is skipped if not set.")
+	flag.BoolVar(&options.Sign, "sign", false, "Sign requests to the instance with the AWS credentials in the environment rather than using the bindings credentials.")
+	flag.BoolVar(&options.Keep, "keep", false, "Do not deprovision the instance, e.g., to investigate a failure.")
+	flag.DurationVar(&options.PollInterval, "poll-interval", time.Second*10, "How often to poll the last operation of asynchronous requests.")
+	flag.DurationVar(&options.Timeout, "timeout", time.Minute*60, "How long to wait for provisioning or deprovisioning to finish.")
+	flag.BoolVar(&options.Json, "json", false, "Print the results as json.")
+}
+
+// step runs and records one step of the test.
+func (c *Client) step(name string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	step := Step{Name: name, Status: "passed", Duration: float64(time.Since(start)) / float64(time.Millisecond)}
+	if err != nil {
+		step.Status = "failed"
+		step.Message = err.Error()
+	}
+	c.steps = append(c.steps, step)
+	if !options.Json {
+		fmt.Printf("%-12s %-7s %8s  %s\n", step.Name, step.Status, time.Since(start).Round(time.Second), step.Message)
+	}
+	return err
+}
+
+func (c *Client) skip(name string, reason string) {
+	c.steps = append(c.steps, Step{Name: name, Status: "skipped", Message: reason})
+	if !options.Json {
+		fmt.Printf("%-12s %-7s %8s  %s\n", name, "skipped", "", reason)
+	}
+}
+
+// Cluster talks to the instance through the endpoint in its bindings credentials.
+type Cluster struct {
+	url      string
+	username string
+	password string
+	signer   *v4.Signer
+	http     *http.Client
+}
+
+func (c *Cluster) do(method string, path string, body interface{}) (int, []byte, error) {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return 0, nil, err
+		}
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.url, "/")+path, bytes.NewReader(data))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.signer != nil {
+		if _, err = c.signer.Sign(req, bytes.NewReader(data), "es", os.Getenv("AWS_REGION"), time.Now()); err != nil {
+			return 0, nil, err
+		}
+	} else if c.username != "" && c.password != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	response, err := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, response, err
+}
+
+func (c *Cluster) expect(method string, path string, body interface{}, codes ...int) ([]byte, error) {
+	status, data, err := c.do(method, path, body)
+	if err != nil {
+		return data, err
+	}
+	for _, code := range codes {
+		if status == code {
+			return data, nil
+		}
+	}
+	return data, fmt.Errorf("%s %s returned %d: %s", method, path, status, string(data))
+}
+
+// documentPath is the path of a document, elasticsearch before 6.2 does not accept
+// the _doc type.
+func (c *Cluster) documentPath(index string, id string) (string, error) {
+	data, err := c.expect("GET", "/", nil, http.StatusOK)
+	if err != nil {
+		return "", err
+	}
+	var info struct {
+		Version struct {
+			Number string `json:"number"`
+		} `json:"version"`
+	}
+	if err = json.Unmarshal(data, &info); err != nil {
+		return "", err
+	}
+	parts := strings.Split(info.Version.Number, ".")
+	if len(parts) >= 2 {
+		major, _ := strconv.Atoi(parts[0])
+		minor, _ := strconv.Atoi(parts[1])
+		if major < 6 || (major == 6 && minor < 2) {
+			return "/" + index + "/doc/" + id, nil
+		}
+	}
+	return "/" + index + "/_doc/" + id, nil
+}
+
+// searchHits returns the total hits of a search response, which is a number before
+// elasticsearch 7 and an object after.
+func searchHits(data []byte) (int, error) {
+	var response struct {
+		Hits struct {
+			Total json.RawMessage `json:"total"`
+		} `json:"hits"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return 0, err
+	}
+	var total int
+	if err := json.Unmarshal(response.Hits.Total, &total); err == nil {
+		return total, nil
+	}
+	var totalObject struct {
+		Value int `json:"value"`
+	}
+	if err := json.Unmarshal(response.Hits.Total, &totalObject); err != nil {
+		return 0, err
+	}
+	return totalObject.Value, nil
+}
+
+func (c *Client) run() {
+	instanceId := osbclient.UUID()
+	bindingId := osbclient.UUID()
+	instancePath := "/v2/service_instances/" + instanceId
+	bindingPath := instancePath + "/service_bindings/" + bindingId
+	marker := osbclient.UUID()
+
+	err := c.step("provision", func() error {
+		status, _, err := c.Expect("PUT", instancePath+"?accepts_incomplete=true", map[string]interface{}{
+			"service_id":        options.ServiceId,
+			"plan_id":           options.PlanId,
+			"organization_guid": "smoketest",
+			"space_guid":        "smoketest",
+			"context":           map[string]interface{}{"platform": "smoketest"},
+		}, http.StatusOK, http.StatusCreated, http.StatusAccepted)
+		if err != nil || status != http.StatusAccepted {
+			return err
+		}
+		return c.Wait(instanceId, options.ServiceId, options.PlanId, false)
+	})
+	if err != nil {
+		c.deprovision(instanceId, instancePath)
+		return
+	}
+
+	cluster := &Cluster{http: &http.Client{Timeout: time.Second * 60}}
+	err = c.step("bind", func() error {
+		_, data, err := c.Expect("PUT", bindingPath, map[string]interface{}{
+			"service_id": options.ServiceId,
+			"plan_id":    options.PlanId,
+		}, http.StatusOK, http.StatusCreated)
+		if err != nil {
+			return err
+		}
+		var binding struct {
+			Credentials map[string]interface{} `json:"credentials"`
+		}
+		if err = json.Unmarshal(data, &binding); err != nil {
+			return err
+		}
+		cluster.url, _ = binding.Credentials["ES_URL"].(string)
+		cluster.username, _ = binding.Credentials["ES_USERNAME"].(string)
+		cluster.password, _ = binding.Credentials["ES_PASSWORD"].(string)
+		if cluster.url == "" {
+			return errors.New("The binding has no ES_URL")
+		}
+		if options.Sign {
+			cluster.signer = v4.NewSigner(session.New(&aws.Config{Region: aws.String(os.Getenv("AWS_REGION"))}).Config.Credentials)
+		}
+		return nil
+	})
+
+	if err == nil {
+		err = c.step("index", func() error {
+			path, err := cluster.documentPath("smoketest", "1")
+			if err != nil {
+				return err
+			}
+			_, err = cluster.expect("PUT", path+"?refresh=true", map[string]interface{}{
+				"marker":  marker,
+				"created": time.Now().UTC().Format(time.RFC3339),
+			}, http.StatusOK, http.StatusCreated)
+			return err
+		})
+		if err == nil {
+			c.step("query", func() error {
+				data, err := cluster.expect("GET", "/smoketest/_search?q=marker:"+marker, nil, http.StatusOK)
+				if err != nil {
+					return err
+				}
+				hits, err := searchHits(data)
+				if err != nil {
+					return err
+				}
+				if hits != 1 {
+					return errors.New("Expected the indexed document to be found, found " + strconv.Itoa(hits) + " documents")
+				}
+				return nil
+			})
+		}
+		if options.SnapshotRepository == "" {
+			c.skip("snapshot", "No -snapshot-repository was given")
+		} else {
+			c.step("snapshot", func() error {
+				path := "/_snapshot/" + options.SnapshotRepository + "/smoketest-" + marker
+				data, err := cluster.expect("PUT", path+"?wait_for_completion=true", map[string]interface{}{
+					"indices":              "smoketest",
+					"include_global_state": false,
+				}, http.StatusOK)
+				if err != nil {
+					return err
+				}
+				var snapshot struct {
+					Snapshot struct {
+						State string `json:"state"`
+					} `json:"snapshot"`
+				}
+				if err = json.Unmarshal(data, &snapshot); err != nil {
+					return err
+				}
+				if snapshot.Snapshot.State != "SUCCESS" {
+					return errors.New("The snapshot finished with the state " + snapshot.Snapshot.State)
+				}
+				_, err = cluster.expect("DELETE", path, nil, http.StatusOK)
+				return err
+			})
+		}
+		c.step("unbind", func() error {
+			_, _, err := c.Expect("DELETE", bindingPath+"?service_id="+options.ServiceId+"&plan_id="+options.PlanId, nil, http.StatusOK, http.StatusGone)
+			return err
+		})
+	}
+	c.deprovision(instanceId, instancePath)
+}
+
+// deprovision always removes the instance (unless -keep is given) so failed runs do not
+// leak domains.
+func (c *Client) deprovision(instanceId string, instancePath string) {
+	if options.Keep {
+		c.skip("deprovision", "The instance "+instanceId+" was kept")
+		return
+	}
+	c.step("deprovision", func() error {
+		status, _, err := c.Expect("DELETE", instancePath+"?accepts_incomplete=true&service_id="+options.ServiceId+"&plan_id="+options.PlanId, nil, http.StatusOK, http.StatusAccepted, http.StatusGone)
+		if err != nil || status != http.StatusAccepted {
+			return err
+		}
+		return c.Wait(instanceId, options.ServiceId, options.PlanId, true)
+	})
+}
+
+func main() {
+	flag.Parse()
+	if options.PlanId == "" {
+		fmt.Fprintln(os.Stderr, "The -plan option is required.")
+		os.Exit(2)
+	}
+	client := &Client{Client: &osbclient.Client{
+		Url:          options.Url,
+		Username:     options.Username,
+		Password:     options.Password,
+		ApiVersion:   apiVersion,
+		PollInterval: options.PollInterval,
+		Timeout:      options.Timeout,
+		HTTP:         &http.Client{Timeout: time.Second * 60},
+	}, steps: make([]Step, 0)}
+	if options.ServiceId == "" {
+		serviceId, err := client.FindService(options.PlanId)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to get the catalog: %s\n", err.Error())
+			os.Exit(1)
+		}
+		options.ServiceId = serviceId
+	}
+	start := time.Now()
+	client.run()
+
+	failed := 0
+	for _, step := range client.steps {
+		if step.Status == "failed" {
+			failed++
+		}
+	}
+	if options.Json {
+		out, _ := json.MarshalIndent(map[string]interface{}{
+			"elapsed_ms": float64(time.Since(start)) / float64(time.Millisecond),
+			"passed":     failed == 0,
+			"steps":      client.steps,
+		}, "", "  ")
+		fmt.Println(string(out))
+	} else if failed == 0 {
+		fmt.Printf("\nThe smoke test passed in %s\n", time.Since(start).Round(time.Second))
+	} else {
+		fmt.Printf("\nThe smoke test failed (%d steps) after %s\n", failed, time.Since(start).Round(time.Second))
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,139 @@
+// Package osbclient is the minimal open service broker client the load and smoke tests
+// drive a running broker with.
+package osbclient
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type Client struct {
+	Url          string
+	Username     string
+	Password     string
+	ApiVersion   string
+	PollInterval time.Duration
+	Timeout      time.Duration
+	HTTP         *http.Client
+}
+
+type LastOperation struct {
+	State       string `json:"state"`
+	Description string `json:"description"`
+}
+
+func UUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func (c *Client) Do(method string, path string, body interface{}) (int, []byte, error) {
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, nil, err
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader([]byte{})
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.Url, "/")+path, reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("X-Broker-API-Version", c.ApiVersion)
+	req.Header.Set("Content-Type", "application/json")
+	if c.Username != "" || c.Password != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, data, err
+}
+
+// Expect makes the request and returns an error unless it returned one of the codes.
+func (c *Client) Expect(method string, path string, body interface{}, codes ...int) (int, []byte, error) {
+	status, data, err := c.Do(method, path, body)
+	if err != nil {
+		return status, data, err
+	}
+	for _, code := range codes {
+		if status == code {
+			return status, data, nil
+		}
+	}
+	return status, data, fmt.Errorf("%s %s returned %d: %s", method, path, status, string(data))
+}
+
+// Wait polls the last operation until it succeeds or fails, gone is treated
+// as success when waiting on a deprovision.
+func (c *Client) Wait(instanceId string, serviceId string, planId string, deleting bool) error {
+	deadline := time.Now().Add(c.Timeout)
+	path := "/v2/service_instances/" + instanceId + "/last_operation?service_id=" + serviceId + "&plan_id=" + planId
+	for time.Now().Before(deadline) {
+		status, data, err := c.Do("GET", path, nil)
+		if err != nil {
+			return err
+		}
+		if status == http.StatusGone && deleting {
+			return nil
+		}
+		if status != http.StatusOK {
+			return fmt.Errorf("last operation returned %d: %s", status, string(data))
+		}
+		var op LastOperation
+		if err = json.Unmarshal(data, &op); err != nil {
+			return err
+		}
+		if op.State == "succeeded" {
+			return nil
+		}
+		if op.State == "failed" {
+			return errors.New("operation failed: " + op.Description)
+		}
+		time.Sleep(c.PollInterval)
+	}
+	return errors.New("timed out waiting for the operation to finish")
+}
+
+// FindService returns the id of the service in the catalog that offers the plan.
+func (c *Client) FindService(planId string) (string, error) {
+	_, data, err := c.Expect("GET", "/v2/catalog", nil, http.StatusOK)
+	if err != nil {
+		return "", err
+	}
+	var catalog struct {
+		Services []struct {
+			ID    string `json:"id"`
+			Plans []struct {
+				ID string `json:"id"`
+			} `json:"plans"`
+		} `json:"services"`
+	}
+	if err = json.Unmarshal(data, &catalog); err != nil {
+		return "", err
+	}
+	for _, service := range catalog.Services {
+		for _, plan := range service.Plans {
+			if plan.ID == planId {
+				return service.ID, nil
+			}
+		}
+	}
+	return "", errors.New("Cannot find the plan " + planId + " in the catalog")
+}
@@ -0,0 +1,134 @@
+package broker
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// Freezes and legal holds are admin toggles, a state of an instance that is on or off (with
+// why) and that only operators with the admin token can change. Each change is recorded as an
+// event of the instance with who made it and why, the events are the history of the toggle.
+
+type adminToggle struct {
+	// name is what the toggle is called in logs, e.g., "freeze"
+	name string
+	// field is the json field of the state in requests and responses, e.g., "frozen"
+	field string
+	// reasons is what a reason is, e.g., "the incident or legal hold"
+	reasons  string
+	onEvent  EventType
+	offEvent EventType
+	get      func(storage Storage, InstanceID string) (bool, string, error)
+	set      func(storage Storage, InstanceID string, on bool, reason string, actor string) error
+}
+
+// state returns whether the toggle is on for the instance and why, the errors are osb errors.
+func (t adminToggle) state(storage Storage, InstanceID string) (bool, string, error) {
+	on, reason, err := t.get(storage, InstanceID)
+	if err != nil && err.Error() == "Cannot find resource instance" {
+		return false, "", NotFound()
+	} else if err != nil {
+		glog.Errorf("Unable to get the %s of %s: %s\n", t.name, InstanceID, err.Error())
+		return false, "", InternalServerError()
+	}
+	return on, reason, nil
+}
+
+// isOn is used by the automated actions, which treat an instance they can't check as off.
+func (t adminToggle) isOn(storage Storage, InstanceID string) bool {
+	on, _, err := t.get(storage, InstanceID)
+	return err == nil && on
+}
+
+// history returns the changes of the toggle from the events of the instance, oldest first.
+func (t adminToggle) history(storage Storage, InstanceID string) ([]map[string]interface{}, error) {
+	events, err := storage.GetEvents(InstanceID)
+	if err != nil {
+		glog.Errorf("Unable to get the events of %s: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	history := make([]map[string]interface{}, 0)
+	for _, event := range events {
+		if event.Type == t.onEvent || event.Type == t.offEvent {
+			history = append(history, map[string]interface{}{
+				t.field:   event.Type == t.onEvent,
+				"actor":   event.Data.Actor,
+				"reason":  event.Data.Reason,
+				"created": event.Created,
+			})
+		}
+	}
+	return history, nil
+}
+
+// decode checks the request is from an operator with the admin token and returns who they
+// are with the state and the reason of its body.
+func (t adminToggle) decode(InstanceID string, c *broker.RequestContext) (string, bool, string, error) {
+	actor, ok := adminActor(c)
+	if !ok {
+		glog.Infof("Rejected a change to the %s of %s without a valid admin token and user\n", t.name, InstanceID)
+		return "", false, "", Forbidden()
+	}
+	invalid := UnprocessableEntityWithMessage("InvalidRequest", "The body must be {\""+t.field+"\":true, \"reason\":\"...\"}.")
+	if c.Request.Body == nil {
+		return "", false, "", invalid
+	}
+	var request map[string]json.RawMessage
+	if err := json.NewDecoder(c.Request.Body).Decode(&request); err != nil {
+		return "", false, "", invalid
+	}
+	var on bool
+	if value, ok := request[t.field]; ok {
+		if err := json.Unmarshal(value, &on); err != nil {
+			return "", false, "", invalid
+		}
+	}
+	var reason string
+	if value, ok := request["reason"]; ok {
+		if err := json.Unmarshal(value, &reason); err != nil {
+			return "", false, "", invalid
+		}
+	}
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return "", false, "", UnprocessableEntityWithMessage("InvalidRequest", "A reason (e.g., "+t.reasons+") is required.")
+	}
+	return actor, on, reason, nil
+}
+
+// getAction returns the state of the toggle and its history.
+func (t adminToggle) getAction(storage Storage, InstanceID string) (interface{}, error) {
+	on, reason, err := t.state(storage, InstanceID)
+	if err != nil {
+		return nil, err
+	}
+	history, err := t.history(storage, InstanceID)
+	if err != nil {
+		return nil, err
+	}
+	response := map[string]interface{}{t.field: on, "history": history}
+	if reason != "" {
+		response["reason"] = reason
+	}
+	return response, nil
+}
+
+// setAction turns the toggle on or off with the body of the request.
+func (t adminToggle) setAction(storage Storage, InstanceID string, c *broker.RequestContext) (interface{}, error) {
+	actor, on, reason, err := t.decode(InstanceID, c)
+	if err != nil {
+		return nil, err
+	}
+	err = t.set(storage, InstanceID, on, reason, actor)
+	if err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Unable to set the %s of %s: %s\n", t.name, InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	glog.Infof("%s set the %s to %t on %s (%s)\n", actor, t.name, on, InstanceID, reason)
+	return map[string]interface{}{t.field: on, "reason": reason, "actor": actor}, nil
+}
@@ -0,0 +1,162 @@
+package broker
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// The most of a query response returned, diagnostics queries should never need more.
+const maxAdminQueryResponse = 1024 * 1024
+
+// An AdminQuery is a break-glass diagnostic query ran against an instance by an
+// operator rather than its owner, every query is recorded before it is ran.
+type AdminQuery struct {
+	Id         string    `json:"id"`
+	InstanceId string    `json:"instance_id"`
+	Actor      string    `json:"actor"`
+	Reason     string    `json:"reason"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	Created    time.Time `json:"created"`
+}
+
+type AdminQueryRequest struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+type AdminQueryResponse struct {
+	Id        string          `json:"id"`
+	Status    int             `json:"status"`
+	Body      json.RawMessage `json:"body,omitempty"`
+	Text      string          `json:"text,omitempty"`
+	Truncated bool            `json:"truncated,omitempty"`
+}
+
+// ValidateAdminQueryPath only allows read-only diagnostic apis, _cat/*, _cluster/* and
+// _nodes/stats, the path is cleaned first so it cannot escape them.
+func ValidateAdminQueryPath(query string) (string, bool) {
+	u, err := url.Parse(query)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Fragment != "" {
+		return "", false
+	}
+	p := strings.TrimPrefix(u.Path, "/")
+	if p == "" || path.Clean("/"+p) != "/"+p || strings.Contains(u.RawPath, "%2") {
+		return "", false
+	}
+	if !strings.HasPrefix(p, "_cat/") && !strings.HasPrefix(p, "_cluster/") && p != "_nodes/stats" && !strings.HasPrefix(p, "_nodes/stats/") {
+		return "", false
+	}
+	if u.RawQuery != "" {
+		return "/" + p + "?" + u.RawQuery, true
+	}
+	return "/" + p, true
+}
+
+// adminActor returns who is making the request if it has the admin token, ADMIN_TOKEN must
+// be set for the admin apis (the catalog, freezes, legal holds, etc.) to be enabled at all.
+func adminActor(c *broker.RequestContext) (string, bool) {
+	return tokenActor(c, "ADMIN_TOKEN")
+}
+
+// adminQueryActor returns who is running the query if the request has the admin query token,
+// ADMIN_QUERY_TOKEN only grants running read-only admin queries.
+func adminQueryActor(c *broker.RequestContext) (string, bool) {
+	return tokenActor(c, "ADMIN_QUERY_TOKEN")
+}
+
+// tokenActor returns the x-admin-user of the request if its x-admin-token is the secret in
+// the environment variable name, nothing is accepted while it is unset.
+func tokenActor(c *broker.RequestContext, name string) (string, bool) {
+	token := os.Getenv(name)
+	if token == "" || c == nil || c.Request == nil {
+		return "", false
+	}
+	if subtle.ConstantTimeCompare([]byte(c.Request.Header.Get("x-admin-token")), []byte(token)) != 1 {
+		return "", false
+	}
+	actor := strings.TrimSpace(c.Request.Header.Get("x-admin-user"))
+	return actor, actor != ""
+}
+
+// POST /v2/service_instances/{instance_id}/actions/admin-query with {"path":"_cat/indices?v", "reason":"..."}
+func (b *BusinessLogic) AdminQueryAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	actor, ok := adminQueryActor(c)
+	if !ok {
+		glog.Infof("Rejected an admin query against %s without a valid admin token and user\n", InstanceID)
+		return nil, Forbidden()
+	}
+	var request AdminQueryRequest
+	if c.Request.Body == nil {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "The body must be {\"path\":\"...\", \"reason\":\"...\"}.")
+	}
+	if err := json.NewDecoder(c.Request.Body).Decode(&request); err != nil {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "The body must be {\"path\":\"...\", \"reason\":\"...\"}.")
+	}
+	if strings.TrimSpace(request.Reason) == "" {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "A reason (e.g., the incident) is required.")
+	}
+	query, ok := ValidateAdminQueryPath(request.Path)
+	if !ok {
+		return nil, UnprocessableEntityWithMessage("InvalidPath", "Only _cat/*, _cluster/* and _nodes/stats may be queried.")
+	}
+	Instance, err := b.GetInstanceById(InstanceID)
+	if err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Error finding instance id (during admin query): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	// the query is never ran unless it has been recorded
+	id, err := b.storage.AddAdminQuery(&AdminQuery{InstanceId: InstanceID, Actor: actor, Reason: request.Reason, Path: query})
+	if err != nil {
+		glog.Errorf("Unable to record admin query, refusing to run it: %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	glog.Infof("Admin query %s by %s against %s: GET %s (%s)\n", id, actor, Instance.Name, query, request.Reason)
+	response, status, err := NewClusterClient().Do(Instance, "GET", query, nil)
+	if err != nil {
+		glog.Errorf("Admin query %s against %s failed: %s\n", id, Instance.Name, err.Error())
+		b.storage.UpdateAdminQuery(id, 0)
+		return nil, UnprocessableEntityWithMessage("QueryFailed", err.Error())
+	}
+	if err = b.storage.UpdateAdminQuery(id, status); err != nil {
+		glog.Errorf("Unable to record the status of admin query %s: %s\n", id, err.Error())
+	}
+	result := AdminQueryResponse{Id: id, Status: status}
+	if len(response) > maxAdminQueryResponse {
+		response = response[0:maxAdminQueryResponse]
+		result.Truncated = true
+	}
+	if json.Valid(response) {
+		result.Body = json.RawMessage(response)
+	} else {
+		result.Text = string(response)
+	}
+	return result, nil
+}
+
+// GET /v2/service_instances/{instance_id}/actions/admin-query lists the admin queries
+// ran against an instance, so its owners can see what was looked at.
+func (b *BusinessLogic) ListAdminQueriesAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	if _, err := b.storage.GetInstance(InstanceID); err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Error finding instance id (during list admin queries): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	queries, err := b.storage.GetAdminQueries(InstanceID)
+	if err != nil {
+		glog.Errorf("Unable to get admin queries for %s: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	return queries, nil
+}
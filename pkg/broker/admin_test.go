@@ -0,0 +1,44 @@
+package broker
+
+import (
+	"testing"
+)
+
+func TestValidateAdminQueryPath(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+		ok    bool
+	}{
+		{"cat api", "_cat/indices", "/_cat/indices", true},
+		{"leading slash", "/_cluster/health", "/_cluster/health", true},
+		{"nodes stats", "_nodes/stats", "/_nodes/stats", true},
+		{"nodes stats metric", "_nodes/stats/jvm", "/_nodes/stats/jvm", true},
+		{"other nodes api", "_nodes/hot_threads", "", false},
+		{"write api", "logs/_doc/1", "", false},
+		{"empty", "", "", false},
+		{"prefix without a slash", "_cat", "", false},
+		{"traversal", "_cat/../_security/user", "", false},
+		{"traversal from the root", "/_cluster/../../_security/user", "", false},
+		{"dot segment", "_cat/./indices", "", false},
+		{"trailing traversal", "_cat/indices/..", "", false},
+		{"double slash", "_cat//indices", "", false},
+		{"encoded traversal", "_cat/%2e%2e/_security/user", "", false},
+		{"encoded slash", "_cat/indices%2F..%2F_security", "", false},
+		{"encoded lowercase slash", "_cat/indices%2flogs", "", false},
+		{"absolute url", "http://other.example.com/_cat/indices", "", false},
+		{"scheme relative url", "//other.example.com/_cat/indices", "", false},
+		{"other scheme", "file:///_cat/indices", "", false},
+		{"query string", "_cat/indices?v&format=json", "/_cat/indices?v&format=json", true},
+		{"query string only", "?v", "", false},
+		{"query string on another api", "_search?q=*", "", false},
+		{"fragment", "_cat/indices?v#health", "", false},
+	}
+	for _, test := range tests {
+		got, ok := ValidateAdminQueryPath(test.query)
+		if got != test.want || ok != test.ok {
+			t.Errorf("%s: ValidateAdminQueryPath(%q) = %q, %t, want %q, %t", test.name, test.query, got, ok, test.want, test.ok)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// Annotations describe what an instance is for: its purpose, who to escalate to and the
+// classification of its data, set with the annotations action. With "publish":true they are
+// written (with the owner and contacts of the instance) to the cluster, as the document
+// instance of the .broker-metadata index and as an "About this cluster" markdown
+// visualization in Kibana, so anyone opening the cluster or its Kibana knows who owns it and
+// what it is for. They are published again when the contacts change, and removed from the
+// cluster when publish is turned off. DATA_CLASSIFICATIONS (comma separated) limits the
+// classifications that can be set.
+
+const (
+	metadataIndex           = ".broker-metadata"
+	metadataDocument        = "/" + metadataIndex + "/_doc/instance"
+	metadataSavedObject     = "/api/saved_objects/visualization/broker-metadata"
+	maxEscalationContacts   = 10
+	maxAnnotationLength     = 1024
+	maxClassificationLength = 64
+)
+
+type Annotations struct {
+	Purpose            string   `json:"purpose"`
+	Escalation         []string `json:"escalation"`
+	DataClassification string   `json:"data_classification"`
+	Publish            bool     `json:"publish"`
+}
+
+// InstanceMetadata is the document published to the .broker-metadata index.
+type InstanceMetadata struct {
+	InstanceId         string    `json:"instance_id"`
+	Name               string    `json:"name"`
+	Owner              string    `json:"owner"`
+	Plan               string    `json:"plan"`
+	Purpose            string    `json:"purpose"`
+	Escalation         []string  `json:"escalation"`
+	Contacts           []string  `json:"contacts"`
+	DataClassification string    `json:"data_classification"`
+	Updated            time.Time `json:"updated"`
+}
+
+func ValidateAnnotations(annotations *Annotations) error {
+	if len(annotations.Purpose) > maxAnnotationLength {
+		return errors.New("The purpose must be at most " + strconv.Itoa(maxAnnotationLength) + " characters.")
+	}
+	if len(annotations.Escalation) > maxEscalationContacts {
+		return errors.New("An instance can have at most " + strconv.Itoa(maxEscalationContacts) + " escalation contacts.")
+	}
+	for _, contact := range annotations.Escalation {
+		if strings.TrimSpace(contact) == "" || len(contact) > 256 {
+			return errors.New("Escalation contacts must be 1 to 256 characters.")
+		}
+	}
+	if len(annotations.DataClassification) > maxClassificationLength {
+		return errors.New("The data classification must be at most " + strconv.Itoa(maxClassificationLength) + " characters.")
+	}
+	if allowed := os.Getenv("DATA_CLASSIFICATIONS"); allowed != "" && annotations.DataClassification != "" {
+		for _, classification := range strings.Split(allowed, ",") {
+			if strings.TrimSpace(classification) == annotations.DataClassification {
+				return nil
+			}
+		}
+		return errors.New("The data classification must be one of " + allowed + ".")
+	}
+	return nil
+}
+
+// metadataMarkdown is the text of the Kibana visualization.
+func metadataMarkdown(metadata *InstanceMetadata) string {
+	text := "## About this cluster\n\n**Name:** " + metadata.Name + "\n\n**Owner:** " + metadata.Owner + "\n\n"
+	if metadata.Purpose != "" {
+		text += "**Purpose:** " + metadata.Purpose + "\n\n"
+	}
+	if metadata.DataClassification != "" {
+		text += "**Data classification:** " + metadata.DataClassification + "\n\n"
+	}
+	if len(metadata.Escalation) > 0 {
+		text += "**Escalation:** " + strings.Join(metadata.Escalation, ", ") + "\n\n"
+	}
+	if len(metadata.Contacts) > 0 {
+		text += "**Contacts:** " + strings.Join(metadata.Contacts, ", ") + "\n\n"
+	}
+	return text + "_Published by the elasticsearch broker on " + metadata.Updated.Format(time.RFC1123) + "._\n"
+}
+
+// metadataVisualization is the Kibana saved object showing the metadata.
+func metadataVisualization(metadata *InstanceMetadata) ([]byte, error) {
+	visState, err := json.Marshal(map[string]interface{}{
+		"title":  "About this cluster",
+		"type":   "markdown",
+		"params": map[string]interface{}{"markdown": metadataMarkdown(metadata), "fontSize": 12},
+		"aggs":   []interface{}{},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]interface{}{
+		"attributes": map[string]interface{}{
+			"title":                 "About this cluster",
+			"description":           "Who owns " + metadata.Name + " and what it is for.",
+			"visState":              string(visState),
+			"uiStateJSON":           "{}",
+			"version":               1,
+			"kibanaSavedObjectMeta": map[string]string{"searchSourceJSON": "{}"},
+		},
+	})
+}
+
+// clusterResult is the error of a request to the cluster, a missing document is not one if
+// it was being removed.
+func clusterResult(what string, data []byte, status int, err error, removing bool) error {
+	if err != nil {
+		return err
+	}
+	if removing && status == http.StatusNotFound {
+		return nil
+	}
+	if status < 200 || status > 299 {
+		return errors.New("Unable to " + what + " (" + strconv.Itoa(status) + "): " + string(data))
+	}
+	return nil
+}
+
+// PublishAnnotations writes the annotations of the instance to its cluster and Kibana, or
+// removes them if they are not published.
+func PublishAnnotations(storage Storage, cluster *ClusterClient, instance *Instance) error {
+	annotations, err := storage.GetAnnotations(instance.Id)
+	if err != nil {
+		return err
+	}
+	if !annotations.Publish {
+		data, status, err := cluster.Do(instance, "DELETE", metadataDocument, nil)
+		if err = clusterResult("remove the metadata document", data, status, err, true); err != nil {
+			return err
+		}
+		data, status, err = cluster.DoKibana(instance, "DELETE", metadataSavedObject, nil)
+		return clusterResult("remove the metadata visualization", data, status, err, true)
+	}
+	contacts, err := storage.GetContacts(instance.Id)
+	if err != nil {
+		return err
+	}
+	metadata := InstanceMetadata{
+		InstanceId:         instance.Id,
+		Name:               instance.Name,
+		Owner:              instance.Owner,
+		Plan:               instance.Plan.ID,
+		Purpose:            annotations.Purpose,
+		Escalation:         annotations.Escalation,
+		Contacts:           contacts,
+		DataClassification: annotations.DataClassification,
+		Updated:            time.Now(),
+	}
+	body, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	data, status, err := cluster.Do(instance, "PUT", metadataDocument, body)
+	if err = clusterResult("write the metadata document", data, status, err, false); err != nil {
+		return err
+	}
+	if body, err = metadataVisualization(&metadata); err != nil {
+		return err
+	}
+	data, status, err = cluster.DoKibana(instance, "POST", metadataSavedObject+"?overwrite=true", body)
+	return clusterResult("write the metadata visualization", data, status, err, false)
+}
+
+// republishAnnotations refreshes the published annotations after the contacts changed.
+func (b *BusinessLogic) republishAnnotations(InstanceID string) {
+	annotations, err := b.storage.GetAnnotations(InstanceID)
+	if err != nil || !annotations.Publish {
+		return
+	}
+	instance, err := b.GetInstanceById(InstanceID)
+	if err != nil || !IsAvailable(instance.Status) {
+		return
+	}
+	if err = PublishAnnotations(b.storage, NewClusterClient(), instance); err != nil {
+		glog.Errorf("Unable to publish the annotations of %s: %s\n", instance.Name, err.Error())
+	}
+}
+
+// GET /v2/service_instances/{instance_id}/actions/annotations
+func (b *BusinessLogic) GetAnnotationsAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	annotations, err := b.storage.GetAnnotations(InstanceID)
+	if err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Unable to get annotations for %s: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	return annotations, nil
+}
+
+// PUT /v2/service_instances/{instance_id}/actions/annotations with {"purpose":"...",
+// "escalation":["..."],"data_classification":"...","publish":true}, the annotations replace
+// those of the instance.
+func (b *BusinessLogic) SetAnnotationsAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	var request Annotations
+	if c == nil || c.Request == nil || c.Request.Body == nil {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "The body must be {\"purpose\":...,\"escalation\":[...],\"data_classification\":...,\"publish\":...}.")
+	}
+	if err := json.NewDecoder(c.Request.Body).Decode(&request); err != nil {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "The body must be {\"purpose\":...,\"escalation\":[...],\"data_classification\":...,\"publish\":...}.")
+	}
+	if request.Escalation == nil {
+		request.Escalation = make([]string, 0)
+	}
+	if err := ValidateAnnotations(&request); err != nil {
+		return nil, UnprocessableEntityWithMessage("InvalidAnnotations", err.Error())
+	}
+	instance, err := b.GetInstanceById(InstanceID)
+	if err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Error finding instance id (during set annotations): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	previous, err := b.storage.GetAnnotations(InstanceID)
+	if err != nil {
+		glog.Errorf("Unable to get annotations for %s: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	if err = b.storage.SetAnnotations(InstanceID, &request); err != nil {
+		glog.Errorf("Unable to set annotations for %s: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	if !request.Publish && !previous.Publish {
+		return request, nil
+	}
+	if !IsAvailable(instance.Status) {
+		return nil, UnprocessableEntityWithMessage("InstanceNotAvailable", "The annotations were saved but can only be published once the instance is available, set them again then.")
+	}
+	if err = PublishAnnotations(b.storage, NewClusterClient(), instance); err != nil {
+		glog.Errorf("Unable to publish the annotations of %s: %s\n", instance.Name, err.Error())
+		return nil, UnprocessableEntityWithMessage("PublishFailed", "The annotations were saved but could not be published to the cluster: "+err.Error())
+	}
+	return request, nil
+}
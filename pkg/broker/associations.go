@@ -0,0 +1,225 @@
+package broker
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/glog"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// An Association records a consumer VPC (possibly in another account or region) that
+// has been given access to a VPC-only instance.
+type Association struct {
+	Id                  string `json:"id"`
+	InstanceId          string `json:"instance_id"`
+	VpcId               string `json:"vpc_id"`
+	AccountId           string `json:"account_id"`
+	Region              string `json:"region"`
+	Cidr                string `json:"cidr"`
+	PeeringConnectionId string `json:"peering_connection_id,omitempty"`
+	Status              string `json:"status"`
+	Message             string `json:"message,omitempty"`
+}
+
+type AssociationRequest struct {
+	VpcId         string `json:"vpc_id"`
+	AccountId     string `json:"account_id"`
+	Region        string `json:"region"`
+	Cidr          string `json:"cidr"`
+	CreatePeering bool   `json:"create_peering"`
+}
+
+func newEC2() *ec2.EC2 {
+	return ec2.New(awsSession())
+}
+
+// Finds an existing peering connection between the two vpcs in either direction.
+func findPeeringConnection(svc *ec2.EC2, vpcId string, peerVpcId string) (*ec2.VpcPeeringConnection, error) {
+	for _, side := range [][]string{{"requester-vpc-info.vpc-id", "accepter-vpc-info.vpc-id"}, {"accepter-vpc-info.vpc-id", "requester-vpc-info.vpc-id"}} {
+		res, err := svc.DescribeVpcPeeringConnections(&ec2.DescribeVpcPeeringConnectionsInput{
+			Filters: []*ec2.Filter{
+				&ec2.Filter{Name: aws.String(side[0]), Values: []*string{aws.String(vpcId)}},
+				&ec2.Filter{Name: aws.String(side[1]), Values: []*string{aws.String(peerVpcId)}},
+				&ec2.Filter{Name: aws.String("status-code"), Values: aws.StringSlice([]string{"active", "pending-acceptance", "provisioning"})},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(res.VpcPeeringConnections) > 0 {
+			return res.VpcPeeringConnections[0], nil
+		}
+	}
+	return nil, nil
+}
+
+func httpsIngress(cidr string) []*ec2.IpPermission {
+	return []*ec2.IpPermission{&ec2.IpPermission{
+		IpProtocol: aws.String("tcp"),
+		FromPort:   aws.Int64(443),
+		ToPort:     aws.Int64(443),
+		IpRanges:   []*ec2.IpRange{&ec2.IpRange{CidrIp: aws.String(cidr), Description: aws.String("elasticsearch-broker association")}},
+	}}
+}
+
+func (b *BusinessLogic) getInstanceNetwork(InstanceID string) (*Instance, *NetworkInfo, error) {
+	Instance, err := b.GetInstanceById(InstanceID)
+	if err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Error finding instance id (during associations): %s\n", err.Error())
+		return nil, nil, InternalServerError()
+	}
+	provider, err := GetProviderByPlan(b.namePrefix, Instance.Plan)
+	if err != nil {
+		glog.Errorf("Unable to get network, cannot find provider (GetProviderByPlan failed): %s\n", err.Error())
+		return nil, nil, InternalServerError()
+	}
+	network, err := provider.GetNetwork(Instance)
+	if err != nil {
+		glog.Errorf("Unable to get network information for %s: %s\n", Instance.Name, err.Error())
+		return nil, nil, InternalServerError()
+	}
+	return Instance, network, nil
+}
+
+// GET /v2/service_instances/{instance_id}/actions/associations
+func (b *BusinessLogic) ListAssociationsAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	if _, err := b.storage.GetInstance(InstanceID); err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	}
+	associations, err := b.storage.GetAssociations(InstanceID)
+	if err != nil {
+		glog.Errorf("Unable to get associations for %s: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	return associations, nil
+}
+
+// POST /v2/service_instances/{instance_id}/actions/associations, checks (or with create_peering
+// requests) a peering connection to the consumers vpc and allows https from the consumers cidr.
+// Route tables in both vpcs and accepting a cross-account peering are left to the consumer.
+func (b *BusinessLogic) AddAssociationAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	if err := b.checkNotFrozen(InstanceID); err != nil {
+		return nil, err
+	}
+	var request AssociationRequest
+	if c == nil || c.Request == nil || c.Request.Body == nil {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "A vpc_id and cidr must be provided.")
+	}
+	if err := json.NewDecoder(c.Request.Body).Decode(&request); err != nil || request.VpcId == "" || request.Cidr == "" {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "A vpc_id and cidr must be provided.")
+	}
+	if request.AccountId == "" {
+		request.AccountId = os.Getenv("AWS_ACCOUNT_ID")
+	}
+	if request.Region == "" {
+		request.Region = os.Getenv("AWS_REGION")
+	}
+	Instance, network, err := b.getInstanceNetwork(InstanceID)
+	if err != nil {
+		return nil, err
+	}
+	if network.Public {
+		return nil, UnprocessableEntityWithMessage("PublicInstance", "The instance is not inside of a VPC, no association is needed.")
+	}
+
+	association := Association{
+		InstanceId: Instance.Id,
+		VpcId:      request.VpcId,
+		AccountId:  request.AccountId,
+		Region:     request.Region,
+		Cidr:       request.Cidr,
+	}
+	svc := newEC2()
+	if request.VpcId == network.VpcId {
+		association.Status = "active"
+		association.Message = "The consumer is in the same VPC as the instance."
+	} else {
+		peering, err := findPeeringConnection(svc, network.VpcId, request.VpcId)
+		if err != nil {
+			glog.Errorf("Unable to describe peering connections for %s: %s\n", Instance.Name, err.Error())
+			return nil, InternalServerError()
+		}
+		if peering == nil && request.CreatePeering {
+			res, err := svc.CreateVpcPeeringConnection(&ec2.CreateVpcPeeringConnectionInput{
+				VpcId:       aws.String(network.VpcId),
+				PeerVpcId:   aws.String(request.VpcId),
+				PeerOwnerId: aws.String(request.AccountId),
+				PeerRegion:  aws.String(request.Region),
+			})
+			if err != nil {
+				glog.Errorf("Unable to create peering connection for %s: %s\n", Instance.Name, err.Error())
+				return nil, UnprocessableEntityWithMessage("PeeringFailed", "Unable to create a peering connection: "+err.Error())
+			}
+			peering = res.VpcPeeringConnection
+		}
+		if peering == nil {
+			association.Status = "unreachable"
+			association.Message = "No peering connection exists between " + network.VpcId + " and " + request.VpcId + ", retry with create_peering=true to request one."
+		} else {
+			association.PeeringConnectionId = aws.StringValue(peering.VpcPeeringConnectionId)
+			association.Status = "active"
+			if peering.Status != nil && aws.StringValue(peering.Status.Code) != "active" {
+				association.Status = aws.StringValue(peering.Status.Code)
+				association.Message = "The peering connection must be accepted by the owner of " + request.VpcId + " and routes to " + request.Cidr + " added to both VPCs."
+			}
+		}
+	}
+
+	if association.Status != "unreachable" {
+		for _, group := range network.SecurityGroupIds {
+			_, err := svc.AuthorizeSecurityGroupIngress(&ec2.AuthorizeSecurityGroupIngressInput{GroupId: aws.String(group), IpPermissions: httpsIngress(request.Cidr)})
+			if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "InvalidPermission.Duplicate" {
+				continue
+			} else if err != nil {
+				glog.Errorf("Unable to allow %s on security group %s for %s: %s\n", request.Cidr, group, Instance.Name, err.Error())
+				return nil, UnprocessableEntityWithMessage("SecurityGroupFailed", "Unable to allow access from "+request.Cidr+": "+err.Error())
+			}
+		}
+	}
+
+	if association.Id, err = b.storage.AddAssociation(&association); err != nil {
+		glog.Errorf("Unable to record association for %s: %s\n", Instance.Name, err.Error())
+		return nil, InternalServerError()
+	}
+	return association, nil
+}
+
+// DELETE /v2/service_instances/{instance_id}/actions/associations/{association_id}, the peering
+// connection is left in place as other instances in the vpc may be using it.
+func (b *BusinessLogic) RemoveAssociationAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	if err := b.checkNotFrozen(InstanceID); err != nil {
+		return nil, err
+	}
+	association, err := b.storage.GetAssociation(InstanceID, vars["association_id"])
+	if err != nil && err.Error() == "Not found" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Unable to get association %s: %s\n", vars["association_id"], err.Error())
+		return nil, InternalServerError()
+	}
+	Instance, network, err := b.getInstanceNetwork(InstanceID)
+	if err != nil {
+		return nil, err
+	}
+	svc := newEC2()
+	for _, group := range network.SecurityGroupIds {
+		_, err := svc.RevokeSecurityGroupIngress(&ec2.RevokeSecurityGroupIngressInput{GroupId: aws.String(group), IpPermissions: httpsIngress(association.Cidr)})
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "InvalidPermission.NotFound" {
+			continue
+		} else if err != nil {
+			glog.Errorf("Unable to revoke %s on security group %s for %s: %s\n", association.Cidr, group, Instance.Name, err.Error())
+			return nil, InternalServerError()
+		}
+	}
+	if err = b.storage.DeleteAssociation(InstanceID, association.Id); err != nil {
+		glog.Errorf("Unable to remove association %s: %s\n", association.Id, err.Error())
+		return nil, InternalServerError()
+	}
+	return association, nil
+}
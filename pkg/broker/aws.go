@@ -0,0 +1,157 @@
+package broker
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	elasticsearchv2 "github.com/aws/aws-sdk-go-v2/service/elasticsearchservice"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/elasticsearchservice"
+	"github.com/aws/aws-sdk-go/service/opensearchservice"
+	"github.com/aws/smithy-go"
+	"github.com/golang/glog"
+)
+
+// The broker shares one AWS session whose credentials come from the default chain (env,
+// shared credentials file, web identity (IRSA) or the instance profile). The sdk refreshes
+// temporary credentials as they expire, but a session keeps static keys for its lifetime,
+// so it is re-created when the shared credentials file changes, when aws rejects the
+// credentials (e.g., swapped or expired keys) or when RefreshAWSSession is called.
+
+// awsCredentialsCheckInterval is how often the shared credentials file is checked for changes.
+const awsCredentialsCheckInterval = time.Second * 30
+
+var awsSessions struct {
+	sync.Mutex
+	session    *session.Session
+	es         *elasticsearchservice.ElasticsearchService
+	esV2       *elasticsearchv2.Client
+	opensearch *opensearchservice.OpenSearchService
+	stale      bool
+	modified   time.Time
+	checked    time.Time
+}
+
+func sharedCredentialsFile() string {
+	if os.Getenv("AWS_SHARED_CREDENTIALS_FILE") != "" {
+		return os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".aws", "credentials")
+	}
+	return ""
+}
+
+func sharedCredentialsModified() time.Time {
+	if name := sharedCredentialsFile(); name != "" {
+		if info, err := os.Stat(name); err == nil {
+			return info.ModTime()
+		}
+	}
+	return time.Time{}
+}
+
+// isCredentialsError is true if aws rejected the credentials themselves, rather than
+// denying the request, for errors of either sdk.
+func isCredentialsError(err error) bool {
+	code := ""
+	var apiErr smithy.APIError
+	if aerr, ok := err.(awserr.Error); ok {
+		code = aerr.Code()
+	} else if errors.As(err, &apiErr) {
+		code = apiErr.ErrorCode()
+	}
+	switch code {
+	case "ExpiredToken", "ExpiredTokenException", "InvalidClientTokenId", "UnrecognizedClientException", "InvalidSignatureException", "SignatureDoesNotMatch":
+		return true
+	}
+	return false
+}
+
+// RefreshAWSSession re-creates the AWS session (and clients) on their next use, e.g., after
+// the brokers keys were swapped.
+func RefreshAWSSession() {
+	awsSessions.Lock()
+	awsSessions.stale = true
+	awsSessions.Unlock()
+}
+
+func awsSessionLocked() *session.Session {
+	now := time.Now()
+	if awsSessions.session != nil && now.Sub(awsSessions.checked) > awsCredentialsCheckInterval {
+		awsSessions.checked = now
+		if modified := sharedCredentialsModified(); !modified.Equal(awsSessions.modified) {
+			glog.Infof("The AWS shared credentials file changed, refreshing the AWS session\n")
+			awsSessions.stale = true
+		}
+	}
+	if awsSessions.session == nil || awsSessions.stale {
+		sess := session.New(&aws.Config{Region: aws.String(os.Getenv("AWS_REGION"))})
+		sess.Handlers.Complete.PushBack(func(r *request.Request) {
+			if r.Error != nil && isCredentialsError(r.Error) {
+				glog.Errorf("AWS rejected the brokers credentials, refreshing the AWS session: %s\n", r.Error.Error())
+				RefreshAWSSession()
+			}
+		})
+		awsSessions.session = sess
+		awsSessions.es = nil
+		awsSessions.esV2 = nil
+		awsSessions.opensearch = nil
+		awsSessions.stale = false
+		awsSessions.modified = sharedCredentialsModified()
+		awsSessions.checked = now
+	}
+	return awsSessions.session
+}
+
+// awsSession returns the shared AWS session in AWS_REGION.
+func awsSession() *session.Session {
+	awsSessions.Lock()
+	defer awsSessions.Unlock()
+	return awsSessionLocked()
+}
+
+// awsRetryer is the retryer of the elasticsearch service client, the number of retries can be
+// set with AWS_MAX_RETRIES and the longest delay between them (in seconds) with AWS_MAX_RETRY_DELAY.
+func awsRetryer() client.DefaultRetryer {
+	retryer := client.DefaultRetryer{NumMaxRetries: client.DefaultRetryerMaxNumRetries}
+	if retries, err := strconv.Atoi(os.Getenv("AWS_MAX_RETRIES")); err == nil && retries >= 0 {
+		retryer.NumMaxRetries = retries
+	}
+	if seconds, err := strconv.Atoi(os.Getenv("AWS_MAX_RETRY_DELAY")); err == nil && seconds > 0 {
+		retryer.MaxRetryDelay = time.Duration(seconds) * time.Second
+		retryer.MaxThrottleDelay = time.Duration(seconds) * time.Second
+	}
+	return retryer
+}
+
+// newElasticsearchService returns the elasticsearch service client of the current session.
+func newElasticsearchService() *elasticsearchservice.ElasticsearchService {
+	awsSessions.Lock()
+	defer awsSessions.Unlock()
+	sess := awsSessionLocked()
+	if awsSessions.es == nil {
+		awsSessions.es = elasticsearchservice.New(sess, request.WithRetryer(&aws.Config{Endpoint: aws.String(esEndpoint())}, awsRetryer()))
+	}
+	return awsSessions.es
+}
+
+// newOpenSearchService returns the OpenSearch service client of the current session, it
+// shares the endpoint and retries of the elasticsearch service client.
+func newOpenSearchService() *opensearchservice.OpenSearchService {
+	awsSessions.Lock()
+	defer awsSessions.Unlock()
+	sess := awsSessionLocked()
+	if awsSessions.opensearch == nil {
+		awsSessions.opensearch = opensearchservice.New(sess, request.WithRetryer(&aws.Config{Endpoint: aws.String(esEndpoint())}, awsRetryer()))
+	}
+	return awsSessions.opensearch
+}
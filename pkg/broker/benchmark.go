@@ -0,0 +1,276 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// A benchmark runs a small standardized workload against an instance and keeps its indexing
+// throughput and search latency, so plans can be compared on numbers measured on them. It is
+// opt-in, with the provision parameters {"benchmark":true} (run once the new instance is
+// available) or POST /v2/service_instances/{instance_id}/actions/benchmark, and runs in the
+// task worker. The workload bulk indexes BENCHMARK_DOCUMENTS (default 10000) generated
+// documents (the same ones every run) into a .broker-benchmark index of one shard without
+// replicas, then runs BENCHMARK_SEARCHES (default 200) match, term, range and aggregation
+// searches, and deletes the index. The average of the benchmarks of each plan over the last
+// 90 days is published in its catalog metadata ("benchmark").
+
+const (
+	benchmarkIndex     = ".broker-benchmark"
+	benchmarkBatchSize = 500
+	benchmarkSeed      = 42
+)
+
+var benchmarkWords = []string{"alpha", "bravo", "charlie", "delta", "echo", "foxtrot", "golf", "hotel", "india", "juliet", "kilo", "lima", "mike", "november", "oscar", "papa", "quebec", "romeo", "sierra", "tango", "uniform", "victor", "whiskey", "xray", "yankee", "zulu"}
+
+var benchmarkCategories = []string{"books", "music", "garden", "tools", "games", "sports", "toys", "food"}
+
+type BenchmarkResult struct {
+	Id         string `json:"id"`
+	InstanceId string `json:"instance_id"`
+	Plan       string `json:"plan"`
+	Documents  int    `json:"documents"`
+	Searches   int    `json:"searches"`
+	// Documents indexed a second.
+	IndexThroughput float64 `json:"index_throughput"`
+	// Search round trips in milliseconds.
+	SearchLatencyP50 float64   `json:"search_latency_p50"`
+	SearchLatencyP90 float64   `json:"search_latency_p90"`
+	Created          time.Time `json:"created"`
+}
+
+// PlanBenchmark is the average of the recent benchmarks of a plan.
+type PlanBenchmark struct {
+	Runs             int     `json:"runs"`
+	IndexThroughput  float64 `json:"index_throughput"`
+	SearchLatencyP50 float64 `json:"search_latency_p50"`
+	SearchLatencyP90 float64 `json:"search_latency_p90"`
+}
+
+// ParseBenchmarkParameters reads whether the provision parameters ask for a benchmark.
+func ParseBenchmarkParameters(parameters map[string]interface{}) (bool, error) {
+	if parameters == nil || parameters["benchmark"] == nil {
+		return false, nil
+	}
+	benchmark, ok := parameters["benchmark"].(bool)
+	if !ok {
+		return false, errors.New("The benchmark parameter must be true or false.")
+	}
+	return benchmark, nil
+}
+
+// benchmarkDocuments returns the bulk bodies indexing the documents of the workload.
+func benchmarkDocuments(count int) [][]byte {
+	random := rand.New(rand.NewSource(benchmarkSeed))
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	batches := make([][]byte, 0)
+	var batch bytes.Buffer
+	for i := 0; i < count; i++ {
+		title := ""
+		for w := 0; w < 6; w++ {
+			if w > 0 {
+				title += " "
+			}
+			title += benchmarkWords[random.Intn(len(benchmarkWords))]
+		}
+		doc, _ := json.Marshal(map[string]interface{}{
+			"title":    title,
+			"category": benchmarkCategories[random.Intn(len(benchmarkCategories))],
+			"price":    math.Round(random.Float64()*10000) / 100,
+			"quantity": random.Intn(1000),
+			"created":  start.Add(time.Duration(random.Intn(365*24)) * time.Hour).Format(time.RFC3339),
+		})
+		batch.WriteString("{\"index\":{}}\n")
+		batch.Write(doc)
+		batch.WriteString("\n")
+		if (i+1)%benchmarkBatchSize == 0 || i == count-1 {
+			batches = append(batches, append([]byte{}, batch.Bytes()...))
+			batch.Reset()
+		}
+	}
+	return batches
+}
+
+// benchmarkSearch is the i-th search of the workload.
+func benchmarkSearch(i int) []byte {
+	var query map[string]interface{}
+	switch i % 4 {
+	case 0:
+		query = map[string]interface{}{"query": map[string]interface{}{"match": map[string]interface{}{"title": benchmarkWords[i%len(benchmarkWords)] + " " + benchmarkWords[(i*7)%len(benchmarkWords)]}}}
+	case 1:
+		query = map[string]interface{}{"query": map[string]interface{}{"term": map[string]interface{}{"category.keyword": benchmarkCategories[i%len(benchmarkCategories)]}}}
+	case 2:
+		query = map[string]interface{}{"query": map[string]interface{}{"range": map[string]interface{}{"price": map[string]interface{}{"gte": i % 50, "lt": i%50 + 10}}}}
+	default:
+		query = map[string]interface{}{"size": 0, "aggs": map[string]interface{}{"categories": map[string]interface{}{
+			"terms": map[string]interface{}{"field": "category.keyword"},
+			"aggs":  map[string]interface{}{"price": map[string]interface{}{"avg": map[string]interface{}{"field": "price"}}},
+		}}}
+	}
+	data, _ := json.Marshal(query)
+	return data
+}
+
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	index := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if index < 0 {
+		index = 0
+	}
+	return sorted[index]
+}
+
+func benchmarkRequest(cluster *ClusterClient, instance *Instance, method string, path string, body []byte) ([]byte, error) {
+	data, status, err := cluster.Do(instance, method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	if status < 200 || status > 299 {
+		return nil, errors.New(method + " " + path + " returned " + strconv.Itoa(status) + ": " + string(data))
+	}
+	return data, nil
+}
+
+// RunBenchmark runs the workload against the instance.
+func RunBenchmark(cluster *ClusterClient, instance *Instance) (*BenchmarkResult, error) {
+	documents := envInt("BENCHMARK_DOCUMENTS", 10000)
+	searches := envInt("BENCHMARK_SEARCHES", 200)
+	if documents < 1 || searches < 1 {
+		return nil, errors.New("BENCHMARK_DOCUMENTS and BENCHMARK_SEARCHES must be at least 1.")
+	}
+	// a benchmark that failed part way leaves its index behind.
+	if _, status, err := cluster.Do(instance, "DELETE", "/"+benchmarkIndex, nil); err != nil {
+		return nil, err
+	} else if status != 404 && (status < 200 || status > 299) {
+		return nil, errors.New("Unable to remove the previous benchmark index (" + strconv.Itoa(status) + ")")
+	}
+	defer func() {
+		if _, _, err := cluster.Do(instance, "DELETE", "/"+benchmarkIndex, nil); err != nil {
+			glog.Errorf("Unable to remove the benchmark index of %s: %s\n", instance.Name, err.Error())
+		}
+	}()
+	// the fields are mapped dynamically, which works the same across engine versions.
+	settings := []byte(`{"settings":{"number_of_shards":1,"number_of_replicas":0}}`)
+	if _, err := benchmarkRequest(cluster, instance, "PUT", "/"+benchmarkIndex, settings); err != nil {
+		return nil, err
+	}
+	batches := benchmarkDocuments(documents)
+	started := time.Now()
+	for _, batch := range batches {
+		data, err := benchmarkRequest(cluster, instance, "POST", "/"+benchmarkIndex+"/_doc/_bulk", batch)
+		if err != nil {
+			return nil, err
+		}
+		var response struct {
+			Errors bool `json:"errors"`
+		}
+		if err = json.Unmarshal(data, &response); err != nil {
+			return nil, err
+		}
+		if response.Errors {
+			return nil, errors.New("Some documents of the benchmark could not be indexed.")
+		}
+	}
+	if _, err := benchmarkRequest(cluster, instance, "POST", "/"+benchmarkIndex+"/_refresh", nil); err != nil {
+		return nil, err
+	}
+	indexing := time.Since(started)
+	latencies := make([]float64, 0)
+	for i := 0; i < searches; i++ {
+		searchStarted := time.Now()
+		if _, err := benchmarkRequest(cluster, instance, "POST", "/"+benchmarkIndex+"/_search", benchmarkSearch(i)); err != nil {
+			return nil, err
+		}
+		latencies = append(latencies, float64(time.Since(searchStarted))/float64(time.Millisecond))
+	}
+	return &BenchmarkResult{
+		InstanceId:       instance.Id,
+		Plan:             instance.Plan.ID,
+		Documents:        documents,
+		Searches:         searches,
+		IndexThroughput:  math.Round(float64(documents)/indexing.Seconds()*100) / 100,
+		SearchLatencyP50: math.Round(percentile(latencies, 0.5)*100) / 100,
+		SearchLatencyP90: math.Round(percentile(latencies, 0.9)*100) / 100,
+	}, nil
+}
+
+func RunBenchmarkTaskFromQueue(storage Storage, namePrefix string, cluster *ClusterClient, task *Task) {
+	// a new instance has an hour to become available
+	if task.Retries >= 60 {
+		FinishedTask(storage, task.Id, task.Retries, "Unable to benchmark the instance ("+task.Result+")", "failed")
+		return
+	}
+	instance, err := GetInstanceById(namePrefix, storage, task.ResourceId)
+	if err != nil {
+		UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot get Instance: "+err.Error(), "pending")
+		return
+	}
+	if !IsAvailable(instance.Status) {
+		UpdateTaskStatus(storage, task.Id, task.Retries+1, "Waiting for the instance to become available ("+instance.Status+")", "pending")
+		return
+	}
+	result, err := RunBenchmark(cluster, instance)
+	if err != nil {
+		glog.Infof("Unable to benchmark %s: %s\n", instance.Name, err.Error())
+		UpdateTaskStatus(storage, task.Id, task.Retries+1, "Unable to run the benchmark: "+err.Error(), "pending")
+		return
+	}
+	if err = storage.AddBenchmark(result); err != nil {
+		UpdateTaskStatus(storage, task.Id, task.Retries+1, "Unable to record the benchmark: "+err.Error(), "pending")
+		return
+	}
+	glog.Infof("Benchmarked %s: %.2f documents/s, %.2fms p50 and %.2fms p90 search latency\n", instance.Name, result.IndexThroughput, result.SearchLatencyP50, result.SearchLatencyP90)
+	FinishedTask(storage, task.Id, task.Retries, "Indexed "+strconv.FormatFloat(result.IndexThroughput, 'f', 2, 64)+" documents/s, searched in "+strconv.FormatFloat(result.SearchLatencyP50, 'f', 2, 64)+"ms (p50)", "finished")
+}
+
+// GET /v2/service_instances/{instance_id}/actions/benchmark
+func (b *BusinessLogic) GetBenchmarksAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	if _, err := b.storage.GetInstance(InstanceID); err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Unable to get the instance %s: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	results, err := b.storage.GetBenchmarks(InstanceID)
+	if err != nil {
+		glog.Errorf("Unable to get the benchmarks of %s: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	return results, nil
+}
+
+// POST /v2/service_instances/{instance_id}/actions/benchmark schedules a benchmark.
+func (b *BusinessLogic) RunBenchmarkAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	instance, err := b.GetInstanceById(InstanceID)
+	if err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Error finding instance id (during benchmark): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	if !IsAvailable(instance.Status) {
+		return nil, UnprocessableEntityWithMessage("ConcurrencyError", "The instance must be available to benchmark it.")
+	}
+	if task, err := b.storage.GetLastTask(InstanceID, BenchmarkTask); err == nil && (task.Status == "pending" || task.Status == "started") {
+		return nil, UnprocessableEntityWithMessage("ConcurrencyError", "A benchmark of the instance is already scheduled.")
+	}
+	id, err := b.storage.AddTask(InstanceID, BenchmarkTask, "")
+	if err != nil {
+		glog.Errorf("Unable to schedule a benchmark of %s: %s\n", instance.Name, err.Error())
+		return nil, InternalServerError()
+	}
+	return map[string]string{"task": id, "status": "pending"}, nil
+}
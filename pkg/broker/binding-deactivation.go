@@ -0,0 +1,95 @@
+package broker
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// Operators can deactivate the credentials of a binding they suspect are compromised without
+// deleting the binding, so the platform and the app still see it while the incident is
+// contained. The user of the binding is stripped of its roles (it can still authenticate but
+// is not allowed to do anything) until the binding is reactivated, unbound, or rotated with
+// a new binding naming it as the predecessor. Bindings sharing the credentials of the instance
+// have no user of their own and can't be deactivated. Only operators with the admin token can
+// deactivate or reactivate a binding, both are recorded in the events of the instance.
+
+type BindingDeactivation struct {
+	Deactivated bool   `json:"deactivated"`
+	Reason      string `json:"reason,omitempty"`
+	Actor       string `json:"actor,omitempty"`
+}
+
+// PUT /v2/service_instances/{instance_id}/actions/bindings/{binding_id}/deactivation with
+// {"deactivated":true, "reason":"..."}
+func (b *BusinessLogic) SetBindingDeactivationAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	actor, ok := adminActor(c)
+	if !ok {
+		glog.Infof("Rejected deactivating a binding of %s without a valid admin token and user\n", InstanceID)
+		return nil, Forbidden()
+	}
+	var request BindingDeactivation
+	if c.Request.Body == nil {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "The body must be {\"deactivated\":true, \"reason\":\"...\"}.")
+	}
+	if err := json.NewDecoder(c.Request.Body).Decode(&request); err != nil {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "The body must be {\"deactivated\":true, \"reason\":\"...\"}.")
+	}
+	request.Reason = strings.TrimSpace(request.Reason)
+	if request.Reason == "" {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "A reason (e.g., the incident) is required.")
+	}
+	request.Actor = actor
+	bindingId := vars["binding_id"]
+	instance, err := b.GetInstanceById(InstanceID)
+	if err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Error finding instance id (during binding deactivation): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	status, _, err := b.storage.GetBindingStatus(InstanceID, bindingId)
+	if err != nil && err.Error() == "Not found" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Unable to get the status of binding %s: %s\n", bindingId, err.Error())
+		return nil, InternalServerError()
+	}
+	if request.Deactivated && status == BindingDeactivated {
+		return request, nil
+	} else if request.Deactivated && status != BindingBound {
+		return nil, UnprocessableEntityWithMessage("NotBound", "The binding is "+status+", only bound bindings can be deactivated.")
+	} else if !request.Deactivated && status != BindingDeactivated {
+		return nil, UnprocessableEntityWithMessage("NotDeactivated", "The binding is not deactivated.")
+	}
+	username, err := b.storage.GetBindingUser(InstanceID, bindingId)
+	if err != nil {
+		glog.Errorf("Unable to get the user of binding %s: %s\n", bindingId, err.Error())
+		return nil, InternalServerError()
+	}
+	if username == "" {
+		return nil, UnprocessableEntityWithMessage("SharedCredentials", "The binding uses the credentials of the instance, rotate it with a new binding (predecessor_binding_id) to revoke them.")
+	}
+	roles := []string{}
+	if !request.Deactivated {
+		roles = bindingUserRoles()
+	}
+	if err = SetBindingUserRoles(NewClusterClient(), instance, username, roles); err != nil {
+		glog.Errorf("Unable to change the roles of the user of binding %s: %s\n", bindingId, err.Error())
+		return nil, UnprocessableEntityWithMessage("DeactivationFailed", err.Error())
+	}
+	eventType := BindingReactivatedEvent
+	status, result := BindingBound, ""
+	if request.Deactivated {
+		eventType = BindingDeactivatedEvent
+		status, result = BindingDeactivated, "Deactivated by "+actor+": "+request.Reason
+	}
+	if err = b.storage.SetBindingDeactivation(InstanceID, bindingId, status, result, eventType, EventData{Binding: bindingId, Actor: actor, Reason: request.Reason}); err != nil {
+		glog.Errorf("Unable to set the status of binding %s: %s\n", bindingId, err.Error())
+		return nil, InternalServerError()
+	}
+	glog.Infof("%s set the deactivation of binding %s of %s to %t (%s)\n", actor, bindingId, instance.Name, request.Deactivated, request.Reason)
+	return request, nil
+}
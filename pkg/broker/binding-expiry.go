@@ -0,0 +1,62 @@
+package broker
+
+import (
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Bindings that are about to expire (within BINDING_EXPIRY_WARNING_HOURS, default 72) are
+// posted once to BINDING_EXPIRY_WEBHOOK (signed with BINDING_EXPIRY_WEBHOOK_SECRET) and
+// emailed to the contacts of their instance, so the credentials are rotated before they are
+// revoked, and again once they have expired. Changing the expiry of a binding warns again.
+
+type BindingExpiryNotice struct {
+	InstanceId string    `json:"instance_id"`
+	Name       string    `json:"name"`
+	Owner      string    `json:"owner"`
+	BindingId  string    `json:"binding_id"`
+	Expires    time.Time `json:"expires"`
+	Expired    bool      `json:"expired"`
+}
+
+func notifyBindingExpiry(storage Storage, event NotificationEvent, binding ExpiredBinding) error {
+	entry, err := storage.GetInstance(binding.InstanceId)
+	if err != nil {
+		return err
+	}
+	notice := BindingExpiryNotice{
+		InstanceId: binding.InstanceId,
+		Name:       entry.Name,
+		Owner:      entry.Owner,
+		BindingId:  binding.BindingId,
+		Expires:    binding.Expires,
+		Expired:    event == BindingExpiredNotification,
+	}
+	if url := os.Getenv("BINDING_EXPIRY_WEBHOOK"); url != "" {
+		if err = Notify(storage, event, url, os.Getenv("BINDING_EXPIRY_WEBHOOK_SECRET"), notice); err != nil {
+			return err
+		}
+	}
+	return EmailContacts(storage, event, binding.InstanceId, notice)
+}
+
+// WarnExpiringBindings warns of the bindings that expire within the warning window.
+func WarnExpiringBindings(storage Storage) {
+	window := time.Hour * time.Duration(envInt("BINDING_EXPIRY_WARNING_HOURS", 72))
+	bindings, err := storage.GetExpiringBindings(time.Now().Add(window))
+	if err != nil {
+		glog.Errorf("Unable to get expiring bindings: %s\n", err.Error())
+		return
+	}
+	for _, binding := range bindings {
+		if err = notifyBindingExpiry(storage, BindingExpiringNotification, binding); err != nil {
+			glog.Errorf("Unable to warn that binding %s of %s expires: %s\n", binding.BindingId, binding.InstanceId, err.Error())
+			continue
+		}
+		if err = storage.SetBindingExpiryWarned(binding.InstanceId, binding.BindingId); err != nil {
+			glog.Errorf("Unable to record the expiry warning of binding %s: %s\n", binding.BindingId, err.Error())
+		}
+	}
+}
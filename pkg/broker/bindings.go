@@ -0,0 +1,420 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// Binding statuses, a binding is bound unless it was created or removed asynchronously
+// and the task worker has not finished it yet (or failed to).
+const (
+	BindingInProgress = "binding"
+	BindingBound      = "bound"
+	BindingUnbinding  = "unbinding"
+	BindingFailed     = "failed"
+	BindingExpired    = "expired"
+	// an operator stripped the user of the binding of its roles (see binding-deactivation.go)
+	BindingDeactivated = "deactivated"
+)
+
+const (
+	bindOperation   = osb.OperationKey("bind")
+	unbindOperation = osb.OperationKey("unbind")
+	// asynchronous binds and unbinds are given up on after this many attempts (a minute apart)
+	bindingTaskAttempts = 10
+)
+
+// ExpiredBinding is a binding past its expiry.
+type ExpiredBinding struct {
+	InstanceId string
+	BindingId  string
+	Expires    time.Time
+}
+
+type BindingTaskMetadata struct {
+	Binding     string `json:"binding"`
+	AppGUID     string `json:"app_guid,omitempty"`
+	Predecessor string `json:"predecessor,omitempty"`
+}
+
+// securityUsersPath is the internal users api of the security plugin on domains with
+// fine-grained access control.
+const securityUsersPath = "/_opendistro/_security/api/internalusers/"
+
+type predecessorBindingKey struct{}
+
+// PredecessorBindingMiddleware reads the predecessor_binding_id (OSB 2.17) of bind requests
+// into the request context, the OSB library drops fields it does not know of.
+func PredecessorBindingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/service_bindings/") && r.Body != nil {
+			data, err := ioutil.ReadAll(r.Body)
+			r.Body.Close()
+			r.Body = ioutil.NopCloser(bytes.NewReader(data))
+			var body struct {
+				PredecessorBindingID string `json:"predecessor_binding_id"`
+			}
+			if err == nil && json.Unmarshal(data, &body) == nil && body.PredecessorBindingID != "" {
+				r = r.WithContext(context.WithValue(r.Context(), predecessorBindingKey{}, body.PredecessorBindingID))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func predecessorBindingID(c *broker.RequestContext) string {
+	if c == nil || c.Request == nil {
+		return ""
+	}
+	predecessor, _ := c.Request.Context().Value(predecessorBindingKey{}).(string)
+	return predecessor
+}
+
+// bindingPassword is random and meets the password policy of fine-grained access control
+// (upper and lower case letters, a number and a special character).
+func bindingPassword() (string, error) {
+	data := make([]byte, 24)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return "Es1!" + base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// bindingUserRoles are the roles of binding users, set with BINDING_USER_ROLES (default all_access).
+func bindingUserRoles() []string {
+	if os.Getenv("BINDING_USER_ROLES") == "" {
+		return []string{"all_access"}
+	}
+	return strings.Split(os.Getenv("BINDING_USER_ROLES"), ",")
+}
+
+// CreateBindingUser creates (or resets the password of) the user of a binding on the cluster.
+func CreateBindingUser(cluster *ClusterClient, instance *Instance, bindingId string) (string, string, error) {
+	username := "binding-" + bindingId
+	password, err := bindingPassword()
+	if err != nil {
+		return "", "", err
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"password":                  password,
+		"opendistro_security_roles": bindingUserRoles(),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	data, status, err := cluster.Do(instance, http.MethodPut, securityUsersPath+username, body)
+	if err != nil {
+		return "", "", err
+	}
+	if status != http.StatusOK && status != http.StatusCreated {
+		return "", "", errors.New("Unable to create the user " + username + " (" + strconv.Itoa(status) + "): " + string(data))
+	}
+	return username, password, nil
+}
+
+func DeleteBindingUser(cluster *ClusterClient, instance *Instance, username string) error {
+	data, status, err := cluster.Do(instance, http.MethodDelete, securityUsersPath+username, nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK && status != http.StatusNotFound {
+		return errors.New("Unable to delete the user " + username + " (" + strconv.Itoa(status) + "): " + string(data))
+	}
+	return nil
+}
+
+// SetBindingUserRoles replaces the roles of the user of a binding, without roles the user can
+// still authenticate but is not allowed to do anything.
+func SetBindingUserRoles(cluster *ClusterClient, instance *Instance, username string, roles []string) error {
+	body, err := json.Marshal([]map[string]interface{}{
+		{"op": "replace", "path": "/opendistro_security_roles", "value": roles},
+	})
+	if err != nil {
+		return err
+	}
+	data, status, err := cluster.Do(instance, http.MethodPatch, securityUsersPath+username, body)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return errors.New("Unable to change the roles of the user " + username + " (" + strconv.Itoa(status) + "): " + string(data))
+	}
+	return nil
+}
+
+// CreateBinding does the work of a bind, it tags the instance with the binding and app and
+// stores the credentials given to the binding. When platforms accept it this is ran by the
+// task worker so slow calls (e.g., creating users for the binding) don't time out the bind.
+// A binding rotating a predecessor gets its own user on instances with credentials, so the
+// predecessors credentials stay valid until it is unbound.
+func CreateBinding(storage Storage, provider Provider, instance *Instance, bindingId string, appGuid string, predecessor string, params *BindingParameters) (map[string]interface{}, error) {
+	credentials := Credentials(provider, instance)
+	if predecessor != "" && instance.Username != "" && instance.Password != "" {
+		username, password, err := CreateBindingUser(NewClusterClient(), instance, bindingId)
+		if err != nil {
+			return nil, err
+		}
+		if err = storage.SetBindingUser(instance.Id, bindingId, username); err != nil {
+			return nil, err
+		}
+		user := map[string]interface{}{"ES_USERNAME": username, "ES_PASSWORD": password}
+		if instance.Plan != nil {
+			user = RenameConfigVars(user, instance.Plan.ConfigVarNames)
+		}
+		for key, value := range user {
+			credentials[key] = value
+		}
+	}
+	credentials = params.Apply(credentials)
+	data, err := json.Marshal(credentials)
+	if err != nil {
+		return nil, err
+	}
+	if err = storage.SetBindingCredentials(instance.Id, bindingId, string(data)); err != nil {
+		return nil, err
+	}
+	expires, err := BindingExpiresAt(instance.Plan, params, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if err = storage.SetBindingExpires(instance.Id, bindingId, expires); err != nil {
+		return nil, err
+	}
+	if appGuid != "" {
+		if err = provider.Tag(instance, "Binding", bindingId); err != nil {
+			return nil, err
+		}
+		if err = provider.Tag(instance, "App", appGuid); err != nil {
+			return nil, err
+		}
+	}
+	if err = storage.SetBindingStatus(instance.Id, bindingId, BindingBound, ""); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// BindingExpiresAt is when a binding created at now expires, nil if neither its ttl parameter
+// nor the plans binding ttl is set. The ttl parameter can shorten the plans binding ttl but
+// not extend it.
+func BindingExpiresAt(plan *ProviderPlan, params *BindingParameters, now time.Time) (*time.Time, error) {
+	var ttl time.Duration
+	if plan != nil {
+		ttl = plan.BindingTTL
+	}
+	if params != nil && params.TTL != "" {
+		requested, err := time.ParseDuration(params.TTL)
+		if err != nil || requested <= 0 {
+			return nil, errors.New("The ttl must be a positive duration, e.g., 24h.")
+		}
+		if ttl > 0 && requested > ttl {
+			return nil, errors.New("The ttl can be at most " + ttl.String() + " on this plan.")
+		}
+		ttl = requested
+	}
+	if ttl <= 0 {
+		return nil, nil
+	}
+	expires := now.Add(ttl)
+	return &expires, nil
+}
+
+// ExpireBindings revokes the credentials of bindings past their expiry, the user of a
+// binding that has one is deleted from the cluster and the binding is marked as expired.
+// The binding itself is kept until the platform unbinds it.
+func ExpireBindings(namePrefix string, storage Storage) {
+	if claimed, err := storage.ClaimSchedule("expire-bindings", time.Minute*5); err != nil || !claimed {
+		return
+	}
+	WarnExpiringBindings(storage)
+	bindings, err := storage.GetExpiredBindings()
+	if err != nil {
+		glog.Errorf("Unable to get expired bindings: %s\n", err.Error())
+		return
+	}
+	for _, binding := range bindings {
+		if isFrozen(storage, binding.InstanceId) {
+			continue
+		}
+		if DryRun(ExpireBindingAction) {
+			ReportDryRun(storage, ExpireBindingAction, binding.InstanceId, "Would revoke the credentials of binding "+binding.BindingId+" as it expired at "+binding.Expires.Format(time.RFC3339))
+			continue
+		}
+		if username, err := storage.GetBindingUser(binding.InstanceId, binding.BindingId); err == nil && username != "" {
+			instance, err := GetInstanceById(namePrefix, storage, binding.InstanceId)
+			if err != nil {
+				glog.Errorf("Unable to expire binding %s: %s\n", binding.BindingId, err.Error())
+				continue
+			}
+			if err = DeleteBindingUser(NewClusterClient(), instance, username); err != nil {
+				glog.Errorf("Unable to expire binding %s: %s\n", binding.BindingId, err.Error())
+				continue
+			}
+		}
+		if err = storage.RevokeBinding(binding.InstanceId, binding.BindingId); err != nil {
+			glog.Errorf("Unable to mark binding %s as expired: %s\n", binding.BindingId, err.Error())
+			continue
+		}
+		glog.Infof("Binding %s of %s expired, its credentials were revoked\n", binding.BindingId, binding.InstanceId)
+		if err = notifyBindingExpiry(storage, BindingExpiredNotification, binding); err != nil {
+			glog.Errorf("Unable to notify that binding %s of %s expired: %s\n", binding.BindingId, binding.InstanceId, err.Error())
+		}
+	}
+}
+
+// RemoveBinding does the work of an unbind, it removes the binding and app tags from the
+// instance, the bindings user if it has one and deletes the binding.
+func RemoveBinding(storage Storage, provider Provider, instance *Instance, bindingId string) error {
+	if username, err := storage.GetBindingUser(instance.Id, bindingId); err == nil && username != "" {
+		if err = DeleteBindingUser(NewClusterClient(), instance, username); err != nil {
+			return err
+		}
+	}
+	if err := provider.Untag(instance, "Binding"); err != nil {
+		return err
+	}
+	if err := provider.Untag(instance, "App"); err != nil {
+		return err
+	}
+	return storage.DeleteBinding(instance.Id, bindingId)
+}
+
+func bindingTaskFailed(storage Storage, task *Task, bindingId string, result string) {
+	FinishedTask(storage, task.Id, task.Retries, result, "failed")
+	if err := storage.SetBindingStatus(task.ResourceId, bindingId, BindingFailed, result); err != nil {
+		glog.Errorf("Unable to mark binding %s as failed: %s\n", bindingId, err.Error())
+	}
+}
+
+// RunBindingTaskFromQueue finishes an asynchronous bind or unbind, it is retried until the
+// binding is created or removed and the binding is marked as failed if it never is.
+func RunBindingTaskFromQueue(storage Storage, namePrefix string, task *Task) {
+	var metadata BindingTaskMetadata
+	if err := json.Unmarshal([]byte(task.Metadata), &metadata); err != nil {
+		FinishedTask(storage, task.Id, task.Retries, "Cannot read the task metadata: "+err.Error(), "failed")
+		return
+	}
+	if task.Retries >= bindingTaskAttempts {
+		bindingTaskFailed(storage, task, metadata.Binding, "Unable to "+string(task.Action)+" "+metadata.Binding+" as it failed multiple times ("+task.Result+")")
+		return
+	}
+	instance, err := GetInstanceById(namePrefix, storage, task.ResourceId)
+	if err != nil {
+		UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot get Instance: "+err.Error(), "pending")
+		return
+	}
+	provider, err := GetProviderByPlan(namePrefix, instance.Plan)
+	if err != nil {
+		UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot get provider: "+err.Error(), "pending")
+		return
+	}
+	if task.Action == UnbindTask {
+		if err = RemoveBinding(storage, provider, instance, metadata.Binding); err != nil {
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot remove binding: "+err.Error(), "pending")
+			return
+		}
+		FinishedTask(storage, task.Id, task.Retries, "Removed binding "+metadata.Binding, "finished")
+		return
+	}
+	data, err := storage.GetBindingParameters(task.ResourceId, metadata.Binding)
+	if err != nil {
+		bindingTaskFailed(storage, task, metadata.Binding, "Cannot get the binding parameters: "+err.Error())
+		return
+	}
+	var params BindingParameters
+	if err = json.Unmarshal([]byte(data), &params); err != nil {
+		bindingTaskFailed(storage, task, metadata.Binding, "Cannot read the binding parameters: "+err.Error())
+		return
+	}
+	if _, err = CreateBinding(storage, provider, instance, metadata.Binding, metadata.AppGUID, metadata.Predecessor, &params); err != nil {
+		UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot create binding: "+err.Error(), "pending")
+		return
+	}
+	FinishedTask(storage, task.Id, task.Retries, "Created binding "+metadata.Binding, "finished")
+}
+
+// addBindingTask schedules an asynchronous bind or unbind and returns the operation to poll.
+func (b *BusinessLogic) addBindingTask(InstanceID string, action TaskAction, status string, metadata BindingTaskMetadata) (*osb.OperationKey, error) {
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+	if err = b.storage.SetBindingStatus(InstanceID, metadata.Binding, status, ""); err != nil {
+		return nil, err
+	}
+	if _, err = b.storage.AddTask(InstanceID, action, string(data)); err != nil {
+		return nil, err
+	}
+	operation := bindOperation
+	if action == UnbindTask {
+		operation = unbindOperation
+	}
+	return &operation, nil
+}
+
+// BindingLastOperation reports the state of an asynchronous bind or unbind, once a binding
+// is removed it is gone.
+func (b *BusinessLogic) BindingLastOperation(request *osb.BindingLastOperationRequest, c *broker.RequestContext) (*broker.LastOperationResponse, error) {
+	status, result, err := b.storage.GetBindingStatus(request.InstanceID, request.BindingID)
+	if err != nil && err.Error() == "Not found" {
+		return nil, Gone()
+	} else if err != nil {
+		glog.Errorf("Unable to get the status of binding %s: %s\n", request.BindingID, err.Error())
+		return nil, InternalServerError()
+	}
+	response := broker.LastOperationResponse{}
+	response.Description = &status
+	switch status {
+	case BindingInProgress, BindingUnbinding:
+		response.State = osb.StateInProgress
+	case BindingFailed:
+		response.State = osb.StateFailed
+		if result != "" {
+			response.Description = &result
+		}
+	default:
+		response.State = osb.StateSucceeded
+	}
+	return &response, nil
+}
+
+// RouteBindingLastOperation adds GET /v2/service_instances/{instance_id}/service_bindings/{binding_id}/last_operation
+// (polling asynchronous bindings from OSB 2.14).
+func (b *BusinessLogic) RouteBindingLastOperation(router *mux.Router) {
+	router.HandleFunc("/v2/service_instances/{instance_id}/service_bindings/{binding_id}/last_operation", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		request := osb.BindingLastOperationRequest{InstanceID: vars["instance_id"], BindingID: vars["binding_id"]}
+		if operation := r.URL.Query().Get("operation"); operation != "" {
+			key := osb.OperationKey(operation)
+			request.OperationKey = &key
+		}
+		resp, err := b.BindingLastOperation(&request, &broker.RequestContext{Request: r, Writer: w})
+		if httpErr, ok := osb.IsHTTPError(err); ok {
+			body := map[string]string{}
+			if httpErr.Description != nil {
+				body["description"] = *httpErr.Description
+			}
+			if httpErr.ErrorMessage != nil {
+				body["error"] = *httpErr.ErrorMessage
+			}
+			HttpWrite(w, httpErr.StatusCode, body)
+			return
+		}
+		HttpWrite(w, http.StatusOK, resp)
+	}).Methods("GET")
+}
@@ -0,0 +1,282 @@
+package broker
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"regexp"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// The catalog is read from the services and plans tables on every request, operators manage
+// it with the admin catalog api (with the x-admin-token and x-admin-user headers of admin
+// queries) so new instance classes are rolled out without redeploying the broker. Retired
+// plans are removed from the catalog and can't be provisioned, but existing instances keep
+// them until they change plans. The provider private details of a plan are never returned,
+// they are encrypted with the current credentials key if one is configured.
+
+var catalogNamePattern = regexp.MustCompile(`^[A-Za-z0-9\-]{1,128}$`)
+
+type CatalogService struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	HumanName   string `json:"human_name"`
+	Description string `json:"description"`
+	Categories  string `json:"categories"`
+	Image       string `json:"image"`
+	Beta        bool   `json:"beta"`
+	Deprecated  bool   `json:"deprecated"`
+}
+
+type CatalogPlan struct {
+	Id                               string            `json:"id"`
+	ServiceId                        string            `json:"service_id"`
+	Name                             string            `json:"name"`
+	HumanName                        string            `json:"human_name"`
+	Description                      string            `json:"description"`
+	Version                          string            `json:"version"`
+	Type                             string            `json:"type"`
+	Scheme                           string            `json:"scheme"`
+	Categories                       string            `json:"categories"`
+	CostCents                        int               `json:"cost_cents"`
+	CostUnit                         string            `json:"cost_unit"`
+	Attributes                       json.RawMessage   `json:"attributes,omitempty"`
+	Provider                         string            `json:"provider"`
+	ProviderPrivateDetails           json.RawMessage   `json:"provider_private_details,omitempty"`
+	InstallableInsidePrivateNetwork  bool              `json:"installable_inside_private_network"`
+	InstallableOutsidePrivateNetwork bool              `json:"installable_outside_private_network"`
+	Preprovision                     int               `json:"preprovision"`
+	ConfigVarNames                   map[string]string `json:"config_var_names,omitempty"`
+	Logging                          json.RawMessage   `json:"logging,omitempty"`
+	Schemas                          json.RawMessage   `json:"schemas,omitempty"`
+	ParameterPolicy                  json.RawMessage   `json:"parameter_policy,omitempty"`
+	// Intervals, e.g., "3 days", empty for none.
+	TTL        string `json:"ttl,omitempty"`
+	BindingTTL string `json:"binding_ttl,omitempty"`
+	Beta       bool   `json:"beta"`
+	Deprecated bool   `json:"deprecated"`
+	Retired    bool   `json:"retired"`
+	// The plan instances of a deprecated plan are migrated to.
+	MigrateTo string `json:"migrate_to,omitempty"`
+}
+
+// validateCatalogPlan checks a plan before it is saved, its provider private details (nil to
+// keep the current details) must be a plan aws accepts.
+func validateCatalogPlan(plan *CatalogPlan) error {
+	if !catalogNamePattern.MatchString(plan.Name) {
+		return UnprocessableEntityWithMessage("InvalidPlan", "The name must be letters, numbers and dashes.")
+	}
+	if plan.ServiceId == "" || plan.HumanName == "" || plan.Description == "" || plan.Version == "" {
+		return UnprocessableEntityWithMessage("InvalidPlan", "The service_id, human_name, description and version are required.")
+	}
+	if GetProvidersFromString(plan.Provider) == Unknown {
+		return UnprocessableEntityWithMessage("InvalidPlan", "The provider "+plan.Provider+" is not supported.")
+	}
+	for name, value := range map[string]json.RawMessage{"attributes": plan.Attributes, "logging": plan.Logging, "schemas": plan.Schemas} {
+		if len(value) > 0 && !json.Valid(value) {
+			return UnprocessableEntityWithMessage("InvalidPlan", "The "+name+" must be json.")
+		}
+	}
+	if _, err := ParseParameterPolicy(plan.ParameterPolicy); err != nil {
+		return UnprocessableEntityWithMessage("InvalidPlan", err.Error())
+	}
+	if plan.ProviderPrivateDetails == nil {
+		return nil
+	}
+	var details map[string]interface{}
+	if err := json.Unmarshal(plan.ProviderPrivateDetails, &details); err != nil {
+		return UnprocessableEntityWithMessage("InvalidPlan", "The provider_private_details must be a json object.")
+	}
+	err := ValidatePlan(&ProviderPlan{ID: plan.Id, Provider: GetProvidersFromString(plan.Provider), providerPrivateDetails: os.ExpandEnv(string(plan.ProviderPrivateDetails))})
+	if invalid, ok := err.(*PlanValidationError); ok {
+		return UnprocessableEntityWithMessage("InvalidPlan", invalid.Error())
+	} else if err != nil {
+		return UnprocessableEntityWithMessage("InvalidPlan", "The provider_private_details are invalid: "+err.Error())
+	}
+	return nil
+}
+
+// encryptPlanDetails encrypts the details with the current credentials key as a json string
+// (see decryptPlanDetails), they are returned as is if no keys are configured.
+func encryptPlanDetails(details json.RawMessage) (string, error) {
+	encrypted, err := EncryptCredential(string(details))
+	if err != nil {
+		return "", err
+	}
+	if encrypted == string(details) {
+		return encrypted, nil
+	}
+	data, err := json.Marshal(encrypted)
+	return string(data), err
+}
+
+func writeCatalogError(w http.ResponseWriter, err error) {
+	body := map[string]string{"error": "InternalServerError", "description": "Internal Server Error"}
+	status := http.StatusInternalServerError
+	if httpErr, ok := osb.IsHTTPError(err); ok {
+		status = httpErr.StatusCode
+		delete(body, "error")
+		body["description"] = ""
+		if httpErr.Description != nil {
+			body["description"] = *httpErr.Description
+		}
+		if httpErr.ErrorMessage != nil {
+			body["error"] = *httpErr.ErrorMessage
+		}
+	}
+	HttpWrite(w, status, body)
+}
+
+// catalogHandler only lets requests with the admin token and user through.
+func catalogHandler(handler func(actor string, w http.ResponseWriter, r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		actor, ok := adminActor(&broker.RequestContext{Request: r, Writer: w})
+		if !ok {
+			glog.Infof("Rejected a catalog request (%s %s) without a valid admin token and user\n", r.Method, r.URL.Path)
+			writeCatalogError(w, Forbidden())
+			return
+		}
+		obj, err := handler(actor, w, r)
+		if err != nil {
+			writeCatalogError(w, err)
+			return
+		}
+		HttpWrite(w, http.StatusOK, obj)
+	}
+}
+
+func (b *BusinessLogic) saveCatalogService(actor string, service *CatalogService) (interface{}, error) {
+	if !catalogNamePattern.MatchString(service.Name) || service.HumanName == "" || service.Description == "" {
+		return nil, UnprocessableEntityWithMessage("InvalidService", "The name (letters, numbers and dashes), human_name and description are required.")
+	}
+	if service.Categories == "" {
+		service.Categories = "Data Stores"
+	}
+	if err := b.storage.SaveCatalogService(service); err != nil {
+		glog.Errorf("Unable to save the service %s: %s\n", service.Name, err.Error())
+		return nil, InternalServerError()
+	}
+	glog.Infof("%s saved the service %s (%s)\n", actor, service.Name, service.Id)
+	return service, nil
+}
+
+func (b *BusinessLogic) saveCatalogPlan(actor string, plan *CatalogPlan) (interface{}, error) {
+	if err := validateCatalogPlan(plan); err != nil {
+		return nil, err
+	}
+	if err := b.validateMigrateTo(plan); err != nil {
+		return nil, err
+	}
+	details := ""
+	if plan.ProviderPrivateDetails != nil {
+		var err error
+		if details, err = encryptPlanDetails(plan.ProviderPrivateDetails); err != nil {
+			glog.Errorf("Unable to encrypt the details of plan %s: %s\n", plan.Name, err.Error())
+			return nil, InternalServerError()
+		}
+	}
+	if err := b.storage.SaveCatalogPlan(plan, details); err != nil && err.Error() == "Not found" {
+		return nil, UnprocessableEntityWithMessage("InvalidPlan", "The service "+plan.ServiceId+" does not exist.")
+	} else if err != nil {
+		glog.Errorf("Unable to save the plan %s: %s\n", plan.Name, err.Error())
+		return nil, InternalServerError()
+	}
+	glog.Infof("%s saved the plan %s (%s)\n", actor, plan.Name, plan.Id)
+	plan.ProviderPrivateDetails = nil
+	return plan, nil
+}
+
+// RouteCatalog adds the admin catalog api, GET and POST (create) /v2/admin/catalog/services,
+// PUT /v2/admin/catalog/services/{service_id}, GET and POST (create) /v2/admin/catalog/plans
+// and GET, PUT and DELETE (retire) /v2/admin/catalog/plans/{plan_id}.
+func (b *BusinessLogic) RouteCatalog(router *mux.Router) {
+	b.routePlanVisibility(router)
+	b.routePlanMigration(router)
+	router.HandleFunc("/v2/admin/catalog/services", catalogHandler(func(actor string, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		services, err := b.storage.GetCatalogServices()
+		if err != nil {
+			glog.Errorf("Unable to get the services: %s\n", err.Error())
+			return nil, InternalServerError()
+		}
+		return services, nil
+	})).Methods("GET")
+	router.HandleFunc("/v2/admin/catalog/services", catalogHandler(func(actor string, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		var service CatalogService
+		if err := json.NewDecoder(r.Body).Decode(&service); err != nil {
+			return nil, UnprocessableEntityWithMessage("InvalidService", "The body must be a service.")
+		}
+		service.Id = ""
+		return b.saveCatalogService(actor, &service)
+	})).Methods("POST")
+	router.HandleFunc("/v2/admin/catalog/services/{service_id}", catalogHandler(func(actor string, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		var service CatalogService
+		if err := json.NewDecoder(r.Body).Decode(&service); err != nil {
+			return nil, UnprocessableEntityWithMessage("InvalidService", "The body must be a service.")
+		}
+		service.Id = mux.Vars(r)["service_id"]
+		return b.saveCatalogService(actor, &service)
+	})).Methods("PUT")
+	router.HandleFunc("/v2/admin/catalog/plans", catalogHandler(func(actor string, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		plans, err := b.storage.GetCatalogPlans()
+		if err != nil {
+			glog.Errorf("Unable to get the plans: %s\n", err.Error())
+			return nil, InternalServerError()
+		}
+		return plans, nil
+	})).Methods("GET")
+	router.HandleFunc("/v2/admin/catalog/plans", catalogHandler(func(actor string, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		var plan CatalogPlan
+		if err := json.NewDecoder(r.Body).Decode(&plan); err != nil {
+			return nil, UnprocessableEntityWithMessage("InvalidPlan", "The body must be a plan.")
+		}
+		if plan.ProviderPrivateDetails == nil {
+			return nil, UnprocessableEntityWithMessage("InvalidPlan", "The provider_private_details are required.")
+		}
+		plan.Id = ""
+		return b.saveCatalogPlan(actor, &plan)
+	})).Methods("POST")
+	router.HandleFunc("/v2/admin/catalog/plans/{plan_id}", catalogHandler(func(actor string, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		plan, err := b.storage.GetCatalogPlan(mux.Vars(r)["plan_id"])
+		if err != nil && err.Error() == "Not found" {
+			return nil, NotFound()
+		} else if err != nil {
+			glog.Errorf("Unable to get the plan %s: %s\n", mux.Vars(r)["plan_id"], err.Error())
+			return nil, InternalServerError()
+		}
+		return plan, nil
+	})).Methods("GET")
+	router.HandleFunc("/v2/admin/catalog/plans/{plan_id}", catalogHandler(func(actor string, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		var plan CatalogPlan
+		if err := json.NewDecoder(r.Body).Decode(&plan); err != nil {
+			return nil, UnprocessableEntityWithMessage("InvalidPlan", "The body must be a plan.")
+		}
+		plan.Id = mux.Vars(r)["plan_id"]
+		if _, err := b.storage.GetCatalogPlan(plan.Id); err != nil && err.Error() == "Not found" {
+			return nil, NotFound()
+		} else if err != nil {
+			glog.Errorf("Unable to get the plan %s: %s\n", plan.Id, err.Error())
+			return nil, InternalServerError()
+		}
+		return b.saveCatalogPlan(actor, &plan)
+	})).Methods("PUT")
+	router.HandleFunc("/v2/admin/catalog/plans/{plan_id}", catalogHandler(func(actor string, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		plan, err := b.storage.GetCatalogPlan(mux.Vars(r)["plan_id"])
+		if err != nil && err.Error() == "Not found" {
+			return nil, NotFound()
+		} else if err != nil {
+			glog.Errorf("Unable to get the plan %s: %s\n", mux.Vars(r)["plan_id"], err.Error())
+			return nil, InternalServerError()
+		}
+		if err = b.storage.RetirePlan(plan.Id); err != nil {
+			glog.Errorf("Unable to retire the plan %s: %s\n", plan.Name, err.Error())
+			return nil, InternalServerError()
+		}
+		glog.Infof("%s retired the plan %s (%s)\n", actor, plan.Name, plan.Id)
+		plan.Retired = true
+		return plan, nil
+	})).Methods("DELETE")
+}
@@ -0,0 +1,105 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+// A clone is an instance (on a plan with a ttl, e.g., for a review app) provisioned with
+// the selected indices of another instances latest snapshot (see restore.go). The snapshots
+// are read from CLONE_SNAPSHOT_REPOSITORY, which must be registered on the instances cloned from.
+
+// CloneParameters are the provision parameters that make the new instance a clone, e.g.,
+// {"clone":{"from":"<instance id>", "indices":["products", "orders-*"]}}.
+type CloneParameters struct {
+	From    string   `json:"from"`
+	Indices []string `json:"indices"`
+}
+
+type snapshotRepository struct {
+	Type     string                 `json:"type"`
+	Settings map[string]interface{} `json:"settings"`
+}
+
+func cloneRepository() string {
+	return os.Getenv("CLONE_SNAPSHOT_REPOSITORY")
+}
+
+func ParseCloneParameters(parameters map[string]interface{}) (*CloneParameters, error) {
+	if parameters == nil || parameters["clone"] == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(parameters["clone"])
+	if err != nil {
+		return nil, err
+	}
+	var clone CloneParameters
+	if err = json.Unmarshal(data, &clone); err != nil {
+		return nil, errors.New("The clone must be {\"from\":\"<instance id>\", \"indices\":[...]}.")
+	}
+	if clone.From == "" {
+		return nil, errors.New("The instance to clone from is required.")
+	}
+	if err = validateRestoreIndices(clone.Indices); err != nil {
+		return nil, err
+	}
+	return &clone, nil
+}
+
+// ValidateClone checks the clone can be made on the plan by the owner, instances can only
+// be cloned by their owner and only onto plans with a ttl.
+func ValidateClone(namePrefix string, storage Storage, clone *CloneParameters, plan *ProviderPlan, owner string) error {
+	if cloneRepository() == "" {
+		return errors.New("Cloning instances is not enabled.")
+	}
+	if plan.TTL <= 0 {
+		return errors.New("Clones can only be created on plans with a ttl.")
+	}
+	source, err := GetInstanceById(namePrefix, storage, clone.From)
+	if err != nil && err.Error() == "Cannot find resource instance" {
+		return errors.New("The instance to clone from does not exist.")
+	} else if err != nil {
+		return err
+	}
+	if source.Owner != owner {
+		return errors.New("The instance to clone from does not exist.")
+	}
+	return nil
+}
+
+// registerCloneRepository registers the repository of the source on the clone as read-only.
+func registerCloneRepository(cluster *ClusterClient, source *Instance, clone *Instance, repository string) error {
+	response, status, err := cluster.Do(source, "GET", "/_snapshot/"+url.PathEscape(repository), nil)
+	if err != nil {
+		return err
+	}
+	if status != 200 {
+		return errors.New("Unable to get the repository " + repository + " of " + source.Name + ", _snapshot returned " + strconv.Itoa(status))
+	}
+	var repositories map[string]snapshotRepository
+	if err = json.Unmarshal(response, &repositories); err != nil {
+		return err
+	}
+	settings, ok := repositories[repository]
+	if !ok {
+		return errors.New("The repository " + repository + " is not registered on " + source.Name)
+	}
+	if settings.Settings == nil {
+		settings.Settings = make(map[string]interface{})
+	}
+	settings.Settings["readonly"] = true
+	body, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	if _, status, err = cluster.Do(clone, "PUT", "/_snapshot/"+url.PathEscape(repository), body); err != nil {
+		return err
+	}
+	if status != 200 {
+		return errors.New("Unable to register the repository " + repository + " on " + clone.Name + ", _snapshot returned " + strconv.Itoa(status))
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package broker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+)
+
+// ClusterClient talks to the REST api of a provisioned cluster, requests are signed
+// with the brokers AWS credentials unless the instance has its own credentials.
+type ClusterClient struct {
+	signer *v4.Signer
+	region string
+	client *http.Client
+}
+
+func NewClusterClient() *ClusterClient {
+	sess := awsSession()
+	return &ClusterClient{
+		signer: v4.NewSigner(sess.Config.Credentials),
+		region: os.Getenv("AWS_REGION"),
+		client: &http.Client{Timeout: time.Second * 60},
+	}
+}
+
+// Sign adds either basic auth or an AWS v4 signature to the request, the body
+// must be the same bytes that are sent with the request.
+func (c *ClusterClient) Sign(instance *Instance, req *http.Request, body []byte) error {
+	if instance.Username != "" && instance.Password != "" {
+		req.SetBasicAuth(instance.Username, instance.Password)
+		return nil
+	}
+	service := serverlessSigningName(instance)
+	if service == "aoss" {
+		// collections only accept requests that carry the hash of their payload.
+		hash := sha256.Sum256(body)
+		req.Header.Set("x-amz-content-sha256", hex.EncodeToString(hash[:]))
+	}
+	_, err := c.signer.Sign(req, bytes.NewReader(body), service, c.region, time.Now())
+	return err
+}
+
+func (c *ClusterClient) Do(instance *Instance, method string, path string, body []byte) ([]byte, int, error) {
+	return c.do(instance, method, path, body, nil)
+}
+
+// DoKibana calls the api of the Kibana (or OpenSearch Dashboards) of the cluster, path is
+// relative to it (e.g., /api/saved_objects/...).
+func (c *ClusterClient) DoKibana(instance *Instance, method string, path string, body []byte) ([]byte, int, error) {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return c.do(instance, method, dashboardsPath(instance.Plan)+path, body, http.Header{"kbn-xsrf": []string{"true"}})
+}
+
+func (c *ClusterClient) do(instance *Instance, method string, path string, body []byte, header http.Header) ([]byte, int, error) {
+	if instance.Endpoint == "" {
+		return nil, 0, errors.New("The instance " + instance.Name + " does not have an endpoint.")
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	req, err := http.NewRequest(method, instance.Scheme+"://"+instance.Endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if len(body) > 0 {
+		req.Header.Set("content-type", "application/json")
+	}
+	if err = c.Sign(instance, req, body); err != nil {
+		return nil, 0, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+	return data, resp.StatusCode, nil
+}
@@ -0,0 +1,189 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+type IndexSample struct {
+	Index string
+	Bytes int64
+	Docs  int64
+}
+
+type StorageSample struct {
+	UsedBytes  int64
+	TotalBytes int64
+	Sampled    time.Time
+}
+
+// MetricsCollector runs in the task worker and periodically samples the size of each
+// instances indices and disks, the samples are used for digests and storage predictions.
+type MetricsCollector struct {
+	namePrefix string
+	storage    Storage
+	cluster    *ClusterClient
+	interval   time.Duration
+	retention  time.Duration
+}
+
+func NewMetricsCollector(namePrefix string, storage Storage) *MetricsCollector {
+	interval := time.Hour
+	if os.Getenv("COLLECT_METRICS_INTERVAL") != "" {
+		if minutes, err := strconv.Atoi(os.Getenv("COLLECT_METRICS_INTERVAL")); err == nil && minutes >= 0 {
+			interval = time.Minute * time.Duration(minutes)
+		} else {
+			glog.Errorf("Invalid COLLECT_METRICS_INTERVAL, using the default of %s\n", interval.String())
+		}
+	}
+	return &MetricsCollector{
+		namePrefix: namePrefix,
+		storage:    storage,
+		cluster:    NewClusterClient(),
+		interval:   interval,
+		retention:  time.Hour * 24 * 35,
+	}
+}
+
+func parseCatInt(value string) int64 {
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return i
+}
+
+func (m *MetricsCollector) sampleIndices(instance *Instance) ([]IndexSample, error) {
+	response, status, err := m.cluster.Do(instance, "GET", "/_cat/indices?format=json&bytes=b&h=index,store.size,docs.count", nil)
+	if err != nil {
+		return nil, err
+	}
+	if status != 200 {
+		return nil, errors.New("_cat/indices returned " + strconv.Itoa(status))
+	}
+	var rows []map[string]string
+	if err = json.Unmarshal(response, &rows); err != nil {
+		return nil, err
+	}
+	samples := make([]IndexSample, 0)
+	for _, row := range rows {
+		samples = append(samples, IndexSample{Index: row["index"], Bytes: parseCatInt(row["store.size"]), Docs: parseCatInt(row["docs.count"])})
+	}
+	return samples, nil
+}
+
+func (m *MetricsCollector) sampleStorage(instance *Instance) (*StorageSample, error) {
+	response, status, err := m.cluster.Do(instance, "GET", "/_cat/allocation?format=json&bytes=b&h=disk.used,disk.total", nil)
+	if err != nil {
+		return nil, err
+	}
+	if status != 200 {
+		return nil, errors.New("_cat/allocation returned " + strconv.Itoa(status))
+	}
+	var rows []map[string]string
+	if err = json.Unmarshal(response, &rows); err != nil {
+		return nil, err
+	}
+	sample := StorageSample{Sampled: time.Now()}
+	for _, row := range rows {
+		sample.UsedBytes += parseCatInt(row["disk.used"])
+		sample.TotalBytes += parseCatInt(row["disk.total"])
+	}
+	return &sample, nil
+}
+
+func (m *MetricsCollector) Collect() error {
+	entries, err := m.storage.GetInstances()
+	if err != nil {
+		glog.Errorf("Metrics collector unable to get instances: %s\n", err.Error())
+		return err
+	}
+	for _, entry := range entries {
+		if !IsAvailable(entry.Status) {
+			continue
+		}
+		instance, err := GetInstanceById(m.namePrefix, m.storage, entry.Id)
+		if err != nil {
+			glog.Errorf("Metrics collector unable to get instance %s (%s): %s\n", entry.Id, entry.Name, err.Error())
+			continue
+		}
+		indices, err := m.sampleIndices(instance)
+		if err != nil {
+			glog.Errorf("Metrics collector unable to sample the indices of %s: %s\n", instance.Name, err.Error())
+			continue
+		}
+		if err = m.storage.AddIndexSamples(instance.Id, indices); err != nil {
+			glog.Errorf("Metrics collector unable to record the indices of %s: %s\n", instance.Name, err.Error())
+		}
+		sample, err := m.sampleStorage(instance)
+		if err != nil {
+			glog.Errorf("Metrics collector unable to sample the storage of %s: %s\n", instance.Name, err.Error())
+			continue
+		}
+		if err = m.storage.AddStorageSample(instance.Id, *sample); err != nil {
+			glog.Errorf("Metrics collector unable to record the storage of %s: %s\n", instance.Name, err.Error())
+		}
+	}
+	if err = m.storage.PruneSamples(time.Now().Add(-m.retention)); err != nil {
+		glog.Errorf("Metrics collector unable to prune old samples: %s\n", err.Error())
+		return err
+	}
+	return nil
+}
+
+// Run checks every minute whether a collection (or digest) is due, schedules are
+// claimed in the database so only one of many workers runs each.
+func (m *MetricsCollector) Run(ctx context.Context) {
+	if m.interval == 0 {
+		glog.Infof("COLLECT_METRICS_INTERVAL is 0, metrics will not be collected.\n")
+		RecordHeartbeat(m.storage, MetricsCollectorLoop, 0, nil, 0)
+		RecordHeartbeat(m.storage, SnapshotExportsLoop, 0, nil, 0)
+		return
+	}
+	t := time.NewTicker(time.Minute)
+	defer t.Stop()
+	for {
+		if claimed, err := m.storage.ClaimSchedule("collect-metrics", m.interval); err != nil {
+			glog.Errorf("Metrics collector unable to claim its schedule: %s\n", err.Error())
+			RecordHeartbeat(m.storage, MetricsCollectorLoop, m.interval, err, 0)
+		} else if claimed {
+			RecordHeartbeat(m.storage, MetricsCollectorLoop, m.interval, m.Collect(), 0)
+			AlertOnStorageExhaustion(m.namePrefix, m.storage)
+			CheckReadOnlyIndices(m.namePrefix, m.storage, m.cluster)
+			CheckClusterHealth(m.namePrefix, m.storage, m.cluster)
+			CheckSettingsDrift(m.namePrefix, m.storage, m.cluster)
+		}
+		if claimed, err := m.storage.ClaimSchedule("weekly-digest", time.Hour*24*7); err != nil {
+			glog.Errorf("Metrics collector unable to claim the digest schedule: %s\n", err.Error())
+		} else if claimed {
+			SendDigests(m.storage)
+		}
+		if claimed, err := m.storage.ClaimSchedule("eol-warnings", time.Hour*24*7); err != nil {
+			glog.Errorf("Metrics collector unable to claim the end of support warning schedule: %s\n", err.Error())
+		} else if claimed {
+			SendEOLWarnings(m.namePrefix, m.storage)
+		}
+		if claimed, err := m.storage.ClaimSchedule("linked-instances", time.Minute*15); err != nil {
+			glog.Errorf("Metrics collector unable to claim the linked instance schedule: %s\n", err.Error())
+		} else if claimed {
+			SyncLinkedInstances(m.namePrefix, m.storage)
+		}
+		if claimed, err := m.storage.ClaimSchedule("snapshot-exports", time.Hour*24); err != nil {
+			glog.Errorf("Metrics collector unable to claim the snapshot export schedule: %s\n", err.Error())
+			RecordHeartbeat(m.storage, SnapshotExportsLoop, time.Hour*24, err, 0)
+		} else if claimed {
+			RecordHeartbeat(m.storage, SnapshotExportsLoop, time.Hour*24, ExportSnapshots(m.namePrefix, m.storage, m.cluster), 0)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
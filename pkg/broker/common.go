@@ -101,6 +101,13 @@ func UnprocessableEntityWithMessage(err string, description string) error {
 	}
 }
 
+func BadRequestWithMessage(description string) error {
+	return osb.HTTPStatusCodeError{
+		StatusCode:  http.StatusBadRequest,
+		Description: &description,
+	}
+}
+
 func UnprocessableEntity() error {
 	description := "Unprocessable Entity"
 	return osb.HTTPStatusCodeError{
@@ -117,6 +124,22 @@ func NotFound() error {
 	}
 }
 
+func Gone() error {
+	description := "Gone"
+	return osb.HTTPStatusCodeError{
+		StatusCode:  http.StatusGone,
+		Description: &description,
+	}
+}
+
+func Forbidden() error {
+	description := "Forbidden"
+	return osb.HTTPStatusCodeError{
+		StatusCode:  http.StatusForbidden,
+		Description: &description,
+	}
+}
+
 type Action struct {
 	name    string
 	path    string
@@ -137,7 +160,12 @@ func InitFromOptions(ctx context.Context, o Options) (Storage, string, error) {
 		return nil, "", errors.New("The name prefix was not specified, set NAME_PREFIX in your environment or provide it via the cli using -name-prefix")
 	}
 	storage, err := InitStorage(ctx, o)
-	return storage, o.NamePrefix, err
+	if err != nil {
+		return nil, "", err
+	}
+	ReportPermissions(storage)
+	ReportInvalidPlans(storage)
+	return storage, o.NamePrefix, nil
 }
 
 func (b *ActionBase) ActionSchemaHandler(w http.ResponseWriter, r *http.Request) {
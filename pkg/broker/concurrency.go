@@ -0,0 +1,78 @@
+package broker
+
+import (
+	"github.com/golang/glog"
+)
+
+// Provisions, updates and deprovisions of the same instance can't overlap. While a broker
+// process handles a request it holds the operations lock of the instance in the database, so
+// requests to other processes see it too, and once the request has returned the operation
+// continues as tasks, so both are checked before an operation starts and a 422
+// ConcurrencyError is returned if another operation is running.
+
+// operationActions are the task actions each operation continues as, in the order they are
+// checked.
+var operationActions = []struct {
+	operation string
+	actions   []TaskAction
+}{
+	{"provision", []TaskAction{PerformPostProvisionTask, ResyncFromProviderUntilAvailableTask}},
+	{"update", []TaskAction{ChangePlansTask, ChangeProvidersTask, ResyncFromProviderTask}},
+	{"deprovision", []TaskAction{RunPreDeprovisionHookTask, DeleteTask, ExpireTask}},
+	{"restore", []TaskAction{RestoreTask, RestoreDbTask}},
+}
+
+func concurrencyError(operation string) error {
+	return UnprocessableEntityWithMessage("ConcurrencyError", "Another operation ("+operation+") is in progress on the instance, try again once it has finished.")
+}
+
+// runningOperation returns the operation the tasks of the instance are running, ignoring
+// the operations in except (e.g., a repeated deprovision is not a conflict).
+func runningOperation(storage Storage, InstanceID string, except ...string) (string, error) {
+	for _, op := range operationActions {
+		ignored := false
+		for _, e := range except {
+			if e == op.operation {
+				ignored = true
+			}
+		}
+		if ignored {
+			continue
+		}
+		task, err := storage.GetTaskInProgress(InstanceID, op.actions)
+		if err != nil && err.Error() == "Not found" {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		glog.Infof("The %s of %s is in progress (task %s, %s)\n", op.operation, InstanceID, task.Id, task.Action)
+		return op.operation, nil
+	}
+	return "", nil
+}
+
+// beginOperation takes the operations lock of the instance for the operation, the returned
+// func must be called once the request has been handled. The tasks of the operations in
+// except do not conflict with it.
+func (b *BusinessLogic) beginOperation(InstanceID string, operation string, except ...string) (func(), error) {
+	release, locked, err := b.storage.LockInstanceOperations(InstanceID)
+	if err != nil {
+		glog.Errorf("Unable to lock the operations of %s: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	if !locked {
+		glog.Infof("Rejected the %s of %s, another request is being handled\n", operation, InstanceID)
+		return nil, concurrencyError("another request")
+	}
+	running, err := runningOperation(b.storage, InstanceID, except...)
+	if err != nil {
+		release()
+		glog.Errorf("Unable to determine the operations in progress on %s: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	if running != "" {
+		release()
+		return nil, concurrencyError(running)
+	}
+	return release, nil
+}
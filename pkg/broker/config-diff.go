@@ -0,0 +1,122 @@
+package broker
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/golang/glog"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// Operators debugging an incident that only happens in one environment can diff the aws
+// configuration and cluster settings of two instances (e.g., staging and production). It
+// requires the admin token as the other instance may belong to anyone.
+
+type ConfigDifference struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Other string `json:"other"`
+}
+
+type ConfigDiff struct {
+	InstanceId string             `json:"instance_id"`
+	OtherId    string             `json:"other_id"`
+	Config     []ConfigDifference `json:"config"`
+	Settings   []ConfigDifference `json:"settings"`
+}
+
+// flattenConfig flattens json into dotted keys (e.g., VPCOptions.SubnetIds.0), values
+// that are not set are left out.
+func flattenConfig(prefix string, value interface{}, out map[string]string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			flattenConfig(key, child, out)
+		}
+	case []interface{}:
+		for i, child := range v {
+			flattenConfig(prefix+"."+strconv.Itoa(i), child, out)
+		}
+	case nil:
+	default:
+		out[prefix] = settingValue(v)
+	}
+}
+
+// diffConfig returns the keys whose values differ (or are only set on one side), sorted
+// by key.
+func diffConfig(values map[string]string, others map[string]string) []ConfigDifference {
+	differences := make([]ConfigDifference, 0)
+	for key, value := range values {
+		if other, ok := others[key]; !ok || other != value {
+			differences = append(differences, ConfigDifference{Key: key, Value: value, Other: other})
+		}
+	}
+	for key, other := range others {
+		if _, ok := values[key]; !ok {
+			differences = append(differences, ConfigDifference{Key: key, Other: other})
+		}
+	}
+	sort.Slice(differences, func(i, j int) bool { return differences[i].Key < differences[j].Key })
+	return differences
+}
+
+// instanceConfig returns the aws configuration (with the plan and engine version) and the
+// cluster settings of an instance.
+func (b *BusinessLogic) instanceConfig(instance *Instance) (map[string]string, map[string]string, error) {
+	provider, err := GetProviderByPlan(b.namePrefix, instance.Plan)
+	if err != nil {
+		return nil, nil, err
+	}
+	config, err := provider.GetConfig(instance)
+	if err != nil {
+		return nil, nil, err
+	}
+	config["plan"] = instance.Plan.ID
+	config["engine_version"] = instance.EngineVersion
+	settings, err := GetEffectiveSettings(NewClusterClient(), instance)
+	if err != nil {
+		return nil, nil, err
+	}
+	return config, settings, nil
+}
+
+// GET /v2/service_instances/{instance_id}/actions/diff/{other_id}
+func (b *BusinessLogic) DiffAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	actor, ok := adminActor(c)
+	if !ok {
+		glog.Infof("Rejected a diff of %s without a valid admin token and user\n", InstanceID)
+		return nil, Forbidden()
+	}
+	instances := make([]*Instance, 0)
+	for _, id := range []string{InstanceID, vars["other_id"]} {
+		instance, err := b.GetInstanceById(id)
+		if err != nil && err.Error() == "Cannot find resource instance" {
+			return nil, NotFound()
+		} else if err != nil {
+			glog.Errorf("Error finding instance id (during diff): %s\n", err.Error())
+			return nil, InternalServerError()
+		}
+		instances = append(instances, instance)
+	}
+	glog.Infof("%s is diffing %s and %s\n", actor, instances[0].Name, instances[1].Name)
+	config, settings, err := b.instanceConfig(instances[0])
+	if err != nil {
+		glog.Errorf("Unable to get the configuration of %s: %s\n", instances[0].Name, err.Error())
+		return nil, UnprocessableEntityWithMessage("ConfigUnavailable", "Unable to get the configuration of "+instances[0].Id+": "+err.Error())
+	}
+	otherConfig, otherSettings, err := b.instanceConfig(instances[1])
+	if err != nil {
+		glog.Errorf("Unable to get the configuration of %s: %s\n", instances[1].Name, err.Error())
+		return nil, UnprocessableEntityWithMessage("ConfigUnavailable", "Unable to get the configuration of "+instances[1].Id+": "+err.Error())
+	}
+	return ConfigDiff{
+		InstanceId: instances[0].Id,
+		OtherId:    instances[1].Id,
+		Config:     diffConfig(config, otherConfig),
+		Settings:   diffConfig(settings, otherSettings),
+	}, nil
+}
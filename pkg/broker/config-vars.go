@@ -0,0 +1,187 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// BindingParameters are the parameters accepted when creating a binding, config_var_names
+// renames the config vars (e.g., {"ES_URL":"ELASTICSEARCH_URL"}), config_vars overrides
+// (or adds to) the config vars returned for that binding and ttl (e.g., 24h) expires it.
+type BindingParameters struct {
+	ConfigVarNames map[string]string `json:"config_var_names,omitempty"`
+	ConfigVars     map[string]string `json:"config_vars,omitempty"`
+	TTL            string            `json:"ttl,omitempty"`
+}
+
+// RenameConfigVars renames the keys in vars using the names mapping, keys not in
+// the mapping keep their default names.
+func RenameConfigVars(vars map[string]interface{}, names map[string]string) map[string]interface{} {
+	if len(names) == 0 {
+		return vars
+	}
+	renamed := make(map[string]interface{})
+	for key, value := range vars {
+		if name, ok := names[key]; ok && name != "" {
+			renamed[name] = value
+		} else {
+			renamed[key] = value
+		}
+	}
+	return renamed
+}
+
+func ParseBindingParameters(parameters map[string]interface{}) (*BindingParameters, error) {
+	var params BindingParameters
+	if parameters == nil {
+		return &params, nil
+	}
+	byteData, err := json.Marshal(parameters)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(byteData, &params); err != nil {
+		return nil, err
+	}
+	if params.TTL != "" {
+		if ttl, err := time.ParseDuration(params.TTL); err != nil || ttl <= 0 {
+			return nil, errors.New("The ttl must be a positive duration, e.g., 24h.")
+		}
+	}
+	return &params, nil
+}
+
+func (p *BindingParameters) Apply(vars map[string]interface{}) map[string]interface{} {
+	if p == nil {
+		return vars
+	}
+	vars = RenameConfigVars(vars, p.ConfigVarNames)
+	for key, value := range p.ConfigVars {
+		vars[key] = value
+	}
+	return vars
+}
+
+// ConfigVars are the config vars exactly as akkeris sets them on an app, they
+// are the bindings credentials plus information about the instance.
+func ConfigVars(provider Provider, instance *Instance) map[string]interface{} {
+	vars := provider.GetUrl(instance)
+	if instance.Username != "" && instance.Password != "" {
+		vars["ES_USERNAME"] = instance.Username
+		vars["ES_PASSWORD"] = instance.Password
+	}
+	if os.Getenv("AWS_REGION") != "" {
+		vars["ES_REGION"] = os.Getenv("AWS_REGION")
+	}
+	if instance.ProviderId != "" {
+		vars["ES_ARN"] = instance.ProviderId
+	}
+	if instance.Plan != nil {
+		vars = RenameConfigVars(vars, instance.Plan.ConfigVarNames)
+	}
+	return vars
+}
+
+// Credentials are the providers urls with the plans config var names applied.
+func Credentials(provider Provider, instance *Instance) map[string]interface{} {
+	vars := provider.GetUrl(instance)
+	if instance.Plan != nil {
+		vars = RenameConfigVars(vars, instance.Plan.ConfigVarNames)
+	}
+	return vars
+}
+
+func (b *BusinessLogic) getBindingParameters(InstanceID string, BindingID string) (*BindingParameters, error) {
+	if BindingID == "" {
+		return nil, nil
+	}
+	data, err := b.storage.GetBindingParameters(InstanceID, BindingID)
+	if err != nil && err.Error() == "Not found" {
+		return nil, NotFound()
+	} else if err != nil {
+		return nil, err
+	}
+	var params BindingParameters
+	if err = json.Unmarshal([]byte(data), &params); err != nil {
+		return nil, err
+	}
+	return &params, nil
+}
+
+const maskedCredential = "********"
+
+// MaskCredentials masks the credentials whose names match one of the comma separated patterns
+// (e.g., ES_PASSWORD,*_URL) in BINDING_CREDENTIALS_MASK, they are only given in full when
+// the binding is created.
+func MaskCredentials(credentials map[string]interface{}) map[string]interface{} {
+	patterns := strings.Split(os.Getenv("BINDING_CREDENTIALS_MASK"), ",")
+	masked := make(map[string]interface{})
+	for key, value := range credentials {
+		masked[key] = value
+		for _, pattern := range patterns {
+			if matched, err := path.Match(strings.TrimSpace(pattern), key); err == nil && matched {
+				masked[key] = maskedCredential
+				break
+			}
+		}
+	}
+	return masked
+}
+
+// getBindingCredentials returns the credentials stored when the binding was created, or nil
+// if the binding predates storing them.
+func (b *BusinessLogic) getBindingCredentials(InstanceID string, BindingID string) (map[string]interface{}, error) {
+	data, err := b.storage.GetBindingCredentials(InstanceID, BindingID)
+	if err != nil && err.Error() == "Not found" {
+		return nil, NotFound()
+	} else if err != nil {
+		return nil, err
+	}
+	if data == "" {
+		return nil, nil
+	}
+	var credentials map[string]interface{}
+	if err = json.Unmarshal([]byte(data), &credentials); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// GET /v2/service_instances/{instance_id}/actions/config-vars[?binding_id=]
+func (b *BusinessLogic) ConfigVarsAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	Instance, err := b.GetInstanceById(InstanceID)
+	if err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Error finding instance id (during config vars): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	if !CanGetBindings(Instance.Status) {
+		return nil, UnprocessableEntityWithMessage("ServiceNotYetAvailable", "The service requested is not yet available.")
+	}
+	provider, err := GetProviderByPlan(b.namePrefix, Instance.Plan)
+	if err != nil {
+		glog.Errorf("Unable to get config vars, cannot find provider (GetProviderByPlan failed): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	bindingId := ""
+	if c != nil && c.Request != nil && c.Request.URL != nil {
+		bindingId = c.Request.URL.Query().Get("binding_id")
+	}
+	params, err := b.getBindingParameters(InstanceID, bindingId)
+	if _, ok := osb.IsHTTPError(err); ok {
+		return nil, err
+	} else if err != nil {
+		glog.Errorf("Unable to get binding parameters for %s: %s\n", bindingId, err.Error())
+		return nil, InternalServerError()
+	}
+	return params.Apply(ConfigVars(provider, Instance)), nil
+}
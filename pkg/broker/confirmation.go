@@ -0,0 +1,143 @@
+package broker
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// If CONFIRMATION_SECRET is set destructive operations (deprovisioning, and restoring over
+// the indices of an instance) require a confirmation token from a prior prepare call, so an
+// automation bug can't delete an instance with a single request. Tokens are signed with the
+// secret, name the operation and the instance and expire after ten minutes.
+
+const confirmationTokenHeader = "X-Confirmation-Token"
+
+const confirmationTTL = time.Minute * 10
+
+type ConfirmationRequest struct {
+	Operation string `json:"operation"`
+}
+
+type Confirmation struct {
+	Operation  string    `json:"operation"`
+	InstanceId string    `json:"instance_id"`
+	Name       string    `json:"name"`
+	Token      string    `json:"token"`
+	Expires    time.Time `json:"expires"`
+}
+
+var confirmedOperations = map[string]bool{
+	"deprovision": true,
+	"restore":     true,
+}
+
+func confirmationSecret() []byte {
+	return []byte(os.Getenv("CONFIRMATION_SECRET"))
+}
+
+func confirmationRequired() bool {
+	return len(confirmationSecret()) > 0
+}
+
+func signConfirmation(value string) string {
+	h := hmac.New(sha256.New, confirmationSecret())
+	h.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString([]byte(value)) + "." + base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// NewConfirmation issues a token confirming the operation on the instance.
+func NewConfirmation(operation string, instance *Instance) *Confirmation {
+	expires := time.Now().Add(confirmationTTL)
+	value := strings.Join([]string{operation, instance.Id, instance.Name, strconv.FormatInt(expires.Unix(), 10)}, ":")
+	return &Confirmation{Operation: operation, InstanceId: instance.Id, Name: instance.Name, Token: signConfirmation(value), Expires: expires}
+}
+
+// VerifyConfirmation returns an error unless the token confirms the operation on the instance
+// and has not expired.
+func VerifyConfirmation(token string, operation string, instance *Instance) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return errors.New("The confirmation token is invalid.")
+	}
+	value, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return errors.New("The confirmation token is invalid.")
+	}
+	if !hmac.Equal([]byte(signConfirmation(string(value))), []byte(token)) {
+		return errors.New("The confirmation token is invalid.")
+	}
+	fields := strings.Split(string(value), ":")
+	if len(fields) != 4 {
+		return errors.New("The confirmation token is invalid.")
+	}
+	if fields[0] != operation || fields[1] != instance.Id || fields[2] != instance.Name {
+		return errors.New("The confirmation token is for the " + fields[0] + " of " + fields[2] + ", not the " + operation + " of " + instance.Name + ".")
+	}
+	expires, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return errors.New("The confirmation token has expired, prepare the " + operation + " again.")
+	}
+	return nil
+}
+
+// requestConfirmationToken is the token of the X-Confirmation-Token header or the
+// confirmation_token query parameter.
+func requestConfirmationToken(c *broker.RequestContext) string {
+	if c == nil || c.Request == nil {
+		return ""
+	}
+	if token := c.Request.Header.Get(confirmationTokenHeader); token != "" {
+		return token
+	}
+	if c.Request.URL != nil {
+		return c.Request.URL.Query().Get("confirmation_token")
+	}
+	return ""
+}
+
+// checkConfirmation rejects a destructive operation without a valid confirmation token, if
+// confirmations are required.
+func checkConfirmation(token string, operation string, instance *Instance) error {
+	if !confirmationRequired() {
+		return nil
+	}
+	if token == "" {
+		return UnprocessableEntityWithMessage("ConfirmationRequired", "The "+operation+" must be confirmed, POST {\"operation\":\""+operation+"\"} to /v2/service_instances/"+instance.Id+"/actions/prepare and send its token in the "+confirmationTokenHeader+" header.")
+	}
+	if err := VerifyConfirmation(token, operation, instance); err != nil {
+		return UnprocessableEntityWithMessage("ConfirmationInvalid", err.Error())
+	}
+	return nil
+}
+
+// POST /v2/service_instances/{instance_id}/actions/prepare with {"operation":"deprovision"}
+func (b *BusinessLogic) PrepareAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	var request ConfirmationRequest
+	if c == nil || c.Request == nil || c.Request.Body == nil {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "The body must be {\"operation\":\"deprovision\"} or {\"operation\":\"restore\"}.")
+	}
+	if err := json.NewDecoder(c.Request.Body).Decode(&request); err != nil || !confirmedOperations[request.Operation] {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "The body must be {\"operation\":\"deprovision\"} or {\"operation\":\"restore\"}.")
+	}
+	if !confirmationRequired() {
+		return nil, UnprocessableEntityWithMessage("ConfirmationDisabled", "Operations do not require confirmation on this broker.")
+	}
+	instance, err := b.GetInstanceById(InstanceID)
+	if err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Error finding instance id (during prepare): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	return NewConfirmation(request.Operation, instance), nil
+}
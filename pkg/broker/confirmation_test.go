@@ -0,0 +1,69 @@
+package broker
+
+import (
+	"encoding/base64"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyConfirmation(t *testing.T) {
+	os.Setenv("CONFIRMATION_SECRET", "test-secret")
+	defer os.Unsetenv("CONFIRMATION_SECRET")
+	instance := &Instance{Id: "instance-1", Name: "logs"}
+	other := &Instance{Id: "instance-2", Name: "metrics"}
+	token := NewConfirmation("deprovision", instance).Token
+	expired := signConfirmation(strings.Join([]string{"deprovision", instance.Id, instance.Name, strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)}, ":"))
+	parts := strings.Split(token, ".")
+	tests := []struct {
+		name      string
+		token     string
+		operation string
+		instance  *Instance
+		err       string
+	}{
+		{"valid", token, "deprovision", instance, ""},
+		{"expired", expired, "deprovision", instance, "has expired"},
+		{"wrong operation", token, "restore", instance, "not the restore of logs"},
+		{"wrong instance", token, "deprovision", other, "not the deprovision of metrics"},
+		{"renamed instance", token, "deprovision", &Instance{Id: instance.Id, Name: "renamed"}, "not the deprovision of renamed"},
+		{"tampered signature", parts[0] + "." + strings.Repeat("A", len(parts[1])), "deprovision", instance, "is invalid"},
+		{"tampered value", base64.RawURLEncoding.EncodeToString([]byte("deprovision:instance-2:metrics:9999999999")) + "." + parts[1], "deprovision", other, "is invalid"},
+		{"no signature", parts[0], "deprovision", instance, "is invalid"},
+		{"empty", "", "deprovision", instance, "is invalid"},
+	}
+	for _, test := range tests {
+		err := VerifyConfirmation(test.token, test.operation, test.instance)
+		if test.err == "" && err != nil {
+			t.Errorf("%s: VerifyConfirmation() = %s, want no error", test.name, err.Error())
+		} else if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: VerifyConfirmation() = %v, want an error containing %q", test.name, err, test.err)
+		}
+	}
+
+	os.Setenv("CONFIRMATION_SECRET", "another-secret")
+	if err := VerifyConfirmation(token, "deprovision", instance); err == nil {
+		t.Errorf("VerifyConfirmation() of a token signed with another secret did not fail")
+	}
+}
+
+func TestCheckConfirmation(t *testing.T) {
+	instance := &Instance{Id: "instance-1", Name: "logs"}
+	os.Unsetenv("CONFIRMATION_SECRET")
+	if err := checkConfirmation("", "deprovision", instance); err != nil {
+		t.Errorf("checkConfirmation() without CONFIRMATION_SECRET = %s, want no error", err.Error())
+	}
+	os.Setenv("CONFIRMATION_SECRET", "test-secret")
+	defer os.Unsetenv("CONFIRMATION_SECRET")
+	if err := checkConfirmation("", "deprovision", instance); err == nil {
+		t.Errorf("checkConfirmation() without a token did not fail")
+	}
+	if err := checkConfirmation(NewConfirmation("restore", instance).Token, "deprovision", instance); err == nil {
+		t.Errorf("checkConfirmation() with the token of another operation did not fail")
+	}
+	if err := checkConfirmation(NewConfirmation("deprovision", instance).Token, "deprovision", instance); err != nil {
+		t.Errorf("checkConfirmation() = %s, want no error", err.Error())
+	}
+}
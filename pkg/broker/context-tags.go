@@ -0,0 +1,110 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+
+	"github.com/golang/glog"
+)
+
+// The OSB context of provision and update requests names where an instance is used (e.g.,
+// the organization and space on cloud foundry or the namespace on kubernetes), its fields are
+// tagged on the domain alongside its billingcode. CONTEXT_TAGS maps context fields to tag
+// names as a json object that overrides the defaults, an empty tag name leaves a field out.
+
+var defaultContextTags = map[string]string{
+	"organization_guid": "organization",
+	"organization_name": "organization-name",
+	"space_guid":        "space",
+	"space_name":        "space-name",
+	"app_guid":          "app",
+	"app_name":          "app-name",
+	"namespace":         "namespace",
+	"platform":          "platform",
+}
+
+func contextTagNames() (map[string]string, error) {
+	names := make(map[string]string)
+	for field, name := range defaultContextTags {
+		names[field] = name
+	}
+	if os.Getenv("CONTEXT_TAGS") != "" {
+		if err := json.Unmarshal([]byte(os.Getenv("CONTEXT_TAGS")), &names); err != nil {
+			return nil, errors.New("CONTEXT_TAGS must be a json object of context fields and tag names: " + err.Error())
+		}
+	}
+	return names, nil
+}
+
+// ContextTags returns the tags of the string fields of an OSB context that have a tag name.
+func ContextTags(context map[string]interface{}) (map[string]string, error) {
+	names, err := contextTagNames()
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]string)
+	for field, name := range names {
+		if value, ok := context[field].(string); ok && value != "" && name != "" {
+			value = tagValueInvalid.ReplaceAllString(value, "_")
+			if len(value) > 256 {
+				value = value[:256]
+			}
+			tags[name] = value
+		}
+	}
+	return tags, nil
+}
+
+// TagContext tags the domain with the tags of the last context the instance was provisioned
+// or updated with.
+func TagContext(storage Storage, provider Provider, instance *Instance) error {
+	tags, err := storage.GetContextTags(instance.Id)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0)
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err = provider.Tag(instance, name, tags[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetContextTags keeps the tags of the context of a request with the instance, they are tagged
+// on the domain once it is available (see TagContext).
+func SetContextTags(storage Storage, instance *Instance, context map[string]interface{}) error {
+	tags, err := ContextTags(context)
+	if err != nil {
+		return err
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	return storage.SetContextTags(instance.Id, tags)
+}
+
+// updateContextTags keeps and tags the context of an update of an available instance.
+func (b *BusinessLogic) updateContextTags(instance *Instance, context map[string]interface{}) {
+	if len(context) == 0 {
+		return
+	}
+	if err := SetContextTags(b.storage, instance, context); err != nil {
+		glog.Errorf("Error: Unable to set the context tags of instance (%s): %s\n", instance.Name, err.Error())
+		return
+	}
+	provider, err := GetProviderByPlan(b.namePrefix, instance.Plan)
+	if err != nil {
+		glog.Errorf("Error: Unable to tag the context of instance (%s), cannot get provider: %s\n", instance.Name, err.Error())
+		return
+	}
+	if err = TagContext(b.storage, provider, instance); err != nil {
+		glog.Errorf("Error: Unable to tag the context of instance (%s): %s\n", instance.Name, err.Error())
+	}
+}
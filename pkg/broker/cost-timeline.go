@@ -0,0 +1,277 @@
+package broker
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/golang/glog"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// The cost timeline of an instance puts its daily cost from Cost Explorer next to its daily
+// usage from CloudWatch and its plan changes, so owners can see how their spend follows the
+// plan and the traffic. Domains are tagged with their instance id (COST_TAG, default
+// instance-id) when they are provisioned or claimed, the tag must be activated as a cost
+// allocation tag in the billing console for Cost Explorer to report costs by it, which it
+// only does from the day it was activated.
+
+const (
+	costTimelineDays    = 30
+	costTimelineMaxDays = 365
+	costDateFormat      = "2006-01-02"
+)
+
+// costTagKey is the tag the instance id of a domain is tagged with.
+func costTagKey() string {
+	if os.Getenv("COST_TAG") != "" {
+		return os.Getenv("COST_TAG")
+	}
+	return "instance-id"
+}
+
+// TagInstanceId tags the domain with the id of its instance, for its cost allocation.
+func TagInstanceId(provider Provider, instance *Instance) error {
+	return provider.Tag(instance, costTagKey(), instance.Id)
+}
+
+type CostTimelineDay struct {
+	Date string `json:"date"`
+	// The cost (unblended) of the day in Unit, nil if Cost Explorer has none.
+	Cost      *float64 `json:"cost"`
+	Estimated bool     `json:"estimated"`
+	// The usage of the day, nil if CloudWatch has none.
+	SearchCount      *float64 `json:"search_count"`
+	IndexingCount    *float64 `json:"indexing_count"`
+	CPUUtilization   *float64 `json:"cpu_utilization"`
+	UsedStorageBytes *float64 `json:"used_storage_bytes"`
+	// The plan at the end of the day, and whether it changed during the day.
+	Plan        string `json:"plan"`
+	PlanChanged bool   `json:"plan_changed"`
+}
+
+type CostTimelinePlanChange struct {
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	Changed time.Time `json:"changed"`
+}
+
+type CostTimeline struct {
+	InstanceId  string                   `json:"instance_id"`
+	Name        string                   `json:"name"`
+	Unit        string                   `json:"unit"`
+	TotalCost   float64                  `json:"total_cost"`
+	Days        []CostTimelineDay        `json:"days"`
+	PlanChanges []CostTimelinePlanChange `json:"plan_changes"`
+}
+
+// dailyCosts returns the cost of each day (by date) of the resources tagged with the instance id.
+func dailyCosts(instance *Instance, start time.Time, end time.Time) (map[string]float64, map[string]bool, string, error) {
+	svc := costexplorer.New(awsSession(), aws.NewConfig().WithRegion("us-east-1"))
+	input := &costexplorer.GetCostAndUsageInput{
+		Filter: &costexplorer.Expression{
+			Tags: &costexplorer.TagValues{Key: aws.String(costTagKey()), Values: []*string{aws.String(instance.Id)}},
+		},
+		Granularity: aws.String(costexplorer.GranularityDaily),
+		Metrics:     []*string{aws.String("UnblendedCost")},
+		TimePeriod:  &costexplorer.DateInterval{Start: aws.String(start.Format(costDateFormat)), End: aws.String(end.Format(costDateFormat))},
+	}
+	costs := make(map[string]float64)
+	estimated := make(map[string]bool)
+	unit := "USD"
+	for {
+		res, err := svc.GetCostAndUsage(input)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		for _, result := range res.ResultsByTime {
+			if result.TimePeriod == nil || result.Total["UnblendedCost"] == nil {
+				continue
+			}
+			total := result.Total["UnblendedCost"]
+			amount, err := strconv.ParseFloat(aws.StringValue(total.Amount), 64)
+			if err != nil {
+				continue
+			}
+			date := aws.StringValue(result.TimePeriod.Start)
+			costs[date] = amount
+			estimated[date] = aws.BoolValue(result.Estimated)
+			if aws.StringValue(total.Unit) != "" {
+				unit = aws.StringValue(total.Unit)
+			}
+		}
+		if aws.StringValue(res.NextPageToken) == "" {
+			return costs, estimated, unit, nil
+		}
+		input.NextPageToken = res.NextPageToken
+	}
+}
+
+// dailyMetric returns the daily statistic (by date) of a metric of the domain.
+func dailyMetric(svc *cloudwatch.CloudWatch, instance *Instance, metric string, statistic string, start time.Time, end time.Time) (map[string]float64, error) {
+	res, err := svc.GetMetricStatistics(&cloudwatch.GetMetricStatisticsInput{
+		Namespace:  aws.String("AWS/ES"),
+		MetricName: aws.String(metric),
+		Dimensions: []*cloudwatch.Dimension{
+			&cloudwatch.Dimension{Name: aws.String("DomainName"), Value: aws.String(instance.Name)},
+			&cloudwatch.Dimension{Name: aws.String("ClientId"), Value: aws.String(os.Getenv("AWS_ACCOUNT_ID"))},
+		},
+		StartTime:  aws.Time(start),
+		EndTime:    aws.Time(end),
+		Period:     aws.Int64(86400),
+		Statistics: []*string{aws.String(statistic)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]float64)
+	for _, point := range res.Datapoints {
+		if point.Timestamp == nil {
+			continue
+		}
+		var value *float64
+		switch statistic {
+		case cloudwatch.StatisticSum:
+			value = point.Sum
+		case cloudwatch.StatisticAverage:
+			value = point.Average
+		case cloudwatch.StatisticMaximum:
+			value = point.Maximum
+		}
+		if value != nil {
+			values[aws.TimeValue(point.Timestamp).UTC().Format(costDateFormat)] = *value
+		}
+	}
+	return values, nil
+}
+
+func dayValue(values map[string]float64, date string) *float64 {
+	if value, ok := values[date]; ok {
+		return &value
+	}
+	return nil
+}
+
+// planChanges returns the plan changes recorded in the events of the instance.
+func planChanges(events []Event) []CostTimelinePlanChange {
+	changes := make([]CostTimelinePlanChange, 0)
+	plan := ""
+	for _, event := range events {
+		if event.Data.Plan == nil {
+			continue
+		}
+		if plan != "" && *event.Data.Plan != plan {
+			changes = append(changes, CostTimelinePlanChange{From: plan, To: *event.Data.Plan, Changed: event.Created})
+		}
+		plan = *event.Data.Plan
+	}
+	return changes
+}
+
+// BuildCostTimeline combines the daily costs, usage and plan changes of the instance for the
+// days up to today.
+func BuildCostTimeline(storage Storage, instance *Instance, days int) (*CostTimeline, error) {
+	end := time.Now().UTC().Truncate(time.Hour * 24).Add(time.Hour * 24)
+	start := end.Add(-time.Hour * 24 * time.Duration(days))
+	events, err := storage.GetEvents(instance.Id)
+	if err != nil {
+		return nil, err
+	}
+	costs, estimated, unit, err := dailyCosts(instance, start, end)
+	if err != nil {
+		return nil, err
+	}
+	svc := cloudwatch.New(awsSession())
+	usage := make(map[string]map[string]float64)
+	for _, metric := range []struct{ name, statistic string }{
+		{"SearchRate", cloudwatch.StatisticSum},
+		{"IndexingRate", cloudwatch.StatisticSum},
+		{"CPUUtilization", cloudwatch.StatisticAverage},
+		{"ClusterUsedSpace", cloudwatch.StatisticMaximum},
+	} {
+		if usage[metric.name], err = dailyMetric(svc, instance, metric.name, metric.statistic, start, end); err != nil {
+			return nil, err
+		}
+	}
+	// ClusterUsedSpace is reported in megabytes
+	for date, used := range usage["ClusterUsedSpace"] {
+		usage["ClusterUsedSpace"][date] = used * 1024 * 1024
+	}
+	changes := planChanges(events)
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Changed.Before(changes[j].Changed) })
+	timeline := &CostTimeline{
+		InstanceId:  instance.Id,
+		Name:        instance.Name,
+		Unit:        unit,
+		Days:        make([]CostTimelineDay, 0),
+		PlanChanges: make([]CostTimelinePlanChange, 0),
+	}
+	for _, change := range changes {
+		if !change.Changed.Before(start) {
+			timeline.PlanChanges = append(timeline.PlanChanges, change)
+		}
+	}
+	for day := start; day.Before(end); day = day.Add(time.Hour * 24) {
+		date := day.Format(costDateFormat)
+		entry := CostTimelineDay{
+			Date:             date,
+			Cost:             dayValue(costs, date),
+			Estimated:        estimated[date],
+			SearchCount:      dayValue(usage["SearchRate"], date),
+			IndexingCount:    dayValue(usage["IndexingRate"], date),
+			CPUUtilization:   dayValue(usage["CPUUtilization"], date),
+			UsedStorageBytes: dayValue(usage["ClusterUsedSpace"], date),
+			Plan:             instance.Plan.ID,
+		}
+		// the plan of the day is the one in effect at its end, the current plan if it has
+		// not changed since.
+		nextDay := day.Add(time.Hour * 24)
+		for i := len(changes) - 1; i >= 0; i-- {
+			if !changes[i].Changed.Before(nextDay) {
+				entry.Plan = changes[i].From
+			} else {
+				break
+			}
+		}
+		for _, change := range changes {
+			if !change.Changed.Before(day) && change.Changed.Before(nextDay) {
+				entry.PlanChanged = true
+			}
+		}
+		if entry.Cost != nil {
+			timeline.TotalCost += *entry.Cost
+		}
+		timeline.Days = append(timeline.Days, entry)
+	}
+	return timeline, nil
+}
+
+// GET /v2/service_instances/{instance_id}/actions/cost-timeline?days=30
+func (b *BusinessLogic) CostTimelineAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	instance, err := b.GetInstanceById(InstanceID)
+	if err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Error finding instance id (during cost timeline): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	if !instance.Plan.Provider.IsAWSDomain() || SimulationMode() {
+		return nil, UnprocessableEntityWithMessage("NotSupported", "The cost timeline is only available for aws domains.")
+	}
+	days := costTimelineDays
+	if value := c.Request.URL.Query().Get("days"); value != "" {
+		if days, err = strconv.Atoi(value); err != nil || days < 1 || days > costTimelineMaxDays {
+			return nil, BadRequestWithMessage("The days must be a number between 1 and " + strconv.Itoa(costTimelineMaxDays) + ".")
+		}
+	}
+	timeline, err := BuildCostTimeline(b.storage, instance, days)
+	if err != nil {
+		glog.Errorf("Unable to build the cost timeline of %s: %s\n", instance.Name, err.Error())
+		return nil, InternalServerError()
+	}
+	return timeline, nil
+}
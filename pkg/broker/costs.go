@@ -0,0 +1,141 @@
+package broker
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/golang/glog"
+)
+
+// Each plan in the catalog carries a monthly cost estimate in its metadata ("costs", as
+// platforms expect it) so users see what an instance costs before provisioning it. The cost
+// is derived from the plan: its data, dedicated master and UltraWarm nodes at their hourly
+// on-demand price and the EBS storage of each data node. The default prices are the aws
+// us-east-1 on-demand prices in usd, INSTANCE_TYPE_PRICES (json of the hourly price of each
+// instance type) and EBS_PRICES (json of the monthly price of a GB of each volume type)
+// override or add to them for other regions or negotiated prices.
+
+const hoursPerMonth = 730
+
+var defaultInstanceTypePrices = map[string]float64{
+	"t2.small.elasticsearch":          0.036,
+	"t2.medium.elasticsearch":         0.073,
+	"t3.small.elasticsearch":          0.036,
+	"t3.medium.elasticsearch":         0.073,
+	"m5.large.elasticsearch":          0.142,
+	"m5.xlarge.elasticsearch":         0.283,
+	"m5.2xlarge.elasticsearch":        0.566,
+	"m5.4xlarge.elasticsearch":        1.132,
+	"m5.12xlarge.elasticsearch":       3.396,
+	"c5.large.elasticsearch":          0.125,
+	"c5.xlarge.elasticsearch":         0.250,
+	"c5.2xlarge.elasticsearch":        0.500,
+	"c5.4xlarge.elasticsearch":        1.000,
+	"r5.large.elasticsearch":          0.186,
+	"r5.xlarge.elasticsearch":         0.372,
+	"r5.2xlarge.elasticsearch":        0.744,
+	"r5.4xlarge.elasticsearch":        1.488,
+	"r5.12xlarge.elasticsearch":       4.464,
+	"i3.large.elasticsearch":          0.249,
+	"i3.xlarge.elasticsearch":         0.498,
+	"i3.2xlarge.elasticsearch":        0.996,
+	"ultrawarm1.medium.elasticsearch": 0.238,
+	"ultrawarm1.large.elasticsearch":  2.680,
+}
+
+var defaultEBSPrices = map[string]float64{
+	"gp2":      0.135,
+	"gp3":      0.122,
+	"io1":      0.169,
+	"standard": 0.067,
+}
+
+// nodeGroup is a number of nodes of one instance type.
+type nodeGroup struct {
+	instanceType string
+	count        int64
+}
+
+type PlanCost struct {
+	Amount map[string]float64 `json:"amount"`
+	Unit   string             `json:"unit"`
+}
+
+// pricesFromEnv returns the defaults with the prices set in the environment variable (json).
+func pricesFromEnv(name string, defaults map[string]float64) map[string]float64 {
+	prices := make(map[string]float64)
+	for key, price := range defaults {
+		prices[key] = price
+	}
+	if os.Getenv(name) == "" {
+		return prices
+	}
+	var overrides map[string]float64
+	if err := json.Unmarshal([]byte(os.Getenv(name)), &overrides); err != nil {
+		glog.Errorf("Invalid %s, using the default prices: %s\n", name, err.Error())
+		return prices
+	}
+	for key, price := range overrides {
+		prices[key] = price
+	}
+	return prices
+}
+
+// PlanMonthlyCost estimates the monthly cost (in usd) of an instance of the plan, ok is false
+// if the plan is not an aws plan or uses an instance type without a price.
+func PlanMonthlyCost(plan *ProviderPlan) (float64, bool) {
+	if !plan.Provider.IsAWSDomain() {
+		return 0, false
+	}
+	settings, err := planDomainInput(plan)
+	if err != nil || settings.ElasticsearchClusterConfig == nil {
+		return 0, false
+	}
+	config := settings.ElasticsearchClusterConfig
+	prices := pricesFromEnv("INSTANCE_TYPE_PRICES", defaultInstanceTypePrices)
+	dataNodes := aws.Int64Value(config.InstanceCount)
+	if config.InstanceCount == nil {
+		dataNodes = 1
+	}
+	nodes := []nodeGroup{{aws.StringValue(config.InstanceType), dataNodes}}
+	if aws.BoolValue(config.DedicatedMasterEnabled) {
+		nodes = append(nodes, nodeGroup{aws.StringValue(config.DedicatedMasterType), aws.Int64Value(config.DedicatedMasterCount)})
+	}
+	if aws.BoolValue(config.WarmEnabled) {
+		nodes = append(nodes, nodeGroup{aws.StringValue(config.WarmType), aws.Int64Value(config.WarmCount)})
+	}
+	hourly := 0.0
+	for _, node := range nodes {
+		price, ok := prices[node.instanceType]
+		if !ok {
+			glog.Infof("No price for the instance type %s, the plan %s has no cost estimate\n", node.instanceType, plan.ID)
+			return 0, false
+		}
+		hourly += price * float64(node.count)
+	}
+	monthly := hourly * hoursPerMonth
+	if ebs := settings.EBSOptions; ebs != nil && aws.BoolValue(ebs.EBSEnabled) {
+		volumeType := aws.StringValue(ebs.VolumeType)
+		if volumeType == "" {
+			volumeType = "gp2"
+		}
+		price, ok := pricesFromEnv("EBS_PRICES", defaultEBSPrices)[volumeType]
+		if !ok {
+			glog.Infof("No price for the volume type %s, the plan %s has no cost estimate\n", volumeType, plan.ID)
+			return 0, false
+		}
+		monthly += price * float64(aws.Int64Value(ebs.VolumeSize)) * float64(dataNodes)
+	}
+	return math.Round(monthly*100) / 100, true
+}
+
+// planCosts is the costs metadata of a plan, nil if its cost can't be estimated.
+func planCosts(plan *ProviderPlan) []PlanCost {
+	monthly, ok := PlanMonthlyCost(plan)
+	if !ok {
+		return nil
+	}
+	return []PlanCost{{Amount: map[string]float64{"usd": monthly}, Unit: "MONTHLY"}}
+}
@@ -0,0 +1,177 @@
+package broker
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+type IndexGrowth struct {
+	Index       string `json:"index"`
+	Bytes       int64  `json:"bytes"`
+	Docs        int64  `json:"docs"`
+	GrowthBytes int64  `json:"growth_bytes"`
+}
+
+type InstanceDigest struct {
+	InstanceId        string        `json:"instance_id"`
+	Name              string        `json:"name"`
+	Plan              string        `json:"plan"`
+	UsedBytes         int64         `json:"used_bytes"`
+	TotalBytes        int64         `json:"total_bytes"`
+	GrowthBytesPerDay float64       `json:"growth_bytes_per_day"`
+	ProjectedFull     *time.Time    `json:"projected_full,omitempty"`
+	Indices           []IndexGrowth `json:"indices"`
+}
+
+type OwnerDigest struct {
+	Owner     string           `json:"owner"`
+	Generated time.Time        `json:"generated"`
+	Since     time.Time        `json:"since"`
+	Instances []InstanceDigest `json:"instances"`
+}
+
+// StorageTrend fits a line through the samples and returns the growth in bytes per
+// day and when the used bytes will reach limit, nil if usage is not growing.
+func StorageTrend(samples []StorageSample, limit float64) (float64, *time.Time) {
+	if len(samples) < 2 {
+		return 0, nil
+	}
+	start := samples[0].Sampled
+	var n, sumX, sumY, sumXY, sumXX float64
+	for _, sample := range samples {
+		x := sample.Sampled.Sub(start).Hours() / 24
+		y := float64(sample.UsedBytes)
+		n++
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	if n*sumXX-sumX*sumX == 0 {
+		return 0, nil
+	}
+	slope := (n*sumXY - sumX*sumY) / (n*sumXX - sumX*sumX)
+	if slope <= 0 {
+		return slope, nil
+	}
+	last := samples[len(samples)-1]
+	days := (limit - float64(last.UsedBytes)) / slope
+	if days < 0 {
+		days = 0
+	}
+	full := last.Sampled.Add(time.Duration(days * 24 * float64(time.Hour)))
+	return slope, &full
+}
+
+func digestTopIndices() int {
+	if count, err := strconv.Atoi(os.Getenv("DIGEST_TOP_INDICES")); err == nil && count > 0 {
+		return count
+	}
+	return 10
+}
+
+func GetInstanceDigest(storage Storage, entry Entry, since time.Time) (*InstanceDigest, error) {
+	samples, err := storage.GetStorageSamples(entry.Id, since)
+	if err != nil {
+		return nil, err
+	}
+	if len(samples) == 0 {
+		return nil, nil
+	}
+	indices, err := storage.GetIndexGrowth(entry.Id, since)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(indices, func(i, j int) bool { return indices[i].Bytes > indices[j].Bytes })
+	if len(indices) > digestTopIndices() {
+		indices = indices[0:digestTopIndices()]
+	}
+	last := samples[len(samples)-1]
+	rate, full := StorageTrend(samples, float64(last.TotalBytes))
+	return &InstanceDigest{
+		InstanceId:        entry.Id,
+		Name:              entry.Name,
+		Plan:              entry.PlanId,
+		UsedBytes:         last.UsedBytes,
+		TotalBytes:        last.TotalBytes,
+		GrowthBytesPerDay: rate,
+		ProjectedFull:     full,
+		Indices:           indices,
+	}, nil
+}
+
+// postSigned posts body to url, if secret is set the body is signed with an
+// x-osb-signature header the same way hooks are.
+func postSigned(url string, secret string, body []byte) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("content-type", "application/json")
+	if secret != "" {
+		h := hmac.New(sha256.New, []byte(secret))
+		h.Write(body)
+		req.Header.Add("x-osb-signature", base64.StdEncoding.EncodeToString(h.Sum(nil)))
+	}
+	client := &http.Client{Timeout: time.Second * 60}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New(url + " returned " + strconv.Itoa(resp.StatusCode))
+	}
+	return nil
+}
+
+// SendDigests posts a digest per owner of their instances largest indices, growth and
+// projected storage exhaustion to DIGEST_WEBHOOK.
+func SendDigests(storage Storage) {
+	url := os.Getenv("DIGEST_WEBHOOK")
+	if url == "" {
+		return
+	}
+	entries, err := storage.GetInstances()
+	if err != nil {
+		glog.Errorf("Unable to send digests, cannot get instances: %s\n", err.Error())
+		return
+	}
+	now := time.Now()
+	since := now.Add(-time.Hour * 24 * 7)
+	digests := make(map[string]*OwnerDigest)
+	owners := make([]string, 0)
+	for _, entry := range entries {
+		if entry.Owner == "" {
+			continue
+		}
+		instance, err := GetInstanceDigest(storage, entry, since)
+		if err != nil {
+			glog.Errorf("Unable to build the digest for %s: %s\n", entry.Name, err.Error())
+			continue
+		}
+		if instance == nil {
+			continue
+		}
+		if _, ok := digests[entry.Owner]; !ok {
+			digests[entry.Owner] = &OwnerDigest{Owner: entry.Owner, Generated: now, Since: since, Instances: make([]InstanceDigest, 0)}
+			owners = append(owners, entry.Owner)
+		}
+		digests[entry.Owner].Instances = append(digests[entry.Owner].Instances, *instance)
+	}
+	for _, owner := range owners {
+		if err := Notify(storage, StorageDigestNotification, url, os.Getenv("DIGEST_WEBHOOK_SECRET"), digests[owner]); err != nil {
+			glog.Errorf("Unable to send the digest for %s: %s\n", owner, err.Error())
+		}
+	}
+}
@@ -7,4 +7,7 @@
 //   business logic
 // - The NewBusinessLogic function, which creates a BusinessLogic from the
 //   Options the program is run with
+//
+// The broker can also be embedded in another go service, NewHandler returns the OSB
+// api as an http.Handler and a Service with methods to provision and bind directly.
 package broker // import "github.com/akkeris/elasticsearch-broker/pkg/broker"
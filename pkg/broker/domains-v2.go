@@ -0,0 +1,211 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	elasticsearchv2 "github.com/aws/aws-sdk-go-v2/service/elasticsearchservice"
+	"github.com/aws/aws-sdk-go-v2/service/elasticsearchservice/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/elasticsearchservice"
+)
+
+// sdkV2DomainService is the DomainService on aws-sdk-go-v2, the default unless AWS_SDK_VERSION
+// is v1. Plans keep the v1 shapes, both sdks are generated from the same api model so the
+// inputs and outputs are converted between them through json (see convertShape).
+
+// awsRetryerV2 is the v2 equivalent of awsRetryer, AWS_MAX_RETRIES counts the retries after
+// the first attempt as it does for the v1 sdk.
+func awsRetryerV2() awsv2.Retryer {
+	return retry.NewStandard(func(o *retry.StandardOptions) {
+		o.MaxAttempts = client.DefaultRetryerMaxNumRetries + 1
+		if retries, err := strconv.Atoi(os.Getenv("AWS_MAX_RETRIES")); err == nil && retries >= 0 {
+			o.MaxAttempts = retries + 1
+		}
+		if seconds, err := strconv.Atoi(os.Getenv("AWS_MAX_RETRY_DELAY")); err == nil && seconds > 0 {
+			o.MaxBackoff = time.Duration(seconds) * time.Second
+		}
+	})
+}
+
+// sessionCredentials gives the v2 clients the credentials of the shared v1 session, so both
+// sdks use the same credential chain and session refreshes.
+func sessionCredentials(sess *session.Session) awsv2.CredentialsProvider {
+	return awsv2.CredentialsProviderFunc(func(ctx context.Context) (awsv2.Credentials, error) {
+		value, err := sess.Config.Credentials.GetWithContext(ctx)
+		if err != nil {
+			return awsv2.Credentials{}, err
+		}
+		credentials := awsv2.Credentials{
+			AccessKeyID:     value.AccessKeyID,
+			SecretAccessKey: value.SecretAccessKey,
+			SessionToken:    value.SessionToken,
+			Source:          value.ProviderName,
+		}
+		if expires, err := sess.Config.Credentials.ExpiresAt(); err == nil {
+			credentials.CanExpire = true
+			credentials.Expires = expires
+		}
+		return credentials, nil
+	})
+}
+
+// newElasticsearchServiceV2 returns the v2 elasticsearch service client of the current session.
+func newElasticsearchServiceV2() *elasticsearchv2.Client {
+	awsSessions.Lock()
+	defer awsSessions.Unlock()
+	sess := awsSessionLocked()
+	if awsSessions.esV2 == nil {
+		awsSessions.esV2 = elasticsearchv2.NewFromConfig(awsv2.Config{
+			Region:      aws.StringValue(sess.Config.Region),
+			Credentials: sessionCredentials(sess),
+			Retryer:     awsRetryerV2,
+		}, func(o *elasticsearchv2.Options) {
+			o.EndpointResolver = elasticsearchv2.EndpointResolverFromURL(esEndpoint())
+		})
+	}
+	return awsSessions.esV2
+}
+
+// convertShape copies from into to, a type of the other sdk with the same fields.
+func convertShape(from interface{}, to interface{}) error {
+	data, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, to)
+}
+
+func fromDomainV2(domain *types.ElasticsearchDomainStatus) (*elasticsearchservice.ElasticsearchDomainStatus, error) {
+	if domain == nil {
+		return nil, nil
+	}
+	var status elasticsearchservice.ElasticsearchDomainStatus
+	if err := convertShape(domain, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+type sdkV2DomainService struct{}
+
+// check refreshes the AWS session if aws rejected its credentials, as the v1 session's
+// handler does for the v1 clients.
+func (s sdkV2DomainService) check(err error) error {
+	if err != nil && isCredentialsError(err) {
+		RefreshAWSSession()
+	}
+	return err
+}
+
+func (s sdkV2DomainService) DescribeDomain(ctx context.Context, name string) (*elasticsearchservice.ElasticsearchDomainStatus, error) {
+	res, err := newElasticsearchServiceV2().DescribeElasticsearchDomain(ctx, &elasticsearchv2.DescribeElasticsearchDomainInput{
+		DomainName: awsv2.String(name),
+	})
+	if err != nil {
+		return nil, s.check(err)
+	}
+	return fromDomainV2(res.DomainStatus)
+}
+
+func (s sdkV2DomainService) CreateDomain(ctx context.Context, input *elasticsearchservice.CreateElasticsearchDomainInput) (*elasticsearchservice.ElasticsearchDomainStatus, error) {
+	var create elasticsearchv2.CreateElasticsearchDomainInput
+	if err := convertShape(input, &create); err != nil {
+		return nil, err
+	}
+	res, err := newElasticsearchServiceV2().CreateElasticsearchDomain(ctx, &create)
+	if err != nil {
+		return nil, s.check(err)
+	}
+	return fromDomainV2(res.DomainStatus)
+}
+
+func (s sdkV2DomainService) UpdateDomainConfig(ctx context.Context, input *elasticsearchservice.UpdateElasticsearchDomainConfigInput) error {
+	var update elasticsearchv2.UpdateElasticsearchDomainConfigInput
+	if err := convertShape(input, &update); err != nil {
+		return err
+	}
+	_, err := newElasticsearchServiceV2().UpdateElasticsearchDomainConfig(ctx, &update)
+	return s.check(err)
+}
+
+func (s sdkV2DomainService) DeleteDomain(ctx context.Context, name string) error {
+	_, err := newElasticsearchServiceV2().DeleteElasticsearchDomain(ctx, &elasticsearchv2.DeleteElasticsearchDomainInput{
+		DomainName: awsv2.String(name),
+	})
+	return s.check(err)
+}
+
+func (s sdkV2DomainService) AddTags(ctx context.Context, arn string, tags map[string]string) error {
+	tagList := make([]types.Tag, 0)
+	for key, value := range tags {
+		tagList = append(tagList, types.Tag{Key: awsv2.String(key), Value: awsv2.String(value)})
+	}
+	_, err := newElasticsearchServiceV2().AddTags(ctx, &elasticsearchv2.AddTagsInput{
+		ARN:     awsv2.String(arn),
+		TagList: tagList,
+	})
+	return s.check(err)
+}
+
+func (s sdkV2DomainService) RemoveTags(ctx context.Context, arn string, keys []string) error {
+	_, err := newElasticsearchServiceV2().RemoveTags(ctx, &elasticsearchv2.RemoveTagsInput{
+		ARN:     awsv2.String(arn),
+		TagKeys: keys,
+	})
+	return s.check(err)
+}
+
+func (s sdkV2DomainService) ListVersions(ctx context.Context) ([]string, error) {
+	versions := make([]string, 0)
+	input := &elasticsearchv2.ListElasticsearchVersionsInput{}
+	for {
+		res, err := newElasticsearchServiceV2().ListElasticsearchVersions(ctx, input)
+		if err != nil {
+			return nil, s.check(err)
+		}
+		versions = append(versions, res.ElasticsearchVersions...)
+		if awsv2.ToString(res.NextToken) == "" {
+			return versions, nil
+		}
+		input.NextToken = res.NextToken
+	}
+}
+
+func (s sdkV2DomainService) CompatibleVersions(ctx context.Context) (map[string][]string, error) {
+	res, err := newElasticsearchServiceV2().GetCompatibleElasticsearchVersions(ctx, &elasticsearchv2.GetCompatibleElasticsearchVersionsInput{})
+	if err != nil {
+		return nil, s.check(err)
+	}
+	versions := make(map[string][]string)
+	for _, compatible := range res.CompatibleElasticsearchVersions {
+		versions[awsv2.ToString(compatible.SourceVersion)] = compatible.TargetVersions
+	}
+	return versions, nil
+}
+
+func (s sdkV2DomainService) UpgradeDomain(ctx context.Context, name string, version string) error {
+	_, err := newElasticsearchServiceV2().UpgradeElasticsearchDomain(ctx, &elasticsearchv2.UpgradeElasticsearchDomainInput{
+		DomainName:    awsv2.String(name),
+		TargetVersion: awsv2.String(version),
+	})
+	return s.check(err)
+}
+
+func (s sdkV2DomainService) IsNotFound(err error) bool {
+	var notFound *types.ResourceNotFoundException
+	return errors.As(err, &notFound)
+}
+
+func (s sdkV2DomainService) IsAlreadyExists(err error) bool {
+	var exists *types.ResourceAlreadyExistsException
+	return errors.As(err, &exists)
+}
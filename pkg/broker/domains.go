@@ -0,0 +1,154 @@
+package broker
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/elasticsearchservice"
+)
+
+// DomainService is the part of the elasticsearch service api the aws provider uses, so the
+// sdk behind it can be changed (e.g., to aws-sdk-go-v2 or the OpenSearch api) without
+// touching the provider. Plans keep the shape of the v1 CreateElasticsearchDomainInput their
+// provider_private_details are read into, other implementations (see domains-v2.go) convert
+// from these types.
+type DomainService interface {
+	DescribeDomain(ctx context.Context, name string) (*elasticsearchservice.ElasticsearchDomainStatus, error)
+	CreateDomain(ctx context.Context, input *elasticsearchservice.CreateElasticsearchDomainInput) (*elasticsearchservice.ElasticsearchDomainStatus, error)
+	UpdateDomainConfig(ctx context.Context, input *elasticsearchservice.UpdateElasticsearchDomainConfigInput) error
+	DeleteDomain(ctx context.Context, name string) error
+	AddTags(ctx context.Context, arn string, tags map[string]string) error
+	RemoveTags(ctx context.Context, arn string, keys []string) error
+	// ListVersions returns the engine versions new domains can be created with.
+	ListVersions(ctx context.Context) ([]string, error)
+	// CompatibleVersions returns the versions each engine version can be upgraded to.
+	CompatibleVersions(ctx context.Context) (map[string][]string, error)
+	// UpgradeDomain starts an in-place upgrade of the domain to the engine version.
+	UpgradeDomain(ctx context.Context, name string, version string) error
+	// IsNotFound is true if the error is because the domain does not exist.
+	IsNotFound(err error) bool
+	// IsAlreadyExists is true if the error is because a domain with the name exists.
+	IsAlreadyExists(err error) bool
+}
+
+// NewDomainService creates the DomainService of the aws provider, on aws-sdk-go-v2 unless
+// AWS_SDK_VERSION is v1. Services embedding the broker can replace it (e.g., with a fake api).
+var NewDomainService = func() DomainService {
+	if os.Getenv("AWS_SDK_VERSION") == "v1" {
+		return sdkDomainService{}
+	}
+	return sdkV2DomainService{}
+}
+
+// domainRequestTimeout bounds each call to the elasticsearch service api (including its
+// retries), it can be set in seconds with AWS_REQUEST_TIMEOUT.
+func domainRequestTimeout() time.Duration {
+	if seconds, err := strconv.Atoi(os.Getenv("AWS_REQUEST_TIMEOUT")); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return time.Minute * 2
+}
+
+// sdkDomainService is the DomainService on the v1 sdk (AWS_SDK_VERSION=v1), the client is
+// looked up on each call so it picks up a refreshed AWS session.
+type sdkDomainService struct{}
+
+func (s sdkDomainService) DescribeDomain(ctx context.Context, name string) (*elasticsearchservice.ElasticsearchDomainStatus, error) {
+	res, err := newElasticsearchService().DescribeElasticsearchDomainWithContext(ctx, &elasticsearchservice.DescribeElasticsearchDomainInput{
+		DomainName: aws.String(name),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.DomainStatus, nil
+}
+
+func (s sdkDomainService) CreateDomain(ctx context.Context, input *elasticsearchservice.CreateElasticsearchDomainInput) (*elasticsearchservice.ElasticsearchDomainStatus, error) {
+	res, err := newElasticsearchService().CreateElasticsearchDomainWithContext(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	return res.DomainStatus, nil
+}
+
+func (s sdkDomainService) UpdateDomainConfig(ctx context.Context, input *elasticsearchservice.UpdateElasticsearchDomainConfigInput) error {
+	_, err := newElasticsearchService().UpdateElasticsearchDomainConfigWithContext(ctx, input)
+	return err
+}
+
+func (s sdkDomainService) DeleteDomain(ctx context.Context, name string) error {
+	_, err := newElasticsearchService().DeleteElasticsearchDomainWithContext(ctx, &elasticsearchservice.DeleteElasticsearchDomainInput{
+		DomainName: aws.String(name),
+	})
+	return err
+}
+
+func (s sdkDomainService) AddTags(ctx context.Context, arn string, tags map[string]string) error {
+	tagList := make([]*elasticsearchservice.Tag, 0)
+	for key, value := range tags {
+		tagList = append(tagList, &elasticsearchservice.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+	_, err := newElasticsearchService().AddTagsWithContext(ctx, &elasticsearchservice.AddTagsInput{
+		ARN:     aws.String(arn),
+		TagList: tagList,
+	})
+	return err
+}
+
+func (s sdkDomainService) RemoveTags(ctx context.Context, arn string, keys []string) error {
+	_, err := newElasticsearchService().RemoveTagsWithContext(ctx, &elasticsearchservice.RemoveTagsInput{
+		ARN:     aws.String(arn),
+		TagKeys: aws.StringSlice(keys),
+	})
+	return err
+}
+
+func (s sdkDomainService) ListVersions(ctx context.Context) ([]string, error) {
+	versions := make([]string, 0)
+	input := &elasticsearchservice.ListElasticsearchVersionsInput{}
+	for {
+		res, err := newElasticsearchService().ListElasticsearchVersionsWithContext(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, aws.StringValueSlice(res.ElasticsearchVersions)...)
+		if aws.StringValue(res.NextToken) == "" {
+			return versions, nil
+		}
+		input.NextToken = res.NextToken
+	}
+}
+
+func (s sdkDomainService) CompatibleVersions(ctx context.Context) (map[string][]string, error) {
+	res, err := newElasticsearchService().GetCompatibleElasticsearchVersionsWithContext(ctx, &elasticsearchservice.GetCompatibleElasticsearchVersionsInput{})
+	if err != nil {
+		return nil, err
+	}
+	versions := make(map[string][]string)
+	for _, compatible := range res.CompatibleElasticsearchVersions {
+		versions[aws.StringValue(compatible.SourceVersion)] = aws.StringValueSlice(compatible.TargetVersions)
+	}
+	return versions, nil
+}
+
+func (s sdkDomainService) UpgradeDomain(ctx context.Context, name string, version string) error {
+	_, err := newElasticsearchService().UpgradeElasticsearchDomainWithContext(ctx, &elasticsearchservice.UpgradeElasticsearchDomainInput{
+		DomainName:    aws.String(name),
+		TargetVersion: aws.String(version),
+	})
+	return err
+}
+
+func (s sdkDomainService) IsNotFound(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == elasticsearchservice.ErrCodeResourceNotFoundException
+}
+
+func (s sdkDomainService) IsAlreadyExists(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == elasticsearchservice.ErrCodeResourceAlreadyExistsException
+}
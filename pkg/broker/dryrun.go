@@ -0,0 +1,66 @@
+package broker
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// An AutomatedAction is a corrective action the broker takes on its own, each can be
+// put in dry-run mode where it only reports what it would have done.
+type AutomatedAction string
+
+const (
+	ReconcileAction         AutomatedAction = "reconcile"
+	RemediateReadOnlyAction AutomatedAction = "remediate-read-only"
+	ExpireAction            AutomatedAction = "expire"
+	ExpireBindingAction     AutomatedAction = "expire-binding"
+	DeleteOrphanAction      AutomatedAction = "delete-orphan"
+	RevertSettingsAction    AutomatedAction = "revert-settings"
+	RehearseUpgradeAction   AutomatedAction = "rehearse-upgrade"
+)
+
+type DryRunReport struct {
+	InstanceId  string          `json:"instance_id"`
+	Action      AutomatedAction `json:"action"`
+	Description string          `json:"description"`
+	Created     time.Time       `json:"created"`
+}
+
+// DryRun is true if the action should only be reported, DRY_RUN_<ACTION> (e.g.,
+// DRY_RUN_REMEDIATE_READ_ONLY) overrides DRY_RUN for a single action.
+func DryRun(action AutomatedAction) bool {
+	value := os.Getenv("DRY_RUN_" + strings.ToUpper(strings.Replace(string(action), "-", "_", -1)))
+	if value == "" {
+		value = os.Getenv("DRY_RUN")
+	}
+	return value == "true"
+}
+
+// ReportDryRun logs and records what an action would have done to an instance.
+func ReportDryRun(storage Storage, action AutomatedAction, instanceId string, description string) {
+	glog.Infof("Dry run (%s) for %s: %s\n", action, instanceId, description)
+	if err := storage.AddDryRunReport(&DryRunReport{InstanceId: instanceId, Action: action, Description: description}); err != nil {
+		glog.Errorf("Unable to record the dry run of %s for %s: %s\n", action, instanceId, err.Error())
+	}
+}
+
+// GET /v2/service_instances/{instance_id}/actions/dry-run lists what automated actions
+// would have done to an instance while in dry-run mode.
+func (b *BusinessLogic) DryRunAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	if _, err := b.storage.GetInstance(InstanceID); err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Error finding instance id (during dry run): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	reports, err := b.storage.GetDryRunReports(InstanceID)
+	if err != nil {
+		glog.Errorf("Unable to get dry run reports for %s: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	return reports, nil
+}
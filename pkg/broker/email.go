@@ -0,0 +1,151 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+type Contacts struct {
+	Emails []string `json:"emails"`
+}
+
+type InstanceNotification struct {
+	InstanceId string `json:"instance_id"`
+	Name       string `json:"name"`
+	Owner      string `json:"owner"`
+	Plan       string `json:"plan"`
+}
+
+type UpgradeRequired struct {
+	InstanceNotification
+	Support EngineSupportStatus `json:"engine_support"`
+}
+
+func newInstanceNotification(instance *Instance) InstanceNotification {
+	return InstanceNotification{InstanceId: instance.Id, Name: instance.Name, Owner: instance.Owner, Plan: instance.Plan.ID}
+}
+
+// ValidateContacts normalizes a list of contact emails, e.g., from the provision
+// parameters {"contacts":["team@example.com"]}.
+func ValidateContacts(emails []string) ([]string, error) {
+	valid := make([]string, 0)
+	for _, email := range emails {
+		address, err := mail.ParseAddress(strings.TrimSpace(email))
+		if err != nil {
+			return nil, errors.New("The contact " + email + " is not a valid email address.")
+		}
+		valid = append(valid, address.Address)
+	}
+	return valid, nil
+}
+
+// ParseContactParameters reads the contacts from provision parameters, nil if there are none.
+func ParseContactParameters(parameters map[string]interface{}) ([]string, error) {
+	if parameters == nil || parameters["contacts"] == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(parameters["contacts"])
+	if err != nil {
+		return nil, err
+	}
+	var emails []string
+	if err = json.Unmarshal(data, &emails); err != nil {
+		return nil, errors.New("The contacts must be a list of email addresses.")
+	}
+	return ValidateContacts(emails)
+}
+
+func smtpConfigured() bool {
+	return os.Getenv("SMTP_HOST") != "" && os.Getenv("SMTP_FROM") != ""
+}
+
+// SendEmail renders the email templates of the event and sends it to each address
+// through SMTP_HOST, it does nothing unless smtp is configured.
+func SendEmail(storage Storage, event NotificationEvent, to []string, data interface{}) error {
+	if !smtpConfigured() || len(to) == 0 {
+		return nil
+	}
+	subject, err := RenderNotification(storage, event, EmailSubjectChannel, data)
+	if err != nil {
+		return err
+	}
+	body, err := RenderNotification(storage, event, EmailChannel, data)
+	if err != nil {
+		return err
+	}
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	var auth smtp.Auth
+	if os.Getenv("SMTP_USERNAME") != "" {
+		auth = smtp.PlainAuth("", os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), os.Getenv("SMTP_HOST"))
+	}
+	var msg bytes.Buffer
+	msg.WriteString("From: " + os.Getenv("SMTP_FROM") + "\r\n")
+	msg.WriteString("To: " + strings.Join(to, ", ") + "\r\n")
+	msg.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject)) + "\r\n")
+	msg.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+	return smtp.SendMail(os.Getenv("SMTP_HOST")+":"+port, auth, os.Getenv("SMTP_FROM"), to, msg.Bytes())
+}
+
+// EmailContacts emails the contacts of an instance, if it has any.
+func EmailContacts(storage Storage, event NotificationEvent, instanceId string, data interface{}) error {
+	if !smtpConfigured() {
+		return nil
+	}
+	contacts, err := storage.GetContacts(instanceId)
+	if err != nil {
+		return err
+	}
+	return SendEmail(storage, event, contacts, data)
+}
+
+func (b *BusinessLogic) GetContactsAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	contacts, err := b.storage.GetContacts(InstanceID)
+	if err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Unable to get contacts for %s: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	return Contacts{Emails: contacts}, nil
+}
+
+// PUT /v2/service_instances/{instance_id}/actions/contacts with {"emails":["team@example.com"]}
+func (b *BusinessLogic) SetContactsAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	var request Contacts
+	if c == nil || c.Request == nil || c.Request.Body == nil {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "The body must be {\"emails\":[...]}.")
+	}
+	if err := json.NewDecoder(c.Request.Body).Decode(&request); err != nil {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "The body must be {\"emails\":[...]}.")
+	}
+	emails, err := ValidateContacts(request.Emails)
+	if err != nil {
+		return nil, UnprocessableEntityWithMessage("InvalidContacts", err.Error())
+	}
+	err = b.storage.SetContacts(InstanceID, emails)
+	if err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Unable to set contacts for %s: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	b.republishAnnotations(InstanceID)
+	return Contacts{Emails: emails}, nil
+}
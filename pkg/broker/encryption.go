@@ -0,0 +1,213 @@
+package broker
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+// Credentials (and plan details) stored by the broker are encrypted with AES-256-GCM
+// under a key encryption key from CREDENTIALS_KEYS, "{id}:{key}[,{id}:{key}...]". The
+// first key encrypts and every key can decrypt, so keys can be rotated by adding a new
+// key first and running rotate-credentials. A key is 32 base64 encoded bytes, or kms:
+// followed by the base64 KMS ciphertext of them, which is decrypted with KMS once.
+//
+// Encrypted values are stored as enc:{id}:{base64 nonce and ciphertext}, values without
+// the prefix are treated as plain text so existing databases keep working until rotated.
+
+const encryptedPrefix = "enc:"
+
+type credentialKeyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+var credentialKeys *credentialKeyring
+var credentialKeysErr error
+var credentialKeysOnce sync.Once
+
+func decodeCredentialKey(value string) ([]byte, error) {
+	if strings.HasPrefix(value, "kms:") {
+		blob, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, "kms:"))
+		if err != nil {
+			return nil, err
+		}
+		svc := kms.New(awsSession())
+		res, err := svc.Decrypt(&kms.DecryptInput{CiphertextBlob: blob})
+		if err != nil {
+			return nil, err
+		}
+		return res.Plaintext, nil
+	}
+	return base64.StdEncoding.DecodeString(value)
+}
+
+func loadCredentialKeys() (*credentialKeyring, error) {
+	credentialKeysOnce.Do(func() {
+		if os.Getenv("CREDENTIALS_KEYS") == "" {
+			return
+		}
+		keyring := credentialKeyring{keys: make(map[string]cipher.AEAD)}
+		for _, entry := range strings.Split(os.Getenv("CREDENTIALS_KEYS"), ",") {
+			parts := strings.SplitN(strings.TrimSpace(entry), ":", 2)
+			if len(parts) != 2 || parts[0] == "" {
+				credentialKeysErr = errors.New("CREDENTIALS_KEYS must be {id}:{key}[,{id}:{key}...]")
+				return
+			}
+			key, err := decodeCredentialKey(parts[1])
+			if err != nil {
+				credentialKeysErr = errors.New("Unable to decode the credentials key " + parts[0] + ": " + err.Error())
+				return
+			}
+			if len(key) != 32 {
+				credentialKeysErr = errors.New("The credentials key " + parts[0] + " must be 32 bytes")
+				return
+			}
+			block, err := aes.NewCipher(key)
+			if err != nil {
+				credentialKeysErr = err
+				return
+			}
+			aead, err := cipher.NewGCM(block)
+			if err != nil {
+				credentialKeysErr = err
+				return
+			}
+			if keyring.current == "" {
+				keyring.current = parts[0]
+			}
+			keyring.keys[parts[0]] = aead
+		}
+		credentialKeys = &keyring
+	})
+	return credentialKeys, credentialKeysErr
+}
+
+// EncryptCredential encrypts the value with the current key, it is returned as is if no
+// keys are configured.
+func EncryptCredential(value string) (string, error) {
+	keyring, err := loadCredentialKeys()
+	if err != nil {
+		return "", err
+	}
+	if keyring == nil || value == "" {
+		return value, nil
+	}
+	aead := keyring.keys[keyring.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(keyring.current))
+	return encryptedPrefix + keyring.current + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptCredential decrypts a value from EncryptCredential, plain text values are
+// returned as is.
+func DecryptCredential(value string) (string, error) {
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		return value, nil
+	}
+	parts := strings.SplitN(strings.TrimPrefix(value, encryptedPrefix), ":", 2)
+	if len(parts) != 2 {
+		return "", errors.New("The encrypted value is malformed")
+	}
+	keyring, err := loadCredentialKeys()
+	if err != nil {
+		return "", err
+	}
+	if keyring == nil || keyring.keys[parts[0]] == nil {
+		return "", errors.New("The credentials key " + parts[0] + " is not in CREDENTIALS_KEYS")
+	}
+	aead := keyring.keys[parts[0]]
+	sealed, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("The encrypted value is malformed")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(parts[0]))
+	if err != nil {
+		return "", errors.New("Unable to decrypt a value with the credentials key " + parts[0] + ": " + err.Error())
+	}
+	return string(plain), nil
+}
+
+// needsRotation is true for plain text values and values encrypted with an old key.
+func needsRotation(value string) bool {
+	keyring, err := loadCredentialKeys()
+	if err != nil || keyring == nil || value == "" {
+		return false
+	}
+	return !strings.HasPrefix(value, encryptedPrefix+keyring.current+":")
+}
+
+// decryptPlanDetails decrypts plan details stored as a json string of an encrypted value,
+// e.g., "enc:2021:...", other details are returned as is.
+func decryptPlanDetails(details string) (string, error) {
+	if !strings.HasPrefix(details, "\""+encryptedPrefix) {
+		return details, nil
+	}
+	var value string
+	if err := json.Unmarshal([]byte(details), &value); err != nil {
+		return "", err
+	}
+	return DecryptCredential(value)
+}
+
+// RunEncryptValue prints the encrypted value of stdin, e.g., to store plan details with
+// update plans set provider_private_details = to_json('{output}'::text).
+func RunEncryptValue() error {
+	data, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+	if keyring, err := loadCredentialKeys(); err != nil {
+		return err
+	} else if keyring == nil {
+		return errors.New("CREDENTIALS_KEYS must be set to encrypt values")
+	}
+	encrypted, err := EncryptCredential(strings.TrimSpace(string(data)))
+	if err != nil {
+		return err
+	}
+	fmt.Println(encrypted)
+	return nil
+}
+
+// RunRotateCredentials re-encrypts every stored credential and encrypted plan with the
+// current key, plain text credentials are encrypted. Old keys can be removed afterwards.
+func RunRotateCredentials(ctx context.Context, o Options) error {
+	if keyring, err := loadCredentialKeys(); err != nil {
+		return err
+	} else if keyring == nil {
+		return errors.New("CREDENTIALS_KEYS must be set to rotate credentials")
+	}
+	storage, err := InitStorage(ctx, o)
+	if err != nil {
+		return err
+	}
+	resources, plans, err := storage.RotateCredentials()
+	if err != nil {
+		return err
+	}
+	bindings, err := storage.RotateBindingCredentials()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Re-encrypted the credentials of %d instances and %d bindings and the details of %d plans\n", resources, bindings, plans)
+	return nil
+}
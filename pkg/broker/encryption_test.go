@@ -0,0 +1,136 @@
+package broker
+
+import (
+	"encoding/base64"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func credentialKey(b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string([]byte{b}), 32)))
+}
+
+// useCredentialKeys sets CREDENTIALS_KEYS and forgets the keys loaded before.
+func useCredentialKeys(keys string) {
+	if keys == "" {
+		os.Unsetenv("CREDENTIALS_KEYS")
+	} else {
+		os.Setenv("CREDENTIALS_KEYS", keys)
+	}
+	credentialKeys = nil
+	credentialKeysErr = nil
+	credentialKeysOnce = sync.Once{}
+}
+
+func TestCredentialEncryptionRoundTrip(t *testing.T) {
+	defer useCredentialKeys("")
+	useCredentialKeys("2021:" + credentialKey('a'))
+	encrypted, err := EncryptCredential("hunter2")
+	if err != nil {
+		t.Fatalf("EncryptCredential() failed: %s", err.Error())
+	}
+	if !strings.HasPrefix(encrypted, "enc:2021:") || strings.Contains(encrypted, "hunter2") {
+		t.Errorf("EncryptCredential() = %q, want it encrypted with the key 2021", encrypted)
+	}
+	if again, _ := EncryptCredential("hunter2"); again == encrypted {
+		t.Errorf("EncryptCredential() reused a nonce")
+	}
+	decrypted, err := DecryptCredential(encrypted)
+	if err != nil || decrypted != "hunter2" {
+		t.Errorf("DecryptCredential() = %q, %v, want hunter2", decrypted, err)
+	}
+	if needsRotation(encrypted) {
+		t.Errorf("needsRotation() of a value encrypted with the current key is true")
+	}
+	if empty, err := EncryptCredential(""); err != nil || empty != "" {
+		t.Errorf("EncryptCredential() of an empty value = %q, %v, want it empty", empty, err)
+	}
+}
+
+func TestCredentialEncryptionRotatedKey(t *testing.T) {
+	defer useCredentialKeys("")
+	useCredentialKeys("2020:" + credentialKey('a'))
+	old, err := EncryptCredential("hunter2")
+	if err != nil {
+		t.Fatalf("EncryptCredential() failed: %s", err.Error())
+	}
+	useCredentialKeys("2021:" + credentialKey('b') + ", 2020:" + credentialKey('a'))
+	decrypted, err := DecryptCredential(old)
+	if err != nil || decrypted != "hunter2" {
+		t.Errorf("DecryptCredential() with a key that is no longer current = %q, %v, want hunter2", decrypted, err)
+	}
+	if !needsRotation(old) {
+		t.Errorf("needsRotation() of a value encrypted with an old key is false")
+	}
+	rotated, err := EncryptCredential(decrypted)
+	if err != nil || !strings.HasPrefix(rotated, "enc:2021:") {
+		t.Errorf("EncryptCredential() = %q, %v, want it encrypted with the current key 2021", rotated, err)
+	}
+}
+
+func TestCredentialEncryptionPlainText(t *testing.T) {
+	defer useCredentialKeys("")
+	useCredentialKeys("")
+	if value, err := EncryptCredential("hunter2"); err != nil || value != "hunter2" {
+		t.Errorf("EncryptCredential() without keys = %q, %v, want it as is", value, err)
+	}
+	if value, err := DecryptCredential("hunter2"); err != nil || value != "hunter2" {
+		t.Errorf("DecryptCredential() of plain text without keys = %q, %v, want it as is", value, err)
+	}
+	useCredentialKeys("2021:" + credentialKey('a'))
+	if value, err := DecryptCredential("hunter2"); err != nil || value != "hunter2" {
+		t.Errorf("DecryptCredential() of plain text = %q, %v, want it as is", value, err)
+	}
+	if !needsRotation("hunter2") {
+		t.Errorf("needsRotation() of plain text is false")
+	}
+}
+
+func TestCredentialEncryptionMalformed(t *testing.T) {
+	defer useCredentialKeys("")
+	useCredentialKeys("2021:" + credentialKey('a'))
+	encrypted, err := EncryptCredential("hunter2")
+	if err != nil {
+		t.Fatalf("EncryptCredential() failed: %s", err.Error())
+	}
+	sealed, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(encrypted, "enc:2021:"))
+	sealed[len(sealed)-1] ^= 1
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"no key id", "enc:" + strings.TrimPrefix(encrypted, "enc:2021:")},
+		{"not base64", "enc:2021:not base64!"},
+		{"shorter than the nonce", "enc:2021:" + base64.StdEncoding.EncodeToString([]byte("short"))},
+		{"tampered", "enc:2021:" + base64.StdEncoding.EncodeToString(sealed)},
+		{"another key id", strings.Replace(encrypted, "enc:2021:", "enc:2020:", 1)},
+	}
+	for _, test := range tests {
+		if value, err := DecryptCredential(test.value); err == nil {
+			t.Errorf("%s: DecryptCredential() = %q, want an error", test.name, value)
+		}
+	}
+}
+
+func TestCredentialEncryptionUnknownKey(t *testing.T) {
+	defer useCredentialKeys("")
+	useCredentialKeys("2020:" + credentialKey('a'))
+	encrypted, err := EncryptCredential("hunter2")
+	if err != nil {
+		t.Fatalf("EncryptCredential() failed: %s", err.Error())
+	}
+	useCredentialKeys("2021:" + credentialKey('b'))
+	if _, err = DecryptCredential(encrypted); err == nil || !strings.Contains(err.Error(), "2020 is not in CREDENTIALS_KEYS") {
+		t.Errorf("DecryptCredential() with a removed key = %v, want an error naming the key", err)
+	}
+	useCredentialKeys("")
+	if _, err = DecryptCredential(encrypted); err == nil {
+		t.Errorf("DecryptCredential() without keys did not fail")
+	}
+	useCredentialKeys("2021:" + base64.StdEncoding.EncodeToString([]byte("short")))
+	if _, err = EncryptCredential("hunter2"); err == nil {
+		t.Errorf("EncryptCredential() with a key that is not 32 bytes did not fail")
+	}
+}
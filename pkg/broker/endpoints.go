@@ -0,0 +1,77 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/json"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/service/elasticsearchservice"
+)
+
+// EndpointOptions are the parts of a plan that change which endpoint an instance is
+// reached at, they're read from the plans provider private details.
+type EndpointOptions struct {
+	IPAddressType         string `json:"IPAddressType"`
+	DomainEndpointOptions struct {
+		CustomEndpointEnabled        bool   `json:"CustomEndpointEnabled"`
+		CustomEndpoint               string `json:"CustomEndpoint"`
+		CustomEndpointCertificateArn string `json:"CustomEndpointCertificateArn"`
+	} `json:"DomainEndpointOptions"`
+}
+
+// EndpointOptions returns the endpoint settings of the plan for the named instance, the
+// custom endpoint may use the {{.InstanceName}} template variable.
+func (plan *ProviderPlan) EndpointOptions(name string) EndpointOptions {
+	var options EndpointOptions
+	if err := json.Unmarshal([]byte(plan.providerPrivateDetails), &options); err != nil {
+		return EndpointOptions{}
+	}
+	if options.DomainEndpointOptions.CustomEndpoint != "" {
+		t, err := template.New("endpoint").Parse(options.DomainEndpointOptions.CustomEndpoint)
+		if err == nil {
+			var b bytes.Buffer
+			if err = t.Execute(&b, NewPlanTemplateData("", name, "")); err == nil {
+				options.DomainEndpointOptions.CustomEndpoint = b.String()
+			}
+		}
+	}
+	return options
+}
+
+func (o EndpointOptions) CustomEndpoint() string {
+	if o.DomainEndpointOptions.CustomEndpointEnabled {
+		return o.DomainEndpointOptions.CustomEndpoint
+	}
+	return ""
+}
+
+// ResolveEndpoint returns the hostname an instance should be reached at regardless of how
+// the domain was created (or whether it's elasticsearch or opensearch). In order of preference
+// a custom endpoint, the dual-stack (vpcv2) endpoint for dual-stack plans, the vpc endpoint, any
+// other published endpoint and lastly the public endpoint of domains outside of a vpc.
+func ResolveEndpoint(status *elasticsearchservice.ElasticsearchDomainStatus, options EndpointOptions) string {
+	if status == nil {
+		return ""
+	}
+	if custom := options.CustomEndpoint(); custom != "" {
+		return custom
+	}
+	keys := []string{"vpc", "vpcv2"}
+	if options.IPAddressType == "dualstack" {
+		keys = []string{"vpcv2", "vpc"}
+	}
+	for _, key := range keys {
+		if endpoint, ok := status.Endpoints[key]; ok && endpoint != nil && *endpoint != "" {
+			return *endpoint
+		}
+	}
+	for _, endpoint := range status.Endpoints {
+		if endpoint != nil && *endpoint != "" {
+			return *endpoint
+		}
+	}
+	if status.Endpoint != nil {
+		return *status.Endpoint
+	}
+	return ""
+}
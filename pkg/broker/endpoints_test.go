@@ -0,0 +1,61 @@
+package broker
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elasticsearchservice"
+)
+
+func customEndpointOptions(enabled bool, endpoint string) EndpointOptions {
+	var options EndpointOptions
+	options.DomainEndpointOptions.CustomEndpointEnabled = enabled
+	options.DomainEndpointOptions.CustomEndpoint = endpoint
+	return options
+}
+
+func TestResolveEndpoint(t *testing.T) {
+	public := &elasticsearchservice.ElasticsearchDomainStatus{Endpoint: aws.String("search-public.us-west-2.es.amazonaws.com")}
+	vpc := &elasticsearchservice.ElasticsearchDomainStatus{Endpoints: map[string]*string{"vpc": aws.String("vpc-ipv4.us-west-2.es.amazonaws.com")}}
+	dualStack := &elasticsearchservice.ElasticsearchDomainStatus{Endpoints: map[string]*string{
+		"vpc":   aws.String("vpc-ipv4.us-west-2.es.amazonaws.com"),
+		"vpcv2": aws.String("vpc-dualstack.us-west-2.on.aws"),
+	}}
+	tests := []struct {
+		name    string
+		status  *elasticsearchservice.ElasticsearchDomainStatus
+		options EndpointOptions
+		want    string
+	}{
+		{"public", public, EndpointOptions{}, "search-public.us-west-2.es.amazonaws.com"},
+		{"vpc", vpc, EndpointOptions{}, "vpc-ipv4.us-west-2.es.amazonaws.com"},
+		{"vpc preferred over vpcv2 for ipv4 plans", dualStack, EndpointOptions{IPAddressType: "ipv4"}, "vpc-ipv4.us-west-2.es.amazonaws.com"},
+		{"dual-stack", dualStack, EndpointOptions{IPAddressType: "dualstack"}, "vpc-dualstack.us-west-2.on.aws"},
+		{"dual-stack plan without a vpcv2 endpoint", vpc, EndpointOptions{IPAddressType: "dualstack"}, "vpc-ipv4.us-west-2.es.amazonaws.com"},
+		{"other published endpoint", &elasticsearchservice.ElasticsearchDomainStatus{Endpoints: map[string]*string{"other": aws.String("other.example.com")}}, EndpointOptions{}, "other.example.com"},
+		{"empty vpc endpoint", &elasticsearchservice.ElasticsearchDomainStatus{Endpoint: aws.String("search-public.us-west-2.es.amazonaws.com"), Endpoints: map[string]*string{"vpc": aws.String(""), "vpcv2": nil}}, EndpointOptions{}, "search-public.us-west-2.es.amazonaws.com"},
+		{"custom endpoint", vpc, customEndpointOptions(true, "search.example.com"), "search.example.com"},
+		{"disabled custom endpoint", vpc, customEndpointOptions(false, "search.example.com"), "vpc-ipv4.us-west-2.es.amazonaws.com"},
+		{"nil status", nil, customEndpointOptions(true, "search.example.com"), ""},
+		{"empty status", &elasticsearchservice.ElasticsearchDomainStatus{}, EndpointOptions{}, ""},
+	}
+	for _, test := range tests {
+		if got := ResolveEndpoint(test.status, test.options); got != test.want {
+			t.Errorf("%s: ResolveEndpoint() = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPlanEndpointOptions(t *testing.T) {
+	plan := &ProviderPlan{providerPrivateDetails: `{"IPAddressType":"dualstack","DomainEndpointOptions":{"CustomEndpointEnabled":true,"CustomEndpoint":"{{.InstanceName}}.search.example.com"}}`}
+	options := plan.EndpointOptions("logs")
+	if options.IPAddressType != "dualstack" {
+		t.Errorf("IPAddressType = %q, want dualstack", options.IPAddressType)
+	}
+	if options.CustomEndpoint() != "logs.search.example.com" {
+		t.Errorf("CustomEndpoint() = %q, want logs.search.example.com", options.CustomEndpoint())
+	}
+	if (&ProviderPlan{providerPrivateDetails: "not json"}).EndpointOptions("logs").CustomEndpoint() != "" {
+		t.Errorf("EndpointOptions of invalid provider private details has a custom endpoint")
+	}
+}
@@ -0,0 +1,146 @@
+package broker
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	EngineSupported      = "supported"
+	EngineApproachingEOL = "approaching-eol"
+	EngineEOL            = "eol"
+	EngineSupportUnknown = "unknown"
+)
+
+// EngineSupport is a row of the engine_versions table, the support window of an engine
+// version. Versions without an end of support are supported indefinitely.
+type EngineSupport struct {
+	Engine       string     `json:"engine"`
+	Version      string     `json:"version"`
+	EndOfSupport *time.Time `json:"end_of_support,omitempty"`
+	UpgradeTo    string     `json:"upgrade_to,omitempty"`
+}
+
+type EngineSupportStatus struct {
+	EngineSupport
+	Status        string `json:"status"`
+	DaysRemaining *int   `json:"days_remaining,omitempty"`
+}
+
+type EOLWarning struct {
+	Owner     string                 `json:"owner"`
+	Generated time.Time              `json:"generated"`
+	Instances []InstanceEngineStatus `json:"instances"`
+}
+
+type InstanceEngineStatus struct {
+	InstanceId string              `json:"instance_id"`
+	Name       string              `json:"name"`
+	Plan       string              `json:"plan"`
+	Support    EngineSupportStatus `json:"engine_support"`
+}
+
+func eolWarningDays() int {
+	return envInt("EOL_WARNING_DAYS", 180)
+}
+
+// GetEngineSupportStatus returns whether an engine version is supported, approaching
+// its end of support (within EOL_WARNING_DAYS) or past it. Versions not in the
+// engine_versions table are unknown.
+func GetEngineSupportStatus(supports []EngineSupport, engine string, version string, now time.Time) EngineSupportStatus {
+	for _, support := range supports {
+		if support.Engine != engine || support.Version != version {
+			continue
+		}
+		status := EngineSupportStatus{EngineSupport: support, Status: EngineSupported}
+		if support.EndOfSupport == nil {
+			return status
+		}
+		days := int(support.EndOfSupport.Sub(now).Hours() / 24)
+		status.DaysRemaining = &days
+		if !support.EndOfSupport.After(now) {
+			status.Status = EngineEOL
+		} else if days <= eolWarningDays() {
+			status.Status = EngineApproachingEOL
+		}
+		return status
+	}
+	return EngineSupportStatus{EngineSupport: EngineSupport{Engine: engine, Version: version}, Status: EngineSupportUnknown}
+}
+
+// Description is used as the maintenance_info description of an instance.
+func (s EngineSupportStatus) Description() string {
+	upgrade := ""
+	if s.UpgradeTo != "" {
+		upgrade = ", upgrade to " + s.UpgradeTo
+	}
+	switch s.Status {
+	case EngineEOL:
+		return s.Engine + " " + s.Version + " reached its end of support on " + s.EndOfSupport.Format("2006-01-02") + upgrade + "."
+	case EngineApproachingEOL:
+		return s.Engine + " " + s.Version + " reaches its end of support on " + s.EndOfSupport.Format("2006-01-02") + " (" + strconv.Itoa(*s.DaysRemaining) + " days)" + upgrade + "."
+	case EngineSupported:
+		return s.Engine + " " + s.Version + " is supported."
+	}
+	return "The support window of " + s.Engine + " " + s.Version + " is unknown."
+}
+
+// SendEOLWarnings posts each owner their instances running engine versions that are
+// approaching or past their end of support to EOL_WEBHOOK, and emails each instances
+// contacts that it must be upgraded.
+func SendEOLWarnings(namePrefix string, storage Storage) {
+	url := os.Getenv("EOL_WEBHOOK")
+	if url == "" && !smtpConfigured() {
+		return
+	}
+	supports, err := storage.GetEngineSupports()
+	if err != nil {
+		glog.Errorf("Unable to send end of support warnings, cannot get engine versions: %s\n", err.Error())
+		return
+	}
+	entries, err := storage.GetInstances()
+	if err != nil {
+		glog.Errorf("Unable to send end of support warnings, cannot get instances: %s\n", err.Error())
+		return
+	}
+	now := time.Now()
+	warnings := make(map[string]*EOLWarning)
+	owners := make([]string, 0)
+	for _, entry := range entries {
+		if !IsAvailable(entry.Status) {
+			continue
+		}
+		instance, err := GetInstanceById(namePrefix, storage, entry.Id)
+		if err != nil {
+			glog.Errorf("Unable to check the engine support of %s: %s\n", entry.Name, err.Error())
+			continue
+		}
+		support := GetEngineSupportStatus(supports, instance.Engine, instance.EngineVersion, now)
+		if support.Status != EngineApproachingEOL && support.Status != EngineEOL {
+			continue
+		}
+		glog.Infof("Warning: %s (owned by %s): %s\n", instance.Name, entry.Owner, support.Description())
+		if err = EmailContacts(storage, UpgradeRequiredNotification, instance.Id, UpgradeRequired{InstanceNotification: newInstanceNotification(instance), Support: support}); err != nil {
+			glog.Errorf("Unable to email the contacts of %s: %s\n", instance.Name, err.Error())
+		}
+		if entry.Owner == "" {
+			continue
+		}
+		if _, ok := warnings[entry.Owner]; !ok {
+			warnings[entry.Owner] = &EOLWarning{Owner: entry.Owner, Generated: now, Instances: make([]InstanceEngineStatus, 0)}
+			owners = append(owners, entry.Owner)
+		}
+		warnings[entry.Owner].Instances = append(warnings[entry.Owner].Instances, InstanceEngineStatus{InstanceId: instance.Id, Name: instance.Name, Plan: instance.Plan.ID, Support: support})
+	}
+	if url == "" {
+		return
+	}
+	for _, owner := range owners {
+		if err := Notify(storage, EOLWarningNotification, url, os.Getenv("EOL_WEBHOOK_SECRET"), warnings[owner]); err != nil {
+			glog.Errorf("Unable to send the end of support warning for %s: %s\n", owner, err.Error())
+		}
+	}
+}
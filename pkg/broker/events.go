@@ -0,0 +1,215 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type EventType string
+
+const (
+	PreprovisioningEvent EventType = "preprovisioning"
+	ProvisionedEvent     EventType = "provisioned"
+	ClaimedEvent         EventType = "claimed"
+	ReturnedEvent        EventType = "returned"
+	UpdatedEvent         EventType = "updated"
+	OwnerChangedEvent    EventType = "owner-changed"
+	DeprovisionedEvent   EventType = "deprovisioned"
+	NukedEvent           EventType = "nuked"
+	FrozenEvent          EventType = "frozen"
+	UnfrozenEvent        EventType = "unfrozen"
+	LegalHoldEvent       EventType = "legal-hold"
+	LegalHoldLiftedEvent EventType = "legal-hold-lifted"
+	SharedEvent          EventType = "shared"
+	UnsharedEvent        EventType = "unshared"
+	ReleasedEvent        EventType = "released"
+	// The credentials of a binding were deactivated (or reactivated) by an operator.
+	BindingDeactivatedEvent EventType = "binding-deactivated"
+	BindingReactivatedEvent EventType = "binding-reactivated"
+	// The state of an instance created before its changes were recorded, it starts the
+	// stream so the state projected from it matches the instance.
+	SnapshotEvent EventType = "snapshot"
+)
+
+// projected is true if the event changes the state projected from the stream (the fields
+// of InstanceProjection).
+func (t EventType) projected() bool {
+	switch t {
+	case SnapshotEvent, PreprovisioningEvent, ProvisionedEvent, ClaimedEvent, ReturnedEvent, UpdatedEvent, OwnerChangedEvent, DeprovisionedEvent, NukedEvent:
+		return true
+	}
+	return false
+}
+
+// EventData holds only the fields that changed, credentials are intentionally
+// never recorded in the event stream.
+type EventData struct {
+	Name     *string `json:"name,omitempty"`
+	Plan     *string `json:"plan,omitempty"`
+	Status   *string `json:"status,omitempty"`
+	Endpoint *string `json:"endpoint,omitempty"`
+	Owner    *string `json:"owner,omitempty"`
+	Claimed  *bool   `json:"claimed,omitempty"`
+	// Only set by snapshots of instances that were already deleted.
+	Deleted *bool `json:"deleted,omitempty"`
+	// The preprovisioned resource an instance was claimed from.
+	From string `json:"from,omitempty"`
+	// Who froze (or unfroze) an instance, placed (or lifted) a legal hold or deactivated (or
+	// reactivated) a binding and why.
+	Actor  string `json:"actor,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	// The space an instance was shared with or unshared from.
+	Space string `json:"space,omitempty"`
+	// The binding that was deactivated or reactivated.
+	Binding string `json:"binding,omitempty"`
+}
+
+// Events are an append-only log of every change to a resource, they are written by the
+// storage layer in the transaction of the change so every code path that changes an
+// instance is recorded. The name, plan, status, endpoint, owner, claimed and deleted
+// columns of resources are not written directly, they are projected from the stream
+// (see recordEvent).
+type Event struct {
+	Id         string    `json:"id"`
+	ResourceId string    `json:"resource"`
+	Type       EventType `json:"type"`
+	Data       EventData `json:"data"`
+	Created    time.Time `json:"created"`
+}
+
+type InstanceProjection struct {
+	Entry
+	Deleted bool
+	Events  int
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+// Returns the fields that differ between the stored entry and the instance.
+func diffEventData(entry *Entry, instance *Instance, planId string) (EventData, bool) {
+	var data EventData
+	changed := false
+	if entry.Name != instance.Name {
+		data.Name = stringPtr(instance.Name)
+		changed = true
+	}
+	if entry.PlanId != planId {
+		data.Plan = stringPtr(planId)
+		changed = true
+	}
+	if entry.Status != instance.Status {
+		data.Status = stringPtr(instance.Status)
+		changed = true
+	}
+	if entry.Endpoint != instance.Endpoint {
+		data.Endpoint = stringPtr(instance.Endpoint)
+		changed = true
+	}
+	return data, changed
+}
+
+func instanceEventData(instance *Instance, planId string, claimed bool) EventData {
+	return EventData{
+		Name:     stringPtr(instance.Name),
+		Plan:     stringPtr(planId),
+		Status:   stringPtr(instance.Status),
+		Endpoint: stringPtr(instance.Endpoint),
+		Owner:    stringPtr(instance.Owner),
+		Claimed:  &claimed,
+	}
+}
+
+// ProjectEvents folds the event stream of a resource into its current state, this is how
+// the state of an instance is built when an event is recorded and when it is replayed.
+func ProjectEvents(Id string, events []Event) (*InstanceProjection, error) {
+	if len(events) == 0 {
+		return nil, errors.New("No events were found for " + Id)
+	}
+	projection := InstanceProjection{Entry: Entry{Id: Id}}
+	for _, event := range events {
+		if event.Data.Name != nil {
+			projection.Name = *event.Data.Name
+		}
+		if event.Data.Plan != nil {
+			projection.PlanId = *event.Data.Plan
+		}
+		if event.Data.Status != nil {
+			projection.Status = *event.Data.Status
+		}
+		if event.Data.Endpoint != nil {
+			projection.Endpoint = *event.Data.Endpoint
+		}
+		if event.Data.Owner != nil {
+			projection.Owner = *event.Data.Owner
+		}
+		if event.Data.Claimed != nil {
+			projection.Claimed = *event.Data.Claimed
+		}
+		if event.Data.Deleted != nil {
+			projection.Deleted = *event.Data.Deleted
+		}
+		if event.Type == DeprovisionedEvent || event.Type == NukedEvent || event.Type == ReturnedEvent {
+			projection.Deleted = true
+		} else if event.Type == ProvisionedEvent || event.Type == ClaimedEvent {
+			projection.Deleted = false
+		}
+		projection.Events++
+	}
+	return &projection, nil
+}
+
+// RunReplay prints the event stream for an instance and the state derived from it, if
+// repair is true the resources table is updated to match the derived state, e.g., after
+// a manual change to the database.
+func RunReplay(ctx context.Context, o Options, Id string, repair bool) error {
+	if Id == "" {
+		return errors.New("An instance id must be provided, e.g. replay {instance_id} [repair]")
+	}
+	storage, err := InitStorage(ctx, o)
+	if err != nil {
+		return err
+	}
+	events, err := storage.GetEvents(Id)
+	if err != nil {
+		return err
+	}
+	for _, event := range events {
+		data, err := json.Marshal(event.Data)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s  %-16s %s\n", event.Created.Format(time.RFC3339), event.Type, string(data))
+	}
+	projection, err := ProjectEvents(Id, events)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nDerived state from %d events:\n", projection.Events)
+	fmt.Printf("  name: %s\n  plan: %s\n  status: %s\n  endpoint: %s\n  owner: %s\n  claimed: %t\n  deleted: %t\n", projection.Name, projection.PlanId, projection.Status, projection.Endpoint, projection.Owner, projection.Claimed, projection.Deleted)
+
+	entry, err := storage.GetInstance(Id)
+	if err != nil && err.Error() != "Cannot find resource instance" {
+		return err
+	}
+	if entry == nil {
+		fmt.Printf("\nThe resources table has no (undeleted) record of this instance.\n")
+	} else if entry.Name != projection.Name || entry.PlanId != projection.PlanId || entry.Status != projection.Status || entry.Endpoint != projection.Endpoint || entry.Owner != projection.Owner || entry.Claimed != projection.Claimed || projection.Deleted {
+		fmt.Printf("\nThe resources table differs from the derived state:\n")
+		fmt.Printf("  name: %s\n  plan: %s\n  status: %s\n  endpoint: %s\n  owner: %s\n  claimed: %t\n", entry.Name, entry.PlanId, entry.Status, entry.Endpoint, entry.Owner, entry.Claimed)
+	} else {
+		fmt.Printf("\nThe resources table matches the derived state.\n")
+		return nil
+	}
+	if repair {
+		if err = storage.RepairInstance(projection); err != nil {
+			return err
+		}
+		fmt.Printf("Repaired the resources table from the derived state.\n")
+	}
+	return nil
+}
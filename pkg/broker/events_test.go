@@ -0,0 +1,39 @@
+package broker
+
+import (
+	"testing"
+)
+
+func TestProjectEvents(t *testing.T) {
+	deleted := true
+	instance := &Instance{Name: "logs", Status: "creating", Endpoint: "", Owner: "team@example.com"}
+	events := []Event{
+		{Type: ProvisionedEvent, Data: instanceEventData(instance, "plan-1", true)},
+		{Type: UpdatedEvent, Data: EventData{Status: stringPtr("available"), Endpoint: stringPtr("logs.example.com")}},
+		{Type: FrozenEvent, Data: EventData{Actor: "ops", Reason: "INC-42"}},
+		{Type: OwnerChangedEvent, Data: EventData{Owner: stringPtr("other@example.com")}},
+	}
+	projection, err := ProjectEvents("id", events)
+	if err != nil {
+		t.Fatalf("ProjectEvents() failed: %s", err.Error())
+	}
+	want := Entry{Id: "id", Name: "logs", PlanId: "plan-1", Status: "available", Endpoint: "logs.example.com", Owner: "other@example.com", Claimed: true}
+	if projection.Entry != want || projection.Deleted || projection.Events != 4 {
+		t.Errorf("ProjectEvents() = %+v, want %+v from 4 events", projection, want)
+	}
+
+	projection, _ = ProjectEvents("id", append(events, Event{Type: DeprovisionedEvent}))
+	if !projection.Deleted {
+		t.Errorf("ProjectEvents() of a deprovisioned instance is not deleted")
+	}
+
+	snapshot := Event{Type: SnapshotEvent, Data: EventData{Name: stringPtr("old"), Plan: stringPtr("plan-1"), Status: stringPtr("available"), Deleted: &deleted}}
+	projection, _ = ProjectEvents("id", []Event{snapshot})
+	if projection.Name != "old" || !projection.Deleted {
+		t.Errorf("ProjectEvents() of a snapshot of a deleted instance = %+v", projection)
+	}
+
+	if _, err = ProjectEvents("id", nil); err == nil {
+		t.Errorf("ProjectEvents() of no events did not fail")
+	}
+}
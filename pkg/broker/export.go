@@ -0,0 +1,237 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// Owners can register their own S3 bucket as an additional snapshot target (e.g., for
+// data portability or compliance), the domain is snapshotted into it daily. The domains
+// write to the bucket as SNAPSHOT_EXPORT_ROLE_ARN, which the bucket policy must allow.
+
+const exportRepository = "customer-export"
+
+type SnapshotExport struct {
+	InstanceId   string     `json:"instance_id"`
+	Bucket       string     `json:"bucket"`
+	Region       string     `json:"region"`
+	BasePath     string     `json:"base_path,omitempty"`
+	Status       string     `json:"status"`
+	Message      string     `json:"message,omitempty"`
+	LastSnapshot string     `json:"last_snapshot,omitempty"`
+	LastExported *time.Time `json:"last_exported,omitempty"`
+}
+
+type SnapshotExportRequest struct {
+	Bucket   string `json:"bucket"`
+	Region   string `json:"region"`
+	BasePath string `json:"base_path"`
+}
+
+var bucketNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.\-]{1,61}[a-z0-9]$`)
+
+func exportRoleArn() string {
+	return os.Getenv("SNAPSHOT_EXPORT_ROLE_ARN")
+}
+
+// ExportBucketPolicy is the bucket policy the owner must apply so the domains can write
+// snapshots to the bucket (under the base path if there is one).
+func ExportBucketPolicy(bucket string, basePath string) map[string]interface{} {
+	objects := "arn:aws:s3:::" + bucket + "/*"
+	if basePath != "" {
+		objects = "arn:aws:s3:::" + bucket + "/" + strings.Trim(basePath, "/") + "/*"
+	}
+	principal := map[string]string{"AWS": exportRoleArn()}
+	return map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []map[string]interface{}{
+			{
+				"Sid":       "ElasticsearchSnapshotList",
+				"Effect":    "Allow",
+				"Principal": principal,
+				"Action":    []string{"s3:ListBucket", "s3:GetBucketLocation"},
+				"Resource":  "arn:aws:s3:::" + bucket,
+			},
+			{
+				"Sid":       "ElasticsearchSnapshotObjects",
+				"Effect":    "Allow",
+				"Principal": principal,
+				"Action":    []string{"s3:GetObject", "s3:PutObject", "s3:DeleteObject"},
+				"Resource":  objects,
+			},
+		},
+	}
+}
+
+// registerExportRepository registers (or re-registers) the bucket as a repository on the
+// domain, elasticsearch verifies it can write to the bucket before accepting it.
+func registerExportRepository(cluster *ClusterClient, instance *Instance, export *SnapshotExport) error {
+	settings := map[string]interface{}{
+		"bucket":                 export.Bucket,
+		"region":                 export.Region,
+		"role_arn":               exportRoleArn(),
+		"server_side_encryption": true,
+	}
+	if export.BasePath != "" {
+		settings["base_path"] = strings.Trim(export.BasePath, "/")
+	}
+	body, err := json.Marshal(snapshotRepository{Type: "s3", Settings: settings})
+	if err != nil {
+		return err
+	}
+	response, status, err := cluster.Do(instance, "PUT", "/_snapshot/"+exportRepository, body)
+	if err != nil {
+		return err
+	}
+	if status != 200 {
+		return errors.New("The bucket could not be verified (_snapshot returned " + strconv.Itoa(status) + "), check the bucket policy allows " + exportRoleArn() + ": " + string(response))
+	}
+	return nil
+}
+
+// ExportSnapshots starts a snapshot of every instance with an active export into its bucket.
+func ExportSnapshots(namePrefix string, storage Storage, cluster *ClusterClient) error {
+	exports, err := storage.GetSnapshotExports()
+	if err != nil {
+		glog.Errorf("Unable to get the snapshot exports: %s\n", err.Error())
+		return err
+	}
+	for _, export := range exports {
+		if export.Status != "active" {
+			continue
+		}
+		if deleting, err := storage.IsDeleting(export.InstanceId); err != nil || deleting {
+			continue
+		}
+		instance, err := GetInstanceById(namePrefix, storage, export.InstanceId)
+		if err != nil {
+			glog.Errorf("Unable to export a snapshot of %s: %s\n", export.InstanceId, err.Error())
+			continue
+		}
+		if !IsAvailable(instance.Status) {
+			continue
+		}
+		now := time.Now().UTC()
+		name := "export-" + now.Format("20060102-1504")
+		_, status, err := cluster.Do(instance, "PUT", "/_snapshot/"+exportRepository+"/"+name, nil)
+		if err != nil {
+			export.Message = "Unable to start the snapshot " + name + ": " + err.Error()
+		} else if status != 200 {
+			export.Message = "Unable to start the snapshot " + name + ", _snapshot returned " + strconv.Itoa(status)
+		} else {
+			export.Message = ""
+			export.LastSnapshot = name
+			export.LastExported = &now
+		}
+		if export.Message != "" {
+			glog.Errorf("Unable to export a snapshot of %s to %s: %s\n", instance.Name, export.Bucket, export.Message)
+		}
+		if err = storage.SetSnapshotExport(&export); err != nil {
+			glog.Errorf("Unable to record the snapshot export of %s: %s\n", instance.Name, err.Error())
+		}
+	}
+	return nil
+}
+
+// GET /v2/service_instances/{instance_id}/actions/snapshot-export
+func (b *BusinessLogic) GetSnapshotExportAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	export, err := b.storage.GetSnapshotExport(InstanceID)
+	if err != nil && err.Error() == "Not found" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Unable to get the snapshot export of %s: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	return export, nil
+}
+
+// GET /v2/service_instances/{instance_id}/actions/snapshot-export/policy?bucket=&base_path=
+// returns the bucket policy to apply before registering the bucket.
+func (b *BusinessLogic) GetSnapshotExportPolicyAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	if exportRoleArn() == "" {
+		return nil, UnprocessableEntityWithMessage("NotEnabled", "Snapshot exports are not enabled.")
+	}
+	bucket := c.Request.URL.Query().Get("bucket")
+	if !bucketNamePattern.MatchString(bucket) {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "A valid bucket must be provided.")
+	}
+	return ExportBucketPolicy(bucket, c.Request.URL.Query().Get("base_path")), nil
+}
+
+// PUT /v2/service_instances/{instance_id}/actions/snapshot-export with {"bucket":"...",
+// "region":"...", "base_path":"..."} registers the bucket, it must already have the
+// bucket policy from snapshot-export/policy.
+func (b *BusinessLogic) SetSnapshotExportAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	if exportRoleArn() == "" {
+		return nil, UnprocessableEntityWithMessage("NotEnabled", "Snapshot exports are not enabled.")
+	}
+	var request SnapshotExportRequest
+	if c == nil || c.Request == nil || c.Request.Body == nil {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "A bucket must be provided.")
+	}
+	if err := json.NewDecoder(c.Request.Body).Decode(&request); err != nil || !bucketNamePattern.MatchString(request.Bucket) {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "A valid bucket must be provided.")
+	}
+	if request.Region == "" {
+		request.Region = os.Getenv("AWS_REGION")
+	}
+	Instance, err := b.GetInstanceById(InstanceID)
+	if err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Error finding instance id (during snapshot export): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	if !IsAvailable(Instance.Status) {
+		return nil, UnprocessableEntityWithMessage("NotAvailable", "The instance must be available to register a bucket.")
+	}
+	export := SnapshotExport{
+		InstanceId: Instance.Id,
+		Bucket:     request.Bucket,
+		Region:     request.Region,
+		BasePath:   request.BasePath,
+		Status:     "active",
+	}
+	if existing, err := b.storage.GetSnapshotExport(InstanceID); err == nil && existing.Bucket == export.Bucket && existing.BasePath == export.BasePath {
+		export.LastSnapshot = existing.LastSnapshot
+		export.LastExported = existing.LastExported
+	}
+	if err = registerExportRepository(NewClusterClient(), Instance, &export); err != nil {
+		return nil, UnprocessableEntityWithMessage("BucketNotWritable", err.Error())
+	}
+	if err = b.storage.SetSnapshotExport(&export); err != nil {
+		glog.Errorf("Unable to record the snapshot export of %s: %s\n", Instance.Name, err.Error())
+		return nil, InternalServerError()
+	}
+	return export, nil
+}
+
+// DELETE /v2/service_instances/{instance_id}/actions/snapshot-export stops exporting, the
+// snapshots already in the bucket are left to its owner.
+func (b *BusinessLogic) RemoveSnapshotExportAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	export, err := b.storage.GetSnapshotExport(InstanceID)
+	if err != nil && err.Error() == "Not found" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Unable to get the snapshot export of %s: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	if Instance, err := b.GetInstanceById(InstanceID); err == nil {
+		if _, status, err := NewClusterClient().Do(Instance, "DELETE", "/_snapshot/"+exportRepository, nil); err != nil || (status != 200 && status != 404) {
+			glog.Errorf("Unable to remove the export repository from %s (status %d): %v\n", Instance.Name, status, err)
+		}
+	}
+	if err = b.storage.DeleteSnapshotExport(InstanceID); err != nil {
+		glog.Errorf("Unable to remove the snapshot export of %s: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	return export, nil
+}
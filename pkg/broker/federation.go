@@ -0,0 +1,353 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+// The catalogs of the brokers are cached for this long, a broker that can't be reached keeps
+// offering the plans of its last catalog (if there is one) rather than failing the catalog.
+const federationCatalogTTL = time.Minute * 5
+
+var federationEnvInvalid = regexp.MustCompile(`[^A-Z0-9_]`)
+
+// FederatedBroker is a regional or account specific broker that the federation
+// router forwards requests to.
+type FederatedBroker struct {
+	Name     string
+	Url      *url.URL
+	Username string
+	Password string
+	proxy    *httputil.ReverseProxy
+	// the last catalog the broker returned
+	catalog *osb.CatalogResponse
+}
+
+// Federation aggregates the catalogs of multiple brokers and forwards OSB requests to
+// the broker that owns the plan (on provision) or the instance (on everything else), so
+// the platform only needs to know about one broker url.
+type Federation struct {
+	sync.RWMutex
+	brokers []*FederatedBroker
+	plans   map[string]*FederatedBroker
+	catalog *osb.CatalogResponse
+	fetched time.Time
+	storage Storage
+	client  *http.Client
+}
+
+func (f *FederatedBroker) authorize(req *http.Request) {
+	if f.Username != "" || f.Password != "" {
+		req.SetBasicAuth(f.Username, f.Password)
+	}
+}
+
+// federationEnv is the environment variable a setting of the broker is in, e.g.,
+// FEDERATION_US_EAST_PASSWORD for the password of us-east.
+func federationEnv(name string, setting string) string {
+	return "FEDERATION_" + federationEnvInvalid.ReplaceAllString(strings.ToUpper(name), "_") + "_" + setting
+}
+
+// NewFederatedBroker creates the broker with the url, its credentials are read from
+// FEDERATION_<NAME>_USERNAME and FEDERATION_<NAME>_PASSWORD so they are not part of the url.
+func NewFederatedBroker(name string, rawurl string) (*FederatedBroker, error) {
+	u, err := url.Parse(strings.TrimSuffix(rawurl, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, errors.New("The url for the federated broker " + name + " is invalid.")
+	}
+	if u.User != nil {
+		return nil, errors.New("The url for the federated broker " + name + " must not contain credentials, set " + federationEnv(name, "USERNAME") + " and " + federationEnv(name, "PASSWORD") + " instead.")
+	}
+	fb := &FederatedBroker{
+		Name:     name,
+		Url:      u,
+		Username: os.Getenv(federationEnv(name, "USERNAME")),
+		Password: os.Getenv(federationEnv(name, "PASSWORD")),
+	}
+	fb.proxy = &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = u.Scheme
+			req.URL.Host = u.Host
+			req.URL.Path = u.Path + req.URL.Path
+			req.Host = u.Host
+			req.Header.Del("Authorization")
+			fb.authorize(req)
+		},
+	}
+	return fb, nil
+}
+
+// NewFederation reads the downstream brokers from FEDERATION_BROKERS, a comma separated
+// list of name=url pairs (e.g., us=https://es-broker-us,eu=https://es-broker-eu).
+func NewFederation(ctx context.Context, o Options) (*Federation, error) {
+	if os.Getenv("FEDERATION_BROKERS") == "" {
+		return nil, errors.New("Unable to find FEDERATION_BROKERS environment variable.")
+	}
+	storage, err := InitStorage(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+	brokers := make([]*FederatedBroker, 0)
+	for _, pair := range strings.Split(os.Getenv("FEDERATION_BROKERS"), ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("Invalid entry in FEDERATION_BROKERS, expected name=url: " + pair)
+		}
+		fb, err := NewFederatedBroker(parts[0], parts[1])
+		if err != nil {
+			return nil, err
+		}
+		brokers = append(brokers, fb)
+	}
+	return &Federation{
+		brokers: brokers,
+		plans:   make(map[string]*FederatedBroker),
+		storage: storage,
+		client:  &http.Client{Timeout: time.Second * 60},
+	}, nil
+}
+
+func (f *Federation) getBroker(name string) *FederatedBroker {
+	for _, fb := range f.brokers {
+		if fb.Name == name {
+			return fb
+		}
+	}
+	return nil
+}
+
+func (f *Federation) fetchCatalog(fb *FederatedBroker, version string) (*osb.CatalogResponse, error) {
+	req, err := http.NewRequest("GET", fb.Url.String()+"/v2/catalog", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Broker-API-Version", version)
+	fb.authorize(req)
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("Catalog request returned " + resp.Status)
+	}
+	var catalog osb.CatalogResponse
+	if err = json.NewDecoder(resp.Body).Decode(&catalog); err != nil {
+		return nil, err
+	}
+	return &catalog, nil
+}
+
+// Catalog returns the aggregated catalog, refreshing it once it is older than
+// federationCatalogTTL.
+func (f *Federation) Catalog(version string) (*osb.CatalogResponse, error) {
+	f.RLock()
+	catalog, fetched := f.catalog, f.fetched
+	f.RUnlock()
+	if catalog != nil && time.Since(fetched) < federationCatalogTTL {
+		return catalog, nil
+	}
+	return f.refreshCatalog(version)
+}
+
+// refreshCatalog aggregates the catalogs of all brokers (fetched concurrently), services with
+// the same id have their plans merged. Plan ids must be unique across brokers as they determine
+// where instances are created. A broker that fails is skipped (or its last catalog is used),
+// the catalog only fails when no broker has one.
+func (f *Federation) refreshCatalog(version string) (*osb.CatalogResponse, error) {
+	var wg sync.WaitGroup
+	catalogs := make([]*osb.CatalogResponse, len(f.brokers))
+	for i, fb := range f.brokers {
+		wg.Add(1)
+		go (func(i int, fb *FederatedBroker) {
+			defer wg.Done()
+			catalog, err := f.fetchCatalog(fb, version)
+			f.Lock()
+			defer f.Unlock()
+			if err != nil {
+				glog.Errorf("Unable to get catalog from federated broker %s: %s\n", fb.Name, err.Error())
+				catalogs[i] = fb.catalog
+				return
+			}
+			fb.catalog = catalog
+			catalogs[i] = catalog
+		})(i, fb)
+	}
+	wg.Wait()
+
+	services := make([]osb.Service, 0)
+	index := make(map[string]int)
+	plans := make(map[string]*FederatedBroker)
+	found := false
+	for i, fb := range f.brokers {
+		catalog := catalogs[i]
+		if catalog == nil {
+			continue
+		}
+		found = true
+		for _, service := range catalog.Services {
+			servicePlans := make([]osb.Plan, 0)
+			for _, plan := range service.Plans {
+				if existing, ok := plans[plan.ID]; ok {
+					glog.Errorf("The plan %s is offered by both %s and %s, only %s will be used.\n", plan.ID, existing.Name, fb.Name, existing.Name)
+					continue
+				}
+				plans[plan.ID] = fb
+				servicePlans = append(servicePlans, plan)
+			}
+			if i, ok := index[service.ID]; ok {
+				services[i].Plans = append(services[i].Plans, servicePlans...)
+			} else {
+				service.Plans = servicePlans
+				index[service.ID] = len(services)
+				services = append(services, service)
+			}
+		}
+	}
+	if !found {
+		return nil, errors.New("Unable to get the catalog of any federated broker.")
+	}
+	catalog := &osb.CatalogResponse{Services: services}
+	f.Lock()
+	f.plans = plans
+	f.catalog = catalog
+	f.fetched = time.Now()
+	f.Unlock()
+	return catalog, nil
+}
+
+func (f *Federation) brokerForPlan(planId string, version string) *FederatedBroker {
+	f.RLock()
+	fb, ok := f.plans[planId]
+	f.RUnlock()
+	if ok {
+		return fb
+	}
+	if _, err := f.refreshCatalog(version); err != nil {
+		return nil
+	}
+	f.RLock()
+	defer f.RUnlock()
+	return f.plans[planId]
+}
+
+// Finds the broker that owns the instance, falling back to the plan id the platform sent.
+func (f *Federation) brokerForInstance(r *http.Request, instanceId string) *FederatedBroker {
+	name, err := f.storage.GetFederatedInstance(instanceId)
+	if err == nil {
+		if fb := f.getBroker(name); fb != nil {
+			return fb
+		}
+	}
+	planId := r.URL.Query().Get("plan_id")
+	if planId == "" {
+		planId = planIdFromBody(r)
+	}
+	if planId != "" {
+		return f.brokerForPlan(planId, r.Header.Get("X-Broker-API-Version"))
+	}
+	return nil
+}
+
+// Reads the plan_id from the request body and restores the body so it can still be forwarded.
+func planIdFromBody(r *http.Request) string {
+	if r.Body == nil {
+		return ""
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+	var request struct {
+		PlanID string `json:"plan_id"`
+	}
+	json.Unmarshal(body, &request)
+	return request.PlanID
+}
+
+// Authenticate requires the basic auth credentials in FEDERATION_USERNAME and
+// FEDERATION_PASSWORD on every request to the router.
+func (f *Federation) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(username), []byte(os.Getenv("FEDERATION_USERNAME"))) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(os.Getenv("FEDERATION_PASSWORD"))) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="federation"`)
+			HttpWrite(w, http.StatusUnauthorized, map[string]string{"description": "Unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// HasCredentials is true when the router has credentials of its own to authenticate requests with.
+func (f *Federation) HasCredentials() bool {
+	return os.Getenv("FEDERATION_USERNAME") != "" && os.Getenv("FEDERATION_PASSWORD") != ""
+}
+
+func (f *Federation) CatalogHandler(w http.ResponseWriter, r *http.Request) {
+	catalog, err := f.Catalog(r.Header.Get("X-Broker-API-Version"))
+	if err != nil {
+		HttpWrite(w, http.StatusInternalServerError, map[string]string{"description": "Unable to get the catalog from all brokers."})
+		return
+	}
+	HttpWrite(w, http.StatusOK, catalog)
+}
+
+func (f *Federation) ProvisionHandler(w http.ResponseWriter, r *http.Request) {
+	instanceId := mux.Vars(r)["instance_id"]
+	fb := f.brokerForInstance(r, instanceId)
+	if fb == nil {
+		HttpWrite(w, http.StatusBadRequest, map[string]string{"description": "The plan was not found in any broker."})
+		return
+	}
+	if err := f.storage.AddFederatedInstance(instanceId, fb.Name); err != nil {
+		glog.Errorf("Unable to record federated instance %s (%s): %s\n", instanceId, fb.Name, err.Error())
+		HttpWrite(w, http.StatusInternalServerError, map[string]string{"description": "Internal Server Error"})
+		return
+	}
+	glog.Infof("Federating provision of %s to %s\n", instanceId, fb.Name)
+	fb.proxy.ServeHTTP(w, r)
+}
+
+func (f *Federation) InstanceHandler(w http.ResponseWriter, r *http.Request) {
+	instanceId := mux.Vars(r)["instance_id"]
+	fb := f.brokerForInstance(r, instanceId)
+	if fb == nil {
+		if r.Method == "DELETE" {
+			HttpWrite(w, http.StatusGone, map[string]string{})
+		} else {
+			HttpWrite(w, http.StatusNotFound, map[string]string{"description": "Not Found"})
+		}
+		return
+	}
+	fb.proxy.ServeHTTP(w, r)
+}
+
+func (f *Federation) Routes(router *mux.Router) {
+	router.HandleFunc("/v2/catalog", f.CatalogHandler).Methods("GET")
+	router.HandleFunc("/v2/service_instances/{instance_id}", f.ProvisionHandler).Methods("PUT")
+	router.PathPrefix("/v2/service_instances/{instance_id}").HandlerFunc(f.InstanceHandler)
+}
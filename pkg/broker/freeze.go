@@ -0,0 +1,60 @@
+package broker
+
+import (
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// A frozen instance can't be changed, updated, upgraded, deprovisioned, bound or unbound (nor
+// expired or restored) until it is unfrozen, e.g., while an incident is investigated or it
+// is under a legal hold. Only operators with the admin token can freeze or unfreeze it.
+
+type InstanceFreeze struct {
+	Frozen bool   `json:"frozen"`
+	Reason string `json:"reason,omitempty"`
+	Actor  string `json:"actor,omitempty"`
+}
+
+var freezeToggle = adminToggle{
+	name:     "freeze",
+	field:    "frozen",
+	reasons:  "the incident or legal hold",
+	onEvent:  FrozenEvent,
+	offEvent: UnfrozenEvent,
+	get: func(storage Storage, InstanceID string) (bool, string, error) {
+		freeze, err := storage.GetFreeze(InstanceID)
+		if err != nil {
+			return false, "", err
+		}
+		return freeze.Frozen, freeze.Reason, nil
+	},
+	set: func(storage Storage, InstanceID string, on bool, reason string, actor string) error {
+		return storage.SetFreeze(InstanceID, &InstanceFreeze{Frozen: on, Reason: reason, Actor: actor})
+	},
+}
+
+// checkNotFrozen returns an error if the instance is frozen.
+func (b *BusinessLogic) checkNotFrozen(InstanceID string) error {
+	frozen, reason, err := freezeToggle.state(b.storage, InstanceID)
+	if err != nil {
+		return err
+	}
+	if frozen {
+		return UnprocessableEntityWithMessage("InstanceFrozen", "The instance is frozen ("+reason+"), it can't be changed until it is unfrozen.")
+	}
+	return nil
+}
+
+// isFrozen is used by the automated actions, which skip frozen instances.
+func isFrozen(storage Storage, InstanceID string) bool {
+	return freezeToggle.isOn(storage, InstanceID)
+}
+
+// GET /v2/service_instances/{instance_id}/actions/freeze
+func (b *BusinessLogic) GetFreezeAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	return freezeToggle.getAction(b.storage, InstanceID)
+}
+
+// PUT /v2/service_instances/{instance_id}/actions/freeze with {"frozen":true, "reason":"..."}
+func (b *BusinessLogic) SetFreezeAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	return freezeToggle.setAction(b.storage, InstanceID, c)
+}
@@ -0,0 +1,114 @@
+package broker
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+type GetInstanceResponse struct {
+	ServiceID       string                 `json:"service_id"`
+	PlanID          string                 `json:"plan_id"`
+	DashboardURL    string                 `json:"dashboard_url,omitempty"`
+	Parameters      map[string]interface{} `json:"parameters,omitempty"`
+	MaintenanceInfo MaintenanceInfo        `json:"maintenance_info"`
+	// The status of the domain at the provider (e.g., available or processing).
+	Status        string              `json:"status"`
+	EngineSupport EngineSupportStatus `json:"engine_support"`
+}
+
+// DashboardURL is kibana on the kibana proxy if it is running, otherwise on the domain.
+func DashboardURL(instance *Instance) string {
+	if os.Getenv("KIBANA_PROXY_PORT") != "" && os.Getenv("KIBANA_PROXY_URL") != "" {
+		return strings.TrimSuffix(os.Getenv("KIBANA_PROXY_URL"), "/") + "/kibana/" + instance.Id
+	}
+	if instance.Endpoint == "" {
+		return ""
+	}
+	return instance.Scheme + "://" + instance.Endpoint + dashboardsPath(instance.Plan)
+}
+
+func (b *BusinessLogic) GetInstance(InstanceID string) (*GetInstanceResponse, error) {
+	Instance, err := b.GetInstanceById(InstanceID)
+	if err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Error finding instance id (during get instance): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	// instances can't be fetched until they are provisioned or while they are being updated
+	if Instance.Status == "creating" {
+		return nil, NotFound()
+	}
+	if upgrading, err := b.storage.IsUpgrading(InstanceID); err != nil {
+		glog.Errorf("Unable to determine if %s is being updated (during get instance): %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	} else if upgrading {
+		return nil, UnprocessableEntityWithMessage("ConcurrencyError", "The instance is being updated.")
+	}
+	// the parameters the owner gave, with the domain settings as applied
+	parameters, err := b.storage.GetRequestedParameters(InstanceID)
+	if err != nil {
+		glog.Errorf("Unable to get the requested parameters of %s (during get instance): %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	if Instance.Parameters != nil {
+		data, err := json.Marshal(Instance.Parameters)
+		if err == nil {
+			err = json.Unmarshal(data, &parameters)
+		}
+		if err != nil {
+			glog.Errorf("Unable to marshal the parameters of %s (during get instance): %s\n", InstanceID, err.Error())
+			return nil, InternalServerError()
+		}
+	}
+	if len(parameters) == 0 {
+		parameters = nil
+	}
+	supports, err := b.storage.GetEngineSupports()
+	if err != nil {
+		glog.Errorf("Unable to get engine versions (during get instance): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	support := GetEngineSupportStatus(supports, Instance.Engine, Instance.EngineVersion, time.Now())
+	serviceId := ""
+	if service, ok := Instance.Plan.basePlan.Metadata["addon_service"].(map[string]interface{}); ok {
+		serviceId, _ = service["id"].(string)
+	}
+	return &GetInstanceResponse{
+		ServiceID:       serviceId,
+		PlanID:          Instance.Plan.ID,
+		DashboardURL:    DashboardURL(Instance),
+		Parameters:      parameters,
+		MaintenanceInfo: MaintenanceInfo{Version: InstanceMaintenanceInfo(Instance).Version, Description: support.Description()},
+		Status:          Instance.Status,
+		EngineSupport:   support,
+	}, nil
+}
+
+// RouteGetInstance adds GET /v2/service_instances/{instance_id} (fetching an instance
+// from OSB 2.14), it reports the plan, parameters, dashboard, status, engine version and
+// its support window.
+func (b *BusinessLogic) RouteGetInstance(router *mux.Router) {
+	router.HandleFunc("/v2/service_instances/{instance_id}", func(w http.ResponseWriter, r *http.Request) {
+		resp, err := b.GetInstance(mux.Vars(r)["instance_id"])
+		if httpErr, ok := osb.IsHTTPError(err); ok {
+			body := map[string]string{}
+			if httpErr.Description != nil {
+				body["description"] = *httpErr.Description
+			}
+			if httpErr.ErrorMessage != nil {
+				body["error"] = *httpErr.ErrorMessage
+			}
+			HttpWrite(w, httpErr.StatusCode, body)
+			return
+		}
+		HttpWrite(w, http.StatusOK, resp)
+	}).Methods("GET")
+}
@@ -0,0 +1,162 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of testdata/plans instead of comparing them")
+
+// A GoldenCase is a representative plan and the broker environment it is
+// provisioned with, the request rendered from it is compared to a golden file
+// so changes to how settings are merged can't silently change what is created.
+type GoldenCase struct {
+	Env                    map[string]string `json:"env"`
+	ProviderPrivateDetails json.RawMessage   `json:"provider_private_details"`
+}
+
+const (
+	goldenInstanceId   = "00000000-0000-0000-0000-000000000000"
+	goldenInstanceName = "golden"
+	goldenOwner        = "golden-owner"
+)
+
+// goldenEnv is the environment every case starts from, cases may add to or
+// override it.
+var goldenEnv = map[string]string{
+	"AWS_REGION":            "us-west-2",
+	"AWS_ACCOUNT_ID":        "123456789012",
+	"AWS_KMS_KEY_ID":        "11111111-2222-3333-4444-555555555555",
+	"AWS_SUBNET_ID":         "",
+	"AWS_SECURITY_GROUP_ID": "",
+}
+
+// withEnv sets the environment for the duration of fn and restores it after.
+func withEnv(env map[string]string, fn func() error) error {
+	previous := make(map[string]*string)
+	for name, value := range env {
+		if old, ok := os.LookupEnv(name); ok {
+			previous[name] = &old
+		} else {
+			previous[name] = nil
+		}
+		os.Setenv(name, value)
+	}
+	defer (func() {
+		for name, old := range previous {
+			if old == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *old)
+			}
+		}
+	})()
+	return fn()
+}
+
+// withoutNulls drops null values so golden files only contain what is sent.
+func withoutNulls(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{})
+		for key, item := range v {
+			if item != nil {
+				out[key] = withoutNulls(item)
+			}
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, 0)
+		for _, item := range v {
+			out = append(out, withoutNulls(item))
+		}
+		return out
+	}
+	return value
+}
+
+// renderGoldenCase returns the create domain request the case would send to aws.
+func renderGoldenCase(c GoldenCase) ([]byte, error) {
+	env := make(map[string]string)
+	for name, value := range goldenEnv {
+		env[name] = value
+	}
+	for name, value := range c.Env {
+		env[name] = value
+	}
+	var rendered []byte
+	err := withEnv(env, func() error {
+		plan := &ProviderPlan{ID: "golden", Provider: AWSESInstance, providerPrivateDetails: string(c.ProviderPrivateDetails)}
+		details, err := plan.RenderPrivateDetails(NewPlanTemplateData(goldenInstanceId, goldenInstanceName, goldenOwner))
+		if err != nil {
+			return err
+		}
+		settings, err := BuildCreateDomainInput(goldenInstanceName, details)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(settings)
+		if err != nil {
+			return err
+		}
+		var value interface{}
+		if err = json.Unmarshal(data, &value); err != nil {
+			return err
+		}
+		rendered, err = json.MarshalIndent(withoutNulls(value), "", "  ")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(rendered, '\n'), nil
+}
+
+// TestGolden renders each case (testdata/plans/*.json) and compares it to its golden file
+// (*.golden), with -update the golden files are rewritten instead.
+func TestGolden(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "plans", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("No golden cases were found in testdata/plans")
+	}
+	for _, file := range files {
+		file := file
+		t.Run(strings.TrimSuffix(filepath.Base(file), ".json"), func(t *testing.T) {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var c GoldenCase
+			if err = json.Unmarshal(data, &c); err != nil {
+				t.Fatalf("Unable to read %s: %s", file, err.Error())
+			}
+			rendered, err := renderGoldenCase(c)
+			if err != nil {
+				t.Fatalf("Unable to render %s: %s", file, err.Error())
+			}
+			golden := strings.TrimSuffix(file, ".json") + ".golden"
+			if *update {
+				if err = ioutil.WriteFile(golden, rendered, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			expected, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("Unable to read the golden file (run with -update to create it): %s", err.Error())
+			}
+			if !bytes.Equal(expected, rendered) {
+				t.Errorf("The rendered request differs from %s\n--- expected\n%s+++ rendered\n%s", golden, string(expected), string(rendered))
+			}
+		})
+	}
+}
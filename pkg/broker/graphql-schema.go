@@ -0,0 +1,430 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+const graphQLSchema = `
+schema {
+  query: Query
+}
+
+scalar Time
+
+# The labels of an instance as a json object of keys to values.
+scalar Labels
+
+type Query {
+  instances(plan: String, status: String, owner: String, engine: String, label: [String!], sort: String, order: String, limit: Int, cursor: String): InstancePage!
+  instance(id: String!): Instance
+  plans: [Plan!]!
+  metrics: Metrics!
+}
+
+type InstancePage {
+  items: [Instance!]!
+  nextCursor: String!
+}
+
+type Instance {
+  id: String!
+  name: String!
+  status: String!
+  owner: String!
+  engine: String!
+  engineVersion: String!
+  labels: Labels!
+  created: Time!
+  updated: Time!
+  plan: Plan
+  engineSupport: EngineSupport!
+  storage: Storage
+  operations(action: String, status: String, sort: String, order: String, limit: Int, cursor: String): OperationPage!
+  bindings(sort: String, order: String, limit: Int, cursor: String): BindingPage!
+}
+
+type Plan {
+  id: String!
+  name: String!
+  description: String!
+  engine: String!
+  engineVersion: String!
+  provider: String!
+}
+
+type EngineSupport {
+  status: String!
+  endOfSupport: Time
+  daysRemaining: Int
+  upgradeTo: String!
+  description: String!
+}
+
+type Storage {
+  usedBytes: Float!
+  totalBytes: Float!
+  sampled: Time!
+}
+
+type OperationPage {
+  items: [Operation!]!
+  nextCursor: String!
+}
+
+type Operation {
+  id: String!
+  action: String!
+  status: String!
+  retries: Int!
+  result: String!
+  created: Time!
+  updated: Time!
+  started: Time
+  finished: Time
+}
+
+type BindingPage {
+  items: [Binding!]!
+  nextCursor: String!
+}
+
+type Binding {
+  id: String!
+  status: String!
+  expires: Time
+  created: Time!
+  updated: Time!
+}
+
+type Metrics {
+  instances: Int!
+  byStatus: [Count!]!
+  byPlan: [Count!]!
+}
+
+type Count {
+  key: String!
+  count: Int!
+}
+`
+
+// gqlListArgs are the arguments of the lists, they are converted to the query string
+// parameters of the REST lists.
+type gqlListArgs struct {
+	Plan   *string
+	Status *string
+	Owner  *string
+	Engine *string
+	Action *string
+	Label  *[]string
+	Sort   *string
+	Order  *string
+	Limit  *int32
+	Cursor *string
+}
+
+func (a gqlListArgs) query(spec listSpec) (*ListQuery, error) {
+	values := url.Values{}
+	for name, value := range map[string]*string{"plan": a.Plan, "status": a.Status, "owner": a.Owner, "engine": a.Engine, "action": a.Action, "sort": a.Sort, "order": a.Order, "cursor": a.Cursor} {
+		if value != nil {
+			values.Set(name, *value)
+		}
+	}
+	if a.Label != nil {
+		values["label"] = *a.Label
+	}
+	values.Set("limit", strconv.Itoa(gqlMaxListLimit))
+	if a.Limit != nil {
+		if *a.Limit < 1 || *a.Limit > gqlMaxListLimit {
+			return nil, errors.New("The limit must be between 1 and " + strconv.Itoa(gqlMaxListLimit) + ".")
+		}
+		values.Set("limit", strconv.Itoa(int(*a.Limit)))
+	}
+	return ParseListQuery(values, spec)
+}
+
+func gqlTime(t *time.Time) *graphql.Time {
+	if t == nil {
+		return nil
+	}
+	return &graphql.Time{Time: *t}
+}
+
+// gqlLabels is the Labels scalar, it is returned as a json object.
+type gqlLabels map[string]string
+
+func (gqlLabels) ImplementsGraphQLType(name string) bool {
+	return name == "Labels"
+}
+
+func (l *gqlLabels) UnmarshalGraphQL(input interface{}) error {
+	return errors.New("Labels cannot be used as an input")
+}
+
+type gqlQuery struct{}
+
+func (q *gqlQuery) Instances(ctx context.Context, args gqlListArgs) (*gqlInstancePage, error) {
+	l := gqlLoaderFrom(ctx)
+	query, err := args.query(instancesListSpec)
+	if err != nil {
+		return nil, err
+	}
+	instances, next, err := l.b.storage.ListInstances(query)
+	if err != nil {
+		return nil, err
+	}
+	page := &gqlInstancePage{Items: make([]*gqlInstance, 0), NextCursor: next}
+	for _, instance := range instances {
+		page.Items = append(page.Items, &gqlInstance{l: l, i: instance})
+	}
+	return page, nil
+}
+
+func (q *gqlQuery) Instance(ctx context.Context, args struct{ Id string }) (*gqlInstance, error) {
+	l := gqlLoaderFrom(ctx)
+	query := &ListQuery{Limit: 1, Sort: instancesListSpec.DefaultSort, Filters: map[string]string{"id": args.Id}}
+	instances, _, err := l.b.storage.ListInstances(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(instances) == 0 {
+		return nil, nil
+	}
+	return &gqlInstance{l: l, i: instances[0]}, nil
+}
+
+func (q *gqlQuery) Plans(ctx context.Context) ([]*gqlPlan, error) {
+	l := gqlLoaderFrom(ctx)
+	services, err := l.b.storage.GetServices()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]*gqlPlan, 0)
+	for _, service := range services {
+		plans, err := l.b.storage.GetPlans(service.ID)
+		if err != nil {
+			return nil, err
+		}
+		for i := range plans {
+			items = append(items, newGqlPlan(&plans[i]))
+		}
+	}
+	return items, nil
+}
+
+func (q *gqlQuery) Metrics(ctx context.Context) (*gqlMetrics, error) {
+	entries, err := gqlLoaderFrom(ctx).b.storage.GetInstances()
+	if err != nil {
+		return nil, err
+	}
+	statuses := make(map[string]int32)
+	plans := make(map[string]int32)
+	for _, entry := range entries {
+		statuses[entry.Status]++
+		plans[entry.PlanId]++
+	}
+	return &gqlMetrics{Instances: int32(len(entries)), ByStatus: gqlCounts(statuses), ByPlan: gqlCounts(plans)}, nil
+}
+
+type gqlInstancePage struct {
+	Items      []*gqlInstance
+	NextCursor string
+}
+
+type gqlInstance struct {
+	l *gqlLoader
+	i InstanceSummary
+}
+
+func (r *gqlInstance) Id() string            { return r.i.Id }
+func (r *gqlInstance) Name() string          { return r.i.Name }
+func (r *gqlInstance) Status() string        { return r.i.Status }
+func (r *gqlInstance) Owner() string         { return r.i.Owner }
+func (r *gqlInstance) Engine() string        { return r.i.Engine }
+func (r *gqlInstance) EngineVersion() string { return r.i.EngineVersion }
+func (r *gqlInstance) Created() graphql.Time { return graphql.Time{Time: r.i.Created} }
+func (r *gqlInstance) Updated() graphql.Time { return graphql.Time{Time: r.i.Updated} }
+
+func (r *gqlInstance) Labels() gqlLabels {
+	if r.i.Labels == nil {
+		return gqlLabels{}
+	}
+	return gqlLabels(r.i.Labels)
+}
+
+func (r *gqlInstance) Plan() (*gqlPlan, error) {
+	plan, err := r.l.plan(r.i.PlanId)
+	if err != nil {
+		return nil, err
+	}
+	return newGqlPlan(plan), nil
+}
+
+func (r *gqlInstance) EngineSupport() (*gqlEngineSupport, error) {
+	supports, err := r.l.engineSupports()
+	if err != nil {
+		return nil, err
+	}
+	support := GetEngineSupportStatus(supports, r.i.Engine, r.i.EngineVersion, time.Now())
+	s := &gqlEngineSupport{
+		Status:       support.Status,
+		EndOfSupport: gqlTime(support.EndOfSupport),
+		UpgradeTo:    support.UpgradeTo,
+		Description:  support.Description(),
+	}
+	if support.DaysRemaining != nil {
+		days := int32(*support.DaysRemaining)
+		s.DaysRemaining = &days
+	}
+	return s, nil
+}
+
+func (r *gqlInstance) Storage() (*gqlStorage, error) {
+	samples, err := r.l.b.storage.GetStorageSamples(r.i.Id, time.Now().Add(-time.Hour*24))
+	if err != nil || len(samples) == 0 {
+		return nil, err
+	}
+	last := samples[len(samples)-1]
+	return &gqlStorage{UsedBytes: float64(last.UsedBytes), TotalBytes: float64(last.TotalBytes), Sampled: graphql.Time{Time: last.Sampled}}, nil
+}
+
+func (r *gqlInstance) Operations(args gqlListArgs) (*gqlOperationPage, error) {
+	query, err := args.query(operationsListSpec)
+	if err != nil {
+		return nil, err
+	}
+	operations, next, err := r.l.b.storage.ListOperations(r.i.Id, query)
+	if err != nil {
+		return nil, err
+	}
+	page := &gqlOperationPage{Items: make([]*gqlOperation, 0), NextCursor: next}
+	for _, o := range operations {
+		page.Items = append(page.Items, &gqlOperation{
+			Id:       o.Id,
+			Action:   string(o.Action),
+			Status:   o.Status,
+			Retries:  int32(o.Retries),
+			Result:   o.Result,
+			Created:  graphql.Time{Time: o.Created},
+			Updated:  graphql.Time{Time: o.Updated},
+			Started:  gqlTime(o.Started),
+			Finished: gqlTime(o.Finished),
+		})
+	}
+	return page, nil
+}
+
+func (r *gqlInstance) Bindings(args gqlListArgs) (*gqlBindingPage, error) {
+	query, err := args.query(bindingsListSpec)
+	if err != nil {
+		return nil, err
+	}
+	bindings, next, err := r.l.b.storage.ListBindings(r.i.Id, query)
+	if err != nil {
+		return nil, err
+	}
+	page := &gqlBindingPage{Items: make([]*gqlBinding, 0), NextCursor: next}
+	for _, binding := range bindings {
+		page.Items = append(page.Items, &gqlBinding{
+			Id:      binding.Id,
+			Status:  binding.Status,
+			Expires: gqlTime(binding.Expires),
+			Created: graphql.Time{Time: binding.Created},
+			Updated: graphql.Time{Time: binding.Updated},
+		})
+	}
+	return page, nil
+}
+
+type gqlPlan struct {
+	Id            string
+	Name          string
+	Description   string
+	Engine        string
+	EngineVersion string
+	Provider      string
+}
+
+func newGqlPlan(plan *ProviderPlan) *gqlPlan {
+	engine, version := "", ""
+	if e, ok := plan.basePlan.Metadata["engine"].(map[string]string); ok {
+		engine, version = e["type"], e["version"]
+	}
+	return &gqlPlan{
+		Id:            plan.ID,
+		Name:          plan.basePlan.Name,
+		Description:   plan.basePlan.Description,
+		Engine:        engine,
+		EngineVersion: version,
+		Provider:      string(plan.Provider),
+	}
+}
+
+type gqlEngineSupport struct {
+	Status        string
+	EndOfSupport  *graphql.Time
+	DaysRemaining *int32
+	UpgradeTo     string
+	Description   string
+}
+
+type gqlStorage struct {
+	UsedBytes  float64
+	TotalBytes float64
+	Sampled    graphql.Time
+}
+
+type gqlOperationPage struct {
+	Items      []*gqlOperation
+	NextCursor string
+}
+
+type gqlOperation struct {
+	Id       string
+	Action   string
+	Status   string
+	Retries  int32
+	Result   string
+	Created  graphql.Time
+	Updated  graphql.Time
+	Started  *graphql.Time
+	Finished *graphql.Time
+}
+
+type gqlBindingPage struct {
+	Items      []*gqlBinding
+	NextCursor string
+}
+
+type gqlBinding struct {
+	Id      string
+	Status  string
+	Expires *graphql.Time
+	Created graphql.Time
+	Updated graphql.Time
+}
+
+type gqlMetrics struct {
+	Instances int32
+	ByStatus  []*gqlCount
+	ByPlan    []*gqlCount
+}
+
+type gqlCount struct {
+	Key   string
+	Count int32
+}
+
+func gqlCounts(counts map[string]int32) []*gqlCount {
+	items := make([]*gqlCount, 0)
+	for key, count := range counts {
+		items = append(items, &gqlCount{Key: key, Count: count})
+	}
+	return items
+}
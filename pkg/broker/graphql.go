@@ -0,0 +1,121 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	graphql "github.com/graph-gophers/graphql-go"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// The GraphQL admin api is served with graph-gophers/graphql-go, the schema and its
+// resolvers are in graphql-schema.go. Only queries are supported.
+
+const (
+	// The deepest selection allowed, e.g., instances { items { operations { items { id } } } }
+	gqlMaxDepth = 5
+	// The most items a list in a query may return, lists nested in a list run one query
+	// per item so this bounds the fan out of a query to gqlMaxListLimit per nested list.
+	gqlMaxListLimit = 25
+	// The most resolvers ran concurrently for a query.
+	gqlMaxParallelism = 10
+)
+
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+type gqlLoaderKey struct{}
+
+// gqlLoader caches the plans and engine support windows for the duration of a query so
+// they are only read once no matter how many instances are resolved.
+type gqlLoader struct {
+	b        *BusinessLogic
+	mutex    sync.Mutex
+	plans    map[string]*ProviderPlan
+	supports []EngineSupport
+}
+
+func gqlLoaderFrom(ctx context.Context) *gqlLoader {
+	return ctx.Value(gqlLoaderKey{}).(*gqlLoader)
+}
+
+func (l *gqlLoader) plan(id string) (*ProviderPlan, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if plan, ok := l.plans[id]; ok {
+		return plan, nil
+	}
+	plan, err := l.b.storage.GetPlanByID(id)
+	if err != nil {
+		return nil, err
+	}
+	l.plans[id] = plan
+	return plan, nil
+}
+
+func (l *gqlLoader) engineSupports() ([]EngineSupport, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.supports != nil {
+		return l.supports, nil
+	}
+	supports, err := l.b.storage.GetEngineSupports()
+	if err != nil {
+		return nil, err
+	}
+	l.supports = supports
+	return supports, nil
+}
+
+func graphQLError(w http.ResponseWriter, status int, message string) {
+	HttpWrite(w, status, graphql.Response{Errors: []*gqlerrors.QueryError{{Message: message}}})
+}
+
+// RouteGraphQL adds POST (or GET with ?query=) /v2/graphql if GRAPHQL_API is true, requests
+// must have the admin token and user (see adminActor).
+func (b *BusinessLogic) RouteGraphQL(router *mux.Router) {
+	if os.Getenv("GRAPHQL_API") != "true" {
+		return
+	}
+	schema := graphql.MustParseSchema(graphQLSchema, &gqlQuery{},
+		graphql.UseFieldResolvers(),
+		graphql.MaxDepth(gqlMaxDepth),
+		graphql.MaxParallelism(gqlMaxParallelism))
+	router.HandleFunc("/v2/graphql", func(w http.ResponseWriter, r *http.Request) {
+		actor, ok := adminActor(&broker.RequestContext{Request: r})
+		if !ok {
+			glog.Infof("Rejected a GraphQL query without a valid admin token and user\n")
+			graphQLError(w, http.StatusForbidden, "A valid x-admin-token and x-admin-user are required.")
+			return
+		}
+		var request GraphQLRequest
+		if r.Method == "GET" {
+			request.Query = r.URL.Query().Get("query")
+			request.OperationName = r.URL.Query().Get("operationName")
+			if r.URL.Query().Get("variables") != "" {
+				if err := json.Unmarshal([]byte(r.URL.Query().Get("variables")), &request.Variables); err != nil {
+					graphQLError(w, http.StatusBadRequest, "The variables are not valid json")
+					return
+				}
+			}
+		} else if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			graphQLError(w, http.StatusBadRequest, "The body must be {\"query\":\"...\", \"variables\":{...}}")
+			return
+		}
+		ctx := context.WithValue(r.Context(), gqlLoaderKey{}, &gqlLoader{b: b, plans: make(map[string]*ProviderPlan)})
+		response := schema.Exec(ctx, request.Query, request.OperationName, request.Variables)
+		if len(response.Errors) > 0 {
+			glog.Infof("GraphQL query by %s failed: %s\n", actor, response.Errors[0].Error())
+		}
+		HttpWrite(w, http.StatusOK, response)
+	}).Methods("GET", "POST")
+}
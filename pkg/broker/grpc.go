@@ -0,0 +1,340 @@
+package broker
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/akkeris/elasticsearch-broker/pkg/brokerpb"
+)
+
+// The internal gRPC api, defined in proto/broker.proto (the messages and service in
+// pkg/brokerpb are generated from it). It is served over TLS with grpc-go, every call
+// must have the GRPC_TOKEN as its bearer token.
+
+// grpcServer implements the service with the business logic behind the OSB api.
+type grpcServer struct {
+	logic *BusinessLogic
+}
+
+// grpcStatus converts the OSB errors returned by the business logic to a grpc status.
+func grpcStatus(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	httpErr, ok := osb.IsHTTPError(err)
+	if !ok {
+		return status.Error(codes.Internal, err.Error())
+	}
+	message := "Internal Server Error"
+	if httpErr.Description != nil {
+		message = *httpErr.Description
+	} else if httpErr.ErrorMessage != nil {
+		message = *httpErr.ErrorMessage
+	}
+	switch httpErr.StatusCode {
+	case http.StatusBadRequest:
+		return status.Error(codes.InvalidArgument, message)
+	case http.StatusUnauthorized:
+		return status.Error(codes.Unauthenticated, message)
+	case http.StatusForbidden:
+		return status.Error(codes.PermissionDenied, message)
+	case http.StatusNotFound, http.StatusGone:
+		return status.Error(codes.NotFound, message)
+	case http.StatusConflict:
+		return status.Error(codes.AlreadyExists, message)
+	case http.StatusUnprocessableEntity:
+		return status.Error(codes.FailedPrecondition, message)
+	case http.StatusNotImplemented:
+		return status.Error(codes.Unimplemented, message)
+	}
+	return status.Error(codes.Internal, message)
+}
+
+// grpcParameters decodes the json object passed as the parameters of a request.
+func grpcParameters(parameters string) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	if parameters == "" {
+		return values, nil
+	}
+	if err := json.Unmarshal([]byte(parameters), &values); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "The parameters must be a json object.")
+	}
+	return values, nil
+}
+
+// grpcRequestContext gives the business logic the metadata of the call as the headers of
+// a request, e.g., the originating identity.
+func grpcRequestContext(ctx context.Context, method string) *broker.RequestContext {
+	r, _ := http.NewRequest("POST", "/"+method, nil)
+	r = r.WithContext(ctx)
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for name, values := range md {
+			for _, value := range values {
+				r.Header.Add(name, value)
+			}
+		}
+	}
+	return &broker.RequestContext{Request: r}
+}
+
+func operationResponse(async bool, operation *osb.OperationKey) *brokerpb.OperationResponse {
+	response := &brokerpb.OperationResponse{Async: async}
+	if operation != nil {
+		response.Operation = string(*operation)
+	}
+	return response
+}
+
+func grpcInstance(i InstanceSummary, supports []EngineSupport) *brokerpb.Instance {
+	support := GetEngineSupportStatus(supports, i.Engine, i.EngineVersion, time.Now())
+	return &brokerpb.Instance{
+		Id:                       i.Id,
+		Name:                     i.Name,
+		PlanId:                   i.PlanId,
+		Plan:                     i.Plan,
+		Engine:                   i.Engine,
+		EngineVersion:            i.EngineVersion,
+		Status:                   i.Status,
+		Owner:                    i.Owner,
+		Created:                  i.Created.Unix(),
+		Updated:                  i.Updated.Unix(),
+		EngineSupport:            support.Status,
+		EngineSupportDescription: support.Description(),
+		Labels:                   i.Labels,
+	}
+}
+
+func (s *grpcServer) GetCatalog(ctx context.Context, r *brokerpb.GetCatalogRequest) (*brokerpb.GetCatalogResponse, error) {
+	catalog, err := s.logic.GetCatalog(grpcRequestContext(ctx, "GetCatalog"))
+	if err != nil {
+		glog.Errorf("Unable to get the catalog (grpc): %s\n", err.Error())
+		return nil, grpcStatus(InternalServerError())
+	}
+	response := &brokerpb.GetCatalogResponse{Plans: make([]*brokerpb.Plan, 0)}
+	for _, service := range catalog.Services {
+		for _, plan := range service.Plans {
+			response.Plans = append(response.Plans, &brokerpb.Plan{Id: plan.ID, Name: plan.Name, Description: plan.Description, ServiceId: service.ID, ServiceName: service.Name})
+		}
+	}
+	return response, nil
+}
+
+func (s *grpcServer) Provision(ctx context.Context, r *brokerpb.ProvisionRequest) (*brokerpb.OperationResponse, error) {
+	parameters, err := grpcParameters(r.Parameters)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.logic.Provision(&osb.ProvisionRequest{
+		InstanceID:        r.InstanceId,
+		AcceptsIncomplete: true,
+		ServiceID:         r.ServiceId,
+		PlanID:            r.PlanId,
+		OrganizationGUID:  r.OrganizationGuid,
+		Parameters:        parameters,
+	}, grpcRequestContext(ctx, "Provision"))
+	if err != nil {
+		return nil, grpcStatus(err)
+	}
+	return operationResponse(resp.Async, resp.OperationKey), nil
+}
+
+func (s *grpcServer) Update(ctx context.Context, r *brokerpb.UpdateRequest) (*brokerpb.OperationResponse, error) {
+	parameters, err := grpcParameters(r.Parameters)
+	if err != nil {
+		return nil, err
+	}
+	var planId *string
+	if r.PlanId != "" {
+		planId = &r.PlanId
+	}
+	resp, err := s.logic.Update(&osb.UpdateInstanceRequest{
+		InstanceID:        r.InstanceId,
+		AcceptsIncomplete: true,
+		ServiceID:         r.ServiceId,
+		PlanID:            planId,
+		Parameters:        parameters,
+	}, grpcRequestContext(ctx, "Update"))
+	if err != nil {
+		return nil, grpcStatus(err)
+	}
+	return operationResponse(resp.Async, resp.OperationKey), nil
+}
+
+func (s *grpcServer) Deprovision(ctx context.Context, r *brokerpb.DeprovisionRequest) (*brokerpb.OperationResponse, error) {
+	resp, err := s.logic.Deprovision(&osb.DeprovisionRequest{
+		InstanceID:        r.InstanceId,
+		AcceptsIncomplete: true,
+		ServiceID:         r.ServiceId,
+		PlanID:            r.PlanId,
+	}, grpcRequestContext(ctx, "Deprovision"))
+	if err != nil {
+		return nil, grpcStatus(err)
+	}
+	return operationResponse(resp.Async, resp.OperationKey), nil
+}
+
+func (s *grpcServer) LastOperation(ctx context.Context, r *brokerpb.LastOperationRequest) (*brokerpb.LastOperationResponse, error) {
+	var operation *osb.OperationKey
+	if r.Operation != "" {
+		key := osb.OperationKey(r.Operation)
+		operation = &key
+	}
+	resp, err := s.logic.LastOperation(&osb.LastOperationRequest{InstanceID: r.InstanceId, OperationKey: operation}, grpcRequestContext(ctx, "LastOperation"))
+	if err != nil {
+		return nil, grpcStatus(err)
+	}
+	response := &brokerpb.LastOperationResponse{State: string(resp.State)}
+	if resp.Description != nil {
+		response.Description = *resp.Description
+	}
+	return response, nil
+}
+
+func (s *grpcServer) GetInstance(ctx context.Context, r *brokerpb.GetInstanceRequest) (*brokerpb.Instance, error) {
+	q := &ListQuery{Limit: 1, Sort: instancesListSpec.DefaultSort, Filters: map[string]string{"id": r.InstanceId}}
+	instances, _, err := s.logic.storage.ListInstances(q)
+	if err != nil {
+		glog.Errorf("Unable to get instance %s (grpc): %s\n", r.InstanceId, err.Error())
+		return nil, grpcStatus(InternalServerError())
+	}
+	if len(instances) == 0 {
+		return nil, grpcStatus(NotFound())
+	}
+	supports, err := s.logic.storage.GetEngineSupports()
+	if err != nil {
+		glog.Errorf("Unable to get engine versions (grpc): %s\n", err.Error())
+		return nil, grpcStatus(InternalServerError())
+	}
+	return grpcInstance(instances[0], supports), nil
+}
+
+func (s *grpcServer) ListInstances(ctx context.Context, r *brokerpb.ListInstancesRequest) (*brokerpb.ListInstancesResponse, error) {
+	values := url.Values{}
+	for name, value := range map[string]string{"plan": r.Plan, "status": r.Status, "owner": r.Owner, "engine": r.Engine, "sort": r.Sort, "order": r.Order, "cursor": r.Cursor} {
+		if value != "" {
+			values.Set(name, value)
+		}
+	}
+	if r.Limit != 0 {
+		values.Set("limit", strconv.Itoa(int(r.Limit)))
+	}
+	for _, label := range r.Labels {
+		values.Add("label", label)
+	}
+	q, err := ParseListQuery(values, instancesListSpec)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	instances, next, err := s.logic.storage.ListInstances(q)
+	if err != nil {
+		glog.Errorf("Unable to list instances (grpc): %s\n", err.Error())
+		return nil, grpcStatus(InternalServerError())
+	}
+	supports, err := s.logic.storage.GetEngineSupports()
+	if err != nil {
+		glog.Errorf("Unable to get engine versions (grpc): %s\n", err.Error())
+		return nil, grpcStatus(InternalServerError())
+	}
+	response := &brokerpb.ListInstancesResponse{Instances: make([]*brokerpb.Instance, 0), NextCursor: next}
+	for _, instance := range instances {
+		response.Instances = append(response.Instances, grpcInstance(instance, supports))
+	}
+	return response, nil
+}
+
+func (s *grpcServer) Bind(ctx context.Context, r *brokerpb.BindRequest) (*brokerpb.BindResponse, error) {
+	parameters, err := grpcParameters(r.Parameters)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.logic.Bind(&osb.BindRequest{
+		InstanceID: r.InstanceId,
+		BindingID:  r.BindingId,
+		ServiceID:  r.ServiceId,
+		PlanID:     r.PlanId,
+		Parameters: parameters,
+	}, grpcRequestContext(ctx, "Bind"))
+	if err != nil {
+		return nil, grpcStatus(err)
+	}
+	response := &brokerpb.BindResponse{Credentials: make(map[string]string)}
+	for name, value := range resp.Credentials {
+		if s, ok := value.(string); ok {
+			response.Credentials[name] = s
+		} else if data, err := json.Marshal(value); err == nil {
+			response.Credentials[name] = string(data)
+		} else {
+			response.Credentials[name] = fmt.Sprint(value)
+		}
+	}
+	return response, nil
+}
+
+func (s *grpcServer) Unbind(ctx context.Context, r *brokerpb.UnbindRequest) (*brokerpb.OperationResponse, error) {
+	resp, err := s.logic.Unbind(&osb.UnbindRequest{
+		InstanceID: r.InstanceId,
+		BindingID:  r.BindingId,
+		ServiceID:  r.ServiceId,
+		PlanID:     r.PlanId,
+	}, grpcRequestContext(ctx, "Unbind"))
+	if err != nil {
+		return nil, grpcStatus(err)
+	}
+	return operationResponse(resp.Async, resp.OperationKey), nil
+}
+
+// grpcAuthenticate requires GRPC_TOKEN as the bearer token in the authorization metadata
+// of every call.
+func grpcAuthenticate(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	token := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("authorization")) > 0 {
+		token = strings.TrimSpace(strings.TrimPrefix(md.Get("authorization")[0], "Bearer"))
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(os.Getenv("GRPC_TOKEN"))) != 1 {
+		return nil, status.Error(codes.Unauthenticated, "A valid bearer token is required.")
+	}
+	return handler(ctx, req)
+}
+
+// RunGRPC serves the internal grpc api on addr over TLS with the certificate and key in
+// GRPC_TLS_CERT_FILE and GRPC_TLS_KEY_FILE, GRPC_TOKEN must be set and is required as the
+// bearer token of every call.
+func (b *BusinessLogic) RunGRPC(addr string) error {
+	if os.Getenv("GRPC_TOKEN") == "" {
+		return errors.New("GRPC_TOKEN must be set to run the grpc api")
+	}
+	if os.Getenv("GRPC_TLS_CERT_FILE") == "" || os.Getenv("GRPC_TLS_KEY_FILE") == "" {
+		return errors.New("GRPC_TLS_CERT_FILE and GRPC_TLS_KEY_FILE must be set to run the grpc api")
+	}
+	creds, err := credentials.NewServerTLSFromFile(os.Getenv("GRPC_TLS_CERT_FILE"), os.Getenv("GRPC_TLS_KEY_FILE"))
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	server := grpc.NewServer(grpc.Creds(creds), grpc.UnaryInterceptor(grpcAuthenticate))
+	brokerpb.RegisterBrokerServer(server, &grpcServer{logic: b})
+	glog.Infof("Starting grpc api on %s\n", addr)
+	return server.Serve(listener)
+}
@@ -0,0 +1,103 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// embeddablePaths are the endpoints platform UIs may call from the browser (e.g., to embed
+// instance health widgets), fetching an instance, its actions and the kibana proxy. Only
+// GET requests from the allowed origins are allowed by CORS.
+var embeddablePaths = regexp.MustCompile(`^(/v2/service_instances/[^/]+(/actions/.*)?|/kibana/.*)$`)
+
+// ResponsePolicy is the CORS and response header policy of the embeddable endpoints, it is
+// set with CORS_ALLOWED_ORIGINS (comma separated, or *), CORS_ALLOWED_HEADERS, CORS_MAX_AGE
+// and RESPONSE_HEADERS (a json object of headers added to every response, e.g., a
+// Content-Security-Policy with frame-ancestors).
+type ResponsePolicy struct {
+	Origins        []string
+	AllowedHeaders string
+	MaxAge         int
+	Headers        map[string]string
+}
+
+func NewResponsePolicy() (*ResponsePolicy, error) {
+	policy := &ResponsePolicy{
+		Origins:        make([]string, 0),
+		AllowedHeaders: "Authorization, Content-Type, X-Broker-API-Version",
+		MaxAge:         envInt("CORS_MAX_AGE", 600),
+		Headers:        make(map[string]string),
+	}
+	for _, origin := range strings.Split(os.Getenv("CORS_ALLOWED_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			policy.Origins = append(policy.Origins, strings.TrimSuffix(origin, "/"))
+		}
+	}
+	if os.Getenv("CORS_ALLOWED_HEADERS") != "" {
+		policy.AllowedHeaders = os.Getenv("CORS_ALLOWED_HEADERS")
+	}
+	if os.Getenv("RESPONSE_HEADERS") != "" {
+		if err := json.Unmarshal([]byte(os.Getenv("RESPONSE_HEADERS")), &policy.Headers); err != nil {
+			return nil, errors.New("RESPONSE_HEADERS must be a json object of header names and values: " + err.Error())
+		}
+	}
+	return policy, nil
+}
+
+func (p *ResponsePolicy) allowedOrigin(origin string) string {
+	if origin == "" {
+		return ""
+	}
+	for _, allowed := range p.Origins {
+		if allowed == "*" || allowed == origin {
+			return origin
+		}
+	}
+	return ""
+}
+
+// Middleware adds the response headers and CORS headers to responses of the embeddable
+// endpoints and answers their CORS preflight requests.
+func (p *ResponsePolicy) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !embeddablePaths.MatchString(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for name, value := range p.Headers {
+			w.Header().Set(name, value)
+		}
+		origin := p.allowedOrigin(r.Header.Get("Origin"))
+		if origin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+			w.Header().Add("Vary", "Origin")
+		}
+		if r.Method == http.MethodOptions {
+			if origin != "" && r.Header.Get("Access-Control-Request-Method") == http.MethodGet {
+				w.Header().Set("Access-Control-Allow-Methods", http.MethodGet)
+				w.Header().Set("Access-Control-Allow-Headers", p.AllowedHeaders)
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(p.MaxAge))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Routes adds the policy to the router, preflight requests are routed so the middleware
+// sees them (the embeddable endpoints only route their own methods).
+func (p *ResponsePolicy) Routes(router *mux.Router) {
+	router.Use(p.Middleware)
+	router.PathPrefix("/").Methods(http.MethodOptions).HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+}
@@ -0,0 +1,119 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+type ClusterHealth struct {
+	Status           string `json:"status"`
+	NumberOfNodes    int    `json:"number_of_nodes"`
+	UnassignedShards int    `json:"unassigned_shards"`
+}
+
+type ClusterRed struct {
+	InstanceNotification
+	ClusterHealth
+}
+
+func GetClusterHealth(cluster *ClusterClient, instance *Instance) (*ClusterHealth, error) {
+	response, status, err := cluster.Do(instance, "GET", "/_cluster/health", nil)
+	if err != nil {
+		return nil, err
+	}
+	if status != 200 {
+		return nil, errors.New("_cluster/health returned " + strconv.Itoa(status))
+	}
+	var health ClusterHealth
+	if err = json.Unmarshal(response, &health); err != nil {
+		return nil, err
+	}
+	return &health, nil
+}
+
+// endpointUnreachable prefixes the result of a provision whose domain aws reports as
+// available but whose endpoint does not answer.
+const endpointUnreachable = "The endpoint is not reachable"
+
+// CheckReachable verifies the endpoint of a new domain answers GET / before its provision is
+// reported as succeeded, a security group or subnet the broker (and apps) can't reach
+// otherwise only surfaces once apps use it. Any response other than a server error is an
+// answer, set SKIP_ENDPOINT_CHECK=true if the broker is not in the vpc of the domains. The
+// endpoints of simulated domains never answer, so they are not checked.
+func CheckReachable(cluster *ClusterClient, instance *Instance) error {
+	if os.Getenv("SKIP_ENDPOINT_CHECK") == "true" || SimulationMode() {
+		return nil
+	}
+	_, status, err := cluster.Do(instance, "GET", "/", nil)
+	if err != nil {
+		return errors.New(endpointUnreachable + " (check the security groups and subnets of the domain): " + err.Error())
+	}
+	if status >= 500 {
+		return errors.New(endpointUnreachable + ", GET / returned " + strconv.Itoa(status))
+	}
+	return nil
+}
+
+// GET /v2/service_instances/{instance_id}/actions/health
+func (b *BusinessLogic) HealthAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	Instance, err := b.GetInstanceById(InstanceID)
+	if err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Error finding instance id (during health): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	if !IsAvailable(Instance.Status) {
+		return nil, UnprocessableEntityWithMessage("ServiceNotYetAvailable", "The service requested is not yet available.")
+	}
+	health, err := GetClusterHealth(NewClusterClient(), Instance)
+	if err != nil {
+		glog.Errorf("Unable to get the cluster health of %s: %s\n", Instance.Name, err.Error())
+		return nil, InternalServerError()
+	}
+	return health, nil
+}
+
+// CheckClusterHealth emails the contacts of any instance whose cluster is red, at most
+// once a day per instance.
+func CheckClusterHealth(namePrefix string, storage Storage, cluster *ClusterClient) {
+	if !smtpConfigured() {
+		return
+	}
+	entries, err := storage.GetInstances()
+	if err != nil {
+		glog.Errorf("Unable to check cluster health, cannot get instances: %s\n", err.Error())
+		return
+	}
+	for _, entry := range entries {
+		if !IsAvailable(entry.Status) {
+			continue
+		}
+		instance, err := GetInstanceById(namePrefix, storage, entry.Id)
+		if err != nil {
+			glog.Errorf("Unable to check the cluster health of %s: %s\n", entry.Name, err.Error())
+			continue
+		}
+		health, err := GetClusterHealth(cluster, instance)
+		if err != nil {
+			glog.Errorf("Unable to check the cluster health of %s: %s\n", instance.Name, err.Error())
+			continue
+		}
+		if health.Status != "red" {
+			continue
+		}
+		if claimed, err := storage.ClaimSchedule("cluster-red-"+instance.Id, time.Hour*24); err != nil || !claimed {
+			continue
+		}
+		glog.Infof("Warning: %s is red with %d unassigned shards\n", instance.Name, health.UnassignedShards)
+		if err = EmailContacts(storage, ClusterRedNotification, instance.Id, ClusterRed{InstanceNotification: newInstanceNotification(instance), ClusterHealth: *health}); err != nil {
+			glog.Errorf("Unable to email the contacts of %s: %s\n", instance.Name, err.Error())
+		}
+	}
+}
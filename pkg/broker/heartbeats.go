@@ -0,0 +1,170 @@
+package broker
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// The background loops (the reconciler in the api, the task worker, the preprovisioner, the
+// metrics collector and the snapshot export schedule in the worker) record a heartbeat each
+// time they run, with how often they are expected to run and how much work is waiting. A loop
+// that has not succeeded for HEARTBEAT_STALE_INTERVALS (default 3) of its intervals, or never
+// ran, is stale. Loops that are turned off (e.g., COLLECT_METRICS_INTERVAL is 0) record an
+// interval of 0 and are never stale. GET /v2/admin/background reports the loops (503 if any is stale) so a loop
+// that died or keeps failing without anyone noticing can be alerted on, and the heartbeats
+// are exported with the other metrics.
+
+const (
+	ReconcilerLoop       = "reconciler"
+	TaskWorkerLoop       = "task-worker"
+	PreprovisionerLoop   = "preprovisioner"
+	MetricsCollectorLoop = "metrics-collector"
+	SnapshotExportsLoop  = "snapshot-exports"
+)
+
+var backgroundLoops = []string{ReconcilerLoop, TaskWorkerLoop, PreprovisionerLoop, MetricsCollectorLoop, SnapshotExportsLoop}
+
+type Heartbeat struct {
+	Name string `json:"name"`
+	// Seconds between runs the loop expects.
+	Interval    int        `json:"interval"`
+	LastRun     *time.Time `json:"last_run"`
+	LastSuccess *time.Time `json:"last_success"`
+	LastError   string     `json:"last_error,omitempty"`
+	Backlog     int        `json:"backlog"`
+	Stale       bool       `json:"stale"`
+}
+
+type BackgroundStatus struct {
+	Healthy bool        `json:"healthy"`
+	Loops   []Heartbeat `json:"loops"`
+}
+
+// RecordHeartbeat records a run of a background loop, err is the reason the run failed.
+func RecordHeartbeat(storage Storage, name string, interval time.Duration, err error, backlog int) {
+	failure := ""
+	if err != nil {
+		failure = err.Error()
+	}
+	if err = storage.RecordHeartbeat(name, interval, failure, backlog); err != nil {
+		glog.Errorf("Unable to record the heartbeat of the %s: %s\n", name, err.Error())
+	}
+}
+
+func (h *Heartbeat) stale(now time.Time, intervals int) bool {
+	if h.LastSuccess == nil {
+		return true
+	}
+	if h.Interval == 0 {
+		return false
+	}
+	return now.Sub(*h.LastSuccess) > time.Duration(intervals*h.Interval)*time.Second
+}
+
+// GetBackgroundStatus returns the heartbeats of every background loop, loops that never ran
+// are included without one.
+func GetBackgroundStatus(storage Storage) (*BackgroundStatus, error) {
+	heartbeats, err := storage.GetHeartbeats()
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]Heartbeat)
+	for _, heartbeat := range heartbeats {
+		byName[heartbeat.Name] = heartbeat
+	}
+	intervals := envInt("HEARTBEAT_STALE_INTERVALS", 3)
+	now := time.Now()
+	status := BackgroundStatus{Healthy: true, Loops: make([]Heartbeat, 0)}
+	for _, name := range backgroundLoops {
+		heartbeat, ok := byName[name]
+		if !ok {
+			heartbeat = Heartbeat{Name: name}
+		}
+		heartbeat.Stale = heartbeat.stale(now, intervals)
+		if heartbeat.Stale {
+			status.Healthy = false
+		}
+		status.Loops = append(status.Loops, heartbeat)
+	}
+	return &status, nil
+}
+
+// HeartbeatMetrics exports the heartbeats when the metrics are scraped, as the worker loops
+// run in another process.
+type HeartbeatMetrics struct {
+	storage     Storage
+	lastRun     *prom.Desc
+	lastSuccess *prom.Desc
+	backlog     *prom.Desc
+	stale       *prom.Desc
+}
+
+func NewHeartbeatMetrics(storage Storage) *HeartbeatMetrics {
+	return &HeartbeatMetrics{
+		storage:     storage,
+		lastRun:     prom.NewDesc("elasticsearch_broker_background_last_run_timestamp_seconds", "When the background loop last ran.", []string{"loop"}, nil),
+		lastSuccess: prom.NewDesc("elasticsearch_broker_background_last_success_timestamp_seconds", "When the background loop last ran successfully.", []string{"loop"}, nil),
+		backlog:     prom.NewDesc("elasticsearch_broker_background_backlog", "The work waiting on the background loop, e.g., pending tasks.", []string{"loop"}, nil),
+		stale:       prom.NewDesc("elasticsearch_broker_background_stale", "1 if the background loop has not succeeded recently.", []string{"loop"}, nil),
+	}
+}
+
+func (m *HeartbeatMetrics) Describe(ch chan<- *prom.Desc) {
+	ch <- m.lastRun
+	ch <- m.lastSuccess
+	ch <- m.backlog
+	ch <- m.stale
+}
+
+func (m *HeartbeatMetrics) Collect(ch chan<- prom.Metric) {
+	status, err := GetBackgroundStatus(m.storage)
+	if err != nil {
+		glog.Errorf("Unable to get the heartbeats for the metrics: %s\n", err.Error())
+		return
+	}
+	for _, heartbeat := range status.Loops {
+		if heartbeat.LastRun != nil {
+			ch <- prom.MustNewConstMetric(m.lastRun, prom.GaugeValue, float64(heartbeat.LastRun.Unix()), heartbeat.Name)
+		}
+		if heartbeat.LastSuccess != nil {
+			ch <- prom.MustNewConstMetric(m.lastSuccess, prom.GaugeValue, float64(heartbeat.LastSuccess.Unix()), heartbeat.Name)
+		}
+		ch <- prom.MustNewConstMetric(m.backlog, prom.GaugeValue, float64(heartbeat.Backlog), heartbeat.Name)
+		stale := 0.0
+		if heartbeat.Stale {
+			stale = 1
+		}
+		ch <- prom.MustNewConstMetric(m.stale, prom.GaugeValue, stale, heartbeat.Name)
+	}
+}
+
+func (m *HeartbeatMetrics) Register(reg prom.Registerer) {
+	reg.MustRegister(m)
+}
+
+// RouteBackground adds GET /v2/admin/background (with the admin headers, see adminActor).
+func (b *BusinessLogic) RouteBackground(router *mux.Router) {
+	router.HandleFunc("/v2/admin/background", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := adminActor(&broker.RequestContext{Request: r, Writer: w}); !ok {
+			glog.Infof("Rejected a background status request without a valid admin token and user\n")
+			writeCatalogError(w, Forbidden())
+			return
+		}
+		status, err := GetBackgroundStatus(b.storage)
+		if err != nil {
+			glog.Errorf("Unable to get the heartbeats: %s\n", err.Error())
+			writeCatalogError(w, InternalServerError())
+			return
+		}
+		if !status.Healthy {
+			HttpWrite(w, http.StatusServiceUnavailable, status)
+			return
+		}
+		HttpWrite(w, http.StatusOK, status)
+	}).Methods("GET")
+}
@@ -0,0 +1,332 @@
+package broker
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+type HookStage string
+
+const (
+	PostProvisionHook  HookStage = "post-provision"
+	PreDeprovisionHook HookStage = "pre-deprovision"
+)
+
+type HookType string
+
+const (
+	// A webhook calls out to an arbitrary url (e.g., to register the instance elsewhere)
+	WebhookHook HookType = "webhook"
+	// A rest hook calls a path on the newly created cluster (e.g., /_snapshot/repo)
+	RestHook HookType = "rest"
+)
+
+// Hooks are registered by operators per plan in the hooks table, the url and
+// body are go templates rendered with HookTemplateData.
+type Hook struct {
+	Id      string
+	PlanId  string
+	Stage   HookStage
+	Type    HookType
+	Method  string
+	Url     string
+	Body    string
+	Secret  string
+	Retries int64
+}
+
+type HookTemplateData struct {
+	Id            string
+	Name          string
+	Endpoint      string
+	Url           string
+	Plan          string
+	EngineVersion string
+	Stage         HookStage
+}
+
+type HookTaskMetadata struct {
+	Hooks []string `json:"hooks"`
+	// The task to schedule once all of the hooks have succeeded.
+	Then         TaskAction `json:"then,omitempty"`
+	ThenMetadata string     `json:"then_metadata,omitempty"`
+}
+
+func hookTaskAction(stage HookStage) TaskAction {
+	if stage == PreDeprovisionHook {
+		return RunPreDeprovisionHookTask
+	}
+	return RunHookTask
+}
+
+func renderHookTemplate(name string, text string, data HookTemplateData) (string, error) {
+	t, err := template.New(name).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err = t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// RunHook executes the hook against the instance and returns a short description
+// of the result suitable for recording on the task.
+func RunHook(cluster *ClusterClient, hook *Hook, instance *Instance) (string, error) {
+	data := HookTemplateData{
+		Id:            instance.Id,
+		Name:          instance.Name,
+		Endpoint:      instance.Endpoint,
+		Url:           instance.Scheme + "://" + instance.Endpoint,
+		Plan:          instance.Plan.ID,
+		EngineVersion: instance.EngineVersion,
+		Stage:         hook.Stage,
+	}
+	url, err := renderHookTemplate("url", hook.Url, data)
+	if err != nil {
+		return "", err
+	}
+	body, err := renderHookTemplate("body", hook.Body, data)
+	if err != nil {
+		return "", err
+	}
+	if hook.Type == WebhookHook && body == "" {
+		byteData, err := json.Marshal(data)
+		if err != nil {
+			return "", err
+		}
+		body = string(byteData)
+	}
+
+	var status int
+	var response []byte
+	if hook.Type == RestHook {
+		response, status, err = cluster.Do(instance, hook.Method, url, []byte(body))
+		if err != nil {
+			return "", err
+		}
+	} else if hook.Type == WebhookHook {
+		req, err := http.NewRequest(hook.Method, url, bytes.NewReader([]byte(body)))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Add("content-type", "application/json")
+		if hook.Secret != "" {
+			h := hmac.New(sha256.New, []byte(hook.Secret))
+			h.Write([]byte(body))
+			req.Header.Add("x-osb-signature", base64.StdEncoding.EncodeToString(h.Sum(nil)))
+		}
+		client := &http.Client{Timeout: time.Second * 60}
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		status = resp.StatusCode
+		response, _ = ioutil.ReadAll(resp.Body)
+	} else {
+		return "", errors.New("Unknown hook type: " + string(hook.Type))
+	}
+
+	if len(response) > 1024 {
+		response = response[0:1024]
+	}
+	result := strconv.Itoa(status) + " " + string(response)
+	if status < 200 || status > 399 {
+		return result, errors.New("Hook returned an invalid status code: " + result)
+	}
+	return result, nil
+}
+
+// ScheduleHooks queues the hooks for the instances plan at the given stage, they are
+// executed in order by the task worker, each waiting for the previous to succeed.
+func ScheduleHooks(storage Storage, instance *Instance, stage HookStage) error {
+	_, err := ScheduleHooksThen(storage, instance, stage, "", "")
+	return err
+}
+
+// ScheduleHooksThen is the same as ScheduleHooks but queues the task then once all the
+// hooks have succeeded, it returns the id of the task running the hooks. If no hooks exist
+// for the stage an empty id is returned and nothing is scheduled.
+func ScheduleHooksThen(storage Storage, instance *Instance, stage HookStage, then TaskAction, thenMetadata string) (string, error) {
+	hooks, err := storage.GetHooks(instance.Plan.ID, stage)
+	if err != nil {
+		return "", err
+	}
+	if len(hooks) == 0 {
+		return "", nil
+	}
+	ids := make([]string, 0)
+	for _, hook := range hooks {
+		ids = append(ids, hook.Id)
+	}
+	byteData, err := json.Marshal(HookTaskMetadata{Hooks: ids, Then: then, ThenMetadata: thenMetadata})
+	if err != nil {
+		return "", err
+	}
+	return storage.AddTask(instance.Id, hookTaskAction(stage), string(byteData))
+}
+
+// SchedulePostProvisionHooks queues the post-provision hooks of an instance once it has been
+// claimed, preprovisioned instances have no owner until then so their hooks are scheduled
+// when they are claimed (see ScheduleClaimedHooks).
+func SchedulePostProvisionHooks(storage Storage, instance *Instance) error {
+	entry, err := storage.GetInstance(instance.Id)
+	if err != nil {
+		return err
+	}
+	if !entry.Claimed {
+		glog.Infof("The post-provision hooks of %s run once it is claimed\n", instance.Name)
+		return nil
+	}
+	return ScheduleHooks(storage, instance, PostProvisionHook)
+}
+
+// ScheduleClaimedHooks queues the post-provision hooks of a preprovisioned instance that was
+// just claimed, unless it is still being provisioned and the provisioning task schedules them.
+func ScheduleClaimedHooks(storage Storage, instance *Instance) error {
+	_, err := storage.GetTaskInProgress(instance.Id, []TaskAction{ResyncFromProviderUntilAvailableTask, PerformPostProvisionTask})
+	if err == nil {
+		return nil
+	} else if err.Error() != "Not found" {
+		return err
+	}
+	return ScheduleHooks(storage, instance, PostProvisionHook)
+}
+
+func RunHookTaskFromQueue(storage Storage, namePrefix string, cluster *ClusterClient, task *Task) {
+	var taskMetaData HookTaskMetadata
+	if err := json.Unmarshal([]byte(task.Metadata), &taskMetaData); err != nil || len(taskMetaData.Hooks) == 0 {
+		FinishedTask(storage, task.Id, task.Retries, "Invalid hook task metadata", "failed")
+		return
+	}
+	hook, err := storage.GetHook(taskMetaData.Hooks[0])
+	if err != nil {
+		FinishedTask(storage, task.Id, task.Retries, "Cannot get hook "+taskMetaData.Hooks[0]+": "+err.Error(), "failed")
+		return
+	}
+	if task.Retries >= hook.Retries {
+		glog.Infof("Retry limit was reached for task: %s %d\n", task.Id, task.Retries)
+		FinishedTask(storage, task.Id, task.Retries, "Hook "+hook.Id+" failed multiple times, the remaining hooks were skipped ("+task.Result+")", "failed")
+		return
+	}
+	instance, err := GetInstanceById(namePrefix, storage, task.ResourceId)
+	if err != nil {
+		UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot get Instance: "+err.Error(), "pending")
+		return
+	}
+	result, err := RunHook(cluster, hook, instance)
+	if err != nil {
+		glog.Infof("Hook %s failed for task: %s, %s\n", hook.Id, task.Id, err.Error())
+		UpdateTaskStatus(storage, task.Id, task.Retries+1, "Hook "+hook.Id+" failed: "+err.Error(), "pending")
+		return
+	}
+	if len(taskMetaData.Hooks) > 1 {
+		taskMetaData.Hooks = taskMetaData.Hooks[1:]
+		byteData, err := json.Marshal(taskMetaData)
+		if err == nil {
+			_, err = storage.AddTask(instance.Id, task.Action, string(byteData))
+		}
+		if err != nil {
+			glog.Errorf("Error: Unable to schedule the remaining hooks for %s: %s\n", instance.Name, err.Error())
+		}
+	} else if taskMetaData.Then != "" {
+		if _, err = storage.AddTask(instance.Id, taskMetaData.Then, taskMetaData.ThenMetadata); err != nil {
+			glog.Errorf("Error: Unable to schedule %s after hooks for %s: %s\n", taskMetaData.Then, instance.Name, err.Error())
+		}
+	}
+	FinishedTask(storage, task.Id, task.Retries, "Hook "+hook.Id+" returned "+result, "finished")
+}
+
+type SkipHooksRequest struct {
+	Reason string `json:"reason"`
+}
+
+type SkipHooksResponse struct {
+	Operation string `json:"operation"`
+}
+
+// POST /v2/service_instances/{instance_id}/actions/skip-hooks with {"reason":"..."} deletes an
+// instance whose deprovision is stuck on a failed pre-deprovision hook without running the
+// hooks. Only operators with the admin token can skip them, platforms then see the next
+// deprovision of the instance in progress.
+func (b *BusinessLogic) SkipHooksAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	actor, ok := adminActor(c)
+	if !ok {
+		glog.Infof("Rejected skipping the hooks of %s without a valid admin token and user\n", InstanceID)
+		return nil, Forbidden()
+	}
+	var request SkipHooksRequest
+	if c.Request.Body == nil {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "The body must be {\"reason\":\"...\"}.")
+	}
+	if err := json.NewDecoder(c.Request.Body).Decode(&request); err != nil {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "The body must be {\"reason\":\"...\"}.")
+	}
+	request.Reason = strings.TrimSpace(request.Reason)
+	if request.Reason == "" {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "A reason is required.")
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	instance, err := b.GetInstanceById(InstanceID)
+	if err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Error finding instance id (during skip hooks): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	if err = b.checkNotFrozen(InstanceID); err != nil {
+		return nil, err
+	}
+	task, err := b.storage.GetLastTask(InstanceID, RunPreDeprovisionHookTask)
+	if err != nil && err.Error() != "Not found" {
+		glog.Errorf("Unable to get the pre-deprovision hooks of %s: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	if err != nil || task.Status != "failed" {
+		return nil, UnprocessableEntityWithMessage("NoFailedHooks", "The instance has no failed pre-deprovision hooks to skip.")
+	}
+	release, err := b.beginOperation(InstanceID, "deprovision", "deprovision")
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	deleting, err := b.storage.IsDeleting(InstanceID)
+	if err != nil {
+		glog.Errorf("Unable to determine if instance is being deleted (IsDeleting failed): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	if deleting {
+		return nil, ConflictErrorWithMessage("The instance is already being deleted.")
+	}
+	provider, err := GetProviderByPlan(b.namePrefix, instance.Plan)
+	if err != nil {
+		glog.Errorf("Unable to skip hooks, cannot find provider (GetProviderByPlan failed): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	glog.Infof("%s skipped the pre-deprovision hooks of %s (%s): %s\n", actor, instance.Name, InstanceID, request.Reason)
+	if err = deprovisionUnlessHeld(b.storage, provider, instance); err != nil {
+		glog.Errorf("Error failed to deprovision, the worker will retry: (Id: %s Name: %s) %s\n", instance.Id, instance.Name, err.Error())
+	}
+	if _, err = b.storage.AddTask(instance.Id, DeleteTask, instance.Name); err != nil {
+		glog.Errorf("Error: Unable to schedule delete from provider! (%s): %s\n", instance.Name, err.Error())
+		return nil, InternalServerError()
+	}
+	return SkipHooksResponse{Operation: string(*b.newOperationKey(InstanceID, DeprovisionOperation))}, nil
+}
@@ -22,6 +22,9 @@ type Instance struct {
 	Engine        string        `json:"engine"`
 	EngineVersion string        `json:"engine_version"`
 	Scheme        string        `json:"scheme"`
+	Owner         string        `json:"owner"`
+	// The settings the owner changed with an update, see InstanceParameters.
+	Parameters    *InstanceParameters `json:"parameters,omitempty"`
 }
 
 type Entry struct {
@@ -34,6 +37,8 @@ type Entry struct {
 	Username string
 	Password string
 	Endpoint string
+	Owner    string
+	Parameters string
 }
 
 func (i *Instance) Match(other *Instance) bool {
@@ -56,9 +61,9 @@ func IsAvailable(status string) bool {
 }
 
 func InProgress(status string) bool {
-	return status == "upgrading" || status == "creating"
+	return status == "upgrading" || status == "creating" || status == "processing" || status == "deleting"
 }
 
 func CanGetBindings(status string) bool {
-	return status != "deleted" && status != "creating"
+	return status != "deleted" && status != "deleting" && status != "creating"
 }
\ No newline at end of file
@@ -0,0 +1,417 @@
+package broker
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+)
+
+const kibanaSessionCookie = "es_broker_session"
+const kibanaInstanceCookie = "es_broker_instance"
+const kibanaStateCookie = "es_broker_state"
+const kibanaBasePath = "/_plugin/kibana/"
+const dashboardsBasePath = "/_dashboards/"
+
+// KibanaProxy is an authenticated reverse proxy that sits in front of the kibana
+// plugin of VPC-only domains. Users authenticate with the platform SSO (OAuth2
+// authorization code flow), and requests to the domain are signed by the ClusterClient
+// so the domain never needs to be reachable from outside the VPC. As those requests have
+// the brokers access to the domain, users may only open the instances of owners they are
+// (by name or group) or are mapped to in KIBANA_PROXY_ROLES.
+type KibanaProxy struct {
+	namePrefix   string
+	storage      Storage
+	cluster      *ClusterClient
+	secret       []byte
+	baseUrl      string
+	authorizeUrl string
+	tokenUrl     string
+	userUrl      string
+	clientId     string
+	clientSecret string
+	// the users and groups (besides the owner itself) that may open the instances of an owner,
+	// those of "*" may open any instance
+	roles map[string][]string
+}
+
+type kibanaSession struct {
+	User    string   `json:"user"`
+	Groups  []string `json:"groups,omitempty"`
+	Expires int64    `json:"expires"`
+}
+
+func NewKibanaProxy(namePrefix string, storage Storage) (*KibanaProxy, error) {
+	if os.Getenv("KIBANA_PROXY_SECRET") == "" {
+		return nil, errors.New("Unable to find KIBANA_PROXY_SECRET environment variable.")
+	}
+	if os.Getenv("KIBANA_PROXY_URL") == "" {
+		return nil, errors.New("Unable to find KIBANA_PROXY_URL environment variable.")
+	}
+	if os.Getenv("SSO_AUTHORIZE_URL") == "" || os.Getenv("SSO_TOKEN_URL") == "" || os.Getenv("SSO_USER_URL") == "" {
+		return nil, errors.New("The SSO_AUTHORIZE_URL, SSO_TOKEN_URL and SSO_USER_URL environment variables must be set to use the kibana proxy.")
+	}
+	if os.Getenv("SSO_CLIENT_ID") == "" || os.Getenv("SSO_CLIENT_SECRET") == "" {
+		return nil, errors.New("The SSO_CLIENT_ID and SSO_CLIENT_SECRET environment variables must be set to use the kibana proxy.")
+	}
+	roles := make(map[string][]string)
+	if os.Getenv("KIBANA_PROXY_ROLES") != "" {
+		if err := json.Unmarshal([]byte(os.Getenv("KIBANA_PROXY_ROLES")), &roles); err != nil {
+			return nil, errors.New("The KIBANA_PROXY_ROLES environment variable is not a json object of owners to users and groups: " + err.Error())
+		}
+	}
+	return &KibanaProxy{
+		namePrefix:   namePrefix,
+		storage:      storage,
+		cluster:      NewClusterClient(),
+		secret:       []byte(os.Getenv("KIBANA_PROXY_SECRET")),
+		baseUrl:      strings.TrimSuffix(os.Getenv("KIBANA_PROXY_URL"), "/"),
+		authorizeUrl: os.Getenv("SSO_AUTHORIZE_URL"),
+		tokenUrl:     os.Getenv("SSO_TOKEN_URL"),
+		userUrl:      os.Getenv("SSO_USER_URL"),
+		clientId:     os.Getenv("SSO_CLIENT_ID"),
+		clientSecret: os.Getenv("SSO_CLIENT_SECRET"),
+		roles:        roles,
+	}, nil
+}
+
+func (p *KibanaProxy) sign(value string) string {
+	h := hmac.New(sha256.New, p.secret)
+	h.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString([]byte(value)) + "." + base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+func (p *KibanaProxy) verify(signed string) (string, error) {
+	parts := strings.Split(signed, ".")
+	if len(parts) != 2 {
+		return "", errors.New("Invalid signed value")
+	}
+	value, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+	h := hmac.New(sha256.New, p.secret)
+	h.Write(value)
+	if !hmac.Equal(sig, h.Sum(nil)) {
+		return "", errors.New("Invalid signature")
+	}
+	return string(value), nil
+}
+
+func (p *KibanaProxy) getSession(r *http.Request) (*kibanaSession, error) {
+	cookie, err := r.Cookie(kibanaSessionCookie)
+	if err != nil {
+		return nil, err
+	}
+	value, err := p.verify(cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+	var s kibanaSession
+	if err = json.Unmarshal([]byte(value), &s); err != nil {
+		return nil, err
+	}
+	if time.Now().Unix() > s.Expires {
+		return nil, errors.New("Session expired")
+	}
+	return &s, nil
+}
+
+func (p *KibanaProxy) setCookie(w http.ResponseWriter, name string, value string, expires time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(p.baseUrl, "https://"),
+		Expires:  time.Now().Add(expires),
+	})
+}
+
+// authorized is true when the user of the session may open the instances of the owner, as
+// the owner itself, a member of a group named after it or through KIBANA_PROXY_ROLES.
+func (p *KibanaProxy) authorized(s *kibanaSession, owner string) bool {
+	if owner == "" {
+		return p.mapped(s, "*")
+	}
+	identities := append([]string{s.User}, s.Groups...)
+	for _, identity := range identities {
+		if strings.EqualFold(identity, owner) {
+			return true
+		}
+	}
+	return p.mapped(s, owner) || p.mapped(s, "*")
+}
+
+func (p *KibanaProxy) mapped(s *kibanaSession, owner string) bool {
+	identities := append([]string{s.User}, s.Groups...)
+	for _, allowed := range p.roles[owner] {
+		for _, identity := range identities {
+			if strings.EqualFold(identity, allowed) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *KibanaProxy) forbidden(w http.ResponseWriter, s *kibanaSession, instanceId string) {
+	glog.Infof("Kibana proxy denied %s access to %s\n", s.User, instanceId)
+	w.WriteHeader(http.StatusForbidden)
+	w.Write([]byte("Forbidden, you are not allowed to open this instance."))
+}
+
+// Entry point for users, e.g. https://kibana-proxy/kibana/{instance_id}, if the user
+// has no session they're sent to the SSO provider first. The state of the login is tied
+// to the browser with a nonce in a cookie, so a login can't be completed in another browser.
+func (p *KibanaProxy) EnterHandler(w http.ResponseWriter, r *http.Request) {
+	instanceId := mux.Vars(r)["instance_id"]
+	entry, err := p.storage.GetInstance(instanceId)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Not Found"))
+		return
+	}
+	s, err := p.getSession(r)
+	if err != nil {
+		nonce := RandomString(32)
+		p.setCookie(w, kibanaStateCookie, nonce, time.Minute*10)
+		state := p.sign(instanceId + ":" + nonce)
+		http.Redirect(w, r, p.authorizeUrl+"?response_type=code&client_id="+url.QueryEscape(p.clientId)+"&redirect_uri="+url.QueryEscape(p.baseUrl+"/kibana/oauth/callback")+"&state="+url.QueryEscape(state), http.StatusFound)
+		return
+	}
+	if !p.authorized(s, entry.Owner) {
+		p.forbidden(w, s, instanceId)
+		return
+	}
+	p.setCookie(w, kibanaInstanceCookie, p.sign(instanceId), time.Hour*8)
+	http.Redirect(w, r, p.basePath(instanceId), http.StatusFound)
+}
+
+// basePath is where the proxy serves the Kibana (or OpenSearch Dashboards) of the instance.
+func (p *KibanaProxy) basePath(instanceId string) string {
+	entry, err := p.storage.GetInstance(instanceId)
+	if err != nil {
+		return kibanaBasePath
+	}
+	plan, err := p.storage.GetPlanByID(entry.PlanId)
+	if err != nil {
+		glog.Errorf("Kibana proxy unable to get plan %s: %s\n", entry.PlanId, err.Error())
+		return kibanaBasePath
+	}
+	return dashboardsPath(plan) + "/"
+}
+
+// verifyState checks the state of the callback was signed by the proxy and has the nonce
+// of the browser's state cookie, and returns the instance the login was for.
+func (p *KibanaProxy) verifyState(r *http.Request) (string, error) {
+	state, err := p.verify(r.URL.Query().Get("state"))
+	if err != nil {
+		return "", err
+	}
+	parts := strings.SplitN(state, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", errors.New("Invalid state")
+	}
+	cookie, err := r.Cookie(kibanaStateCookie)
+	if err != nil {
+		return "", err
+	}
+	if !hmac.Equal([]byte(cookie.Value), []byte(parts[1])) {
+		return "", errors.New("The state does not match the state cookie")
+	}
+	return parts[0], nil
+}
+
+func (p *KibanaProxy) CallbackHandler(w http.ResponseWriter, r *http.Request) {
+	instanceId, err := p.verifyState(r)
+	if err != nil || r.URL.Query().Get("code") == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Unauthorized"))
+		return
+	}
+	p.setCookie(w, kibanaStateCookie, "", -time.Hour)
+	s, err := p.exchange(r.URL.Query().Get("code"))
+	if err != nil {
+		glog.Errorf("Unable to complete sso login for kibana proxy: %s\n", err.Error())
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Unauthorized"))
+		return
+	}
+	s.Expires = time.Now().Add(time.Hour * 8).Unix()
+	data, err := json.Marshal(s)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	p.setCookie(w, kibanaSessionCookie, p.sign(string(data)), time.Hour*8)
+	glog.Infof("Kibana proxy login for %s\n", s.User)
+	entry, err := p.storage.GetInstance(instanceId)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Not Found"))
+		return
+	}
+	if !p.authorized(s, entry.Owner) {
+		p.forbidden(w, s, instanceId)
+		return
+	}
+	p.setCookie(w, kibanaInstanceCookie, p.sign(instanceId), time.Hour*8)
+	http.Redirect(w, r, p.basePath(instanceId), http.StatusFound)
+}
+
+// Exchanges the authorization code for an access token and returns the user (and the groups
+// of the user) it belongs to.
+func (p *KibanaProxy) exchange(code string) (*kibanaSession, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.baseUrl+"/kibana/oauth/callback")
+	form.Set("client_id", p.clientId)
+	form.Set("client_secret", p.clientSecret)
+	client := &http.Client{Timeout: time.Second * 30}
+	resp, err := client.PostForm(p.tokenUrl, form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("Token exchange returned " + resp.Status)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", p.userUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	uresp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer uresp.Body.Close()
+	if uresp.StatusCode != http.StatusOK {
+		return nil, errors.New("User info returned " + uresp.Status)
+	}
+	var user map[string]interface{}
+	if err = json.NewDecoder(uresp.Body).Decode(&user); err != nil {
+		return nil, err
+	}
+	s := &kibanaSession{Groups: make([]string, 0)}
+	if groups, ok := user["groups"].([]interface{}); ok {
+		for _, group := range groups {
+			if name, ok := group.(string); ok {
+				s.Groups = append(s.Groups, name)
+			}
+		}
+	}
+	for _, key := range []string{"email", "preferred_username", "name", "sub"} {
+		if v, ok := user[key].(string); ok && v != "" {
+			s.User = v
+			return s, nil
+		}
+	}
+	return nil, errors.New("Unable to determine user from sso user info")
+}
+
+func (p *KibanaProxy) ProxyHandler(w http.ResponseWriter, r *http.Request) {
+	s, err := p.getSession(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Unauthorized, open /kibana/{instance_id} to login."))
+		return
+	}
+	cookie, err := r.Cookie(kibanaInstanceCookie)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("No instance selected, open /kibana/{instance_id} to select one."))
+		return
+	}
+	instanceId, err := p.verify(cookie.Value)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid instance selected."))
+		return
+	}
+	instance, err := GetInstanceById(p.namePrefix, p.storage, instanceId)
+	if err != nil {
+		glog.Errorf("Kibana proxy unable to get instance %s: %s\n", instanceId, err.Error())
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Not Found"))
+		return
+	}
+	if !p.authorized(s, instance.Owner) {
+		p.forbidden(w, s, instanceId)
+		return
+	}
+	if !instance.Ready || instance.Endpoint == "" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("The instance is not yet available."))
+		return
+	}
+	glog.V(2).Infof("Kibana proxy %s %s %s for %s\n", instance.Name, r.Method, r.URL.Path, s.User)
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = instance.Scheme
+			req.URL.Host = instance.Endpoint
+			req.Host = instance.Endpoint
+			req.Header.Del("Cookie")
+			req.Header.Del("Authorization")
+			var body []byte
+			if req.Body != nil {
+				body, _ = ioutil.ReadAll(req.Body)
+				req.Body.Close()
+			}
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+			if err := p.cluster.Sign(instance, req, body); err != nil {
+				glog.Errorf("Kibana proxy unable to sign request to %s: %s\n", instance.Name, err.Error())
+			}
+		},
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+func (p *KibanaProxy) Routes(router *mux.Router) {
+	router.HandleFunc("/kibana/oauth/callback", p.CallbackHandler).Methods("GET")
+	router.HandleFunc("/kibana/{instance_id}", p.EnterHandler).Methods("GET")
+	router.PathPrefix(kibanaBasePath).HandlerFunc(p.ProxyHandler)
+	router.PathPrefix(dashboardsBasePath).HandlerFunc(p.ProxyHandler)
+}
+
+// Runs the kibana proxy on its own listener, this is kept separate from the OSB API
+// so that it can be exposed outside of the VPC without exposing the broker.
+func (b *BusinessLogic) RunKibanaProxy(addr string) error {
+	proxy, err := NewKibanaProxy(b.namePrefix, b.storage)
+	if err != nil {
+		return err
+	}
+	policy, err := NewResponsePolicy()
+	if err != nil {
+		return err
+	}
+	router := mux.NewRouter()
+	policy.Routes(router)
+	proxy.Routes(router)
+	glog.Infof("Starting kibana proxy on %s\n", addr)
+	return http.ListenAndServe(addr, router)
+}
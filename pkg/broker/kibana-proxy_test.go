@@ -0,0 +1,95 @@
+package broker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func testKibanaProxy() *KibanaProxy {
+	return &KibanaProxy{
+		secret:  []byte("test-secret"),
+		baseUrl: "https://kibana.example.com",
+		roles: map[string][]string{
+			"search-team": {"oncall", "alice@example.com"},
+			"*":           {"platform-admins"},
+		},
+	}
+}
+
+func TestKibanaProxyAuthorized(t *testing.T) {
+	p := testKibanaProxy()
+	tests := []struct {
+		name    string
+		session kibanaSession
+		owner   string
+		want    bool
+	}{
+		{"owner", kibanaSession{User: "search-team"}, "search-team", true},
+		{"owner any case", kibanaSession{User: "Search-Team"}, "search-team", true},
+		{"group named after owner", kibanaSession{User: "bob@example.com", Groups: []string{"search-team"}}, "search-team", true},
+		{"mapped user", kibanaSession{User: "alice@example.com"}, "search-team", true},
+		{"mapped group", kibanaSession{User: "carol@example.com", Groups: []string{"oncall"}}, "search-team", true},
+		{"mapped to all owners", kibanaSession{User: "dave@example.com", Groups: []string{"platform-admins"}}, "billing", true},
+		{"mapped to another owner", kibanaSession{User: "alice@example.com"}, "billing", false},
+		{"unrelated user", kibanaSession{User: "mallory@example.com", Groups: []string{"guests"}}, "search-team", false},
+		{"no owner", kibanaSession{User: "mallory@example.com"}, "", false},
+		{"no owner with admin", kibanaSession{User: "dave@example.com", Groups: []string{"platform-admins"}}, "", true},
+	}
+	for _, test := range tests {
+		if got := p.authorized(&test.session, test.owner); got != test.want {
+			t.Errorf("%s: authorized(%s, %s) = %v, want %v", test.name, test.session.User, test.owner, got, test.want)
+		}
+	}
+}
+
+func callbackRequest(state string, nonce string) *http.Request {
+	r := httptest.NewRequest("GET", "/kibana/oauth/callback?code=abc&state="+url.QueryEscape(state), nil)
+	if nonce != "" {
+		r.AddCookie(&http.Cookie{Name: kibanaStateCookie, Value: nonce})
+	}
+	return r
+}
+
+func TestKibanaProxyVerifyState(t *testing.T) {
+	p := testKibanaProxy()
+	state := p.sign("instance-1:nonce-1")
+
+	instanceId, err := p.verifyState(callbackRequest(state, "nonce-1"))
+	if err != nil {
+		t.Fatalf("verifyState with the nonce of the browser failed: %s", err.Error())
+	}
+	if instanceId != "instance-1" {
+		t.Errorf("verifyState returned %s, want instance-1", instanceId)
+	}
+
+	tests := []struct {
+		name  string
+		state string
+		nonce string
+	}{
+		{"no state cookie", state, ""},
+		{"another browser", state, "nonce-2"},
+		{"unsigned state", "instance-1:nonce-1", "nonce-1"},
+		{"forged signature", testKibanaProxy().sign("instance-1:nonce-1") + "x", "nonce-1"},
+		{"no nonce", p.sign("instance-1"), "nonce-1"},
+	}
+	for _, test := range tests {
+		if _, err := p.verifyState(callbackRequest(test.state, test.nonce)); err == nil {
+			t.Errorf("%s: verifyState succeeded, want an error", test.name)
+		}
+	}
+}
+
+func TestKibanaProxyCallbackRejectsForeignState(t *testing.T) {
+	p := testKibanaProxy()
+	w := httptest.NewRecorder()
+	p.CallbackHandler(w, callbackRequest(p.sign("instance-1:nonce-1"), "nonce-2"))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("CallbackHandler returned %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Errorf("CallbackHandler set cookies on a rejected login")
+	}
+}
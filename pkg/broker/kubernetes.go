@@ -0,0 +1,125 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// The providers of operators in kubernetes (eck, opensearch-operator) call the kubernetes api
+// directly with the transport client-go configures, so any authentication kubeconfigs support
+// works, and keep the tags of their instances as annotations of their resources.
+
+const kubernetesTagPrefix = "broker.akkeris.io/"
+
+var kubernetesAnnotationInvalid = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// KubernetesError is a Status the kubernetes api failed a request with.
+type KubernetesError struct {
+	Code    int    `json:"code"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+func (e *KubernetesError) Error() string {
+	return "Kubernetes returned " + strconv.Itoa(e.Code) + " (" + e.Reason + "): " + e.Message
+}
+
+func isKubernetesError(err error, reason string) bool {
+	kerr, ok := err.(*KubernetesError)
+	return ok && kerr.Reason == reason
+}
+
+type kubernetesClient struct {
+	host   string
+	client *http.Client
+}
+
+// kubernetesConfig is the config of the cluster in the kubeconfig, or the one the broker runs
+// in (with its service account) if there is none.
+func kubernetesConfig(kubeconfig string) (*rest.Config, error) {
+	if kubeconfig != "" {
+		return clientcmd.BuildConfigFromFlags("", kubeconfig)
+	}
+	return rest.InClusterConfig()
+}
+
+func newKubernetesClient(kubeconfig string) (*kubernetesClient, error) {
+	config, err := kubernetesConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := rest.TransportFor(config)
+	if err != nil {
+		return nil, err
+	}
+	return &kubernetesClient{
+		host:   strings.TrimSuffix(config.Host, "/"),
+		client: &http.Client{Transport: transport, Timeout: time.Second * 60},
+	}, nil
+}
+
+// request calls the kubernetes api and reads its response into output (if not nil).
+func (k *kubernetesClient) request(method string, path string, contentType string, input interface{}, output interface{}) error {
+	var body []byte
+	var err error
+	if input != nil {
+		if body, err = json.Marshal(input); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, k.host+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if input != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		kerr := &KubernetesError{Code: resp.StatusCode, Reason: resp.Status}
+		json.Unmarshal(data, kerr)
+		return kerr
+	}
+	if output == nil {
+		return nil
+	}
+	return json.Unmarshal(data, output)
+}
+
+// kubernetesPath is the path of the resources of a kind in a namespace (of the one with the
+// name if it is given).
+func kubernetesPath(api string, kind string, namespace string, name string) string {
+	path := api + "/namespaces/" + namespace + "/" + kind
+	if name != "" {
+		path = path + "/" + name
+	}
+	return path
+}
+
+// kubernetesAnnotation is the annotation a tag is kept in, characters annotation names can't
+// have are replaced.
+func kubernetesAnnotation(tag string) string {
+	name := kubernetesAnnotationInvalid.ReplaceAllString(tag, "-")
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return kubernetesTagPrefix + name
+}
@@ -0,0 +1,157 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// Labels are arbitrary key values owners attach to an instance, with the provision
+// parameters {"labels":{"team":"search"}} or the labels action. They are mirrored on the
+// domain as tags prefixed with label: and instances can be listed by them.
+
+const labelTagPrefix = "label:"
+
+// aws allows 50 tags on a domain, the rest are left for the tags of the broker.
+const maxLabels = 30
+
+var labelKey = regexp.MustCompile(`^[A-Za-z0-9_./=+\-@]{1,64}$`)
+
+type Labels struct {
+	Labels map[string]string `json:"labels"`
+}
+
+func ValidateLabels(labels map[string]string) error {
+	if len(labels) > maxLabels {
+		return errors.New("An instance can have at most " + strconv.Itoa(maxLabels) + " labels.")
+	}
+	for key, value := range labels {
+		if !labelKey.MatchString(key) {
+			return errors.New("The label " + key + " must be 1 to 64 letters, numbers or _./=+-@.")
+		}
+		if len(value) > 256 || tagValueInvalid.MatchString(value) {
+			return errors.New("The value of the label " + key + " must be at most 256 letters, numbers, spaces or _.:/=+-@.")
+		}
+	}
+	return nil
+}
+
+func ParseLabelParameters(parameters map[string]interface{}) (map[string]string, error) {
+	if parameters == nil || parameters["labels"] == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(parameters["labels"])
+	if err != nil {
+		return nil, err
+	}
+	var labels map[string]string
+	if err = json.Unmarshal(data, &labels); err != nil {
+		return nil, errors.New("The labels must be an object of string keys and values.")
+	}
+	return labels, ValidateLabels(labels)
+}
+
+// TagLabels tags the domain with the labels of the instance, and untags the labels in removed.
+func TagLabels(storage Storage, provider Provider, instance *Instance, removed []string) error {
+	labels, err := storage.GetLabels(instance.Id)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0)
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err = provider.Tag(instance, labelTagPrefix+key, labels[key]); err != nil {
+			return err
+		}
+	}
+	for _, key := range removed {
+		if err = provider.Untag(instance, labelTagPrefix+key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GET /v2/service_instances/{instance_id}/actions/labels
+func (b *BusinessLogic) GetLabelsAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	labels, err := b.storage.GetLabels(InstanceID)
+	if err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Unable to get labels for %s: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	return Labels{Labels: labels}, nil
+}
+
+// PUT /v2/service_instances/{instance_id}/actions/labels with {"labels":{"team":"search"}},
+// the labels replace those of the instance.
+func (b *BusinessLogic) SetLabelsAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	var request Labels
+	if c == nil || c.Request == nil || c.Request.Body == nil {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "The body must be {\"labels\":{...}}.")
+	}
+	if err := json.NewDecoder(c.Request.Body).Decode(&request); err != nil {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "The body must be {\"labels\":{...}}.")
+	}
+	if request.Labels == nil {
+		request.Labels = make(map[string]string)
+	}
+	if err := ValidateLabels(request.Labels); err != nil {
+		return nil, UnprocessableEntityWithMessage("InvalidLabels", err.Error())
+	}
+	instance, err := b.GetInstanceById(InstanceID)
+	if err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Error finding instance id (during set labels): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	previous, err := b.storage.GetLabels(InstanceID)
+	if err != nil {
+		glog.Errorf("Unable to get labels for %s: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	if err = b.storage.SetLabels(InstanceID, request.Labels); err != nil {
+		glog.Errorf("Unable to set labels for %s: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	// a domain that is still being created is tagged by the post provision task.
+	if IsAvailable(instance.Status) {
+		removed := make([]string, 0)
+		for key := range previous {
+			if _, ok := request.Labels[key]; !ok {
+				removed = append(removed, key)
+			}
+		}
+		sort.Strings(removed)
+		if provider, err := GetProviderByPlan(b.namePrefix, instance.Plan); err != nil {
+			glog.Errorf("Unable to tag the labels of %s, cannot get provider: %s\n", instance.Name, err.Error())
+		} else if err = TagLabels(b.storage, provider, instance, removed); err != nil {
+			glog.Errorf("Unable to tag the labels of %s: %s\n", instance.Name, err.Error())
+		}
+	}
+	return request, nil
+}
+
+// parseLabelFilters reads the label filters of a list, ?label=team:search (repeatable).
+func parseLabelFilters(values []string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, value := range values {
+		parts := strings.SplitN(value, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.New("Label filters must be label=key:value.")
+		}
+		labels[parts[0]] = parts[1]
+	}
+	return labels, nil
+}
@@ -0,0 +1,88 @@
+package broker
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// Operators can place the snapshots of an instance under a legal hold. Deleting a domain
+// deletes its automated snapshots, so before the domain of a held instance is deleted (when
+// it is deprovisioned or expires) the worker takes a final snapshot of it into
+// LEGAL_HOLD_SNAPSHOT_REPOSITORY, a repository the broker never deletes snapshots from, and
+// only deletes the domain once it has succeeded. The broker never prunes the snapshots it
+// takes into other repositories either (final, clone and export snapshots). Only operators
+// with the admin token can place or lift a hold.
+
+type LegalHold struct {
+	Held   bool   `json:"held"`
+	Reason string `json:"reason,omitempty"`
+	Actor  string `json:"actor,omitempty"`
+}
+
+var legalHoldToggle = adminToggle{
+	name:     "legal hold",
+	field:    "held",
+	reasons:  "the matter or ticket",
+	onEvent:  LegalHoldEvent,
+	offEvent: LegalHoldLiftedEvent,
+	get: func(storage Storage, InstanceID string) (bool, string, error) {
+		hold, err := storage.GetLegalHold(InstanceID)
+		if err != nil {
+			return false, "", err
+		}
+		return hold.Held, hold.Reason, nil
+	},
+	set: func(storage Storage, InstanceID string, on bool, reason string, actor string) error {
+		return storage.SetLegalHold(InstanceID, &LegalHold{Held: on, Reason: reason, Actor: actor})
+	},
+}
+
+func legalHoldRepository() string {
+	return os.Getenv("LEGAL_HOLD_SNAPSHOT_REPOSITORY")
+}
+
+func legalHoldSnapshotName(instance *Instance) string {
+	return "legal-hold-" + strings.ToLower(instance.Name)
+}
+
+// deprovisionUnlessHeld deletes the domain of the instance unless its snapshots may be under a
+// legal hold, the worker then deletes it once they are preserved (see preserveHeldSnapshots).
+func deprovisionUnlessHeld(storage Storage, provider Provider, instance *Instance) error {
+	held, _, err := legalHoldToggle.get(storage, instance.Id)
+	if err != nil || held {
+		glog.Infof("The domain of %s is deleted by the worker once its snapshots are preserved\n", instance.Name)
+		return nil
+	}
+	return provider.Deprovision(instance, true)
+}
+
+// preserveHeldSnapshots starts (or checks on) the final snapshot of an instance under a legal
+// hold before its domain is deleted, it returns true once the snapshot has succeeded or if the
+// instance is not held.
+func preserveHeldSnapshots(storage Storage, cluster *ClusterClient, instance *Instance) (bool, error) {
+	held, _, err := legalHoldToggle.get(storage, instance.Id)
+	if err != nil {
+		return false, err
+	}
+	if !held {
+		return true, nil
+	}
+	if legalHoldRepository() == "" {
+		return false, errors.New("The snapshots of the instance are under a legal hold, LEGAL_HOLD_SNAPSHOT_REPOSITORY must be set to preserve them")
+	}
+	return finalSnapshot(cluster, instance, legalHoldRepository(), legalHoldSnapshotName(instance))
+}
+
+// GET /v2/service_instances/{instance_id}/actions/legal-hold
+func (b *BusinessLogic) GetLegalHoldAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	return legalHoldToggle.getAction(b.storage, InstanceID)
+}
+
+// PUT /v2/service_instances/{instance_id}/actions/legal-hold with {"held":true, "reason":"..."}
+func (b *BusinessLogic) SetLegalHoldAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	return legalHoldToggle.setAction(b.storage, InstanceID, c)
+}
@@ -0,0 +1,231 @@
+package broker
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elasticsearchservice"
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+type StorageLimits struct {
+	Type    string              `json:"type"`
+	SubType string              `json:"sub_type,omitempty"`
+	Limits  map[string][]string `json:"limits"`
+}
+
+type RoleLimits struct {
+	MinimumInstanceCount int64               `json:"minimum_instance_count"`
+	MaximumInstanceCount int64               `json:"maximum_instance_count"`
+	Storage              []StorageLimits     `json:"storage"`
+	Additional           map[string][]string `json:"additional,omitempty"`
+}
+
+// InstanceTypeLimits are the limits aws places on a domain of an instance type and
+// version, by node role (data or master).
+type InstanceTypeLimits struct {
+	PlanId        string                `json:"plan_id,omitempty"`
+	InstanceType  string                `json:"instance_type"`
+	EngineVersion string                `json:"engine_version"`
+	Roles         map[string]RoleLimits `json:"roles"`
+}
+
+type cachedLimits struct {
+	limits  InstanceTypeLimits
+	fetched time.Time
+}
+
+// Limits rarely change, they are cached so validation and size pickers don't
+// call aws on every request.
+var limitsCache = struct {
+	sync.Mutex
+	entries map[string]cachedLimits
+}{entries: make(map[string]cachedLimits)}
+
+const limitsCacheDuration = time.Hour
+
+func stringValues(values []*string) []string {
+	out := make([]string, 0)
+	for _, value := range values {
+		out = append(out, aws.StringValue(value))
+	}
+	return out
+}
+
+func GetInstanceTypeLimits(instanceType string, version string) (*InstanceTypeLimits, error) {
+	key := instanceType + "/" + version
+	limitsCache.Lock()
+	cached, ok := limitsCache.entries[key]
+	limitsCache.Unlock()
+	if ok && time.Since(cached.fetched) < limitsCacheDuration {
+		limits := cached.limits
+		return &limits, nil
+	}
+
+	svc := newElasticsearchService()
+	res, err := svc.DescribeElasticsearchInstanceTypeLimits(&elasticsearchservice.DescribeElasticsearchInstanceTypeLimitsInput{
+		InstanceType:         aws.String(instanceType),
+		ElasticsearchVersion: aws.String(version),
+	})
+	if err != nil {
+		return nil, err
+	}
+	limits := InstanceTypeLimits{InstanceType: instanceType, EngineVersion: version, Roles: make(map[string]RoleLimits)}
+	for role, roleLimits := range res.LimitsByRole {
+		if roleLimits == nil {
+			continue
+		}
+		r := RoleLimits{Storage: make([]StorageLimits, 0), Additional: make(map[string][]string)}
+		if roleLimits.InstanceLimits != nil && roleLimits.InstanceLimits.InstanceCountLimits != nil {
+			r.MinimumInstanceCount = aws.Int64Value(roleLimits.InstanceLimits.InstanceCountLimits.MinimumInstanceCount)
+			r.MaximumInstanceCount = aws.Int64Value(roleLimits.InstanceLimits.InstanceCountLimits.MaximumInstanceCount)
+		}
+		for _, storage := range roleLimits.StorageTypes {
+			s := StorageLimits{Type: aws.StringValue(storage.StorageTypeName), SubType: aws.StringValue(storage.StorageSubTypeName), Limits: make(map[string][]string)}
+			for _, limit := range storage.StorageTypeLimits {
+				s.Limits[aws.StringValue(limit.LimitName)] = stringValues(limit.LimitValues)
+			}
+			r.Storage = append(r.Storage, s)
+		}
+		for _, limit := range roleLimits.AdditionalLimits {
+			r.Additional[aws.StringValue(limit.LimitName)] = stringValues(limit.LimitValues)
+		}
+		limits.Roles[role] = r
+	}
+
+	limitsCache.Lock()
+	limitsCache.entries[key] = cachedLimits{limits: limits, fetched: time.Now()}
+	limitsCache.Unlock()
+	return &limits, nil
+}
+
+// storageLimit returns a numeric storage limit (e.g., MaximumVolumeSize) for a role
+// and ebs volume type.
+func (l *InstanceTypeLimits) storageLimit(role string, storageType string, subType string, name string) (int64, bool) {
+	r, ok := l.Roles[role]
+	if !ok {
+		return 0, false
+	}
+	for _, storage := range r.Storage {
+		if storage.Type == storageType && (storage.SubType == "" || storage.SubType == subType) {
+			if values, ok := storage.Limits[name]; ok && len(values) > 0 {
+				if value, err := strconv.ParseInt(values[0], 10, 64); err == nil {
+					return value, true
+				}
+			}
+		}
+	}
+	return 0, false
+}
+
+// ValidateLimits checks the data node count and volume size against the limits aws
+// places on the instance type. Only PlanValidationErrors are returned, if the limits
+// cannot be fetched it is logged and the settings are assumed valid.
+func ValidateLimits(settings *elasticsearchservice.CreateElasticsearchDomainInput) error {
+	config := settings.ElasticsearchClusterConfig
+	if config == nil || config.InstanceType == nil || settings.ElasticsearchVersion == nil {
+		return nil
+	}
+	limits, err := GetInstanceTypeLimits(aws.StringValue(config.InstanceType), aws.StringValue(settings.ElasticsearchVersion))
+	if err != nil {
+		glog.Errorf("Unable to get the instance type limits of %s, skipping limit validation: %s\n", aws.StringValue(config.InstanceType), err.Error())
+		return nil
+	}
+	if data, ok := limits.Roles["data"]; ok && config.InstanceCount != nil {
+		count := aws.Int64Value(config.InstanceCount)
+		if data.MinimumInstanceCount > 0 && count < data.MinimumInstanceCount {
+			return invalidPlan("ElasticsearchClusterConfig.InstanceCount", "%s requires at least %d data nodes, not %d", limits.InstanceType, data.MinimumInstanceCount, count)
+		}
+		if data.MaximumInstanceCount > 0 && count > data.MaximumInstanceCount {
+			return invalidPlan("ElasticsearchClusterConfig.InstanceCount", "%s allows at most %d data nodes, not %d", limits.InstanceType, data.MaximumInstanceCount, count)
+		}
+	}
+	if ebs := settings.EBSOptions; ebs != nil && aws.BoolValue(ebs.EBSEnabled) && ebs.VolumeSize != nil {
+		size := aws.Int64Value(ebs.VolumeSize)
+		volumeType := aws.StringValue(ebs.VolumeType)
+		if min, ok := limits.storageLimit("data", "ebs", volumeType, "MinimumVolumeSize"); ok && size < min {
+			return invalidPlan("EBSOptions.VolumeSize", "%s requires a %s volume of at least %d GiB, not %d", limits.InstanceType, volumeType, min, size)
+		}
+		if max, ok := limits.storageLimit("data", "ebs", volumeType, "MaximumVolumeSize"); ok && size > max {
+			return invalidPlan("EBSOptions.VolumeSize", "%s allows a %s volume of at most %d GiB, not %d", limits.InstanceType, volumeType, max, size)
+		}
+	}
+	return nil
+}
+
+// GetPlanLimits returns the limits of the instance type and version a plan provisions.
+func GetPlanLimits(plan *ProviderPlan) (*InstanceTypeLimits, error) {
+	if !plan.Provider.IsAWSDomain() {
+		return nil, NotFound()
+	}
+	settings, err := planDomainInput(plan)
+	if err != nil {
+		return nil, err
+	}
+	if settings.ElasticsearchClusterConfig == nil || settings.ElasticsearchClusterConfig.InstanceType == nil || settings.ElasticsearchVersion == nil {
+		return nil, UnprocessableEntityWithMessage("InvalidPlan", "The plan does not specify an instance type and version.")
+	}
+	limits, err := GetInstanceTypeLimits(aws.StringValue(settings.ElasticsearchClusterConfig.InstanceType), aws.StringValue(settings.ElasticsearchVersion))
+	if err != nil {
+		return nil, err
+	}
+	limits.PlanId = plan.ID
+	return limits, nil
+}
+
+func (b *BusinessLogic) limitsResponse(plan *ProviderPlan) (interface{}, error) {
+	limits, err := GetPlanLimits(plan)
+	if _, ok := osb.IsHTTPError(err); ok {
+		return nil, err
+	} else if err != nil {
+		glog.Errorf("Unable to get the limits of plan %s: %s\n", plan.ID, err.Error())
+		return nil, InternalServerError()
+	}
+	return limits, nil
+}
+
+func (b *BusinessLogic) LimitsAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	Instance, err := b.GetInstanceById(InstanceID)
+	if err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Error finding instance id (during limits): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	return b.limitsResponse(Instance.Plan)
+}
+
+// RoutePlanLimits adds GET /v2/plans/{plan_id}/limits so the limits of a plan can be
+// shown (e.g., in a size picker) before anything is provisioned.
+func (b *BusinessLogic) RoutePlanLimits(router *mux.Router) {
+	router.HandleFunc("/v2/plans/{plan_id}/limits", func(w http.ResponseWriter, r *http.Request) {
+		plan, err := b.storage.GetPlanByID(mux.Vars(r)["plan_id"])
+		if err != nil && err.Error() == "Not found" {
+			HttpWrite(w, http.StatusNotFound, map[string]string{"error": "NotFound", "description": "Not Found"})
+			return
+		} else if err != nil {
+			glog.Errorf("Unable to get limits (GetPlanByID failed): %s\n", err.Error())
+			HttpWrite(w, http.StatusInternalServerError, map[string]string{"error": "InternalServerError", "description": "Internal Server Error"})
+			return
+		}
+		limits, err := b.limitsResponse(plan)
+		if httpErr, ok := osb.IsHTTPError(err); ok {
+			body := map[string]string{}
+			if httpErr.Description != nil {
+				body["description"] = *httpErr.Description
+			}
+			if httpErr.ErrorMessage != nil {
+				body["error"] = *httpErr.ErrorMessage
+			}
+			HttpWrite(w, httpErr.StatusCode, body)
+			return
+		}
+		HttpWrite(w, http.StatusOK, limits)
+	}).Methods("GET")
+}
@@ -0,0 +1,264 @@
+package broker
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// A staging instance can be linked to its production instance so changes are rehearsed on
+// it first. The worker moves the staging instance to the plan of the production instance,
+// and as soon as the plan has a new engine version (its maintenance_info changes) upgrades
+// the staging instance, ahead of the maintenance window the production instance is upgraded
+// in. Once the staging instance is available again the result is posted to
+// LINKED_REHEARSAL_WEBHOOK (signed with LINKED_REHEARSAL_WEBHOOK_SECRET) and emailed to the
+// contacts of the production instance, and upgrading the production instance to a version
+// that has not been rehearsed successfully warns the caller.
+
+const (
+	LinkTracking   = "tracking"
+	LinkRehearsing = "rehearsing"
+	LinkSucceeded  = "succeeded"
+	LinkFailed     = "failed"
+)
+
+type LinkedInstance struct {
+	InstanceId   string `json:"instance_id"`
+	ProductionId string `json:"production_id"`
+	Status       string `json:"status"`
+	// The plan and maintenance_info version of the last rehearsal.
+	Plan    string    `json:"plan,omitempty"`
+	Version string    `json:"version,omitempty"`
+	Task    string    `json:"-"`
+	Result  string    `json:"result,omitempty"`
+	Updated time.Time `json:"updated"`
+}
+
+type LinkRequest struct {
+	Production string `json:"production"`
+}
+
+type RehearsalReport struct {
+	InstanceId     string `json:"instance_id"`
+	Name           string `json:"name"`
+	ProductionId   string `json:"production_id"`
+	ProductionName string `json:"production_name"`
+	Owner          string `json:"owner"`
+	Plan           string `json:"plan"`
+	Version        string `json:"version"`
+	Status         string `json:"status"`
+	Result         string `json:"result,omitempty"`
+}
+
+// rehearse schedules moving the staging instance to the plan (and engine version) of the
+// production instance.
+func rehearse(storage Storage, link *LinkedInstance, staging *Instance, plan *ProviderPlan, maintenance bool) error {
+	change := "moving to the plan " + plan.ID
+	if maintenance {
+		change = "upgrading to " + plan.MaintenanceInfo().Description
+	}
+	if DryRun(RehearseUpgradeAction) {
+		ReportDryRun(storage, RehearseUpgradeAction, staging.Id, "Would rehearse "+change+" on the staging instance "+staging.Name)
+		return nil
+	}
+	byteData, err := json.Marshal(ChangePlansTaskMetadata{Plan: plan.ID, Maintenance: maintenance})
+	if err != nil {
+		return err
+	}
+	task, err := storage.AddTask(staging.Id, ChangePlansTask, string(byteData))
+	if err != nil {
+		return err
+	}
+	glog.Infof("Rehearsing %s on the staging instance %s\n", change, staging.Name)
+	link.Status = LinkRehearsing
+	link.Plan = plan.ID
+	link.Version = plan.MaintenanceInfo().Version
+	link.Task = task
+	link.Result = ""
+	return storage.SetLinkedInstance(link)
+}
+
+// finishRehearsal records and reports the result of a rehearsal once its task has finished
+// and the staging instance is available again.
+func finishRehearsal(storage Storage, link *LinkedInstance, staging *Instance, production *Instance) error {
+	task, err := storage.GetLastTask(staging.Id, ChangePlansTask)
+	if err != nil && err.Error() != "Not found" {
+		return err
+	}
+	if err == nil && task.Id == link.Task && (task.Status == "pending" || task.Status == "started") {
+		return nil
+	}
+	if err == nil && task.Id == link.Task && task.Status == "failed" {
+		link.Status = LinkFailed
+		link.Result = task.Result
+	} else if !IsAvailable(staging.Status) {
+		return nil
+	} else if InstanceMaintenanceInfo(staging).Version != link.Version {
+		link.Status = LinkFailed
+		link.Result = "The staging instance runs " + InstanceMaintenanceInfo(staging).Description + " after the rehearsal."
+	} else {
+		link.Status = LinkSucceeded
+		link.Result = ""
+	}
+	link.Task = ""
+	if err = storage.SetLinkedInstance(link); err != nil {
+		return err
+	}
+	report := RehearsalReport{
+		InstanceId:     staging.Id,
+		Name:           staging.Name,
+		ProductionId:   production.Id,
+		ProductionName: production.Name,
+		Owner:          production.Owner,
+		Plan:           link.Plan,
+		Version:        link.Version,
+		Status:         link.Status,
+		Result:         link.Result,
+	}
+	glog.Infof("The rehearsal on %s (staging of %s) %s %s\n", staging.Name, production.Name, link.Status, link.Result)
+	if url := os.Getenv("LINKED_REHEARSAL_WEBHOOK"); url != "" {
+		if err = Notify(storage, LinkedRehearsalNotification, url, os.Getenv("LINKED_REHEARSAL_WEBHOOK_SECRET"), report); err != nil {
+			glog.Errorf("Unable to send the rehearsal result of %s: %s\n", staging.Name, err.Error())
+		}
+	}
+	if err = EmailContacts(storage, LinkedRehearsalNotification, production.Id, report); err != nil {
+		glog.Errorf("Unable to email the contacts of %s: %s\n", production.Name, err.Error())
+	}
+	return nil
+}
+
+func syncLinkedInstance(namePrefix string, storage Storage, link *LinkedInstance) error {
+	staging, err := GetInstanceById(namePrefix, storage, link.InstanceId)
+	if err != nil {
+		return err
+	}
+	production, err := GetInstanceById(namePrefix, storage, link.ProductionId)
+	if err != nil {
+		return err
+	}
+	if link.Status == LinkRehearsing {
+		return finishRehearsal(storage, link, staging, production)
+	}
+	if !IsAvailable(staging.Status) {
+		return nil
+	}
+	if running, err := runningOperation(storage, staging.Id); err != nil || running != "" {
+		return err
+	}
+	if staging.Plan.ID != production.Plan.ID {
+		return rehearse(storage, link, staging, production.Plan, false)
+	}
+	if InstanceMaintenanceInfo(staging).Version != staging.Plan.MaintenanceInfo().Version {
+		return rehearse(storage, link, staging, staging.Plan, true)
+	}
+	return nil
+}
+
+// SyncLinkedInstances rehearses the changes of production instances on their linked staging
+// instances and reports the results of finished rehearsals.
+func SyncLinkedInstances(namePrefix string, storage Storage) {
+	links, err := storage.GetLinkedInstances()
+	if err != nil {
+		glog.Errorf("Unable to get the linked instances: %s\n", err.Error())
+		return
+	}
+	for i := range links {
+		if err = syncLinkedInstance(namePrefix, storage, &links[i]); err != nil {
+			glog.Errorf("Unable to sync the staging instance %s with %s: %s\n", links[i].InstanceId, links[i].ProductionId, err.Error())
+		}
+	}
+}
+
+// rehearsalWarning warns that a maintenance update of a production instance has not been
+// rehearsed successfully on its staging instances.
+func (b *BusinessLogic) rehearsalWarning(instance *Instance, plan *ProviderPlan, c *broker.RequestContext) {
+	links, err := b.storage.GetLinkedStaging(instance.Id)
+	if err != nil {
+		glog.Errorf("Unable to get the staging instances of %s: %s\n", instance.Name, err.Error())
+		return
+	}
+	version := plan.MaintenanceInfo().Version
+	for _, link := range links {
+		if link.Status == LinkSucceeded && link.Version == version {
+			continue
+		}
+		message := "The upgrade to " + version + " has not been rehearsed successfully on the staging instance " + link.InstanceId + " (" + link.Status + ")."
+		glog.Infof("Warning: %s is upgrading: %s\n", instance.Name, message)
+		if c != nil && c.Writer != nil {
+			c.Writer.Header().Add("Warning", "299 - "+strconv.Quote(message))
+		}
+	}
+}
+
+// GET /v2/service_instances/{instance_id}/actions/link
+func (b *BusinessLogic) GetLinkAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	link, err := b.storage.GetLinkedInstance(InstanceID)
+	if err != nil && err.Error() == "Not found" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Unable to get the link of %s: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	return link, nil
+}
+
+// PUT /v2/service_instances/{instance_id}/actions/link with {"production":"<instance id>"}
+// links the (staging) instance to its production instance.
+func (b *BusinessLogic) SetLinkAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	var request LinkRequest
+	if c == nil || c.Request == nil || c.Request.Body == nil {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "The body must be {\"production\":\"...\"}.")
+	}
+	if err := json.NewDecoder(c.Request.Body).Decode(&request); err != nil || request.Production == "" {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "The body must be {\"production\":\"...\"}.")
+	}
+	if request.Production == InstanceID {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "An instance can't be linked to itself.")
+	}
+	staging, err := b.storage.GetInstance(InstanceID)
+	if err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Error finding instance id (during link): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	production, err := b.storage.GetInstance(request.Production)
+	if err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "The production instance "+request.Production+" does not exist.")
+	} else if err != nil {
+		glog.Errorf("Error finding instance id (during link): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	if production.Owner != staging.Owner {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "The production instance must be owned by the same organization.")
+	}
+	if _, err = b.storage.GetLinkedInstance(production.Id); err == nil {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "The production instance is itself a staging instance.")
+	}
+	link := LinkedInstance{InstanceId: InstanceID, ProductionId: production.Id, Status: LinkTracking}
+	if err = b.storage.SetLinkedInstance(&link); err != nil {
+		glog.Errorf("Unable to link %s to %s: %s\n", InstanceID, production.Id, err.Error())
+		return nil, InternalServerError()
+	}
+	return b.storage.GetLinkedInstance(InstanceID)
+}
+
+// DELETE /v2/service_instances/{instance_id}/actions/link
+func (b *BusinessLogic) RemoveLinkAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	link, err := b.storage.GetLinkedInstance(InstanceID)
+	if err != nil && err.Error() == "Not found" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Unable to get the link of %s: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	if err = b.storage.RemoveLinkedInstance(InstanceID); err != nil {
+		glog.Errorf("Unable to unlink %s: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	return link, nil
+}
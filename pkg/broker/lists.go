@@ -0,0 +1,317 @@
+package broker
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
+// ListQuery is the pagination, filtering and sorting of a list endpoint, e.g.,
+// ?limit=50&sort=created&order=desc&status=available&cursor=...
+type ListQuery struct {
+	Limit   int
+	Sort    string
+	Desc    bool
+	Filters map[string]string
+	// The labels items must have, see listSpec.Labels.
+	Labels map[string]string
+	// The sort value and id of the last item of the previous page.
+	After *listCursor
+}
+
+type listCursor struct {
+	Value string `json:"v"`
+	Id    string `json:"id"`
+}
+
+// ListPage is returned by every list endpoint, next_cursor is passed as ?cursor= to
+// get the next page and is empty on the last page.
+type ListPage struct {
+	Items      interface{} `json:"items"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// A listSpec describes what a list can be sorted and filtered by, as sql expressions.
+type listSpec struct {
+	Sorts       map[string]listColumn
+	Filters     map[string]string
+	DefaultSort string
+	// The json column of the labels of each item, if it can be filtered by labels.
+	Labels string
+}
+
+type listColumn struct {
+	Expr string
+	Type string
+}
+
+var instancesListSpec = listSpec{
+	Sorts: map[string]listColumn{
+		"name":    {"resources.name", "text"},
+		"status":  {"resources.status", "text"},
+		"owner":   {"resources.owner", "text"},
+		"created": {"resources.created", "timestamptz"},
+		"updated": {"resources.updated", "timestamptz"},
+	},
+	Filters: map[string]string{
+		"id":     "resources.id",
+		"plan":   "plans.name",
+		"status": "resources.status",
+		"owner":  "resources.owner",
+		"engine": "plans.type",
+	},
+	DefaultSort: "created",
+	Labels:      "resources.labels",
+}
+
+var operationsListSpec = listSpec{
+	Sorts: map[string]listColumn{
+		"created": {"tasks.created", "timestamptz"},
+		"updated": {"tasks.updated", "timestamptz"},
+		"action":  {"tasks.action", "text"},
+		"status":  {"tasks.status::text", "text"},
+	},
+	Filters: map[string]string{
+		"action": "tasks.action",
+		"status": "tasks.status::text",
+	},
+	DefaultSort: "created",
+}
+
+var bindingsListSpec = listSpec{
+	Sorts: map[string]listColumn{
+		"created": {"bindings.created", "timestamptz"},
+		"updated": {"bindings.updated", "timestamptz"},
+	},
+	Filters:     map[string]string{},
+	DefaultSort: "created",
+}
+
+type InstanceSummary struct {
+	Id            string            `json:"id"`
+	Name          string            `json:"name"`
+	PlanId        string            `json:"plan_id"`
+	Plan          string            `json:"plan"`
+	Engine        string            `json:"engine"`
+	EngineVersion string            `json:"engine_version"`
+	Status        string            `json:"status"`
+	Owner         string            `json:"owner"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	Created       time.Time         `json:"created"`
+	Updated       time.Time         `json:"updated"`
+}
+
+// An Operation is a task as shown to users, without its metadata (which may hold secrets).
+type Operation struct {
+	Id       string     `json:"id"`
+	Action   TaskAction `json:"action"`
+	Status   string     `json:"status"`
+	Retries  int64      `json:"retries"`
+	Result   string     `json:"result"`
+	Created  time.Time  `json:"created"`
+	Updated  time.Time  `json:"updated"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+type BindingSummary struct {
+	Id      string     `json:"id"`
+	Status  string     `json:"status"`
+	Expires *time.Time `json:"expires,omitempty"`
+	Created time.Time  `json:"created"`
+	Updated time.Time  `json:"updated"`
+}
+
+// cursorTimeLayouts are the text forms of a timestamptz sort value, as postgres outputs them
+// (with a whole hour, minutes or seconds offset) or as RFC 3339.
+var cursorTimeLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07",
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999-07:00:00",
+	time.RFC3339Nano,
+}
+
+// validCursorValue is true if the sort value of a cursor can be cast to the type of the sort
+// column, a cursor from another sort would otherwise fail the query.
+func validCursorValue(value string, columnType string) bool {
+	if columnType != "timestamptz" {
+		return true
+	}
+	for _, layout := range cursorTimeLayouts {
+		if _, err := time.Parse(layout, value); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func encodeCursor(value string, id string) string {
+	data, _ := json.Marshal(listCursor{Value: value, Id: id})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ParseListQuery reads the list parameters from a query string, only the sorts and
+// filters in the spec are allowed.
+func ParseListQuery(values url.Values, spec listSpec) (*ListQuery, error) {
+	q := ListQuery{Limit: defaultListLimit, Sort: spec.DefaultSort, Filters: make(map[string]string)}
+	if values.Get("limit") != "" {
+		limit, err := strconv.Atoi(values.Get("limit"))
+		if err != nil || limit < 1 || limit > maxListLimit {
+			return nil, errors.New("The limit must be between 1 and " + strconv.Itoa(maxListLimit) + ".")
+		}
+		q.Limit = limit
+	}
+	if values.Get("sort") != "" {
+		if _, ok := spec.Sorts[values.Get("sort")]; !ok {
+			return nil, errors.New("Lists cannot be sorted by " + values.Get("sort") + ".")
+		}
+		q.Sort = values.Get("sort")
+	}
+	switch values.Get("order") {
+	case "", "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		return nil, errors.New("The order must be asc or desc.")
+	}
+	for name := range spec.Filters {
+		if values.Get(name) != "" {
+			q.Filters[name] = values.Get(name)
+		}
+	}
+	if spec.Labels != "" && len(values["label"]) > 0 {
+		labels, err := parseLabelFilters(values["label"])
+		if err != nil {
+			return nil, err
+		}
+		q.Labels = labels
+	}
+	if values.Get("cursor") != "" {
+		data, err := base64.RawURLEncoding.DecodeString(values.Get("cursor"))
+		if err != nil {
+			return nil, errors.New("The cursor is invalid.")
+		}
+		var cursor listCursor
+		if err = json.Unmarshal(data, &cursor); err != nil || cursor.Id == "" {
+			return nil, errors.New("The cursor is invalid.")
+		}
+		if !validCursorValue(cursor.Value, spec.Sorts[q.Sort].Type) {
+			return nil, errors.New("The cursor is not from a list sorted by " + q.Sort + ".")
+		}
+		q.After = &cursor
+	}
+	return &q, nil
+}
+
+// where returns the filter, cursor, order and limit clauses for the query, args are
+// appended to the queries existing args.
+func (q *ListQuery) where(spec listSpec, idExpr string, args []interface{}) (string, []interface{}) {
+	clause := ""
+	for _, name := range sortedKeys(q.Filters) {
+		args = append(args, q.Filters[name])
+		clause += " and " + spec.Filters[name] + " = $" + strconv.Itoa(len(args))
+	}
+	for _, key := range sortedKeys(q.Labels) {
+		args = append(args, key, q.Labels[key])
+		clause += " and " + spec.Labels + " ->> $" + strconv.Itoa(len(args)-1) + " = $" + strconv.Itoa(len(args))
+	}
+	column := spec.Sorts[q.Sort]
+	direction, compare := "asc", ">"
+	if q.Desc {
+		direction, compare = "desc", "<"
+	}
+	if q.After != nil {
+		args = append(args, q.After.Value, q.After.Id)
+		clause += " and (" + column.Expr + ", " + idExpr + "::text) " + compare + " ($" + strconv.Itoa(len(args)-1) + "::" + column.Type + ", $" + strconv.Itoa(len(args)) + ")"
+	}
+	// one more than the limit is fetched to know if there is another page
+	clause += " order by " + column.Expr + " " + direction + ", " + idExpr + "::text " + direction + " limit " + strconv.Itoa(q.Limit+1)
+	return clause, args
+}
+
+// sortedKeys orders the filters so the same query always has the same sql.
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeListError(w http.ResponseWriter, err error) {
+	HttpWrite(w, http.StatusUnprocessableEntity, map[string]string{"error": "InvalidQuery", "description": err.Error()})
+}
+
+// RouteListInstances adds GET /v2/service_instances to list every instance, filtered by
+// plan, status, owner, engine or labels.
+func (b *BusinessLogic) RouteListInstances(router *mux.Router) {
+	router.HandleFunc("/v2/service_instances", func(w http.ResponseWriter, r *http.Request) {
+		q, err := ParseListQuery(r.URL.Query(), instancesListSpec)
+		if err != nil {
+			writeListError(w, err)
+			return
+		}
+		instances, next, err := b.storage.ListInstances(q)
+		if err != nil {
+			glog.Errorf("Unable to list instances: %s\n", err.Error())
+			HttpWrite(w, http.StatusInternalServerError, map[string]string{"error": "InternalServerError", "description": "Internal Server Error"})
+			return
+		}
+		HttpWrite(w, http.StatusOK, ListPage{Items: instances, NextCursor: next})
+	}).Methods("GET")
+}
+
+// GET /v2/service_instances/{instance_id}/actions/operations
+func (b *BusinessLogic) ListOperationsAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	q, err := ParseListQuery(c.Request.URL.Query(), operationsListSpec)
+	if err != nil {
+		return nil, UnprocessableEntityWithMessage("InvalidQuery", err.Error())
+	}
+	if _, err := b.storage.GetInstance(InstanceID); err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Error finding instance id (during list operations): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	operations, next, err := b.storage.ListOperations(InstanceID, q)
+	if err != nil {
+		glog.Errorf("Unable to list operations for %s: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	return ListPage{Items: operations, NextCursor: next}, nil
+}
+
+// GET /v2/service_instances/{instance_id}/actions/bindings
+func (b *BusinessLogic) ListBindingsAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	q, err := ParseListQuery(c.Request.URL.Query(), bindingsListSpec)
+	if err != nil {
+		return nil, UnprocessableEntityWithMessage("InvalidQuery", err.Error())
+	}
+	if _, err := b.storage.GetInstance(InstanceID); err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Error finding instance id (during list bindings): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	bindings, next, err := b.storage.ListBindings(InstanceID, q)
+	if err != nil {
+		glog.Errorf("Unable to list bindings for %s: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	return ListPage{Items: bindings, NextCursor: next}, nil
+}
@@ -0,0 +1,134 @@
+package broker
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestParseListQuery(t *testing.T) {
+	q, err := ParseListQuery(url.Values{}, instancesListSpec)
+	if err != nil {
+		t.Fatalf("ParseListQuery() failed: %s", err.Error())
+	}
+	if q.Limit != defaultListLimit || q.Sort != "created" || q.Desc || len(q.Filters) != 0 || q.Labels != nil || q.After != nil {
+		t.Errorf("ParseListQuery() of no parameters = %+v, want the defaults", q)
+	}
+
+	values := url.Values{
+		"limit":  {"10"},
+		"sort":   {"name"},
+		"order":  {"desc"},
+		"status": {"available"},
+		"secret": {"ignored"},
+		"label":  {"team:search", "env:prod"},
+		"cursor": {encodeCursor("logs", "instance-1")},
+	}
+	q, err = ParseListQuery(values, instancesListSpec)
+	if err != nil {
+		t.Fatalf("ParseListQuery() failed: %s", err.Error())
+	}
+	want := &ListQuery{
+		Limit:   10,
+		Sort:    "name",
+		Desc:    true,
+		Filters: map[string]string{"status": "available"},
+		Labels:  map[string]string{"team": "search", "env": "prod"},
+		After:   &listCursor{Value: "logs", Id: "instance-1"},
+	}
+	if !reflect.DeepEqual(q, want) {
+		t.Errorf("ParseListQuery() = %+v, want %+v", q, want)
+	}
+
+	q, err = ParseListQuery(url.Values{"label": {"team:search"}}, bindingsListSpec)
+	if err != nil || q.Labels != nil {
+		t.Errorf("ParseListQuery() of a list without labels = %+v, %v, want the labels ignored", q, err)
+	}
+
+	created := "2021-03-04 05:06:07.123456+00"
+	for _, value := range []string{created, "2021-03-04 05:06:07+05:30", "2021-03-04T05:06:07Z"} {
+		q, err = ParseListQuery(url.Values{"cursor": {encodeCursor(value, "instance-1")}}, instancesListSpec)
+		if err != nil || q.After == nil || q.After.Value != value {
+			t.Errorf("ParseListQuery() of a cursor after %q = %+v, %v", value, q, err)
+		}
+	}
+
+	invalid := []struct {
+		name   string
+		values url.Values
+		spec   listSpec
+	}{
+		{"limit of zero", url.Values{"limit": {"0"}}, instancesListSpec},
+		{"limit over the max", url.Values{"limit": {"501"}}, instancesListSpec},
+		{"limit not a number", url.Values{"limit": {"ten"}}, instancesListSpec},
+		{"unknown sort", url.Values{"sort": {"password"}}, instancesListSpec},
+		{"sort of another list", url.Values{"sort": {"name"}}, bindingsListSpec},
+		{"unknown order", url.Values{"order": {"random"}}, instancesListSpec},
+		{"malformed label", url.Values{"label": {"team"}}, instancesListSpec},
+		{"label without a key", url.Values{"label": {":search"}}, instancesListSpec},
+		{"cursor not base64", url.Values{"cursor": {"not a cursor!"}}, instancesListSpec},
+		{"cursor not json", url.Values{"cursor": {"bm90IGpzb24"}}, instancesListSpec},
+		{"cursor without an id", url.Values{"cursor": {encodeCursor(created, "")}}, instancesListSpec},
+		{"text cursor of a time sort", url.Values{"cursor": {encodeCursor("logs", "instance-1")}}, instancesListSpec},
+		{"text cursor of a time sort of another list", url.Values{"sort": {"updated"}, "cursor": {encodeCursor("available", "task-1")}}, operationsListSpec},
+	}
+	for _, test := range invalid {
+		if q, err := ParseListQuery(test.values, test.spec); err == nil {
+			t.Errorf("%s: ParseListQuery() = %+v, want an error", test.name, q)
+		}
+	}
+}
+
+func TestListQueryWhere(t *testing.T) {
+	tests := []struct {
+		name   string
+		q      ListQuery
+		clause string
+		args   []interface{}
+	}{
+		{
+			"defaults",
+			ListQuery{Limit: 50, Sort: "created"},
+			" order by resources.created asc, resources.id::text asc limit 51",
+			[]interface{}{"existing"},
+		},
+		{
+			"filters and labels",
+			ListQuery{Limit: 10, Sort: "name", Filters: map[string]string{"status": "available", "owner": "team"}, Labels: map[string]string{"team": "search", "env": "prod"}},
+			" and resources.owner = $2 and resources.status = $3 and resources.labels ->> $4 = $5 and resources.labels ->> $6 = $7 order by resources.name asc, resources.id::text asc limit 11",
+			[]interface{}{"existing", "team", "available", "env", "prod", "team", "search"},
+		},
+		{
+			"cursor ascending",
+			ListQuery{Limit: 1, Sort: "created", After: &listCursor{Value: "2021-03-04 05:06:07+00", Id: "instance-1"}},
+			" and (resources.created, resources.id::text) > ($2::timestamptz, $3) order by resources.created asc, resources.id::text asc limit 2",
+			[]interface{}{"existing", "2021-03-04 05:06:07+00", "instance-1"},
+		},
+		{
+			"cursor descending",
+			ListQuery{Limit: 500, Sort: "name", Desc: true, Filters: map[string]string{"plan": "small"}, After: &listCursor{Value: "logs", Id: "instance-1"}},
+			" and plans.name = $2 and (resources.name, resources.id::text) < ($3::text, $4) order by resources.name desc, resources.id::text desc limit 501",
+			[]interface{}{"existing", "small", "logs", "instance-1"},
+		},
+	}
+	for _, test := range tests {
+		clause, args := test.q.where(instancesListSpec, "resources.id", []interface{}{"existing"})
+		if clause != test.clause {
+			t.Errorf("%s: where() = %q, want %q", test.name, clause, test.clause)
+		}
+		if !reflect.DeepEqual(args, test.args) {
+			t.Errorf("%s: where() args = %v, want %v", test.name, args, test.args)
+		}
+	}
+}
+
+func TestNextCursor(t *testing.T) {
+	cursors := []listCursor{{"a", "1"}, {"b", "2"}, {"c", "3"}}
+	q := &ListQuery{Limit: 2}
+	if next := nextCursor(q, 2, cursors[:2]); next != "" {
+		t.Errorf("nextCursor() of the last page = %q, want none", next)
+	}
+	if next := nextCursor(q, 3, cursors); next != encodeCursor("b", "2") {
+		t.Errorf("nextCursor() = %q, want the cursor of the last item of the page", next)
+	}
+}
@@ -0,0 +1,200 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// LoggingTier is set on plans meant for app logs (the plans logging column), new
+// instances are bootstrapped with a write alias that rolls over to a new index
+// and an ISM policy that deletes old indices.
+type LoggingTier struct {
+	Alias           string `json:"alias,omitempty"`
+	RolloverMaxSize string `json:"rollover_max_size,omitempty"`
+	RolloverMaxAge  string `json:"rollover_max_age,omitempty"`
+	DeleteAfter     string `json:"delete_after,omitempty"`
+}
+
+type LoggingTaskMetadata struct {
+	Logging *LoggingTier `json:"logging,omitempty"`
+}
+
+var defaultLoggingTier = LoggingTier{
+	Alias:           "logs",
+	RolloverMaxSize: "50gb",
+	RolloverMaxAge:  "1d",
+	DeleteAfter:     "30d",
+}
+
+// Merge returns the tier with any values set in overrides (e.g., from the provision
+// parameters of an instance) replacing its own, unset values fall back to the defaults.
+func (t LoggingTier) Merge(overrides *LoggingTier) LoggingTier {
+	merged := defaultLoggingTier
+	for _, tier := range []*LoggingTier{&t, overrides} {
+		if tier == nil {
+			continue
+		}
+		if tier.Alias != "" {
+			merged.Alias = tier.Alias
+		}
+		if tier.RolloverMaxSize != "" {
+			merged.RolloverMaxSize = tier.RolloverMaxSize
+		}
+		if tier.RolloverMaxAge != "" {
+			merged.RolloverMaxAge = tier.RolloverMaxAge
+		}
+		if tier.DeleteAfter != "" {
+			merged.DeleteAfter = tier.DeleteAfter
+		}
+	}
+	return merged
+}
+
+func (t LoggingTier) PolicyId() string {
+	return t.Alias + "-rollover"
+}
+
+// ParseLoggingParameters reads the logging overrides from provision parameters, e.g.
+// {"logging":{"delete_after":"7d"}}.
+func ParseLoggingParameters(parameters map[string]interface{}) (*LoggingTier, error) {
+	if parameters == nil || parameters["logging"] == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(parameters["logging"])
+	if err != nil {
+		return nil, err
+	}
+	var tier LoggingTier
+	if err = json.Unmarshal(data, &tier); err != nil {
+		return nil, err
+	}
+	if strings.ContainsAny(tier.Alias, " ,/\\*?\"<>|#") || strings.HasPrefix(tier.Alias, "_") || strings.ToLower(tier.Alias) != tier.Alias {
+		return nil, errors.New("The logging alias must be a valid lowercase index name.")
+	}
+	return &tier, nil
+}
+
+func (t LoggingTier) policy() map[string]interface{} {
+	return map[string]interface{}{
+		"policy": map[string]interface{}{
+			"description":   "Rolls over " + t.Alias + " at " + t.RolloverMaxSize + " or " + t.RolloverMaxAge + " and deletes indices after " + t.DeleteAfter + ".",
+			"default_state": "hot",
+			"states": []interface{}{
+				map[string]interface{}{
+					"name": "hot",
+					"actions": []interface{}{
+						map[string]interface{}{"rollover": map[string]interface{}{"min_size": t.RolloverMaxSize, "min_index_age": t.RolloverMaxAge}},
+					},
+					"transitions": []interface{}{
+						map[string]interface{}{"state_name": "delete", "conditions": map[string]interface{}{"min_index_age": t.DeleteAfter}},
+					},
+				},
+				map[string]interface{}{
+					"name":        "delete",
+					"actions":     []interface{}{map[string]interface{}{"delete": map[string]interface{}{}}},
+					"transitions": []interface{}{},
+				},
+			},
+		},
+	}
+}
+
+func (t LoggingTier) template() map[string]interface{} {
+	return map[string]interface{}{
+		"index_patterns": []string{t.Alias + "-*"},
+		"settings": map[string]interface{}{
+			"opendistro.index_state_management.policy_id":      t.PolicyId(),
+			"opendistro.index_state_management.rollover_alias": t.Alias,
+		},
+	}
+}
+
+// alreadyExists is true for the responses returned when a policy or index was
+// created by a previous attempt.
+func alreadyExists(status int, response []byte) bool {
+	return status == 409 || (status == 400 && strings.Contains(string(response), "resource_already_exists_exception"))
+}
+
+// BootstrapLogging creates the ISM policy, the index template that attaches it and
+// the first index behind the write alias. Anything that already exists is left as is
+// so it can be safely retried.
+func BootstrapLogging(cluster *ClusterClient, instance *Instance, tier LoggingTier) (string, error) {
+	steps := []struct {
+		method string
+		path   string
+		body   interface{}
+	}{
+		{"PUT", "/_opendistro/_ism/policies/" + tier.PolicyId(), tier.policy()},
+		{"PUT", "/_template/" + tier.Alias, tier.template()},
+		{"PUT", "/" + tier.Alias + "-000001", map[string]interface{}{"aliases": map[string]interface{}{tier.Alias: map[string]interface{}{"is_write_index": true}}}},
+	}
+	for _, step := range steps {
+		body, err := json.Marshal(step.body)
+		if err != nil {
+			return "", err
+		}
+		response, status, err := cluster.Do(instance, step.method, step.path, body)
+		if err != nil {
+			return "", err
+		}
+		if alreadyExists(status, response) {
+			glog.Infof("%s already exists on %s, leaving it as is.\n", step.path, instance.Name)
+			continue
+		}
+		if status < 200 || status > 299 {
+			if len(response) > 1024 {
+				response = response[0:1024]
+			}
+			return "", errors.New(step.method + " " + step.path + " returned " + strconv.Itoa(status) + ": " + string(response))
+		}
+	}
+	return "Write alias " + tier.Alias + " rolls over at " + tier.RolloverMaxSize + " or " + tier.RolloverMaxAge + ", indices are deleted after " + tier.DeleteAfter, nil
+}
+
+// ScheduleLoggingBootstrap queues bootstrapping the logging alias if the instances
+// plan is a logging tier, metadata holds any per instance overrides.
+func ScheduleLoggingBootstrap(storage Storage, instance *Instance, metadata string) error {
+	if instance.Plan == nil || instance.Plan.Logging == nil {
+		return nil
+	}
+	_, err := storage.AddTask(instance.Id, BootstrapLoggingTask, metadata)
+	return err
+}
+
+func RunBootstrapLoggingTaskFromQueue(storage Storage, namePrefix string, cluster *ClusterClient, task *Task) {
+	if task.Retries >= 30 {
+		FinishedTask(storage, task.Id, task.Retries, "Unable to bootstrap the logging alias as it failed multiple times ("+task.Result+")", "failed")
+		return
+	}
+	var taskMetaData LoggingTaskMetadata
+	if task.Metadata != "" {
+		if err := json.Unmarshal([]byte(task.Metadata), &taskMetaData); err != nil {
+			FinishedTask(storage, task.Id, task.Retries, "Invalid logging task metadata", "failed")
+			return
+		}
+	}
+	instance, err := GetInstanceById(namePrefix, storage, task.ResourceId)
+	if err != nil {
+		UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot get Instance: "+err.Error(), "pending")
+		return
+	}
+	if instance.Plan.Logging == nil {
+		FinishedTask(storage, task.Id, task.Retries, "The plan is not a logging tier", "finished")
+		return
+	}
+	if !IsAvailable(instance.Status) {
+		UpdateTaskStatus(storage, task.Id, task.Retries+1, "Waiting for the instance to become available ("+instance.Status+")", "pending")
+		return
+	}
+	result, err := BootstrapLogging(cluster, instance, instance.Plan.Logging.Merge(taskMetaData.Logging))
+	if err != nil {
+		glog.Infof("Unable to bootstrap logging for %s: %s\n", instance.Name, err.Error())
+		UpdateTaskStatus(storage, task.Id, task.Retries+1, "Unable to bootstrap logging: "+err.Error(), "pending")
+		return
+	}
+	FinishedTask(storage, task.Id, task.Retries, result, "finished")
+}
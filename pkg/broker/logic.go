@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"github.com/golang/glog"
 	"strings"
+	"time"
 	osb "github.com/pmorie/go-open-service-broker-client/v2"
 	"github.com/pmorie/osb-broker-lib/pkg/broker"
 )
@@ -13,6 +14,8 @@ type BusinessLogic struct {
 	ActionBase
 	storage    Storage
 	namePrefix string
+	// The instance metrics refreshed by the reconciler, registered by NewServer.
+	metrics *InstanceMetrics
 }
 
 func NewBusinessLogic(ctx context.Context, o Options) (*BusinessLogic, error) {
@@ -25,6 +28,52 @@ func NewBusinessLogic(ctx context.Context, o Options) (*BusinessLogic, error) {
 		storage:    storage,
 		namePrefix: namePrefix,
 	}
+	bl.AddActions("config-vars", "config-vars", "GET", bl.ConfigVarsAction)
+	bl.AddActions("network", "network", "GET", bl.NetworkAction)
+	bl.AddActions("list-associations", "associations", "GET", bl.ListAssociationsAction)
+	bl.AddActions("add-association", "associations", "POST", bl.AddAssociationAction)
+	bl.AddActions("remove-association", "associations/{association_id}", "DELETE", bl.RemoveAssociationAction)
+	bl.AddActions("get-link", "link", "GET", bl.GetLinkAction)
+	bl.AddActions("set-link", "link", "PUT", bl.SetLinkAction)
+	bl.AddActions("remove-link", "link", "DELETE", bl.RemoveLinkAction)
+	bl.AddActions("list-shares", "shares", "GET", bl.ListSharesAction)
+	bl.AddActions("add-share", "shares", "POST", bl.AddShareAction)
+	bl.AddActions("remove-share", "shares/{share_id}", "DELETE", bl.RemoveShareAction)
+	bl.AddActions("limits", "limits", "GET", bl.LimitsAction)
+	bl.AddActions("health", "health", "GET", bl.HealthAction)
+	bl.AddActions("get-read-only-remediation", "read-only-remediation", "GET", bl.GetReadOnlyRemediationAction)
+	bl.AddActions("set-read-only-remediation", "read-only-remediation", "PUT", bl.SetReadOnlyRemediationAction)
+	bl.AddActions("get-freeze", "freeze", "GET", bl.GetFreezeAction)
+	bl.AddActions("set-freeze", "freeze", "PUT", bl.SetFreezeAction)
+	bl.AddActions("get-legal-hold", "legal-hold", "GET", bl.GetLegalHoldAction)
+	bl.AddActions("set-legal-hold", "legal-hold", "PUT", bl.SetLegalHoldAction)
+	bl.AddActions("set-binding-deactivation", "bindings/{binding_id}/deactivation", "PUT", bl.SetBindingDeactivationAction)
+	bl.AddActions("admin-query", "admin-query", "POST", bl.AdminQueryAction)
+	bl.AddActions("diff", "diff/{other_id}", "GET", bl.DiffAction)
+	bl.AddActions("list-admin-queries", "admin-query", "GET", bl.ListAdminQueriesAction)
+	bl.AddActions("skip-hooks", "skip-hooks", "POST", bl.SkipHooksAction)
+	bl.AddActions("get-contacts", "contacts", "GET", bl.GetContactsAction)
+	bl.AddActions("set-contacts", "contacts", "PUT", bl.SetContactsAction)
+	bl.AddActions("get-labels", "labels", "GET", bl.GetLabelsAction)
+	bl.AddActions("set-labels", "labels", "PUT", bl.SetLabelsAction)
+	bl.AddActions("get-annotations", "annotations", "GET", bl.GetAnnotationsAction)
+	bl.AddActions("set-annotations", "annotations", "PUT", bl.SetAnnotationsAction)
+	bl.AddActions("get-benchmarks", "benchmark", "GET", bl.GetBenchmarksAction)
+	bl.AddActions("run-benchmark", "benchmark", "POST", bl.RunBenchmarkAction)
+	bl.AddActions("dry-run", "dry-run", "GET", bl.DryRunAction)
+	bl.AddActions("list-operations", "operations", "GET", bl.ListOperationsAction)
+	bl.AddActions("list-bindings", "bindings", "GET", bl.ListBindingsAction)
+	bl.AddActions("get-expiry", "expiry", "GET", bl.GetExpiryAction)
+	bl.AddActions("renew", "renew", "POST", bl.RenewAction)
+	bl.AddActions("restore", "restore", "POST", bl.RestoreAction)
+	bl.AddActions("restore-throttle", "restore-throttle", "PUT", bl.RestoreThrottleAction)
+	bl.AddActions("snapshots", "snapshots", "GET", bl.SnapshotsAction)
+	bl.AddActions("prepare", "prepare", "POST", bl.PrepareAction)
+	bl.AddActions("get-snapshot-export", "snapshot-export", "GET", bl.GetSnapshotExportAction)
+	bl.AddActions("set-snapshot-export", "snapshot-export", "PUT", bl.SetSnapshotExportAction)
+	bl.AddActions("remove-snapshot-export", "snapshot-export", "DELETE", bl.RemoveSnapshotExportAction)
+	bl.AddActions("get-snapshot-export-policy", "snapshot-export/policy", "GET", bl.GetSnapshotExportPolicyAction)
+	bl.AddActions("cost-timeline", "cost-timeline", "GET", bl.CostTimelineAction)
 	return &bl, nil
 }
 
@@ -34,6 +83,18 @@ func (b *BusinessLogic) GetCatalog(c *broker.RequestContext) (*broker.CatalogRes
 	if err != nil {
 		return nil, err
 	}
+	supports, err := b.storage.GetEngineSupports()
+	if err != nil {
+		glog.Errorf("Unable to get the engine support windows for the catalog: %s\n", err.Error())
+	}
+	AddEngineVersions(services, supports)
+	if org, space := catalogCaller(c); org != "" || space != "" {
+		rules, err := b.storage.GetVisibilityRules()
+		if err != nil {
+			return nil, err
+		}
+		services = filterCatalog(services, rules, org, space)
+	}
 	osbResponse := &osb.CatalogResponse{Services: services}
 	response.CatalogResponse = *osbResponse
 	return response, nil
@@ -70,7 +131,13 @@ func GetInstanceById(namePrefix string, storage Storage, Id string) (*Instance,
 	if Instance.Endpoint == "" {
 		Instance.Endpoint = entry.Endpoint
 	}
+	Instance.Owner = entry.Owner
 	Instance.Plan = plan
+	if entry.Parameters != "" && entry.Parameters != "null" {
+		if err = json.Unmarshal([]byte(entry.Parameters), &Instance.Parameters); err != nil {
+			return nil, err
+		}
+	}
 
 	return Instance, nil
 }
@@ -108,6 +175,12 @@ func (b *BusinessLogic) Provision(request *osb.ProvisionRequest, c *broker.Reque
 	if request.InstanceID == "" {
 		return nil, UnprocessableEntityWithMessage("InstanceRequired", "The instance ID was not provided.")
 	}
+	// repeating a provision in progress is not a conflict, it returns the same operation
+	release, err := b.beginOperation(request.InstanceID, "provision", "provision")
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 
 	// Ensure we are not trying to provision a UUID that has ever been used before.
 	if err := b.storage.ValidateInstanceID(request.InstanceID); err != nil {
@@ -121,7 +194,71 @@ func (b *BusinessLogic) Provision(request *osb.ProvisionRequest, c *broker.Reque
 		glog.Errorf("Unable to provision (GetPlanByID failed): %s\n", err.Error())
 		return nil, InternalServerError()
 	}
+	if plan.Retired {
+		return nil, UnprocessableEntityWithMessage("PlanRetired", "The plan has been retired, choose another plan.")
+	}
+	if plan.Deprecated {
+		return nil, deprecatedPlanError(b.storage, plan)
+	}
+	request.Parameters = b.applyPresets(request.OrganizationGUID, request.Parameters)
+	space := request.SpaceGUID
+	if space == "" {
+		space = contextSpace(request.Context)
+	}
+	if err = b.checkPlanVisible(plan, request.OrganizationGUID, space); err != nil {
+		return nil, err
+	}
+	if err = checkMaintenanceInfo(requestMaintenanceInfo(c), plan); err != nil {
+		return nil, err
+	}
+	if err = checkParameterPolicy(plan, ProvisionParameters, request.Parameters); err != nil {
+		return nil, err
+	}
+
+	postProvisionMetadata := ""
+	if plan.Logging != nil {
+		logging, err := ParseLoggingParameters(request.Parameters)
+		if err != nil {
+			return nil, UnprocessableEntityWithMessage("InvalidParameters", "The logging parameters were invalid: " + err.Error())
+		}
+		byteData, err := json.Marshal(LoggingTaskMetadata{Logging: logging})
+		if err != nil {
+			glog.Errorf("Unable to marshal logging task meta data: %s\n", err.Error())
+			return nil, InternalServerError()
+		}
+		postProvisionMetadata = string(byteData)
+	}
+	contacts, err := ParseContactParameters(request.Parameters)
+	if err != nil {
+		return nil, UnprocessableEntityWithMessage("InvalidParameters", err.Error())
+	}
+	labels, err := ParseLabelParameters(request.Parameters)
+	if err != nil {
+		return nil, UnprocessableEntityWithMessage("InvalidParameters", err.Error())
+	}
+	clone, err := ParseCloneParameters(request.Parameters)
+	if err != nil {
+		return nil, UnprocessableEntityWithMessage("InvalidParameters", err.Error())
+	}
+	benchmark, err := ParseBenchmarkParameters(request.Parameters)
+	if err != nil {
+		return nil, UnprocessableEntityWithMessage("InvalidParameters", err.Error())
+	}
+	cloneMetadata := ""
+	if clone != nil {
+		if err = ValidateClone(b.namePrefix, b.storage, clone, plan, request.OrganizationGUID); err != nil {
+			return nil, UnprocessableEntityWithMessage("InvalidClone", err.Error())
+		}
+		byteData, err := json.Marshal(RestoreTaskMetadata{From: clone.From, RestoreParameters: RestoreParameters{Indices: clone.Indices}, Repository: cloneRepository()})
+		if err != nil {
+			glog.Errorf("Unable to marshal clone task meta data: %s\n", err.Error())
+			return nil, InternalServerError()
+		}
+		cloneMetadata = string(byteData)
+	}
 
+	identity := requestOriginatingIdentity(c)
+	var quotaWarning *QuotaWarning
 	Instance, err := b.GetInstanceById(request.InstanceID)
 
 	if err == nil {
@@ -131,17 +268,74 @@ func (b *BusinessLogic) Provision(request *osb.ProvisionRequest, c *broker.Reque
 		response.Exists = true
 	} else if err != nil && err.Error() == "Cannot find resource instance" {
 		response.Exists = false
+		quotaWarning, err = b.checkQuota(request.OrganizationGUID)
+		if err != nil {
+			return nil, err
+		}
 		Instance, err = b.GetUnclaimedInstance(request.PlanID, request.InstanceID)
 
-		if err != nil && err.Error() == "Cannot find resource instance" {
+		if err == nil {
+			if err = b.storage.UpdateOwner(Instance.Id, request.OrganizationGUID); err != nil {
+				glog.Errorf("Error: Unable to set the owner of a claimed instance (%s): %s\n", Instance.Name, err.Error())
+			}
+			Instance.Owner = request.OrganizationGUID
+			if provider, err := GetProviderByPlan(b.namePrefix, Instance.Plan); err != nil {
+				glog.Errorf("Error: Unable to tag a claimed instance (%s), cannot get provider: %s\n", Instance.Name, err.Error())
+			} else if err = provider.Tag(Instance, "billingcode", Instance.Owner); err != nil {
+				glog.Errorf("Error: Unable to tag a claimed instance (%s): %s\n", Instance.Name, err.Error())
+			} else if err = TagInstanceId(provider, Instance); err != nil {
+				glog.Errorf("Error: Unable to tag a claimed instance (%s) with its instance id: %s\n", Instance.Name, err.Error())
+			} else if identity != nil {
+				if err = b.storage.SetOriginatingIdentity(Instance.Id, identity); err != nil {
+					glog.Errorf("Error: Unable to set the originating identity of a claimed instance (%s): %s\n", Instance.Name, err.Error())
+				} else if err = TagCreatedBy(b.storage, provider, Instance); err != nil {
+					glog.Errorf("Error: Unable to tag the creator of a claimed instance (%s): %s\n", Instance.Name, err.Error())
+				}
+			}
+			if err = b.storage.SetSpace(Instance.Id, space); err != nil {
+				glog.Errorf("Error: Unable to set the space of a claimed instance (%s): %s\n", Instance.Name, err.Error())
+			}
+			if err = SetContextTags(b.storage, Instance, request.Context); err != nil {
+				glog.Errorf("Error: Unable to set the context tags of a claimed instance (%s): %s\n", Instance.Name, err.Error())
+			} else if provider, err := GetProviderByPlan(b.namePrefix, Instance.Plan); err == nil {
+				if err = TagContext(b.storage, provider, Instance); err != nil {
+					glog.Errorf("Error: Unable to tag the context of a claimed instance (%s): %s\n", Instance.Name, err.Error())
+				}
+			}
+			if labels != nil {
+				if err = b.storage.SetLabels(Instance.Id, labels); err != nil {
+					glog.Errorf("Error: Unable to set the labels of a claimed instance (%s): %s\n", Instance.Name, err.Error())
+				} else if provider, err := GetProviderByPlan(b.namePrefix, Instance.Plan); err == nil {
+					if err = TagLabels(b.storage, provider, Instance, nil); err != nil {
+						glog.Errorf("Error: Unable to tag the labels of a claimed instance (%s): %s\n", Instance.Name, err.Error())
+					}
+				}
+			}
+			if contacts != nil {
+				if err = b.storage.SetContacts(Instance.Id, contacts); err != nil {
+					glog.Errorf("Error: Unable to set the contacts of a claimed instance (%s): %s\n", Instance.Name, err.Error())
+				}
+			}
+			if err = ScheduleLoggingBootstrap(b.storage, Instance, postProvisionMetadata); err != nil {
+				glog.Errorf("Error: Unable to schedule bootstrapping logging of a claimed instance (%s): %s\n", Instance.Name, err.Error())
+			}
+			if err = ScheduleClaimedHooks(b.storage, Instance); err != nil {
+				glog.Errorf("Error: Unable to schedule post provision hooks of a claimed instance (%s): %s\n", Instance.Name, err.Error())
+			}
+		} else if err != nil && err.Error() == "Cannot find resource instance" {
 			// Create a new one
 			provider, err := GetProviderByPlan(b.namePrefix, plan)
 			if err != nil {
 				glog.Errorf("Unable to provision, cannot find provider (GetProviderByPlan failed): %s\n", err.Error())
 				return nil, InternalServerError()
 			}
-			Instance, err = provider.Provision(request.InstanceID, plan, request.OrganizationGUID)
-			if err != nil {
+			// The name is recorded before the domain is created, if anything fails after aws
+			// created it the reconciler deletes the orphaned domain.
+			var name string
+			Instance, name, err = ProvisionWithRandomName(b.storage, provider, request.InstanceID, plan, request.OrganizationGUID)
+			if invalid, ok := err.(*PlanValidationError); ok {
+				return nil, UnprocessableEntityWithMessage("InvalidPlan", invalid.Error())
+			} else if err != nil {
 				glog.Errorf("Error provisioning resource: %s\n", err.Error())
 				return nil, InternalServerError()
 			}
@@ -150,15 +344,38 @@ func (b *BusinessLogic) Provision(request *osb.ProvisionRequest, c *broker.Reque
 				glog.Errorf("Error inserting record into provisioned table: %s\n", err.Error())
 
 				if err = provider.Deprovision(Instance, false); err != nil {
-					glog.Errorf("Error cleaning up (deprovision failed) after insert record failed but provision succeeded (Resource Id:%s Name: %s) %s\n", Instance.Id, Instance.Name, err.Error())
-					if _, err = b.storage.AddTask(Instance.Id, DeleteTask, Instance.Name); err != nil {
-						glog.Errorf("Error: Unable to add task to delete instance, WE HAVE AN ORPHAN! (%s): %s\n", Instance.Name, err.Error())
-					}
+					glog.Errorf("Error cleaning up (deprovision failed) after insert record failed but provision succeeded, the reconciler will delete it (Resource Id:%s Name: %s) %s\n", Instance.Id, Instance.Name, err.Error())
 				}
 				return nil, InternalServerError()
 			}
+			if err = b.storage.RemoveDomainIntent(name); err != nil {
+				glog.Errorf("Unable to remove the recorded domain %s (the reconciler will remove it): %s\n", name, err.Error())
+			}
+			// aws does not accept tags on a domain that is still being created, the creator is
+			// tagged by the post provision task.
+			if identity != nil {
+				if err = b.storage.SetOriginatingIdentity(Instance.Id, identity); err != nil {
+					glog.Errorf("Error: Unable to set the originating identity of instance (%s): %s\n", Instance.Name, err.Error())
+				}
+			}
+			if err = b.storage.SetSpace(Instance.Id, space); err != nil {
+				glog.Errorf("Error: Unable to set the space of instance (%s): %s\n", Instance.Name, err.Error())
+			}
+			if err = SetContextTags(b.storage, Instance, request.Context); err != nil {
+				glog.Errorf("Error: Unable to set the context tags of instance (%s): %s\n", Instance.Name, err.Error())
+			}
+			if labels != nil {
+				if err = b.storage.SetLabels(Instance.Id, labels); err != nil {
+					glog.Errorf("Error: Unable to set the labels of instance (%s): %s\n", Instance.Name, err.Error())
+				}
+			}
+			if contacts != nil {
+				if err = b.storage.SetContacts(Instance.Id, contacts); err != nil {
+					glog.Errorf("Error: Unable to set the contacts of instance (%s): %s\n", Instance.Name, err.Error())
+				}
+			}
 			if !IsAvailable(Instance.Status) {
-				if _, err = b.storage.AddTask(Instance.Id, PerformPostProvisionTask, ""); err != nil {
+				if _, err = b.storage.AddTask(Instance.Id, PerformPostProvisionTask, postProvisionMetadata); err != nil {
 					glog.Errorf("Error: Unable to schedule resync from provider! (%s): %s\n", Instance.Name, err.Error())
 				}
 				// This is a hack to support callbacks, hopefully this will become an OSB standard.
@@ -182,15 +399,43 @@ func (b *BusinessLogic) Provision(request *osb.ProvisionRequest, c *broker.Reque
 		return nil, InternalServerError()
 	}
 
+	if !response.Exists && len(request.Parameters) > 0 {
+		if err = b.storage.SetRequestedParameters(Instance.Id, MergeRequestedParameters(nil, request.Parameters)); err != nil {
+			glog.Errorf("Error: Unable to set the requested parameters of instance (%s): %s\n", Instance.Name, err.Error())
+		}
+	}
+	if !response.Exists && plan.TTL > 0 {
+		if err = b.storage.SetExpires(Instance.Id, ExpiresAt(plan, time.Now())); err != nil {
+			glog.Errorf("Error: Unable to set the expiry of instance (%s): %s\n", Instance.Name, err.Error())
+		}
+	}
+	if !response.Exists && cloneMetadata != "" {
+		if _, err = b.storage.AddTask(Instance.Id, RestoreTask, cloneMetadata); err != nil {
+			glog.Errorf("Error: Unable to schedule restoring the clone (%s): %s\n", Instance.Name, err.Error())
+			return nil, InternalServerError()
+		}
+	}
+	if !response.Exists && benchmark {
+		if _, err = b.storage.AddTask(Instance.Id, BenchmarkTask, ""); err != nil {
+			glog.Errorf("Error: Unable to schedule benchmarking instance (%s): %s\n", Instance.Name, err.Error())
+		}
+	}
+	if quotaWarning != nil {
+		b.sendQuotaWarning(quotaWarning, Instance, c)
+	}
+
 	if request.AcceptsIncomplete && Instance.Ready == false {
-		opkey := osb.OperationKey(request.InstanceID)
 		response.Async = !Instance.Ready
-		response.OperationKey = &opkey
+		response.OperationKey = b.newOperationKey(request.InstanceID, ProvisionOperation)
 	} else if request.AcceptsIncomplete && Instance.Ready == true {
 		response.Async = false
 	}
 
 	response.ExtensionAPIs = b.ConvertActionsToExtensions(Instance.Id)
+	// a new domain has no endpoint until it is available, unless kibana is proxied
+	if dashboard := DashboardURL(Instance); dashboard != "" {
+		response.DashboardURL = &dashboard
+	}
 
 	return &response, nil
 }
@@ -200,6 +445,9 @@ func (b *BusinessLogic) Deprovision(request *osb.DeprovisionRequest, c *broker.R
 	defer b.Unlock()
 
 	response := broker.DeprovisionResponse{}
+	if !request.AcceptsIncomplete {
+		return nil, UnprocessableEntityWithMessage("AsyncRequired", "The query parameter accepts_incomplete=true MUST be included the request.")
+	}
 	Instance, err := b.GetInstanceById(request.InstanceID)
 	if err != nil && err.Error() == "Cannot find resource instance" {
 		return nil, NotFound()
@@ -207,6 +455,17 @@ func (b *BusinessLogic) Deprovision(request *osb.DeprovisionRequest, c *broker.R
 		glog.Errorf("Error finding instance id (during deprovision) from provisioned table: %s\n", err.Error())
 		return nil, InternalServerError()
 	}
+	if err = b.checkNotFrozen(request.InstanceID); err != nil {
+		return nil, err
+	}
+	if err = checkConfirmation(requestConfirmationToken(c), "deprovision", Instance); err != nil {
+		return nil, err
+	}
+	release, err := b.beginOperation(request.InstanceID, "deprovision", "deprovision")
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 
 	provider, err := GetProviderByPlan(b.namePrefix, Instance.Plan)
 	if err != nil {
@@ -214,22 +473,58 @@ func (b *BusinessLogic) Deprovision(request *osb.DeprovisionRequest, c *broker.R
 		return nil, InternalServerError()
 	}
 
-	if err = provider.Deprovision(Instance, true); err != nil {
-		glog.Errorf("Error failed to deprovision: (Id: %s Name: %s) %s\n", Instance.Id, Instance.Name, err.Error())
-		if _, err = b.storage.AddTask(Instance.Id, DeleteTask, Instance.Name); err != nil {
-			glog.Errorf("Error: Unable to schedule delete from provider! (%s): %s\n", Instance.Name, err.Error())
+	deleting, err := b.storage.IsDeleting(Instance.Id)
+	if err != nil {
+		glog.Errorf("Unable to determine if instance is being deleted (IsDeleting failed): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	if deleting {
+		response.Async = true
+		response.OperationKey = b.currentOperationKey(request.InstanceID, DeprovisionOperation)
+		return &response, nil
+	}
+
+	// A shared instance is only released, its domain is deleted once its last share is removed.
+	shares, err := b.storage.GetShares(Instance.Id)
+	if err != nil {
+		glog.Errorf("Unable to get the shares of %s: %s\n", Instance.Name, err.Error())
+		return nil, InternalServerError()
+	}
+	if len(shares) > 0 {
+		glog.Infof("Releasing %s, it is still shared with %d spaces\n", Instance.Name, len(shares))
+		if err = b.storage.Release(Instance.Id); err != nil {
+			glog.Errorf("Unable to release %s: %s\n", Instance.Name, err.Error())
 			return nil, InternalServerError()
-		} else {
-			glog.Errorf("Successfully scheduled db to be removed.")
-			response.Async = true
-			return &response, nil
 		}
+		response.Async = false
+		return &response, nil
+	}
+
+	// Pre-deprovision hooks must succeed before the domain is deleted, the delete is scheduled
+	// once they have finished. If they fail an operator can skip them (see SkipHooksAction).
+	// The contacts are emailed by the worker once the domain is deleted.
+	hookTask, err := ScheduleHooksThen(b.storage, Instance, PreDeprovisionHook, DeleteTask, Instance.Name)
+	if err != nil {
+		glog.Errorf("Error: Unable to schedule pre-deprovision hooks! (%s): %s\n", Instance.Name, err.Error())
+		return nil, InternalServerError()
+	}
+	if hookTask != "" {
+		response.Async = true
+		response.OperationKey = b.newTaskOperationKey(request.InstanceID, DeprovisionOperation, hookTask)
+		return &response, nil
+	}
+
+	// Deleting the domain takes many minutes, the worker deletes it and only removes the
+	// instance once aws no longer knows of it.
+	if err = deprovisionUnlessHeld(b.storage, provider, Instance); err != nil {
+		glog.Errorf("Error failed to deprovision, the worker will retry: (Id: %s Name: %s) %s\n", Instance.Id, Instance.Name, err.Error())
 	}
-	if err = b.storage.DeleteInstance(Instance); err != nil {
-		glog.Errorf("Error removing record from provisioned table: %s\n", err.Error())
+	if _, err = b.storage.AddTask(Instance.Id, DeleteTask, Instance.Name); err != nil {
+		glog.Errorf("Error: Unable to schedule delete from provider! (%s): %s\n", Instance.Name, err.Error())
 		return nil, InternalServerError()
 	}
-	response.Async = false
+	response.Async = true
+	response.OperationKey = b.newOperationKey(request.InstanceID, DeprovisionOperation)
 	return &response, nil
 }
 
@@ -245,26 +540,82 @@ func (b *BusinessLogic) Update(request *osb.UpdateInstanceRequest, c *broker.Req
 		glog.Errorf("Error finding instance id (during deprovision) from provisioned table: %s\n", err.Error())
 		return nil, InternalServerError()
 	}
-	if request.PlanID == nil {
+	if err = b.checkNotFrozen(request.InstanceID); err != nil {
+		return nil, err
+	}
+	release, err := b.beginOperation(request.InstanceID, "update")
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	parameters, err := ParseInstanceParameters(request.Parameters)
+	if err != nil {
+		return nil, UnprocessableEntityWithMessage("InvalidParameters", err.Error())
+	}
+	maintenanceInfo := requestMaintenanceInfo(c)
+	if request.PlanID == nil && parameters == nil && maintenanceInfo == nil {
 		return nil, UnprocessableEntity()
 	}
+	if request.PlanID == nil {
+		request.PlanID = &Instance.Plan.ID
+	}
 
 	if !IsAvailable(Instance.Status) {
 		return nil, UnprocessableEntityWithMessage("ConcurrencyError", "Clients MUST wait until pending requests have completed for the specified resources.")
 	}
 
-	if strings.ToLower(*request.PlanID) == strings.ToLower(Instance.Plan.ID) {
-		return nil, UnprocessableEntityWithMessage("UpgradeError", "Cannot upgrade to the same plan.")
-	}
-
 	target_plan, err := b.storage.GetPlanByID(*request.PlanID)
 	if err != nil {
 		glog.Errorf("Unable to provision resource (GetPlanByID failed): %s\n", err.Error())
 		return nil, err
 	}
+	if err = checkMaintenanceInfo(maintenanceInfo, target_plan); err != nil {
+		return nil, err
+	}
+	if err = checkParameterPolicy(target_plan, UpdateParameters, request.Parameters); err != nil {
+		return nil, err
+	}
+	// a maintenance update upgrades the engine to the version of the plan
+	maintenance := maintenanceInfo != nil && target_plan.ID == Instance.Plan.ID && maintenanceInfo.Version != InstanceMaintenanceInfo(Instance).Version
+
+	if strings.ToLower(*request.PlanID) == strings.ToLower(Instance.Plan.ID) && parameters == nil && !maintenance {
+		return nil, UnprocessableEntityWithMessage("UpgradeError", "Cannot upgrade to the same plan.")
+	}
+
+	if invalid, ok := ValidatePlan(target_plan).(*PlanValidationError); ok {
+		return nil, UnprocessableEntityWithMessage("InvalidPlan", invalid.Error())
+	}
+	if target_plan.ID != Instance.Plan.ID && target_plan.Deprecated {
+		return nil, deprecatedPlanError(b.storage, target_plan)
+	}
+	if target_plan.ID != Instance.Plan.ID {
+		space, _, err := b.storage.GetSpace(Instance.Id)
+		if err != nil {
+			glog.Errorf("Unable to get the space of %s: %s\n", Instance.Id, err.Error())
+			return nil, InternalServerError()
+		}
+		if err = b.checkPlanVisible(target_plan, Instance.Owner, space); err != nil {
+			return nil, err
+		}
+	}
+	if maintenance {
+		b.rehearsalWarning(Instance, target_plan, c)
+	}
+	// the parameters are kept across plan changes, so they must also fit the new plan
+	if parameters != nil {
+		parameters = Instance.Parameters.Merge(parameters)
+	}
+	if parameters != nil || Instance.Parameters != nil {
+		if err = ValidateInstanceParameters(target_plan, Instance.Parameters.Merge(parameters)); err != nil {
+			return nil, UnprocessableEntityWithMessage("InvalidParameters", err.Error())
+		}
+	}
+	if err = checkPlanTransition(Instance, target_plan, parameters); err != nil {
+		return nil, err
+	}
 
 	if Instance.Plan.Provider == target_plan.Provider {
-		byteData, err := json.Marshal(ChangePlansTaskMetadata{Plan:*request.PlanID})
+		byteData, err := json.Marshal(ChangePlansTaskMetadata{Plan:*request.PlanID, Parameters: parameters, Maintenance: maintenance})
 		if err != nil {
 			glog.Errorf("Unable to marshal change plans task meta data: %s\n", err.Error())
 			return nil, err
@@ -273,7 +624,14 @@ func (b *BusinessLogic) Update(request *osb.UpdateInstanceRequest, c *broker.Req
 			glog.Errorf("Error: Unable to schedule upgrade of a plan! (%s): %s\n", Instance.Name, err.Error())
 			return nil, err
 		}
+		// Changing to a plan without a ttl keeps the instance, changing to one with a ttl starts it.
+		if err = b.storage.SetExpires(Instance.Id, ExpiresAt(target_plan, time.Now())); err != nil {
+			glog.Errorf("Error: Unable to set the expiry of instance (%s): %s\n", Instance.Name, err.Error())
+		}
+		b.updateContextTags(Instance, request.Context)
+		b.updateRequestedParameters(Instance, request.Parameters)
 		response.Async = true
+		response.OperationKey = b.newOperationKey(Instance.Id, UpdateOperation)
 		return &response, nil
 	} else {
 		return nil, UnprocessableEntityWithMessage("UpgradeError", "Cannot upgrade or change plans across provider types.")
@@ -281,6 +639,17 @@ func (b *BusinessLogic) Update(request *osb.UpdateInstanceRequest, c *broker.Req
 }
 
 func (b *BusinessLogic) LastOperation(request *osb.LastOperationRequest, c *broker.RequestContext) (*broker.LastOperationResponse, error) {
+	key := b.polledOperation(request)
+	response, err := b.lastOperation(request, key, c)
+	if err != nil {
+		return nil, err
+	}
+	describeOperation(key, response)
+	b.recordOperationOutcome(request.InstanceID, key, response, c)
+	return response, nil
+}
+
+func (b *BusinessLogic) lastOperation(request *osb.LastOperationRequest, key *OperationKey, c *broker.RequestContext) (*broker.LastOperationResponse, error) {
 	response := broker.LastOperationResponse{}
 	
 	upgrading, err := b.storage.IsUpgrading(request.InstanceID)
@@ -295,6 +664,39 @@ func (b *BusinessLogic) LastOperation(request *osb.LastOperationRequest, c *brok
 		return nil, InternalServerError()
 	}
 
+	deleting, err := b.storage.IsDeleting(request.InstanceID)
+	if err != nil {
+		glog.Errorf("Unable to get resource (%s) status, IsDeleting failed: %s\n", request.InstanceID, err.Error()) 
+		return nil, InternalServerError()
+	}
+
+	if deleting {
+		desc := "deprovisioning"
+		if task, err := b.storage.GetLastTask(request.InstanceID, DeleteTask); err == nil && task.Result != "" {
+			desc = "deprovisioning (" + task.Result + ")"
+		}
+		response.Description = &desc
+		response.State = osb.StateInProgress
+		return &response, nil
+	} else if task, err := b.storage.GetLastTask(request.InstanceID, DeleteTask); err == nil && task.Status == "failed" {
+		desc := "Deprovisioning failed: " + task.Result
+		response.Description = &desc
+		response.State = osb.StateFailed
+		return &response, nil
+	} else if failed := b.failedPreDeprovisionHook(request.InstanceID, key); failed != nil {
+		return failed, nil
+	}
+
+	if task, timedout := IsTimedOut(b.storage, request.InstanceID); timedout {
+		response.Description = &task.Result
+		response.State = osb.StateFailed
+		return &response, nil
+	}
+
+	if failed := b.failedUpdate(request.InstanceID, key); failed != nil {
+		return failed, nil
+	}
+
 	if upgrading {
 		desc := "upgrading"
 		Instance, err := b.GetInstanceById(request.InstanceID)
@@ -306,6 +708,9 @@ func (b *BusinessLogic) LastOperation(request *osb.LastOperationRequest, c *brok
 		return &response, nil
 	} else if restoring {		
 		desc := "restoring"
+		if task, err := b.storage.GetLastTask(request.InstanceID, RestoreTask); err == nil && task.Result != "" {
+			desc = "restoring (" + task.Result + ")"
+		}
 		Instance, err := b.GetInstanceById(request.InstanceID)
 		if err == nil && !IsAvailable(Instance.Status) {
 			desc = Instance.Status
@@ -315,9 +720,41 @@ func (b *BusinessLogic) LastOperation(request *osb.LastOperationRequest, c *brok
 		return &response, nil
 	}  
 
+	// While an instance is being provisioned the worker polls aws and persists its status,
+	// so report that rather than describing the domain on every poll.
+	if task, err := b.storage.GetLastTaskIn(request.InstanceID, []TaskAction{PerformPostProvisionTask, ResyncFromProviderUntilAvailableTask}); err == nil {
+		entry, err := b.storage.GetInstance(request.InstanceID)
+		if err != nil && err.Error() == "Cannot find resource instance" {
+			return nil, NotFound()
+		} else if err != nil {
+			glog.Errorf("Unable to get resource (%s) status: %s\n", request.InstanceID, err.Error()) 
+			return nil, InternalServerError()
+		}
+		if task.Status == "pending" || task.Status == "started" {
+			desc := entry.Status
+			if strings.HasPrefix(task.Result, endpointUnreachable) {
+				desc = task.Result
+			} else {
+				desc = provisionProgress(b.storage, request.InstanceID, entry.PlanId, entry.Status)
+			}
+			response.Description = &desc
+			response.State = osb.StateInProgress
+			return &response, nil
+		} else if task.Status == "failed" && (!IsAvailable(entry.Status) || strings.Contains(task.Result, endpointUnreachable)) {
+			desc := "Provisioning failed: " + task.Result
+			response.Description = &desc
+			response.State = osb.StateFailed
+			return &response, nil
+		}
+	}
+
 
 	Instance, err := b.GetInstanceById(request.InstanceID)
 	if err != nil && err.Error() == "Cannot find resource instance" {
+		// instances are only removed once their domain is gone, gone tells the platform the deprovision succeeded
+		if err = b.storage.ValidateInstanceID(request.InstanceID); err != nil && err.Error() == "The instance id is already in use (even if deleted)" {
+			return nil, Gone()
+		}
 		return nil, NotFound()
 	} else if err != nil {
 		glog.Errorf("Unable to get resource (%s) status: %s\n", request.InstanceID, err.Error()) 
@@ -352,6 +789,13 @@ func (b *BusinessLogic) Bind(request *osb.BindRequest, c *broker.RequestContext)
 	if Instance.Ready == false {
 		return nil, UnprocessableEntity()
 	}
+	if err = b.checkNotFrozen(request.InstanceID); err != nil {
+		return nil, err
+	}
+	space := contextSpace(request.Context)
+	if err = b.checkBindingSpace(request.InstanceID, space); err != nil {
+		return nil, err
+	}
 
 	provider, err := GetProviderByPlan(b.namePrefix, Instance.Plan)
 	if err != nil {
@@ -359,21 +803,76 @@ func (b *BusinessLogic) Bind(request *osb.BindRequest, c *broker.RequestContext)
 		return nil, InternalServerError()
 	}
 
-	if request.BindResource != nil && request.BindResource.AppGUID != nil {
-		if err = provider.Tag(Instance, "Binding", request.BindingID); err != nil {
-			glog.Errorf("Error tagging: %s with %s, got %s\n", request.InstanceID, *request.BindResource.AppGUID, err.Error())
+	params, err := ParseBindingParameters(request.Parameters)
+	if err != nil {
+		return nil, UnprocessableEntityWithMessage("InvalidParameters", "The binding parameters were invalid: " + err.Error())
+	}
+	if status, _, err := b.storage.GetBindingStatus(request.InstanceID, request.BindingID); err == nil && (status == BindingInProgress || status == BindingUnbinding) {
+		return nil, UnprocessableEntityWithMessage("ConcurrencyError", "The binding is being created or removed, try again once it is finished.")
+	}
+
+	// a binding rotating a predecessor keeps its parameters unless it is given new ones
+	predecessor := predecessorBindingID(c)
+	if predecessor != "" {
+		if status, _, err := b.storage.GetBindingStatus(request.InstanceID, predecessor); err != nil || (status != BindingBound && status != BindingDeactivated) {
+			return nil, BadRequestWithMessage("The predecessor binding " + predecessor + " is not a binding of this instance.")
+		}
+		if request.Parameters == nil {
+			if params, err = b.getBindingParameters(request.InstanceID, predecessor); err != nil {
+				glog.Errorf("Unable to get binding parameters for %s: %s\n", predecessor, err.Error())
+				return nil, InternalServerError()
+			}
+		}
+	}
+	if _, err = BindingExpiresAt(Instance.Plan, params, time.Now()); err != nil {
+		return nil, UnprocessableEntityWithMessage("InvalidParameters", "The binding parameters were invalid: " + err.Error())
+	}
+	byteData, err := json.Marshal(params)
+	if err != nil {
+		glog.Errorf("Unable to marshal binding parameters: %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	if err = b.storage.AddBinding(request.InstanceID, request.BindingID, string(byteData)); err != nil {
+		glog.Errorf("Unable to record binding %s: %s\n", request.BindingID, err.Error())
+		return nil, InternalServerError()
+	}
+	if space != "" {
+		if err = b.storage.SetBindingSpace(request.InstanceID, request.BindingID, space); err != nil {
+			glog.Errorf("Unable to record the space of binding %s: %s\n", request.BindingID, err.Error())
 			return nil, InternalServerError()
 		}
-		if err = provider.Tag(Instance, "App", *request.BindResource.AppGUID); err != nil {
-			glog.Errorf("Error tagging: %s with %s, got %s\n", request.InstanceID, *request.BindResource.AppGUID, err.Error())
+	}
+
+	appGuid := ""
+	if request.BindResource != nil && request.BindResource.AppGUID != nil {
+		appGuid = *request.BindResource.AppGUID
+	}
+
+	// finish binding in the task worker if the platform will poll for it
+	if request.AcceptsIncomplete {
+		operation, err := b.addBindingTask(request.InstanceID, BindTask, BindingInProgress, BindingTaskMetadata{Binding: request.BindingID, AppGUID: appGuid, Predecessor: predecessor})
+		if err != nil {
+			glog.Errorf("Unable to schedule binding %s: %s\n", request.BindingID, err.Error())
 			return nil, InternalServerError()
 		}
+		return &broker.BindResponse{
+			BindResponse: osb.BindResponse{
+				Async: true,
+				OperationKey: operation,
+			},
+		}, nil
+	}
+
+	credentials, err := CreateBinding(b.storage, provider, Instance, request.BindingID, appGuid, predecessor, params)
+	if err != nil {
+		glog.Errorf("Unable to create binding %s on %s: %s\n", request.BindingID, request.InstanceID, err.Error())
+		return nil, InternalServerError()
 	}
 
 	return &broker.BindResponse{
 		BindResponse: osb.BindResponse{
 			Async: false,
-			Credentials:provider.GetUrl(Instance),
+			Credentials:credentials,
 		},
 	}, nil
 }
@@ -392,6 +891,9 @@ func (b *BusinessLogic) Unbind(request *osb.UnbindRequest, c *broker.RequestCont
 	if Instance.Ready == false {
 		return nil, UnprocessableEntity()
 	}
+	if err = b.checkNotFrozen(request.InstanceID); err != nil {
+		return nil, err
+	}
 
 	provider, err := GetProviderByPlan(b.namePrefix, Instance.Plan)
 	if err != nil {
@@ -399,12 +901,28 @@ func (b *BusinessLogic) Unbind(request *osb.UnbindRequest, c *broker.RequestCont
 		return nil, InternalServerError()
 	}
 
-	if err = provider.Untag(Instance, "Binding"); err != nil {
-		glog.Errorf("Error untagging: %s\n", err.Error())
-		return nil, InternalServerError()
+	status, _, err := b.storage.GetBindingStatus(request.InstanceID, request.BindingID)
+	if err == nil && (status == BindingInProgress || status == BindingUnbinding) {
+		return nil, UnprocessableEntityWithMessage("ConcurrencyError", "The binding is being created or removed, try again once it is finished.")
 	}
-	if err = provider.Untag(Instance, "App"); err != nil {
-		glog.Errorf("Error untagging: got %s\n", err.Error())
+
+	// finish unbinding in the task worker if the platform will poll for it
+	if request.AcceptsIncomplete && err == nil {
+		operation, err := b.addBindingTask(request.InstanceID, UnbindTask, BindingUnbinding, BindingTaskMetadata{Binding: request.BindingID})
+		if err != nil {
+			glog.Errorf("Unable to schedule unbinding %s: %s\n", request.BindingID, err.Error())
+			return nil, InternalServerError()
+		}
+		return &broker.UnbindResponse{
+			UnbindResponse: osb.UnbindResponse{
+				Async: true,
+				OperationKey: operation,
+			},
+		}, nil
+	}
+
+	if err = RemoveBinding(b.storage, provider, Instance, request.BindingID); err != nil {
+		glog.Errorf("Unable to remove binding %s: %s\n", request.BindingID, err.Error())
 		return nil, InternalServerError()
 	}
 
@@ -430,13 +948,31 @@ func (b *BusinessLogic) GetBinding(request *osb.GetBindingRequest, context *brok
 		glog.Errorf("Error finding instance id (during getbinding): %s\n", err.Error())
 		return nil, err
 	}
+	if status, _, err := b.storage.GetBindingStatus(request.InstanceID, request.BindingID); err == nil && (status == BindingInProgress || status == BindingFailed || status == BindingExpired) {
+		return nil, NotFound()
+	}
+	credentials, err := b.getBindingCredentials(request.InstanceID, request.BindingID)
+	if err != nil && err.Error() != NotFound().Error() {
+		glog.Errorf("Unable to get binding credentials for %s: %s\n", request.BindingID, err.Error())
+		return nil, InternalServerError()
+	}
+	if credentials != nil {
+		return &osb.GetBindingResponse{
+			Credentials: MaskCredentials(credentials),
+		}, nil
+	}
 	provider, err := GetProviderByPlan(b.namePrefix, Instance.Plan)
 	if err != nil {
 		glog.Errorf("Unable to provision, cannot find provider (GetProviderByPlan failed): %s\n", err.Error())
 		return nil, InternalServerError()
 	}
+	params, err := b.getBindingParameters(request.InstanceID, request.BindingID)
+	if err != nil && err.Error() != NotFound().Error() {
+		glog.Errorf("Unable to get binding parameters for %s: %s\n", request.BindingID, err.Error())
+		return nil, InternalServerError()
+	}
 	return &osb.GetBindingResponse{
-		Credentials: provider.GetUrl(Instance),
+		Credentials: MaskCredentials(params.Apply(Credentials(provider, Instance))),
 	}, nil
 }
 
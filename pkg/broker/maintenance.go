@@ -0,0 +1,161 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// Plans publish a maintenance_info (OSB 2.15) so platforms can offer upgrade-only updates when
+// the engine version in the provider private details of a plan is bumped. Its version is the
+// engine version (as semver) with a hash of the private details as build metadata, e.g.,
+// 7.10.0+3f2a9c1b7d4e. An instance reports the engine version it runs with the hash of its
+// plan, so it differs from its plan once the plans engine version is bumped. Updating it with
+// the plans maintenance_info upgrades the engine in place.
+
+var instancePath = regexp.MustCompile(`^/v2/service_instances/[^/]+$`)
+
+var semverParts = regexp.MustCompile(`^[0-9]+(\.[0-9]+){0,2}$`)
+
+type MaintenanceInfo struct {
+	Version     string `json:"version"`
+	Description string `json:"description"`
+}
+
+// maintenanceSemver pads an engine version (e.g., 7.10) to semver, unknown versions are 0.0.0.
+func maintenanceSemver(version string) string {
+	if !semverParts.MatchString(version) {
+		return "0.0.0"
+	}
+	for strings.Count(version, ".") < 2 {
+		version = version + ".0"
+	}
+	return version
+}
+
+func (plan *ProviderPlan) detailsHash() string {
+	sum := sha256.Sum256([]byte(plan.providerPrivateDetails))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// EngineVersion is the engine version in the plans provider private details, or the version
+// of the plan if the details do not set one.
+func (plan *ProviderPlan) EngineVersion() string {
+	var details struct {
+		ElasticsearchVersion string
+	}
+	if err := json.Unmarshal([]byte(plan.providerPrivateDetails), &details); err == nil && details.ElasticsearchVersion != "" {
+		return details.ElasticsearchVersion
+	}
+	if engine, ok := plan.basePlan.Metadata["engine"].(map[string]string); ok {
+		return engine["version"]
+	}
+	return ""
+}
+
+func (plan *ProviderPlan) MaintenanceInfo() MaintenanceInfo {
+	return MaintenanceInfo{
+		Version:     maintenanceSemver(plan.EngineVersion()) + "+" + plan.detailsHash(),
+		Description: "Elasticsearch " + plan.EngineVersion(),
+	}
+}
+
+// InstanceMaintenanceInfo is the maintenance_info of the engine version the instance runs.
+func InstanceMaintenanceInfo(instance *Instance) MaintenanceInfo {
+	return MaintenanceInfo{
+		Version:     maintenanceSemver(instance.EngineVersion) + "+" + instance.Plan.detailsHash(),
+		Description: "Elasticsearch " + instance.EngineVersion,
+	}
+}
+
+type maintenanceInfoKey struct{}
+
+// MaintenanceInfoMiddleware reads the maintenance_info of provision and update requests into
+// the request context, and adds the maintenance_info of each plan and instances_retrievable
+// of each service to the catalog, the OSB library drops fields it does not know of. The plans
+// and services carry them in their metadata until then.
+func MaintenanceInfoMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method == http.MethodPut || r.Method == http.MethodPatch) && instancePath.MatchString(r.URL.Path) && r.Body != nil {
+			data, err := ioutil.ReadAll(r.Body)
+			r.Body.Close()
+			r.Body = ioutil.NopCloser(bytes.NewReader(data))
+			var body struct {
+				MaintenanceInfo *MaintenanceInfo `json:"maintenance_info"`
+			}
+			if err == nil && json.Unmarshal(data, &body) == nil && body.MaintenanceInfo != nil {
+				r = r.WithContext(context.WithValue(r.Context(), maintenanceInfoKey{}, body.MaintenanceInfo))
+			}
+		}
+		if r.Method != http.MethodGet || r.URL.Path != "/v2/catalog" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		recorder := httptest.NewRecorder()
+		next.ServeHTTP(recorder, r)
+		for name, values := range recorder.Header() {
+			w.Header()[name] = values
+		}
+		body := recorder.Body.Bytes()
+		if recorder.Code == http.StatusOK {
+			if data, err := liftCatalogFields(body); err == nil {
+				body = data
+			}
+		}
+		w.WriteHeader(recorder.Code)
+		w.Write(body)
+	})
+}
+
+func liftCatalogFields(data []byte) ([]byte, error) {
+	var catalog map[string]interface{}
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		return nil, err
+	}
+	services, _ := catalog["services"].([]interface{})
+	for _, service := range services {
+		s, _ := service.(map[string]interface{})
+		if metadata, ok := s["metadata"].(map[string]interface{}); ok {
+			if retrievable, ok := metadata["instances_retrievable"]; ok {
+				s["instances_retrievable"] = retrievable
+				delete(metadata, "instances_retrievable")
+			}
+		}
+		plans, _ := s["plans"].([]interface{})
+		for _, plan := range plans {
+			p, _ := plan.(map[string]interface{})
+			metadata, _ := p["metadata"].(map[string]interface{})
+			if info, ok := metadata["maintenance_info"]; ok {
+				p["maintenance_info"] = info
+				delete(metadata, "maintenance_info")
+			}
+		}
+	}
+	return json.Marshal(catalog)
+}
+
+func requestMaintenanceInfo(c *broker.RequestContext) *MaintenanceInfo {
+	if c == nil || c.Request == nil {
+		return nil
+	}
+	info, _ := c.Request.Context().Value(maintenanceInfoKey{}).(*MaintenanceInfo)
+	return info
+}
+
+// checkMaintenanceInfo returns an error if the maintenance_info of a request is not the plans
+// (e.g., the platform has an old catalog).
+func checkMaintenanceInfo(info *MaintenanceInfo, plan *ProviderPlan) error {
+	if info != nil && info.Version != plan.MaintenanceInfo().Version {
+		return UnprocessableEntityWithMessage("MaintenanceInfoConflict", "The maintenance_info.version does not match the plan ("+plan.MaintenanceInfo().Version+"), fetch the catalog and try again.")
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package broker
+
+import (
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// Instance counts refreshed by the reconciler, so capacity and adoption
+// dashboards can be built without querying the broker database.
+type InstanceMetrics struct {
+	ByPlan          *prom.GaugeVec
+	ByStatus        *prom.GaugeVec
+	ByEngineVersion *prom.GaugeVec
+	ByOwner         *prom.GaugeVec
+	ByEngineSupport *prom.GaugeVec
+}
+
+func NewInstanceMetrics() *InstanceMetrics {
+	return &InstanceMetrics{
+		ByPlan: prom.NewGaugeVec(prom.GaugeOpts{
+			Namespace: "elasticsearch_broker",
+			Name:      "instances_by_plan",
+			Help:      "The number of instances for each plan.",
+		}, []string{"plan"}),
+		ByStatus: prom.NewGaugeVec(prom.GaugeOpts{
+			Namespace: "elasticsearch_broker",
+			Name:      "instances_by_status",
+			Help:      "The number of instances in each status.",
+		}, []string{"status"}),
+		ByEngineVersion: prom.NewGaugeVec(prom.GaugeOpts{
+			Namespace: "elasticsearch_broker",
+			Name:      "instances_by_engine_version",
+			Help:      "The number of instances running each engine version.",
+		}, []string{"engine", "version"}),
+		ByOwner: prom.NewGaugeVec(prom.GaugeOpts{
+			Namespace: "elasticsearch_broker",
+			Name:      "instances_by_owner",
+			Help:      "The number of instances each owner has.",
+		}, []string{"owner"}),
+		ByEngineSupport: prom.NewGaugeVec(prom.GaugeOpts{
+			Namespace: "elasticsearch_broker",
+			Name:      "instances_by_engine_support",
+			Help:      "The number of instances on engine versions that are supported, approaching their end of support (approaching-eol), past it (eol) or unknown.",
+		}, []string{"status"}),
+	}
+}
+
+func (m *InstanceMetrics) Register(reg prom.Registerer) {
+	reg.MustRegister(m.ByPlan, m.ByStatus, m.ByEngineVersion, m.ByOwner, m.ByEngineSupport)
+}
+
+type instanceCounts struct {
+	plans    map[string]int
+	statuses map[string]int
+	versions map[[2]string]int
+	owners   map[string]int
+	support  map[string]int
+}
+
+func newInstanceCounts() *instanceCounts {
+	return &instanceCounts{
+		plans:    make(map[string]int),
+		statuses: make(map[string]int),
+		versions: make(map[[2]string]int),
+		owners:   make(map[string]int),
+		support:  make(map[string]int),
+	}
+}
+
+func (c *instanceCounts) add(plan string, status string, engine string, version string, owner string, support string) {
+	c.plans[plan]++
+	c.statuses[status]++
+	c.versions[[2]string{engine, version}]++
+	c.owners[owner]++
+	c.support[support]++
+}
+
+// set replaces all of the gauges with the counts, so plans or owners that no
+// longer have instances are removed.
+func (m *InstanceMetrics) set(c *instanceCounts) {
+	m.ByPlan.Reset()
+	for plan, count := range c.plans {
+		m.ByPlan.WithLabelValues(plan).Set(float64(count))
+	}
+	m.ByStatus.Reset()
+	for status, count := range c.statuses {
+		m.ByStatus.WithLabelValues(status).Set(float64(count))
+	}
+	m.ByEngineVersion.Reset()
+	for version, count := range c.versions {
+		m.ByEngineVersion.WithLabelValues(version[0], version[1]).Set(float64(count))
+	}
+	m.ByOwner.Reset()
+	for owner, count := range c.owners {
+		m.ByOwner.WithLabelValues(owner).Set(float64(count))
+	}
+	m.ByEngineSupport.Reset()
+	for support, count := range c.support {
+		m.ByEngineSupport.WithLabelValues(support).Set(float64(count))
+	}
+}
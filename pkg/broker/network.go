@@ -0,0 +1,41 @@
+package broker
+
+import (
+	"github.com/golang/glog"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// NetworkInfo describes what an app team needs to reach an instance from outside
+// of the brokers cluster, e.g. to peer a VPC or open a security group.
+type NetworkInfo struct {
+	VpcId             string   `json:"vpc_id,omitempty"`
+	SubnetIds         []string `json:"subnet_ids"`
+	SecurityGroupIds  []string `json:"security_group_ids"`
+	AvailabilityZones []string `json:"availability_zones"`
+	Endpoint          string   `json:"endpoint"`
+	Ports             []int    `json:"ports"`
+	Protocol          string   `json:"protocol"`
+	Public            bool     `json:"public"`
+}
+
+// GET /v2/service_instances/{instance_id}/actions/network
+func (b *BusinessLogic) NetworkAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	Instance, err := b.GetInstanceById(InstanceID)
+	if err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Error finding instance id (during network): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	provider, err := GetProviderByPlan(b.namePrefix, Instance.Plan)
+	if err != nil {
+		glog.Errorf("Unable to get network, cannot find provider (GetProviderByPlan failed): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	network, err := provider.GetNetwork(Instance)
+	if err != nil {
+		glog.Errorf("Unable to get network information for %s: %s\n", Instance.Name, err.Error())
+		return nil, InternalServerError()
+	}
+	return network, nil
+}
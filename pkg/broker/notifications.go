@@ -0,0 +1,192 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+type NotificationEvent string
+
+const (
+	StorageDigestNotification       NotificationEvent = "storage-digest"
+	StorageAlertNotification        NotificationEvent = "storage-alert"
+	ReadOnlyRemediationNotification NotificationEvent = "read-only-remediation"
+	EOLWarningNotification          NotificationEvent = "eol-warning"
+	ClusterRedNotification          NotificationEvent = "cluster-red"
+	DeletionScheduledNotification   NotificationEvent = "deletion-scheduled"
+	DeletedNotification             NotificationEvent = "deleted"
+	UpgradeRequiredNotification     NotificationEvent = "upgrade-required"
+	QuotaWarningNotification        NotificationEvent = "quota-warning"
+	SettingsDriftNotification       NotificationEvent = "settings-drift"
+	LinkedRehearsalNotification     NotificationEvent = "linked-rehearsal"
+	BindingExpiringNotification     NotificationEvent = "binding-expiring"
+	BindingExpiredNotification      NotificationEvent = "binding-expired"
+)
+
+type NotificationChannel string
+
+const (
+	// The body posted to a webhook, json by default but e.g., a slack message
+	// ({"text":{{json (message .)}}}) can be posted instead.
+	WebhookChannel NotificationChannel = "webhook"
+	// A human readable message, available to other templates as {{message .}}
+	TextChannel NotificationChannel = "text"
+	// The subject and body of emails to an instances contacts, the body defaults to
+	// the text message.
+	EmailSubjectChannel NotificationChannel = "email-subject"
+	EmailChannel        NotificationChannel = "email"
+)
+
+// The default templates for each channel, operators can override any of them by adding
+// a row to the notification_templates table with the event, channel and template.
+var defaultNotificationTemplates = map[NotificationChannel]map[NotificationEvent]string{
+	WebhookChannel: {
+		StorageDigestNotification:       `{{json .}}`,
+		StorageAlertNotification:        `{{json .}}`,
+		ReadOnlyRemediationNotification: `{{json .}}`,
+		EOLWarningNotification:          `{{json .}}`,
+		ClusterRedNotification:          `{{json .}}`,
+		DeletionScheduledNotification:   `{{json .}}`,
+		DeletedNotification:             `{{json .}}`,
+		UpgradeRequiredNotification:     `{{json .}}`,
+		QuotaWarningNotification:        `{{json .}}`,
+		SettingsDriftNotification:       `{{json .}}`,
+		LinkedRehearsalNotification:     `{{json .}}`,
+		BindingExpiringNotification:     `{{json .}}`,
+		BindingExpiredNotification:      `{{json .}}`,
+	},
+	TextChannel: {
+		StorageDigestNotification: `Storage digest for {{.Owner}} since {{date .Since}}:
+{{range .Instances}}- {{.Name}} ({{.Plan}}): {{bytes .UsedBytes}} of {{bytes .TotalBytes}} used, growing {{bytes .GrowthBytesPerDay}} a day{{if .ProjectedFull}}, full by {{date .ProjectedFull}}{{end}}
+{{end}}`,
+		StorageAlertNotification:        `{{.Name}} is projected to reach {{.Watermark}}% of its storage by {{date .ProjectedFull}} ({{printf "%.1f" .DaysRemaining}} days), after which its indices become read-only.`,
+		ReadOnlyRemediationNotification: `Cleared the read-only block on {{len .Indices}} indices of {{.Name}}: {{join .Indices ", "}}`,
+		EOLWarningNotification: `Instances owned by {{.Owner}} on engine versions approaching or past their end of support:
+{{range .Instances}}- {{.Name}}: {{.Support.Description}}
+{{end}}`,
+		ClusterRedNotification:        `{{.Name}} is red, {{.UnassignedShards}} shards are unassigned and some data is unavailable.`,
+		DeletionScheduledNotification: `{{.Name}} ({{.Plan}}) has been scheduled for deletion, all of its data will be removed.`,
+		DeletedNotification:           `{{.Name}} ({{.Plan}}) has been deleted, all of its data was removed.`,
+		UpgradeRequiredNotification:   `{{.Name}} must be upgraded: {{.Support.Description}}`,
+		QuotaWarningNotification:      `{{.Message}}`,
+		SettingsDriftNotification: `The cluster settings of {{.Name}} have changed{{if .Reverted}} and were reverted{{end}}:
+{{range .Changes}}- {{.Setting}}: {{if .Previous}}{{.Previous}}{{else}}(default){{end}} to {{if .Current}}{{.Current}}{{else}}(default){{end}}
+{{end}}`,
+		LinkedRehearsalNotification: `The rehearsal of {{.Version}} on {{.Name}}, the staging instance of {{.ProductionName}}, {{.Status}}{{if .Result}}: {{.Result}}{{end}}`,
+		BindingExpiringNotification: `The credentials of the binding {{.BindingId}} of {{.Name}} expire on {{date .Expires}} at {{.Expires.UTC.Format "15:04 MST"}}, create a new binding (e.g., with the predecessor_binding_id of this one) before then.`,
+		BindingExpiredNotification:  `The credentials of the binding {{.BindingId}} of {{.Name}} expired and were revoked.`,
+	},
+	EmailSubjectChannel: {
+		StorageDigestNotification:       `Weekly elasticsearch storage digest`,
+		StorageAlertNotification:        `{{.Name}} is running out of storage`,
+		ReadOnlyRemediationNotification: `Writes to {{.Name}} have been re-enabled`,
+		EOLWarningNotification:          `Elasticsearch versions approaching end of support`,
+		ClusterRedNotification:          `{{.Name}} is red`,
+		DeletionScheduledNotification:   `{{.Name}} is scheduled for deletion`,
+		DeletedNotification:             `{{.Name}} has been deleted`,
+		UpgradeRequiredNotification:     `{{.Name}} must be upgraded`,
+		QuotaWarningNotification:        `{{.Owner}} is approaching its elasticsearch instance quota`,
+		SettingsDriftNotification:       `The cluster settings of {{.Name}} have changed`,
+		LinkedRehearsalNotification:     `The rehearsal on the staging instance of {{.ProductionName}} {{.Status}}`,
+		BindingExpiringNotification:     `The credentials of a binding of {{.Name}} expire soon`,
+		BindingExpiredNotification:      `The credentials of a binding of {{.Name}} expired`,
+	},
+}
+
+func formatBytes(value interface{}) string {
+	var b float64
+	switch v := value.(type) {
+	case int64:
+		b = float64(v)
+	case int:
+		b = float64(v)
+	case float64:
+		b = v
+	default:
+		return fmt.Sprint(value)
+	}
+	units := []string{"B", "KB", "MB", "GB", "TB", "PB"}
+	i := 0
+	for (b >= 1024 || b <= -1024) && i < len(units)-1 {
+		b = b / 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f%s", b, units[i])
+}
+
+func formatDate(value interface{}) string {
+	switch v := value.(type) {
+	case time.Time:
+		return v.Format("2006-01-02")
+	case *time.Time:
+		if v != nil {
+			return v.Format("2006-01-02")
+		}
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+func getNotificationTemplate(storage Storage, event NotificationEvent, channel NotificationChannel) (string, error) {
+	text, err := storage.GetNotificationTemplate(string(event), string(channel))
+	if err != nil {
+		return "", err
+	}
+	if text == "" {
+		text = defaultNotificationTemplates[channel][event]
+	}
+	if text == "" && channel == EmailChannel {
+		text = `{{message .}}`
+	}
+	return text, nil
+}
+
+// RenderNotification renders the template of the event for channel with data, the
+// templates can use env, json, bytes, date, join and message (the text channel).
+func RenderNotification(storage Storage, event NotificationEvent, channel NotificationChannel, data interface{}) (string, error) {
+	text, err := getNotificationTemplate(storage, event, channel)
+	if err != nil {
+		return "", err
+	}
+	funcs := template.FuncMap{
+		"env":   os.Getenv,
+		"bytes": formatBytes,
+		"date":  formatDate,
+		"join":  strings.Join,
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+		"message": func(v interface{}) (string, error) {
+			if channel == TextChannel {
+				return "", errors.New("message cannot be used in a text template")
+			}
+			return RenderNotification(storage, event, TextChannel, v)
+		},
+	}
+	t, err := template.New(string(event) + "-" + string(channel)).Funcs(funcs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err = t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// Notify renders the webhook template of the event and posts it to url, signed with
+// secret if it is set.
+func Notify(storage Storage, event NotificationEvent, url string, secret string, data interface{}) error {
+	body, err := RenderNotification(storage, event, WebhookChannel, data)
+	if err != nil {
+		return err
+	}
+	return postSigned(url, secret, []byte(body))
+}
@@ -0,0 +1,137 @@
+package broker
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// Provisions, updates and deprovisions return an operation key naming the operation, when it
+// started and its attempt (how many operations of the type the instance has had), e.g.,
+// update:1697468645:2. The keys are recorded per instance so last_operation reports which
+// operation is in progress, including upgrades aws reports as UpgradeProcessing. Platforms
+// that poll without a key (or with the instance id, as older brokers returned) get the last
+// operation recorded for the instance.
+
+const (
+	ProvisionOperation   = "provision"
+	UpdateOperation      = "update"
+	DeprovisionOperation = "deprovision"
+)
+
+type OperationKey struct {
+	Type    string    `json:"type"`
+	Started time.Time `json:"started"`
+	Attempt int       `json:"attempt"`
+	// The task the operation waits on, e.g., the pre-deprovision hooks of a deprovision. It is
+	// recorded with the key but is not part of it.
+	Task string `json:"task,omitempty"`
+}
+
+func (k *OperationKey) String() string {
+	return k.Type + ":" + strconv.FormatInt(k.Started.Unix(), 10) + ":" + strconv.Itoa(k.Attempt)
+}
+
+// ParseOperationKey parses a key returned by the broker, it returns nil for keys that are not
+// typed.
+func ParseOperationKey(key string) *OperationKey {
+	parts := strings.Split(key, ":")
+	if len(parts) != 3 {
+		return nil
+	}
+	if parts[0] != ProvisionOperation && parts[0] != UpdateOperation && parts[0] != DeprovisionOperation {
+		return nil
+	}
+	started, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil
+	}
+	attempt, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return nil
+	}
+	return &OperationKey{Type: parts[0], Started: time.Unix(started, 0), Attempt: attempt}
+}
+
+// newOperationKey records a new operation on the instance and returns its key, an operation
+// that can't be recorded is still returned with the legacy (untyped) key.
+func (b *BusinessLogic) newOperationKey(InstanceID string, operation string) *osb.OperationKey {
+	return b.newTaskOperationKey(InstanceID, operation, "")
+}
+
+// newTaskOperationKey records a new operation that waits on the task, see OperationKey.Task.
+func (b *BusinessLogic) newTaskOperationKey(InstanceID string, operation string, task string) *osb.OperationKey {
+	key, err := b.storage.AddOperationKey(InstanceID, operation, task)
+	if err != nil {
+		glog.Errorf("Unable to record the %s operation of %s: %s\n", operation, InstanceID, err.Error())
+		opkey := osb.OperationKey(InstanceID)
+		return &opkey
+	}
+	opkey := osb.OperationKey(key.String())
+	return &opkey
+}
+
+// currentOperationKey returns the key of the last operation of the type on the instance, so
+// repeated requests (e.g., deprovisioning an instance being deleted) poll the same operation.
+func (b *BusinessLogic) currentOperationKey(InstanceID string, operation string) *osb.OperationKey {
+	if key, err := b.storage.GetOperationKey(InstanceID); err == nil && key.Type == operation {
+		opkey := osb.OperationKey(key.String())
+		return &opkey
+	}
+	return b.newOperationKey(InstanceID, operation)
+}
+
+// polledOperation is the operation a last_operation request polls, nil if it is unknown.
+// Only the last operation of the instance has its task.
+func (b *BusinessLogic) polledOperation(request *osb.LastOperationRequest) *OperationKey {
+	key, err := b.storage.GetOperationKey(request.InstanceID)
+	if err != nil {
+		if err.Error() != "Not found" {
+			glog.Errorf("Unable to get the last operation of %s: %s\n", request.InstanceID, err.Error())
+		}
+		key = nil
+	}
+	if request.OperationKey != nil {
+		if polled := ParseOperationKey(string(*request.OperationKey)); polled != nil {
+			if key != nil && key.Type == polled.Type && key.Attempt == polled.Attempt {
+				polled.Task = key.Task
+			}
+			return polled
+		}
+	}
+	return key
+}
+
+// failedPreDeprovisionHook reports the deprovision polled as failed if one of its
+// pre-deprovision hooks failed, earlier deprovisions are not considered.
+func (b *BusinessLogic) failedPreDeprovisionHook(InstanceID string, key *OperationKey) *broker.LastOperationResponse {
+	if key == nil || key.Type != DeprovisionOperation || key.Task == "" {
+		return nil
+	}
+	task, err := b.storage.GetLastTask(InstanceID, RunPreDeprovisionHookTask)
+	if err != nil || task.Id != key.Task || task.Status != "failed" {
+		return nil
+	}
+	desc := "A pre-deprovision hook failed, an operator can skip the hooks with the skip-hooks action: " + task.Result
+	response := broker.LastOperationResponse{}
+	response.Description = &desc
+	response.State = osb.StateFailed
+	return &response
+}
+
+// describeOperation names the operation in the description of an operation in progress,
+// e.g., "update in progress (upgrading)".
+func describeOperation(key *OperationKey, response *broker.LastOperationResponse) {
+	if key == nil || response.State != osb.StateInProgress {
+		return
+	}
+	desc := key.Type + " in progress"
+	if response.Description != nil && *response.Description != "" && *response.Description != "deprovisioning" {
+		desc = desc + " (" + *response.Description + ")"
+	}
+	response.Description = &desc
+}
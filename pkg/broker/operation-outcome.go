@@ -0,0 +1,103 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+
+	"github.com/golang/glog"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// The last_operation of a failed update tells the platform whether the instance survived the
+// failed change (instance_usable) and whether repeating the update is safe (update_repeatable,
+// OSB 2.16). An instance is usable if its domain is available again, and the update can be
+// repeated once nothing else is running on it. The OSB library drops fields it does not know
+// of, so the last operation records them on the request context and OperationOutcomeMiddleware
+// adds them to the response.
+
+var lastOperationPath = regexp.MustCompile(`^/v2/service_instances/[^/]+/last_operation$`)
+
+type OperationOutcome struct {
+	InstanceUsable   bool `json:"instance_usable"`
+	UpdateRepeatable bool `json:"update_repeatable"`
+	recorded         bool
+}
+
+type operationOutcomeKey struct{}
+
+// OperationOutcomeMiddleware adds the outcome of a failed update to its last_operation.
+func OperationOutcomeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || !lastOperationPath.MatchString(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		outcome := &OperationOutcome{}
+		r = r.WithContext(context.WithValue(r.Context(), operationOutcomeKey{}, outcome))
+		recorder := httptest.NewRecorder()
+		next.ServeHTTP(recorder, r)
+		for name, values := range recorder.Header() {
+			w.Header()[name] = values
+		}
+		body := recorder.Body.Bytes()
+		if recorder.Code == http.StatusOK && outcome.recorded {
+			if data, err := addOperationOutcome(body, outcome); err == nil {
+				body = data
+			}
+		}
+		w.WriteHeader(recorder.Code)
+		w.Write(body)
+	})
+}
+
+func addOperationOutcome(data []byte, outcome *OperationOutcome) ([]byte, error) {
+	var response map[string]interface{}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, err
+	}
+	response["instance_usable"] = outcome.InstanceUsable
+	response["update_repeatable"] = outcome.UpdateRepeatable
+	return json.Marshal(response)
+}
+
+// failedUpdate reports the update polled as failed if its plan change failed.
+func (b *BusinessLogic) failedUpdate(InstanceID string, key *OperationKey) *broker.LastOperationResponse {
+	if key == nil || key.Type != UpdateOperation {
+		return nil
+	}
+	task, err := b.storage.GetLastTask(InstanceID, ChangePlansTask)
+	if err != nil || task.Status != "failed" || task.Started == nil || task.Started.Before(key.Started) {
+		return nil
+	}
+	desc := "Updating failed: " + task.Result
+	response := broker.LastOperationResponse{}
+	response.Description = &desc
+	response.State = osb.StateFailed
+	return &response
+}
+
+// recordOperationOutcome records whether the instance of a failed update is usable and the
+// update can be repeated.
+func (b *BusinessLogic) recordOperationOutcome(InstanceID string, key *OperationKey, response *broker.LastOperationResponse, c *broker.RequestContext) {
+	if key == nil || key.Type != UpdateOperation || response.State != osb.StateFailed || c == nil || c.Request == nil {
+		return
+	}
+	outcome, ok := c.Request.Context().Value(operationOutcomeKey{}).(*OperationOutcome)
+	if !ok {
+		return
+	}
+	if instance, err := b.GetInstanceById(InstanceID); err != nil {
+		glog.Errorf("Unable to get the instance %s for the outcome of its update: %s\n", InstanceID, err.Error())
+	} else {
+		outcome.InstanceUsable = IsAvailable(instance.Status)
+	}
+	if outcome.InstanceUsable {
+		running, err := runningOperation(b.storage, InstanceID)
+		outcome.UpdateRepeatable = err == nil && running == ""
+	}
+	outcome.recorded = true
+}
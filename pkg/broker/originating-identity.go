@@ -0,0 +1,85 @@
+package broker
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// Platforms send who requested an operation in the X-Broker-API-Originating-Identity header
+// (OSB 2.13) as the platform and its base64 encoded json identity, e.g., "cloudfoundry
+// eyJ1c2VyX2lkIjoiNjgzZWE3NDgifQ==". The identity of a provision is kept with the instance
+// and its user is tagged on the domain as created-by.
+
+const originatingIdentityHeader = "X-Broker-API-Originating-Identity"
+
+type OriginatingIdentity struct {
+	Platform string          `json:"platform"`
+	User     string          `json:"user"`
+	Value    json.RawMessage `json:"value"`
+}
+
+// identityUserFields are the fields of the identities of known platforms that name the user,
+// cloud foundry sends a user_id and kubernetes a username.
+var identityUserFields = []string{"username", "user_id", "user", "email", "uid"}
+
+// tagValueInvalid matches the characters aws does not allow in tag values.
+var tagValueInvalid = regexp.MustCompile(`[^\p{L}\p{Z}\p{N}_.:/=+\-@]`)
+
+func ParseOriginatingIdentity(header string) (*OriginatingIdentity, error) {
+	parts := strings.Fields(header)
+	if len(parts) != 2 {
+		return nil, errors.New("The originating identity must be the platform and its base64 encoded identity.")
+	}
+	data, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("The originating identity was not base64 encoded: " + err.Error())
+	}
+	var value map[string]interface{}
+	if err = json.Unmarshal(data, &value); err != nil {
+		return nil, errors.New("The originating identity was not a json object: " + err.Error())
+	}
+	identity := &OriginatingIdentity{Platform: parts[0], Value: json.RawMessage(data)}
+	for _, field := range identityUserFields {
+		if user, ok := value[field].(string); ok && user != "" {
+			identity.User = user
+			break
+		}
+	}
+	return identity, nil
+}
+
+// requestOriginatingIdentity is the originating identity of the request, or nil if it was not
+// sent (or could not be parsed, the identity is informational).
+func requestOriginatingIdentity(c *broker.RequestContext) *OriginatingIdentity {
+	if c == nil || c.Request == nil || c.Request.Header.Get(originatingIdentityHeader) == "" {
+		return nil
+	}
+	identity, err := ParseOriginatingIdentity(c.Request.Header.Get(originatingIdentityHeader))
+	if err != nil {
+		glog.Errorf("Unable to parse the originating identity of the request: %s\n", err.Error())
+		return nil
+	}
+	return identity
+}
+
+// TagCreatedBy tags the domain with the user whose request created it, if it is known.
+func TagCreatedBy(storage Storage, provider Provider, instance *Instance) error {
+	identity, err := storage.GetOriginatingIdentity(instance.Id)
+	if err != nil {
+		return err
+	}
+	if identity == nil || identity.User == "" {
+		return nil
+	}
+	user := tagValueInvalid.ReplaceAllString(identity.User, "_")
+	if len(user) > 256 {
+		user = user[:256]
+	}
+	return provider.Tag(instance, "created-by", user)
+}
@@ -0,0 +1,112 @@
+package broker
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// The name of every domain is recorded as an intent before it is created and removed once an
+// instance has it. If provisioning fails after aws created the domain (e.g., the instance
+// could not be saved or the create call timed out) the intent is left behind, and the
+// reconciler deletes the orphaned domain.
+
+// orphanGracePeriod is how long a provision has to save its instance before its domain is
+// considered orphaned.
+const orphanGracePeriod = time.Minute * 30
+
+type DomainIntent struct {
+	Name       string    `json:"name"`
+	InstanceId string    `json:"instance_id"`
+	PlanId     string    `json:"plan_id"`
+	Created    time.Time `json:"created"`
+}
+
+// DeleteOrphanedDomains deletes the domains of failed provisions, the intent is removed once
+// aws no longer knows of the domain.
+func DeleteOrphanedDomains(namePrefix string, storage Storage) {
+	intents, err := storage.GetOrphanedDomainIntents(orphanGracePeriod)
+	if err != nil {
+		glog.Errorf("Unable to get orphaned domains: %s\n", err.Error())
+		return
+	}
+	for _, intent := range intents {
+		plan, err := storage.GetPlanByID(intent.PlanId)
+		if err != nil {
+			glog.Errorf("Unable to clean up the orphaned domain %s, cannot get plan %s: %s\n", intent.Name, intent.PlanId, err.Error())
+			continue
+		}
+		provider, err := GetProviderByPlan(namePrefix, plan)
+		if err != nil {
+			glog.Errorf("Unable to clean up the orphaned domain %s, cannot get provider: %s\n", intent.Name, err.Error())
+			continue
+		}
+		instance := &Instance{Id: intent.InstanceId, Name: intent.Name, Plan: plan}
+		deleted, err := provider.IsDeleted(instance)
+		if err != nil {
+			glog.Errorf("Unable to determine if the orphaned domain %s was deleted: %s\n", intent.Name, err.Error())
+			continue
+		}
+		if deleted {
+			if err = storage.RemoveDomainIntent(intent.Name); err != nil {
+				glog.Errorf("Unable to remove the intent of %s: %s\n", intent.Name, err.Error())
+			}
+			continue
+		}
+		if DryRun(DeleteOrphanAction) {
+			ReportDryRun(storage, DeleteOrphanAction, intent.InstanceId, "Would delete the orphaned domain "+intent.Name+" of a failed provision")
+			continue
+		}
+		glog.Infof("Deleting the orphaned domain %s of a failed provision of %s\n", intent.Name, intent.InstanceId)
+		if err = provider.Deprovision(instance, false); err != nil {
+			glog.Errorf("Unable to delete the orphaned domain %s: %s\n", intent.Name, err.Error())
+		}
+	}
+}
+
+// maxNameAttempts bounds how many random names are tried for a new domain, names are a
+// truncated uuid so they may clash with a domain of a long lived installation.
+const maxNameAttempts = 5
+
+// NameCollisionError is returned when the name of a new domain is already in use, by another
+// provision or by a domain aws already has.
+type NameCollisionError struct {
+	Name string
+}
+
+func (e *NameCollisionError) Error() string {
+	return "The domain name " + e.Name + " is already in use."
+}
+
+// ProvisionWithRandomName records a random name and creates the domain with it, names that are
+// already in use are regenerated. It returns the instance and the name that was recorded, the
+// intent is removed by the caller once the instance has been saved.
+func ProvisionWithRandomName(storage Storage, provider Provider, Id string, plan *ProviderPlan, Owner string) (*Instance, string, error) {
+	var collision error
+	for attempt := 0; attempt < maxNameAttempts; attempt++ {
+		name := provider.CreateRandomName()
+		if err := storage.AddDomainIntent(name, Id, plan.ID); err != nil {
+			if _, ok := err.(*NameCollisionError); ok {
+				glog.Infof("The domain name %s is already recorded, generating another for %s\n", name, Id)
+				collision = err
+				continue
+			}
+			return nil, "", err
+		}
+		instance, err := provider.Provision(Id, name, plan, Owner)
+		if _, ok := err.(*NameCollisionError); ok {
+			// the domain is not ours, its intent must be removed or the reconciler deletes it.
+			if err = storage.RemoveDomainIntent(name); err != nil {
+				glog.Errorf("Unable to remove the intent of %s, which belongs to an existing domain: %s\n", name, err.Error())
+				return nil, "", err
+			}
+			glog.Infof("aws already has a domain named %s, generating another for %s\n", name, Id)
+			collision = &NameCollisionError{Name: name}
+			continue
+		}
+		return instance, name, err
+	}
+	return nil, "", errors.New("Unable to find an unused domain name after " + strconv.Itoa(maxNameAttempts) + " attempts: " + collision.Error())
+}
@@ -0,0 +1,178 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Operators control the provision and update parameters a plan accepts with its
+// parameter_policy (set with the catalog admin api), e.g., {"allow":{"instance_count":
+// {"type":"integer","minimum":2,"maximum":6,"operations":["update"]},"labels":{"type":"object"}},
+// "deny":["clone"]}. Parameters are checked against the policy before anything is parsed or
+// applied to the domain settings. A parameter on the deny list is always rejected, with an
+// allow list only the parameters on it are accepted and must match their rule: the json
+// type (string, integer, number, boolean, array or object), the bounds (of the value of
+// numbers, the length of strings and arrays), the values it may have (enum) and the
+// operations (provision or update) it is accepted on. Plans without a policy accept every
+// parameter the broker understands.
+
+const (
+	ProvisionParameters = "provision"
+	UpdateParameters    = "update"
+)
+
+var parameterRuleTypes = map[string]bool{"string": true, "integer": true, "number": true, "boolean": true, "array": true, "object": true}
+
+type ParameterRule struct {
+	Type    string        `json:"type,omitempty"`
+	Minimum *float64      `json:"minimum,omitempty"`
+	Maximum *float64      `json:"maximum,omitempty"`
+	Enum    []interface{} `json:"enum,omitempty"`
+	// The operations (provision or update) the parameter is accepted on, both if empty.
+	Operations []string `json:"operations,omitempty"`
+}
+
+type ParameterPolicy struct {
+	Allow map[string]*ParameterRule `json:"allow,omitempty"`
+	Deny  []string                  `json:"deny,omitempty"`
+}
+
+// ParseParameterPolicy reads and checks a policy, it returns nil if there is none.
+func ParseParameterPolicy(data []byte) (*ParameterPolicy, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+	var policy ParameterPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, errors.New("The parameter_policy must be {\"allow\":{...},\"deny\":[...]}.")
+	}
+	for name, rule := range policy.Allow {
+		if rule == nil {
+			return nil, errors.New("The rule of the parameter " + name + " must be an object.")
+		}
+		if rule.Type != "" && !parameterRuleTypes[rule.Type] {
+			return nil, errors.New("The type of the parameter " + name + " must be string, integer, number, boolean, array or object.")
+		}
+		if rule.Minimum != nil && rule.Maximum != nil && *rule.Minimum > *rule.Maximum {
+			return nil, errors.New("The minimum of the parameter " + name + " is above its maximum.")
+		}
+		for _, operation := range rule.Operations {
+			if operation != ProvisionParameters && operation != UpdateParameters {
+				return nil, errors.New("The operations of the parameter " + name + " must be provision or update.")
+			}
+		}
+	}
+	return &policy, nil
+}
+
+// parameterType is the json type of a decoded parameter value.
+func parameterType(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "null"
+}
+
+// check returns why the value does not match the rule, or nil if it does.
+func (rule *ParameterRule) check(name string, value interface{}) error {
+	actual := parameterType(value)
+	if rule.Type != "" && actual != rule.Type && !(rule.Type == "number" && actual == "integer") {
+		return errors.New("The parameter " + name + " must be of type " + rule.Type + ".")
+	}
+	var size float64
+	var bounded string
+	switch v := value.(type) {
+	case float64:
+		size, bounded = v, "The parameter "+name
+	case string:
+		size, bounded = float64(len(v)), "The length of the parameter "+name
+	case []interface{}:
+		size, bounded = float64(len(v)), "The number of items in the parameter "+name
+	}
+	if bounded != "" && rule.Minimum != nil && size < *rule.Minimum {
+		return errors.New(bounded + " must be at least " + strconv.FormatFloat(*rule.Minimum, 'f', -1, 64) + ".")
+	}
+	if bounded != "" && rule.Maximum != nil && size > *rule.Maximum {
+		return errors.New(bounded + " must be at most " + strconv.FormatFloat(*rule.Maximum, 'f', -1, 64) + ".")
+	}
+	if len(rule.Enum) > 0 {
+		for _, allowed := range rule.Enum {
+			if reflect.DeepEqual(allowed, value) {
+				return nil
+			}
+		}
+		data, _ := json.Marshal(rule.Enum)
+		return errors.New("The parameter " + name + " must be one of " + string(data) + ".")
+	}
+	return nil
+}
+
+// Evaluate checks the parameters of a provision or update against the policy.
+func (policy *ParameterPolicy) Evaluate(operation string, parameters map[string]interface{}) error {
+	if policy == nil {
+		return nil
+	}
+	names := make([]string, 0)
+	for name := range parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, denied := range policy.Deny {
+			if denied == name {
+				return errors.New("The parameter " + name + " is not allowed on this plan.")
+			}
+		}
+		if policy.Allow == nil {
+			continue
+		}
+		rule, ok := policy.Allow[name]
+		if !ok && len(policy.Allow) == 0 {
+			return errors.New("The parameter " + name + " is not allowed, this plan accepts no parameters.")
+		} else if !ok {
+			allowed := make([]string, 0)
+			for key := range policy.Allow {
+				allowed = append(allowed, key)
+			}
+			sort.Strings(allowed)
+			return errors.New("The parameter " + name + " is not allowed on this plan, it accepts " + strings.Join(allowed, ", ") + ".")
+		}
+		if len(rule.Operations) > 0 {
+			accepted := false
+			for _, o := range rule.Operations {
+				accepted = accepted || o == operation
+			}
+			if !accepted {
+				return errors.New("The parameter " + name + " can't be given on " + operation + " on this plan.")
+			}
+		}
+		if err := rule.check(name, parameters[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkParameterPolicy rejects parameters the policy of the plan does not accept.
+func checkParameterPolicy(plan *ProviderPlan, operation string, parameters map[string]interface{}) error {
+	if err := plan.ParameterPolicy.Evaluate(operation, parameters); err != nil {
+		return UnprocessableEntityWithMessage("ParameterNotAllowed", err.Error())
+	}
+	return nil
+}
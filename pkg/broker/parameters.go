@@ -0,0 +1,142 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/service/elasticsearchservice"
+	"github.com/golang/glog"
+)
+
+// InstanceParameters are the domain settings an owner can change with an update, they
+// override the plans provider_private_details and are kept across plan changes.
+type InstanceParameters struct {
+	InstanceCount *int64 `json:"instance_count,omitempty"`
+	VolumeSize    *int64 `json:"volume_size,omitempty"`
+	SnapshotHour  *int64 `json:"snapshot_hour,omitempty"`
+}
+
+// ParseInstanceParameters reads the instance parameters from update parameters, it
+// returns nil if none were given.
+func ParseInstanceParameters(parameters map[string]interface{}) (*InstanceParameters, error) {
+	if parameters == nil || (parameters["instance_count"] == nil && parameters["volume_size"] == nil && parameters["snapshot_hour"] == nil) {
+		return nil, nil
+	}
+	data, err := json.Marshal(parameters)
+	if err != nil {
+		return nil, err
+	}
+	var p InstanceParameters
+	if err = json.Unmarshal(data, &p); err != nil {
+		return nil, errors.New("The instance_count, volume_size and snapshot_hour must be whole numbers.")
+	}
+	if p.InstanceCount != nil && *p.InstanceCount < 1 {
+		return nil, errors.New("The instance_count must be at least 1.")
+	}
+	if p.VolumeSize != nil && *p.VolumeSize < 10 {
+		return nil, errors.New("The volume_size must be at least 10 (GiB).")
+	}
+	if p.SnapshotHour != nil && (*p.SnapshotHour < 0 || *p.SnapshotHour > 23) {
+		return nil, errors.New("The snapshot_hour must be between 0 and 23 (UTC).")
+	}
+	return &p, nil
+}
+
+// Merge returns the parameters with any set in other replacing them.
+func (p *InstanceParameters) Merge(other *InstanceParameters) *InstanceParameters {
+	merged := InstanceParameters{}
+	if p != nil {
+		merged = *p
+	}
+	if other == nil {
+		return &merged
+	}
+	if other.InstanceCount != nil {
+		merged.InstanceCount = other.InstanceCount
+	}
+	if other.VolumeSize != nil {
+		merged.VolumeSize = other.VolumeSize
+	}
+	if other.SnapshotHour != nil {
+		merged.SnapshotHour = other.SnapshotHour
+	}
+	return &merged
+}
+
+// Apply overrides the rendered plan settings with the parameters.
+func (p *InstanceParameters) Apply(settings *elasticsearchservice.CreateElasticsearchDomainInput) {
+	if p == nil {
+		return
+	}
+	if p.InstanceCount != nil {
+		if settings.ElasticsearchClusterConfig == nil {
+			settings.ElasticsearchClusterConfig = &elasticsearchservice.ElasticsearchClusterConfig{}
+		}
+		settings.ElasticsearchClusterConfig.InstanceCount = p.InstanceCount
+	}
+	if p.VolumeSize != nil {
+		if settings.EBSOptions == nil {
+			settings.EBSOptions = &elasticsearchservice.EBSOptions{}
+		}
+		settings.EBSOptions.VolumeSize = p.VolumeSize
+	}
+	if p.SnapshotHour != nil {
+		if settings.SnapshotOptions == nil {
+			settings.SnapshotOptions = &elasticsearchservice.SnapshotOptions{}
+		}
+		settings.SnapshotOptions.AutomatedSnapshotStartHour = p.SnapshotHour
+	}
+}
+
+// ValidateInstanceParameters checks the plan with the parameters applied, e.g., that the
+// instance count can be spread across its zones and the volume fits its instance type.
+func ValidateInstanceParameters(plan *ProviderPlan, p *InstanceParameters) error {
+	if !plan.Provider.IsAWSDomain() {
+		return nil
+	}
+	settings, err := planDomainInput(plan)
+	if err != nil {
+		return err
+	}
+	p.Apply(settings)
+	if err = ValidateZoneAwareness(settings.ElasticsearchClusterConfig, configuredSubnets()); err != nil {
+		return err
+	}
+	return ValidateLimits(settings)
+}
+
+// The provision and update parameters an owner gave are kept (merged, the latest value of
+// each parameter wins) and returned when the instance is fetched. The domain settings are
+// left out of them, they are reported as applied by the last successful update.
+var instanceParameterKeys = []string{"instance_count", "volume_size", "snapshot_hour"}
+
+// MergeRequestedParameters returns the requested parameters with those of an update
+// replacing them, without the domain settings.
+func MergeRequestedParameters(requested map[string]interface{}, parameters map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{})
+	for key, value := range requested {
+		merged[key] = value
+	}
+	for key, value := range parameters {
+		merged[key] = value
+	}
+	for _, key := range instanceParameterKeys {
+		delete(merged, key)
+	}
+	return merged
+}
+
+// updateRequestedParameters keeps the parameters of an update with those requested before.
+func (b *BusinessLogic) updateRequestedParameters(instance *Instance, parameters map[string]interface{}) {
+	if len(MergeRequestedParameters(nil, parameters)) == 0 {
+		return
+	}
+	requested, err := b.storage.GetRequestedParameters(instance.Id)
+	if err != nil {
+		glog.Errorf("Error: Unable to get the requested parameters of instance (%s): %s\n", instance.Name, err.Error())
+		return
+	}
+	if err = b.storage.SetRequestedParameters(instance.Id, MergeRequestedParameters(requested, parameters)); err != nil {
+		glog.Errorf("Error: Unable to set the requested parameters of instance (%s): %s\n", instance.Name, err.Error())
+	}
+}
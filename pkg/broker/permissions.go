@@ -0,0 +1,317 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/golang/glog"
+)
+
+// The AWS api calls the broker (and task worker) makes, grouped by the feature that makes
+// them. Enabled decides if the feature is in use (plans are nil when they are not known) and
+// Resources scopes the actions, both are nil when the feature is always used on any resource.
+type permissionGroup struct {
+	Purpose   string
+	Actions   []string
+	Enabled   func(plans []ProviderPlan) bool
+	Resources func(namePrefix string, plans []ProviderPlan) []string
+}
+
+var requiredPermissions = []permissionGroup{
+	{"provisioning", []string{"es:CreateElasticsearchDomain", "es:DescribeElasticsearchDomain", "es:UpdateElasticsearchDomainConfig", "es:UpdateDomainConfig", "es:DeleteElasticsearchDomain"}, nil, domainResources},
+	{"tagging", []string{"es:AddTags", "es:RemoveTags"}, nil, domainResources},
+	{"plan validation", []string{"es:DescribeElasticsearchInstanceTypeLimits"}, nil, nil},
+	{"engine versions", []string{"es:ListElasticsearchVersions", "es:GetCompatibleElasticsearchVersions"}, nil, nil},
+	{"engine upgrades", []string{"es:UpgradeElasticsearchDomain"}, nil, domainResources},
+	{"cluster access (hooks, kibana proxy)", []string{"es:ESHttpGet", "es:ESHttpPut", "es:ESHttpPost", "es:ESHttpDelete"}, nil, clusterResources},
+	{"serverless collections", []string{"aoss:CreateCollection", "aoss:BatchGetCollection", "aoss:UpdateCollection", "aoss:DeleteCollection", "aoss:TagResource", "aoss:UntagResource"}, serverlessEnabled, nil},
+	{"serverless policies", []string{"aoss:CreateSecurityPolicy", "aoss:GetSecurityPolicy", "aoss:UpdateSecurityPolicy", "aoss:DeleteSecurityPolicy", "aoss:CreateAccessPolicy", "aoss:GetAccessPolicy", "aoss:UpdateAccessPolicy", "aoss:DeleteAccessPolicy"}, serverlessEnabled, nil},
+	{"serverless cluster access (hooks, kibana proxy)", []string{"aoss:APIAccessAll", "aoss:DashboardsAccessAll"}, serverlessEnabled, nil},
+	{"vpc associations", []string{"ec2:DescribeVpcPeeringConnections", "ec2:CreateVpcPeeringConnection", "ec2:AuthorizeSecurityGroupIngress", "ec2:RevokeSecurityGroupIngress"}, nil, nil},
+	{"storage alerts", []string{"cloudwatch:GetMetricStatistics"}, envEnabled("STORAGE_ALERT_WEBHOOK"), nil},
+	{"cost timeline", []string{"ce:GetCostAndUsage", "cloudwatch:GetMetricStatistics"}, nil, nil},
+	{"snapshot repositories", []string{"iam:PassRole"}, snapshotRolesEnabled, snapshotRoleResources},
+	{"plan secrets", []string{"secretsmanager:GetSecretValue"}, planSecretsEnabled, planSecretResources},
+	{"credentials keys", []string{"kms:Decrypt"}, kmsCredentialsKeysEnabled, nil},
+	{"permissions check", []string{"iam:SimulatePrincipalPolicy"}, permissionsCheckEnabled, nil},
+}
+
+func envEnabled(name string) func([]ProviderPlan) bool {
+	return func(plans []ProviderPlan) bool {
+		return os.Getenv(name) != ""
+	}
+}
+
+func domainResources(namePrefix string, plans []ProviderPlan) []string {
+	return []string{"arn:aws:es:" + os.Getenv("AWS_REGION") + ":" + os.Getenv("AWS_ACCOUNT_ID") + ":domain/" + namePrefix + "-*"}
+}
+
+func clusterResources(namePrefix string, plans []ProviderPlan) []string {
+	return []string{"arn:aws:es:" + os.Getenv("AWS_REGION") + ":" + os.Getenv("AWS_ACCOUNT_ID") + ":domain/" + namePrefix + "-*/*"}
+}
+
+// Repositories are registered with the role the domain uses to reach s3, which the broker
+// must pass. The roles of cloned repositories are copied from the instance cloned from, so
+// they are not known ahead of time.
+func snapshotRolesEnabled(plans []ProviderPlan) bool {
+	return exportRoleArn() != "" || cloneRepository() != ""
+}
+
+func snapshotRoleResources(namePrefix string, plans []ProviderPlan) []string {
+	if cloneRepository() != "" || exportRoleArn() == "" {
+		return []string{"*"}
+	}
+	return []string{exportRoleArn()}
+}
+
+var secretReferencePattern = regexp.MustCompile(regexp.QuoteMeta(secretsManagerScheme) + `([^"#]+)`)
+
+// planSecretIds are the secrets manager secrets referenced by the plans.
+func planSecretIds(plans []ProviderPlan) []string {
+	ids := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, plan := range plans {
+		for _, match := range secretReferencePattern.FindAllStringSubmatch(plan.providerPrivateDetails, -1) {
+			if !seen[match[1]] {
+				seen[match[1]] = true
+				ids = append(ids, match[1])
+			}
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func planSecretsEnabled(plans []ProviderPlan) bool {
+	return plans == nil || len(planSecretIds(plans)) > 0
+}
+
+// Secrets referenced by name are matched with a wildcard for the suffix aws adds to their arn.
+func planSecretResources(namePrefix string, plans []ProviderPlan) []string {
+	resources := make([]string, 0)
+	for _, id := range planSecretIds(plans) {
+		if strings.HasPrefix(id, "arn:") {
+			resources = append(resources, id)
+		} else {
+			resources = append(resources, "arn:aws:secretsmanager:"+os.Getenv("AWS_REGION")+":"+os.Getenv("AWS_ACCOUNT_ID")+":secret:"+id+"-*")
+		}
+	}
+	if len(resources) == 0 {
+		return []string{"*"}
+	}
+	return resources
+}
+
+// Collection arns end in an id aws generates, so the serverless actions can't be scoped by name.
+func serverlessEnabled(plans []ProviderPlan) bool {
+	if plans == nil {
+		return true
+	}
+	for _, plan := range plans {
+		if plan.Provider == AWSServerlessInstance {
+			return true
+		}
+	}
+	return false
+}
+
+func kmsCredentialsKeysEnabled(plans []ProviderPlan) bool {
+	return strings.Contains(os.Getenv("CREDENTIALS_KEYS"), ":kms:")
+}
+
+func permissionsCheckEnabled(plans []ProviderPlan) bool {
+	return os.Getenv("SKIP_PERMISSIONS_CHECK") != "true"
+}
+
+type PermissionCheck struct {
+	Purpose string
+	Action  string
+	Allowed bool
+}
+
+// Converts an sts assumed role arn (arn:aws:sts::123:assumed-role/name/session) to the
+// role arn, the iam policy simulator does not accept session arns.
+func principalArn(arn string) string {
+	if strings.Contains(arn, ":assumed-role/") {
+		parts := strings.Split(arn, ":")
+		names := strings.Split(parts[len(parts)-1], "/")
+		if len(names) >= 2 {
+			return "arn:aws:iam::" + parts[4] + ":role/" + names[1]
+		}
+	}
+	return arn
+}
+
+// CheckPermissions uses the iam policy simulator to determine whether the brokers AWS
+// identity is allowed to perform each api call it needs.
+func CheckPermissions(plans []ProviderPlan) ([]PermissionCheck, error) {
+	sess := awsSession()
+	identity, err := sts.New(sess).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return nil, err
+	}
+	if identity.Arn == nil {
+		return nil, errors.New("Unable to determine the AWS identity of the broker.")
+	}
+	actions := make([]*string, 0)
+	for _, group := range requiredPermissions {
+		if group.Enabled != nil && !group.Enabled(plans) {
+			continue
+		}
+		for _, action := range group.Actions {
+			actions = append(actions, aws.String(action))
+		}
+	}
+	allowed := make(map[string]bool)
+	input := &iam.SimulatePrincipalPolicyInput{
+		PolicySourceArn: aws.String(principalArn(*identity.Arn)),
+		ActionNames:     actions,
+	}
+	for {
+		res, err := iam.New(sess).SimulatePrincipalPolicy(input)
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range res.EvaluationResults {
+			if result.EvalActionName != nil && result.EvalDecision != nil {
+				allowed[*result.EvalActionName] = *result.EvalDecision == iam.PolicyEvaluationDecisionTypeAllowed
+			}
+		}
+		if res.IsTruncated == nil || !*res.IsTruncated {
+			break
+		}
+		input.Marker = res.Marker
+	}
+	checks := make([]PermissionCheck, 0)
+	for _, group := range requiredPermissions {
+		if group.Enabled != nil && !group.Enabled(plans) {
+			continue
+		}
+		for _, action := range group.Actions {
+			checks = append(checks, PermissionCheck{Purpose: group.Purpose, Action: action, Allowed: allowed[action]})
+		}
+	}
+	return checks, nil
+}
+
+// ReportPermissions logs a checklist of the permissions the broker has (or is missing)
+// at startup, it never fails startup as some permissions are only needed for optional features.
+func ReportPermissions(storage Storage) {
+	if os.Getenv("SKIP_PERMISSIONS_CHECK") == "true" || SimulationMode() {
+		return
+	}
+	plans, err := allPlans(storage)
+	if err != nil {
+		glog.Errorf("Unable to get the plans to check AWS permissions for: %s\n", err.Error())
+		plans = nil
+	}
+	checks, err := CheckPermissions(plans)
+	if err != nil {
+		glog.Errorf("Unable to check AWS permissions (does the broker have iam:SimulatePrincipalPolicy?): %s\n", err.Error())
+		return
+	}
+	missing := 0
+	for _, check := range checks {
+		if !check.Allowed {
+			missing++
+		}
+	}
+	if missing == 0 {
+		glog.Infof("AWS permissions check passed, all %d required actions are allowed.\n", len(checks))
+		return
+	}
+	glog.Warningf("AWS permissions check found %d missing permissions:\n", missing)
+	for _, check := range checks {
+		mark := "[x]"
+		if !check.Allowed {
+			mark = "[ ]"
+		}
+		glog.Warningf("  %s %s (%s)\n", mark, check.Action, check.Purpose)
+	}
+}
+
+func allPlans(storage Storage) ([]ProviderPlan, error) {
+	services, err := storage.GetServices()
+	if err != nil {
+		return nil, err
+	}
+	plans := make([]ProviderPlan, 0)
+	for _, service := range services {
+		servicePlans, err := storage.GetPlans(service.ID)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, servicePlans...)
+	}
+	return plans, nil
+}
+
+// IAMPolicy is the least privileged policy for the brokers AWS identity given the features
+// that are enabled (by the environment and the plans), one statement per feature.
+func IAMPolicy(namePrefix string, plans []ProviderPlan) map[string]interface{} {
+	statements := make([]map[string]interface{}, 0)
+	for _, group := range requiredPermissions {
+		if group.Enabled != nil && !group.Enabled(plans) {
+			continue
+		}
+		resources := []string{"*"}
+		if group.Resources != nil {
+			resources = group.Resources(namePrefix, plans)
+		}
+		statements = append(statements, map[string]interface{}{
+			"Sid":      permissionSid(group.Purpose),
+			"Effect":   "Allow",
+			"Action":   group.Actions,
+			"Resource": resources,
+		})
+	}
+	return map[string]interface{}{
+		"Version":   "2012-10-17",
+		"Statement": statements,
+	}
+}
+
+var sidInvalidChars = regexp.MustCompile("[^A-Za-z0-9]+")
+
+func permissionSid(purpose string) string {
+	sid := ""
+	for _, word := range sidInvalidChars.Split(purpose, -1) {
+		if word != "" {
+			sid += strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return sid
+}
+
+// RunIAMPolicy prints the IAM policy the broker needs with its current environment and plans.
+func RunIAMPolicy(ctx context.Context, o Options) error {
+	if o.NamePrefix == "" {
+		o.NamePrefix = os.Getenv("NAME_PREFIX")
+	}
+	if o.NamePrefix == "" {
+		return errors.New("The name prefix was not specified, set NAME_PREFIX in your environment or provide it via the cli using -name-prefix")
+	}
+	storage, err := InitStorage(ctx, o)
+	if err != nil {
+		return err
+	}
+	plans, err := allPlans(storage)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(IAMPolicy(o.NamePrefix, plans), "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
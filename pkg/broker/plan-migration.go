@@ -0,0 +1,171 @@
+package broker
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+)
+
+// A deprecated plan is removed from the catalog and provisions of it are rejected with the
+// plan to use instead (its migrate_to), while existing instances keep it. Operators move the
+// instances to the replacement plan with POST /v2/admin/catalog/plans/{plan_id}/migrate,
+// which schedules the same plan change (modifying the domain in place) a platform update
+// does for each available instance, optionally in batches ({"limit":10}). Instances that
+// are busy, frozen or whose parameters do not fit the replacement plan are skipped and can
+// be migrated by calling it again.
+
+type PlanMigrationRequest struct {
+	// The most instances to migrate, 0 for all of them.
+	Limit int `json:"limit"`
+}
+
+type PlanMigrationSkip struct {
+	InstanceId string `json:"instance_id"`
+	Reason     string `json:"reason"`
+}
+
+type PlanMigration struct {
+	Plan      string              `json:"plan"`
+	MigrateTo string              `json:"migrate_to"`
+	Scheduled []string            `json:"scheduled"`
+	Skipped   []PlanMigrationSkip `json:"skipped"`
+}
+
+// deprecatedPlanError points a provision of a deprecated plan to the plan to use instead.
+func deprecatedPlanError(storage Storage, plan *ProviderPlan) error {
+	if plan.MigrateTo != "" {
+		if replacement, err := storage.GetPlanByID(plan.MigrateTo); err == nil {
+			return UnprocessableEntityWithMessage("PlanDeprecated", "The plan "+plan.basePlan.Name+" is deprecated, use the plan "+replacement.basePlan.Name+" ("+replacement.ID+") instead.")
+		}
+	}
+	return UnprocessableEntityWithMessage("PlanDeprecated", "The plan "+plan.basePlan.Name+" is deprecated, choose another plan.")
+}
+
+// validateMigrateTo checks the plan a deprecated plan migrates to exists, uses the same
+// provider and is itself available.
+func (b *BusinessLogic) validateMigrateTo(plan *CatalogPlan) error {
+	if plan.MigrateTo == "" {
+		return nil
+	}
+	if plan.MigrateTo == plan.Id {
+		return UnprocessableEntityWithMessage("InvalidPlan", "A plan can't migrate to itself.")
+	}
+	replacement, err := b.storage.GetCatalogPlan(plan.MigrateTo)
+	if err != nil && err.Error() == "Not found" {
+		return UnprocessableEntityWithMessage("InvalidPlan", "The plan to migrate to "+plan.MigrateTo+" does not exist.")
+	} else if err != nil {
+		glog.Errorf("Unable to get the plan %s: %s\n", plan.MigrateTo, err.Error())
+		return InternalServerError()
+	}
+	if replacement.Provider != plan.Provider {
+		return UnprocessableEntityWithMessage("InvalidPlan", "The plan to migrate to must use the same provider.")
+	}
+	if replacement.Deprecated || replacement.Retired {
+		return UnprocessableEntityWithMessage("InvalidPlan", "The plan to migrate to is itself deprecated or retired.")
+	}
+	return nil
+}
+
+// migrateInstance schedules the plan change of one instance, it returns why the instance was
+// skipped if it can't be migrated now.
+func (b *BusinessLogic) migrateInstance(entry *Entry, target *ProviderPlan) (string, error) {
+	if !IsAvailable(entry.Status) {
+		return "The instance is " + entry.Status + ".", nil
+	}
+	if running, err := runningOperation(b.storage, entry.Id); err != nil {
+		return "", err
+	} else if running != "" {
+		return "The instance is busy (" + running + ").", nil
+	}
+	if isFrozen(b.storage, entry.Id) {
+		return "The instance is frozen.", nil
+	}
+	instance, err := b.GetInstanceById(entry.Id)
+	if err != nil {
+		return "", err
+	}
+	if instance.Parameters != nil {
+		if err = ValidateInstanceParameters(target, instance.Parameters); err != nil {
+			return "The parameters do not fit the plan: " + err.Error(), nil
+		}
+	}
+	if err = engineDowngradeError(instance, target); err != nil {
+		return "The plan to migrate to has an older engine version than the instance.", nil
+	}
+	if rule, err := ValidatePlanTransition(instance.Plan, instance.Parameters, target, instance.Parameters); err == nil && rule != nil {
+		return "The plan change is not supported (" + rule.Name + "): " + rule.Description, nil
+	}
+	byteData, err := json.Marshal(ChangePlansTaskMetadata{Plan: target.ID})
+	if err != nil {
+		return "", err
+	}
+	if _, err = b.storage.AddTask(instance.Id, ChangePlansTask, string(byteData)); err != nil {
+		return "", err
+	}
+	if err = b.storage.SetExpires(instance.Id, ExpiresAt(target, time.Now())); err != nil {
+		glog.Errorf("Error: Unable to set the expiry of instance (%s): %s\n", instance.Name, err.Error())
+	}
+	b.newOperationKey(instance.Id, UpdateOperation)
+	return "", nil
+}
+
+// MigratePlan schedules moving the instances of a deprecated plan to its replacement.
+func (b *BusinessLogic) MigratePlan(actor string, planId string, limit int) (*PlanMigration, error) {
+	plan, err := b.storage.GetPlanByID(planId)
+	if err != nil && err.Error() == "Not found" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Unable to get the plan %s: %s\n", planId, err.Error())
+		return nil, InternalServerError()
+	}
+	if !plan.Deprecated || plan.MigrateTo == "" {
+		return nil, UnprocessableEntityWithMessage("NotDeprecated", "Only deprecated plans with a plan to migrate to can be migrated.")
+	}
+	target, err := b.storage.GetPlanByID(plan.MigrateTo)
+	if err != nil {
+		glog.Errorf("Unable to get the plan %s: %s\n", plan.MigrateTo, err.Error())
+		return nil, InternalServerError()
+	}
+	entries, err := b.storage.GetInstances()
+	if err != nil {
+		glog.Errorf("Unable to get the instances: %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	migration := PlanMigration{Plan: plan.ID, MigrateTo: target.ID, Scheduled: make([]string, 0), Skipped: make([]PlanMigrationSkip, 0)}
+	for i := range entries {
+		if entries[i].PlanId != plan.ID || !entries[i].Claimed {
+			continue
+		}
+		if limit > 0 && len(migration.Scheduled) >= limit {
+			break
+		}
+		reason, err := b.migrateInstance(&entries[i], target)
+		if err != nil {
+			glog.Errorf("Unable to migrate %s to the plan %s: %s\n", entries[i].Id, target.ID, err.Error())
+			reason = "Unable to schedule the plan change: " + err.Error()
+		}
+		if reason != "" {
+			migration.Skipped = append(migration.Skipped, PlanMigrationSkip{InstanceId: entries[i].Id, Reason: reason})
+			continue
+		}
+		migration.Scheduled = append(migration.Scheduled, entries[i].Id)
+	}
+	glog.Infof("%s is migrating %d instances of the plan %s to %s (%d skipped)\n", actor, len(migration.Scheduled), plan.ID, target.ID, len(migration.Skipped))
+	return &migration, nil
+}
+
+// routePlanMigration adds POST /v2/admin/catalog/plans/{plan_id}/migrate to the admin
+// catalog api.
+func (b *BusinessLogic) routePlanMigration(router *mux.Router) {
+	router.HandleFunc("/v2/admin/catalog/plans/{plan_id}/migrate", catalogHandler(func(actor string, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		var request PlanMigrationRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+			return nil, UnprocessableEntityWithMessage("InvalidRequest", "The body must be {\"limit\":...} or empty.")
+		}
+		return b.MigratePlan(actor, mux.Vars(r)["plan_id"], request.Limit)
+	})).Methods("POST")
+}
@@ -0,0 +1,180 @@
+package broker
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elasticsearchservice"
+	"github.com/golang/glog"
+)
+
+// Some plan changes can't be made to an existing domain, aws fails them part way through
+// the update with an error that rarely says why. Plan changes (and changes of the instance
+// parameters) are checked against transition rules before anything is scheduled, comparing
+// the settings of the current plan with those of the target plan (both with the instance
+// parameters applied), and rejected with the reason. PLAN_TRANSITION_RULES_DISABLED is a
+// comma separated list of rules to skip, e.g., if aws starts supporting one of the changes.
+// Independently of the rules, aws can't downgrade a domain, so a plan whose engine version is
+// older than the version the domain runs is always rejected.
+
+type TransitionRule struct {
+	Name        string
+	Description string
+	// blocks returns whether the rule rejects changing from the settings of one plan to another.
+	blocks func(from *elasticsearchservice.CreateElasticsearchDomainInput, to *elasticsearchservice.CreateElasticsearchDomainInput) bool
+}
+
+func ebsEnabled(settings *elasticsearchservice.CreateElasticsearchDomainInput) bool {
+	return settings.EBSOptions != nil && aws.BoolValue(settings.EBSOptions.EBSEnabled)
+}
+
+func dedicatedMasters(settings *elasticsearchservice.CreateElasticsearchDomainInput) bool {
+	return settings.ElasticsearchClusterConfig != nil && aws.BoolValue(settings.ElasticsearchClusterConfig.DedicatedMasterEnabled)
+}
+
+func multiAZ(settings *elasticsearchservice.CreateElasticsearchDomainInput) bool {
+	return settings.ElasticsearchClusterConfig != nil && aws.BoolValue(settings.ElasticsearchClusterConfig.ZoneAwarenessEnabled)
+}
+
+func singleNode(settings *elasticsearchservice.CreateElasticsearchDomainInput) bool {
+	config := settings.ElasticsearchClusterConfig
+	return config == nil || config.InstanceCount == nil || aws.Int64Value(config.InstanceCount) == 1
+}
+
+func encryptedAtRest(settings *elasticsearchservice.CreateElasticsearchDomainInput) bool {
+	return settings.EncryptionAtRestOptions != nil && aws.BoolValue(settings.EncryptionAtRestOptions.Enabled)
+}
+
+func nodeToNodeEncrypted(settings *elasticsearchservice.CreateElasticsearchDomainInput) bool {
+	return settings.NodeToNodeEncryptionOptions != nil && aws.BoolValue(settings.NodeToNodeEncryptionOptions.Enabled)
+}
+
+var transitionRules = []TransitionRule{
+	{
+		Name:        "ebs-to-instance-storage",
+		Description: "An EBS-backed domain can't move to an instance type with instance storage, its data is on the EBS volumes.",
+		blocks: func(from *elasticsearchservice.CreateElasticsearchDomainInput, to *elasticsearchservice.CreateElasticsearchDomainInput) bool {
+			return ebsEnabled(from) && !ebsEnabled(to)
+		},
+	},
+	{
+		Name:        "instance-storage-to-ebs",
+		Description: "A domain using instance storage can't move to EBS volumes, its data is on the instance storage.",
+		blocks: func(from *elasticsearchservice.CreateElasticsearchDomainInput, to *elasticsearchservice.CreateElasticsearchDomainInput) bool {
+			return !ebsEnabled(from) && ebsEnabled(to)
+		},
+	},
+	{
+		Name:        "multi-az-to-single-node",
+		Description: "A multi-AZ domain with dedicated masters can't move to a single node, create a new instance and restore it instead.",
+		blocks: func(from *elasticsearchservice.CreateElasticsearchDomainInput, to *elasticsearchservice.CreateElasticsearchDomainInput) bool {
+			return multiAZ(from) && (dedicatedMasters(from) || dedicatedMasters(to)) && !multiAZ(to) && singleNode(to)
+		},
+	},
+	{
+		Name:        "disable-encryption-at-rest",
+		Description: "Encryption at rest can't be turned off once a domain is encrypted.",
+		blocks: func(from *elasticsearchservice.CreateElasticsearchDomainInput, to *elasticsearchservice.CreateElasticsearchDomainInput) bool {
+			return encryptedAtRest(from) && !encryptedAtRest(to)
+		},
+	},
+	{
+		Name:        "disable-node-to-node-encryption",
+		Description: "Node-to-node encryption can't be turned off once a domain has it.",
+		blocks: func(from *elasticsearchservice.CreateElasticsearchDomainInput, to *elasticsearchservice.CreateElasticsearchDomainInput) bool {
+			return nodeToNodeEncrypted(from) && !nodeToNodeEncrypted(to)
+		},
+	},
+}
+
+func disabledTransitionRules() map[string]bool {
+	disabled := make(map[string]bool)
+	for _, name := range strings.Split(os.Getenv("PLAN_TRANSITION_RULES_DISABLED"), ",") {
+		if strings.TrimSpace(name) != "" {
+			disabled[strings.TrimSpace(name)] = true
+		}
+	}
+	return disabled
+}
+
+// engineVersionOlder returns whether version is older than than, versions that are not
+// numbers (e.g., OpenSearch_1.0) are never older.
+func engineVersionOlder(version string, than string) bool {
+	a := strings.Split(version, ".")
+	b := strings.Split(than, ".")
+	for i := 0; i < len(a) || i < len(b); i++ {
+		x, y := 0, 0
+		var err error
+		if i < len(a) {
+			if x, err = strconv.Atoi(a[i]); err != nil {
+				return false
+			}
+		}
+		if i < len(b) {
+			if y, err = strconv.Atoi(b[i]); err != nil {
+				return false
+			}
+		}
+		if x != y {
+			return x < y
+		}
+	}
+	return false
+}
+
+// engineDowngradeError rejects changing the domain to a plan with an older engine version.
+func engineDowngradeError(instance *Instance, target *ProviderPlan) error {
+	version := target.EngineVersion()
+	if version == "" || instance.EngineVersion == "" || !engineVersionOlder(version, instance.EngineVersion) {
+		return nil
+	}
+	return BadRequestWithMessage("The plan " + target.basePlan.Name + " uses Elasticsearch " + version + " but the instance runs " + instance.EngineVersion + ", aws can't downgrade a domain, choose a plan with version " + instance.EngineVersion + " or newer.")
+}
+
+// ValidatePlanTransition checks changing the plan (and the parameters) of an instance
+// against the transition rules, it returns the first rule the change breaks.
+func ValidatePlanTransition(from *ProviderPlan, fromParameters *InstanceParameters, to *ProviderPlan, toParameters *InstanceParameters) (*TransitionRule, error) {
+	if !from.Provider.IsAWSDomain() || !to.Provider.IsAWSDomain() {
+		return nil, nil
+	}
+	fromSettings, err := planDomainInput(from)
+	if err != nil {
+		return nil, err
+	}
+	fromParameters.Apply(fromSettings)
+	toSettings, err := planDomainInput(to)
+	if err != nil {
+		return nil, err
+	}
+	toParameters.Apply(toSettings)
+	disabled := disabledTransitionRules()
+	for i := range transitionRules {
+		if disabled[transitionRules[i].Name] {
+			continue
+		}
+		if transitionRules[i].blocks(fromSettings, toSettings) {
+			return &transitionRules[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// checkPlanTransition rejects a plan change the transition rules do not allow.
+func checkPlanTransition(instance *Instance, target *ProviderPlan, parameters *InstanceParameters) error {
+	if err := engineDowngradeError(instance, target); err != nil {
+		glog.Infof("Rejected changing %s from the plan %s to %s, it would downgrade the engine from %s\n", instance.Name, instance.Plan.ID, target.ID, instance.EngineVersion)
+		return err
+	}
+	rule, err := ValidatePlanTransition(instance.Plan, instance.Parameters, target, instance.Parameters.Merge(parameters))
+	if err != nil {
+		glog.Errorf("Unable to check the plan change of %s to %s, skipping the transition rules: %s\n", instance.Name, target.ID, err.Error())
+		return nil
+	}
+	if rule != nil {
+		glog.Infof("Rejected changing %s from the plan %s to %s (%s)\n", instance.Name, instance.Plan.ID, target.ID, rule.Name)
+		return BadRequestWithMessage("The plan change is not supported (" + rule.Name + "): " + rule.Description)
+	}
+	return nil
+}
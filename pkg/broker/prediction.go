@@ -0,0 +1,159 @@
+package broker
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/golang/glog"
+)
+
+// The flood stage disk watermark, once a node passes it elasticsearch makes every
+// index with a shard on the node read-only.
+const defaultStorageWatermark = 95
+
+type StorageAlert struct {
+	InstanceId        string    `json:"instance_id"`
+	Name              string    `json:"name"`
+	Owner             string    `json:"owner"`
+	Plan              string    `json:"plan"`
+	FreeBytes         int64     `json:"free_bytes"`
+	TotalBytes        int64     `json:"total_bytes"`
+	Watermark         int       `json:"watermark_percent"`
+	GrowthBytesPerDay float64   `json:"growth_bytes_per_day"`
+	ProjectedFull     time.Time `json:"projected_full"`
+	DaysRemaining     float64   `json:"days_remaining"`
+}
+
+func envInt(name string, defaultValue int) int {
+	if os.Getenv(name) == "" {
+		return defaultValue
+	}
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		glog.Errorf("Invalid %s, using the default of %d\n", name, defaultValue)
+		return defaultValue
+	}
+	return value
+}
+
+// freeStorageSamples returns the hourly FreeStorageSpace (summed across nodes) of a
+// domain as storage samples, total is used to derive the used bytes.
+func freeStorageSamples(svc *cloudwatch.CloudWatch, instance *Instance, total int64, since time.Time) ([]StorageSample, error) {
+	res, err := svc.GetMetricStatistics(&cloudwatch.GetMetricStatisticsInput{
+		Namespace:  aws.String("AWS/ES"),
+		MetricName: aws.String("FreeStorageSpace"),
+		Dimensions: []*cloudwatch.Dimension{
+			&cloudwatch.Dimension{Name: aws.String("DomainName"), Value: aws.String(instance.Name)},
+			&cloudwatch.Dimension{Name: aws.String("ClientId"), Value: aws.String(os.Getenv("AWS_ACCOUNT_ID"))},
+		},
+		StartTime:  aws.Time(since),
+		EndTime:    aws.Time(time.Now()),
+		Period:     aws.Int64(3600),
+		Statistics: []*string{aws.String(cloudwatch.StatisticSum)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	samples := make([]StorageSample, 0)
+	for _, point := range res.Datapoints {
+		if point.Sum == nil || point.Timestamp == nil {
+			continue
+		}
+		// FreeStorageSpace is reported in megabytes
+		free := int64(aws.Float64Value(point.Sum) * 1024 * 1024)
+		samples = append(samples, StorageSample{UsedBytes: total - free, TotalBytes: total, Sampled: aws.TimeValue(point.Timestamp)})
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Sampled.Before(samples[j].Sampled) })
+	return samples, nil
+}
+
+// PredictStorageExhaustion fits the recent FreeStorageSpace trend of an instance and
+// returns an alert if it will reach the watermark within the alert window, the total
+// disk size comes from the metrics collectors latest sample.
+func PredictStorageExhaustion(svc *cloudwatch.CloudWatch, storage Storage, instance *Instance) (*StorageAlert, error) {
+	if !instance.Plan.Provider.IsAWSDomain() {
+		return nil, nil
+	}
+	now := time.Now()
+	recent, err := storage.GetStorageSamples(instance.Id, now.Add(-time.Hour*24))
+	if err != nil {
+		return nil, err
+	}
+	if len(recent) == 0 || recent[len(recent)-1].TotalBytes == 0 {
+		return nil, nil
+	}
+	total := recent[len(recent)-1].TotalBytes
+	samples, err := freeStorageSamples(svc, instance, total, now.Add(-time.Hour*24*time.Duration(envInt("STORAGE_TREND_DAYS", 3))))
+	if err != nil {
+		return nil, err
+	}
+	if len(samples) < 6 {
+		return nil, nil
+	}
+	watermark := envInt("STORAGE_WATERMARK", defaultStorageWatermark)
+	rate, full := StorageTrend(samples, float64(total)*float64(watermark)/100)
+	if full == nil {
+		return nil, nil
+	}
+	remaining := full.Sub(now).Hours() / 24
+	if remaining > float64(envInt("STORAGE_ALERT_DAYS", 7)) {
+		return nil, nil
+	}
+	last := samples[len(samples)-1]
+	return &StorageAlert{
+		InstanceId:        instance.Id,
+		Name:              instance.Name,
+		Owner:             instance.Owner,
+		Plan:              instance.Plan.ID,
+		FreeBytes:         last.TotalBytes - last.UsedBytes,
+		TotalBytes:        total,
+		Watermark:         watermark,
+		GrowthBytesPerDay: rate,
+		ProjectedFull:     *full,
+		DaysRemaining:     remaining,
+	}, nil
+}
+
+// AlertOnStorageExhaustion predicts storage exhaustion for every available instance and
+// posts an alert to STORAGE_ALERT_WEBHOOK, at most once a day per instance.
+func AlertOnStorageExhaustion(namePrefix string, storage Storage) {
+	url := os.Getenv("STORAGE_ALERT_WEBHOOK")
+	if url == "" {
+		return
+	}
+	entries, err := storage.GetInstances()
+	if err != nil {
+		glog.Errorf("Unable to predict storage exhaustion, cannot get instances: %s\n", err.Error())
+		return
+	}
+	svc := cloudwatch.New(awsSession())
+	for _, entry := range entries {
+		if !IsAvailable(entry.Status) {
+			continue
+		}
+		instance, err := GetInstanceById(namePrefix, storage, entry.Id)
+		if err != nil {
+			glog.Errorf("Unable to predict storage exhaustion for %s: %s\n", entry.Name, err.Error())
+			continue
+		}
+		alert, err := PredictStorageExhaustion(svc, storage, instance)
+		if err != nil {
+			glog.Errorf("Unable to predict storage exhaustion for %s: %s\n", instance.Name, err.Error())
+			continue
+		}
+		if alert == nil {
+			continue
+		}
+		if claimed, err := storage.ClaimSchedule("storage-alert-"+instance.Id, time.Hour*24); err != nil || !claimed {
+			continue
+		}
+		glog.Infof("Warning: %s is projected to reach %d%% of its storage by %s (%.1f days)\n", instance.Name, alert.Watermark, alert.ProjectedFull.Format(time.RFC3339), alert.DaysRemaining)
+		if err = Notify(storage, StorageAlertNotification, url, os.Getenv("STORAGE_ALERT_WEBHOOK_SECRET"), alert); err != nil {
+			glog.Errorf("Unable to send the storage alert for %s: %s\n", instance.Name, err.Error())
+		}
+	}
+}
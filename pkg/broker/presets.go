@@ -0,0 +1,115 @@
+package broker
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+)
+
+// Owners (organizations) can register default provision parameters, e.g., the contacts and
+// labels every one of their instances should have, with PUT /v2/owners/{owner}/presets. They
+// are added to the parameters of each provision of the owner, a parameter given on the
+// provision replaces the preset of the same name. Presets hold the provision parameters the
+// broker accepts on every plan (contacts, labels, logging and benchmark), the logging preset
+// only applies to logging tiers. The plan (and with it the engine version) is always chosen
+// on the provision.
+
+var presetParameters = map[string]bool{"contacts": true, "labels": true, "logging": true, "benchmark": true}
+
+type OwnerPresets struct {
+	Owner      string                 `json:"owner"`
+	Parameters map[string]interface{} `json:"parameters"`
+}
+
+// ValidatePresets checks the presets are provision parameters the broker accepts.
+func ValidatePresets(parameters map[string]interface{}) error {
+	for key := range parameters {
+		if !presetParameters[key] {
+			return UnprocessableEntityWithMessage("InvalidPresets", "The parameter "+key+" can't be preset, presets can set contacts, labels, logging and benchmark.")
+		}
+	}
+	if _, err := ParseContactParameters(parameters); err != nil {
+		return UnprocessableEntityWithMessage("InvalidPresets", err.Error())
+	}
+	if _, err := ParseLabelParameters(parameters); err != nil {
+		return UnprocessableEntityWithMessage("InvalidPresets", err.Error())
+	}
+	if _, err := ParseLoggingParameters(parameters); err != nil {
+		return UnprocessableEntityWithMessage("InvalidPresets", "The logging parameters were invalid: "+err.Error())
+	}
+	if _, err := ParseBenchmarkParameters(parameters); err != nil {
+		return UnprocessableEntityWithMessage("InvalidPresets", err.Error())
+	}
+	return nil
+}
+
+// applyPresets adds the presets of the owner to the provision parameters it did not give.
+func (b *BusinessLogic) applyPresets(owner string, parameters map[string]interface{}) map[string]interface{} {
+	if owner == "" {
+		return parameters
+	}
+	presets, err := b.storage.GetOwnerPresets(owner)
+	if err != nil {
+		glog.Errorf("Unable to get the presets of %s, provisioning without them: %s\n", owner, err.Error())
+		return parameters
+	}
+	if len(presets) == 0 {
+		return parameters
+	}
+	merged := make(map[string]interface{})
+	for key, value := range presets {
+		merged[key] = value
+	}
+	for key, value := range parameters {
+		merged[key] = value
+	}
+	return merged
+}
+
+// RouteOwnerPresets adds GET, PUT (replace) and DELETE /v2/owners/{owner}/presets.
+func (b *BusinessLogic) RouteOwnerPresets(router *mux.Router) {
+	router.HandleFunc("/v2/owners/{owner}/presets", func(w http.ResponseWriter, r *http.Request) {
+		owner := mux.Vars(r)["owner"]
+		presets, err := b.storage.GetOwnerPresets(owner)
+		if err != nil {
+			glog.Errorf("Unable to get the presets of %s: %s\n", owner, err.Error())
+			writeCatalogError(w, InternalServerError())
+			return
+		}
+		HttpWrite(w, http.StatusOK, OwnerPresets{Owner: owner, Parameters: presets})
+	}).Methods("GET")
+	router.HandleFunc("/v2/owners/{owner}/presets", func(w http.ResponseWriter, r *http.Request) {
+		owner := mux.Vars(r)["owner"]
+		var request OwnerPresets
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeCatalogError(w, UnprocessableEntityWithMessage("InvalidRequest", "The body must be {\"parameters\":{...}}."))
+			return
+		}
+		if request.Parameters == nil {
+			request.Parameters = make(map[string]interface{})
+		}
+		if err := ValidatePresets(request.Parameters); err != nil {
+			writeCatalogError(w, err)
+			return
+		}
+		if err := b.storage.SetOwnerPresets(owner, request.Parameters); err != nil {
+			glog.Errorf("Unable to set the presets of %s: %s\n", owner, err.Error())
+			writeCatalogError(w, InternalServerError())
+			return
+		}
+		glog.Infof("Set the presets of %s to %d parameters\n", owner, len(request.Parameters))
+		HttpWrite(w, http.StatusOK, OwnerPresets{Owner: owner, Parameters: request.Parameters})
+	}).Methods("PUT")
+	router.HandleFunc("/v2/owners/{owner}/presets", func(w http.ResponseWriter, r *http.Request) {
+		owner := mux.Vars(r)["owner"]
+		if err := b.storage.SetOwnerPresets(owner, nil); err != nil {
+			glog.Errorf("Unable to remove the presets of %s: %s\n", owner, err.Error())
+			writeCatalogError(w, InternalServerError())
+			return
+		}
+		glog.Infof("Removed the presets of %s\n", owner)
+		HttpWrite(w, http.StatusOK, OwnerPresets{Owner: owner, Parameters: make(map[string]interface{})})
+	}).Methods("DELETE")
+}
@@ -0,0 +1,123 @@
+package broker
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/golang/glog"
+)
+
+// Creating a domain takes 20 to 40 minutes, so how long each provision took is recorded
+// with its plan and instance type and the last_operation of a provision estimates when it
+// will finish. The estimate is the median of the recent provisions that took longer than
+// the provision has so far, so a slow provision is compared to the slow ones.
+
+// minEstimateSamples is how many recorded provisions a plan needs before it is estimated
+// from its own history, plans with fewer use the provisions of their instance type.
+const minEstimateSamples = 5
+
+// maxEstimateSamples bounds how many recent provisions an estimate uses.
+const maxEstimateSamples = 100
+
+type ProvisionEstimate struct {
+	Elapsed   time.Duration `json:"elapsed"`
+	Remaining time.Duration `json:"remaining"`
+	Finishes  time.Time     `json:"finishes"`
+	Percent   int           `json:"percent"`
+	Samples   int           `json:"samples"`
+}
+
+// Description is appended to the status of a provision, e.g., "processing, about 40% done,
+// expected to finish around 15:04 UTC (based on 12 recent provisions)".
+func (e *ProvisionEstimate) Description() string {
+	return "about " + strconv.Itoa(e.Percent) + "% done, expected to finish around " + e.Finishes.UTC().Format("15:04 MST") + " (based on " + strconv.Itoa(e.Samples) + " recent provisions)"
+}
+
+// planInstanceType is the instance type of the data nodes of a plan, or "" if it has none.
+func planInstanceType(plan *ProviderPlan) string {
+	if !plan.Provider.IsAWSDomain() {
+		return ""
+	}
+	settings, err := planDomainInput(plan)
+	if err != nil || settings.ElasticsearchClusterConfig == nil {
+		return ""
+	}
+	return aws.StringValue(settings.ElasticsearchClusterConfig.InstanceType)
+}
+
+// RecordProvisionDuration records how long the domain of the instance took to become
+// available, it is called once a provision has finished.
+func RecordProvisionDuration(storage Storage, instance *Instance) {
+	if err := storage.AddProvisionDuration(instance.Id, planInstanceType(instance.Plan)); err != nil {
+		glog.Errorf("Unable to record the provision duration of %s: %s\n", instance.Name, err.Error())
+	}
+}
+
+// EstimateProvision estimates when the provision of the instance finishes, it returns nil if
+// there is not enough history or the provision is taking longer than any recent one.
+func EstimateProvision(storage Storage, instanceId string, plan *ProviderPlan) (*ProvisionEstimate, error) {
+	started, err := storage.GetInstanceCreated(instanceId)
+	if err != nil {
+		return nil, err
+	}
+	durations, err := storage.GetProvisionDurations(plan.ID, "", maxEstimateSamples)
+	if err != nil {
+		return nil, err
+	}
+	if len(durations) < minEstimateSamples {
+		if instanceType := planInstanceType(plan); instanceType != "" {
+			if durations, err = storage.GetProvisionDurations("", instanceType, maxEstimateSamples); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(durations) < minEstimateSamples {
+		return nil, nil
+	}
+	return estimateFrom(durations, time.Since(started), time.Now()), nil
+}
+
+func estimateFrom(durations []time.Duration, elapsed time.Duration, now time.Time) *ProvisionEstimate {
+	longer := make([]time.Duration, 0)
+	for _, duration := range durations {
+		if duration > elapsed {
+			longer = append(longer, duration)
+		}
+	}
+	if len(longer) == 0 {
+		return nil
+	}
+	sort.Slice(longer, func(i, j int) bool { return longer[i] < longer[j] })
+	expected := longer[len(longer)/2]
+	percent := int(elapsed * 100 / expected)
+	if percent > 99 {
+		percent = 99
+	}
+	return &ProvisionEstimate{
+		Elapsed:   elapsed,
+		Remaining: expected - elapsed,
+		Finishes:  now.Add(expected - elapsed),
+		Percent:   percent,
+		Samples:   len(durations),
+	}
+}
+
+// provisionProgress appends the estimate of a provision to its status.
+func provisionProgress(storage Storage, instanceId string, planId string, status string) string {
+	plan, err := storage.GetPlanByID(planId)
+	if err != nil {
+		glog.Errorf("Unable to estimate the provision of %s, cannot get plan %s: %s\n", instanceId, planId, err.Error())
+		return status
+	}
+	estimate, err := EstimateProvision(storage, instanceId, plan)
+	if err != nil {
+		glog.Errorf("Unable to estimate the provision of %s: %s\n", instanceId, err.Error())
+		return status
+	}
+	if estimate == nil {
+		return status
+	}
+	return status + ", " + estimate.Description()
+}
@@ -1,41 +1,110 @@
 package broker
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"io/ioutil"
+	"net/http"
 	"github.com/aws/aws-sdk-go/service/elasticsearchservice"
 	"github.com/nu7hatch/gouuid"
 	"os"
 	"strings"
 	"time"
-	"fmt"
 )
 
 type AWSInstanceESProvider struct {
 	Provider
-	svc              	*elasticsearchservice.ElasticsearchService
+	domains             DomainService
+	// engine is the Engine of the instances, elasticsearch or opensearch.
+	engine              string
 	namePrefix          string
 	instanceCache 		map[string]*Instance
 }
 
+// The version of the aws sdk used does not yet support the IPAddressType or custom endpoints
+// of a domain, so they're set through the configuration api directly.
+func (provider AWSInstanceESProvider) UpdateDomainConfig(name string, config map[string]interface{}) error {
+	body, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", esEndpoint() + "/2021-01-01/opensearch/domain/" + name + "/config", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("content-type", "application/json")
+	sess := awsSession()
+	if _, err = v4.NewSigner(sess.Config.Credentials).Sign(req, bytes.NewReader(body), "es", os.Getenv("AWS_REGION"), time.Now()); err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: time.Second * 60}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		data, _ := ioutil.ReadAll(resp.Body)
+		return errors.New("Unable to update the configuration of " + name + ": " + resp.Status + " " + string(data))
+	}
+	return nil
+}
+
+// DomainStatus is the state of a domain derived from the status aws returns, brand new
+// domains may omit most fields so anything missing is treated as false.
+type DomainStatus struct {
+	Status     string
+	Ready      bool
+	Created    bool
+	Deleting   bool
+	Processing bool
+	Upgrading  bool
+}
+
+func GetDomainStatus(status *elasticsearchservice.ElasticsearchDomainStatus) DomainStatus {
+	if status == nil {
+		return DomainStatus{Status: "unknown"}
+	}
+	s := DomainStatus{
+		Created:    aws.BoolValue(status.Created),
+		Deleting:   aws.BoolValue(status.Deleted), // deleted is set as soon as the deletion starts
+		Processing: aws.BoolValue(status.Processing),
+		Upgrading:  aws.BoolValue(status.UpgradeProcessing),
+	}
+	if s.Deleting {
+		s.Status = "deleting"
+	} else if !s.Created {
+		s.Status = "creating"
+	} else if s.Upgrading {
+		s.Status = "upgrading"
+	} else if s.Processing {
+		s.Status = "processing"
+	} else {
+		s.Status = "available"
+	}
+	s.Ready = s.Created && !s.Deleting && !s.Upgrading
+	return s
+}
+
 func IsReady(status *elasticsearchservice.ElasticsearchDomainStatus) bool {
-	return *status.Created == true && *status.Deleted == false && *status.UpgradeProcessing == false
+	return GetDomainStatus(status).Ready
 }
 
 func GetStatus(status *elasticsearchservice.ElasticsearchDomainStatus) string {
-	if *status.Created == true && *status.Deleted == false && *status.UpgradeProcessing == true {
-		return "upgrading"
-	} else if *status.Created == true && *status.Deleted == false && *status.Processing == true {
-		return "processing"
-	} else if *status.Deleted == true {
-		return "deleted"
-	} else if *status.Created == false && *status.Deleted == false {
-		return "creating"
-	} else {
-		return "available"
+	return GetDomainStatus(status).Status
+}
+
+// esEndpoint is the elasticsearch service api, this can be pointed at LocalStack
+// (or another emulator) with AWS_ES_ENDPOINT for testing.
+func esEndpoint() string {
+	if os.Getenv("AWS_ES_ENDPOINT") != "" {
+		return strings.TrimSuffix(os.Getenv("AWS_ES_ENDPOINT"), "/")
 	}
+	return "https://es." + os.Getenv("AWS_REGION") + ".amazonaws.com"
 }
 
 func NewAWSInstanceESProvider(namePrefix string) (*AWSInstanceESProvider, error) {
@@ -48,8 +117,9 @@ func NewAWSInstanceESProvider(namePrefix string) (*AWSInstanceESProvider, error)
 	t := time.NewTicker(time.Second * 5)
 	AWSInstanceESProvider := &AWSInstanceESProvider{
 		namePrefix:          namePrefix,
+		domains:             NewDomainService(),
+		engine:              "elasticsearch",
 		instanceCache:		 make(map[string]*Instance),
-		svc:              	 elasticsearchservice.New(session.New(&aws.Config{ Region: aws.String(os.Getenv("AWS_REGION")) })),
 	}
 	go (func() {
 		for {
@@ -61,6 +131,11 @@ func NewAWSInstanceESProvider(namePrefix string) (*AWSInstanceESProvider, error)
 }
 
 
+// context bounds a call to the domain service, see domainRequestTimeout.
+func (provider AWSInstanceESProvider) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), domainRequestTimeout())
+}
+
 func (provider AWSInstanceESProvider) CreateRandomName() string {
 	id, _ := uuid.NewV4()
 	return provider.namePrefix + "-u" + (strings.Split(id.String(), "-")[0])
@@ -71,141 +146,267 @@ func (provider AWSInstanceESProvider) GetInstance(name string, plan *ProviderPla
 		return provider.instanceCache[name + plan.ID], nil
 	}
 
-	res, err := provider.svc.DescribeElasticsearchDomain(&elasticsearchservice.DescribeElasticsearchDomainInput{
-		DomainName:aws.String(name),
-	})
+	ctx, cancel := provider.context()
+	defer cancel()
+	domain, err := provider.domains.DescribeDomain(ctx, name)
 
 	if err != nil {
 		return nil, err
 	}
-
-	endpoint := ""
-	if res.DomainStatus != nil && res.DomainStatus.Endpoints != nil {
-		endpoint = *res.DomainStatus.Endpoints["vpc"]
+	if domain == nil {
+		return nil, errors.New("No domain status was returned by aws.")
 	}
 
+	status := GetDomainStatus(domain)
+	endpoint := ResolveEndpoint(domain, plan.EndpointOptions(name))
+
 	return &Instance{
 		Id:            "", 						// provider should not store this.
 		Name:          name,
-		ProviderId:    *res.DomainStatus.ARN,
+		ProviderId:    aws.StringValue(domain.ARN),
 		Plan:          plan,
 		Username:      "",						// provider should not store this.
 		Password:      "",						// provider should not store this.
 		Endpoint:      endpoint,
-		Status:        GetStatus(res.DomainStatus),
-		Ready:         IsReady(res.DomainStatus),
-		Engine:        "elasticsearch",
-		EngineVersion: *res.DomainStatus.ElasticsearchVersion,
+		Status:        status.Status,
+		Ready:         status.Ready,
+		Engine:        provider.engine,
+		EngineVersion: aws.StringValue(domain.ElasticsearchVersion),
 		Scheme:        "https",
 	}, nil
 }
 
+// Applies the endpoint settings the sdk cannot set when the domain is created.
+func (provider AWSInstanceESProvider) applyEndpointOptions(name string, options EndpointOptions) error {
+	config := make(map[string]interface{})
+	if options.IPAddressType != "" {
+		config["IPAddressType"] = options.IPAddressType
+	}
+	if options.CustomEndpoint() != "" {
+		config["DomainEndpointOptions"] = map[string]interface{}{
+			"CustomEndpointEnabled":        true,
+			"CustomEndpoint":               options.CustomEndpoint(),
+			"CustomEndpointCertificateArn": options.DomainEndpointOptions.CustomEndpointCertificateArn,
+		}
+	}
+	if len(config) == 0 {
+		return nil
+	}
+	return provider.UpdateDomainConfig(name, config)
+}
+
 func (provider AWSInstanceESProvider) PerformPostProvision(db *Instance) (*Instance, error) {
+	// aws does not accept tags on a domain that is still being created, so the owner is
+	// tagged once it is available rather than when it is provisioned.
+	if err := provider.Tag(db, "billingcode", db.Owner); err != nil {
+		return nil, err
+	}
+	if err := provider.applyEndpointOptions(db.Name, db.Plan.EndpointOptions(db.Name)); err != nil {
+		return nil, err
+	}
 	return db, nil
 }
 
 func (provider AWSInstanceESProvider) GetUrl(instance *Instance) map[string]interface{} {
 	return map[string]interface{}{
-		"KIBANA_URL": instance.Scheme + "://" + instance.Endpoint + "/_plugin/kibana",
+		"KIBANA_URL": instance.Scheme + "://" + instance.Endpoint + dashboardsPath(instance.Plan),
 		"ES_URL": instance.Scheme + "://" + instance.Endpoint,
 	}
 }
 
-func (provider AWSInstanceESProvider) Provision(Id string, plan *ProviderPlan, Owner string) (*Instance, error) {
+func (provider AWSInstanceESProvider) GetNetwork(instance *Instance) (*NetworkInfo, error) {
+	ctx, cancel := provider.context()
+	defer cancel()
+	domain, err := provider.domains.DescribeDomain(ctx, instance.Name)
+	if err != nil {
+		return nil, err
+	}
+	if domain == nil {
+		return nil, errors.New("No domain status was returned by aws.")
+	}
+	network := &NetworkInfo{
+		SubnetIds:         []string{},
+		SecurityGroupIds:  []string{},
+		AvailabilityZones: []string{},
+		Endpoint:          instance.Endpoint,
+		Ports:             []int{443},
+		Protocol:          "tcp",
+		Public:            domain.VPCOptions == nil,
+	}
+	if domain.VPCOptions != nil {
+		network.VpcId = aws.StringValue(domain.VPCOptions.VPCId)
+		network.SubnetIds = aws.StringValueSlice(domain.VPCOptions.SubnetIds)
+		network.SecurityGroupIds = aws.StringValueSlice(domain.VPCOptions.SecurityGroupIds)
+		network.AvailabilityZones = aws.StringValueSlice(domain.VPCOptions.AvailabilityZones)
+	} else if domain.Endpoint != nil {
+		network.Endpoint = *domain.Endpoint
+	}
+	return network, nil
+}
+
+// instanceConfigFields are the fields of a domain that are its identity or state rather than
+// its configuration.
+var instanceConfigFields = []string{"ARN", "DomainId", "DomainName", "Endpoint", "Endpoints", "AccessPolicies", "Created", "Deleted", "Processing", "UpgradeProcessing"}
+
+func (provider AWSInstanceESProvider) GetConfig(instance *Instance) (map[string]string, error) {
+	ctx, cancel := provider.context()
+	defer cancel()
+	domain, err := provider.domains.DescribeDomain(ctx, instance.Name)
+	if err != nil {
+		return nil, err
+	}
+	if domain == nil {
+		return nil, errors.New("No domain status was returned by aws.")
+	}
+	data, err := json.Marshal(domain)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for _, field := range instanceConfigFields {
+		delete(fields, field)
+	}
+	config := make(map[string]string)
+	flattenConfig("", fields, config)
+	return config, nil
+}
+
+// applyNetworkOptions places vpc domains in one subnet per availability zone they use,
+// the settings must have been validated first.
+func applyNetworkOptions(settings *elasticsearchservice.CreateElasticsearchDomainInput) {
+	subnetIds := configuredSubnets()
+	if subnetIds == nil {
+		settings.VPCOptions = nil
+		return
+	}
+	if settings.VPCOptions == nil {
+		settings.VPCOptions = &elasticsearchservice.VPCOptions{}
+	}
+	settings.VPCOptions.SubnetIds = aws.StringSlice(domainSubnets(settings.ElasticsearchClusterConfig, subnetIds))
+	settings.VPCOptions.SecurityGroupIds = []*string{aws.String(os.Getenv("AWS_SECURITY_GROUP_ID"))}
+}
+
+// BuildCreateDomainInput merges the rendered plan details with the brokers access policy and
+// network settings into the request sent to aws, it does not call aws.
+func BuildCreateDomainInput(name string, details []byte) (*elasticsearchservice.CreateElasticsearchDomainInput, error) {
 	var settings elasticsearchservice.CreateElasticsearchDomainInput
-	if err := json.Unmarshal([]byte(plan.providerPrivateDetails), &settings); err != nil {
+	if err := json.Unmarshal(details, &settings); err != nil {
 		return nil, err
 	}
 	
-	settings.DomainName = aws.String(provider.CreateRandomName())
+	settings.DomainName = aws.String(name)
 	settings.AccessPolicies = aws.String("{\"Version\":\"2012-10-17\",\"Statement\":[{\"Effect\":\"Allow\",\"Principal\":{\"AWS\":\"*\"},\"Action\":\"es:*\",\"Resource\":\"arn:aws:es:" + os.Getenv("AWS_REGION") + ":" + os.Getenv("AWS_ACCOUNT_ID") + ":domain/" + *settings.DomainName + "/*\"}]}")
 
-	if os.Getenv("AWS_SECURITY_GROUP_ID") != "" && os.Getenv("AWS_SUBNET_ID") != "" {
-		settings.VPCOptions.SubnetIds = make([]*string, 0)
-		subnetIds := strings.Split(os.Getenv("AWS_SUBNET_ID"), ",")
-		if settings.ElasticsearchClusterConfig != nil {
-			if settings.ElasticsearchClusterConfig.InstanceCount != nil && settings.ElasticsearchClusterConfig.DedicatedMasterCount == nil && *settings.ElasticsearchClusterConfig.InstanceCount == 1 {
-				// if we only have one instance without multicluster AZ availability only use one subnet
-				// otherwise use all of them.
-				settings.VPCOptions.SubnetIds = append(settings.VPCOptions.SubnetIds, aws.String(fmt.Sprintf("%v", subnetIds[0])))
-			} else {
-		        for _, element := range strings.Split(os.Getenv("AWS_SUBNET_ID"), ",") {
-    		       settings.VPCOptions.SubnetIds = append(settings.VPCOptions.SubnetIds, aws.String(fmt.Sprintf("%v",element)))
-        		}
-        	}
-			settings.VPCOptions.SecurityGroupIds = []*string{aws.String(os.Getenv("AWS_SECURITY_GROUP_ID"))}
-		}
-	} else {
-		settings.VPCOptions = nil
-	} 
+	if err := ValidateDomainInput(&settings, details); err != nil {
+		return nil, err
+	}
+	applyNetworkOptions(&settings)
+	return &settings, nil
+}
 
-	res, err := provider.svc.CreateElasticsearchDomain(&settings)
+func (provider AWSInstanceESProvider) Provision(Id string, name string, plan *ProviderPlan, Owner string) (*Instance, error) {
+	details, err := plan.RenderPrivateDetails(NewPlanTemplateData(Id, name, Owner))
+	if err != nil {
+		return nil, err
+	}
+	settings, err := BuildCreateDomainInput(name, details)
 	if err != nil {
 		return nil, err
 	}
+	if err = ValidateLimits(settings); err != nil {
+		return nil, err
+	}
 
-	endpoint := ""
-	if res.DomainStatus != nil && res.DomainStatus.Endpoints != nil {
-		endpoint = *res.DomainStatus.Endpoints["vpc"]
+	ctx, cancel := provider.context()
+	defer cancel()
+	domain, err := provider.domains.CreateDomain(ctx, settings)
+	if err != nil && provider.domains.IsAlreadyExists(err) {
+		return nil, &NameCollisionError{Name: name}
+	} else if err != nil {
+		return nil, err
 	}
+	if domain == nil {
+		return nil, errors.New("No domain status was returned by aws.")
+	}
+
+	status := GetDomainStatus(domain)
+	endpoint := ResolveEndpoint(domain, plan.EndpointOptions(name))
 
 	instance := &Instance{
 		Id:            Id,
 		Name:          *settings.DomainName,
-		ProviderId:    *res.DomainStatus.ARN,
+		ProviderId:    aws.StringValue(domain.ARN),
 		Plan:          plan,
 		Username:      "",
 		Password:      "",
 		Endpoint:      endpoint,
-		Status:        GetStatus(res.DomainStatus),
-		Ready:         IsReady(res.DomainStatus),
-		Engine:        "elasticsearch",
-		EngineVersion: *res.DomainStatus.ElasticsearchVersion,
+		Status:        status.Status,
+		Ready:         status.Ready,
+		Engine:        provider.engine,
+		EngineVersion: aws.StringValue(domain.ElasticsearchVersion),
 		Scheme:        "https",
+		Owner:         Owner,
 	}
 
-	time.Sleep( time.Second * time.Duration(10))
-	if err := provider.Tag(instance, "billingcode", Owner); err != nil {
-		return nil, err
-	}
+	// The domain takes many minutes to be created, the worker polls it (see PerformPostProvisionTask
+	// and ResyncFromProviderUntilAvailableTask) and persists its status for LastOperation.
 	return instance, nil
 }
 
 func (provider AWSInstanceESProvider) Deprovision(Instance *Instance, takeSnapshot bool) error {
-	params := &elasticsearchservice.DeleteElasticsearchDomainInput{
-		DomainName: aws.String(Instance.Name), // Required
+	ctx, cancel := provider.context()
+	defer cancel()
+	return provider.domains.DeleteDomain(ctx, Instance.Name)
+}
+
+// IsDeleted is true once aws no longer knows of the domain, deleting a domain takes
+// many minutes after DeleteElasticsearchDomain returns.
+func (provider AWSInstanceESProvider) IsDeleted(Instance *Instance) (bool, error) {
+	ctx, cancel := provider.context()
+	defer cancel()
+	_, err := provider.domains.DescribeDomain(ctx, Instance.Name)
+	if err != nil && provider.domains.IsNotFound(err) {
+		return true, nil
+	} else if err != nil {
+		return false, err
 	}
-	_, err := provider.svc.DeleteElasticsearchDomain(params)
-	return err
+	return false, nil
 }
 
-func (provider AWSInstanceESProvider) Modify(instance *Instance, plan *ProviderPlan) (*Instance, error) {
+func (provider AWSInstanceESProvider) Modify(instance *Instance, plan *ProviderPlan, maintenance bool) (*Instance, error) {
 	var settings elasticsearchservice.CreateElasticsearchDomainInput
-	if err := json.Unmarshal([]byte(plan.providerPrivateDetails), &settings); err != nil {
+	details, err := plan.RenderPrivateDetails(NewPlanTemplateData(instance.Id, instance.Name, instance.Owner))
+	if err != nil {
 		return nil, err
 	}
-	if os.Getenv("AWS_SECURITY_GROUP_ID") != "" && os.Getenv("AWS_SUBNET_ID") != "" {
-		settings.VPCOptions.SubnetIds = make([]*string, 0)
-		subnetIds := strings.Split(os.Getenv("AWS_SUBNET_ID"), ",")
-		if settings.ElasticsearchClusterConfig != nil {
-			if settings.ElasticsearchClusterConfig.InstanceCount != nil && settings.ElasticsearchClusterConfig.DedicatedMasterCount == nil && *settings.ElasticsearchClusterConfig.InstanceCount == 1 {
-				// if we only have one instance without multicluster AZ availability only use one subnet
-				// otherwise use all of them.
-				settings.VPCOptions.SubnetIds = append(settings.VPCOptions.SubnetIds, aws.String(fmt.Sprintf("%v", subnetIds[0])))
-			} else {
-		        for _, element := range strings.Split(os.Getenv("AWS_SUBNET_ID"), ",") {
-    		       settings.VPCOptions.SubnetIds = append(settings.VPCOptions.SubnetIds, aws.String(fmt.Sprintf("%v",element)))
-        		}
-        	}
-			settings.VPCOptions.SecurityGroupIds = []*string{aws.String(os.Getenv("AWS_SECURITY_GROUP_ID"))}
-		}
-	} else {
-		settings.VPCOptions = nil
+	if err := json.Unmarshal(details, &settings); err != nil {
+		return nil, err
+	}
+	// aws rejects configuration changes while a domain is upgraded, a maintenance update that
+	// changes the engine version only upgrades it.
+	if engineVersionOlder(aws.StringValue(settings.ElasticsearchVersion), instance.EngineVersion) {
+		return nil, errors.New("Unable to change " + instance.Name + " to Elasticsearch " + aws.StringValue(settings.ElasticsearchVersion) + ", it runs " + instance.EngineVersion + " and aws can't downgrade a domain.")
+	}
+	if maintenance && aws.StringValue(settings.ElasticsearchVersion) != "" && aws.StringValue(settings.ElasticsearchVersion) != instance.EngineVersion {
+		return provider.upgrade(instance, plan, aws.StringValue(settings.ElasticsearchVersion))
 	}
+	instance.Parameters.Apply(&settings)
+	if err := ValidateDomainInput(&settings, details); err != nil {
+		return nil, err
+	}
+	if err := ValidateLimits(&settings); err != nil {
+		return nil, err
+	}
+	applyNetworkOptions(&settings)
 	
 	settings.DomainName = aws.String(instance.Name)
 	
-	_, err := provider.svc.UpdateElasticsearchDomainConfig(&elasticsearchservice.UpdateElasticsearchDomainConfigInput{
+	ctx, cancel := provider.context()
+	defer cancel()
+	err = provider.domains.UpdateDomainConfig(ctx, &elasticsearchservice.UpdateElasticsearchDomainConfigInput{
 		AccessPolicies: settings.AccessPolicies,
 		AdvancedOptions: settings.AdvancedOptions,
 		CognitoOptions: settings.CognitoOptions,
@@ -219,48 +420,72 @@ func (provider AWSInstanceESProvider) Modify(instance *Instance, plan *ProviderP
 	if err != nil {
 		return nil, err
 	}
+	if options := plan.EndpointOptions(instance.Name); options != instance.Plan.EndpointOptions(instance.Name) {
+		if err = provider.applyEndpointOptions(instance.Name, options); err != nil {
+			return nil, err
+		}
+	}
 	
-	res, err := provider.svc.DescribeElasticsearchDomain(&elasticsearchservice.DescribeElasticsearchDomainInput{
-		DomainName:aws.String(instance.Name),
-	})
+	domain, err := provider.domains.DescribeDomain(ctx, instance.Name)
 
 	if err != nil {
 		return nil, err
 	}
-
-	endpoint := ""
-	if res.DomainStatus != nil && res.DomainStatus.Endpoints != nil {
-		endpoint = *res.DomainStatus.Endpoints["vpc"]
+	if domain == nil {
+		return nil, errors.New("No domain status was returned by aws.")
 	}
 
+	status := GetDomainStatus(domain)
+	endpoint := ResolveEndpoint(domain, plan.EndpointOptions(instance.Name))
+
 	return &Instance{
 		Id:            instance.Id,
 		Name:          *settings.DomainName,
-		ProviderId:    *res.DomainStatus.ARN,
+		ProviderId:    aws.StringValue(domain.ARN),
 		Plan:          plan,
 		Username:      "",
 		Password:      "",
 		Endpoint:      endpoint,
-		Status:        GetStatus(res.DomainStatus),
-		Ready:         IsReady(res.DomainStatus),
-		Engine:        "elasticsearch",
-		EngineVersion: *res.DomainStatus.ElasticsearchVersion,
+		Status:        status.Status,
+		Ready:         status.Ready,
+		Engine:        provider.engine,
+		EngineVersion: aws.StringValue(domain.ElasticsearchVersion),
 		Scheme:        "https",
 	}, nil
 }
 
+// upgrade starts an in-place upgrade of the domain to the engine version, aws rejects versions
+// the domain can't be upgraded to.
+func (provider AWSInstanceESProvider) upgrade(instance *Instance, plan *ProviderPlan, version string) (*Instance, error) {
+	ctx, cancel := provider.context()
+	defer cancel()
+	if err := provider.domains.UpgradeDomain(ctx, instance.Name, version); err != nil {
+		return nil, err
+	}
+	domain, err := provider.domains.DescribeDomain(ctx, instance.Name)
+	if err != nil {
+		return nil, err
+	}
+	if domain == nil {
+		return nil, errors.New("No domain status was returned by aws.")
+	}
+	status := GetDomainStatus(domain)
+	upgraded := *instance
+	upgraded.Plan = plan
+	upgraded.Status = status.Status
+	upgraded.Ready = status.Ready
+	upgraded.EngineVersion = aws.StringValue(domain.ElasticsearchVersion)
+	return &upgraded, nil
+}
+
 func (provider AWSInstanceESProvider) Tag(Instance *Instance, Name string, Value string) error {
-	_, err := provider.svc.AddTags(&elasticsearchservice.AddTagsInput{
-		ARN: aws.String(Instance.ProviderId),
-		TagList:[]*elasticsearchservice.Tag{&elasticsearchservice.Tag{Key: aws.String(Name), Value:aws.String(Value)}},
-	})
-	return err
+	ctx, cancel := provider.context()
+	defer cancel()
+	return provider.domains.AddTags(ctx, Instance.ProviderId, map[string]string{Name: Value})
 }
 
 func (provider AWSInstanceESProvider) Untag(Instance *Instance, Name string) error {
-	_, err := provider.svc.RemoveTags(&elasticsearchservice.RemoveTagsInput{
-		ARN: aws.String(Instance.ProviderId),
-		TagKeys:[]*string{ aws.String(Name) },
-	})
-	return err
+	ctx, cancel := provider.context()
+	defer cancel()
+	return provider.domains.RemoveTags(ctx, Instance.ProviderId, []string{Name})
 }
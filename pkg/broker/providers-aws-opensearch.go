@@ -0,0 +1,234 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/elasticsearchservice"
+	"github.com/aws/aws-sdk-go/service/opensearchservice"
+)
+
+// The aws-opensearch provider creates OpenSearch 1.x and 2.x domains with the OpenSearch
+// service api, the aws-es provider stays on the elasticsearch service api for the existing
+// instances. Plans of both providers share the provider private details (the shape of
+// CreateElasticsearchDomainInput), for aws-opensearch plans ElasticsearchVersion is the
+// OpenSearch version (e.g., 2.11) and the instance types may end in .elasticsearch or .search.
+// The domain service converts to and from the OpenSearch api, so the rest of the broker sees
+// the domains (and their versions) the same way for both providers.
+
+const openSearchVersionPrefix = "OpenSearch_"
+
+// NewOpenSearchDomainService creates the DomainService of the aws-opensearch provider,
+// services embedding the broker can replace it as with NewDomainService.
+var NewOpenSearchDomainService = func() DomainService {
+	return opensearchDomainService{}
+}
+
+// NewAWSOpenSearchProvider is the aws provider on the OpenSearch service api.
+func NewAWSOpenSearchProvider(namePrefix string) (*AWSInstanceESProvider, error) {
+	provider, err := NewAWSInstanceESProvider(namePrefix)
+	if err != nil {
+		return nil, err
+	}
+	provider.domains = NewOpenSearchDomainService()
+	provider.engine = "opensearch"
+	return provider, nil
+}
+
+// dashboardsPath is the path of Kibana on domains of the plan, OpenSearch domains (and
+// serverless collections) serve OpenSearch Dashboards instead.
+func dashboardsPath(plan *ProviderPlan) string {
+	if plan != nil && (plan.Provider == AWSOpenSearchInstance || plan.Provider == AWSServerlessInstance) {
+		return "/_dashboards"
+	}
+	return "/_plugin/kibana"
+}
+
+// openSearchVersion is the version the OpenSearch api expects, e.g., OpenSearch_2.11 for 2.11.
+func openSearchVersion(version string) string {
+	if version == "" || strings.Contains(version, "_") {
+		return version
+	}
+	return openSearchVersionPrefix + version
+}
+
+func openSearchVersions(versions []*string) []string {
+	stripped := make([]string, 0)
+	for _, version := range aws.StringValueSlice(versions) {
+		if strings.HasPrefix(version, openSearchVersionPrefix) {
+			stripped = append(stripped, strings.TrimPrefix(version, openSearchVersionPrefix))
+		}
+	}
+	return stripped
+}
+
+func renameField(fields map[string]interface{}, from string, to string) {
+	if value, ok := fields[from]; ok {
+		delete(fields, from)
+		fields[to] = value
+	}
+}
+
+// convertDomain copies from into to (a type of the other api) through json, renaming the
+// fields and instance types that differ between the elasticsearch and OpenSearch apis.
+func convertDomain(from interface{}, to interface{}, toOpenSearch bool) error {
+	data, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	var fields map[string]interface{}
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	clusterConfig, engineVersion, fromSuffix, toSuffix := "ElasticsearchClusterConfig", "ElasticsearchVersion", ".search", ".elasticsearch"
+	if toOpenSearch {
+		renameField(fields, "ElasticsearchClusterConfig", "ClusterConfig")
+		renameField(fields, "ElasticsearchVersion", "EngineVersion")
+		clusterConfig, engineVersion, fromSuffix, toSuffix = "ClusterConfig", "EngineVersion", ".elasticsearch", ".search"
+	} else {
+		renameField(fields, "ClusterConfig", "ElasticsearchClusterConfig")
+		renameField(fields, "EngineVersion", "ElasticsearchVersion")
+	}
+	if version, ok := fields[engineVersion].(string); ok && toOpenSearch {
+		fields[engineVersion] = openSearchVersion(version)
+	} else if ok {
+		fields[engineVersion] = strings.TrimPrefix(version, openSearchVersionPrefix)
+	}
+	if config, ok := fields[clusterConfig].(map[string]interface{}); ok {
+		for _, field := range []string{"InstanceType", "DedicatedMasterType", "WarmType"} {
+			if instanceType, ok := config[field].(string); ok && strings.HasSuffix(instanceType, fromSuffix) {
+				config[field] = strings.TrimSuffix(instanceType, fromSuffix) + toSuffix
+			}
+		}
+	}
+	if data, err = json.Marshal(fields); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, to)
+}
+
+func fromOpenSearchDomain(domain *opensearchservice.DomainStatus) (*elasticsearchservice.ElasticsearchDomainStatus, error) {
+	if domain == nil {
+		return nil, nil
+	}
+	var status elasticsearchservice.ElasticsearchDomainStatus
+	if err := convertDomain(domain, &status, false); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// opensearchDomainService is the DomainService on the OpenSearch api of the v1 sdk.
+type opensearchDomainService struct{}
+
+func (s opensearchDomainService) DescribeDomain(ctx context.Context, name string) (*elasticsearchservice.ElasticsearchDomainStatus, error) {
+	res, err := newOpenSearchService().DescribeDomainWithContext(ctx, &opensearchservice.DescribeDomainInput{
+		DomainName: aws.String(name),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fromOpenSearchDomain(res.DomainStatus)
+}
+
+func (s opensearchDomainService) CreateDomain(ctx context.Context, input *elasticsearchservice.CreateElasticsearchDomainInput) (*elasticsearchservice.ElasticsearchDomainStatus, error) {
+	var create opensearchservice.CreateDomainInput
+	if err := convertDomain(input, &create, true); err != nil {
+		return nil, err
+	}
+	res, err := newOpenSearchService().CreateDomainWithContext(ctx, &create)
+	if err != nil {
+		return nil, err
+	}
+	return fromOpenSearchDomain(res.DomainStatus)
+}
+
+func (s opensearchDomainService) UpdateDomainConfig(ctx context.Context, input *elasticsearchservice.UpdateElasticsearchDomainConfigInput) error {
+	var update opensearchservice.UpdateDomainConfigInput
+	if err := convertDomain(input, &update, true); err != nil {
+		return err
+	}
+	_, err := newOpenSearchService().UpdateDomainConfigWithContext(ctx, &update)
+	return err
+}
+
+func (s opensearchDomainService) DeleteDomain(ctx context.Context, name string) error {
+	_, err := newOpenSearchService().DeleteDomainWithContext(ctx, &opensearchservice.DeleteDomainInput{
+		DomainName: aws.String(name),
+	})
+	return err
+}
+
+func (s opensearchDomainService) AddTags(ctx context.Context, arn string, tags map[string]string) error {
+	tagList := make([]*opensearchservice.Tag, 0)
+	for key, value := range tags {
+		tagList = append(tagList, &opensearchservice.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+	_, err := newOpenSearchService().AddTagsWithContext(ctx, &opensearchservice.AddTagsInput{
+		ARN:     aws.String(arn),
+		TagList: tagList,
+	})
+	return err
+}
+
+func (s opensearchDomainService) RemoveTags(ctx context.Context, arn string, keys []string) error {
+	_, err := newOpenSearchService().RemoveTagsWithContext(ctx, &opensearchservice.RemoveTagsInput{
+		ARN:     aws.String(arn),
+		TagKeys: aws.StringSlice(keys),
+	})
+	return err
+}
+
+// ListVersions returns the OpenSearch versions (without the OpenSearch_ prefix), the
+// elasticsearch versions the api also lists are left to the aws-es provider.
+func (s opensearchDomainService) ListVersions(ctx context.Context) ([]string, error) {
+	versions := make([]string, 0)
+	input := &opensearchservice.ListVersionsInput{}
+	for {
+		res, err := newOpenSearchService().ListVersionsWithContext(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, openSearchVersions(res.Versions)...)
+		if aws.StringValue(res.NextToken) == "" {
+			return versions, nil
+		}
+		input.NextToken = res.NextToken
+	}
+}
+
+func (s opensearchDomainService) CompatibleVersions(ctx context.Context) (map[string][]string, error) {
+	res, err := newOpenSearchService().GetCompatibleVersionsWithContext(ctx, &opensearchservice.GetCompatibleVersionsInput{})
+	if err != nil {
+		return nil, err
+	}
+	versions := make(map[string][]string)
+	for _, compatible := range res.CompatibleVersions {
+		source := aws.StringValue(compatible.SourceVersion)
+		if strings.HasPrefix(source, openSearchVersionPrefix) {
+			versions[strings.TrimPrefix(source, openSearchVersionPrefix)] = openSearchVersions(compatible.TargetVersions)
+		}
+	}
+	return versions, nil
+}
+
+func (s opensearchDomainService) UpgradeDomain(ctx context.Context, name string, version string) error {
+	_, err := newOpenSearchService().UpgradeDomainWithContext(ctx, &opensearchservice.UpgradeDomainInput{
+		DomainName:    aws.String(name),
+		TargetVersion: aws.String(openSearchVersion(version)),
+	})
+	return err
+}
+
+func (s opensearchDomainService) IsNotFound(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == opensearchservice.ErrCodeResourceNotFoundException
+}
+
+func (s opensearchDomainService) IsAlreadyExists(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == opensearchservice.ErrCodeResourceAlreadyExistsException
+}
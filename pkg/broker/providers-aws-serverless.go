@@ -0,0 +1,520 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/nu7hatch/gouuid"
+)
+
+// The aws-opensearch-serverless provider creates OpenSearch Serverless collections, a tier
+// without nodes, volumes or versions to manage. Each collection gets an encryption, a
+// network and a data access policy named after it, the data access policy lets the broker
+// (and the Principals of the plan) manage the collection and its indexes. The version of the
+// aws sdk used does not support OpenSearch Serverless, so its api is called directly (see
+// serverlessRequest). The provider private details of its plans are ServerlessCollectionSettings.
+
+const (
+	serverlessNotFound = "ResourceNotFoundException"
+	serverlessConflict = "ConflictException"
+)
+
+var serverlessCollectionTypes = map[string]bool{"SEARCH": true, "TIMESERIES": true, "VECTORSEARCH": true}
+
+// ServerlessCollectionSettings are the provider private details of aws-opensearch-serverless plans.
+type ServerlessCollectionSettings struct {
+	// SEARCH, TIMESERIES or VECTORSEARCH, SEARCH if empty.
+	Type        string `json:"Type"`
+	Description string `json:"Description"`
+	// The kms key the collection is encrypted with, an aws owned key if empty.
+	KmsKeyId string `json:"KmsKeyId"`
+	// Whether the collection and its dashboards are reachable from the internet, otherwise
+	// only from the SourceVPCEs (OpenSearch Serverless vpc endpoints).
+	AllowFromPublic bool     `json:"AllowFromPublic"`
+	SourceVPCEs     []string `json:"SourceVPCEs"`
+	// IAM principals given access to the collection and its indexes besides the broker.
+	Principals []string `json:"Principals"`
+}
+
+// ServerlessCollection is a collection as the api returns it.
+type ServerlessCollection struct {
+	Arn                string `json:"arn"`
+	Id                 string `json:"id"`
+	Name               string `json:"name"`
+	Status             string `json:"status"`
+	Type               string `json:"type"`
+	Description        string `json:"description,omitempty"`
+	KmsKeyArn          string `json:"kmsKeyArn,omitempty"`
+	CollectionEndpoint string `json:"collectionEndpoint,omitempty"`
+	DashboardEndpoint  string `json:"dashboardEndpoint,omitempty"`
+	CreatedDate        int64  `json:"createdDate,omitempty"`
+	LastModifiedDate   int64  `json:"lastModifiedDate,omitempty"`
+}
+
+type serverlessTag struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// ServerlessError is an error returned by the OpenSearch Serverless api, it is an
+// awserr.Error so rejected credentials refresh the AWS session as for the sdk clients.
+type ServerlessError struct {
+	ErrorCode    string
+	ErrorMessage string
+}
+
+func (e *ServerlessError) Error() string {
+	return e.ErrorCode + ": " + e.ErrorMessage
+}
+
+func (e *ServerlessError) Code() string {
+	return e.ErrorCode
+}
+
+func (e *ServerlessError) Message() string {
+	return e.ErrorMessage
+}
+
+func (e *ServerlessError) OrigErr() error {
+	return nil
+}
+
+func isServerlessError(err error, code string) bool {
+	serr, ok := err.(*ServerlessError)
+	return ok && serr.ErrorCode == code
+}
+
+// serverlessEndpoint is the OpenSearch Serverless api of AWS_REGION, it can be set with
+// AWS_SERVERLESS_ENDPOINT for testing.
+func serverlessEndpoint() string {
+	if os.Getenv("AWS_SERVERLESS_ENDPOINT") != "" {
+		return strings.TrimSuffix(os.Getenv("AWS_SERVERLESS_ENDPOINT"), "/")
+	}
+	return "https://aoss." + os.Getenv("AWS_REGION") + ".amazonaws.com"
+}
+
+// serverlessRequest calls an action of the OpenSearch Serverless api (json 1.0) and reads
+// its response into output (if not nil).
+func serverlessRequest(ctx context.Context, action string, input interface{}, output interface{}) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", serverlessEndpoint()+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("content-type", "application/x-amz-json-1.0")
+	req.Header.Set("x-amz-target", "OpenSearchServerless."+action)
+	sess := awsSession()
+	if _, err = v4.NewSigner(sess.Config.Credentials).Sign(req, bytes.NewReader(body), "aoss", os.Getenv("AWS_REGION"), time.Now()); err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(data, &failure)
+		code := failure.Type[strings.LastIndex(failure.Type, "#")+1:]
+		if code == "" {
+			code = resp.Status
+		}
+		serr := &ServerlessError{ErrorCode: code, ErrorMessage: failure.Message}
+		if isCredentialsError(serr) {
+			RefreshAWSSession()
+		}
+		return serr
+	}
+	if output == nil {
+		return nil
+	}
+	return json.Unmarshal(data, output)
+}
+
+type AWSServerlessProvider struct {
+	Provider
+	namePrefix string
+}
+
+func NewAWSServerlessProvider(namePrefix string) (*AWSServerlessProvider, error) {
+	if os.Getenv("AWS_REGION") == "" {
+		return nil, errors.New("Unable to find AWS_REGION environment variable.")
+	}
+	return &AWSServerlessProvider{namePrefix: namePrefix}, nil
+}
+
+// context bounds a call to the OpenSearch Serverless api, see domainRequestTimeout.
+func (provider AWSServerlessProvider) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), domainRequestTimeout())
+}
+
+func (provider AWSServerlessProvider) CreateRandomName() string {
+	id, _ := uuid.NewV4()
+	return provider.namePrefix + "-u" + (strings.Split(id.String(), "-")[0])
+}
+
+// ParseServerlessSettings reads the settings of a plan from its rendered details.
+func ParseServerlessSettings(details []byte) (*ServerlessCollectionSettings, error) {
+	var settings ServerlessCollectionSettings
+	if err := json.Unmarshal(details, &settings); err != nil {
+		return nil, err
+	}
+	if settings.Type == "" {
+		settings.Type = "SEARCH"
+	}
+	if !serverlessCollectionTypes[settings.Type] {
+		return nil, invalidPlan("Type", "the collection type must be SEARCH, TIMESERIES or VECTORSEARCH, not %s", settings.Type)
+	}
+	if !settings.AllowFromPublic && len(settings.SourceVPCEs) == 0 {
+		return nil, invalidPlan("SourceVPCEs", "a collection that does not allow public access needs at least one vpc endpoint")
+	}
+	return &settings, nil
+}
+
+func serverlessPlanSettings(plan *ProviderPlan, data PlanTemplateData) (*ServerlessCollectionSettings, error) {
+	details, err := plan.RenderPrivateDetails(data)
+	if err != nil {
+		return nil, err
+	}
+	return ParseServerlessSettings(details)
+}
+
+func serverlessPolicy(policy interface{}) string {
+	data, _ := json.Marshal(policy)
+	return string(data)
+}
+
+func collectionRule(resourceType string, resource string, permissions []string) map[string]interface{} {
+	rule := map[string]interface{}{"ResourceType": resourceType, "Resource": []string{resource}}
+	if permissions != nil {
+		rule["Permission"] = permissions
+	}
+	return rule
+}
+
+func encryptionPolicy(name string, settings *ServerlessCollectionSettings) string {
+	policy := map[string]interface{}{"Rules": []interface{}{collectionRule("collection", "collection/"+name, nil)}}
+	if settings.KmsKeyId != "" {
+		policy["KmsARN"] = settings.KmsKeyId
+	} else {
+		policy["AWSOwnedKey"] = true
+	}
+	return serverlessPolicy(policy)
+}
+
+func networkPolicy(name string, settings *ServerlessCollectionSettings) string {
+	policy := map[string]interface{}{
+		"Rules":           []interface{}{collectionRule("collection", "collection/"+name, nil), collectionRule("dashboard", "collection/"+name, nil)},
+		"AllowFromPublic": settings.AllowFromPublic,
+	}
+	if !settings.AllowFromPublic {
+		policy["SourceVPCEs"] = settings.SourceVPCEs
+	}
+	return serverlessPolicy([]interface{}{policy})
+}
+
+func dataAccessPolicy(name string, principals []string) string {
+	return serverlessPolicy([]interface{}{map[string]interface{}{
+		"Rules": []interface{}{
+			collectionRule("collection", "collection/"+name, []string{"aoss:*"}),
+			collectionRule("index", "index/"+name+"/*", []string{"aoss:*"}),
+		},
+		"Principal": principals,
+	}})
+}
+
+// principals are the IAM principals given access to collections, the broker and those of the plan.
+func (provider AWSServerlessProvider) principals(settings *ServerlessCollectionSettings) ([]string, error) {
+	identity, err := sts.New(awsSession()).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return nil, err
+	}
+	if identity.Arn == nil {
+		return nil, errors.New("Unable to determine the AWS identity of the broker.")
+	}
+	return append([]string{principalArn(*identity.Arn)}, settings.Principals...), nil
+}
+
+func (provider AWSServerlessProvider) collection(ctx context.Context, name string) (*ServerlessCollection, error) {
+	var res struct {
+		CollectionDetails []ServerlessCollection `json:"collectionDetails"`
+	}
+	if err := serverlessRequest(ctx, "BatchGetCollection", map[string]interface{}{"names": []string{name}}, &res); err != nil {
+		return nil, err
+	}
+	if len(res.CollectionDetails) == 0 {
+		return nil, &ServerlessError{ErrorCode: serverlessNotFound, ErrorMessage: "The collection " + name + " does not exist."}
+	}
+	return &res.CollectionDetails[0], nil
+}
+
+// collectionStatus is the instance status of a collection (CREATING, ACTIVE, DELETING or FAILED).
+func collectionStatus(collection *ServerlessCollection) string {
+	switch collection.Status {
+	case "ACTIVE":
+		return "available"
+	case "CREATING":
+		return "creating"
+	case "DELETING":
+		return "deleting"
+	}
+	return strings.ToLower(collection.Status)
+}
+
+func (provider AWSServerlessProvider) instance(collection *ServerlessCollection, plan *ProviderPlan) *Instance {
+	return &Instance{
+		Name:       collection.Name,
+		ProviderId: collection.Arn,
+		Plan:       plan,
+		Endpoint:   strings.TrimPrefix(collection.CollectionEndpoint, "https://"),
+		Status:     collectionStatus(collection),
+		Ready:      collection.Status == "ACTIVE",
+		Engine:     "opensearch",
+		Scheme:     "https",
+	}
+}
+
+func (provider AWSServerlessProvider) GetInstance(name string, plan *ProviderPlan) (*Instance, error) {
+	ctx, cancel := provider.context()
+	defer cancel()
+	collection, err := provider.collection(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return provider.instance(collection, plan), nil
+}
+
+func (provider AWSServerlessProvider) Provision(Id string, name string, plan *ProviderPlan, Owner string) (*Instance, error) {
+	settings, err := serverlessPlanSettings(plan, NewPlanTemplateData(Id, name, Owner))
+	if err != nil {
+		return nil, err
+	}
+	principals, err := provider.principals(settings)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := provider.context()
+	defer cancel()
+	// a collection can only be created once an encryption policy covers it.
+	err = serverlessRequest(ctx, "CreateSecurityPolicy", map[string]interface{}{"name": name, "type": "encryption", "policy": encryptionPolicy(name, settings)}, nil)
+	if err != nil && isServerlessError(err, serverlessConflict) {
+		return nil, &NameCollisionError{Name: name}
+	} else if err != nil {
+		return nil, err
+	}
+	if err = serverlessRequest(ctx, "CreateSecurityPolicy", map[string]interface{}{"name": name, "type": "network", "policy": networkPolicy(name, settings)}, nil); err != nil {
+		return nil, err
+	}
+	if err = serverlessRequest(ctx, "CreateAccessPolicy", map[string]interface{}{"name": name, "type": "data", "policy": dataAccessPolicy(name, principals)}, nil); err != nil {
+		return nil, err
+	}
+	var res struct {
+		CreateCollectionDetail ServerlessCollection `json:"createCollectionDetail"`
+	}
+	err = serverlessRequest(ctx, "CreateCollection", map[string]interface{}{
+		"name":        name,
+		"type":        settings.Type,
+		"description": settings.Description,
+		"tags":        []serverlessTag{{Key: "billingcode", Value: Owner}},
+	}, &res)
+	if err != nil && isServerlessError(err, serverlessConflict) {
+		return nil, &NameCollisionError{Name: name}
+	} else if err != nil {
+		return nil, err
+	}
+	instance := provider.instance(&res.CreateCollectionDetail, plan)
+	instance.Id = Id
+	instance.Owner = Owner
+	// The collection takes minutes to be created, the worker polls it for its endpoint.
+	return instance, nil
+}
+
+func (provider AWSServerlessProvider) Deprovision(Instance *Instance, takeSnapshot bool) error {
+	ctx, cancel := provider.context()
+	defer cancel()
+	collection, err := provider.collection(ctx, Instance.Name)
+	if err != nil {
+		return err
+	}
+	return serverlessRequest(ctx, "DeleteCollection", map[string]interface{}{"id": collection.Id}, nil)
+}
+
+// IsDeleted is true once the collection is gone, its policies are removed then as aws does
+// not delete the encryption policy of a collection that still exists.
+func (provider AWSServerlessProvider) IsDeleted(Instance *Instance) (bool, error) {
+	ctx, cancel := provider.context()
+	defer cancel()
+	_, err := provider.collection(ctx, Instance.Name)
+	if err == nil {
+		return false, nil
+	} else if !isServerlessError(err, serverlessNotFound) {
+		return false, err
+	}
+	for _, policy := range []struct{ action, kind string }{{"DeleteSecurityPolicy", "encryption"}, {"DeleteSecurityPolicy", "network"}, {"DeleteAccessPolicy", "data"}} {
+		err = serverlessRequest(ctx, policy.action, map[string]interface{}{"name": Instance.Name, "type": policy.kind}, nil)
+		if err != nil && !isServerlessError(err, serverlessNotFound) {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// updatePolicy replaces a security or access policy, aws only accepts the update with the
+// version of the policy being replaced.
+func (provider AWSServerlessProvider) updatePolicy(ctx context.Context, access bool, name string, kind string, policy string) error {
+	get, update, detail := "GetSecurityPolicy", "UpdateSecurityPolicy", "securityPolicyDetail"
+	if access {
+		get, update, detail = "GetAccessPolicy", "UpdateAccessPolicy", "accessPolicyDetail"
+	}
+	var res map[string]struct {
+		PolicyVersion string `json:"policyVersion"`
+	}
+	if err := serverlessRequest(ctx, get, map[string]interface{}{"name": name, "type": kind}, &res); err != nil {
+		return err
+	}
+	return serverlessRequest(ctx, update, map[string]interface{}{"name": name, "type": kind, "policy": policy, "policyVersion": res[detail].PolicyVersion}, nil)
+}
+
+// Modify replaces the network and data access policies (and the description) of the
+// collection with those of the plan, collections have no version to upgrade and can't change
+// their type or encryption.
+func (provider AWSServerlessProvider) Modify(instance *Instance, plan *ProviderPlan, maintenance bool) (*Instance, error) {
+	settings, err := serverlessPlanSettings(plan, NewPlanTemplateData(instance.Id, instance.Name, instance.Owner))
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := provider.context()
+	defer cancel()
+	collection, err := provider.collection(ctx, instance.Name)
+	if err != nil {
+		return nil, err
+	}
+	if collection.Type != settings.Type {
+		return nil, errors.New("Unable to change " + instance.Name + " to a " + settings.Type + " collection, it is a " + collection.Type + " collection and aws can't change its type.")
+	}
+	if maintenance {
+		modified := *instance
+		modified.Plan = plan
+		return &modified, nil
+	}
+	principals, err := provider.principals(settings)
+	if err != nil {
+		return nil, err
+	}
+	if err = provider.updatePolicy(ctx, false, instance.Name, "network", networkPolicy(instance.Name, settings)); err != nil {
+		return nil, err
+	}
+	if err = provider.updatePolicy(ctx, true, instance.Name, "data", dataAccessPolicy(instance.Name, principals)); err != nil {
+		return nil, err
+	}
+	if collection.Description != settings.Description {
+		if err = serverlessRequest(ctx, "UpdateCollection", map[string]interface{}{"id": collection.Id, "description": settings.Description}, nil); err != nil {
+			return nil, err
+		}
+	}
+	modified := provider.instance(collection, plan)
+	modified.Id = instance.Id
+	return modified, nil
+}
+
+func (provider AWSServerlessProvider) Tag(Instance *Instance, Name string, Value string) error {
+	ctx, cancel := provider.context()
+	defer cancel()
+	return serverlessRequest(ctx, "TagResource", map[string]interface{}{"resourceArn": Instance.ProviderId, "tags": []serverlessTag{{Key: Name, Value: Value}}}, nil)
+}
+
+func (provider AWSServerlessProvider) Untag(Instance *Instance, Name string) error {
+	ctx, cancel := provider.context()
+	defer cancel()
+	return serverlessRequest(ctx, "UntagResource", map[string]interface{}{"resourceArn": Instance.ProviderId, "tagKeys": []string{Name}}, nil)
+}
+
+// PerformPostProvision has nothing to do, collections are tagged when they are created.
+func (provider AWSServerlessProvider) PerformPostProvision(db *Instance) (*Instance, error) {
+	return db, nil
+}
+
+// GetUrl returns the collection endpoint and its OpenSearch Dashboards.
+func (provider AWSServerlessProvider) GetUrl(instance *Instance) map[string]interface{} {
+	return map[string]interface{}{
+		"KIBANA_URL": instance.Scheme + "://" + instance.Endpoint + dashboardsPath(instance.Plan),
+		"ES_URL":     instance.Scheme + "://" + instance.Endpoint,
+	}
+}
+
+func (provider AWSServerlessProvider) GetNetwork(instance *Instance) (*NetworkInfo, error) {
+	settings, err := serverlessPlanSettings(instance.Plan, NewPlanTemplateData(instance.Id, instance.Name, instance.Owner))
+	if err != nil {
+		return nil, err
+	}
+	return &NetworkInfo{
+		SubnetIds:         []string{},
+		SecurityGroupIds:  []string{},
+		AvailabilityZones: []string{},
+		Endpoint:          instance.Endpoint,
+		Ports:             []int{443},
+		Protocol:          "tcp",
+		Public:            settings.AllowFromPublic,
+	}, nil
+}
+
+// collectionConfigFields are the fields of a collection that are its identity or state.
+var collectionConfigFields = []string{"arn", "id", "name", "status", "collectionEndpoint", "dashboardEndpoint", "createdDate", "lastModifiedDate"}
+
+func (provider AWSServerlessProvider) GetConfig(instance *Instance) (map[string]string, error) {
+	ctx, cancel := provider.context()
+	defer cancel()
+	collection, err := provider.collection(ctx, instance.Name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(collection)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for _, field := range collectionConfigFields {
+		delete(fields, field)
+	}
+	config := make(map[string]string)
+	flattenConfig("", fields, config)
+	return config, nil
+}
+
+// serverlessSigningName is the service name requests to the cluster of the instance are
+// signed for.
+func serverlessSigningName(instance *Instance) string {
+	if instance.Plan != nil && instance.Plan.Provider == AWSServerlessInstance {
+		return "aoss"
+	}
+	return "es"
+}
+
+// validateServerlessPlan checks the provider private details of a serverless plan.
+func validateServerlessPlan(plan *ProviderPlan) error {
+	_, err := serverlessPlanSettings(plan, NewPlanTemplateData("00000000-0000-0000-0000-000000000000", "validation", ""))
+	return err
+}
@@ -0,0 +1,578 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/nu7hatch/gouuid"
+)
+
+// The docker provider runs single-node Elasticsearch or OpenSearch containers (and
+// optionally Kibana or OpenSearch Dashboards) with the Docker engine api, so the broker can be
+// developed and the whole OSB flow exercised in CI without aws credentials. The engine is the
+// one in DOCKER_HOST (unix:///var/run/docker.sock by default). Every instance gets its own
+// network and data volume, the containers run without security (instances have no
+// credentials) and publish their ports on DOCKER_PUBLISH_IP (127.0.0.1 by default), the
+// endpoints are on DOCKER_ENDPOINT_HOST (localhost by default). A plan change recreates the
+// containers on the same volume. Images are pulled when an instance is provisioned if they
+// are missing, pull them ahead of time to keep provisions fast.
+
+const (
+	dockerLabel       = "io.akkeris.elasticsearch-broker"
+	dockerPullTimeout = time.Minute * 10
+)
+
+var dockerHeapPattern = regexp.MustCompile(`^[1-9][0-9]*[mg]$`)
+
+// DockerSettings are the provider private details of docker plans.
+type DockerSettings struct {
+	// elasticsearch (the default) or opensearch.
+	Engine               string `json:"Engine"`
+	ElasticsearchVersion string `json:"ElasticsearchVersion"`
+	// The images, by default the official ones of the engine and version.
+	Image           string `json:"Image"`
+	DashboardsImage string `json:"DashboardsImage"`
+	// The heap of the node, e.g., 512m (the default).
+	HeapSize   string `json:"HeapSize"`
+	Dashboards bool   `json:"Dashboards"`
+}
+
+// ParseDockerSettings reads the settings of a plan from its rendered details.
+func ParseDockerSettings(details []byte) (*DockerSettings, error) {
+	var settings DockerSettings
+	if err := json.Unmarshal(details, &settings); err != nil {
+		return nil, err
+	}
+	if settings.Engine == "" {
+		settings.Engine = "elasticsearch"
+	}
+	if settings.Engine != "elasticsearch" && settings.Engine != "opensearch" {
+		return nil, invalidPlan("Engine", "the engine must be elasticsearch or opensearch, not %s", settings.Engine)
+	}
+	if settings.ElasticsearchVersion == "" {
+		return nil, invalidPlan("ElasticsearchVersion", "the version is required")
+	}
+	if settings.HeapSize == "" {
+		settings.HeapSize = "512m"
+	}
+	if !dockerHeapPattern.MatchString(settings.HeapSize) {
+		return nil, invalidPlan("HeapSize", "the heap size must be a size such as 512m or 1g, not %s", settings.HeapSize)
+	}
+	if settings.Image == "" && settings.Engine == "opensearch" {
+		settings.Image = "opensearchproject/opensearch:" + settings.ElasticsearchVersion
+	} else if settings.Image == "" {
+		settings.Image = "docker.elastic.co/elasticsearch/elasticsearch:" + settings.ElasticsearchVersion
+	}
+	if settings.DashboardsImage == "" && settings.Engine == "opensearch" {
+		settings.DashboardsImage = "opensearchproject/opensearch-dashboards:" + settings.ElasticsearchVersion
+	} else if settings.DashboardsImage == "" {
+		settings.DashboardsImage = "docker.elastic.co/kibana/kibana:" + settings.ElasticsearchVersion
+	}
+	return &settings, nil
+}
+
+func dockerPlanSettings(plan *ProviderPlan, data PlanTemplateData) (*DockerSettings, error) {
+	details, err := plan.RenderPrivateDetails(data)
+	if err != nil {
+		return nil, err
+	}
+	return ParseDockerSettings(details)
+}
+
+// validateDockerPlan checks the provider private details of a docker plan.
+func validateDockerPlan(plan *ProviderPlan) error {
+	_, err := dockerPlanSettings(plan, NewPlanTemplateData("00000000-0000-0000-0000-000000000000", "validation", ""))
+	return err
+}
+
+// DockerError is an error the docker engine api returned.
+type DockerError struct {
+	Code    int
+	Message string `json:"message"`
+}
+
+func (e *DockerError) Error() string {
+	return "Docker returned " + strconv.Itoa(e.Code) + ": " + e.Message
+}
+
+func isDockerNotFound(err error) bool {
+	derr, ok := err.(*DockerError)
+	return ok && derr.Code == http.StatusNotFound
+}
+
+// dockerContainer is the part of an inspected container the broker reads.
+type dockerContainer struct {
+	Id    string `json:"Id"`
+	State struct {
+		Status string `json:"Status"`
+		Health *struct {
+			Status string `json:"Status"`
+		} `json:"Health"`
+	} `json:"State"`
+	Config struct {
+		Image  string            `json:"Image"`
+		Env    []string          `json:"Env"`
+		Labels map[string]string `json:"Labels"`
+	} `json:"Config"`
+	NetworkSettings struct {
+		Ports map[string][]struct {
+			HostIp   string `json:"HostIp"`
+			HostPort string `json:"HostPort"`
+		} `json:"Ports"`
+	} `json:"NetworkSettings"`
+}
+
+// status maps the state of the container (and its health check) to the status of the instance.
+func (container *dockerContainer) status() string {
+	switch container.State.Status {
+	case "created", "restarting":
+		return "creating"
+	case "removing":
+		return "deleting"
+	case "running":
+		if container.State.Health == nil {
+			return "available"
+		}
+		switch container.State.Health.Status {
+		case "healthy":
+			return "available"
+		case "unhealthy":
+			return "unavailable"
+		}
+		return "creating"
+	}
+	return "unavailable"
+}
+
+func (container *dockerContainer) hostPort(port string) string {
+	bindings := container.NetworkSettings.Ports[port+"/tcp"]
+	if len(bindings) == 0 {
+		return ""
+	}
+	return bindings[0].HostPort
+}
+
+func (container *dockerContainer) version() string {
+	image := container.Config.Image
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[i+1:]
+	}
+	return ""
+}
+
+func dockerEndpointHost() string {
+	if os.Getenv("DOCKER_ENDPOINT_HOST") != "" {
+		return os.Getenv("DOCKER_ENDPOINT_HOST")
+	}
+	return "localhost"
+}
+
+func dockerPublishIp() string {
+	if os.Getenv("DOCKER_PUBLISH_IP") != "" {
+		return os.Getenv("DOCKER_PUBLISH_IP")
+	}
+	return "127.0.0.1"
+}
+
+func dashboardsContainer(name string) string {
+	return name + "-dashboards"
+}
+
+type DockerProvider struct {
+	Provider
+	namePrefix string
+	host       string
+	client     *http.Client
+}
+
+func NewDockerProvider(namePrefix string) (*DockerProvider, error) {
+	dockerHost := os.Getenv("DOCKER_HOST")
+	if dockerHost == "" {
+		dockerHost = "unix:///var/run/docker.sock"
+	}
+	u, err := url.Parse(dockerHost)
+	if err != nil {
+		return nil, errors.New("The DOCKER_HOST " + dockerHost + " is not valid: " + err.Error())
+	}
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		}
+		return &DockerProvider{namePrefix: namePrefix, host: "http://docker", client: &http.Client{Transport: transport}}, nil
+	case "tcp", "http":
+		return &DockerProvider{namePrefix: namePrefix, host: "http://" + u.Host, client: &http.Client{}}, nil
+	}
+	return nil, errors.New("The DOCKER_HOST " + dockerHost + " is not supported, use a unix:// or tcp:// address.")
+}
+
+func (provider DockerProvider) do(ctx context.Context, method string, path string, input interface{}) (*http.Response, error) {
+	var body io.Reader
+	if input != nil {
+		data, err := json.Marshal(input)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, provider.host+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if input != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := provider.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		derr := &DockerError{Code: resp.StatusCode}
+		data, _ := ioutil.ReadAll(resp.Body)
+		if json.Unmarshal(data, derr) != nil || derr.Message == "" {
+			derr.Message = string(data)
+		}
+		return nil, derr
+	}
+	return resp, nil
+}
+
+// request calls the docker engine api and reads its response into output (if not nil).
+func (provider DockerProvider) request(method string, path string, input interface{}, output interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), domainRequestTimeout())
+	defer cancel()
+	resp, err := provider.do(ctx, method, path, input)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if output == nil {
+		_, err = io.Copy(ioutil.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(output)
+}
+
+// pull pulls the image unless the engine already has it, the progress it streams reports
+// errors as messages rather than a status.
+func (provider DockerProvider) pull(image string) error {
+	if err := provider.request("GET", "/images/"+url.PathEscape(image)+"/json", nil, nil); err == nil {
+		return nil
+	} else if !isDockerNotFound(err) {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dockerPullTimeout)
+	defer cancel()
+	glog.Infof("Pulling the image %s\n", image)
+	resp, err := provider.do(ctx, "POST", "/images/create?fromImage="+url.QueryEscape(image), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var message struct {
+			Error string `json:"error"`
+		}
+		if err = decoder.Decode(&message); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if message.Error != "" {
+			return errors.New("Unable to pull the image " + image + ": " + message.Error)
+		}
+	}
+}
+
+func (provider DockerProvider) inspect(name string) (*dockerContainer, error) {
+	var container dockerContainer
+	if err := provider.request("GET", "/containers/"+name+"/json", nil, &container); err != nil {
+		return nil, err
+	}
+	return &container, nil
+}
+
+// createContainer creates and starts a container on the network of the instance.
+func (provider DockerProvider) createContainer(name string, instanceName string, image string, env []string, port string, volume string, healthcheck []string, labels map[string]string) error {
+	hostConfig := map[string]interface{}{
+		"NetworkMode":   instanceName,
+		"PortBindings":  map[string]interface{}{port + "/tcp": []map[string]string{{"HostIp": dockerPublishIp(), "HostPort": ""}}},
+		"RestartPolicy": map[string]string{"Name": "unless-stopped"},
+	}
+	if volume != "" {
+		hostConfig["Binds"] = []string{volume}
+	}
+	config := map[string]interface{}{
+		"Image":        image,
+		"Env":          env,
+		"Labels":       labels,
+		"ExposedPorts": map[string]interface{}{port + "/tcp": map[string]interface{}{}},
+		"HostConfig":   hostConfig,
+	}
+	if healthcheck != nil {
+		config["Healthcheck"] = map[string]interface{}{
+			"Test":        healthcheck,
+			"Interval":    int64(time.Second * 10),
+			"Timeout":     int64(time.Second * 5),
+			"Retries":     3,
+			"StartPeriod": int64(time.Minute * 2),
+		}
+	}
+	if err := provider.request("POST", "/containers/create?name="+url.QueryEscape(name), config, nil); err != nil {
+		return err
+	}
+	return provider.request("POST", "/containers/"+name+"/start", nil, nil)
+}
+
+// createContainers creates the node (and dashboards) of the instance.
+func (provider DockerProvider) createContainers(name string, settings *DockerSettings, labels map[string]string) error {
+	for _, image := range []string{settings.Image, settings.DashboardsImage} {
+		if image == settings.DashboardsImage && !settings.Dashboards {
+			continue
+		}
+		if err := provider.pull(image); err != nil {
+			return err
+		}
+	}
+	env := []string{"discovery.type=single-node", "cluster.name=" + name}
+	dataPath := "/usr/share/elasticsearch/data"
+	dashboardsEnv := []string{"ELASTICSEARCH_HOSTS=[\"http://" + name + ":9200\"]"}
+	if settings.Engine == "opensearch" {
+		env = append(env, "DISABLE_SECURITY_PLUGIN=true", "DISABLE_INSTALL_DEMO_CONFIG=true", "OPENSEARCH_JAVA_OPTS=-Xms"+settings.HeapSize+" -Xmx"+settings.HeapSize)
+		dataPath = "/usr/share/opensearch/data"
+		dashboardsEnv = []string{"OPENSEARCH_HOSTS=[\"http://" + name + ":9200\"]", "DISABLE_SECURITY_DASHBOARDS_PLUGIN=true"}
+	} else {
+		env = append(env, "xpack.security.enabled=false", "ES_JAVA_OPTS=-Xms"+settings.HeapSize+" -Xmx"+settings.HeapSize)
+	}
+	healthcheck := []string{"CMD-SHELL", "curl -fs http://localhost:9200/_cluster/health || exit 1"}
+	if err := provider.createContainer(name, name, settings.Image, env, "9200", name+"-data:"+dataPath, healthcheck, labels); err != nil {
+		return err
+	}
+	if settings.Dashboards {
+		if err := provider.createContainer(dashboardsContainer(name), name, settings.DashboardsImage, dashboardsEnv, "5601", "", nil, labels); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeContainers removes the containers of the instance (force stops them), the network
+// and volume are left.
+func (provider DockerProvider) removeContainers(name string) error {
+	for _, container := range []string{dashboardsContainer(name), name} {
+		if err := provider.request("DELETE", "/containers/"+container+"?force=true", nil, nil); err != nil && !isDockerNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (provider DockerProvider) instance(name string, container *dockerContainer, plan *ProviderPlan, settings *DockerSettings) *Instance {
+	return &Instance{
+		Name:          name,
+		ProviderId:    container.Id,
+		Plan:          plan,
+		Endpoint:      dockerEndpointHost() + ":" + container.hostPort("9200"),
+		Status:        container.status(),
+		Ready:         container.status() == "available",
+		Engine:        settings.Engine,
+		EngineVersion: container.version(),
+		Scheme:        "http",
+	}
+}
+
+func (provider DockerProvider) CreateRandomName() string {
+	id, _ := uuid.NewV4()
+	return provider.namePrefix + "-" + (strings.Split(id.String(), "-")[0])
+}
+
+func (provider DockerProvider) GetInstance(name string, plan *ProviderPlan) (*Instance, error) {
+	settings, err := dockerPlanSettings(plan, NewPlanTemplateData("", name, ""))
+	if err != nil {
+		return nil, err
+	}
+	container, err := provider.inspect(name)
+	if err != nil {
+		return nil, err
+	}
+	return provider.instance(name, container, plan, settings), nil
+}
+
+// Provision creates the network and volume of the instance and starts its containers.
+func (provider DockerProvider) Provision(Id string, name string, plan *ProviderPlan, Owner string) (*Instance, error) {
+	settings, err := dockerPlanSettings(plan, NewPlanTemplateData(Id, name, Owner))
+	if err != nil {
+		return nil, err
+	}
+	labels := map[string]string{dockerLabel: "true", dockerLabel + ".instance": Id, dockerLabel + ".billingcode": Owner}
+	err = provider.request("POST", "/networks/create", map[string]interface{}{"Name": name, "CheckDuplicate": true, "Labels": labels}, nil)
+	if derr, ok := err.(*DockerError); ok && derr.Code == http.StatusConflict {
+		return nil, &NameCollisionError{Name: name}
+	} else if err != nil {
+		return nil, err
+	}
+	if err = provider.request("POST", "/volumes/create", map[string]interface{}{"Name": name + "-data", "Labels": labels}, nil); err != nil {
+		return nil, err
+	}
+	if err = provider.createContainers(name, settings, labels); err != nil {
+		return nil, err
+	}
+	container, err := provider.inspect(name)
+	if err != nil {
+		return nil, err
+	}
+	instance := provider.instance(name, container, plan, settings)
+	instance.Id = Id
+	instance.Owner = Owner
+	return instance, nil
+}
+
+// Deprovision removes the containers, network and data of the instance.
+func (provider DockerProvider) Deprovision(Instance *Instance, takeSnapshot bool) error {
+	if err := provider.removeContainers(Instance.Name); err != nil {
+		return err
+	}
+	if err := provider.request("DELETE", "/networks/"+Instance.Name, nil, nil); err != nil && !isDockerNotFound(err) {
+		return err
+	}
+	if err := provider.request("DELETE", "/volumes/"+Instance.Name+"-data", nil, nil); err != nil && !isDockerNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (provider DockerProvider) IsDeleted(Instance *Instance) (bool, error) {
+	_, err := provider.inspect(Instance.Name)
+	if err != nil && isDockerNotFound(err) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// Modify recreates the containers with the plan, the data is kept on the volume of the
+// instance. The engine can't be changed and the version can't be downgraded.
+func (provider DockerProvider) Modify(instance *Instance, plan *ProviderPlan, maintenance bool) (*Instance, error) {
+	settings, err := dockerPlanSettings(plan, NewPlanTemplateData(instance.Id, instance.Name, instance.Owner))
+	if err != nil {
+		return nil, err
+	}
+	current, err := dockerPlanSettings(instance.Plan, NewPlanTemplateData(instance.Id, instance.Name, instance.Owner))
+	if err != nil {
+		return nil, err
+	}
+	if current.Engine != settings.Engine {
+		return nil, errors.New("Unable to change " + instance.Name + " from " + current.Engine + " to " + settings.Engine + ", create a new instance instead.")
+	}
+	if engineVersionOlder(settings.ElasticsearchVersion, instance.EngineVersion) {
+		return nil, errors.New("Unable to change " + instance.Name + " to " + settings.ElasticsearchVersion + ", it runs " + instance.EngineVersion + " and its data can't be downgraded.")
+	}
+	container, err := provider.inspect(instance.Name)
+	if err != nil {
+		return nil, err
+	}
+	if err = provider.removeContainers(instance.Name); err != nil {
+		return nil, err
+	}
+	if err = provider.createContainers(instance.Name, settings, container.Config.Labels); err != nil {
+		return nil, err
+	}
+	if container, err = provider.inspect(instance.Name); err != nil {
+		return nil, err
+	}
+	modified := provider.instance(instance.Name, container, plan, settings)
+	modified.Id = instance.Id
+	return modified, nil
+}
+
+// Tag does nothing, the labels of containers can't be changed once they are created.
+func (provider DockerProvider) Tag(Instance *Instance, Name string, Value string) error {
+	return nil
+}
+
+func (provider DockerProvider) Untag(Instance *Instance, Name string) error {
+	return nil
+}
+
+// PerformPostProvision does nothing, the containers run without security so the instances
+// have no credentials.
+func (provider DockerProvider) PerformPostProvision(db *Instance) (*Instance, error) {
+	return db, nil
+}
+
+func (provider DockerProvider) GetUrl(instance *Instance) map[string]interface{} {
+	urls := map[string]interface{}{
+		"ES_URL": instance.Scheme + "://" + instance.Endpoint,
+	}
+	if container, err := provider.inspect(dashboardsContainer(instance.Name)); err == nil && container.hostPort("5601") != "" {
+		urls["KIBANA_URL"] = "http://" + dockerEndpointHost() + ":" + container.hostPort("5601")
+	} else if err != nil && !isDockerNotFound(err) {
+		glog.Errorf("Unable to get the dashboards of %s: %s\n", instance.Name, err.Error())
+	}
+	return urls
+}
+
+// GetNetwork describes the published ports of the containers.
+func (provider DockerProvider) GetNetwork(instance *Instance) (*NetworkInfo, error) {
+	network := &NetworkInfo{
+		SubnetIds:         []string{},
+		SecurityGroupIds:  []string{},
+		AvailabilityZones: []string{},
+		Endpoint:          instance.Endpoint,
+		Ports:             []int{},
+		Protocol:          "tcp",
+		Public:            dockerPublishIp() != "127.0.0.1",
+	}
+	for _, name := range []string{instance.Name, dashboardsContainer(instance.Name)} {
+		container, err := provider.inspect(name)
+		if err != nil && isDockerNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, port := range []string{"9200", "5601"} {
+			if hostPort, err := strconv.Atoi(container.hostPort(port)); err == nil {
+				network.Ports = append(network.Ports, hostPort)
+			}
+		}
+	}
+	return network, nil
+}
+
+// GetConfig returns the image and environment of the node container.
+func (provider DockerProvider) GetConfig(instance *Instance) (map[string]string, error) {
+	container, err := provider.inspect(instance.Name)
+	if err != nil {
+		return nil, err
+	}
+	env := make(map[string]interface{})
+	for _, variable := range container.Config.Env {
+		if parts := strings.SplitN(variable, "=", 2); len(parts) == 2 {
+			env[parts[0]] = parts[1]
+		}
+	}
+	config := make(map[string]string)
+	flattenConfig("", map[string]interface{}{"Image": container.Config.Image, "Env": env}, config)
+	return config, nil
+}
@@ -0,0 +1,432 @@
+package broker
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/nu7hatch/gouuid"
+)
+
+// The eck provider creates Elasticsearch (and Kibana) custom resources for the ECK operator
+// in a kubernetes cluster, for dev and test plans that run on a cluster instead of aws. The
+// cluster is the one the broker runs in, or the one of the kubeconfig in ECK_KUBECONFIG, the
+// operator must already be installed. The provider private details of its plans are
+// ECKSettings, their node sets are in the shape of the Elasticsearch resource. Instances get
+// the credentials of the elastic user ECK generates, and tags are kept as annotations of the
+// Elasticsearch resource (see kubernetes.go).
+
+const (
+	eckElasticsearchApi = "/apis/elasticsearch.k8s.elastic.co/v1"
+	eckKibanaApi        = "/apis/kibana.k8s.elastic.co/v1"
+)
+
+// ECKSettings are the provider private details of eck plans.
+type ECKSettings struct {
+	// The namespace of the resources, ECK_NAMESPACE (or default) if empty.
+	Namespace            string `json:"Namespace"`
+	ElasticsearchVersion string `json:"ElasticsearchVersion"`
+	// The spec.nodeSets of the Elasticsearch resource, e.g., [{"name":"default","count":1}].
+	NodeSets []map[string]interface{} `json:"NodeSets"`
+	// Turns off the self signed certificate ECK serves http with.
+	DisableTLS bool `json:"DisableTLS"`
+	// The number of Kibana instances, none if 0.
+	KibanaCount int64 `json:"KibanaCount"`
+}
+
+// ParseECKSettings reads the settings of a plan from its rendered details.
+func ParseECKSettings(details []byte) (*ECKSettings, error) {
+	var settings ECKSettings
+	if err := json.Unmarshal(details, &settings); err != nil {
+		return nil, err
+	}
+	if settings.ElasticsearchVersion == "" {
+		return nil, invalidPlan("ElasticsearchVersion", "the elasticsearch version is required")
+	}
+	if len(settings.NodeSets) == 0 {
+		return nil, invalidPlan("NodeSets", "at least one node set is required")
+	}
+	for _, nodeSet := range settings.NodeSets {
+		if name, ok := nodeSet["name"].(string); !ok || name == "" {
+			return nil, invalidPlan("NodeSets", "every node set needs a name")
+		}
+	}
+	if settings.KibanaCount < 0 {
+		return nil, invalidPlan("KibanaCount", "the number of Kibana instances can't be negative, not %d", settings.KibanaCount)
+	}
+	if settings.Namespace == "" {
+		settings.Namespace = os.Getenv("ECK_NAMESPACE")
+	}
+	if settings.Namespace == "" {
+		settings.Namespace = "default"
+	}
+	return &settings, nil
+}
+
+func eckPlanSettings(plan *ProviderPlan, data PlanTemplateData) (*ECKSettings, error) {
+	details, err := plan.RenderPrivateDetails(data)
+	if err != nil {
+		return nil, err
+	}
+	return ParseECKSettings(details)
+}
+
+// validateECKPlan checks the provider private details of an eck plan.
+func validateECKPlan(plan *ProviderPlan) error {
+	_, err := eckPlanSettings(plan, NewPlanTemplateData("00000000-0000-0000-0000-000000000000", "validation", ""))
+	return err
+}
+
+// eckResource is the part of an Elasticsearch or Kibana resource the broker reads.
+type eckResource struct {
+	Metadata struct {
+		Name              string            `json:"name"`
+		Namespace         string            `json:"namespace"`
+		Uid               string            `json:"uid"`
+		DeletionTimestamp string            `json:"deletionTimestamp"`
+		Annotations       map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Spec   map[string]interface{} `json:"spec"`
+	Status struct {
+		Health         string `json:"health"`
+		Phase          string `json:"phase"`
+		AvailableNodes int64  `json:"availableNodes"`
+		Version        string `json:"version"`
+	} `json:"status"`
+}
+
+func (resource *eckResource) specVersion() string {
+	version, _ := resource.Spec["version"].(string)
+	return version
+}
+
+// status maps the health and phase ECK reports on the Elasticsearch resource to the status
+// of the instance.
+func (resource *eckResource) status() string {
+	if resource.Metadata.DeletionTimestamp != "" {
+		return "deleting"
+	}
+	switch resource.Status.Phase {
+	case "Invalid":
+		return "invalid"
+	case "ApplyingChanges", "MigratingData":
+		if resource.Status.Version != "" && resource.Status.Version != resource.specVersion() {
+			return "upgrading"
+		}
+		return "processing"
+	}
+	switch resource.Status.Health {
+	case "green", "yellow":
+		return "available"
+	case "red":
+		if resource.Status.AvailableNodes == 0 && resource.Status.Version == "" {
+			return "creating"
+		}
+		return "unavailable"
+	}
+	return "creating"
+}
+
+func (resource *eckResource) ready() bool {
+	return resource.Metadata.DeletionTimestamp == "" && (resource.Status.Health == "green" || resource.Status.Health == "yellow")
+}
+
+type ECKProvider struct {
+	Provider
+	*kubernetesClient
+	namePrefix string
+}
+
+func NewECKProvider(namePrefix string) (*ECKProvider, error) {
+	client, err := newKubernetesClient(os.Getenv("ECK_KUBECONFIG"))
+	if err != nil {
+		return nil, errors.New("Unable to find the kubernetes cluster of the eck provider: " + err.Error())
+	}
+	return &ECKProvider{kubernetesClient: client, namePrefix: namePrefix}, nil
+}
+func (provider ECKProvider) elasticsearch(namespace string, name string) (*eckResource, error) {
+	var resource eckResource
+	if err := provider.request("GET", kubernetesPath(eckElasticsearchApi, "elasticsearches", namespace, name), "", nil, &resource); err != nil {
+		return nil, err
+	}
+	return &resource, nil
+}
+
+func eckScheme(settings *ECKSettings) string {
+	if settings.DisableTLS {
+		return "http"
+	}
+	return "https"
+}
+
+// elasticsearchSpec is the spec of the Elasticsearch resource of the settings.
+func elasticsearchSpec(settings *ECKSettings) map[string]interface{} {
+	spec := map[string]interface{}{
+		"version":  settings.ElasticsearchVersion,
+		"nodeSets": settings.NodeSets,
+	}
+	if settings.DisableTLS {
+		spec["http"] = map[string]interface{}{"tls": map[string]interface{}{"selfSignedCertificate": map[string]interface{}{"disabled": true}}}
+	}
+	return spec
+}
+
+func kibanaSpec(name string, settings *ECKSettings) map[string]interface{} {
+	spec := map[string]interface{}{
+		"version":          settings.ElasticsearchVersion,
+		"count":            settings.KibanaCount,
+		"elasticsearchRef": map[string]interface{}{"name": name},
+	}
+	if settings.DisableTLS {
+		spec["http"] = map[string]interface{}{"tls": map[string]interface{}{"selfSignedCertificate": map[string]interface{}{"disabled": true}}}
+	}
+	return spec
+}
+
+func (provider ECKProvider) instance(resource *eckResource, settings *ECKSettings, plan *ProviderPlan) *Instance {
+	version := resource.Status.Version
+	if version == "" {
+		version = resource.specVersion()
+	}
+	return &Instance{
+		Name:          resource.Metadata.Name,
+		ProviderId:    resource.Metadata.Uid,
+		Plan:          plan,
+		Endpoint:      resource.Metadata.Name + "-es-http." + settings.Namespace + ".svc:9200",
+		Status:        resource.status(),
+		Ready:         resource.ready(),
+		Engine:        "elasticsearch",
+		EngineVersion: version,
+		Scheme:        eckScheme(settings),
+	}
+}
+
+func (provider ECKProvider) CreateRandomName() string {
+	id, _ := uuid.NewV4()
+	return provider.namePrefix + "-" + (strings.Split(id.String(), "-")[0])
+}
+
+func (provider ECKProvider) GetInstance(name string, plan *ProviderPlan) (*Instance, error) {
+	settings, err := eckPlanSettings(plan, NewPlanTemplateData("", name, ""))
+	if err != nil {
+		return nil, err
+	}
+	resource, err := provider.elasticsearch(settings.Namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	return provider.instance(resource, settings, plan), nil
+}
+
+func (provider ECKProvider) Provision(Id string, name string, plan *ProviderPlan, Owner string) (*Instance, error) {
+	settings, err := eckPlanSettings(plan, NewPlanTemplateData(Id, name, Owner))
+	if err != nil {
+		return nil, err
+	}
+	var resource eckResource
+	err = provider.request("POST", kubernetesPath(eckElasticsearchApi, "elasticsearches", settings.Namespace, ""), "application/json", map[string]interface{}{
+		"apiVersion": "elasticsearch.k8s.elastic.co/v1",
+		"kind":       "Elasticsearch",
+		"metadata": map[string]interface{}{
+			"name":        name,
+			"namespace":   settings.Namespace,
+			"labels":      map[string]string{"app.kubernetes.io/managed-by": "elasticsearch-broker"},
+			"annotations": map[string]string{kubernetesAnnotation("billingcode"): Owner},
+		},
+		"spec": elasticsearchSpec(settings),
+	}, &resource)
+	if err != nil && isKubernetesError(err, "AlreadyExists") {
+		return nil, &NameCollisionError{Name: name}
+	} else if err != nil {
+		return nil, err
+	}
+	if settings.KibanaCount > 0 {
+		if err = provider.createKibana(name, settings); err != nil {
+			return nil, err
+		}
+	}
+	instance := provider.instance(&resource, settings, plan)
+	instance.Id = Id
+	instance.Owner = Owner
+	return instance, nil
+}
+
+func (provider ECKProvider) createKibana(name string, settings *ECKSettings) error {
+	return provider.request("POST", kubernetesPath(eckKibanaApi, "kibanas", settings.Namespace, ""), "application/json", map[string]interface{}{
+		"apiVersion": "kibana.k8s.elastic.co/v1",
+		"kind":       "Kibana",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": settings.Namespace,
+			"labels":    map[string]string{"app.kubernetes.io/managed-by": "elasticsearch-broker"},
+		},
+		"spec": kibanaSpec(name, settings),
+	}, nil)
+}
+
+func (provider ECKProvider) Deprovision(Instance *Instance, takeSnapshot bool) error {
+	settings, err := eckPlanSettings(Instance.Plan, NewPlanTemplateData(Instance.Id, Instance.Name, Instance.Owner))
+	if err != nil {
+		return err
+	}
+	err = provider.request("DELETE", kubernetesPath(eckKibanaApi, "kibanas", settings.Namespace, Instance.Name), "", nil, nil)
+	if err != nil && !isKubernetesError(err, "NotFound") {
+		return err
+	}
+	return provider.request("DELETE", kubernetesPath(eckElasticsearchApi, "elasticsearches", settings.Namespace, Instance.Name), "", nil, nil)
+}
+
+// IsDeleted is true once kubernetes no longer has the Elasticsearch resource, ECK removes
+// its pods and volumes first.
+func (provider ECKProvider) IsDeleted(Instance *Instance) (bool, error) {
+	settings, err := eckPlanSettings(Instance.Plan, NewPlanTemplateData(Instance.Id, Instance.Name, Instance.Owner))
+	if err != nil {
+		return false, err
+	}
+	_, err = provider.elasticsearch(settings.Namespace, Instance.Name)
+	if err != nil && isKubernetesError(err, "NotFound") {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// Modify applies the plan to the resources, ECK rolls the change (or upgrade) through the
+// node sets itself, so a maintenance update is the same change with the version of the plan.
+func (provider ECKProvider) Modify(instance *Instance, plan *ProviderPlan, maintenance bool) (*Instance, error) {
+	settings, err := eckPlanSettings(plan, NewPlanTemplateData(instance.Id, instance.Name, instance.Owner))
+	if err != nil {
+		return nil, err
+	}
+	current, err := eckPlanSettings(instance.Plan, NewPlanTemplateData(instance.Id, instance.Name, instance.Owner))
+	if err != nil {
+		return nil, err
+	}
+	if current.Namespace != settings.Namespace {
+		return nil, errors.New("Unable to move " + instance.Name + " from the namespace " + current.Namespace + " to " + settings.Namespace + ", create a new instance instead.")
+	}
+	if engineVersionOlder(settings.ElasticsearchVersion, instance.EngineVersion) {
+		return nil, errors.New("Unable to change " + instance.Name + " to Elasticsearch " + settings.ElasticsearchVersion + ", it runs " + instance.EngineVersion + " and ECK can't downgrade a cluster.")
+	}
+	var resource eckResource
+	err = provider.request("PATCH", kubernetesPath(eckElasticsearchApi, "elasticsearches", settings.Namespace, instance.Name), "application/merge-patch+json", map[string]interface{}{
+		"spec": elasticsearchSpec(settings),
+	}, &resource)
+	if err != nil {
+		return nil, err
+	}
+	kibana := kubernetesPath(eckKibanaApi, "kibanas", settings.Namespace, instance.Name)
+	if settings.KibanaCount == 0 && current.KibanaCount > 0 {
+		err = provider.request("DELETE", kibana, "", nil, nil)
+		if err != nil && !isKubernetesError(err, "NotFound") {
+			return nil, err
+		}
+	} else if settings.KibanaCount > 0 {
+		err = provider.request("PATCH", kibana, "application/merge-patch+json", map[string]interface{}{"spec": kibanaSpec(instance.Name, settings)}, nil)
+		if err != nil && isKubernetesError(err, "NotFound") {
+			err = provider.createKibana(instance.Name, settings)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	modified := provider.instance(&resource, settings, plan)
+	modified.Id = instance.Id
+	return modified, nil
+}
+
+func (provider ECKProvider) annotate(instance *Instance, name string, value interface{}) error {
+	settings, err := eckPlanSettings(instance.Plan, NewPlanTemplateData(instance.Id, instance.Name, instance.Owner))
+	if err != nil {
+		return err
+	}
+	return provider.request("PATCH", kubernetesPath(eckElasticsearchApi, "elasticsearches", settings.Namespace, instance.Name), "application/merge-patch+json", map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": map[string]interface{}{kubernetesAnnotation(name): value}},
+	}, nil)
+}
+
+func (provider ECKProvider) Tag(Instance *Instance, Name string, Value string) error {
+	return provider.annotate(Instance, Name, Value)
+}
+
+// Untag removes the annotation, a merge patch removes fields set to null.
+func (provider ECKProvider) Untag(Instance *Instance, Name string) error {
+	return provider.annotate(Instance, Name, nil)
+}
+
+// PerformPostProvision reads the password of the elastic user from the secret ECK created
+// with the cluster.
+func (provider ECKProvider) PerformPostProvision(db *Instance) (*Instance, error) {
+	settings, err := eckPlanSettings(db.Plan, NewPlanTemplateData(db.Id, db.Name, db.Owner))
+	if err != nil {
+		return nil, err
+	}
+	var secret struct {
+		Data map[string]string `json:"data"`
+	}
+	if err = provider.request("GET", "/api/v1/namespaces/"+settings.Namespace+"/secrets/"+db.Name+"-es-elastic-user", "", nil, &secret); err != nil {
+		return nil, err
+	}
+	password, err := base64.StdEncoding.DecodeString(secret.Data["elastic"])
+	if err != nil || len(password) == 0 {
+		return nil, errors.New("Unable to read the password of the elastic user of " + db.Name + ".")
+	}
+	db.Username = "elastic"
+	db.Password = string(password)
+	return db, nil
+}
+
+func (provider ECKProvider) GetUrl(instance *Instance) map[string]interface{} {
+	urls := map[string]interface{}{
+		"ES_URL": instance.Scheme + "://" + instance.Endpoint,
+	}
+	settings, err := eckPlanSettings(instance.Plan, NewPlanTemplateData(instance.Id, instance.Name, instance.Owner))
+	if err != nil {
+		glog.Errorf("Unable to get the eck settings of %s: %s\n", instance.Name, err.Error())
+		return urls
+	}
+	if settings.KibanaCount > 0 {
+		urls["KIBANA_URL"] = instance.Scheme + "://" + instance.Name + "-kb-http." + settings.Namespace + ".svc:5601"
+	}
+	return urls
+}
+
+// GetNetwork describes the services of the cluster, they are only reachable inside kubernetes.
+func (provider ECKProvider) GetNetwork(instance *Instance) (*NetworkInfo, error) {
+	settings, err := eckPlanSettings(instance.Plan, NewPlanTemplateData(instance.Id, instance.Name, instance.Owner))
+	if err != nil {
+		return nil, err
+	}
+	network := &NetworkInfo{
+		SubnetIds:         []string{},
+		SecurityGroupIds:  []string{},
+		AvailabilityZones: []string{},
+		Endpoint:          instance.Endpoint,
+		Ports:             []int{9200},
+		Protocol:          "tcp",
+		Public:            false,
+	}
+	if settings.KibanaCount > 0 {
+		network.Ports = append(network.Ports, 5601)
+	}
+	return network, nil
+}
+
+// GetConfig returns the spec of the Elasticsearch resource.
+func (provider ECKProvider) GetConfig(instance *Instance) (map[string]string, error) {
+	settings, err := eckPlanSettings(instance.Plan, NewPlanTemplateData(instance.Id, instance.Name, instance.Owner))
+	if err != nil {
+		return nil, err
+	}
+	resource, err := provider.elasticsearch(settings.Namespace, instance.Name)
+	if err != nil {
+		return nil, err
+	}
+	config := make(map[string]string)
+	flattenConfig("", resource.Spec, config)
+	return config, nil
+}
@@ -0,0 +1,683 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/nu7hatch/gouuid"
+)
+
+// The elastic-cloud provider creates deployments on Elastic Cloud (ESS) with its deployments
+// api, so plans can offer clusters hosted by Elastic with Kibana and APM. The broker
+// authenticates with an Elastic Cloud api key, ELASTIC_CLOUD_API_KEY or the ApiKey of the
+// plan (which may be a secret reference). The provider private details of its plans are
+// ElasticCloudSettings, the deployment template they name decides the hardware the sizes are
+// on. Deployments are created with the credentials of their elastic user, which bindings get.
+
+const elasticCloudEsRefId = "main-elasticsearch"
+
+// ElasticCloudTopology is the size of a part of a deployment, Size is its memory in MB.
+type ElasticCloudTopology struct {
+	// The topology element of the template (e.g., hot_content or warm), only for elasticsearch.
+	Id                      string `json:"Id"`
+	InstanceConfigurationId string `json:"InstanceConfigurationId"`
+	Size                    int64  `json:"Size"`
+	ZoneCount               int64  `json:"ZoneCount"`
+}
+
+// ElasticCloudSettings are the provider private details of elastic-cloud plans.
+type ElasticCloudSettings struct {
+	// The Elastic Cloud region, e.g., gcp-us-central1 or aws-us-east-1.
+	Region string `json:"Region"`
+	// The deployment template the deployment is created from, e.g., gcp-general-purpose.
+	DeploymentTemplateId string                 `json:"DeploymentTemplateId"`
+	ElasticsearchVersion string                 `json:"ElasticsearchVersion"`
+	Elasticsearch        []ElasticCloudTopology `json:"Elasticsearch"`
+	// Kibana and APM are left out if not set, APM is an integrations server from version 8.
+	Kibana *ElasticCloudTopology `json:"Kibana"`
+	Apm    *ElasticCloudTopology `json:"Apm"`
+	ApiKey string                `json:"ApiKey"`
+}
+
+// ParseElasticCloudSettings reads the settings of a plan from its rendered details.
+func ParseElasticCloudSettings(details []byte) (*ElasticCloudSettings, error) {
+	var settings ElasticCloudSettings
+	if err := json.Unmarshal(details, &settings); err != nil {
+		return nil, err
+	}
+	if settings.Region == "" {
+		return nil, invalidPlan("Region", "the Elastic Cloud region is required")
+	}
+	if settings.DeploymentTemplateId == "" {
+		return nil, invalidPlan("DeploymentTemplateId", "the deployment template is required")
+	}
+	if settings.ElasticsearchVersion == "" {
+		return nil, invalidPlan("ElasticsearchVersion", "the elasticsearch version is required")
+	}
+	if len(settings.Elasticsearch) == 0 {
+		return nil, invalidPlan("Elasticsearch", "at least one elasticsearch topology element is required")
+	}
+	topologies := append(elasticCloudOptional(settings.Kibana, settings.Apm), settings.Elasticsearch...)
+	for _, topology := range topologies {
+		if topology.Size <= 0 {
+			return nil, invalidPlan("Size", "the size (MB of memory) must be above 0, not %d", topology.Size)
+		}
+		if topology.ZoneCount < 0 || topology.ZoneCount > 3 {
+			return nil, invalidPlan("ZoneCount", "the zone count must be between 1 and 3, not %d", topology.ZoneCount)
+		}
+	}
+	if settings.ApiKey == "" {
+		settings.ApiKey = os.Getenv("ELASTIC_CLOUD_API_KEY")
+	}
+	return &settings, nil
+}
+
+func elasticCloudOptional(topologies ...*ElasticCloudTopology) []ElasticCloudTopology {
+	set := make([]ElasticCloudTopology, 0)
+	for _, topology := range topologies {
+		if topology != nil {
+			set = append(set, *topology)
+		}
+	}
+	return set
+}
+
+func elasticCloudPlanSettings(plan *ProviderPlan, data PlanTemplateData) (*ElasticCloudSettings, error) {
+	details, err := plan.RenderPrivateDetails(data)
+	if err != nil {
+		return nil, err
+	}
+	return ParseElasticCloudSettings(details)
+}
+
+// validateElasticCloudPlan checks the provider private details of an elastic-cloud plan.
+func validateElasticCloudPlan(plan *ProviderPlan) error {
+	_, err := elasticCloudPlanSettings(plan, NewPlanTemplateData("00000000-0000-0000-0000-000000000000", "validation", ""))
+	return err
+}
+
+// elasticCloudEndpoint is the Elastic Cloud api, it can be set with ELASTIC_CLOUD_API_URL
+// (e.g., for Elastic Cloud Enterprise).
+func elasticCloudEndpoint() string {
+	if os.Getenv("ELASTIC_CLOUD_API_URL") != "" {
+		return strings.TrimSuffix(os.Getenv("ELASTIC_CLOUD_API_URL"), "/")
+	}
+	return "https://api.elastic-cloud.com"
+}
+
+// ElasticCloudError is an error returned by the Elastic Cloud api.
+type ElasticCloudError struct {
+	StatusCode int
+	Codes      []string
+	Message    string
+}
+
+func (e *ElasticCloudError) Error() string {
+	return "Elastic Cloud returned " + strconv.Itoa(e.StatusCode) + " (" + strings.Join(e.Codes, ", ") + "): " + e.Message
+}
+
+func isElasticCloudNotFound(err error) bool {
+	cerr, ok := err.(*ElasticCloudError)
+	return ok && cerr.StatusCode == http.StatusNotFound
+}
+
+// elasticCloudRequest calls the Elastic Cloud api and reads its response into output (if not nil).
+func elasticCloudRequest(ctx context.Context, apiKey string, method string, path string, input interface{}, output interface{}) error {
+	if apiKey == "" {
+		return errors.New("Unable to find the Elastic Cloud api key, set ELASTIC_CLOUD_API_KEY or the ApiKey of the plan.")
+	}
+	var body []byte
+	var err error
+	if input != nil {
+		if body, err = json.Marshal(input); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, elasticCloudEndpoint()+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "ApiKey "+apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var failure struct {
+			Errors []struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"errors"`
+		}
+		json.Unmarshal(data, &failure)
+		cerr := &ElasticCloudError{StatusCode: resp.StatusCode, Codes: []string{}, Message: resp.Status}
+		messages := make([]string, 0)
+		for _, e := range failure.Errors {
+			cerr.Codes = append(cerr.Codes, e.Code)
+			messages = append(messages, e.Message)
+		}
+		if len(messages) > 0 {
+			cerr.Message = strings.Join(messages, " ")
+		}
+		return cerr
+	}
+	if output == nil {
+		return nil
+	}
+	return json.Unmarshal(data, output)
+}
+
+type elasticCloudTag struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// ElasticCloudResource is an elasticsearch, kibana, apm or integrations server resource of a
+// deployment as the api returns it.
+type ElasticCloudResource struct {
+	RefId  string `json:"ref_id"`
+	Id     string `json:"id"`
+	Region string `json:"region"`
+	Info   struct {
+		Status   string `json:"status"`
+		Healthy  bool   `json:"healthy"`
+		Metadata struct {
+			Endpoint   string `json:"endpoint"`
+			ServiceUrl string `json:"service_url"`
+			AliasedUrl string `json:"aliased_url"`
+			CloudId    string `json:"cloud_id"`
+		} `json:"metadata"`
+		PlanInfo struct {
+			Current *struct {
+				Plan map[string]interface{} `json:"plan"`
+			} `json:"current"`
+			Pending *struct {
+				Plan map[string]interface{} `json:"plan"`
+			} `json:"pending"`
+		} `json:"plan_info"`
+	} `json:"info"`
+}
+
+// url is the public url of the resource, the alias if it has one.
+func (resource *ElasticCloudResource) url() string {
+	if resource.Info.Metadata.AliasedUrl != "" {
+		return resource.Info.Metadata.AliasedUrl
+	}
+	return resource.Info.Metadata.ServiceUrl
+}
+
+// ElasticCloudDeployment is a deployment as the api returns it.
+type ElasticCloudDeployment struct {
+	Id        string `json:"id"`
+	Name      string `json:"name"`
+	Healthy   bool   `json:"healthy"`
+	Resources struct {
+		Elasticsearch      []ElasticCloudResource `json:"elasticsearch"`
+		Kibana             []ElasticCloudResource `json:"kibana"`
+		Apm                []ElasticCloudResource `json:"apm"`
+		IntegrationsServer []ElasticCloudResource `json:"integrations_server"`
+	} `json:"resources"`
+	Metadata struct {
+		Tags []elasticCloudTag `json:"tags"`
+	} `json:"metadata"`
+}
+
+func (deployment *ElasticCloudDeployment) elasticsearch() *ElasticCloudResource {
+	if len(deployment.Resources.Elasticsearch) == 0 {
+		return nil
+	}
+	return &deployment.Resources.Elasticsearch[0]
+}
+
+func (deployment *ElasticCloudDeployment) kibana() *ElasticCloudResource {
+	if len(deployment.Resources.Kibana) == 0 {
+		return nil
+	}
+	return &deployment.Resources.Kibana[0]
+}
+
+func (deployment *ElasticCloudDeployment) apm() *ElasticCloudResource {
+	if len(deployment.Resources.IntegrationsServer) > 0 {
+		return &deployment.Resources.IntegrationsServer[0]
+	} else if len(deployment.Resources.Apm) > 0 {
+		return &deployment.Resources.Apm[0]
+	}
+	return nil
+}
+
+// elasticCloudPlanVersion is the elasticsearch version of a plan of an elasticsearch resource.
+func elasticCloudPlanVersion(plan map[string]interface{}) string {
+	if es, ok := plan["elasticsearch"].(map[string]interface{}); ok {
+		if version, ok := es["version"].(string); ok {
+			return version
+		}
+	}
+	return ""
+}
+
+// status is the instance status of the deployment, from its elasticsearch resource.
+func (deployment *ElasticCloudDeployment) status() string {
+	es := deployment.elasticsearch()
+	if es == nil {
+		return "unknown"
+	}
+	switch es.Info.Status {
+	case "initializing":
+		return "creating"
+	case "stopping", "stopped":
+		return "deleting"
+	case "reconfiguring", "rebooting", "restarting":
+		if es.Info.PlanInfo.Pending != nil && es.Info.PlanInfo.Current != nil && elasticCloudPlanVersion(es.Info.PlanInfo.Pending.Plan) != elasticCloudPlanVersion(es.Info.PlanInfo.Current.Plan) {
+			return "upgrading"
+		}
+		return "processing"
+	}
+	if es.Info.PlanInfo.Pending != nil {
+		return "processing"
+	}
+	return "available"
+}
+
+func (deployment *ElasticCloudDeployment) engineVersion() string {
+	if es := deployment.elasticsearch(); es != nil && es.Info.PlanInfo.Current != nil {
+		return elasticCloudPlanVersion(es.Info.PlanInfo.Current.Plan)
+	}
+	return ""
+}
+
+func elasticCloudTopology(topology ElasticCloudTopology) map[string]interface{} {
+	element := map[string]interface{}{
+		"size":       map[string]interface{}{"value": topology.Size, "resource": "memory"},
+		"zone_count": topology.ZoneCount,
+	}
+	if topology.ZoneCount == 0 {
+		element["zone_count"] = 1
+	}
+	if topology.Id != "" {
+		element["id"] = topology.Id
+	}
+	if topology.InstanceConfigurationId != "" {
+		element["instance_configuration_id"] = topology.InstanceConfigurationId
+	}
+	return element
+}
+
+// elasticCloudMajor is the major version of the elasticsearch version.
+func elasticCloudMajor(version string) int {
+	major, _ := strconv.Atoi(strings.Split(version, ".")[0])
+	return major
+}
+
+// deploymentResources are the resources of the deployments api for the settings.
+func deploymentResources(settings *ElasticCloudSettings) map[string]interface{} {
+	topology := make([]interface{}, 0)
+	for _, element := range settings.Elasticsearch {
+		topology = append(topology, elasticCloudTopology(element))
+	}
+	resources := map[string]interface{}{
+		"elasticsearch": []interface{}{map[string]interface{}{
+			"region": settings.Region,
+			"ref_id": elasticCloudEsRefId,
+			"plan": map[string]interface{}{
+				"cluster_topology":    topology,
+				"elasticsearch":       map[string]interface{}{"version": settings.ElasticsearchVersion},
+				"deployment_template": map[string]interface{}{"id": settings.DeploymentTemplateId},
+			},
+		}},
+	}
+	dependent := func(kind string, topology ElasticCloudTopology) []interface{} {
+		return []interface{}{map[string]interface{}{
+			"region":                       settings.Region,
+			"ref_id":                       "main-" + kind,
+			"elasticsearch_cluster_ref_id": elasticCloudEsRefId,
+			"plan": map[string]interface{}{
+				"cluster_topology": []interface{}{elasticCloudTopology(topology)},
+				kind:               map[string]interface{}{"version": settings.ElasticsearchVersion},
+			},
+		}}
+	}
+	if settings.Kibana != nil {
+		resources["kibana"] = dependent("kibana", *settings.Kibana)
+	}
+	if settings.Apm != nil && elasticCloudMajor(settings.ElasticsearchVersion) >= 8 {
+		resources["integrations_server"] = dependent("integrations_server", *settings.Apm)
+	} else if settings.Apm != nil {
+		resources["apm"] = dependent("apm", *settings.Apm)
+	}
+	return resources
+}
+
+type ElasticCloudProvider struct {
+	Provider
+	namePrefix string
+}
+
+func NewElasticCloudProvider(namePrefix string) (*ElasticCloudProvider, error) {
+	return &ElasticCloudProvider{namePrefix: namePrefix}, nil
+}
+
+func (provider ElasticCloudProvider) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), domainRequestTimeout())
+}
+
+func (provider ElasticCloudProvider) CreateRandomName() string {
+	id, _ := uuid.NewV4()
+	return provider.namePrefix + "-" + (strings.Split(id.String(), "-")[0])
+}
+
+// deployment finds the deployment with the name, Elastic Cloud does not require names to be
+// unique but the broker never reuses one.
+func (provider ElasticCloudProvider) deployment(ctx context.Context, apiKey string, name string) (*ElasticCloudDeployment, error) {
+	var list struct {
+		Deployments []struct {
+			Id   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"deployments"`
+	}
+	if err := elasticCloudRequest(ctx, apiKey, "GET", "/api/v1/deployments", nil, &list); err != nil {
+		return nil, err
+	}
+	for _, d := range list.Deployments {
+		if d.Name == name {
+			var deployment ElasticCloudDeployment
+			if err := elasticCloudRequest(ctx, apiKey, "GET", "/api/v1/deployments/"+d.Id+"?show_metadata=true&show_plans=true", nil, &deployment); err != nil {
+				return nil, err
+			}
+			return &deployment, nil
+		}
+	}
+	return nil, &ElasticCloudError{StatusCode: http.StatusNotFound, Codes: []string{"deployments.deployment_not_found"}, Message: "The deployment " + name + " does not exist."}
+}
+
+func (provider ElasticCloudProvider) instance(deployment *ElasticCloudDeployment, plan *ProviderPlan) *Instance {
+	endpoint := ""
+	if es := deployment.elasticsearch(); es != nil {
+		endpoint = strings.TrimPrefix(es.url(), "https://")
+	}
+	status := deployment.status()
+	return &Instance{
+		Name:          deployment.Name,
+		ProviderId:    deployment.Id,
+		Plan:          plan,
+		Endpoint:      endpoint,
+		Status:        status,
+		Ready:         status == "available" || status == "processing",
+		Engine:        "elasticsearch",
+		EngineVersion: deployment.engineVersion(),
+		Scheme:        "https",
+	}
+}
+
+func (provider ElasticCloudProvider) GetInstance(name string, plan *ProviderPlan) (*Instance, error) {
+	settings, err := elasticCloudPlanSettings(plan, NewPlanTemplateData("", name, ""))
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := provider.context()
+	defer cancel()
+	deployment, err := provider.deployment(ctx, settings.ApiKey, name)
+	if err != nil {
+		return nil, err
+	}
+	return provider.instance(deployment, plan), nil
+}
+
+func (provider ElasticCloudProvider) Provision(Id string, name string, plan *ProviderPlan, Owner string) (*Instance, error) {
+	settings, err := elasticCloudPlanSettings(plan, NewPlanTemplateData(Id, name, Owner))
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := provider.context()
+	defer cancel()
+	if _, err = provider.deployment(ctx, settings.ApiKey, name); err == nil {
+		return nil, &NameCollisionError{Name: name}
+	} else if !isElasticCloudNotFound(err) {
+		return nil, err
+	}
+	var res struct {
+		Id        string `json:"id"`
+		Resources []struct {
+			Kind        string `json:"kind"`
+			Credentials *struct {
+				Username string `json:"username"`
+				Password string `json:"password"`
+			} `json:"credentials"`
+		} `json:"resources"`
+	}
+	err = elasticCloudRequest(ctx, settings.ApiKey, "POST", "/api/v1/deployments", map[string]interface{}{
+		"name":      name,
+		"resources": deploymentResources(settings),
+		"metadata":  map[string]interface{}{"tags": []elasticCloudTag{{Key: "billingcode", Value: Owner}}},
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	instance := &Instance{
+		Id:            Id,
+		Name:          name,
+		ProviderId:    res.Id,
+		Plan:          plan,
+		Status:        "creating",
+		Engine:        "elasticsearch",
+		EngineVersion: settings.ElasticsearchVersion,
+		Scheme:        "https",
+		Owner:         Owner,
+	}
+	// The password of the elastic user is only given when the deployment is created.
+	for _, resource := range res.Resources {
+		if resource.Kind == "elasticsearch" && resource.Credentials != nil {
+			instance.Username = resource.Credentials.Username
+			instance.Password = resource.Credentials.Password
+		}
+	}
+	return instance, nil
+}
+
+func (provider ElasticCloudProvider) Deprovision(Instance *Instance, takeSnapshot bool) error {
+	settings, err := elasticCloudPlanSettings(Instance.Plan, NewPlanTemplateData(Instance.Id, Instance.Name, Instance.Owner))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := provider.context()
+	defer cancel()
+	deployment, err := provider.deployment(ctx, settings.ApiKey, Instance.Name)
+	if err != nil {
+		return err
+	}
+	return elasticCloudRequest(ctx, settings.ApiKey, "POST", "/api/v1/deployments/"+deployment.Id+"/_shutdown", nil, nil)
+}
+
+// IsDeleted is true once the deployment has shut down, it is deleted then (Elastic Cloud
+// keeps shut down deployments otherwise).
+func (provider ElasticCloudProvider) IsDeleted(Instance *Instance) (bool, error) {
+	settings, err := elasticCloudPlanSettings(Instance.Plan, NewPlanTemplateData(Instance.Id, Instance.Name, Instance.Owner))
+	if err != nil {
+		return false, err
+	}
+	ctx, cancel := provider.context()
+	defer cancel()
+	deployment, err := provider.deployment(ctx, settings.ApiKey, Instance.Name)
+	if err != nil && isElasticCloudNotFound(err) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	if es := deployment.elasticsearch(); es != nil && es.Info.Status != "stopped" {
+		return false, nil
+	}
+	err = elasticCloudRequest(ctx, settings.ApiKey, "DELETE", "/api/v1/deployments/"+deployment.Id, nil, nil)
+	if err != nil && !isElasticCloudNotFound(err) {
+		return false, err
+	}
+	return true, nil
+}
+
+// Modify applies the plan to the deployment, Elastic Cloud changes (or upgrades) it in place,
+// a maintenance update is the same plan change with the version of the plan.
+func (provider ElasticCloudProvider) Modify(instance *Instance, plan *ProviderPlan, maintenance bool) (*Instance, error) {
+	settings, err := elasticCloudPlanSettings(plan, NewPlanTemplateData(instance.Id, instance.Name, instance.Owner))
+	if err != nil {
+		return nil, err
+	}
+	if engineVersionOlder(settings.ElasticsearchVersion, instance.EngineVersion) {
+		return nil, errors.New("Unable to change " + instance.Name + " to Elasticsearch " + settings.ElasticsearchVersion + ", it runs " + instance.EngineVersion + " and Elastic Cloud can't downgrade a deployment.")
+	}
+	ctx, cancel := provider.context()
+	defer cancel()
+	deployment, err := provider.deployment(ctx, settings.ApiKey, instance.Name)
+	if err != nil {
+		return nil, err
+	}
+	err = elasticCloudRequest(ctx, settings.ApiKey, "PUT", "/api/v1/deployments/"+deployment.Id, map[string]interface{}{
+		"name":          instance.Name,
+		"prune_orphans": true,
+		"resources":     deploymentResources(settings),
+		"metadata":      map[string]interface{}{"tags": deployment.Metadata.Tags},
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if deployment, err = provider.deployment(ctx, settings.ApiKey, instance.Name); err != nil {
+		return nil, err
+	}
+	modified := provider.instance(deployment, plan)
+	modified.Id = instance.Id
+	// The deployments api only returns the credentials when a deployment is created.
+	modified.Username = instance.Username
+	modified.Password = instance.Password
+	return modified, nil
+}
+
+// setTags replaces the tags of the deployment with those changed by change.
+func (provider ElasticCloudProvider) setTags(instance *Instance, change func(tags []elasticCloudTag) []elasticCloudTag) error {
+	settings, err := elasticCloudPlanSettings(instance.Plan, NewPlanTemplateData(instance.Id, instance.Name, instance.Owner))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := provider.context()
+	defer cancel()
+	deployment, err := provider.deployment(ctx, settings.ApiKey, instance.Name)
+	if err != nil {
+		return err
+	}
+	return elasticCloudRequest(ctx, settings.ApiKey, "PUT", "/api/v1/deployments/"+deployment.Id, map[string]interface{}{
+		"prune_orphans": false,
+		"metadata":      map[string]interface{}{"tags": change(deployment.Metadata.Tags)},
+	}, nil)
+}
+
+func (provider ElasticCloudProvider) Tag(Instance *Instance, Name string, Value string) error {
+	return provider.setTags(Instance, func(tags []elasticCloudTag) []elasticCloudTag {
+		changed := []elasticCloudTag{{Key: Name, Value: Value}}
+		for _, tag := range tags {
+			if tag.Key != Name {
+				changed = append(changed, tag)
+			}
+		}
+		return changed
+	})
+}
+
+func (provider ElasticCloudProvider) Untag(Instance *Instance, Name string) error {
+	return provider.setTags(Instance, func(tags []elasticCloudTag) []elasticCloudTag {
+		changed := make([]elasticCloudTag, 0)
+		for _, tag := range tags {
+			if tag.Key != Name {
+				changed = append(changed, tag)
+			}
+		}
+		return changed
+	})
+}
+
+// PerformPostProvision has nothing to do, deployments are tagged when they are created.
+func (provider ElasticCloudProvider) PerformPostProvision(db *Instance) (*Instance, error) {
+	return db, nil
+}
+
+// GetUrl returns the urls of the deployment, Kibana and APM are separate endpoints on Elastic
+// Cloud so they are looked up (and left out if that fails).
+func (provider ElasticCloudProvider) GetUrl(instance *Instance) map[string]interface{} {
+	urls := map[string]interface{}{
+		"ES_URL": instance.Scheme + "://" + instance.Endpoint,
+	}
+	settings, err := elasticCloudPlanSettings(instance.Plan, NewPlanTemplateData(instance.Id, instance.Name, instance.Owner))
+	if err != nil {
+		glog.Errorf("Unable to get the Elastic Cloud settings of %s: %s\n", instance.Name, err.Error())
+		return urls
+	}
+	ctx, cancel := provider.context()
+	defer cancel()
+	deployment, err := provider.deployment(ctx, settings.ApiKey, instance.Name)
+	if err != nil {
+		glog.Errorf("Unable to get the Elastic Cloud deployment %s: %s\n", instance.Name, err.Error())
+		return urls
+	}
+	if es := deployment.elasticsearch(); es != nil && es.Info.Metadata.CloudId != "" {
+		urls["CLOUD_ID"] = es.Info.Metadata.CloudId
+	}
+	if kibana := deployment.kibana(); kibana != nil && kibana.url() != "" {
+		urls["KIBANA_URL"] = kibana.url()
+	}
+	if apm := deployment.apm(); apm != nil && apm.url() != "" {
+		urls["APM_URL"] = apm.url()
+	}
+	return urls
+}
+
+// GetNetwork describes the public endpoint of the deployment, Elastic Cloud deployments are
+// not placed in the vpcs of the broker.
+func (provider ElasticCloudProvider) GetNetwork(instance *Instance) (*NetworkInfo, error) {
+	return &NetworkInfo{
+		SubnetIds:         []string{},
+		SecurityGroupIds:  []string{},
+		AvailabilityZones: []string{},
+		Endpoint:          instance.Endpoint,
+		Ports:             []int{443, 9243},
+		Protocol:          "tcp",
+		Public:            true,
+	}, nil
+}
+
+// GetConfig returns the current plans of the resources of the deployment.
+func (provider ElasticCloudProvider) GetConfig(instance *Instance) (map[string]string, error) {
+	settings, err := elasticCloudPlanSettings(instance.Plan, NewPlanTemplateData(instance.Id, instance.Name, instance.Owner))
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := provider.context()
+	defer cancel()
+	deployment, err := provider.deployment(ctx, settings.ApiKey, instance.Name)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]interface{})
+	for kind, resources := range map[string][]ElasticCloudResource{
+		"Elasticsearch":      deployment.Resources.Elasticsearch,
+		"Kibana":             deployment.Resources.Kibana,
+		"Apm":                deployment.Resources.Apm,
+		"IntegrationsServer": deployment.Resources.IntegrationsServer,
+	} {
+		if len(resources) > 0 && resources[0].Info.PlanInfo.Current != nil {
+			fields[kind] = resources[0].Info.PlanInfo.Current.Plan
+		}
+	}
+	config := make(map[string]string)
+	flattenConfig("", fields, config)
+	return config, nil
+}
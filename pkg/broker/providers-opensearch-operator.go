@@ -0,0 +1,510 @@
+package broker
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/nu7hatch/gouuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The opensearch-operator provider creates OpenSearchCluster resources for the
+// opensearch-k8s-operator, so self-hosted OpenSearch plans can be offered where managed
+// domains are too expensive. Every instance gets a namespace of its own (named after the
+// instance) that holds the cluster, its secrets and optionally a network policy, deleting the
+// namespace deletes everything of the instance. The broker generates the password of the
+// admin user, keeps it in a secret of the namespace and hands the operator its hash as the
+// internal users of the security config. The cluster is the one the broker runs in, or the one
+// of the kubeconfig in OPENSEARCH_KUBECONFIG, the operator must already be installed.
+
+const (
+	openSearchOperatorApi = "/apis/opensearch.opster.io/v1"
+	openSearchAdminUser   = "admin"
+)
+
+// OpenSearchOperatorSettings are the provider private details of opensearch-operator plans.
+type OpenSearchOperatorSettings struct {
+	// The OpenSearch version, named as for the other providers.
+	ElasticsearchVersion string `json:"ElasticsearchVersion"`
+	// The spec.nodePools of the OpenSearchCluster, e.g., [{"component":"nodes","replicas":3,
+	// "diskSize":"30Gi","roles":["cluster_manager","data"]}].
+	NodePools []map[string]interface{} `json:"NodePools"`
+	// The number of OpenSearch Dashboards instances, none if 0.
+	DashboardsReplicas int64 `json:"DashboardsReplicas"`
+	// Labels of the namespaces of the instances (e.g., their pod security level).
+	NamespaceLabels map[string]string `json:"NamespaceLabels"`
+	// Namespace label selectors the cluster may be reached from, if any are given a network
+	// policy only lets the namespace itself, the operator and these namespaces in.
+	AllowFrom []map[string]string `json:"AllowFrom"`
+}
+
+// ParseOpenSearchOperatorSettings reads the settings of a plan from its rendered details.
+func ParseOpenSearchOperatorSettings(details []byte) (*OpenSearchOperatorSettings, error) {
+	var settings OpenSearchOperatorSettings
+	if err := json.Unmarshal(details, &settings); err != nil {
+		return nil, err
+	}
+	if settings.ElasticsearchVersion == "" {
+		return nil, invalidPlan("ElasticsearchVersion", "the opensearch version is required")
+	}
+	if len(settings.NodePools) == 0 {
+		return nil, invalidPlan("NodePools", "at least one node pool is required")
+	}
+	for _, pool := range settings.NodePools {
+		if component, ok := pool["component"].(string); !ok || component == "" {
+			return nil, invalidPlan("NodePools", "every node pool needs a component name")
+		}
+		if _, ok := pool["replicas"].(float64); !ok {
+			return nil, invalidPlan("NodePools", "every node pool needs a number of replicas")
+		}
+	}
+	if settings.DashboardsReplicas < 0 {
+		return nil, invalidPlan("DashboardsReplicas", "the number of dashboards can't be negative, not %d", settings.DashboardsReplicas)
+	}
+	return &settings, nil
+}
+
+func openSearchOperatorPlanSettings(plan *ProviderPlan, data PlanTemplateData) (*OpenSearchOperatorSettings, error) {
+	details, err := plan.RenderPrivateDetails(data)
+	if err != nil {
+		return nil, err
+	}
+	return ParseOpenSearchOperatorSettings(details)
+}
+
+// validateOpenSearchOperatorPlan checks the provider private details of an opensearch-operator plan.
+func validateOpenSearchOperatorPlan(plan *ProviderPlan) error {
+	_, err := openSearchOperatorPlanSettings(plan, NewPlanTemplateData("00000000-0000-0000-0000-000000000000", "validation", ""))
+	return err
+}
+
+// openSearchOperatorNamespace is the namespace the operator runs in, the network policies of
+// the instances let it in.
+func openSearchOperatorNamespace() string {
+	if os.Getenv("OPENSEARCH_OPERATOR_NAMESPACE") != "" {
+		return os.Getenv("OPENSEARCH_OPERATOR_NAMESPACE")
+	}
+	return "opensearch-operator-system"
+}
+
+// openSearchClusterResource is the part of an OpenSearchCluster the broker reads.
+type openSearchClusterResource struct {
+	Metadata struct {
+		Name              string            `json:"name"`
+		Uid               string            `json:"uid"`
+		DeletionTimestamp string            `json:"deletionTimestamp"`
+		Annotations       map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Spec   map[string]interface{} `json:"spec"`
+	Status struct {
+		Phase            string `json:"phase"`
+		Health           string `json:"health"`
+		Initialized      bool   `json:"initialized"`
+		AvailableNodes   int64  `json:"availableNodes"`
+		Version          string `json:"version"`
+		ComponentsStatus []struct {
+			Component string `json:"component"`
+			Status    string `json:"status"`
+		} `json:"componentsStatus"`
+	} `json:"status"`
+}
+
+func (resource *openSearchClusterResource) specVersion() string {
+	general, _ := resource.Spec["general"].(map[string]interface{})
+	version, _ := general["version"].(string)
+	return version
+}
+
+// status maps the phase, health and the components the operator is running (upgrades,
+// rolling restarts and scaling) to the status of the instance, operators before 2.5 report
+// no health, their running clusters are available.
+func (resource *openSearchClusterResource) status() string {
+	if resource.Metadata.DeletionTimestamp != "" {
+		return "deleting"
+	}
+	if !resource.Status.Initialized || resource.Status.Phase == "PENDING" || resource.Status.Phase == "" {
+		return "creating"
+	}
+	status := ""
+	for _, component := range resource.Status.ComponentsStatus {
+		if component.Status == "Finished" || component.Status == "" {
+			continue
+		}
+		if component.Component == "Upgrader" {
+			return "upgrading"
+		}
+		status = "processing"
+	}
+	if status != "" {
+		return status
+	}
+	switch resource.Status.Health {
+	case "red":
+		return "unavailable"
+	case "green", "yellow", "":
+		return "available"
+	}
+	return "unknown"
+}
+
+func (resource *openSearchClusterResource) ready() bool {
+	return resource.Metadata.DeletionTimestamp == "" && resource.Status.Initialized && resource.Status.Health != "red"
+}
+
+func openSearchAdminSecret(name string) string {
+	return name + "-admin-credentials"
+}
+
+func openSearchSecurityConfigSecret(name string) string {
+	return name + "-security-config"
+}
+
+// openSearchInternalUsers is the internal_users.yml of the security config of a cluster, with
+// only the admin user.
+func openSearchInternalUsers(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return "_meta:\n" +
+		"  type: \"internalusers\"\n" +
+		"  config_version: 2\n" +
+		openSearchAdminUser + ":\n" +
+		"  hash: \"" + string(hash) + "\"\n" +
+		"  reserved: true\n" +
+		"  backend_roles:\n" +
+		"  - \"admin\"\n", nil
+}
+
+// openSearchClusterSpec is the spec of the OpenSearchCluster of the settings.
+func openSearchClusterSpec(name string, settings *OpenSearchOperatorSettings) map[string]interface{} {
+	return map[string]interface{}{
+		"general": map[string]interface{}{
+			"serviceName":      name,
+			"version":          settings.ElasticsearchVersion,
+			"httpPort":         9200,
+			"setVMMaxMapCount": true,
+		},
+		"security": map[string]interface{}{
+			"config": map[string]interface{}{
+				"adminCredentialsSecret": map[string]interface{}{"name": openSearchAdminSecret(name)},
+				"securityConfigSecret":   map[string]interface{}{"name": openSearchSecurityConfigSecret(name)},
+			},
+			"tls": map[string]interface{}{
+				"transport": map[string]interface{}{"generate": true, "perNode": true},
+				"http":      map[string]interface{}{"generate": true},
+			},
+		},
+		"nodePools": settings.NodePools,
+		"dashboards": map[string]interface{}{
+			"enable":                      settings.DashboardsReplicas > 0,
+			"version":                     settings.ElasticsearchVersion,
+			"replicas":                    settings.DashboardsReplicas,
+			"tls":                         map[string]interface{}{"enable": true, "generate": true},
+			"opensearchCredentialsSecret": map[string]interface{}{"name": openSearchAdminSecret(name)},
+		},
+	}
+}
+
+func openSearchNamespaceLabels(settings *OpenSearchOperatorSettings) map[string]string {
+	labels := map[string]string{"app.kubernetes.io/managed-by": "elasticsearch-broker"}
+	for key, value := range settings.NamespaceLabels {
+		labels[key] = value
+	}
+	return labels
+}
+
+// openSearchNetworkPolicy only lets the namespace of the instance, the operator and the
+// namespaces the plan allows reach the cluster.
+func openSearchNetworkPolicy(name string, settings *OpenSearchOperatorSettings) map[string]interface{} {
+	from := []interface{}{
+		map[string]interface{}{"podSelector": map[string]interface{}{}},
+		map[string]interface{}{"namespaceSelector": map[string]interface{}{"matchLabels": map[string]string{"kubernetes.io/metadata.name": openSearchOperatorNamespace()}}},
+	}
+	for _, selector := range settings.AllowFrom {
+		from = append(from, map[string]interface{}{"namespaceSelector": map[string]interface{}{"matchLabels": selector}})
+	}
+	return map[string]interface{}{
+		"apiVersion": "networking.k8s.io/v1",
+		"kind":       "NetworkPolicy",
+		"metadata":   map[string]interface{}{"name": "broker-isolation", "namespace": name},
+		"spec": map[string]interface{}{
+			"podSelector": map[string]interface{}{},
+			"policyTypes": []string{"Ingress"},
+			"ingress":     []interface{}{map[string]interface{}{"from": from}},
+		},
+	}
+}
+
+type OpenSearchOperatorProvider struct {
+	Provider
+	*kubernetesClient
+	namePrefix string
+}
+
+func NewOpenSearchOperatorProvider(namePrefix string) (*OpenSearchOperatorProvider, error) {
+	client, err := newKubernetesClient(os.Getenv("OPENSEARCH_KUBECONFIG"))
+	if err != nil {
+		return nil, errors.New("Unable to find the kubernetes cluster of the opensearch-operator provider: " + err.Error())
+	}
+	return &OpenSearchOperatorProvider{kubernetesClient: client, namePrefix: namePrefix}, nil
+}
+
+func (provider OpenSearchOperatorProvider) cluster(name string) (*openSearchClusterResource, error) {
+	var resource openSearchClusterResource
+	if err := provider.request("GET", kubernetesPath(openSearchOperatorApi, "opensearchclusters", name, name), "", nil, &resource); err != nil {
+		return nil, err
+	}
+	return &resource, nil
+}
+
+func (provider OpenSearchOperatorProvider) instance(resource *openSearchClusterResource, plan *ProviderPlan) *Instance {
+	version := resource.Status.Version
+	if version == "" {
+		version = resource.specVersion()
+	}
+	return &Instance{
+		Name:          resource.Metadata.Name,
+		ProviderId:    resource.Metadata.Uid,
+		Plan:          plan,
+		Endpoint:      resource.Metadata.Name + "." + resource.Metadata.Name + ".svc:9200",
+		Status:        resource.status(),
+		Ready:         resource.ready(),
+		Engine:        "opensearch",
+		EngineVersion: version,
+		Scheme:        "https",
+	}
+}
+
+// applyNetworkPolicy creates, changes or removes the network policy of the namespace.
+func (provider OpenSearchOperatorProvider) applyNetworkPolicy(name string, settings *OpenSearchOperatorSettings) error {
+	path := kubernetesPath("/apis/networking.k8s.io/v1", "networkpolicies", name, "broker-isolation")
+	if len(settings.AllowFrom) == 0 {
+		if err := provider.request("DELETE", path, "", nil, nil); err != nil && !isKubernetesError(err, "NotFound") {
+			return err
+		}
+		return nil
+	}
+	policy := openSearchNetworkPolicy(name, settings)
+	err := provider.request("PATCH", path, "application/merge-patch+json", map[string]interface{}{"spec": policy["spec"]}, nil)
+	if err != nil && isKubernetesError(err, "NotFound") {
+		return provider.request("POST", kubernetesPath("/apis/networking.k8s.io/v1", "networkpolicies", name, ""), "application/json", policy, nil)
+	}
+	return err
+}
+
+func (provider OpenSearchOperatorProvider) CreateRandomName() string {
+	id, _ := uuid.NewV4()
+	return provider.namePrefix + "-" + (strings.Split(id.String(), "-")[0])
+}
+
+func (provider OpenSearchOperatorProvider) GetInstance(name string, plan *ProviderPlan) (*Instance, error) {
+	resource, err := provider.cluster(name)
+	if err != nil {
+		return nil, err
+	}
+	return provider.instance(resource, plan), nil
+}
+
+// Provision creates the namespace of the instance, the secrets with its admin credentials and
+// security config, its network policy and then the cluster.
+func (provider OpenSearchOperatorProvider) Provision(Id string, name string, plan *ProviderPlan, Owner string) (*Instance, error) {
+	settings, err := openSearchOperatorPlanSettings(plan, NewPlanTemplateData(Id, name, Owner))
+	if err != nil {
+		return nil, err
+	}
+	err = provider.request("POST", "/api/v1/namespaces", "application/json", map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Namespace",
+		"metadata": map[string]interface{}{
+			"name":        name,
+			"labels":      openSearchNamespaceLabels(settings),
+			"annotations": map[string]string{kubernetesAnnotation("billingcode"): Owner},
+		},
+	}, nil)
+	if err != nil && isKubernetesError(err, "AlreadyExists") {
+		return nil, &NameCollisionError{Name: name}
+	} else if err != nil {
+		return nil, err
+	}
+	password, err := bindingPassword()
+	if err != nil {
+		return nil, err
+	}
+	internalUsers, err := openSearchInternalUsers(password)
+	if err != nil {
+		return nil, err
+	}
+	secrets := map[string]map[string]string{
+		openSearchAdminSecret(name):          {"username": openSearchAdminUser, "password": password},
+		openSearchSecurityConfigSecret(name): {"internal_users.yml": internalUsers},
+	}
+	for secret, data := range secrets {
+		err = provider.request("POST", kubernetesPath("/api/v1", "secrets", name, ""), "application/json", map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata":   map[string]interface{}{"name": secret, "namespace": name},
+			"type":       "Opaque",
+			"stringData": data,
+		}, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err = provider.applyNetworkPolicy(name, settings); err != nil {
+		return nil, err
+	}
+	var resource openSearchClusterResource
+	err = provider.request("POST", kubernetesPath(openSearchOperatorApi, "opensearchclusters", name, ""), "application/json", map[string]interface{}{
+		"apiVersion": "opensearch.opster.io/v1",
+		"kind":       "OpenSearchCluster",
+		"metadata": map[string]interface{}{
+			"name":        name,
+			"namespace":   name,
+			"annotations": map[string]string{kubernetesAnnotation("billingcode"): Owner},
+		},
+		"spec": openSearchClusterSpec(name, settings),
+	}, &resource)
+	if err != nil {
+		return nil, err
+	}
+	instance := provider.instance(&resource, plan)
+	instance.Id = Id
+	instance.Owner = Owner
+	return instance, nil
+}
+
+// Deprovision deletes the namespace of the instance, kubernetes deletes the cluster, its
+// volumes and secrets with it.
+func (provider OpenSearchOperatorProvider) Deprovision(Instance *Instance, takeSnapshot bool) error {
+	return provider.request("DELETE", "/api/v1/namespaces/"+Instance.Name, "", nil, nil)
+}
+
+func (provider OpenSearchOperatorProvider) IsDeleted(Instance *Instance) (bool, error) {
+	err := provider.request("GET", "/api/v1/namespaces/"+Instance.Name, "", nil, nil)
+	if err != nil && isKubernetesError(err, "NotFound") {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// Modify applies the plan to the cluster and its namespace, the operator rolls the change
+// (or upgrade) through the node pools itself.
+func (provider OpenSearchOperatorProvider) Modify(instance *Instance, plan *ProviderPlan, maintenance bool) (*Instance, error) {
+	settings, err := openSearchOperatorPlanSettings(plan, NewPlanTemplateData(instance.Id, instance.Name, instance.Owner))
+	if err != nil {
+		return nil, err
+	}
+	if engineVersionOlder(settings.ElasticsearchVersion, instance.EngineVersion) {
+		return nil, errors.New("Unable to change " + instance.Name + " to OpenSearch " + settings.ElasticsearchVersion + ", it runs " + instance.EngineVersion + " and the operator can't downgrade a cluster.")
+	}
+	err = provider.request("PATCH", "/api/v1/namespaces/"+instance.Name, "application/merge-patch+json", map[string]interface{}{
+		"metadata": map[string]interface{}{"labels": openSearchNamespaceLabels(settings)},
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = provider.applyNetworkPolicy(instance.Name, settings); err != nil {
+		return nil, err
+	}
+	var resource openSearchClusterResource
+	err = provider.request("PATCH", kubernetesPath(openSearchOperatorApi, "opensearchclusters", instance.Name, instance.Name), "application/merge-patch+json", map[string]interface{}{
+		"spec": openSearchClusterSpec(instance.Name, settings),
+	}, &resource)
+	if err != nil {
+		return nil, err
+	}
+	modified := provider.instance(&resource, plan)
+	modified.Id = instance.Id
+	return modified, nil
+}
+
+func (provider OpenSearchOperatorProvider) annotate(instance *Instance, name string, value interface{}) error {
+	return provider.request("PATCH", kubernetesPath(openSearchOperatorApi, "opensearchclusters", instance.Name, instance.Name), "application/merge-patch+json", map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": map[string]interface{}{kubernetesAnnotation(name): value}},
+	}, nil)
+}
+
+func (provider OpenSearchOperatorProvider) Tag(Instance *Instance, Name string, Value string) error {
+	return provider.annotate(Instance, Name, Value)
+}
+
+// Untag removes the annotation, a merge patch removes fields set to null.
+func (provider OpenSearchOperatorProvider) Untag(Instance *Instance, Name string) error {
+	return provider.annotate(Instance, Name, nil)
+}
+
+// PerformPostProvision reads the admin credentials from the secret in the namespace of the
+// instance.
+func (provider OpenSearchOperatorProvider) PerformPostProvision(db *Instance) (*Instance, error) {
+	var secret struct {
+		Data map[string]string `json:"data"`
+	}
+	if err := provider.request("GET", kubernetesPath("/api/v1", "secrets", db.Name, openSearchAdminSecret(db.Name)), "", nil, &secret); err != nil {
+		return nil, err
+	}
+	username, err := base64.StdEncoding.DecodeString(secret.Data["username"])
+	if err != nil || len(username) == 0 {
+		return nil, errors.New("Unable to read the admin username of " + db.Name + ".")
+	}
+	password, err := base64.StdEncoding.DecodeString(secret.Data["password"])
+	if err != nil || len(password) == 0 {
+		return nil, errors.New("Unable to read the admin password of " + db.Name + ".")
+	}
+	db.Username = string(username)
+	db.Password = string(password)
+	return db, nil
+}
+
+func (provider OpenSearchOperatorProvider) GetUrl(instance *Instance) map[string]interface{} {
+	urls := map[string]interface{}{
+		"ES_URL": instance.Scheme + "://" + instance.Endpoint,
+	}
+	settings, err := openSearchOperatorPlanSettings(instance.Plan, NewPlanTemplateData(instance.Id, instance.Name, instance.Owner))
+	if err != nil {
+		glog.Errorf("Unable to get the opensearch-operator settings of %s: %s\n", instance.Name, err.Error())
+		return urls
+	}
+	if settings.DashboardsReplicas > 0 {
+		urls["KIBANA_URL"] = "https://" + instance.Name + "-dashboards." + instance.Name + ".svc:5601"
+	}
+	return urls
+}
+
+// GetNetwork describes the services of the cluster, they are only reachable inside kubernetes.
+func (provider OpenSearchOperatorProvider) GetNetwork(instance *Instance) (*NetworkInfo, error) {
+	settings, err := openSearchOperatorPlanSettings(instance.Plan, NewPlanTemplateData(instance.Id, instance.Name, instance.Owner))
+	if err != nil {
+		return nil, err
+	}
+	network := &NetworkInfo{
+		SubnetIds:         []string{},
+		SecurityGroupIds:  []string{},
+		AvailabilityZones: []string{},
+		Endpoint:          instance.Endpoint,
+		Ports:             []int{9200},
+		Protocol:          "tcp",
+		Public:            false,
+	}
+	if settings.DashboardsReplicas > 0 {
+		network.Ports = append(network.Ports, 5601)
+	}
+	return network, nil
+}
+
+// GetConfig returns the spec of the OpenSearchCluster.
+func (provider OpenSearchOperatorProvider) GetConfig(instance *Instance) (map[string]string, error) {
+	resource, err := provider.cluster(instance.Name)
+	if err != nil {
+		return nil, err
+	}
+	config := make(map[string]string)
+	flattenConfig("", resource.Spec, config)
+	return config, nil
+}
@@ -0,0 +1,335 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/nu7hatch/gouuid"
+)
+
+// With BROKER_MODE=simulate every provider call is served by the simulated provider instead
+// of aws, so the platform can be integration tested against the broker (and its task worker)
+// without creating any aws resources. Simulated domains are kept in the broker database so
+// the broker and the worker see the same domains, they go through the statuses a domain
+// would (creating, processing, upgrading, deleting) taking about as long as aws does, scaled
+// with SIMULATE_TIME_SCALE (e.g., 0.01 provisions in seconds instead of minutes). Each domain
+// gets a stable endpoint derived from its name that never resolves, calls to the cluster
+// itself (hooks, metrics, the kibana proxy) are not simulated.
+
+const simulatedEndpointSuffix = ".es.simulated.invalid"
+
+const (
+	simulatedCreate  = "create"
+	simulatedModify  = "modify"
+	simulatedUpgrade = "upgrade"
+	simulatedDelete  = "delete"
+)
+
+// simulatedDurations are how long (in seconds) each operation takes on aws, give or take a
+// quarter depending on the domain.
+var simulatedDurations = map[string]float64{
+	simulatedCreate:  600,
+	simulatedModify:  600,
+	simulatedUpgrade: 1200,
+	simulatedDelete:  300,
+}
+
+// simulatedStorage keeps the simulated domains, it is set when the storage is initialized.
+var simulatedStorage Storage
+
+// SimulatedDomain is a domain of the simulated provider, Operation is the last operation
+// started on it (at Changed).
+type SimulatedDomain struct {
+	Name          string
+	Plan          string
+	EngineVersion string
+	Tags          map[string]string
+	Operation     string
+	Changed       time.Time
+	Created       time.Time
+}
+
+// SimulationMode is true if the broker runs with BROKER_MODE=simulate.
+func SimulationMode() bool {
+	return os.Getenv("BROKER_MODE") == "simulate"
+}
+
+func simulatedTimeScale() float64 {
+	if scale, err := strconv.ParseFloat(os.Getenv("SIMULATE_TIME_SCALE"), 64); err == nil && scale >= 0 {
+		return scale
+	}
+	return 1
+}
+
+// simulatedDuration is how long the operation takes on the domain, the same domain always
+// takes the same time.
+func simulatedDuration(name string, operation string) time.Duration {
+	h := fnv.New32a()
+	h.Write([]byte(name + "/" + operation))
+	jitter := 0.75 + float64(h.Sum32()%500)/1000
+	return time.Duration(simulatedDurations[operation] * jitter * simulatedTimeScale() * float64(time.Second))
+}
+
+// simulatedLatency waits as long as an aws api call would take.
+func simulatedLatency() {
+	time.Sleep(time.Duration((100 + rand.Float64()*300) * simulatedTimeScale() * float64(time.Millisecond)))
+}
+
+// simulatedEndpoint is the endpoint of a simulated domain, it never resolves.
+func simulatedEndpoint(name string) string {
+	return "search-" + name + simulatedEndpointSuffix
+}
+
+// done is whether the last operation on the domain has finished.
+func (domain *SimulatedDomain) done() bool {
+	return time.Now().Sub(domain.Changed) >= simulatedDuration(domain.Name, domain.Operation)
+}
+
+func (domain *SimulatedDomain) status() string {
+	if domain.done() {
+		return "available"
+	}
+	switch domain.Operation {
+	case simulatedCreate:
+		return "creating"
+	case simulatedUpgrade:
+		return "upgrading"
+	case simulatedDelete:
+		return "deleting"
+	}
+	return "processing"
+}
+
+type SimulatedProvider struct {
+	Provider
+	namePrefix string
+	storage    Storage
+}
+
+func NewSimulatedProvider(namePrefix string) (*SimulatedProvider, error) {
+	if simulatedStorage == nil {
+		return nil, errors.New("Unable to simulate the provider, the storage has not been initialized.")
+	}
+	return &SimulatedProvider{namePrefix: namePrefix, storage: simulatedStorage}, nil
+}
+
+func (provider SimulatedProvider) CreateRandomName() string {
+	id, _ := uuid.NewV4()
+	return provider.namePrefix + "-" + (strings.Split(id.String(), "-")[0])
+}
+
+// domain returns the simulated domain with the name, an error if there is none (or it has
+// been deleted).
+func (provider SimulatedProvider) domain(name string) (*SimulatedDomain, error) {
+	simulatedLatency()
+	domain, err := provider.storage.GetSimulatedDomain(name)
+	if err != nil {
+		return nil, err
+	}
+	if domain == nil || (domain.Operation == simulatedDelete && domain.done()) {
+		return nil, errors.New("ResourceNotFoundException: Domain not found: " + name)
+	}
+	return domain, nil
+}
+
+func (provider SimulatedProvider) instance(domain *SimulatedDomain, plan *ProviderPlan) *Instance {
+	engine := "opensearch"
+	if plan.Provider == AWSESInstance {
+		engine = "elasticsearch"
+	}
+	status := domain.status()
+	return &Instance{
+		Name:          domain.Name,
+		ProviderId:    "arn:aws:es:simulated:000000000000:domain/" + domain.Name,
+		Plan:          plan,
+		Endpoint:      simulatedEndpoint(domain.Name),
+		Status:        status,
+		Ready:         status == "available" || status == "processing",
+		Engine:        engine,
+		EngineVersion: domain.EngineVersion,
+		Scheme:        "https",
+	}
+}
+
+func (provider SimulatedProvider) GetInstance(name string, plan *ProviderPlan) (*Instance, error) {
+	domain, err := provider.domain(name)
+	if err != nil {
+		return nil, err
+	}
+	return provider.instance(domain, plan), nil
+}
+
+func (provider SimulatedProvider) Provision(Id string, name string, plan *ProviderPlan, Owner string) (*Instance, error) {
+	simulatedLatency()
+	existing, err := provider.storage.GetSimulatedDomain(name)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, &NameCollisionError{Name: name}
+	}
+	domain := &SimulatedDomain{
+		Name:          name,
+		Plan:          plan.ID,
+		EngineVersion: plan.EngineVersion(),
+		Tags:          map[string]string{"billingcode": Owner},
+		Operation:     simulatedCreate,
+		Changed:       time.Now(),
+		Created:       time.Now(),
+	}
+	if err = provider.storage.AddSimulatedDomain(domain); err != nil {
+		return nil, err
+	}
+	glog.Infof("Simulating the provision of %s (%s), it takes %s\n", name, plan.ID, simulatedDuration(name, simulatedCreate).String())
+	instance := provider.instance(domain, plan)
+	instance.Id = Id
+	instance.Owner = Owner
+	return instance, nil
+}
+
+func (provider SimulatedProvider) Deprovision(Instance *Instance, takeSnapshot bool) error {
+	domain, err := provider.domain(Instance.Name)
+	if err != nil {
+		return err
+	}
+	domain.Operation = simulatedDelete
+	domain.Changed = time.Now()
+	return provider.storage.UpdateSimulatedDomain(domain)
+}
+
+// IsDeleted is true once the simulated deletion has finished, the domain is forgotten then.
+func (provider SimulatedProvider) IsDeleted(Instance *Instance) (bool, error) {
+	simulatedLatency()
+	domain, err := provider.storage.GetSimulatedDomain(Instance.Name)
+	if err != nil {
+		return false, err
+	}
+	if domain == nil {
+		return true, nil
+	}
+	if domain.Operation != simulatedDelete || !domain.done() {
+		return false, nil
+	}
+	if err = provider.storage.DeleteSimulatedDomain(Instance.Name); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Modify starts a simulated configuration change, or an upgrade if a maintenance update
+// changes the engine version, as with the aws provider a domain can't be downgraded.
+func (provider SimulatedProvider) Modify(instance *Instance, plan *ProviderPlan, maintenance bool) (*Instance, error) {
+	domain, err := provider.domain(instance.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !domain.done() {
+		return nil, errors.New("Unable to change " + instance.Name + ", its " + domain.Operation + " is still in progress.")
+	}
+	version := plan.EngineVersion()
+	if engineVersionOlder(version, domain.EngineVersion) {
+		return nil, errors.New("Unable to change " + instance.Name + " to " + version + ", it runs " + domain.EngineVersion + " and aws can't downgrade a domain.")
+	}
+	domain.Operation = simulatedModify
+	if maintenance && version != "" && version != domain.EngineVersion {
+		domain.Operation = simulatedUpgrade
+		domain.EngineVersion = version
+	}
+	domain.Plan = plan.ID
+	domain.Changed = time.Now()
+	if err = provider.storage.UpdateSimulatedDomain(domain); err != nil {
+		return nil, err
+	}
+	modified := provider.instance(domain, plan)
+	modified.Id = instance.Id
+	return modified, nil
+}
+
+func (provider SimulatedProvider) Tag(Instance *Instance, Name string, Value string) error {
+	domain, err := provider.domain(Instance.Name)
+	if err != nil {
+		return err
+	}
+	domain.Tags[Name] = Value
+	return provider.storage.UpdateSimulatedDomain(domain)
+}
+
+func (provider SimulatedProvider) Untag(Instance *Instance, Name string) error {
+	domain, err := provider.domain(Instance.Name)
+	if err != nil {
+		return err
+	}
+	delete(domain.Tags, Name)
+	return provider.storage.UpdateSimulatedDomain(domain)
+}
+
+func (provider SimulatedProvider) PerformPostProvision(db *Instance) (*Instance, error) {
+	return db, nil
+}
+
+func (provider SimulatedProvider) GetUrl(instance *Instance) map[string]interface{} {
+	return map[string]interface{}{
+		"KIBANA_URL": instance.Scheme + "://" + instance.Endpoint + dashboardsPath(instance.Plan),
+		"ES_URL":     instance.Scheme + "://" + instance.Endpoint,
+	}
+}
+
+// GetNetwork describes the network the plan would place the domain in, without vpc options
+// the domain is public.
+func (provider SimulatedProvider) GetNetwork(instance *Instance) (*NetworkInfo, error) {
+	network := &NetworkInfo{
+		SubnetIds:         []string{},
+		SecurityGroupIds:  []string{},
+		AvailabilityZones: []string{},
+		Endpoint:          instance.Endpoint,
+		Ports:             []int{443},
+		Protocol:          "tcp",
+		Public:            true,
+	}
+	if !instance.Plan.Provider.IsAWSDomain() {
+		return network, nil
+	}
+	settings, err := planDomainInput(instance.Plan)
+	if err != nil {
+		return nil, err
+	}
+	if settings.VPCOptions != nil {
+		network.VpcId = "vpc-simulated"
+		network.SubnetIds = configuredSubnets()
+		network.SecurityGroupIds = strings.Split(os.Getenv("AWS_SECURITY_GROUP_ID"), ",")
+		network.Public = false
+	}
+	return network, nil
+}
+
+// GetConfig returns the settings of the plan of the domain, as aws would report them.
+func (provider SimulatedProvider) GetConfig(instance *Instance) (map[string]string, error) {
+	domain, err := provider.domain(instance.Name)
+	if err != nil {
+		return nil, err
+	}
+	details, err := instance.Plan.RenderPrivateDetails(NewPlanTemplateData(instance.Id, instance.Name, instance.Owner))
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err = json.Unmarshal(details, &fields); err != nil {
+		return nil, err
+	}
+	for _, field := range instanceConfigFields {
+		delete(fields, field)
+	}
+	if domain.EngineVersion != "" {
+		fields["ElasticsearchVersion"] = domain.EngineVersion
+	}
+	config := make(map[string]string)
+	flattenConfig("", fields, config)
+	return config, nil
+}
@@ -1,20 +1,51 @@
 package broker
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
 )
 
 type Providers string
 
 const (
 	AWSESInstance   		Providers = "aws-es"
+	AWSOpenSearchInstance	Providers = "aws-opensearch"
+	AWSServerlessInstance	Providers = "aws-opensearch-serverless"
+	ElasticCloudInstance	Providers = "elastic-cloud"
+	ECKInstance				Providers = "eck"
+	OpenSearchOperatorInstance	Providers = "opensearch-operator"
+	DockerInstance			Providers = "docker"
 	Unknown        			Providers = "unknown"
 )
 
+// IsAWSDomain is true for the providers of aws domains, their plans share the shape of the
+// provider private details.
+func (p Providers) IsAWSDomain() bool {
+	return p == AWSESInstance || p == AWSOpenSearchInstance
+}
+
 func GetProvidersFromString(str string) Providers {
 	if str == "aws-es" {
 		return AWSESInstance
+	} else if str == "aws-opensearch" {
+		return AWSOpenSearchInstance
+	} else if str == "aws-opensearch-serverless" {
+		return AWSServerlessInstance
+	} else if str == "elastic-cloud" {
+		return ElasticCloudInstance
+	} else if str == "eck" {
+		return ECKInstance
+	} else if str == "opensearch-operator" {
+		return OpenSearchOperatorInstance
+	} else if str == "docker" {
+		return DockerInstance
 	}
 	return Unknown
 }
@@ -25,22 +56,120 @@ type ProviderPlan struct {
 	providerPrivateDetails string    `json:"-"` /* NEVER allow this to be serialized into a JSON call as it may accidently send sensitive info to callbacks */
 	ID                     string    `json:"id"`
 	Scheme                 string    `json:"scheme"`
+	ConfigVarNames         map[string]string `json:"config_var_names,omitempty"`
+	Logging                *LoggingTier      `json:"logging,omitempty"`
+	// Instances of plans with a ttl are deleted once it passes unless renewed.
+	TTL                    time.Duration     `json:"-"`
+	// The json schemas of the parameters, published in the catalog.
+	Schemas                *PlanSchemas      `json:"schemas,omitempty"`
+	// Bindings of plans with a binding ttl expire (and their credentials are revoked) once it passes.
+	BindingTTL             time.Duration     `json:"-"`
+	// Retired plans are not in the catalog and can't be provisioned.
+	Retired                bool              `json:"-"`
+	// Deprecated plans are not in the catalog either, provisions are pointed to the plan to migrate to.
+	Deprecated             bool              `json:"-"`
+	MigrateTo              string            `json:"-"`
+	// The provision and update parameters the plan accepts, all the broker understands if nil.
+	ParameterPolicy        *ParameterPolicy  `json:"-"`
+}
+
+// PlanTemplateData holds the values available to templates in a plans
+// provider private details, e.g. {{.Region}} or {{env "AWS_KMS_KEY_ID"}}. The details
+// are json, the values are escaped to be used in its strings.
+type PlanTemplateData struct {
+	Region       string
+	Owner        string
+	OwnerSlug    string
+	InstanceId   string
+	InstanceName string
+}
+
+var slugInvalidChars = regexp.MustCompile("[^a-z0-9-]+")
+
+// Slug converts a value into something that can safely be used in resource names and tags.
+func Slug(value string) string {
+	return strings.Trim(slugInvalidChars.ReplaceAllString(strings.ToLower(value), "-"), "-")
+}
+
+func NewPlanTemplateData(Id string, Name string, Owner string) PlanTemplateData {
+	return PlanTemplateData{
+		Region:       os.Getenv("AWS_REGION"),
+		Owner:        Owner,
+		OwnerSlug:    Slug(Owner),
+		InstanceId:   Id,
+		InstanceName: Name,
+	}
+}
+
+// jsonEscape escapes a value to be used in a json string, so a value (e.g., an owner with
+// a quote) can't change the structure of the document it is rendered into.
+func jsonEscape(value string) string {
+	data, _ := json.Marshal(value)
+	return string(data[1 : len(data)-1])
+}
+
+// RenderPrivateDetails resolves the template variables and secret references in the provider
+// private details at provision (or modify) time, so one plan can serve multiple regions or accounts.
+func (plan *ProviderPlan) RenderPrivateDetails(data PlanTemplateData) ([]byte, error) {
+	env := func(name string) string {
+		return jsonEscape(os.Getenv(name))
+	}
+	t, err := template.New(plan.ID).Option("missingkey=error").Funcs(template.FuncMap{"env": env}).Parse(plan.providerPrivateDetails)
+	if err != nil {
+		return nil, err
+	}
+	escaped := PlanTemplateData{
+		Region:       jsonEscape(data.Region),
+		Owner:        jsonEscape(data.Owner),
+		OwnerSlug:    jsonEscape(data.OwnerSlug),
+		InstanceId:   jsonEscape(data.InstanceId),
+		InstanceName: jsonEscape(data.InstanceName),
+	}
+	var b bytes.Buffer
+	if err = t.Execute(&b, escaped); err != nil {
+		return nil, err
+	}
+	return ResolveSecrets(b.Bytes())
 }
 
 type Provider interface {
 	GetInstance(string, *ProviderPlan) (*Instance, error)
-	Provision(string, *ProviderPlan, string) (*Instance, error)
+	// CreateRandomName returns the name of a new domain, it is recorded before the domain is
+	// created with Provision so it can be cleaned up if provisioning fails.
+	CreateRandomName() string
+	Provision(string, string, *ProviderPlan, string) (*Instance, error)
 	Deprovision(*Instance, bool) error
-	Modify(*Instance, *ProviderPlan) (*Instance, error)
+	IsDeleted(*Instance) (bool, error)
+	// Modify changes the plan (or parameters) of an instance, a maintenance update upgrades
+	// its engine to the version of the plan instead.
+	Modify(*Instance, *ProviderPlan, bool) (*Instance, error)
 	Tag(*Instance, string, string) error
 	Untag(*Instance, string) error
 	PerformPostProvision(*Instance) (*Instance, error)
 	GetUrl(*Instance) map[string]interface{}
+	GetNetwork(*Instance) (*NetworkInfo, error)
+	// GetConfig returns the configuration of the domain as flattened keys (e.g.,
+	// ElasticsearchClusterConfig.InstanceType), without what differs between any two domains.
+	GetConfig(*Instance) (map[string]string, error)
 }
 
 func GetProviderByPlan(namePrefix string, plan *ProviderPlan) (Provider, error) {
-	if plan.Provider == AWSESInstance {
+	if SimulationMode() {
+		return NewSimulatedProvider(namePrefix)
+	} else if plan.Provider == AWSESInstance {
 		return NewAWSInstanceESProvider(namePrefix)
+	} else if plan.Provider == AWSOpenSearchInstance {
+		return NewAWSOpenSearchProvider(namePrefix)
+	} else if plan.Provider == AWSServerlessInstance {
+		return NewAWSServerlessProvider(namePrefix)
+	} else if plan.Provider == ElasticCloudInstance {
+		return NewElasticCloudProvider(namePrefix)
+	} else if plan.Provider == ECKInstance {
+		return NewECKProvider(namePrefix)
+	} else if plan.Provider == OpenSearchOperatorInstance {
+		return NewOpenSearchOperatorProvider(namePrefix)
+	} else if plan.Provider == DockerInstance {
+		return NewDockerProvider(namePrefix)
 	} else {
 		return nil, errors.New("Unable to find provider for plan.")
 	}
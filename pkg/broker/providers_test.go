@@ -0,0 +1,31 @@
+package broker
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func TestRenderPrivateDetailsEscapesValues(t *testing.T) {
+	os.Setenv("PLAN_TEST_TAG", `a "quoted" tag`)
+	defer os.Unsetenv("PLAN_TEST_TAG")
+	plan := &ProviderPlan{ID: "plan", providerPrivateDetails: `{"Owner":"{{.Owner}}","Slug":"{{.OwnerSlug}}","Name":"{{.InstanceName}}","Tag":"{{env "PLAN_TEST_TAG"}}"}`}
+	owner := `team", "Injected": "true`
+	details, err := plan.RenderPrivateDetails(NewPlanTemplateData("id", `logs\1`, owner))
+	if err != nil {
+		t.Fatalf("RenderPrivateDetails() failed: %s", err.Error())
+	}
+	var rendered map[string]string
+	if err = json.Unmarshal(details, &rendered); err != nil {
+		t.Fatalf("RenderPrivateDetails() = %s is not valid json: %s", string(details), err.Error())
+	}
+	want := map[string]string{"Owner": owner, "Slug": "team-injected-true", "Name": `logs\1`, "Tag": `a "quoted" tag`}
+	if len(rendered) != len(want) {
+		t.Errorf("RenderPrivateDetails() = %v, want %v", rendered, want)
+	}
+	for key, value := range want {
+		if rendered[key] != value {
+			t.Errorf("RenderPrivateDetails() %s = %q, want %q", key, rendered[key], value)
+		}
+	}
+}
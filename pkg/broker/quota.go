@@ -0,0 +1,91 @@
+package broker
+
+import (
+	"os"
+	"strconv"
+
+	"github.com/golang/glog"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// Owners (organizations) can be limited to a number of instances, OWNER_INSTANCE_QUOTA is the
+// quota of every owner (unset or 0 is unlimited) and the owner_quotas table overrides it per
+// owner. Provisions past the quota are rejected, and once an owner reaches
+// QUOTA_WARNING_PERCENT (default 80) of it provisions are still accepted but warn the owner
+// so the rejection is not a surprise.
+
+type QuotaWarning struct {
+	Owner     string `json:"owner"`
+	Instances int    `json:"instances"`
+	Quota     int    `json:"quota"`
+	Percent   int    `json:"percent"`
+	// The instance whose provision reached the warning threshold.
+	InstanceId string `json:"instance_id,omitempty"`
+	Name       string `json:"name,omitempty"`
+}
+
+func (w *QuotaWarning) Message() string {
+	return w.Owner + " has " + strconv.Itoa(w.Instances) + " of its quota of " + strconv.Itoa(w.Quota) + " instances (" + strconv.Itoa(w.Percent) + "%), provisions are rejected once the quota is reached."
+}
+
+func ownerQuota(storage Storage, owner string) (int, error) {
+	quota, err := storage.GetOwnerQuota(owner)
+	if err != nil {
+		return 0, err
+	}
+	if quota < 0 {
+		quota = envInt("OWNER_INSTANCE_QUOTA", 0)
+	}
+	return quota, nil
+}
+
+// checkQuota rejects provisioning another instance for the owner if it would exceed its
+// quota, it returns the warning to send once the provision succeeds if the owner reaches the
+// warning threshold (nil otherwise).
+func (b *BusinessLogic) checkQuota(owner string) (*QuotaWarning, error) {
+	if owner == "" {
+		return nil, nil
+	}
+	quota, err := ownerQuota(b.storage, owner)
+	if err != nil {
+		glog.Errorf("Unable to get the quota of %s: %s\n", owner, err.Error())
+		return nil, InternalServerError()
+	}
+	if quota <= 0 {
+		return nil, nil
+	}
+	count, err := b.storage.CountOwnerInstances(owner)
+	if err != nil {
+		glog.Errorf("Unable to count the instances of %s: %s\n", owner, err.Error())
+		return nil, InternalServerError()
+	}
+	if count >= quota {
+		return nil, UnprocessableEntityWithMessage("QuotaExceeded", "The quota of "+strconv.Itoa(quota)+" instances has been reached, deprovision an instance or ask an operator to raise the quota.")
+	}
+	percent := (count + 1) * 100 / quota
+	if percent < envInt("QUOTA_WARNING_PERCENT", 80) {
+		return nil, nil
+	}
+	return &QuotaWarning{Owner: owner, Instances: count + 1, Quota: quota, Percent: percent}, nil
+}
+
+// sendQuotaWarning adds a Warning header to the provision response, and posts the warning to
+// QUOTA_WEBHOOK (signed with QUOTA_WEBHOOK_SECRET) and emails the contacts of the instance.
+func (b *BusinessLogic) sendQuotaWarning(warning *QuotaWarning, instance *Instance, c *broker.RequestContext) {
+	warning.InstanceId = instance.Id
+	warning.Name = instance.Name
+	glog.Infof("Warning: %s\n", warning.Message())
+	if c != nil && c.Writer != nil {
+		c.Writer.Header().Add("Warning", "299 - "+strconv.Quote(warning.Message()))
+	}
+	go (func() {
+		if url := os.Getenv("QUOTA_WEBHOOK"); url != "" {
+			if err := Notify(b.storage, QuotaWarningNotification, url, os.Getenv("QUOTA_WEBHOOK_SECRET"), warning); err != nil {
+				glog.Errorf("Unable to send the quota warning for %s: %s\n", warning.Owner, err.Error())
+			}
+		}
+		if err := EmailContacts(b.storage, QuotaWarningNotification, instance.Id, warning); err != nil {
+			glog.Errorf("Unable to email the contacts of %s: %s\n", instance.Name, err.Error())
+		}
+	})()
+}
@@ -0,0 +1,100 @@
+package broker
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Reconciler periodically compares every instance with its provider, records any
+// status or endpoint changes made outside of the broker and refreshes the instance metrics.
+type Reconciler struct {
+	namePrefix string
+	storage    Storage
+	metrics    *InstanceMetrics
+	interval   time.Duration
+}
+
+func NewReconciler(namePrefix string, storage Storage, metrics *InstanceMetrics) *Reconciler {
+	interval := time.Minute * 5
+	if os.Getenv("RECONCILE_INTERVAL") != "" {
+		if seconds, err := strconv.Atoi(os.Getenv("RECONCILE_INTERVAL")); err == nil && seconds > 0 {
+			interval = time.Second * time.Duration(seconds)
+		} else {
+			glog.Errorf("Invalid RECONCILE_INTERVAL, using the default of %s\n", interval.String())
+		}
+	}
+	return &Reconciler{
+		namePrefix: namePrefix,
+		storage:    storage,
+		metrics:    metrics,
+		interval:   interval,
+	}
+}
+
+func (r *Reconciler) Reconcile() error {
+	entries, err := r.storage.GetInstances()
+	if err != nil {
+		glog.Errorf("Reconciler unable to get instances: %s\n", err.Error())
+		return err
+	}
+	supports, err := r.storage.GetEngineSupports()
+	if err != nil {
+		glog.Errorf("Reconciler unable to get engine versions: %s\n", err.Error())
+	}
+	now := time.Now()
+	counts := newInstanceCounts()
+	for _, entry := range entries {
+		plan := entry.PlanId
+		engine := "unknown"
+		version := "unknown"
+		status := entry.Status
+		instance, err := GetInstanceById(r.namePrefix, r.storage, entry.Id)
+		if err != nil {
+			glog.Errorf("Reconciler unable to get instance %s (%s): %s\n", entry.Id, entry.Name, err.Error())
+		} else {
+			plan = instance.Plan.basePlan.Name
+			engine = instance.Engine
+			version = instance.EngineVersion
+			status = instance.Status
+			if instance.Status != entry.Status || instance.Endpoint != entry.Endpoint {
+				glog.Infof("Reconciler found %s (%s) changed at the provider, status: %s -> %s\n", entry.Id, entry.Name, entry.Status, instance.Status)
+				if DryRun(ReconcileAction) {
+					ReportDryRun(r.storage, ReconcileAction, entry.Id, "Would update the status from "+entry.Status+" to "+instance.Status+" and the endpoint from "+entry.Endpoint+" to "+instance.Endpoint)
+				} else if err = r.storage.UpdateInstance(instance, instance.Plan.ID); err != nil {
+					glog.Errorf("Reconciler unable to update instance %s: %s\n", entry.Id, err.Error())
+				}
+			}
+		}
+		counts.add(plan, status, engine, version, entry.Owner, GetEngineSupportStatus(supports, engine, version, now).Status)
+		// avoid hitting the providers api rate limits
+		time.Sleep(time.Millisecond * 250)
+	}
+	if r.metrics != nil {
+		r.metrics.set(counts)
+	}
+	DeleteOrphanedDomains(r.namePrefix, r.storage)
+	return nil
+}
+
+func (r *Reconciler) Run(ctx context.Context) {
+	t := time.NewTicker(r.interval)
+	defer t.Stop()
+	for {
+		RecordHeartbeat(r.storage, ReconcilerLoop, r.interval, r.Reconcile(), 0)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// RunReconciler starts reconciling instances (and refreshing the metrics of NewServer) in
+// the background.
+func (b *BusinessLogic) RunReconciler(ctx context.Context) {
+	go NewReconciler(b.namePrefix, b.storage, b.metrics).Run(ctx)
+}
@@ -0,0 +1,218 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// Once a node passes the flood stage watermark elasticsearch sets read_only_allow_delete
+// on its indices, older versions never remove it even after space is freed or the
+// volumes are expanded. The default high watermark, a node must be under it before
+// the block is cleared so the cluster does not immediately block writes again.
+const defaultRemediationWatermark = 90
+
+type ReadOnlyRemediation struct {
+	Enabled bool `json:"enabled"`
+}
+
+type RemediationReport struct {
+	InstanceId  string   `json:"instance_id"`
+	Name        string   `json:"name"`
+	Owner       string   `json:"owner"`
+	Indices     []string `json:"indices"`
+	DiskPercent int      `json:"disk_percent"`
+}
+
+// readOnlyIndices returns the indices blocked with read_only_allow_delete.
+func readOnlyIndices(cluster *ClusterClient, instance *Instance) ([]string, error) {
+	response, status, err := cluster.Do(instance, "GET", "/_all/_settings/index.blocks.read_only_allow_delete?format=json", nil)
+	if err != nil {
+		return nil, err
+	}
+	if status != 200 {
+		return nil, errors.New("_settings returned " + strconv.Itoa(status))
+	}
+	var settings map[string]struct {
+		Settings struct {
+			Index struct {
+				Blocks struct {
+					ReadOnlyAllowDelete string `json:"read_only_allow_delete"`
+				} `json:"blocks"`
+			} `json:"index"`
+		} `json:"settings"`
+	}
+	if err = json.Unmarshal(response, &settings); err != nil {
+		return nil, err
+	}
+	indices := make([]string, 0)
+	for index, s := range settings {
+		if s.Settings.Index.Blocks.ReadOnlyAllowDelete == "true" {
+			indices = append(indices, index)
+		}
+	}
+	sort.Strings(indices)
+	return indices, nil
+}
+
+// maxDiskPercent returns the disk usage of the fullest node.
+func maxDiskPercent(cluster *ClusterClient, instance *Instance) (int, error) {
+	response, status, err := cluster.Do(instance, "GET", "/_cat/allocation?format=json&h=disk.percent", nil)
+	if err != nil {
+		return 0, err
+	}
+	if status != 200 {
+		return 0, errors.New("_cat/allocation returned " + strconv.Itoa(status))
+	}
+	var rows []map[string]string
+	if err = json.Unmarshal(response, &rows); err != nil {
+		return 0, err
+	}
+	max := 0
+	for _, row := range rows {
+		if percent, err := strconv.Atoi(row["disk.percent"]); err == nil && percent > max {
+			max = percent
+		}
+	}
+	return max, nil
+}
+
+// RemediateReadOnly clears the read-only block on every blocked index once every node
+// is under the high watermark, it returns the indices that were (or in a dry run would
+// have been) cleared.
+func RemediateReadOnly(cluster *ClusterClient, instance *Instance, dryRun bool) (*RemediationReport, error) {
+	indices, err := readOnlyIndices(cluster, instance)
+	if err != nil {
+		return nil, err
+	}
+	report := &RemediationReport{InstanceId: instance.Id, Name: instance.Name, Owner: instance.Owner, Indices: indices}
+	if len(indices) == 0 {
+		return report, nil
+	}
+	if report.DiskPercent, err = maxDiskPercent(cluster, instance); err != nil {
+		return nil, err
+	}
+	if watermark := envInt("REMEDIATION_WATERMARK", defaultRemediationWatermark); report.DiskPercent >= watermark {
+		return nil, errors.New("A node is still " + strconv.Itoa(report.DiskPercent) + "% full, the storage must be expanded (or data removed) to under " + strconv.Itoa(watermark) + "% before the read-only block is cleared")
+	}
+	if dryRun {
+		return report, nil
+	}
+	body := []byte("{\"index.blocks.read_only_allow_delete\":null}")
+	response, status, err := cluster.Do(instance, "PUT", "/"+strings.Join(indices, ",")+"/_settings", body)
+	if err != nil {
+		return nil, err
+	}
+	if status != 200 {
+		return nil, errors.New("Clearing the read-only block returned " + strconv.Itoa(status) + ": " + string(response))
+	}
+	return report, nil
+}
+
+// CheckReadOnlyIndices schedules a remediation for any opted in instance with read-only
+// indices, it is ran by the metrics collector.
+func CheckReadOnlyIndices(namePrefix string, storage Storage, cluster *ClusterClient) {
+	ids, err := storage.GetReadOnlyRemediationInstances()
+	if err != nil {
+		glog.Errorf("Unable to get instances opted into read-only remediation: %s\n", err.Error())
+		return
+	}
+	for _, id := range ids {
+		instance, err := GetInstanceById(namePrefix, storage, id)
+		if err != nil {
+			glog.Errorf("Unable to check %s for read-only indices: %s\n", id, err.Error())
+			continue
+		}
+		if !IsAvailable(instance.Status) {
+			continue
+		}
+		indices, err := readOnlyIndices(cluster, instance)
+		if err != nil {
+			glog.Errorf("Unable to check %s for read-only indices: %s\n", instance.Name, err.Error())
+			continue
+		}
+		if len(indices) == 0 {
+			continue
+		}
+		if task, err := storage.GetLastTask(instance.Id, RemediateReadOnlyTask); err == nil && (task.Status == "pending" || task.Status == "started") {
+			continue
+		}
+		glog.Infof("Found %d read-only indices on %s, scheduling remediation\n", len(indices), instance.Name)
+		if _, err = storage.AddTask(instance.Id, RemediateReadOnlyTask, ""); err != nil {
+			glog.Errorf("Error: Unable to schedule read-only remediation for %s: %s\n", instance.Name, err.Error())
+		}
+	}
+}
+
+func RunRemediateReadOnlyTaskFromQueue(storage Storage, namePrefix string, cluster *ClusterClient, task *Task) {
+	// give up after an hour, the collector schedules it again if the indices are still blocked
+	if task.Retries >= 60 {
+		FinishedTask(storage, task.Id, task.Retries, "Unable to clear the read-only block ("+task.Result+")", "failed")
+		return
+	}
+	instance, err := GetInstanceById(namePrefix, storage, task.ResourceId)
+	if err != nil {
+		UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot get Instance: "+err.Error(), "pending")
+		return
+	}
+	dryRun := DryRun(RemediateReadOnlyAction)
+	report, err := RemediateReadOnly(cluster, instance, dryRun)
+	if err != nil {
+		UpdateTaskStatus(storage, task.Id, task.Retries+1, err.Error(), "pending")
+		return
+	}
+	if len(report.Indices) == 0 {
+		FinishedTask(storage, task.Id, task.Retries, "No indices are read-only", "finished")
+		return
+	}
+	if dryRun {
+		result := "Would clear the read-only block on " + strings.Join(report.Indices, ", ")
+		ReportDryRun(storage, RemediateReadOnlyAction, instance.Id, result)
+		FinishedTask(storage, task.Id, task.Retries, "Dry run: "+result, "finished")
+		return
+	}
+	result := "Cleared the read-only block on " + strings.Join(report.Indices, ", ")
+	glog.Infof("%s for %s\n", result, instance.Name)
+	if url := os.Getenv("REMEDIATION_WEBHOOK"); url != "" {
+		if err = Notify(storage, ReadOnlyRemediationNotification, url, os.Getenv("REMEDIATION_WEBHOOK_SECRET"), report); err != nil {
+			glog.Errorf("Unable to send the remediation report for %s: %s\n", instance.Name, err.Error())
+		}
+	}
+	FinishedTask(storage, task.Id, task.Retries, result, "finished")
+}
+
+func (b *BusinessLogic) GetReadOnlyRemediationAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	enabled, err := b.storage.GetReadOnlyRemediation(InstanceID)
+	if err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Unable to get read-only remediation for %s: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	return ReadOnlyRemediation{Enabled: enabled}, nil
+}
+
+// PUT /v2/service_instances/{instance_id}/actions/read-only-remediation with {"enabled":true}
+func (b *BusinessLogic) SetReadOnlyRemediationAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	var request ReadOnlyRemediation
+	if c == nil || c.Request == nil || c.Request.Body == nil {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "The body must be {\"enabled\":true} or {\"enabled\":false}.")
+	}
+	if err := json.NewDecoder(c.Request.Body).Decode(&request); err != nil {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "The body must be {\"enabled\":true} or {\"enabled\":false}.")
+	}
+	err := b.storage.SetReadOnlyRemediation(InstanceID, request.Enabled)
+	if err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Unable to set read-only remediation for %s: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	return request, nil
+}
@@ -0,0 +1,558 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// A restore recovers a subset of indices from a snapshot, either into a clone (see
+// clone.go) or back into the instance the snapshot was taken of. Renaming the indices
+// as they are restored lets a single index be recovered next to the one it replaces
+// rather than having to close or delete it first. While the indices are recovered the
+// last operation reports how many shards and bytes have been restored, and a restore can
+// be throttled (before or while it runs) so it doesn't take the cluster from its traffic.
+
+// RestoreParameters select what is restored, the indices are names or patterns (e.g.,
+// orders-*) and the rename pattern and replacement are the elasticsearch _restore
+// rename_pattern and rename_replacement.
+type RestoreParameters struct {
+	Indices           []string `json:"indices"`
+	Snapshot          string   `json:"snapshot,omitempty"`
+	RenamePattern     string   `json:"rename_pattern,omitempty"`
+	RenameReplacement string   `json:"rename_replacement,omitempty"`
+}
+
+// RestoreThrottle limits how fast a restore recovers the indices, the recovery speed per
+// node (indices.recovery.max_bytes_per_sec, e.g., 20mb) and how many shards a node
+// recovers at once (cluster.routing.allocation.node_concurrent_recoveries). The settings
+// are set back to their previous values once the restore has finished.
+type RestoreThrottle struct {
+	MaxBytesPerSec       string `json:"max_bytes_per_sec,omitempty"`
+	ConcurrentRecoveries int    `json:"concurrent_recoveries,omitempty"`
+}
+
+var restoreThrottleSettings = []string{
+	"indices.recovery.max_bytes_per_sec",
+	"cluster.routing.allocation.node_concurrent_recoveries",
+}
+
+var restoreBytesPattern = regexp.MustCompile(`^[1-9][0-9]*(b|kb|mb|gb)$`)
+
+func (t *RestoreThrottle) Validate() error {
+	if t.MaxBytesPerSec == "" && t.ConcurrentRecoveries == 0 {
+		return errors.New("The max_bytes_per_sec or concurrent_recoveries of the throttle must be given.")
+	}
+	if t.MaxBytesPerSec != "" && !restoreBytesPattern.MatchString(t.MaxBytesPerSec) {
+		return errors.New("The max_bytes_per_sec must be a size such as 20mb.")
+	}
+	if t.ConcurrentRecoveries < 0 || t.ConcurrentRecoveries > 20 {
+		return errors.New("The concurrent_recoveries must be between 1 and 20.")
+	}
+	return nil
+}
+
+type RestoreRequest struct {
+	RestoreParameters
+	Repository string           `json:"repository,omitempty"`
+	Throttle   *RestoreThrottle `json:"throttle,omitempty"`
+	// Restoring without renaming the indices overwrites them, it must be confirmed (see
+	// PrepareAction) if confirmations are required.
+	ConfirmationToken string `json:"confirmation_token,omitempty"`
+}
+
+type RestoreTaskMetadata struct {
+	// The instance the snapshot was taken of, the instance restored into if it is a clone.
+	From string `json:"from"`
+	RestoreParameters
+	Repository string `json:"repository,omitempty"`
+	Restoring  bool   `json:"restoring,omitempty"`
+	// When the restore started, and the throttle of the restore with the values the
+	// throttled settings had before it.
+	Started          *time.Time        `json:"started,omitempty"`
+	Throttle         *RestoreThrottle  `json:"throttle,omitempty"`
+	PreviousSettings map[string]string `json:"previous_settings,omitempty"`
+}
+
+type RestoreProgress struct {
+	Shards          int   `json:"shards"`
+	ShardsRecovered int   `json:"shards_recovered"`
+	TotalBytes      int64 `json:"total_bytes"`
+	RecoveredBytes  int64 `json:"recovered_bytes"`
+	// The recoveries (of any index) still running, replicas of the restored indices are
+	// recovered from their primaries once those are restored.
+	Active int `json:"active"`
+}
+
+// Description is the result of a restore task while it runs, e.g., "12 of 40 shards
+// recovered from snapshot nightly-1, 1.2GB of 5.0GB (24%), expected to finish around
+// 15:04 UTC".
+func (p *RestoreProgress) Description(snapshot string, elapsed time.Duration, now time.Time) string {
+	desc := strconv.Itoa(p.ShardsRecovered) + " of " + strconv.Itoa(p.Shards) + " shards recovered from snapshot " + snapshot
+	if p.TotalBytes > 0 {
+		desc = desc + ", " + formatBytes(p.RecoveredBytes) + " of " + formatBytes(p.TotalBytes) + " (" + strconv.FormatInt(p.RecoveredBytes*100/p.TotalBytes, 10) + "%)"
+	}
+	if p.RecoveredBytes > 0 && p.RecoveredBytes < p.TotalBytes && elapsed > 0 {
+		remaining := time.Duration(float64(elapsed) * float64(p.TotalBytes-p.RecoveredBytes) / float64(p.RecoveredBytes))
+		desc = desc + ", expected to finish around " + now.Add(remaining).UTC().Format("15:04 MST")
+	} else if p.Shards > 0 && p.ShardsRecovered == p.Shards && p.Active > 0 {
+		desc = desc + ", waiting for " + strconv.Itoa(p.Active) + " replica recoveries"
+	}
+	return desc
+}
+
+// restoreProgress sums up the recoveries of the shards restored from the snapshot.
+func restoreProgress(cluster *ClusterClient, instance *Instance, repository string, snapshot string) (*RestoreProgress, error) {
+	response, status, err := cluster.Do(instance, "GET", "/_recovery", nil)
+	if err != nil {
+		return nil, err
+	}
+	if status != 200 {
+		return nil, errors.New("_recovery returned " + strconv.Itoa(status))
+	}
+	var recoveries map[string]struct {
+		Shards []struct {
+			Type   string `json:"type"`
+			Stage  string `json:"stage"`
+			Source struct {
+				Repository string `json:"repository"`
+				Snapshot   string `json:"snapshot"`
+			} `json:"source"`
+			Index struct {
+				Size struct {
+					TotalInBytes     int64 `json:"total_in_bytes"`
+					RecoveredInBytes int64 `json:"recovered_in_bytes"`
+					ReusedInBytes    int64 `json:"reused_in_bytes"`
+				} `json:"size"`
+			} `json:"index"`
+		} `json:"shards"`
+	}
+	if err = json.Unmarshal(response, &recoveries); err != nil {
+		return nil, err
+	}
+	progress := &RestoreProgress{}
+	for _, index := range recoveries {
+		for _, shard := range index.Shards {
+			if shard.Stage != "DONE" {
+				progress.Active++
+			}
+			if shard.Type != "SNAPSHOT" || shard.Source.Snapshot != snapshot || shard.Source.Repository != repository {
+				continue
+			}
+			progress.Shards++
+			progress.TotalBytes += shard.Index.Size.TotalInBytes
+			if shard.Stage == "DONE" {
+				progress.ShardsRecovered++
+				progress.RecoveredBytes += shard.Index.Size.TotalInBytes
+			} else {
+				progress.RecoveredBytes += shard.Index.Size.RecoveredInBytes + shard.Index.Size.ReusedInBytes
+			}
+		}
+	}
+	return progress, nil
+}
+
+// applyRestoreThrottle sets the throttle on the cluster, it returns the values the settings
+// had before (the given previous values if the restore is already throttled) and the status
+// of the _cluster/settings call.
+func applyRestoreThrottle(cluster *ClusterClient, instance *Instance, throttle *RestoreThrottle, previous map[string]string) (map[string]string, int, error) {
+	if previous == nil {
+		effective, err := GetEffectiveSettings(cluster, instance)
+		if err != nil {
+			return nil, 0, err
+		}
+		previous = make(map[string]string)
+		for _, setting := range restoreThrottleSettings {
+			previous[setting] = effective[setting]
+		}
+	}
+	settings := make(map[string]interface{})
+	if throttle.MaxBytesPerSec != "" {
+		settings["indices.recovery.max_bytes_per_sec"] = throttle.MaxBytesPerSec
+	}
+	if throttle.ConcurrentRecoveries != 0 {
+		settings["cluster.routing.allocation.node_concurrent_recoveries"] = throttle.ConcurrentRecoveries
+	}
+	body, err := json.Marshal(map[string]interface{}{"persistent": settings})
+	if err != nil {
+		return nil, 0, err
+	}
+	response, status, err := cluster.Do(instance, "PUT", "/_cluster/settings", body)
+	if err != nil {
+		return nil, 0, err
+	}
+	if status != 200 {
+		return nil, status, errors.New("Unable to throttle the restore, _cluster/settings returned " + strconv.Itoa(status) + ": " + string(response))
+	}
+	return previous, status, nil
+}
+
+// resetRestoreThrottle sets the throttled settings back to the values they had before the
+// restore.
+func resetRestoreThrottle(cluster *ClusterClient, instance *Instance, previous map[string]string) {
+	if previous == nil {
+		return
+	}
+	changes := make([]SettingChange, 0)
+	for _, setting := range restoreThrottleSettings {
+		changes = append(changes, SettingChange{Setting: setting, Previous: previous[setting]})
+	}
+	if err := revertSettings(cluster, instance, changes); err != nil {
+		glog.Errorf("Unable to reset the restore throttle of %s: %s\n", instance.Name, err.Error())
+	}
+}
+
+type snapshotInfo struct {
+	Snapshot          string   `json:"snapshot"`
+	State             string   `json:"state"`
+	Indices           []string `json:"indices"`
+	StartTimeInMillis int64    `json:"start_time_in_millis"`
+	EndTimeInMillis   int64    `json:"end_time_in_millis"`
+	DurationInMillis  int64    `json:"duration_in_millis"`
+}
+
+var restoreIndexPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.*\-]*$`)
+
+// restoreNamePattern is the names of repositories and snapshots a restore accepts, they are
+// part of the paths of the _snapshot api.
+var restoreNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.\-]{0,254}$`)
+
+func validateRestoreIndices(indices []string) error {
+	if len(indices) == 0 {
+		return errors.New("The indices to restore are required.")
+	}
+	for _, index := range indices {
+		if !restoreIndexPattern.MatchString(index) {
+			return errors.New("The index " + index + " is not valid, system indices cannot be restored.")
+		}
+	}
+	return nil
+}
+
+func (p *RestoreParameters) Validate() error {
+	if err := validateRestoreIndices(p.Indices); err != nil {
+		return err
+	}
+	if p.Snapshot != "" && !restoreNamePattern.MatchString(p.Snapshot) {
+		return errors.New("The snapshot " + p.Snapshot + " is not a valid snapshot name.")
+	}
+	if (p.RenamePattern == "") != (p.RenameReplacement == "") {
+		return errors.New("The rename_pattern and rename_replacement must be given together.")
+	}
+	if p.RenamePattern != "" {
+		if _, err := regexp.Compile(p.RenamePattern); err != nil {
+			return errors.New("The rename_pattern is not a valid regular expression: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// containsIndices is true if every pattern matches at least one of the indices.
+func containsIndices(indices []string, patterns []string) bool {
+	for _, pattern := range patterns {
+		found := false
+		for _, index := range indices {
+			if matched, _ := path.Match(pattern, index); matched {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// latestSnapshot returns the newest successful snapshot of the instance in the repository
+// that has all of the indices.
+func latestSnapshot(cluster *ClusterClient, instance *Instance, repository string, indices []string) (string, error) {
+	response, status, err := cluster.Do(instance, "GET", "/_snapshot/"+url.PathEscape(repository)+"/_all", nil)
+	if err != nil {
+		return "", err
+	}
+	if status != 200 {
+		return "", errors.New("Unable to list the snapshots of " + instance.Name + ", _snapshot returned " + strconv.Itoa(status))
+	}
+	var snapshots struct {
+		Snapshots []snapshotInfo `json:"snapshots"`
+	}
+	if err = json.Unmarshal(response, &snapshots); err != nil {
+		return "", err
+	}
+	var latest *snapshotInfo
+	for i, snapshot := range snapshots.Snapshots {
+		if snapshot.State != "SUCCESS" || !containsIndices(snapshot.Indices, indices) {
+			continue
+		}
+		if latest == nil || snapshot.EndTimeInMillis > latest.EndTimeInMillis {
+			latest = &snapshots.Snapshots[i]
+		}
+	}
+	if latest == nil {
+		return "", errors.New("No snapshot of " + instance.Name + " in " + repository + " has the indices " + strings.Join(indices, ", "))
+	}
+	return latest.Snapshot, nil
+}
+
+func restoreBody(p RestoreParameters) ([]byte, error) {
+	body := map[string]interface{}{"indices": strings.Join(p.Indices, ","), "include_global_state": false}
+	if p.RenamePattern != "" {
+		body["rename_pattern"] = p.RenamePattern
+		body["rename_replacement"] = p.RenameReplacement
+	}
+	return json.Marshal(body)
+}
+
+func RunRestoreTaskFromQueue(storage Storage, namePrefix string, cluster *ClusterClient, task *Task) {
+	var taskMetaData RestoreTaskMetadata
+	if err := json.Unmarshal([]byte(task.Metadata), &taskMetaData); err != nil {
+		FinishedTask(storage, task.Id, task.Retries, "Invalid restore task metadata", "failed")
+		return
+	}
+	// the instance has three hours to become available and be restored
+	if task.Retries >= 180 {
+		if instance, err := GetInstanceById(namePrefix, storage, task.ResourceId); err == nil {
+			resetRestoreThrottle(cluster, instance, taskMetaData.PreviousSettings)
+		}
+		FinishedTask(storage, task.Id, task.Retries, "Unable to restore the instance ("+task.Result+")", "failed")
+		return
+	}
+	if taskMetaData.From == "" {
+		taskMetaData.From = task.ResourceId
+	}
+	repository := taskMetaData.Repository
+	if repository == "" {
+		repository = cloneRepository()
+	}
+	if repository == "" {
+		FinishedTask(storage, task.Id, task.Retries, "No snapshot repository was given and CLONE_SNAPSHOT_REPOSITORY is not set", "failed")
+		return
+	}
+	instance, err := GetInstanceById(namePrefix, storage, task.ResourceId)
+	if err != nil {
+		UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot get Instance: "+err.Error(), "pending")
+		return
+	}
+	if !IsAvailable(instance.Status) {
+		UpdateTaskStatus(storage, task.Id, task.Retries+1, "Waiting for the instance to become available ("+instance.Status+")", "pending")
+		return
+	}
+	if !taskMetaData.Restoring {
+		source := instance
+		if taskMetaData.From != instance.Id {
+			if source, err = GetInstanceById(namePrefix, storage, taskMetaData.From); err != nil {
+				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot get the instance to restore from: "+err.Error(), "pending")
+				return
+			}
+			if err = registerCloneRepository(cluster, source, instance, repository); err != nil {
+				UpdateTaskStatus(storage, task.Id, task.Retries+1, err.Error(), "pending")
+				return
+			}
+		}
+		if taskMetaData.Snapshot == "" {
+			snapshot, err := latestSnapshot(cluster, source, repository, taskMetaData.Indices)
+			if err != nil {
+				UpdateTaskStatus(storage, task.Id, task.Retries+1, err.Error(), "pending")
+				return
+			}
+			taskMetaData.Snapshot = snapshot
+		}
+		body, err := restoreBody(taskMetaData.RestoreParameters)
+		if err != nil {
+			FinishedTask(storage, task.Id, task.Retries, "Cannot marshal the restore request: "+err.Error(), "failed")
+			return
+		}
+		if taskMetaData.Throttle != nil {
+			previous, status, err := applyRestoreThrottle(cluster, instance, taskMetaData.Throttle, taskMetaData.PreviousSettings)
+			if err != nil && status >= 400 && status < 500 {
+				// the cluster does not allow the settings
+				FinishedTask(storage, task.Id, task.Retries, err.Error(), "failed")
+				return
+			} else if err != nil {
+				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Unable to throttle the restore: "+err.Error(), "pending")
+				return
+			}
+			taskMetaData.PreviousSettings = previous
+		}
+		response, status, err := cluster.Do(instance, "POST", "/_snapshot/"+url.PathEscape(repository)+"/"+url.PathEscape(taskMetaData.Snapshot)+"/_restore", body)
+		if err != nil {
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "Unable to start the restore: "+err.Error(), "pending")
+			return
+		}
+		if status >= 400 && status < 500 {
+			// e.g., an open index with the same name, retrying will not help
+			resetRestoreThrottle(cluster, instance, taskMetaData.PreviousSettings)
+			FinishedTask(storage, task.Id, task.Retries, "Unable to restore, _restore returned "+strconv.Itoa(status)+": "+string(response), "failed")
+			return
+		}
+		if status != 200 {
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "Unable to start the restore, _restore returned "+strconv.Itoa(status), "pending")
+			return
+		}
+		glog.Infof("Restoring %s into %s from snapshot %s of %s\n", strings.Join(taskMetaData.Indices, ", "), instance.Name, taskMetaData.Snapshot, taskMetaData.From)
+		taskMetaData.Restoring = true
+		started := time.Now()
+		taskMetaData.Started = &started
+		byteData, err := json.Marshal(taskMetaData)
+		if err != nil {
+			FinishedTask(storage, task.Id, task.Retries, "Cannot marshal the restore task metadata: "+err.Error(), "failed")
+			return
+		}
+		metadata := string(byteData)
+		if err = storage.UpdateTask(task.Id, nil, nil, &metadata, nil, nil, nil); err != nil {
+			glog.Errorf("Unable to update the restore task metadata of %s: %s\n", instance.Name, err.Error())
+		}
+		UpdateTaskStatus(storage, task.Id, task.Retries+1, "Restoring from snapshot "+taskMetaData.Snapshot, "pending")
+		return
+	}
+	progress, err := restoreProgress(cluster, instance, repository, taskMetaData.Snapshot)
+	if err != nil {
+		UpdateTaskStatus(storage, task.Id, task.Retries+1, "Waiting for the restore to finish", "pending")
+		return
+	}
+	if progress.Active != 0 || progress.ShardsRecovered < progress.Shards {
+		var elapsed time.Duration
+		if taskMetaData.Started != nil {
+			elapsed = time.Since(*taskMetaData.Started)
+		}
+		UpdateTaskStatus(storage, task.Id, task.Retries+1, progress.Description(taskMetaData.Snapshot, elapsed, time.Now()), "pending")
+		return
+	}
+	resetRestoreThrottle(cluster, instance, taskMetaData.PreviousSettings)
+	FinishedTask(storage, task.Id, task.Retries, "Restored "+strings.Join(taskMetaData.Indices, ", ")+" from snapshot "+taskMetaData.Snapshot, "finished")
+}
+
+// POST /v2/service_instances/{instance_id}/actions/restore restores the selected indices
+// from a snapshot of the instance (the latest one that has them unless a snapshot is given)
+// back into the instance, e.g., {"indices":["orders"], "rename_pattern":"(.+)",
+// "rename_replacement":"restored-$1"}.
+func (b *BusinessLogic) RestoreAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	if err := b.checkNotFrozen(InstanceID); err != nil {
+		return nil, err
+	}
+	var request RestoreRequest
+	if c == nil || c.Request == nil || c.Request.Body == nil {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "The indices to restore must be provided.")
+	}
+	if err := json.NewDecoder(c.Request.Body).Decode(&request); err != nil {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "The request must be {\"indices\":[...]}.")
+	}
+	if err := request.Validate(); err != nil {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", err.Error())
+	}
+	if request.Throttle != nil {
+		if err := request.Throttle.Validate(); err != nil {
+			return nil, UnprocessableEntityWithMessage("InvalidRequest", err.Error())
+		}
+	}
+	if request.Repository == "" {
+		request.Repository = cloneRepository()
+	}
+	if request.Repository == "" {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "A snapshot repository must be provided.")
+	}
+	if !restoreNamePattern.MatchString(request.Repository) {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "The repository "+request.Repository+" is not a valid repository name.")
+	}
+	instance, err := b.GetInstanceById(InstanceID)
+	if err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Error finding instance id (during restore): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	if !IsAvailable(instance.Status) {
+		return nil, UnprocessableEntityWithMessage("NotAvailable", "The instance must be available to be restored.")
+	}
+	if request.RenamePattern == "" {
+		if request.ConfirmationToken == "" {
+			request.ConfirmationToken = requestConfirmationToken(c)
+		}
+		if err = checkConfirmation(request.ConfirmationToken, "restore", instance); err != nil {
+			return nil, err
+		}
+	}
+	if restoring, err := b.storage.IsRestoring(InstanceID); err != nil {
+		glog.Errorf("Unable to determine if %s is being restored: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	} else if restoring {
+		return nil, ConflictErrorWithMessage("The instance is already being restored.")
+	}
+	byteData, err := json.Marshal(RestoreTaskMetadata{From: instance.Id, RestoreParameters: request.RestoreParameters, Repository: request.Repository, Throttle: request.Throttle})
+	if err != nil {
+		glog.Errorf("Unable to marshal restore task meta data: %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	id, err := b.storage.AddTask(instance.Id, RestoreTask, string(byteData))
+	if err != nil {
+		glog.Errorf("Error: Unable to schedule restoring %s: %s\n", instance.Name, err.Error())
+		return nil, InternalServerError()
+	}
+	now := time.Now()
+	return Operation{Id: id, Action: RestoreTask, Status: "pending", Created: now, Updated: now}, nil
+}
+
+// PUT /v2/service_instances/{instance_id}/actions/restore-throttle changes the throttle of
+// the restore running on the instance, e.g., {"max_bytes_per_sec":"20mb"} to slow it down
+// during peak traffic.
+func (b *BusinessLogic) RestoreThrottleAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	var throttle RestoreThrottle
+	if c == nil || c.Request == nil || c.Request.Body == nil {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "The throttle must be provided.")
+	}
+	if err := json.NewDecoder(c.Request.Body).Decode(&throttle); err != nil {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "The request must be {\"max_bytes_per_sec\":\"...\", \"concurrent_recoveries\":...}.")
+	}
+	if err := throttle.Validate(); err != nil {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", err.Error())
+	}
+	instance, err := b.GetInstanceById(InstanceID)
+	if err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Error finding instance id (during restore throttle): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	task, err := b.storage.GetLastTask(InstanceID, RestoreTask)
+	if err != nil || (task.Status != "pending" && task.Status != "started") {
+		return nil, UnprocessableEntityWithMessage("NotRestoring", "The instance is not being restored.")
+	}
+	var taskMetaData RestoreTaskMetadata
+	if err = json.Unmarshal([]byte(task.Metadata), &taskMetaData); err != nil {
+		glog.Errorf("Invalid restore task metadata of %s: %s\n", instance.Name, err.Error())
+		return nil, InternalServerError()
+	}
+	if !taskMetaData.Restoring {
+		// the worker writes the metadata when it starts the restore
+		return nil, ConflictErrorWithMessage("The restore has not started yet, try again shortly or pass the throttle with the restore.")
+	}
+	previous, status, err := applyRestoreThrottle(NewClusterClient(), instance, &throttle, taskMetaData.PreviousSettings)
+	if err != nil && status >= 400 && status < 500 {
+		return nil, UnprocessableEntityWithMessage("InvalidThrottle", err.Error())
+	} else if err != nil {
+		glog.Errorf("Unable to throttle the restore of %s: %s\n", instance.Name, err.Error())
+		return nil, InternalServerError()
+	}
+	taskMetaData.Throttle = &throttle
+	taskMetaData.PreviousSettings = previous
+	byteData, err := json.Marshal(taskMetaData)
+	if err != nil {
+		glog.Errorf("Unable to marshal restore task meta data: %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	metadata := string(byteData)
+	if err = b.storage.UpdateTask(task.Id, nil, nil, &metadata, nil, nil, nil); err != nil {
+		glog.Errorf("Unable to update the restore task metadata of %s: %s\n", instance.Name, err.Error())
+		return nil, InternalServerError()
+	}
+	return throttle, nil
+}
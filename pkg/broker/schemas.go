@@ -0,0 +1,142 @@
+package broker
+
+import (
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+// PlanSchemas are the JSON schemas (draft-04, as the OSB spec requires) of the parameters
+// a plan accepts when it is provisioned, updated or bound. They are published in the catalog
+// so platforms can validate parameters before they reach the broker. A plan has the default
+// schemas of the parameters the broker understands unless its schemas column overrides them.
+type PlanSchemas struct {
+	Create map[string]interface{} `json:"create,omitempty"`
+	Update map[string]interface{} `json:"update,omitempty"`
+	Bind   map[string]interface{} `json:"bind,omitempty"`
+}
+
+const jsonSchemaVersion = "http://json-schema.org/draft-04/schema#"
+
+func objectSchema(properties map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"$schema":    jsonSchemaVersion,
+		"type":       "object",
+		"properties": properties,
+	}
+}
+
+func stringMapSchema(description string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":                 "object",
+		"description":          description,
+		"additionalProperties": map[string]interface{}{"type": "string"},
+	}
+}
+
+// DefaultPlanSchemas are the schemas of the parameters the broker accepts for the plan,
+// the logging and clone parameters are only accepted by plans that support them.
+func DefaultPlanSchemas(plan *ProviderPlan) *PlanSchemas {
+	create := map[string]interface{}{
+		"contacts": map[string]interface{}{
+			"type":        "array",
+			"description": "Email addresses notified before the instance expires or is changed.",
+			"items":       map[string]interface{}{"type": "string", "format": "email"},
+		},
+		"benchmark": map[string]interface{}{
+			"type":        "boolean",
+			"description": "Benchmarks the instance once it is available.",
+		},
+	}
+	if plan.Logging != nil {
+		create["logging"] = map[string]interface{}{
+			"type":        "object",
+			"description": "Overrides the rollover and retention of the plans logging tier.",
+			"properties": map[string]interface{}{
+				"alias":             map[string]interface{}{"type": "string", "pattern": "^[a-z0-9][a-z0-9_.\\-]*$"},
+				"rollover_max_size": map[string]interface{}{"type": "string", "description": "e.g., 50gb"},
+				"rollover_max_age":  map[string]interface{}{"type": "string", "description": "e.g., 1d"},
+				"delete_after":      map[string]interface{}{"type": "string", "description": "e.g., 30d"},
+			},
+			"additionalProperties": false,
+		}
+	}
+	if plan.TTL > 0 && cloneRepository() != "" {
+		create["clone"] = map[string]interface{}{
+			"type":        "object",
+			"description": "Restores the indices from the latest snapshot of another instance.",
+			"properties": map[string]interface{}{
+				"from": map[string]interface{}{"type": "string"},
+				"indices": map[string]interface{}{
+					"type":     "array",
+					"minItems": 1,
+					"items":    map[string]interface{}{"type": "string", "pattern": restoreIndexPattern.String()},
+				},
+			},
+			"required":             []string{"from", "indices"},
+			"additionalProperties": false,
+		}
+	}
+	return &PlanSchemas{
+		Create: objectSchema(create),
+		Update: objectSchema(map[string]interface{}{
+			"instance_count": map[string]interface{}{
+				"type":        "integer",
+				"description": "The number of data nodes.",
+				"minimum":     1,
+			},
+			"volume_size": map[string]interface{}{
+				"type":        "integer",
+				"description": "The EBS volume size (GiB) of each data node.",
+				"minimum":     10,
+			},
+			"snapshot_hour": map[string]interface{}{
+				"type":        "integer",
+				"description": "The hour (UTC) the automated snapshot is taken.",
+				"minimum":     0,
+				"maximum":     23,
+			},
+		}),
+		Bind: objectSchema(map[string]interface{}{
+			"config_var_names": stringMapSchema("Renames the config vars of the binding, e.g., {\"ES_URL\":\"ELASTICSEARCH_URL\"}."),
+			"config_vars":      stringMapSchema("Adds to or overrides the config vars of the binding."),
+			"ttl": map[string]interface{}{
+				"type":        "string",
+				"description": "Expires the binding (revoking its credentials) after this long, e.g., 24h.",
+				"pattern":     "^([0-9]+(\\.[0-9]+)?(h|m|s))+$",
+			},
+		}),
+	}
+}
+
+// WithDefaults returns the schemas with the default schema of the plan for any not set.
+func (s *PlanSchemas) WithDefaults(plan *ProviderPlan) *PlanSchemas {
+	schemas := DefaultPlanSchemas(plan)
+	if s == nil {
+		return schemas
+	}
+	if s.Create != nil {
+		schemas.Create = s.Create
+	}
+	if s.Update != nil {
+		schemas.Update = s.Update
+	}
+	if s.Bind != nil {
+		schemas.Bind = s.Bind
+	}
+	return schemas
+}
+
+// OSB returns the schemas as they are published in the catalog.
+func (s *PlanSchemas) OSB() *osb.Schemas {
+	if s == nil {
+		return nil
+	}
+	return &osb.Schemas{
+		ServiceInstance: &osb.ServiceInstanceSchema{
+			Create: &osb.InputParametersSchema{Parameters: s.Create},
+			Update: &osb.InputParametersSchema{Parameters: s.Update},
+		},
+		ServiceBinding: &osb.ServiceBindingSchema{
+			Create: &osb.RequestResponseSchema{InputParametersSchema: osb.InputParametersSchema{Parameters: s.Bind}},
+		},
+	}
+}
@@ -0,0 +1,146 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+)
+
+const secretsManagerScheme = "secretsmanager://"
+const vaultScheme = "vault://"
+
+// ResolveSecrets replaces any string value in the (json) plan settings that references
+// a secret with the secrets value. References are in the form of
+// secretsmanager://{secret-id}[#json-key] or vault://{path}[#key] so that kms keys,
+// saml metadata and passwords do not need to be stored in plain text in the plan.
+func ResolveSecrets(details []byte) ([]byte, error) {
+	if !strings.Contains(string(details), secretsManagerScheme) && !strings.Contains(string(details), vaultScheme) {
+		return details, nil
+	}
+	var settings interface{}
+	if err := json.Unmarshal(details, &settings); err != nil {
+		return nil, err
+	}
+	resolved, err := resolveSecretValues(settings)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(resolved)
+}
+
+func resolveSecretValues(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			resolved, err := resolveSecretValues(item)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = resolved
+		}
+		return v, nil
+	case []interface{}:
+		for i, item := range v {
+			resolved, err := resolveSecretValues(item)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = resolved
+		}
+		return v, nil
+	case string:
+		if strings.HasPrefix(v, secretsManagerScheme) {
+			return getSecretsManagerSecret(strings.TrimPrefix(v, secretsManagerScheme))
+		} else if strings.HasPrefix(v, vaultScheme) {
+			return getVaultSecret(strings.TrimPrefix(v, vaultScheme))
+		}
+		return v, nil
+	default:
+		return v, nil
+	}
+}
+
+func splitSecretReference(reference string) (string, string) {
+	parts := strings.SplitN(reference, "#", 2)
+	if len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return parts[0], ""
+}
+
+func secretKeyFromJson(data []byte, key string, reference string) (string, error) {
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return "", errors.New("The secret " + reference + " is not a json object, cannot read key " + key)
+	}
+	value, ok := values[key].(string)
+	if !ok {
+		return "", errors.New("The secret " + reference + " does not have the key " + key)
+	}
+	return value, nil
+}
+
+func getSecretsManagerSecret(reference string) (string, error) {
+	id, key := splitSecretReference(reference)
+	svc := secretsmanager.New(awsSession())
+	res, err := svc.GetSecretValue(&secretsmanager.GetSecretValueInput{SecretId: aws.String(id)})
+	if err != nil {
+		return "", err
+	}
+	if res.SecretString == nil {
+		return "", errors.New("The secret " + id + " does not have a string value")
+	}
+	if key == "" {
+		return *res.SecretString, nil
+	}
+	return secretKeyFromJson([]byte(*res.SecretString), key, id)
+}
+
+func getVaultSecret(reference string) (string, error) {
+	if os.Getenv("VAULT_ADDR") == "" || os.Getenv("VAULT_TOKEN") == "" {
+		return "", errors.New("The VAULT_ADDR and VAULT_TOKEN environment variables must be set to use vault secrets.")
+	}
+	path, key := splitSecretReference(reference)
+	if key == "" {
+		key = "value"
+	}
+	req, err := http.NewRequest("GET", strings.TrimSuffix(os.Getenv("VAULT_ADDR"), "/")+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", os.Getenv("VAULT_TOKEN"))
+	client := &http.Client{Timeout: time.Second * 30}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New("Unable to read vault secret " + path + ": " + resp.Status)
+	}
+	var secret struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", err
+	}
+	// kv version 2 engines nest the secret in data.data
+	if nested, ok := secret.Data["data"]; ok {
+		return secretKeyFromJson(nested, key, path)
+	}
+	raw, ok := secret.Data[key]
+	if !ok {
+		return "", errors.New("The secret " + path + " does not have the key " + key)
+	}
+	var value string
+	if err = json.Unmarshal(raw, &value); err != nil {
+		return "", errors.New("The secret " + path + " key " + key + " is not a string")
+	}
+	return value, nil
+}
@@ -0,0 +1,224 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+	"github.com/pmorie/osb-broker-lib/pkg/metrics"
+	"github.com/pmorie/osb-broker-lib/pkg/rest"
+	"github.com/pmorie/osb-broker-lib/pkg/server"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// NewServer creates the business logic and the OSB server with all of the brokers own
+// routes. It is what cmd/servicebroker runs, the reconciler is not started (see
+// RunReconciler).
+func NewServer(ctx context.Context, o Options) (*server.Server, *BusinessLogic, error) {
+	businessLogic, err := NewBusinessLogic(ctx, o)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reg := prom.NewRegistry()
+	osbMetrics := metrics.New()
+	reg.MustRegister(osbMetrics)
+	instanceMetrics := NewInstanceMetrics()
+	instanceMetrics.Register(reg)
+	NewHeartbeatMetrics(businessLogic.storage).Register(reg)
+	businessLogic.metrics = instanceMetrics
+
+	api, err := rest.NewAPISurface(businessLogic, osbMetrics)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	policy, err := NewResponsePolicy()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s := server.New(api, reg)
+	s.Router.Use(PredecessorBindingMiddleware)
+	s.Router.Use(MaintenanceInfoMiddleware)
+	s.Router.Use(OperationOutcomeMiddleware)
+	policy.Routes(s.Router)
+	businessLogic.RouteActions(s.Router)
+	businessLogic.RoutePlanLimits(s.Router)
+	businessLogic.RouteGetInstance(s.Router)
+	businessLogic.RouteBindingLastOperation(s.Router)
+	businessLogic.RouteListInstances(s.Router)
+	businessLogic.RouteGraphQL(s.Router)
+	businessLogic.RouteCatalog(s.Router)
+	businessLogic.RouteBackground(s.Router)
+	businessLogic.RouteOwnerPresets(s.Router)
+	CrudeOSBIHacks(s.Router, businessLogic)
+	return s, businessLogic, nil
+}
+
+// Service is the broker embedded as a library, so other go services can provision
+// elasticsearch without running a separate broker. Provisioning, plan changes and
+// deprovisioning are finished by the background tasks, RunBackgroundTasks must run
+// somewhere against the same database (in a goroutine or as a separate worker).
+type Service struct {
+	logic *BusinessLogic
+}
+
+// NewHandler returns the OSB api (with the brokers own endpoints) as an http.Handler
+// to mount in another server, and a Service to call the broker directly.
+func NewHandler(ctx context.Context, o Options) (http.Handler, *Service, error) {
+	s, businessLogic, err := NewServer(ctx, o)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.Router, &Service{logic: businessLogic}, nil
+}
+
+// RunReconciler starts the reconciler in the background until ctx is done, it keeps the
+// status of instances in sync with their provider and refreshes the instance metrics. Only
+// one process against a database needs to run it.
+func (s *Service) RunReconciler(ctx context.Context) {
+	s.logic.RunReconciler(ctx)
+}
+
+// The Service methods have no http request, hooks and webhooks that are set through
+// query parameters are not available.
+func (s *Service) context() *broker.RequestContext {
+	return &broker.RequestContext{}
+}
+
+// Services returns the catalog, the services and their plans.
+func (s *Service) Services() ([]osb.Service, error) {
+	catalog, err := s.logic.GetCatalog(s.context())
+	if err != nil {
+		return nil, err
+	}
+	return catalog.Services, nil
+}
+
+// Provision creates an instance for owner, it returns the operation to poll with
+// LastOperation or an empty operation if the instance is already available.
+func (s *Service) Provision(instanceId string, serviceId string, planId string, owner string, parameters map[string]interface{}) (string, error) {
+	resp, err := s.logic.Provision(&osb.ProvisionRequest{
+		InstanceID:        instanceId,
+		AcceptsIncomplete: true,
+		ServiceID:         serviceId,
+		PlanID:            planId,
+		OrganizationGUID:  owner,
+		Parameters:        parameters,
+	}, s.context())
+	if err != nil {
+		return "", err
+	}
+	if resp.OperationKey == nil {
+		return "", nil
+	}
+	return string(*resp.OperationKey), nil
+}
+
+// Update changes the plan (if planId is not empty) or parameters of an instance, it
+// returns the operation to poll with LastOperation.
+func (s *Service) Update(instanceId string, serviceId string, planId string, parameters map[string]interface{}) (string, error) {
+	request := &osb.UpdateInstanceRequest{
+		InstanceID:        instanceId,
+		AcceptsIncomplete: true,
+		ServiceID:         serviceId,
+		Parameters:        parameters,
+	}
+	if planId != "" {
+		request.PlanID = &planId
+	}
+	resp, err := s.logic.Update(request, s.context())
+	if err != nil {
+		return "", err
+	}
+	if resp.OperationKey == nil {
+		return "", nil
+	}
+	return string(*resp.OperationKey), nil
+}
+
+// Prepare issues a token confirming the operation ("deprovision" or "restore") on an instance,
+// deprovisions require one if CONFIRMATION_SECRET is set.
+func (s *Service) Prepare(instanceId string, operation string) (*Confirmation, error) {
+	data, err := json.Marshal(ConfirmationRequest{Operation: operation})
+	if err != nil {
+		return nil, err
+	}
+	c := s.context()
+	if c.Request, err = http.NewRequest("POST", "/v2/service_instances/"+instanceId+"/actions/prepare", bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	confirmation, err := s.logic.PrepareAction(instanceId, nil, c)
+	if err != nil {
+		return nil, err
+	}
+	return confirmation.(*Confirmation), nil
+}
+
+// Deprovision schedules an instance for deletion, the confirmation token (see Prepare) is
+// required if CONFIRMATION_SECRET is set and may be empty otherwise.
+func (s *Service) Deprovision(instanceId string, confirmationToken string) (string, error) {
+	c := s.context()
+	if confirmationToken != "" {
+		var err error
+		if c.Request, err = http.NewRequest("DELETE", "/v2/service_instances/"+instanceId, nil); err != nil {
+			return "", err
+		}
+		c.Request.Header.Set(confirmationTokenHeader, confirmationToken)
+	}
+	resp, err := s.logic.Deprovision(&osb.DeprovisionRequest{InstanceID: instanceId, AcceptsIncomplete: true}, c)
+	if err != nil {
+		return "", err
+	}
+	if resp.OperationKey == nil {
+		return "", nil
+	}
+	return string(*resp.OperationKey), nil
+}
+
+// LastOperation returns the state (in progress, succeeded or failed) and description
+// of the last operation on an instance.
+func (s *Service) LastOperation(instanceId string, operation string) (osb.LastOperationState, string, error) {
+	request := osb.LastOperationRequest{InstanceID: instanceId}
+	if operation != "" {
+		key := osb.OperationKey(operation)
+		request.OperationKey = &key
+	}
+	resp, err := s.logic.LastOperation(&request, s.context())
+	if err != nil {
+		return "", "", err
+	}
+	description := ""
+	if resp.Description != nil {
+		description = *resp.Description
+	}
+	return resp.State, description, nil
+}
+
+// Instance returns an instance as reported by its provider.
+func (s *Service) Instance(instanceId string) (*Instance, error) {
+	instance, err := s.logic.GetInstanceById(instanceId)
+	if err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	}
+	return instance, err
+}
+
+// Bind creates a binding and returns its credentials.
+func (s *Service) Bind(instanceId string, bindingId string, parameters map[string]interface{}) (map[string]interface{}, error) {
+	resp, err := s.logic.Bind(&osb.BindRequest{InstanceID: instanceId, BindingID: bindingId, Parameters: parameters}, s.context())
+	if err != nil {
+		return nil, err
+	}
+	return resp.Credentials, nil
+}
+
+// Unbind removes a binding.
+func (s *Service) Unbind(instanceId string, bindingId string) error {
+	_, err := s.logic.Unbind(&osb.UnbindRequest{InstanceID: instanceId, BindingID: bindingId}, s.context())
+	return err
+}
@@ -0,0 +1,223 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// The safety-critical cluster settings of every instance are recorded by the metrics
+// collector, if someone changes one (e.g., disables the disk watermarks or allows wildcard
+// deletes of indices) the contacts of the instance and SETTINGS_DRIFT_WEBHOOK are alerted.
+// With REVERT_SETTINGS_DRIFT=true the recorded values are restored (the revert-settings
+// action, which can be put in dry-run mode).
+
+var defaultGuardedSettings = []string{
+	"action.destructive_requires_name",
+	"cluster.routing.allocation.disk.threshold_enabled",
+	"cluster.routing.allocation.disk.watermark.low",
+	"cluster.routing.allocation.disk.watermark.high",
+	"cluster.routing.allocation.disk.watermark.flood_stage",
+	"cluster.routing.allocation.enable",
+	"cluster.max_shards_per_node",
+}
+
+type SettingChange struct {
+	Setting string `json:"setting"`
+	// An empty value is the default of the setting.
+	Previous string `json:"previous"`
+	Current  string `json:"current"`
+}
+
+type SettingsDrift struct {
+	InstanceNotification
+	Changes  []SettingChange `json:"changes"`
+	Reverted bool            `json:"reverted"`
+}
+
+// guardedSettings are the settings watched for drift, GUARDED_SETTINGS (comma separated)
+// replaces the defaults.
+func guardedSettings() []string {
+	if os.Getenv("GUARDED_SETTINGS") == "" {
+		return defaultGuardedSettings
+	}
+	settings := make([]string, 0)
+	for _, setting := range strings.Split(os.Getenv("GUARDED_SETTINGS"), ",") {
+		if setting = strings.TrimSpace(setting); setting != "" {
+			settings = append(settings, setting)
+		}
+	}
+	return settings
+}
+
+func settingValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case nil:
+		return ""
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(data)
+	}
+}
+
+// GetEffectiveSettings returns the value of every cluster setting that is set, transient
+// settings take precedence over persistent ones.
+func GetEffectiveSettings(cluster *ClusterClient, instance *Instance) (map[string]string, error) {
+	response, status, err := cluster.Do(instance, "GET", "/_cluster/settings?flat_settings=true", nil)
+	if err != nil {
+		return nil, err
+	}
+	if status != 200 {
+		return nil, errors.New("_cluster/settings returned " + strconv.Itoa(status))
+	}
+	var settings struct {
+		Persistent map[string]interface{} `json:"persistent"`
+		Transient  map[string]interface{} `json:"transient"`
+	}
+	if err = json.Unmarshal(response, &settings); err != nil {
+		return nil, err
+	}
+	effective := make(map[string]string)
+	for setting, value := range settings.Persistent {
+		effective[setting] = settingValue(value)
+	}
+	for setting, value := range settings.Transient {
+		effective[setting] = settingValue(value)
+	}
+	return effective, nil
+}
+
+// GetGuardedSettings returns the effective value of each guarded setting, settings left at
+// their default are empty.
+func GetGuardedSettings(cluster *ClusterClient, instance *Instance) (map[string]string, error) {
+	effective, err := GetEffectiveSettings(cluster, instance)
+	if err != nil {
+		return nil, err
+	}
+	guarded := make(map[string]string)
+	for _, setting := range guardedSettings() {
+		guarded[setting] = effective[setting]
+	}
+	return guarded, nil
+}
+
+// revertSettings restores the previous values as persistent settings and removes any
+// transient setting that overrides them.
+func revertSettings(cluster *ClusterClient, instance *Instance, changes []SettingChange) error {
+	persistent := make(map[string]interface{})
+	transient := make(map[string]interface{})
+	for _, change := range changes {
+		if change.Previous == "" {
+			persistent[change.Setting] = nil
+		} else {
+			persistent[change.Setting] = change.Previous
+		}
+		transient[change.Setting] = nil
+	}
+	body, err := json.Marshal(map[string]interface{}{"persistent": persistent, "transient": transient})
+	if err != nil {
+		return err
+	}
+	response, status, err := cluster.Do(instance, "PUT", "/_cluster/settings", body)
+	if err != nil {
+		return err
+	}
+	if status != 200 {
+		return errors.New("Reverting the cluster settings returned " + strconv.Itoa(status) + ": " + string(response))
+	}
+	return nil
+}
+
+// CheckSettingsDrift compares the guarded settings of every available instance with the
+// values recorded at the last check, it is ran by the metrics collector.
+func CheckSettingsDrift(namePrefix string, storage Storage, cluster *ClusterClient) {
+	entries, err := storage.GetInstances()
+	if err != nil {
+		glog.Errorf("Unable to check for cluster settings drift, cannot get instances: %s\n", err.Error())
+		return
+	}
+	for _, entry := range entries {
+		if !IsAvailable(entry.Status) {
+			continue
+		}
+		instance, err := GetInstanceById(namePrefix, storage, entry.Id)
+		if err != nil {
+			glog.Errorf("Unable to check %s for cluster settings drift: %s\n", entry.Name, err.Error())
+			continue
+		}
+		current, err := GetGuardedSettings(cluster, instance)
+		if err != nil {
+			glog.Errorf("Unable to check %s for cluster settings drift: %s\n", instance.Name, err.Error())
+			continue
+		}
+		recorded, err := storage.GetClusterSettings(instance.Id)
+		if err != nil && err.Error() == "Not found" {
+			if err = storage.SetClusterSettings(instance.Id, current); err != nil {
+				glog.Errorf("Unable to record the cluster settings of %s: %s\n", instance.Name, err.Error())
+			}
+			continue
+		} else if err != nil {
+			glog.Errorf("Unable to get the recorded cluster settings of %s: %s\n", instance.Name, err.Error())
+			continue
+		}
+		changes := make([]SettingChange, 0)
+		added := false
+		for setting, value := range current {
+			// settings that were not guarded when they were recorded are not drift.
+			if previous, ok := recorded[setting]; !ok {
+				recorded[setting] = value
+				added = true
+			} else if previous != value {
+				changes = append(changes, SettingChange{Setting: setting, Previous: previous, Current: value})
+			}
+		}
+		if len(changes) == 0 {
+			if added {
+				if err = storage.SetClusterSettings(instance.Id, recorded); err != nil {
+					glog.Errorf("Unable to record the cluster settings of %s: %s\n", instance.Name, err.Error())
+				}
+			}
+			continue
+		}
+		sort.Slice(changes, func(i, j int) bool { return changes[i].Setting < changes[j].Setting })
+		drift := SettingsDrift{InstanceNotification: newInstanceNotification(instance), Changes: changes}
+		glog.Infof("Warning: %d guarded cluster settings of %s have changed\n", len(changes), instance.Name)
+		if os.Getenv("REVERT_SETTINGS_DRIFT") == "true" {
+			if DryRun(RevertSettingsAction) {
+				ReportDryRun(storage, RevertSettingsAction, instance.Id, "Would revert "+strconv.Itoa(len(changes))+" guarded cluster settings of "+instance.Name)
+			} else if err = revertSettings(cluster, instance, changes); err != nil {
+				glog.Errorf("Unable to revert the cluster settings of %s: %s\n", instance.Name, err.Error())
+			} else {
+				drift.Reverted = true
+			}
+		}
+		// the changed values are recorded unless they were reverted so each change is alerted once.
+		if !drift.Reverted {
+			for _, change := range changes {
+				recorded[change.Setting] = change.Current
+			}
+		}
+		if err = storage.SetClusterSettings(instance.Id, recorded); err != nil {
+			glog.Errorf("Unable to record the cluster settings of %s: %s\n", instance.Name, err.Error())
+		}
+		if url := os.Getenv("SETTINGS_DRIFT_WEBHOOK"); url != "" {
+			if err = Notify(storage, SettingsDriftNotification, url, os.Getenv("SETTINGS_DRIFT_WEBHOOK_SECRET"), drift); err != nil {
+				glog.Errorf("Unable to send the cluster settings drift alert for %s: %s\n", instance.Name, err.Error())
+			}
+		}
+		if err = EmailContacts(storage, SettingsDriftNotification, instance.Id, drift); err != nil {
+			glog.Errorf("Unable to email the contacts of %s: %s\n", instance.Name, err.Error())
+		}
+	}
+}
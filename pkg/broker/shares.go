@@ -0,0 +1,170 @@
+package broker
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// An instance can be shared with other spaces so apps in them can bind to the same domain.
+// Bindings are made from the space in their OSB context, only the space the instance was
+// provisioned in and the spaces it is shared with may bind, and each binding has its own
+// user so the credentials of each space are isolated. A share can only be removed once its
+// space has no bindings. Deprovisioning a shared instance releases it, the domain is only
+// deleted once its last share is removed.
+
+type Share struct {
+	Id         string    `json:"id"`
+	InstanceId string    `json:"instance_id"`
+	Space      string    `json:"space"`
+	Created    time.Time `json:"created"`
+}
+
+type ShareRequest struct {
+	Space string `json:"space"`
+}
+
+// contextSpace is the space of an OSB context, the space_guid on cloud foundry (and akkeris)
+// or the namespace on kubernetes.
+func contextSpace(context map[string]interface{}) string {
+	for _, field := range []string{"space_guid", "space", "namespace"} {
+		if space, ok := context[field].(string); ok && space != "" {
+			return space
+		}
+	}
+	return ""
+}
+
+// checkBindingSpace rejects binding the instance from a space it is not shared with, it is
+// not checked if either space is unknown.
+func (b *BusinessLogic) checkBindingSpace(InstanceID string, space string) error {
+	if space == "" {
+		return nil
+	}
+	owner, released, err := b.storage.GetSpace(InstanceID)
+	if err != nil {
+		glog.Errorf("Unable to get the space of %s: %s\n", InstanceID, err.Error())
+		return InternalServerError()
+	}
+	if owner == "" || (owner == space && !released) {
+		return nil
+	}
+	shares, err := b.storage.GetShares(InstanceID)
+	if err != nil {
+		glog.Errorf("Unable to get the shares of %s: %s\n", InstanceID, err.Error())
+		return InternalServerError()
+	}
+	for _, share := range shares {
+		if share.Space == space {
+			return nil
+		}
+	}
+	return UnprocessableEntityWithMessage("InstanceNotShared", "The instance is not shared with the space "+space+".")
+}
+
+// scheduleDelete deletes the domain of the instance once its pre-deprovision hooks have ran.
+func scheduleDelete(storage Storage, instance *Instance) error {
+	hookTask, err := ScheduleHooksThen(storage, instance, PreDeprovisionHook, DeleteTask, instance.Name)
+	if err != nil {
+		return err
+	}
+	if hookTask == "" {
+		_, err = storage.AddTask(instance.Id, DeleteTask, instance.Name)
+	}
+	return err
+}
+
+// GET /v2/service_instances/{instance_id}/actions/shares
+func (b *BusinessLogic) ListSharesAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	if _, err := b.storage.GetInstance(InstanceID); err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Error finding instance id (during list shares): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	shares, err := b.storage.GetShares(InstanceID)
+	if err != nil {
+		glog.Errorf("Unable to get the shares of %s: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	return shares, nil
+}
+
+// POST /v2/service_instances/{instance_id}/actions/shares with {"space":"..."}
+func (b *BusinessLogic) AddShareAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	if err := b.checkNotFrozen(InstanceID); err != nil {
+		return nil, err
+	}
+	var request ShareRequest
+	if c == nil || c.Request == nil || c.Request.Body == nil {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "The body must be {\"space\":\"...\"}.")
+	}
+	if err := json.NewDecoder(c.Request.Body).Decode(&request); err != nil || request.Space == "" {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "The body must be {\"space\":\"...\"}.")
+	}
+	owner, released, err := b.storage.GetSpace(InstanceID)
+	if err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Unable to get the space of %s: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	if owner == request.Space && !released {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "The instance belongs to the space "+request.Space+".")
+	}
+	shares, err := b.storage.GetShares(InstanceID)
+	if err != nil {
+		glog.Errorf("Unable to get the shares of %s: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	for _, share := range shares {
+		if share.Space == request.Space {
+			return nil, ConflictErrorWithMessage("The instance is already shared with the space " + request.Space + ".")
+		}
+	}
+	share, err := b.storage.AddShare(InstanceID, request.Space)
+	if err != nil {
+		glog.Errorf("Unable to share %s with %s: %s\n", InstanceID, request.Space, err.Error())
+		return nil, InternalServerError()
+	}
+	return share, nil
+}
+
+// DELETE /v2/service_instances/{instance_id}/actions/shares/{share_id}
+func (b *BusinessLogic) RemoveShareAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	share, err := b.storage.GetShare(InstanceID, vars["share_id"])
+	if err != nil && err.Error() == "Not found" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Unable to get share %s: %s\n", vars["share_id"], err.Error())
+		return nil, InternalServerError()
+	}
+	count, err := b.storage.CountSpaceBindings(InstanceID, share.Space)
+	if err != nil {
+		glog.Errorf("Unable to count the bindings of %s in %s: %s\n", InstanceID, share.Space, err.Error())
+		return nil, InternalServerError()
+	}
+	if count > 0 {
+		return nil, ConflictErrorWithMessage("The space " + share.Space + " still has bindings to the instance, unbind them first.")
+	}
+	remaining, err := b.storage.RemoveShare(InstanceID, share.Id)
+	if err != nil {
+		glog.Errorf("Unable to remove share %s: %s\n", share.Id, err.Error())
+		return nil, InternalServerError()
+	}
+	if _, released, err := b.storage.GetSpace(InstanceID); err == nil && released && remaining == 0 {
+		instance, err := b.GetInstanceById(InstanceID)
+		if err != nil {
+			glog.Errorf("Unable to delete the released instance %s: %s\n", InstanceID, err.Error())
+			return nil, InternalServerError()
+		}
+		glog.Infof("The last share of the released instance %s was removed, deleting it\n", instance.Name)
+		if err = scheduleDelete(b.storage, instance); err != nil {
+			glog.Errorf("Error: Unable to schedule the delete of %s: %s\n", instance.Name, err.Error())
+			return nil, InternalServerError()
+		}
+	}
+	return share, nil
+}
@@ -0,0 +1,248 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// The snapshot catalog lists the snapshots of an instance in a repository with the indices
+// each has, their sizes and how long the snapshot took, so owners can pick the snapshot (and
+// indices) of a targeted restore. The sizes come from the _status api of the snapshots, which
+// reads them from the repository, so only the newest snapshots are detailed per request.
+
+const (
+	snapshotCatalogLimit    = 20
+	snapshotCatalogMaxLimit = 100
+	// the number of snapshots asked for in a single _status call
+	snapshotStatusBatch = 10
+)
+
+// snapshotStats are the stats _status reports of a snapshot or an index in it, elasticsearch
+// 7.4 and later (and opensearch) report total and incremental sizes, older versions only the
+// total size.
+type snapshotStats struct {
+	Incremental struct {
+		FileCount   int64 `json:"file_count"`
+		SizeInBytes int64 `json:"size_in_bytes"`
+	} `json:"incremental"`
+	Total struct {
+		FileCount   int64 `json:"file_count"`
+		SizeInBytes int64 `json:"size_in_bytes"`
+	} `json:"total"`
+	NumberOfFiles     int64 `json:"number_of_files"`
+	TotalSizeInBytes  int64 `json:"total_size_in_bytes"`
+	StartTimeInMillis int64 `json:"start_time_in_millis"`
+	TimeInMillis      int64 `json:"time_in_millis"`
+}
+
+func (s snapshotStats) size() int64 {
+	if s.Total.SizeInBytes != 0 {
+		return s.Total.SizeInBytes
+	}
+	return s.TotalSizeInBytes
+}
+
+func (s snapshotStats) incrementalSize() int64 {
+	if s.Total.SizeInBytes != 0 {
+		return s.Incremental.SizeInBytes
+	}
+	return s.TotalSizeInBytes
+}
+
+func (s snapshotStats) files() int64 {
+	if s.Total.FileCount != 0 {
+		return s.Total.FileCount
+	}
+	return s.NumberOfFiles
+}
+
+type snapshotDetail struct {
+	Snapshot string        `json:"snapshot"`
+	State    string        `json:"state"`
+	Stats    snapshotStats `json:"stats"`
+	Indices  map[string]struct {
+		Stats snapshotStats `json:"stats"`
+	} `json:"indices"`
+}
+
+type SnapshotIndex struct {
+	Name string `json:"name"`
+	// The size of the files of the index in the snapshot, and of those that were not already
+	// in the repository from an earlier snapshot.
+	SizeBytes            int64 `json:"size_bytes"`
+	IncrementalSizeBytes int64 `json:"incremental_size_bytes"`
+	Files                int64 `json:"files"`
+	DurationMillis       int64 `json:"duration_millis"`
+}
+
+type SnapshotCatalogEntry struct {
+	Snapshot             string          `json:"snapshot"`
+	State                string          `json:"state"`
+	Started              time.Time       `json:"started"`
+	Ended                *time.Time      `json:"ended,omitempty"`
+	DurationMillis       int64           `json:"duration_millis"`
+	SizeBytes            int64           `json:"size_bytes"`
+	IncrementalSizeBytes int64           `json:"incremental_size_bytes"`
+	Indices              []SnapshotIndex `json:"indices"`
+}
+
+type SnapshotCatalog struct {
+	Repository string                 `json:"repository"`
+	Total      int                    `json:"total"`
+	Snapshots  []SnapshotCatalogEntry `json:"snapshots"`
+}
+
+func millisTime(millis int64) time.Time {
+	return time.Unix(0, millis*int64(time.Millisecond)).UTC()
+}
+
+// listSnapshots returns the snapshots of the instance in the repository.
+func listSnapshots(cluster *ClusterClient, instance *Instance, repository string) ([]snapshotInfo, error) {
+	response, status, err := cluster.Do(instance, "GET", "/_snapshot/"+url.PathEscape(repository)+"/_all", nil)
+	if err != nil {
+		return nil, err
+	}
+	if status == 404 {
+		return nil, errors.New("The repository " + repository + " is not registered on " + instance.Name + ".")
+	}
+	if status != 200 {
+		return nil, errors.New("Unable to list the snapshots of " + instance.Name + ", _snapshot returned " + strconv.Itoa(status))
+	}
+	var snapshots struct {
+		Snapshots []snapshotInfo `json:"snapshots"`
+	}
+	if err = json.Unmarshal(response, &snapshots); err != nil {
+		return nil, err
+	}
+	return snapshots.Snapshots, nil
+}
+
+// snapshotStatuses returns the _status of the snapshots (by name).
+func snapshotStatuses(cluster *ClusterClient, instance *Instance, repository string, names []string) (map[string]snapshotDetail, error) {
+	statuses := make(map[string]snapshotDetail)
+	for start := 0; start < len(names); start += snapshotStatusBatch {
+		end := start + snapshotStatusBatch
+		if end > len(names) {
+			end = len(names)
+		}
+		escaped := make([]string, 0)
+		for _, name := range names[start:end] {
+			escaped = append(escaped, url.PathEscape(name))
+		}
+		response, status, err := cluster.Do(instance, "GET", "/_snapshot/"+url.PathEscape(repository)+"/"+strings.Join(escaped, ",")+"/_status", nil)
+		if err != nil {
+			return nil, err
+		}
+		if status != 200 {
+			return nil, errors.New("Unable to get the status of the snapshots of " + instance.Name + ", _status returned " + strconv.Itoa(status))
+		}
+		var result struct {
+			Snapshots []snapshotDetail `json:"snapshots"`
+		}
+		if err = json.Unmarshal(response, &result); err != nil {
+			return nil, err
+		}
+		for _, snapshot := range result.Snapshots {
+			statuses[snapshot.Snapshot] = snapshot
+		}
+	}
+	return statuses, nil
+}
+
+// BuildSnapshotCatalog details the newest snapshots (up to the limit) of the instance in the
+// repository, newest first.
+func BuildSnapshotCatalog(cluster *ClusterClient, instance *Instance, repository string, limit int) (*SnapshotCatalog, error) {
+	snapshots, err := listSnapshots(cluster, instance, repository)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].StartTimeInMillis > snapshots[j].StartTimeInMillis })
+	catalog := &SnapshotCatalog{Repository: repository, Total: len(snapshots), Snapshots: make([]SnapshotCatalogEntry, 0)}
+	if len(snapshots) > limit {
+		snapshots = snapshots[:limit]
+	}
+	// failed snapshots may be missing files in the repository, _status fails on them
+	names := make([]string, 0)
+	for _, snapshot := range snapshots {
+		if snapshot.State != "FAILED" {
+			names = append(names, snapshot.Snapshot)
+		}
+	}
+	statuses, err := snapshotStatuses(cluster, instance, repository, names)
+	if err != nil {
+		return nil, err
+	}
+	for _, snapshot := range snapshots {
+		entry := SnapshotCatalogEntry{
+			Snapshot:       snapshot.Snapshot,
+			State:          snapshot.State,
+			Started:        millisTime(snapshot.StartTimeInMillis),
+			DurationMillis: snapshot.DurationInMillis,
+			Indices:        make([]SnapshotIndex, 0),
+		}
+		if snapshot.EndTimeInMillis != 0 {
+			ended := millisTime(snapshot.EndTimeInMillis)
+			entry.Ended = &ended
+		}
+		status, ok := statuses[snapshot.Snapshot]
+		if ok {
+			entry.SizeBytes = status.Stats.size()
+			entry.IncrementalSizeBytes = status.Stats.incrementalSize()
+		}
+		for _, name := range snapshot.Indices {
+			index := SnapshotIndex{Name: name}
+			if stats, ok := status.Indices[name]; ok {
+				index.SizeBytes = stats.Stats.size()
+				index.IncrementalSizeBytes = stats.Stats.incrementalSize()
+				index.Files = stats.Stats.files()
+				index.DurationMillis = stats.Stats.TimeInMillis
+			}
+			entry.Indices = append(entry.Indices, index)
+		}
+		sort.Slice(entry.Indices, func(i, j int) bool { return entry.Indices[i].Name < entry.Indices[j].Name })
+		catalog.Snapshots = append(catalog.Snapshots, entry)
+	}
+	return catalog, nil
+}
+
+// GET /v2/service_instances/{instance_id}/actions/snapshots?repository=backups&limit=20
+func (b *BusinessLogic) SnapshotsAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	repository := c.Request.URL.Query().Get("repository")
+	if repository == "" {
+		repository = cloneRepository()
+	}
+	if repository == "" {
+		return nil, UnprocessableEntityWithMessage("InvalidRequest", "A snapshot repository must be provided.")
+	}
+	limit := snapshotCatalogLimit
+	if value := c.Request.URL.Query().Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > snapshotCatalogMaxLimit {
+			return nil, BadRequestWithMessage("The limit must be a number between 1 and " + strconv.Itoa(snapshotCatalogMaxLimit) + ".")
+		}
+	}
+	instance, err := b.GetInstanceById(InstanceID)
+	if err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Error finding instance id (during snapshot listing): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	if !IsAvailable(instance.Status) {
+		return nil, UnprocessableEntityWithMessage("NotAvailable", "The instance must be available to list its snapshots.")
+	}
+	catalog, err := BuildSnapshotCatalog(NewClusterClient(), instance, repository, limit)
+	if err != nil {
+		glog.Errorf("Unable to list the snapshots of %s: %s\n", instance.Name, err.Error())
+		return nil, UnprocessableEntityWithMessage("SnapshotsUnavailable", err.Error())
+	}
+	return catalog, nil
+}
@@ -38,7 +38,15 @@ select
     plans.beta,
     plans.provider,
     plans.provider_private_details::text,
-    plans.deprecated
+    plans.deprecated,
+    plans.config_var_names::text,
+    coalesce(plans.logging::text, ''),
+    coalesce(extract(epoch from plans.ttl)::bigint, 0),
+    coalesce(plans.schemas::text, ''),
+    coalesce(extract(epoch from plans.binding_ttl)::bigint, 0),
+    plans.retired,
+    coalesce(plans.migrate_to, ''),
+    coalesce(plans.parameter_policy::text, '')
 from plans join services on services.service = plans.service
     where services.deleted = false and plans.deleted = false `
 
@@ -67,6 +75,10 @@ begin
         create type enginetype as enum('elasticsearch');
     end if;
 
+    if not exists (select 1 from pg_enum where enumtypid = 'enginetype'::regtype and enumlabel = 'opensearch') then
+        alter type enginetype add value 'opensearch';
+    end if;
+
     if not exists (select 1 from pg_type where typname = 'clientdbtype') then
         create type clientdbtype as enum('elasticsearch', 'https');
     end if;
@@ -140,6 +152,14 @@ begin
         created timestamp with time zone not null default now(),
         updated timestamp with time zone not null default now()
     );
+    alter table plans add column if not exists config_var_names json not null default '{}';
+    alter table plans add column if not exists logging json;
+    alter table plans add column if not exists ttl interval;
+    alter table plans add column if not exists schemas json;
+    alter table plans add column if not exists binding_ttl interval;
+    alter table plans add column if not exists retired boolean not null default false;
+    alter table plans add column if not exists migrate_to varchar(1024);
+    alter table plans add column if not exists parameter_policy json;
     drop trigger if exists plans_updated on plans;
     create trigger plans_updated before update on plans for each row execute procedure mark_updated_column();
 
@@ -157,6 +177,21 @@ begin
         updated timestamp with time zone not null default now(),
         deleted bool not null default false
     );
+    alter table resources add column if not exists owner varchar(1024) not null default '';
+    alter table resources add column if not exists remediate_read_only boolean not null default false;
+    alter table resources add column if not exists contacts text not null default '';
+    alter table resources add column if not exists expires timestamp with time zone;
+    alter table resources add column if not exists frozen boolean not null default false;
+    alter table resources add column if not exists frozen_reason text not null default '';
+    alter table resources add column if not exists legal_hold boolean not null default false;
+    alter table resources add column if not exists legal_hold_reason text not null default '';
+    alter table resources add column if not exists created_by varchar(1024) not null default '';
+    alter table resources add column if not exists originating_identity json;
+    alter table resources add column if not exists context_tags json;
+    alter table resources add column if not exists labels json;
+    alter table resources add column if not exists annotations json;
+    alter table resources add column if not exists space varchar(1024) not null default '';
+    alter table resources add column if not exists released boolean not null default false;
     drop trigger if exists resources_updated on resources;
     create trigger resources_updated before update on resources for each row execute procedure mark_updated_column();
 
@@ -197,6 +232,303 @@ begin
     drop trigger if exists tasks_updated on tasks;
     create trigger tasks_updated before update on tasks for each row execute procedure mark_updated_column();
 
+    create table if not exists hooks
+    (
+        hook uuid not null primary key default uuid_generate_v4(),
+        plan uuid references plans("plan") not null,
+        stage varchar(128) not null,
+        type varchar(128) not null,
+        method varchar(16) not null default 'POST',
+        url text not null,
+        body text not null default '',
+        secret text not null default '',
+        retries int not null default 10,
+        ordinal int not null default 0,
+        created timestamp with time zone not null default now(),
+        updated timestamp with time zone not null default now(),
+        deleted bool not null default false
+    );
+    drop trigger if exists hooks_updated on hooks;
+    create trigger hooks_updated before update on hooks for each row execute procedure mark_updated_column();
+
+    create table if not exists events
+    (
+        event uuid not null primary key default uuid_generate_v4(),
+        resource varchar(1024) not null,
+        type varchar(128) not null,
+        data json not null,
+        created timestamp with time zone not null default now()
+    );
+    create index if not exists events_resource on events (resource, created);
+    -- events recorded in one transaction have the same created time, seq keeps them in order.
+    alter table events add column if not exists seq bigserial;
+
+    create table if not exists bindings
+    (
+        binding varchar(1024) not null primary key,
+        resource varchar(1024) references resources("id") not null,
+        parameters json not null default '{}',
+        created timestamp with time zone not null default now(),
+        updated timestamp with time zone not null default now(),
+        deleted bool not null default false
+    );
+    drop trigger if exists bindings_updated on bindings;
+    create trigger bindings_updated before update on bindings for each row execute procedure mark_updated_column();
+
+    create table if not exists associations
+    (
+        association uuid not null primary key default uuid_generate_v4(),
+        resource varchar(1024) references resources("id") not null,
+        vpc_id varchar(128) not null,
+        account_id varchar(128) not null,
+        region varchar(128) not null,
+        cidr varchar(128) not null,
+        peering_connection_id varchar(128) not null default '',
+        status varchar(128) not null,
+        message text not null default '',
+        created timestamp with time zone not null default now(),
+        updated timestamp with time zone not null default now(),
+        deleted bool not null default false
+    );
+    drop trigger if exists associations_updated on associations;
+    create trigger associations_updated before update on associations for each row execute procedure mark_updated_column();
+
+    create table if not exists schedules
+    (
+        name varchar(128) not null primary key,
+        next_run timestamp with time zone not null default now()
+    );
+
+    create table if not exists index_samples
+    (
+        resource varchar(1024) not null,
+        index_name varchar(1024) not null,
+        bytes bigint not null,
+        docs bigint not null,
+        sampled timestamp with time zone not null default now()
+    );
+    create index if not exists index_samples_resource on index_samples (resource, sampled);
+
+    create table if not exists storage_samples
+    (
+        resource varchar(1024) not null,
+        used_bytes bigint not null,
+        total_bytes bigint not null,
+        sampled timestamp with time zone not null default now()
+    );
+    create index if not exists storage_samples_resource on storage_samples (resource, sampled);
+
+    create table if not exists admin_queries
+    (
+        query uuid not null primary key default uuid_generate_v4(),
+        resource varchar(1024) not null,
+        actor varchar(1024) not null,
+        reason text not null,
+        path text not null,
+        status int,
+        created timestamp with time zone not null default now()
+    );
+    create index if not exists admin_queries_resource on admin_queries (resource, created);
+
+    create table if not exists operation_keys
+    (
+        resource varchar(1024) not null,
+        type varchar(32) not null,
+        attempt integer not null,
+        started timestamp with time zone not null default now(),
+        primary key (resource, type, attempt)
+    );
+    alter table operation_keys add column if not exists task varchar(1024) not null default '';
+
+    create table if not exists provision_durations
+    (
+        resource varchar(1024) not null primary key,
+        plan uuid references plans("plan") not null,
+        instance_type varchar(128) not null default '',
+        seconds integer not null,
+        created timestamp with time zone not null default now()
+    );
+
+    create table if not exists shares
+    (
+        share uuid not null primary key default uuid_generate_v4(),
+        resource varchar(1024) references resources("id") not null,
+        space varchar(1024) not null,
+        created timestamp with time zone not null default now(),
+        deleted bool not null default false
+    );
+
+    create table if not exists linked_instances
+    (
+        resource varchar(1024) references resources("id") not null primary key,
+        production varchar(1024) references resources("id") not null,
+        status varchar(32) not null default 'tracking',
+        plan varchar(1024) not null default '',
+        version varchar(1024) not null default '',
+        task varchar(1024) not null default '',
+        result text not null default '',
+        updated timestamp with time zone not null default now()
+    );
+
+    create table if not exists cluster_settings
+    (
+        resource varchar(1024) references resources("id") not null primary key,
+        settings json not null,
+        updated timestamp with time zone not null default now()
+    );
+
+    create table if not exists domain_intents
+    (
+        name varchar(1024) not null primary key,
+        resource varchar(1024) not null,
+        plan varchar(1024) not null,
+        created timestamp with time zone not null default now()
+    );
+
+    create table if not exists owner_quotas
+    (
+        owner varchar(1024) not null primary key,
+        max_instances int not null,
+        updated timestamp with time zone not null default now()
+    );
+
+    create table if not exists engine_versions
+    (
+        engine varchar(128) not null,
+        version varchar(128) not null,
+        end_of_support timestamp with time zone,
+        upgrade_to varchar(128) not null default '',
+        primary key (engine, version)
+    );
+    if (select count(*) from engine_versions) = 0 then
+        insert into engine_versions (engine, version, end_of_support, upgrade_to) values
+            ('elasticsearch', '1.5', '2025-11-07', '6.8'),
+            ('elasticsearch', '2.3', '2025-11-07', '6.8'),
+            ('elasticsearch', '5.1', '2025-11-07', '6.8'),
+            ('elasticsearch', '5.3', '2025-11-07', '6.8'),
+            ('elasticsearch', '5.5', '2025-11-07', '6.8'),
+            ('elasticsearch', '5.6', '2025-11-07', '6.8'),
+            ('elasticsearch', '6.0', '2025-11-07', '6.8'),
+            ('elasticsearch', '6.2', '2025-11-07', '6.8'),
+            ('elasticsearch', '6.3', '2025-11-07', '6.8'),
+            ('elasticsearch', '6.4', '2025-11-07', '6.8'),
+            ('elasticsearch', '6.5', '2025-11-07', '6.8'),
+            ('elasticsearch', '6.7', '2025-11-07', '6.8'),
+            ('elasticsearch', '7.1', '2025-11-07', '7.10'),
+            ('elasticsearch', '7.4', '2025-11-07', '7.10'),
+            ('elasticsearch', '7.7', '2025-11-07', '7.10'),
+            ('elasticsearch', '7.8', '2025-11-07', '7.10'),
+            ('elasticsearch', '7.9', '2025-11-07', '7.10'),
+            ('elasticsearch', '6.8', null, ''),
+            ('elasticsearch', '7.10', null, '');
+    end if;
+
+    create table if not exists notification_templates
+    (
+        event varchar(128) not null,
+        channel varchar(128) not null,
+        template text not null,
+        primary key (event, channel)
+    );
+
+    create table if not exists dry_run_reports
+    (
+        resource varchar(1024) not null,
+        action varchar(128) not null,
+        description text not null,
+        created timestamp with time zone not null default now()
+    );
+    create index if not exists dry_run_reports_resource on dry_run_reports (resource, created);
+
+    -- credentials may be encrypted (see encryption.go) which is longer than they are.
+    alter table resources alter column username type varchar(1024);
+    alter table resources alter column password type varchar(1024);
+    alter table resources add column if not exists parameters json;
+    alter table resources add column if not exists requested_parameters json;
+    alter table bindings add column if not exists credentials text;
+    alter table bindings add column if not exists status varchar(128) not null default 'bound';
+    alter table bindings add column if not exists result text not null default '';
+    -- the user created on the cluster for a rotated binding, removed when it is unbound.
+    alter table bindings add column if not exists username varchar(1024) not null default '';
+    alter table bindings add column if not exists expires timestamp with time zone;
+    alter table bindings add column if not exists expiry_warned boolean not null default false;
+    alter table bindings add column if not exists space varchar(1024) not null default '';
+
+    create table if not exists snapshot_exports
+    (
+        resource varchar(1024) references resources("id") not null primary key,
+        bucket varchar(128) not null,
+        region varchar(128) not null,
+        base_path varchar(1024) not null default '',
+        status varchar(128) not null,
+        message text not null default '',
+        last_snapshot varchar(1024) not null default '',
+        last_exported timestamp with time zone,
+        created timestamp with time zone not null default now(),
+        updated timestamp with time zone not null default now()
+    );
+    drop trigger if exists snapshot_exports_updated on snapshot_exports;
+    create trigger snapshot_exports_updated before update on snapshot_exports for each row execute procedure mark_updated_column();
+
+    create table if not exists federated_instances
+    (
+        id varchar(1024) not null primary key,
+        broker varchar(1024) not null,
+        created timestamp with time zone not null default now()
+    );
+
+    create table if not exists heartbeats
+    (
+        name varchar(128) not null primary key,
+        interval_seconds integer not null default 0,
+        last_run timestamp with time zone,
+        last_success timestamp with time zone,
+        last_error text not null default '',
+        backlog integer not null default 0
+    );
+
+    create table if not exists owner_presets
+    (
+        owner varchar(1024) not null primary key,
+        parameters json not null,
+        updated timestamp with time zone not null default now()
+    );
+
+    create table if not exists benchmarks
+    (
+        id uuid not null default uuid_generate_v4() primary key,
+        resource varchar(1024) not null,
+        plan varchar(1024) not null,
+        documents integer not null,
+        searches integer not null,
+        index_throughput double precision not null,
+        search_latency_p50 double precision not null,
+        search_latency_p90 double precision not null,
+        created timestamp with time zone not null default now()
+    );
+    create index if not exists benchmarks_resource on benchmarks (resource, created);
+    create index if not exists benchmarks_plan on benchmarks (plan, created);
+
+    create table if not exists plan_visibility
+    (
+        plan varchar(1024) not null,
+        type varchar(32) not null,
+        id varchar(1024) not null,
+        effect varchar(32) not null,
+        primary key (plan, type, id)
+    );
+
+    create table if not exists simulated_domains
+    (
+        name varchar(1024) not null primary key,
+        plan varchar(1024) not null,
+        engine_version varchar(128) not null default '',
+        tags json not null,
+        operation varchar(32) not null,
+        changed timestamp with time zone not null,
+        created timestamp with time zone not null default now()
+    );
+
     -- populate some default services
     if (select count(*) from services) = 0 then
         insert into services 
@@ -243,13 +575,17 @@ type Storage interface {
 	GetPlans(string) ([]ProviderPlan, error)
 	GetPlanByID(string) (*ProviderPlan, error)
 	GetInstance(string) (*Entry, error)
+	GetInstances() ([]Entry, error)
 	AddInstance(*Instance) error
 	DeleteInstance(*Instance) error
 	UpdateInstance(*Instance, string) error
+	UpdateOwner(string, string) error
 	AddTask(string, TaskAction, string) (string, error)
 	GetServices() ([]osb.Service, error)
 	UpdateTask(string, *string, *int64, *string, *string, *time.Time, *time.Time) error
 	PopPendingTask() (*Task, error)
+	GetTaskInProgress(string, []TaskAction) (*Task, error)
+	LockInstanceOperations(string) (func(), bool, error)
 	GetUnclaimedInstance(string, string) (*Entry, error)
 	ReturnClaimedInstance(string) error
 	StartProvisioningTasks() ([]Entry, error)
@@ -258,6 +594,125 @@ type Storage interface {
     IsRestoring(string) (bool, error)
     IsUpgrading(string) (bool, error)
     ValidateInstanceID(string) error
+	GetHooks(string, HookStage) ([]Hook, error)
+	GetHook(string) (*Hook, error)
+	IsDeleting(string) (bool, error)
+	GetLastTask(string, TaskAction) (*Task, error)
+	GetLastTaskIn(string, []TaskAction) (*Task, error)
+	FailStuckTasks(TaskAction, int64, string) ([]Task, error)
+	GetEvents(string) ([]Event, error)
+	RepairInstance(*InstanceProjection) error
+	GetFederatedInstance(string) (string, error)
+	AddFederatedInstance(string, string) error
+	AddBinding(string, string, string) error
+	GetBindingParameters(string, string) (string, error)
+	SetBindingCredentials(string, string, string) error
+	GetBindingCredentials(string, string) (string, error)
+	SetBindingStatus(string, string, string, string) error
+	SetBindingDeactivation(string, string, string, string, EventType, EventData) error
+	GetBindingStatus(string, string) (string, string, error)
+	SetBindingUser(string, string, string) error
+	GetBindingUser(string, string) (string, error)
+	SetBindingExpires(string, string, *time.Time) error
+	GetExpiredBindings() ([]ExpiredBinding, error)
+	GetExpiringBindings(time.Time) ([]ExpiredBinding, error)
+	SetBindingExpiryWarned(string, string) error
+	RevokeBinding(string, string) error
+	DeleteBinding(string, string) error
+	AddAssociation(*Association) (string, error)
+	GetAssociations(string) ([]Association, error)
+	GetAssociation(string, string) (*Association, error)
+	DeleteAssociation(string, string) error
+	ClaimSchedule(string, time.Duration) (bool, error)
+	AddIndexSamples(string, []IndexSample) error
+	AddStorageSample(string, StorageSample) error
+	GetStorageSamples(string, time.Time) ([]StorageSample, error)
+	GetIndexGrowth(string, time.Time) ([]IndexGrowth, error)
+	PruneSamples(time.Time) error
+	GetReadOnlyRemediation(string) (bool, error)
+	SetReadOnlyRemediation(string, bool) error
+	GetReadOnlyRemediationInstances() ([]string, error)
+	GetFreeze(string) (*InstanceFreeze, error)
+	SetFreeze(string, *InstanceFreeze) error
+	GetLegalHold(string) (*LegalHold, error)
+	SetLegalHold(string, *LegalHold) error
+	GetOriginatingIdentity(string) (*OriginatingIdentity, error)
+	SetOriginatingIdentity(string, *OriginatingIdentity) error
+	GetContextTags(string) (map[string]string, error)
+	GetClusterSettings(string) (map[string]string, error)
+	GetLabels(string) (map[string]string, error)
+	AddOperationKey(string, string, string) (*OperationKey, error)
+	GetOperationKey(string) (*OperationKey, error)
+	GetInstanceCreated(string) (time.Time, error)
+	AddProvisionDuration(string, string) error
+	GetProvisionDurations(string, string, int) ([]time.Duration, error)
+	GetSpace(string) (string, bool, error)
+	SetSpace(string, string) error
+	Release(string) error
+	GetShares(string) ([]Share, error)
+	GetShare(string, string) (*Share, error)
+	AddShare(string, string) (*Share, error)
+	RemoveShare(string, string) (int, error)
+	SetBindingSpace(string, string, string) error
+	CountSpaceBindings(string, string) (int, error)
+	SetLabels(string, map[string]string) error
+	SetClusterSettings(string, map[string]string) error
+	GetCatalogServices() ([]CatalogService, error)
+	SaveCatalogService(*CatalogService) error
+	GetCatalogPlans() ([]CatalogPlan, error)
+	GetCatalogPlan(string) (*CatalogPlan, error)
+	SaveCatalogPlan(*CatalogPlan, string) error
+	RetirePlan(string) error
+	GetLinkedInstance(string) (*LinkedInstance, error)
+	GetLinkedInstances() ([]LinkedInstance, error)
+	GetLinkedStaging(string) ([]LinkedInstance, error)
+	SetLinkedInstance(*LinkedInstance) error
+	RemoveLinkedInstance(string) error
+	SetContextTags(string, map[string]string) error
+	AddAdminQuery(*AdminQuery) (string, error)
+	UpdateAdminQuery(string, int) error
+	GetAdminQueries(string) ([]AdminQuery, error)
+	GetOwnerQuota(string) (int, error)
+	AddDomainIntent(string, string, string) error
+	RemoveDomainIntent(string) error
+	GetOrphanedDomainIntents(time.Duration) ([]DomainIntent, error)
+	CountOwnerInstances(string) (int, error)
+	GetEngineSupports() ([]EngineSupport, error)
+	GetNotificationTemplate(string, string) (string, error)
+	GetContacts(string) ([]string, error)
+	SetContacts(string, []string) error
+	AddDryRunReport(*DryRunReport) error
+	GetDryRunReports(string) ([]DryRunReport, error)
+	ListInstances(*ListQuery) ([]InstanceSummary, string, error)
+	ListOperations(string, *ListQuery) ([]Operation, string, error)
+	ListBindings(string, *ListQuery) ([]BindingSummary, string, error)
+	GetExpires(string) (*time.Time, error)
+	SetExpires(string, *time.Time) error
+	GetExpiredInstances() ([]string, error)
+	SetInstanceParameters(string, *InstanceParameters) error
+	GetRequestedParameters(string) (map[string]interface{}, error)
+	SetRequestedParameters(string, map[string]interface{}) error
+	GetSnapshotExport(string) (*SnapshotExport, error)
+	GetSnapshotExports() ([]SnapshotExport, error)
+	SetSnapshotExport(*SnapshotExport) error
+	DeleteSnapshotExport(string) error
+	RecordHeartbeat(string, time.Duration, string, int) error
+	GetHeartbeats() ([]Heartbeat, error)
+	CountPendingTasks() (int, error)
+	GetVisibilityRules() ([]VisibilityRule, error)
+	GetPlanVisibility(string) ([]VisibilityRule, error)
+	SetPlanVisibility(string, []VisibilityRule) error
+	GetAnnotations(string) (*Annotations, error)
+	AddBenchmark(*BenchmarkResult) error
+	GetBenchmarks(string) ([]BenchmarkResult, error)
+	GetPlanBenchmarks() (map[string]PlanBenchmark, error)
+	GetOwnerPresets(string) (map[string]interface{}, error)
+	SetOwnerPresets(string, map[string]interface{}) error
+	SetAnnotations(string, *Annotations) error
+	GetSimulatedDomain(string) (*SimulatedDomain, error)
+	AddSimulatedDomain(*SimulatedDomain) error
+	UpdateSimulatedDomain(*SimulatedDomain) error
+	DeleteSimulatedDomain(string) error
 }
 
 type PostgresStorage struct {
@@ -265,6 +720,122 @@ type PostgresStorage struct {
 	db *sql.DB
 }
 
+type sqlExecer interface {
+	Exec(string, ...interface{}) (sql.Result, error)
+}
+
+type sqlQueryer interface {
+	Query(string, ...interface{}) (*sql.Rows, error)
+}
+
+func addEvent(db sqlExecer, Id string, eventType EventType, data EventData) error {
+	byteData, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("insert into events (resource, type, data) values ($1, $2, $3)", Id, eventType, string(byteData))
+	return err
+}
+
+// recordEvent appends an event to the stream of a resource in the transaction of the change
+// it records, so a change is never made without its event (or the other way around). If the
+// event changes the projected state the resource is updated to the state projected from its
+// stream. Instances created before events were recorded have their current state recorded
+// as a snapshot first.
+func recordEvent(tx *sql.Tx, Id string, eventType EventType, data EventData) error {
+	if eventType.projected() && eventType != PreprovisioningEvent && eventType != ProvisionedEvent && eventType != ClaimedEvent {
+		if err := snapshotInstance(tx, Id); err != nil {
+			return err
+		}
+	}
+	if err := addEvent(tx, Id, eventType, data); err != nil {
+		glog.Errorf("Unable to record %s event for %s: %s\n", eventType, Id, err.Error())
+		return err
+	}
+	if !eventType.projected() {
+		return nil
+	}
+	events, err := getEvents(tx, Id)
+	if err != nil {
+		return err
+	}
+	projection, err := ProjectEvents(Id, events)
+	if err != nil {
+		return err
+	}
+	return updateProjection(tx, projection)
+}
+
+func snapshotInstance(tx *sql.Tx, Id string) error {
+	var count int
+	if err := tx.QueryRow("select count(*) from events where resource = $1", Id).Scan(&count); err != nil || count != 0 {
+		return err
+	}
+	var entry Entry
+	var deleted bool
+	err := tx.QueryRow("select name, plan, status, endpoint, owner, claimed, deleted from resources where id = $1", Id).Scan(&entry.Name, &entry.PlanId, &entry.Status, &entry.Endpoint, &entry.Owner, &entry.Claimed, &deleted)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return addEvent(tx, Id, SnapshotEvent, EventData{Name: &entry.Name, Plan: &entry.PlanId, Status: &entry.Status, Endpoint: &entry.Endpoint, Owner: &entry.Owner, Claimed: &entry.Claimed, Deleted: &deleted})
+}
+
+func updateProjection(db sqlExecer, projection *InstanceProjection) error {
+	_, err := db.Exec("update resources set name = $2, plan = $3, status = $4, endpoint = $5, owner = $6, claimed = $7, deleted = $8 where id = $1", projection.Id, projection.Name, projection.PlanId, projection.Status, projection.Endpoint, projection.Owner, projection.Claimed, projection.Deleted)
+	return err
+}
+
+// withEvent runs change and records its event in one transaction, if either fails neither
+// is committed.
+func (b *PostgresStorage) withEvent(Id string, eventType EventType, data EventData, change func(tx *sql.Tx) error) error {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+	if change != nil {
+		if err = change(tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err = recordEvent(tx, Id, eventType, data); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func getEvents(db sqlQueryer, Id string) ([]Event, error) {
+	rows, err := db.Query("select event, resource, type, data, created from events where resource = $1 order by created asc, seq asc", Id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := make([]Event, 0)
+	for rows.Next() {
+		var event Event
+		var data string
+		if err = rows.Scan(&event.Id, &event.ResourceId, &event.Type, &data, &event.Created); err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal([]byte(data), &event.Data); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func (b *PostgresStorage) GetEvents(Id string) ([]Event, error) {
+	return getEvents(b.db, Id)
+}
+
+func (b *PostgresStorage) RepairInstance(projection *InstanceProjection) error {
+	return updateProjection(b.db, projection)
+}
+
 func (b *PostgresStorage) getPlans(subquery string, arg string) ([]ProviderPlan, error) {
 	// arg could be a service ID or Plan Id
 	rows, err := b.db.Query(plansQuery+subquery, arg)
@@ -275,16 +846,21 @@ func (b *PostgresStorage) getPlans(subquery string, arg string) ([]ProviderPlan,
 	defer rows.Close()
 	plans := make([]ProviderPlan, 0)
 	for rows.Next() {
-		var planId, serviceId, serviceName, name, humanName, description, engineVersion, engineType, scheme, categories, costUnits, provider, attributes, providerPrivateDetails string
+		var planId, serviceId, serviceName, name, humanName, description, engineVersion, engineType, scheme, categories, costUnits, provider, attributes, providerPrivateDetails, configVarNames, logging, schemas, migrateTo, parameterPolicy string
 		var costInCents, preprovision int
-		var beta, deprecated, installInsidePrivateNetwork, installOutsidePrivateNetwork, supportsMultipleInstallations, supportsSharing bool
+		var ttl, bindingTTL int64
+		var beta, deprecated, installInsidePrivateNetwork, installOutsidePrivateNetwork, supportsMultipleInstallations, supportsSharing, retired bool
 		var created, updated time.Time
 
-		err := rows.Scan(&planId, &serviceId, &serviceName, &name, &humanName, &description, &engineVersion, &engineType, &scheme, &categories, &costInCents, &costUnits, &attributes, &installInsidePrivateNetwork, &installOutsidePrivateNetwork, &supportsMultipleInstallations, &supportsSharing, &preprovision, &beta, &provider, &providerPrivateDetails, &deprecated)
+		err := rows.Scan(&planId, &serviceId, &serviceName, &name, &humanName, &description, &engineVersion, &engineType, &scheme, &categories, &costInCents, &costUnits, &attributes, &installInsidePrivateNetwork, &installOutsidePrivateNetwork, &supportsMultipleInstallations, &supportsSharing, &preprovision, &beta, &provider, &providerPrivateDetails, &deprecated, &configVarNames, &logging, &ttl, &schemas, &bindingTTL, &retired, &migrateTo, &parameterPolicy)
 		if err != nil {
 			glog.Errorf("Scan from query failed: %s\n", err.Error())
 			return nil, err
 		}
+		if providerPrivateDetails, err = decryptPlanDetails(providerPrivateDetails); err != nil {
+			glog.Errorf("Unable to decrypt the details of plan %s: %s\n", name, err.Error())
+			return nil, err
+		}
 		var free = falsePtr()
 		if costInCents == 0 {
 			free = truePtr()
@@ -295,6 +871,30 @@ func (b *PostgresStorage) getPlans(subquery string, arg string) ([]ProviderPlan,
 			glog.Errorf("Unable to unmarshal attributes in plans query: %s\n", err.Error())
 			return nil, err
 		}
+		var configVarNamesJson map[string]string
+		if err = json.Unmarshal([]byte(configVarNames), &configVarNamesJson); err != nil {
+			glog.Errorf("Unable to unmarshal config var names in plans query: %s\n", err.Error())
+			return nil, err
+		}
+		var loggingTier *LoggingTier
+		if logging != "" && logging != "null" {
+			if err = json.Unmarshal([]byte(logging), &loggingTier); err != nil {
+				glog.Errorf("Unable to unmarshal logging in plans query: %s\n", err.Error())
+				return nil, err
+			}
+		}
+		var planSchemas *PlanSchemas
+		if schemas != "" && schemas != "null" {
+			if err = json.Unmarshal([]byte(schemas), &planSchemas); err != nil {
+				glog.Errorf("Unable to unmarshal schemas in plans query: %s\n", err.Error())
+				return nil, err
+			}
+		}
+		policy, err := ParseParameterPolicy([]byte(parameterPolicy))
+		if err != nil {
+			glog.Errorf("Unable to read the parameter policy in plans query: %s\n", err.Error())
+			return nil, err
+		}
 		var state = "ga"
 		if beta == true {
 			state = "beta"
@@ -302,17 +902,12 @@ func (b *PostgresStorage) getPlans(subquery string, arg string) ([]ProviderPlan,
 		if deprecated == true {
 			state = "deprecated"
 		}
-		plans = append(plans, ProviderPlan{
+		plan := ProviderPlan{
 			basePlan: osb.Plan{
 				ID:          planId,
 				Name:        name,
 				Description: description,
 				Free:        free,
-				Schemas: &osb.Schemas{
-					ServiceInstance: &osb.ServiceInstanceSchema{
-						Create: &osb.InputParametersSchema{},
-					},
-				},
 				Metadata: map[string]interface{}{
 					"addon_service": map[string]interface{}{
 						"id":   serviceId,
@@ -345,13 +940,29 @@ func (b *PostgresStorage) getPlans(subquery string, arg string) ([]ProviderPlan,
 			Scheme:                 scheme,
 			providerPrivateDetails: os.ExpandEnv(providerPrivateDetails),
 			ID:                     planId,
-		})
+			ConfigVarNames:         configVarNamesJson,
+			Logging:                loggingTier,
+			TTL:                    time.Duration(ttl) * time.Second,
+			BindingTTL:             time.Duration(bindingTTL) * time.Second,
+			Retired:                retired,
+			Deprecated:             deprecated,
+			MigrateTo:              migrateTo,
+			ParameterPolicy:        policy,
+		}
+		plan.Schemas = planSchemas.WithDefaults(&plan)
+		plan.basePlan.Schemas = plan.Schemas.OSB()
+		plan.basePlan.Metadata["maintenance_info"] = plan.MaintenanceInfo()
+		plans = append(plans, plan)
 	}
 	return plans, nil
 }
 
 func (b *PostgresStorage) GetServices() ([]osb.Service, error) {
 	services := make([]osb.Service, 0)
+	benchmarks, err := b.GetPlanBenchmarks()
+	if err != nil {
+		return nil, err
+	}
 
 	rows, err := b.db.Query(servicesQuery)
 	if err != nil {
@@ -375,6 +986,12 @@ func (b *PostgresStorage) GetServices() ([]osb.Service, error) {
 
 		osbPlans := make([]osb.Plan, 0)
 		for _, plan := range plans {
+			if costs := planCosts(&plan); costs != nil {
+				plan.basePlan.Metadata["costs"] = costs
+			}
+			if benchmark, ok := benchmarks[plan.ID]; ok {
+				plan.basePlan.Metadata["benchmark"] = benchmark
+			}
 			osbPlans = append(osbPlans, plan.basePlan)
 		}
 		services = append(services, osb.Service{
@@ -386,8 +1003,11 @@ func (b *PostgresStorage) GetServices() ([]osb.Service, error) {
 			PlanUpdatable:       truePtr(),
 			Tags:                strings.Split(plan_categories, ","),
 			Metadata: map[string]interface{}{
-				"name":  plan_human_name,
-				"image": plan_image,
+				"name":      plan_human_name,
+				"image":     plan_image,
+				"shareable": true,
+				// lifted into the service by MaintenanceInfoMiddleware
+				"instances_retrievable": true,
 			},
 			Plans: osbPlans,
 		})
@@ -407,7 +1027,7 @@ func (b *PostgresStorage) GetPlanByID(planId string) (*ProviderPlan, error) {
 }
 
 func (b *PostgresStorage) GetPlans(serviceId string) ([]ProviderPlan, error) {
-	return b.getPlans(" and services.service::varchar(1024) = $1::varchar(1024) order by plans.name", serviceId)
+	return b.getPlans(" and plans.retired = false and plans.deprecated = false and services.service::varchar(1024) = $1::varchar(1024) order by plans.name", serviceId)
 }
 
 func (b *PostgresStorage) IsUpgrading(dbId string) (bool, error) {
@@ -422,6 +1042,101 @@ func (b *PostgresStorage) IsRestoring(dbId string) (bool, error) {
     return count > 0, err
 }
 
+func (b *PostgresStorage) IsDeleting(dbId string) (bool, error) {
+    var count int64
+    err := b.db.QueryRow("select count(*) from tasks where ( status = 'started' or status = 'pending' ) and (action = 'delete' OR action = 'run-pre-deprovision-hook') and deleted = false and resource = $1", dbId).Scan(&count)
+    return count > 0, err
+}
+
+func (b *PostgresStorage) GetLastTask(dbId string, action TaskAction) (*Task, error) {
+	var task Task
+	err := b.db.QueryRow(`
+        select task, action, resource, status, retries, metadata, result, started, finished
+        from tasks
+        where resource = $1 and action = $2 and deleted = false
+        order by created desc limit 1
+    `, dbId, action).Scan(&task.Id, &task.Action, &task.ResourceId, &task.Status, &task.Retries, &task.Metadata, &task.Result, &task.Started, &task.Finished)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		return nil, errors.New("Not found")
+	} else if err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+func (b *PostgresStorage) GetLastTaskIn(dbId string, actions []TaskAction) (*Task, error) {
+	names := make([]string, 0)
+	for _, action := range actions {
+		names = append(names, string(action))
+	}
+	var task Task
+	err := b.db.QueryRow(`
+        select task, action, resource, status, retries, metadata, result, started, finished
+        from tasks
+        where resource = $1 and action = any(string_to_array($2, ',')) and deleted = false
+        order by created desc limit 1
+    `, dbId, strings.Join(names, ",")).Scan(&task.Id, &task.Action, &task.ResourceId, &task.Status, &task.Retries, &task.Metadata, &task.Result, &task.Started, &task.Finished)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		return nil, errors.New("Not found")
+	} else if err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+func (b *PostgresStorage) FailStuckTasks(action TaskAction, minutes int64, result string) ([]Task, error) {
+	rows, err := b.db.Query(`
+        update tasks set
+            status = 'failed',
+            finished = now(),
+            result = $3 || ' (' || result || ')'
+        where
+            action = $1 and
+            ( status = 'pending' or status = 'started' ) and
+            created < now() - ($2 * interval '1 minute') and
+            deleted = false
+        returning task, action, resource, status, retries, metadata, result, started, finished
+    `, action, minutes, result)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tasks := make([]Task, 0)
+	for rows.Next() {
+		var task Task
+		if err = rows.Scan(&task.Id, &task.Action, &task.ResourceId, &task.Status, &task.Retries, &task.Metadata, &task.Result, &task.Started, &task.Finished); err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+// decryptEntry decrypts the credentials of an entry read from the resources table.
+func decryptEntry(entry *Entry) error {
+	var err error
+	if entry.Username, err = DecryptCredential(entry.Username); err != nil {
+		return err
+	}
+	if entry.Password, err = DecryptCredential(entry.Password); err != nil {
+		return err
+	}
+	return nil
+}
+
+// encryptCredentials returns the username and password of an instance to store.
+func encryptCredentials(Instance *Instance) (string, string, error) {
+	username, err := EncryptCredential(Instance.Username)
+	if err != nil {
+		return "", "", err
+	}
+	password, err := EncryptCredential(Instance.Password)
+	if err != nil {
+		return "", "", err
+	}
+	return username, password, nil
+}
+
 func (b *PostgresStorage) GetUnclaimedInstance(PlanId string, InstanceId string) (*Entry, error) {
 	tx, err := b.db.Begin()
 	if err != nil {
@@ -452,49 +1167,114 @@ func (b *PostgresStorage) GetUnclaimedInstance(PlanId string, InstanceId string)
 		return nil, err
 	}
 
+	claimed := true
+	if err = recordEvent(tx, InstanceId, ClaimedEvent, EventData{Name: &entry.Name, Plan: &entry.PlanId, Status: &entry.Status, Endpoint: &entry.Endpoint, Claimed: &claimed, From: entry.Id}); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
     entry.Claimed = true
 	entry.Id = InstanceId
 
 	if err = tx.Commit(); err != nil {
 		return nil, err
 	}
+	if err = decryptEntry(&entry); err != nil {
+		return nil, err
+	}
 	return &entry, err
 }
 
+// ReturnClaimedInstance puts a claimed instance back in the preprovisioned pool under a new
+// id, the stream of Id ends with it being returned and the stream of the new id starts with
+// its state.
 func (b *PostgresStorage) ReturnClaimedInstance(Id string) error {
-	rows, err := b.db.Exec("update resources set claimed = false, id = uuid_generate_v4()::varchar(1024) where id = $1 and status = 'available' and deleted = false and claimed = true", Id)
+	tx, err := b.db.Begin()
 	if err != nil {
 		return err
 	}
-	count, err := rows.RowsAffected()
-	if err != nil {
+	var entry Entry
+	err = tx.QueryRow("update resources set claimed = false, id = uuid_generate_v4()::varchar(1024) where id = $1 and status = 'available' and deleted = false and claimed = true returning id, name, plan, status, endpoint", Id).Scan(&entry.Id, &entry.Name, &entry.PlanId, &entry.Status, &entry.Endpoint)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		tx.Rollback()
+		return errors.New("invalid count returned after trying to return unclaimed db " + Id)
+	} else if err != nil {
+		tx.Rollback()
 		return err
 	}
-	if count != 1 {
-		return errors.New("invalid count returned after trying to return unclaimed db " + Id)
+	if err = recordEvent(tx, Id, ReturnedEvent, EventData{}); err != nil {
+		tx.Rollback()
+		return err
 	}
-	return nil
+	if err = recordEvent(tx, entry.Id, PreprovisioningEvent, EventData{Name: &entry.Name, Plan: &entry.PlanId, Status: &entry.Status, Endpoint: &entry.Endpoint, Owner: stringPtr(PreprovisionedOwner), Claimed: falsePtr(), From: Id}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
 
 func (b *PostgresStorage) AddInstance(Instance *Instance) error {
-	_, err := b.db.Exec("insert into resources (id, name, plan, claimed, status, username, password, endpoint) values ($1, $2, $3, true, $4, $5, $6, $7)", Instance.Id, Instance.Name, Instance.Plan.ID, Instance.Status, Instance.Username, Instance.Password, Instance.Endpoint)
-	return err
-}
+	username, password, err := encryptCredentials(Instance)
+	if err != nil {
+		return err
+	}
+	return b.withEvent(Instance.Id, ProvisionedEvent, instanceEventData(Instance, Instance.Plan.ID, true), func(tx *sql.Tx) error {
+		_, err := tx.Exec("insert into resources (id, name, plan, claimed, status, username, password, endpoint, owner) values ($1, $2, $3, true, $4, $5, $6, $7, $8)", Instance.Id, Instance.Name, Instance.Plan.ID, Instance.Status, username, password, Instance.Endpoint, Instance.Owner)
+		return err
+	})
+}
 
 func (b *PostgresStorage) NukeInstance(Id string) error {
-	_, err := b.db.Exec("delete from resources where id = $1", Id)
-	return err
+	return b.withEvent(Id, NukedEvent, EventData{}, func(tx *sql.Tx) error {
+		_, err := tx.Exec("delete from resources where id = $1", Id)
+		return err
+	})
 }
 
+// DeleteInstance marks the instance deleted by recording its deprovisioned event.
 func (b *PostgresStorage) DeleteInstance(Instance *Instance) error {
-	b.db.Exec("update tasks set deleted = true where resource = $1", Instance.Id)
-	_, err := b.db.Exec("update resources set deleted = true where id = $1", Instance.Id)
-	return err
+	return b.withEvent(Instance.Id, DeprovisionedEvent, EventData{}, func(tx *sql.Tx) error {
+		_, err := tx.Exec("update tasks set deleted = true where resource = $1", Instance.Id)
+		return err
+	})
 }
 
+// UpdateInstance stores the credentials of the instance and records an event with the name,
+// plan, status and endpoint if they changed, only then is the instance updated. This is
+// called on every status check.
 func (b *PostgresStorage) UpdateInstance(Instance *Instance, PlanId string) error {
-	_, err := b.db.Exec("update resources set plan = $1, endpoint = $2, status = $3, username = $4, password = $5, name = $6 where id = $7", PlanId, Instance.Endpoint, Instance.Status, Instance.Username, Instance.Password, Instance.Name, Instance.Id)
-	return err
+	username, password, err := encryptCredentials(Instance)
+	if err != nil {
+		return err
+	}
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+	var entry Entry
+	err = tx.QueryRow("select name, plan, status, endpoint from resources where id = $1 for update", Instance.Id).Scan(&entry.Name, &entry.PlanId, &entry.Status, &entry.Endpoint)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		tx.Rollback()
+		return nil
+	} else if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err = tx.Exec("update resources set username = $2, password = $3 where id = $1", Instance.Id, username, password); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if data, changed := diffEventData(&entry, Instance, PlanId); changed {
+		if err = recordEvent(tx, Instance.Id, UpdatedEvent, data); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (b *PostgresStorage) UpdateOwner(Id string, Owner string) error {
+	return b.withEvent(Id, OwnerChangedEvent, EventData{Owner: &Owner}, nil)
 }
 
 func (b *PostgresStorage) ValidateInstanceID(id string) error {
@@ -518,6 +1298,7 @@ func (b *PostgresStorage) StartProvisioningTasks() ([]Entry, error) {
             plans join services on plans.service = services.service 
         where 
             plans.deprecated = false and 
+            plans.retired = false and 
             plans.deleted = false and 
             services.deleted = false and 
             services.deprecated = false
@@ -538,30 +1319,70 @@ func (b *PostgresStorage) StartProvisioningTasks() ([]Entry, error) {
 			return nil, err
 		}
 		for i := 0; i < needed; i++ {
-			var entry Entry
-			if err := b.db.QueryRow("insert into resources (id, name, plan, claimed, status, username, password, endpoint) values (uuid_generate_v4(), '', $1, false, 'provisioning', '', '', '') returning id", planId).Scan(&entry.Id); err != nil {
+			entry, err := b.addPreprovisionedInstance(planId)
+			if err != nil {
 				glog.Infof("Unable to insert resource entry for preprovisioning: %s\n", err.Error())
 			} else {
-				entry.PlanId = planId
-				entries = append(entries, entry)
+				entries = append(entries, *entry)
 			}
 		}
 	}
 	return entries, nil
 }
 
+func (b *PostgresStorage) addPreprovisionedInstance(planId string) (*Entry, error) {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// The owner is stored before the plan is rendered with it, so an update renders the same plan.
+	entry := Entry{PlanId: planId, Owner: PreprovisionedOwner}
+	if err = tx.QueryRow("insert into resources (id, name, plan, claimed, status, username, password, endpoint, owner) values (uuid_generate_v4(), '', $1, false, 'provisioning', '', '', '', $2) returning id", planId, entry.Owner).Scan(&entry.Id); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err = recordEvent(tx, entry.Id, PreprovisioningEvent, EventData{Name: stringPtr(""), Plan: &entry.PlanId, Status: stringPtr("provisioning"), Endpoint: stringPtr(""), Owner: &entry.Owner, Claimed: falsePtr()}); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return &entry, tx.Commit()
+}
+
 func (b *PostgresStorage) GetInstance(Id string) (*Entry, error) {
 	var entry Entry
-	err := b.db.QueryRow("select id, name, plan, claimed, status, username, password, endpoint, (select count(*) from tasks where tasks.resource=resources.id and tasks.status = 'started' and tasks.deleted = false) as tasks from resources where id = $1 and deleted = false", Id).Scan(&entry.Id, &entry.Name, &entry.PlanId, &entry.Claimed, &entry.Status, &entry.Username, &entry.Password, &entry.Endpoint, &entry.Tasks)
+	err := b.db.QueryRow("select id, name, plan, claimed, status, username, password, endpoint, owner, coalesce(parameters::text, ''), (select count(*) from tasks where tasks.resource=resources.id and tasks.status = 'started' and tasks.deleted = false) as tasks from resources where id = $1 and deleted = false", Id).Scan(&entry.Id, &entry.Name, &entry.PlanId, &entry.Claimed, &entry.Status, &entry.Username, &entry.Password, &entry.Endpoint, &entry.Owner, &entry.Parameters, &entry.Tasks)
 
 	if err != nil && err.Error() == "sql: no rows in result set" {
 		return nil, errors.New("Cannot find resource instance")
 	} else if err != nil {
 		return nil, err
 	}
+	if err = decryptEntry(&entry); err != nil {
+		return nil, err
+	}
 	return &entry, nil
 }
 
+func (b *PostgresStorage) GetInstances() ([]Entry, error) {
+	rows, err := b.db.Query("select id, name, plan, claimed, status, username, password, endpoint, owner from resources where deleted = false and name != '' order by created")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := make([]Entry, 0)
+	for rows.Next() {
+		var entry Entry
+		if err = rows.Scan(&entry.Id, &entry.Name, &entry.PlanId, &entry.Claimed, &entry.Status, &entry.Username, &entry.Password, &entry.Endpoint, &entry.Owner); err != nil {
+			return nil, err
+		}
+		if err = decryptEntry(&entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
 func (b *PostgresStorage) AddTask(Id string, action TaskAction, metadata string) (string, error) {
 	var task_id string
 	return task_id, b.db.QueryRow("insert into tasks (task, resource, action, metadata) values (uuid_generate_v4(), $1, $2, $3) returning task", Id, action, metadata).Scan(&task_id)
@@ -584,14 +1405,30 @@ func (b *PostgresStorage) WarnOnUnfinishedTasks() {
 	}
 }
 
+// PopPendingTask starts the next pending task, owners take turns so one owner queueing many
+// tasks (e.g., creating an environment) does not starve the others. The owner whose last task
+// started the longest ago goes first, then the oldest task of that owner.
 func (b *PostgresStorage) PopPendingTask() (*Task, error) {
 	var task Task
 	err := b.db.QueryRow(`
+        with last_started as (
+            select coalesce(resources.owner, '') as owner, max(tasks.started) as started
+            from tasks left join resources on resources.id = tasks.resource
+            where tasks.started > now() - interval '1 day'
+            group by coalesce(resources.owner, '')
+        )
         update tasks set 
             status = 'started', 
             started = now() 
         where 
-            task in ( select task from tasks where status = 'pending' and deleted = false order by updated asc limit 1)
+            task in ( 
+                select tasks.task from tasks 
+                    left join resources on resources.id = tasks.resource 
+                    left join last_started on last_started.owner = coalesce(resources.owner, '')
+                where tasks.status = 'pending' and tasks.deleted = false 
+                order by last_started.started asc nulls first, tasks.updated asc 
+                limit 1
+            )
         returning task, action, resource, status, retries, metadata, result, started, finished
     `).Scan(&task.Id, &task.Action, &task.ResourceId, &task.Status, &task.Retries, &task.Metadata, &task.Result, &task.Started, &task.Finished)
 	if err != nil {
@@ -600,28 +1437,1695 @@ func (b *PostgresStorage) PopPendingTask() (*Task, error) {
 	return &task, nil
 }
 
-func InitStorage(ctx context.Context, o Options) (*PostgresStorage, error) {
-	// Sanity checks
-	if o.DatabaseUrl == "" && os.Getenv("DATABASE_URL") != "" {
-		o.DatabaseUrl = os.Getenv("DATABASE_URL")
+// LockInstanceOperations takes the operations lock of an instance, a transaction level advisory
+// lock held until the returned func is called. It returns false without waiting if another
+// request (in any broker process) holds it.
+func (b *PostgresStorage) LockInstanceOperations(dbId string) (func(), bool, error) {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return nil, false, err
 	}
-	if o.DatabaseUrl == "" {
-		return nil, errors.New("Unable to connect to resource, none was specified in the environment via resource_URL or through the -resource cli option.")
+	var locked bool
+	if err = tx.QueryRow("select pg_try_advisory_xact_lock(hashtext('operations:' || $1))", dbId).Scan(&locked); err != nil {
+		tx.Rollback()
+		return nil, false, err
 	}
-	db, err := sql.Open("postgres", o.DatabaseUrl)
+	if !locked {
+		tx.Rollback()
+		return nil, false, nil
+	}
+	// nothing is written in the transaction, ending it releases the lock
+	return func() { tx.Rollback() }, true, nil
+}
+
+// GetTaskInProgress returns the oldest pending or started task of the actions.
+func (b *PostgresStorage) GetTaskInProgress(dbId string, actions []TaskAction) (*Task, error) {
+	names := make([]string, 0)
+	for _, action := range actions {
+		names = append(names, string(action))
+	}
+	var task Task
+	err := b.db.QueryRow(`
+        select task, action, resource, status, retries, metadata, result, started, finished
+        from tasks
+        where resource = $1 and action = any(string_to_array($2, ',')) and (status = 'pending' or status = 'started') and deleted = false
+        order by created asc limit 1
+    `, dbId, strings.Join(names, ",")).Scan(&task.Id, &task.Action, &task.ResourceId, &task.Status, &task.Retries, &task.Metadata, &task.Result, &task.Started, &task.Finished)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		return nil, errors.New("Not found")
+	} else if err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+const hooksQuery string = `
+select
+    hook,
+    plan,
+    stage,
+    type,
+    method,
+    url,
+    body,
+    secret,
+    retries
+from hooks where deleted = false `
+
+func (b *PostgresStorage) getHooks(subquery string, args ...interface{}) ([]Hook, error) {
+	rows, err := b.db.Query(hooksQuery+subquery, args...)
 	if err != nil {
-		glog.Errorf("Unable to create resource schema: %s\n", err.Error())
-		return nil, errors.New("Unable to create resource schema: " + err.Error())
+		return nil, err
 	}
+	defer rows.Close()
+	hooks := make([]Hook, 0)
+	for rows.Next() {
+		var hook Hook
+		if err = rows.Scan(&hook.Id, &hook.PlanId, &hook.Stage, &hook.Type, &hook.Method, &hook.Url, &hook.Body, &hook.Secret, &hook.Retries); err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
 
-	_, err = db.Exec(sqlCreateScript)
+func (b *PostgresStorage) GetHooks(planId string, stage HookStage) ([]Hook, error) {
+	return b.getHooks("and plan = $1 and stage = $2 order by ordinal, created", planId, string(stage))
+}
+
+func (b *PostgresStorage) GetHook(Id string) (*Hook, error) {
+	hooks, err := b.getHooks("and hook::varchar(1024) = $1::varchar(1024)", Id)
 	if err != nil {
 		return nil, err
 	}
+	if len(hooks) == 0 {
+		return nil, errors.New("Not found")
+	}
+	return &hooks[0], nil
+}
 
-	go cancelOnInterrupt(ctx, db)
+func (b *PostgresStorage) GetFederatedInstance(Id string) (string, error) {
+	var name string
+	err := b.db.QueryRow("select broker from federated_instances where id = $1", Id).Scan(&name)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		return "", errors.New("Not found")
+	}
+	return name, err
+}
 
-	return &PostgresStorage{
-		db: db,
-	}, nil
+func (b *PostgresStorage) AddFederatedInstance(Id string, Broker string) error {
+	_, err := b.db.Exec("insert into federated_instances (id, broker) values ($1, $2) on conflict (id) do nothing", Id, Broker)
+	return err
+}
+
+func (b *PostgresStorage) AddBinding(InstanceId string, BindingId string, Parameters string) error {
+	_, err := b.db.Exec("insert into bindings (binding, resource, parameters) values ($2, $1, $3) on conflict (binding) do update set parameters = $3, deleted = false", InstanceId, BindingId, Parameters)
+	return err
+}
+
+func (b *PostgresStorage) GetBindingParameters(InstanceId string, BindingId string) (string, error) {
+	var parameters string
+	err := b.db.QueryRow("select parameters from bindings where resource = $1 and binding = $2 and deleted = false", InstanceId, BindingId).Scan(&parameters)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		return "", errors.New("Not found")
+	}
+	return parameters, err
+}
+
+// SetBindingCredentials stores the credentials (as json) given to the binding, they are
+// encrypted the same way the instance credentials are.
+func (b *PostgresStorage) SetBindingCredentials(InstanceId string, BindingId string, Credentials string) error {
+	encrypted, err := EncryptCredential(Credentials)
+	if err != nil {
+		return err
+	}
+	_, err = b.db.Exec("update bindings set credentials = $3 where resource = $1 and binding = $2 and deleted = false", InstanceId, BindingId, encrypted)
+	return err
+}
+
+// GetBindingCredentials returns the credentials (as json) given to the binding, or an empty
+// string if the binding was created before they were stored.
+func (b *PostgresStorage) GetBindingCredentials(InstanceId string, BindingId string) (string, error) {
+	var credentials string
+	err := b.db.QueryRow("select coalesce(credentials, '') from bindings where resource = $1 and binding = $2 and deleted = false", InstanceId, BindingId).Scan(&credentials)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		return "", errors.New("Not found")
+	} else if err != nil {
+		return "", err
+	}
+	return DecryptCredential(credentials)
+}
+
+// SetBindingStatus records the status of an asynchronous bind or unbind and its result.
+func (b *PostgresStorage) SetBindingStatus(InstanceId string, BindingId string, Status string, Result string) error {
+	_, err := b.db.Exec("update bindings set status = $3, result = $4 where resource = $1 and binding = $2 and deleted = false", InstanceId, BindingId, Status, Result)
+	return err
+}
+
+// SetBindingDeactivation sets the status of a binding that was deactivated or reactivated and
+// records the event of who did it and why.
+func (b *PostgresStorage) SetBindingDeactivation(InstanceId string, BindingId string, Status string, Result string, eventType EventType, data EventData) error {
+	return b.withEvent(InstanceId, eventType, data, func(tx *sql.Tx) error {
+		_, err := tx.Exec("update bindings set status = $3, result = $4 where resource = $1 and binding = $2 and deleted = false", InstanceId, BindingId, Status, Result)
+		return err
+	})
+}
+
+func (b *PostgresStorage) GetBindingStatus(InstanceId string, BindingId string) (string, string, error) {
+	var status, result string
+	err := b.db.QueryRow("select status, result from bindings where resource = $1 and binding = $2 and deleted = false", InstanceId, BindingId).Scan(&status, &result)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		return "", "", errors.New("Not found")
+	}
+	return status, result, err
+}
+
+func (b *PostgresStorage) SetBindingUser(InstanceId string, BindingId string, Username string) error {
+	_, err := b.db.Exec("update bindings set username = $3 where resource = $1 and binding = $2 and deleted = false", InstanceId, BindingId, Username)
+	return err
+}
+
+func (b *PostgresStorage) GetBindingUser(InstanceId string, BindingId string) (string, error) {
+	var username string
+	err := b.db.QueryRow("select username from bindings where resource = $1 and binding = $2 and deleted = false", InstanceId, BindingId).Scan(&username)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		return "", errors.New("Not found")
+	}
+	return username, err
+}
+
+func (b *PostgresStorage) SetBindingExpires(InstanceId string, BindingId string, Expires *time.Time) error {
+	_, err := b.db.Exec("update bindings set expires = $3, expiry_warned = false where resource = $1 and binding = $2 and deleted = false", InstanceId, BindingId, Expires)
+	return err
+}
+
+// GetExpiredBindings returns the bound bindings past their expiry.
+func (b *PostgresStorage) GetExpiredBindings() ([]ExpiredBinding, error) {
+	return b.getExpiringBindings("select resource, binding, expires from bindings where deleted = false and status = 'bound' and expires < now()")
+}
+
+// GetExpiringBindings returns the bound bindings that expire before the time and have not
+// been warned of it.
+func (b *PostgresStorage) GetExpiringBindings(before time.Time) ([]ExpiredBinding, error) {
+	return b.getExpiringBindings("select resource, binding, expires from bindings where deleted = false and status = 'bound' and expiry_warned = false and expires >= now() and expires < $1", before)
+}
+
+func (b *PostgresStorage) SetBindingExpiryWarned(InstanceId string, BindingId string) error {
+	_, err := b.db.Exec("update bindings set expiry_warned = true where resource = $1 and binding = $2 and deleted = false", InstanceId, BindingId)
+	return err
+}
+
+func (b *PostgresStorage) getExpiringBindings(query string, args ...interface{}) ([]ExpiredBinding, error) {
+	rows, err := b.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	bindings := make([]ExpiredBinding, 0)
+	for rows.Next() {
+		var binding ExpiredBinding
+		if err = rows.Scan(&binding.InstanceId, &binding.BindingId, &binding.Expires); err != nil {
+			return nil, err
+		}
+		bindings = append(bindings, binding)
+	}
+	return bindings, nil
+}
+
+// RevokeBinding marks a binding as expired and forgets its credentials and user.
+func (b *PostgresStorage) RevokeBinding(InstanceId string, BindingId string) error {
+	_, err := b.db.Exec("update bindings set status = 'expired', credentials = null, username = '' where resource = $1 and binding = $2 and deleted = false", InstanceId, BindingId)
+	return err
+}
+
+func (b *PostgresStorage) DeleteBinding(InstanceId string, BindingId string) error {
+	_, err := b.db.Exec("update bindings set deleted = true where resource = $1 and binding = $2", InstanceId, BindingId)
+	return err
+}
+
+const associationsQuery string = `
+select
+    association,
+    resource,
+    vpc_id,
+    account_id,
+    region,
+    cidr,
+    peering_connection_id,
+    status,
+    message
+from associations where deleted = false and resource = $1 `
+
+func (b *PostgresStorage) getAssociations(subquery string, args ...interface{}) ([]Association, error) {
+	rows, err := b.db.Query(associationsQuery+subquery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	associations := make([]Association, 0)
+	for rows.Next() {
+		var a Association
+		if err = rows.Scan(&a.Id, &a.InstanceId, &a.VpcId, &a.AccountId, &a.Region, &a.Cidr, &a.PeeringConnectionId, &a.Status, &a.Message); err != nil {
+			return nil, err
+		}
+		associations = append(associations, a)
+	}
+	return associations, nil
+}
+
+func (b *PostgresStorage) GetAssociations(InstanceId string) ([]Association, error) {
+	return b.getAssociations("order by created", InstanceId)
+}
+
+func (b *PostgresStorage) GetAssociation(InstanceId string, Id string) (*Association, error) {
+	associations, err := b.getAssociations("and association::varchar(1024) = $2::varchar(1024)", InstanceId, Id)
+	if err != nil {
+		return nil, err
+	}
+	if len(associations) == 0 {
+		return nil, errors.New("Not found")
+	}
+	return &associations[0], nil
+}
+
+func (b *PostgresStorage) AddAssociation(a *Association) (string, error) {
+	var id string
+	err := b.db.QueryRow("insert into associations (resource, vpc_id, account_id, region, cidr, peering_connection_id, status, message) values ($1, $2, $3, $4, $5, $6, $7, $8) returning association", a.InstanceId, a.VpcId, a.AccountId, a.Region, a.Cidr, a.PeeringConnectionId, a.Status, a.Message).Scan(&id)
+	return id, err
+}
+
+func (b *PostgresStorage) DeleteAssociation(InstanceId string, Id string) error {
+	_, err := b.db.Exec("update associations set deleted = true where resource = $1 and association::varchar(1024) = $2::varchar(1024)", InstanceId, Id)
+	return err
+}
+
+// ClaimSchedule returns true if the named schedule is due and moves it forward by
+// interval, only one caller (e.g., of many workers) can claim each run.
+func (b *PostgresStorage) ClaimSchedule(name string, interval time.Duration) (bool, error) {
+	if _, err := b.db.Exec("insert into schedules (name) values ($1) on conflict (name) do nothing", name); err != nil {
+		return false, err
+	}
+	rows, err := b.db.Query("update schedules set next_run = now() + make_interval(secs => $2) where name = $1 and next_run <= now() returning name", name, interval.Seconds())
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	return rows.Next(), nil
+}
+
+func (b *PostgresStorage) AddIndexSamples(Id string, samples []IndexSample) error {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, sample := range samples {
+		if _, err = tx.Exec("insert into index_samples (resource, index_name, bytes, docs) values ($1, $2, $3, $4)", Id, sample.Index, sample.Bytes, sample.Docs); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (b *PostgresStorage) AddStorageSample(Id string, sample StorageSample) error {
+	_, err := b.db.Exec("insert into storage_samples (resource, used_bytes, total_bytes) values ($1, $2, $3)", Id, sample.UsedBytes, sample.TotalBytes)
+	return err
+}
+
+func (b *PostgresStorage) GetStorageSamples(Id string, since time.Time) ([]StorageSample, error) {
+	rows, err := b.db.Query("select used_bytes, total_bytes, sampled from storage_samples where resource = $1 and sampled >= $2 order by sampled asc", Id, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	samples := make([]StorageSample, 0)
+	for rows.Next() {
+		var sample StorageSample
+		if err = rows.Scan(&sample.UsedBytes, &sample.TotalBytes, &sample.Sampled); err != nil {
+			return nil, err
+		}
+		samples = append(samples, sample)
+	}
+	return samples, nil
+}
+
+// GetIndexGrowth returns the latest size of each index that existed in the most recent
+// sample and how much it grew since the first sample after since.
+func (b *PostgresStorage) GetIndexGrowth(Id string, since time.Time) ([]IndexGrowth, error) {
+	rows, err := b.db.Query(`
+		select
+			index_name,
+			(array_agg(bytes order by sampled desc))[1],
+			(array_agg(docs order by sampled desc))[1],
+			(array_agg(bytes order by sampled desc))[1] - (array_agg(bytes order by sampled asc))[1]
+		from index_samples
+		where resource = $1 and sampled >= $2
+		group by index_name
+		having max(sampled) = (select max(sampled) from index_samples where resource = $1)`, Id, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	indices := make([]IndexGrowth, 0)
+	for rows.Next() {
+		var index IndexGrowth
+		if err = rows.Scan(&index.Index, &index.Bytes, &index.Docs, &index.GrowthBytes); err != nil {
+			return nil, err
+		}
+		indices = append(indices, index)
+	}
+	return indices, nil
+}
+
+func (b *PostgresStorage) PruneSamples(before time.Time) error {
+	if _, err := b.db.Exec("delete from index_samples where sampled < $1", before); err != nil {
+		return err
+	}
+	_, err := b.db.Exec("delete from storage_samples where sampled < $1", before)
+	return err
+}
+
+func (b *PostgresStorage) GetReadOnlyRemediation(Id string) (bool, error) {
+	var enabled bool
+	err := b.db.QueryRow("select remediate_read_only from resources where id = $1 and deleted = false", Id).Scan(&enabled)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		return false, errors.New("Cannot find resource instance")
+	}
+	return enabled, err
+}
+
+func (b *PostgresStorage) SetReadOnlyRemediation(Id string, enabled bool) error {
+	rows, err := b.db.Query("update resources set remediate_read_only = $2 where id = $1 and deleted = false returning id", Id, enabled)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return errors.New("Cannot find resource instance")
+	}
+	return nil
+}
+
+func (b *PostgresStorage) GetFreeze(Id string) (*InstanceFreeze, error) {
+	var freeze InstanceFreeze
+	err := b.db.QueryRow("select frozen, frozen_reason from resources where id = $1 and deleted = false", Id).Scan(&freeze.Frozen, &freeze.Reason)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		return nil, errors.New("Cannot find resource instance")
+	}
+	return &freeze, err
+}
+
+// SetFreeze freezes or unfreezes an instance, the change is recorded as an event with who
+// made it and why.
+func (b *PostgresStorage) SetFreeze(Id string, freeze *InstanceFreeze) error {
+	eventType := UnfrozenEvent
+	if freeze.Frozen {
+		eventType = FrozenEvent
+	}
+	return b.setToggle(Id, "update resources set frozen = $2, frozen_reason = $3 where id = $1 and deleted = false", freeze.Frozen, freeze.Reason, eventType, freeze.Actor)
+}
+
+// setToggle sets an admin toggle (e.g., a freeze) of an instance with the update, recording the
+// change as an event.
+func (b *PostgresStorage) setToggle(Id string, update string, on bool, reason string, eventType EventType, actor string) error {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec(update, Id, on, reason)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if count, err := result.RowsAffected(); err != nil || count == 0 {
+		tx.Rollback()
+		return errors.New("Cannot find resource instance")
+	}
+	if err = recordEvent(tx, Id, eventType, EventData{Actor: actor, Reason: reason}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (b *PostgresStorage) GetLegalHold(Id string) (*LegalHold, error) {
+	var hold LegalHold
+	err := b.db.QueryRow("select legal_hold, legal_hold_reason from resources where id = $1 and deleted = false", Id).Scan(&hold.Held, &hold.Reason)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		return nil, errors.New("Cannot find resource instance")
+	}
+	return &hold, err
+}
+
+// SetLegalHold places or lifts a legal hold on the snapshots of an instance, like a freeze
+// the change is recorded as an event with who made it and why.
+func (b *PostgresStorage) SetLegalHold(Id string, hold *LegalHold) error {
+	eventType := LegalHoldLiftedEvent
+	if hold.Held {
+		eventType = LegalHoldEvent
+	}
+	return b.setToggle(Id, "update resources set legal_hold = $2, legal_hold_reason = $3 where id = $1 and deleted = false", hold.Held, hold.Reason, eventType, hold.Actor)
+}
+
+// GetOriginatingIdentity returns the identity of who provisioned an instance, or nil if the
+// platform did not send one.
+func (b *PostgresStorage) GetOriginatingIdentity(Id string) (*OriginatingIdentity, error) {
+	var identity OriginatingIdentity
+	var value sql.NullString
+	err := b.db.QueryRow("select created_by, originating_identity from resources where id = $1 and deleted = false", Id).Scan(&identity.User, &value)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		return nil, errors.New("Cannot find resource instance")
+	} else if err != nil {
+		return nil, err
+	}
+	if !value.Valid {
+		return nil, nil
+	}
+	if err = json.Unmarshal([]byte(value.String), &identity); err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (b *PostgresStorage) SetOriginatingIdentity(Id string, identity *OriginatingIdentity) error {
+	data, err := json.Marshal(identity)
+	if err != nil {
+		return err
+	}
+	_, err = b.db.Exec("update resources set created_by = $2, originating_identity = $3 where id = $1", Id, identity.User, string(data))
+	return err
+}
+
+func (b *PostgresStorage) GetContextTags(Id string) (map[string]string, error) {
+	var value sql.NullString
+	err := b.db.QueryRow("select context_tags from resources where id = $1 and deleted = false", Id).Scan(&value)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		return nil, errors.New("Cannot find resource instance")
+	} else if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]string)
+	if value.Valid {
+		if err = json.Unmarshal([]byte(value.String), &tags); err != nil {
+			return nil, err
+		}
+	}
+	return tags, nil
+}
+
+func (b *PostgresStorage) SetContextTags(Id string, tags map[string]string) error {
+	data, err := json.Marshal(tags)
+	if err != nil {
+		return err
+	}
+	_, err = b.db.Exec("update resources set context_tags = $2 where id = $1", Id, string(data))
+	return err
+}
+
+// GetClusterSettings returns the guarded cluster settings recorded at the last drift check.
+func (b *PostgresStorage) GetClusterSettings(Id string) (map[string]string, error) {
+	var data string
+	err := b.db.QueryRow("select settings from cluster_settings where resource = $1", Id).Scan(&data)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		return nil, errors.New("Not found")
+	} else if err != nil {
+		return nil, err
+	}
+	settings := make(map[string]string)
+	if err = json.Unmarshal([]byte(data), &settings); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+func (b *PostgresStorage) SetClusterSettings(Id string, settings map[string]string) error {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	_, err = b.db.Exec("insert into cluster_settings (resource, settings) values ($1, $2) on conflict (resource) do update set settings = $2, updated = now()", Id, string(data))
+	return err
+}
+
+func (b *PostgresStorage) GetCatalogServices() ([]CatalogService, error) {
+	rows, err := b.db.Query("select service, name, human_name, description, categories, image, beta, deprecated from services where deleted = false order by name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	services := make([]CatalogService, 0)
+	for rows.Next() {
+		var service CatalogService
+		if err = rows.Scan(&service.Id, &service.Name, &service.HumanName, &service.Description, &service.Categories, &service.Image, &service.Beta, &service.Deprecated); err != nil {
+			return nil, err
+		}
+		services = append(services, service)
+	}
+	return services, nil
+}
+
+// SaveCatalogService creates the service (with a new id if it has none) or updates it.
+func (b *PostgresStorage) SaveCatalogService(service *CatalogService) error {
+	return b.db.QueryRow(`
+		insert into services (service, name, human_name, description, categories, image, beta, deprecated)
+		values (coalesce(nullif($1, '')::uuid, uuid_generate_v4()), $2, $3, $4, $5, $6, $7, $8)
+		on conflict (service) do update set name = $2, human_name = $3, description = $4, categories = $5, image = $6, beta = $7, deprecated = $8
+		returning service`,
+		service.Id, service.Name, service.HumanName, service.Description, service.Categories, service.Image, service.Beta, service.Deprecated).Scan(&service.Id)
+}
+
+const catalogPlansQuery string = `
+select
+    plan, service, name, human_name, description, version, type::text, scheme::text, categories, cost_cents, cost_unit::text,
+    attributes::text, provider, installable_inside_private_network, installable_outside_private_network, preprovision,
+    config_var_names::text, coalesce(logging::text, ''), coalesce(schemas::text, ''), coalesce(ttl::text, ''),
+    coalesce(binding_ttl::text, ''), beta, deprecated, retired, coalesce(migrate_to, ''), coalesce(parameter_policy::text, '')
+from plans where deleted = false `
+
+func (b *PostgresStorage) getCatalogPlans(where string, args ...interface{}) ([]CatalogPlan, error) {
+	rows, err := b.db.Query(catalogPlansQuery+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	plans := make([]CatalogPlan, 0)
+	for rows.Next() {
+		var plan CatalogPlan
+		var attributes, configVarNames, logging, schemas, parameterPolicy string
+		if err = rows.Scan(&plan.Id, &plan.ServiceId, &plan.Name, &plan.HumanName, &plan.Description, &plan.Version, &plan.Type, &plan.Scheme, &plan.Categories, &plan.CostCents, &plan.CostUnit,
+			&attributes, &plan.Provider, &plan.InstallableInsidePrivateNetwork, &plan.InstallableOutsidePrivateNetwork, &plan.Preprovision,
+			&configVarNames, &logging, &schemas, &plan.TTL, &plan.BindingTTL, &plan.Beta, &plan.Deprecated, &plan.Retired, &plan.MigrateTo, &parameterPolicy); err != nil {
+			return nil, err
+		}
+		plan.Attributes = json.RawMessage(attributes)
+		if logging != "" {
+			plan.Logging = json.RawMessage(logging)
+		}
+		if schemas != "" {
+			plan.Schemas = json.RawMessage(schemas)
+		}
+		if parameterPolicy != "" {
+			plan.ParameterPolicy = json.RawMessage(parameterPolicy)
+		}
+		if err = json.Unmarshal([]byte(configVarNames), &plan.ConfigVarNames); err != nil {
+			return nil, err
+		}
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
+
+// GetCatalogPlans returns every plan (including retired plans), without its provider private
+// details.
+func (b *PostgresStorage) GetCatalogPlans() ([]CatalogPlan, error) {
+	return b.getCatalogPlans("order by name")
+}
+
+func (b *PostgresStorage) GetCatalogPlan(Id string) (*CatalogPlan, error) {
+	plans, err := b.getCatalogPlans("and plan::varchar(1024) = $1::varchar(1024)", Id)
+	if err != nil {
+		return nil, err
+	}
+	if len(plans) == 0 {
+		return nil, errors.New("Not found")
+	}
+	return &plans[0], nil
+}
+
+// SaveCatalogPlan creates the plan (with a new id if it has none) or updates it, the details
+// are the (possibly encrypted) provider private details, empty keeps the current details.
+func (b *PostgresStorage) SaveCatalogPlan(plan *CatalogPlan, details string) error {
+	var count int64
+	if err := b.db.QueryRow("select count(*) from services where service::varchar(1024) = $1::varchar(1024) and deleted = false", plan.ServiceId).Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.New("Not found")
+	}
+	attributes := string(plan.Attributes)
+	if attributes == "" {
+		attributes = "{}"
+	}
+	configVarNames, err := json.Marshal(plan.ConfigVarNames)
+	if err != nil {
+		return err
+	}
+	if plan.ConfigVarNames == nil {
+		configVarNames = []byte("{}")
+	}
+	if plan.Type == "" {
+		plan.Type = "elasticsearch"
+	}
+	if plan.Scheme == "" {
+		plan.Scheme = "https"
+	}
+	if plan.CostUnit == "" {
+		plan.CostUnit = "month"
+	}
+	// a new plan always has details, an update without them keeps the current details.
+	insertDetails := details
+	if insertDetails == "" {
+		insertDetails = "{}"
+	}
+	return b.db.QueryRow(`
+		insert into plans (plan, service, name, human_name, description, version, type, scheme, categories, cost_cents, cost_unit,
+			attributes, provider, provider_private_details, installable_inside_private_network, installable_outside_private_network, preprovision,
+			config_var_names, logging, schemas, ttl, binding_ttl, beta, deprecated, retired, migrate_to, parameter_policy)
+		values (coalesce(nullif($1, '')::uuid, uuid_generate_v4()), $2, $3, $4, $5, $6, $7::enginetype, $8::clientdbtype, $9, $10, $11::costunit,
+			$12::json, $13, $14::json, $15, $16, $17,
+			$18::json, nullif($19, '')::json, nullif($20, '')::json, nullif($21, '')::interval, nullif($22, '')::interval, $23, $24, $25, nullif($27, ''), nullif($28, '')::json)
+		on conflict (plan) do update set service = $2, name = $3, human_name = $4, description = $5, version = $6, type = $7::enginetype,
+			scheme = $8::clientdbtype, categories = $9, cost_cents = $10, cost_unit = $11::costunit, attributes = $12::json, provider = $13,
+			provider_private_details = coalesce(nullif($26, '')::json, plans.provider_private_details),
+			installable_inside_private_network = $15, installable_outside_private_network = $16, preprovision = $17,
+			config_var_names = $18::json, logging = nullif($19, '')::json, schemas = nullif($20, '')::json,
+			ttl = nullif($21, '')::interval, binding_ttl = nullif($22, '')::interval, beta = $23, deprecated = $24, retired = $25,
+			migrate_to = nullif($27, ''), parameter_policy = nullif($28, '')::json
+		returning plan`,
+		plan.Id, plan.ServiceId, plan.Name, plan.HumanName, plan.Description, plan.Version, plan.Type, plan.Scheme, plan.Categories, plan.CostCents, plan.CostUnit,
+		attributes, plan.Provider, insertDetails, plan.InstallableInsidePrivateNetwork, plan.InstallableOutsidePrivateNetwork, plan.Preprovision,
+		string(configVarNames), string(plan.Logging), string(plan.Schemas), plan.TTL, plan.BindingTTL, plan.Beta, plan.Deprecated, plan.Retired, details, plan.MigrateTo, string(plan.ParameterPolicy)).Scan(&plan.Id)
+}
+
+// RetirePlan removes the plan from the catalog, instances on it keep it.
+func (b *PostgresStorage) RetirePlan(Id string) error {
+	_, err := b.db.Exec("update plans set retired = true where plan::varchar(1024) = $1::varchar(1024) and deleted = false", Id)
+	return err
+}
+
+// getLinkedInstances returns the links of instances that have not been deleted, matching the
+// where clause.
+func (b *PostgresStorage) getLinkedInstances(where string, args ...interface{}) ([]LinkedInstance, error) {
+	rows, err := b.db.Query(`
+		select linked_instances.resource, linked_instances.production, linked_instances.status, linked_instances.plan,
+			linked_instances.version, linked_instances.task, linked_instances.result, linked_instances.updated
+		from linked_instances
+			join resources staging on staging.id = linked_instances.resource and staging.deleted = false
+			join resources production on production.id = linked_instances.production and production.deleted = false
+		`+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	links := make([]LinkedInstance, 0)
+	for rows.Next() {
+		var link LinkedInstance
+		if err = rows.Scan(&link.InstanceId, &link.ProductionId, &link.Status, &link.Plan, &link.Version, &link.Task, &link.Result, &link.Updated); err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, nil
+}
+
+func (b *PostgresStorage) GetLinkedInstance(Id string) (*LinkedInstance, error) {
+	links, err := b.getLinkedInstances("where linked_instances.resource = $1", Id)
+	if err != nil {
+		return nil, err
+	}
+	if len(links) == 0 {
+		return nil, errors.New("Not found")
+	}
+	return &links[0], nil
+}
+
+func (b *PostgresStorage) GetLinkedInstances() ([]LinkedInstance, error) {
+	return b.getLinkedInstances("")
+}
+
+// GetLinkedStaging returns the links of the staging instances of a production instance.
+func (b *PostgresStorage) GetLinkedStaging(ProductionId string) ([]LinkedInstance, error) {
+	return b.getLinkedInstances("where linked_instances.production = $1", ProductionId)
+}
+
+func (b *PostgresStorage) SetLinkedInstance(link *LinkedInstance) error {
+	_, err := b.db.Exec(`
+		insert into linked_instances (resource, production, status, plan, version, task, result) values ($1, $2, $3, $4, $5, $6, $7)
+		on conflict (resource) do update set production = $2, status = $3, plan = $4, version = $5, task = $6, result = $7, updated = now()`,
+		link.InstanceId, link.ProductionId, link.Status, link.Plan, link.Version, link.Task, link.Result)
+	return err
+}
+
+func (b *PostgresStorage) RemoveLinkedInstance(Id string) error {
+	_, err := b.db.Exec("delete from linked_instances where resource = $1", Id)
+	return err
+}
+
+func (b *PostgresStorage) GetReadOnlyRemediationInstances() ([]string, error) {
+	rows, err := b.db.Query("select id from resources where remediate_read_only = true and deleted = false")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// AddDomainIntent records the name of a domain before it is created, a NameCollisionError is
+// returned if another intent or instance has the name.
+func (b *PostgresStorage) AddDomainIntent(name string, resource string, plan string) error {
+	result, err := b.db.Exec("insert into domain_intents (name, resource, plan) select $1, $2, $3 where not exists (select 1 from resources where name = $1) on conflict (name) do nothing", name, resource, plan)
+	if err != nil {
+		return err
+	}
+	if count, err := result.RowsAffected(); err != nil {
+		return err
+	} else if count == 0 {
+		return &NameCollisionError{Name: name}
+	}
+	return nil
+}
+
+func (b *PostgresStorage) RemoveDomainIntent(name string) error {
+	_, err := b.db.Exec("delete from domain_intents where name = $1", name)
+	return err
+}
+
+// GetOrphanedDomainIntents returns the domains recorded over age ago that no instance has,
+// the intents of domains an instance has are removed.
+func (b *PostgresStorage) GetOrphanedDomainIntents(age time.Duration) ([]DomainIntent, error) {
+	if _, err := b.db.Exec("delete from domain_intents where exists (select 1 from resources where resources.name = domain_intents.name and resources.deleted = false)"); err != nil {
+		return nil, err
+	}
+	rows, err := b.db.Query("select name, resource, plan, created from domain_intents where created < $1 order by created", time.Now().Add(-age))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	intents := make([]DomainIntent, 0)
+	for rows.Next() {
+		var intent DomainIntent
+		if err = rows.Scan(&intent.Name, &intent.InstanceId, &intent.PlanId, &intent.Created); err != nil {
+			return nil, err
+		}
+		intents = append(intents, intent)
+	}
+	return intents, nil
+}
+
+// GetOwnerPresets returns the default provision parameters of the owner, empty if it has none.
+func (b *PostgresStorage) GetOwnerPresets(owner string) (map[string]interface{}, error) {
+	var data string
+	presets := make(map[string]interface{})
+	err := b.db.QueryRow("select parameters from owner_presets where owner = $1", owner).Scan(&data)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		return presets, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal([]byte(data), &presets); err != nil {
+		return nil, err
+	}
+	return presets, nil
+}
+
+// SetOwnerPresets replaces the presets of the owner, empty presets remove them.
+func (b *PostgresStorage) SetOwnerPresets(owner string, parameters map[string]interface{}) error {
+	if len(parameters) == 0 {
+		_, err := b.db.Exec("delete from owner_presets where owner = $1", owner)
+		return err
+	}
+	data, err := json.Marshal(parameters)
+	if err != nil {
+		return err
+	}
+	_, err = b.db.Exec("insert into owner_presets (owner, parameters) values ($1, $2) on conflict (owner) do update set parameters = $2, updated = now()", owner, string(data))
+	return err
+}
+
+// GetOwnerQuota returns the most instances the owner may have, or -1 if it has no quota of its own.
+func (b *PostgresStorage) GetOwnerQuota(owner string) (int, error) {
+	var quota int
+	err := b.db.QueryRow("select max_instances from owner_quotas where owner = $1", owner).Scan(&quota)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		return -1, nil
+	}
+	return quota, err
+}
+
+func (b *PostgresStorage) CountOwnerInstances(owner string) (int, error) {
+	var count int
+	err := b.db.QueryRow("select count(*) from resources where owner = $1 and deleted = false and name != ''", owner).Scan(&count)
+	return count, err
+}
+
+func (b *PostgresStorage) AddAdminQuery(q *AdminQuery) (string, error) {
+	var id string
+	err := b.db.QueryRow("insert into admin_queries (resource, actor, reason, path) values ($1, $2, $3, $4) returning query", q.InstanceId, q.Actor, q.Reason, q.Path).Scan(&id)
+	return id, err
+}
+
+func (b *PostgresStorage) UpdateAdminQuery(Id string, status int) error {
+	_, err := b.db.Exec("update admin_queries set status = $2 where query = $1", Id, status)
+	return err
+}
+
+func (b *PostgresStorage) GetAdminQueries(InstanceId string) ([]AdminQuery, error) {
+	rows, err := b.db.Query("select query, resource, actor, reason, path, coalesce(status, 0), created from admin_queries where resource = $1 order by created", InstanceId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	queries := make([]AdminQuery, 0)
+	for rows.Next() {
+		var q AdminQuery
+		if err = rows.Scan(&q.Id, &q.InstanceId, &q.Actor, &q.Reason, &q.Path, &q.Status, &q.Created); err != nil {
+			return nil, err
+		}
+		queries = append(queries, q)
+	}
+	return queries, nil
+}
+
+func (b *PostgresStorage) GetEngineSupports() ([]EngineSupport, error) {
+	rows, err := b.db.Query("select engine, version, end_of_support, upgrade_to from engine_versions")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	supports := make([]EngineSupport, 0)
+	for rows.Next() {
+		var support EngineSupport
+		if err = rows.Scan(&support.Engine, &support.Version, &support.EndOfSupport, &support.UpgradeTo); err != nil {
+			return nil, err
+		}
+		supports = append(supports, support)
+	}
+	return supports, nil
+}
+
+// GetNotificationTemplate returns the operators override of a notification template,
+// or an empty string if there is none.
+func (b *PostgresStorage) GetNotificationTemplate(event string, channel string) (string, error) {
+	var text string
+	err := b.db.QueryRow("select template from notification_templates where event = $1 and channel = $2", event, channel).Scan(&text)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		return "", nil
+	}
+	return text, err
+}
+
+func (b *PostgresStorage) GetContacts(Id string) ([]string, error) {
+	var contacts string
+	err := b.db.QueryRow("select contacts from resources where id = $1 and deleted = false", Id).Scan(&contacts)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		return nil, errors.New("Cannot find resource instance")
+	} else if err != nil {
+		return nil, err
+	}
+	if contacts == "" {
+		return []string{}, nil
+	}
+	return strings.Split(contacts, ","), nil
+}
+
+func (b *PostgresStorage) SetContacts(Id string, contacts []string) error {
+	rows, err := b.db.Query("update resources set contacts = $2 where id = $1 and deleted = false returning id", Id, strings.Join(contacts, ","))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return errors.New("Cannot find resource instance")
+	}
+	return nil
+}
+
+func (b *PostgresStorage) GetLabels(Id string) (map[string]string, error) {
+	var data sql.NullString
+	err := b.db.QueryRow("select labels from resources where id = $1 and deleted = false", Id).Scan(&data)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		return nil, errors.New("Cannot find resource instance")
+	} else if err != nil {
+		return nil, err
+	}
+	labels := make(map[string]string)
+	if data.Valid {
+		if err = json.Unmarshal([]byte(data.String), &labels); err != nil {
+			return nil, err
+		}
+	}
+	return labels, nil
+}
+
+func (b *PostgresStorage) GetAnnotations(Id string) (*Annotations, error) {
+	var data sql.NullString
+	err := b.db.QueryRow("select annotations from resources where id = $1 and deleted = false", Id).Scan(&data)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		return nil, errors.New("Cannot find resource instance")
+	} else if err != nil {
+		return nil, err
+	}
+	annotations := Annotations{Escalation: make([]string, 0)}
+	if data.Valid {
+		if err = json.Unmarshal([]byte(data.String), &annotations); err != nil {
+			return nil, err
+		}
+	}
+	return &annotations, nil
+}
+
+func (b *PostgresStorage) SetAnnotations(Id string, annotations *Annotations) error {
+	data, err := json.Marshal(annotations)
+	if err != nil {
+		return err
+	}
+	rows, err := b.db.Query("update resources set annotations = $2 where id = $1 and deleted = false returning id", Id, string(data))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return errors.New("Cannot find resource instance")
+	}
+	return nil
+}
+
+func (b *PostgresStorage) SetLabels(Id string, labels map[string]string) error {
+	data, err := json.Marshal(labels)
+	if err != nil {
+		return err
+	}
+	rows, err := b.db.Query("update resources set labels = $2 where id = $1 and deleted = false returning id", Id, string(data))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return errors.New("Cannot find resource instance")
+	}
+	return nil
+}
+
+// AddOperationKey records a new operation of the type on the instance, its attempt is one
+// more than the last operation of the type. The task is the task the operation waits on, if
+// any.
+func (b *PostgresStorage) AddOperationKey(Id string, operation string, task string) (*OperationKey, error) {
+	key := OperationKey{Type: operation, Task: task}
+	err := b.db.QueryRow(`
+		insert into operation_keys (resource, type, attempt, task)
+		select $1, $2, coalesce(max(attempt), 0) + 1, $3 from operation_keys where resource = $1 and type = $2
+		returning attempt, started`, Id, operation, task).Scan(&key.Attempt, &key.Started)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// GetOperationKey returns the last operation recorded for the instance.
+func (b *PostgresStorage) GetOperationKey(Id string) (*OperationKey, error) {
+	var key OperationKey
+	err := b.db.QueryRow("select type, attempt, started, task from operation_keys where resource = $1 order by started desc limit 1", Id).Scan(&key.Type, &key.Attempt, &key.Started, &key.Task)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		return nil, errors.New("Not found")
+	} else if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (b *PostgresStorage) GetInstanceCreated(Id string) (time.Time, error) {
+	var created time.Time
+	err := b.db.QueryRow("select created from resources where id = $1 and deleted = false", Id).Scan(&created)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		return created, errors.New("Cannot find resource instance")
+	}
+	return created, err
+}
+
+// AddProvisionDuration records the time since the instance was created as its provision
+// duration, only the first provision of an instance is recorded.
+func (b *PostgresStorage) AddProvisionDuration(Id string, instanceType string) error {
+	_, err := b.db.Exec(`
+		insert into provision_durations (resource, plan, instance_type, seconds)
+		select id, plan, $2, extract(epoch from now() - created)::integer from resources where id = $1 and deleted = false
+		on conflict (resource) do nothing`, Id, instanceType)
+	return err
+}
+
+// GetProvisionDurations returns the most recent provision durations of the plan or instance
+// type (an empty value matches any).
+func (b *PostgresStorage) GetProvisionDurations(planId string, instanceType string, limit int) ([]time.Duration, error) {
+	rows, err := b.db.Query(`
+		select seconds from provision_durations
+		where ($1 = '' or plan::varchar(1024) = $1) and ($2 = '' or instance_type = $2)
+		order by created desc limit $3`, planId, instanceType, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	durations := make([]time.Duration, 0)
+	for rows.Next() {
+		var seconds int64
+		if err = rows.Scan(&seconds); err != nil {
+			return nil, err
+		}
+		durations = append(durations, time.Duration(seconds)*time.Second)
+	}
+	return durations, nil
+}
+
+// GetSpace returns the space an instance was provisioned in, and whether it has been
+// released (deprovisioned while it was shared).
+func (b *PostgresStorage) GetSpace(Id string) (string, bool, error) {
+	var space string
+	var released bool
+	err := b.db.QueryRow("select space, released from resources where id = $1 and deleted = false", Id).Scan(&space, &released)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		return "", false, errors.New("Cannot find resource instance")
+	}
+	return space, released, err
+}
+
+func (b *PostgresStorage) SetSpace(Id string, space string) error {
+	_, err := b.db.Exec("update resources set space = $2 where id = $1", Id, space)
+	return err
+}
+
+func (b *PostgresStorage) Release(Id string) error {
+	return b.withEvent(Id, ReleasedEvent, EventData{}, func(tx *sql.Tx) error {
+		_, err := tx.Exec("update resources set released = true where id = $1 and deleted = false", Id)
+		return err
+	})
+}
+
+func (b *PostgresStorage) getShares(subquery string, args ...interface{}) ([]Share, error) {
+	rows, err := b.db.Query("select share, resource, space, created from shares where resource = $1 and deleted = false "+subquery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	shares := make([]Share, 0)
+	for rows.Next() {
+		var share Share
+		if err = rows.Scan(&share.Id, &share.InstanceId, &share.Space, &share.Created); err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, nil
+}
+
+func (b *PostgresStorage) GetShares(InstanceId string) ([]Share, error) {
+	return b.getShares("order by created", InstanceId)
+}
+
+func (b *PostgresStorage) GetShare(InstanceId string, Id string) (*Share, error) {
+	shares, err := b.getShares("and share::varchar(1024) = $2::varchar(1024)", InstanceId, Id)
+	if err != nil {
+		return nil, err
+	}
+	if len(shares) == 0 {
+		return nil, errors.New("Not found")
+	}
+	return &shares[0], nil
+}
+
+func (b *PostgresStorage) AddShare(InstanceId string, space string) (*Share, error) {
+	share := Share{InstanceId: InstanceId, Space: space}
+	err := b.withEvent(InstanceId, SharedEvent, EventData{Space: space}, func(tx *sql.Tx) error {
+		return tx.QueryRow("insert into shares (resource, space) values ($1, $2) returning share, created", InstanceId, space).Scan(&share.Id, &share.Created)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &share, nil
+}
+
+// RemoveShare removes the share and returns how many shares the instance has left.
+func (b *PostgresStorage) RemoveShare(InstanceId string, Id string) (int, error) {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	var space string
+	err = tx.QueryRow("update shares set deleted = true where resource = $1 and share::varchar(1024) = $2::varchar(1024) and deleted = false returning space", InstanceId, Id).Scan(&space)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		tx.Rollback()
+		return 0, errors.New("Not found")
+	} else if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err = recordEvent(tx, InstanceId, UnsharedEvent, EventData{Space: space}); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	var count int
+	err = b.db.QueryRow("select count(*) from shares where resource = $1 and deleted = false", InstanceId).Scan(&count)
+	return count, err
+}
+
+func (b *PostgresStorage) SetBindingSpace(InstanceId string, BindingId string, space string) error {
+	_, err := b.db.Exec("update bindings set space = $3 where resource = $1 and binding = $2", InstanceId, BindingId, space)
+	return err
+}
+
+func (b *PostgresStorage) CountSpaceBindings(InstanceId string, space string) (int, error) {
+	var count int
+	err := b.db.QueryRow("select count(*) from bindings where resource = $1 and space = $2 and deleted = false", InstanceId, space).Scan(&count)
+	return count, err
+}
+
+func (b *PostgresStorage) AddDryRunReport(r *DryRunReport) error {
+	_, err := b.db.Exec("insert into dry_run_reports (resource, action, description) values ($1, $2, $3)", r.InstanceId, r.Action, r.Description)
+	return err
+}
+
+func (b *PostgresStorage) GetDryRunReports(InstanceId string) ([]DryRunReport, error) {
+	rows, err := b.db.Query("select resource, action, description, created from dry_run_reports where resource = $1 order by created", InstanceId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	reports := make([]DryRunReport, 0)
+	for rows.Next() {
+		var r DryRunReport
+		if err = rows.Scan(&r.InstanceId, &r.Action, &r.Description, &r.Created); err != nil {
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+	return reports, nil
+}
+
+// nextCursor returns the cursor of the page after one with count rows, as one more row
+// than the limit is fetched there is a next page only if count is over the limit.
+func nextCursor(q *ListQuery, count int, cursors []listCursor) string {
+	if count <= q.Limit {
+		return ""
+	}
+	return encodeCursor(cursors[q.Limit-1].Value, cursors[q.Limit-1].Id)
+}
+
+func (b *PostgresStorage) ListInstances(q *ListQuery) ([]InstanceSummary, string, error) {
+	where, args := q.where(instancesListSpec, "resources.id", []interface{}{})
+	rows, err := b.db.Query("select resources.id, resources.name, resources.plan, plans.name, plans.type, plans.version, resources.status, resources.owner, coalesce(resources.labels::text, '{}'), resources.created, resources.updated, "+instancesListSpec.Sorts[q.Sort].Expr+"::text from resources join plans on resources.plan = plans.plan where resources.deleted = false and resources.name != ''"+where, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+	instances := make([]InstanceSummary, 0)
+	cursors := make([]listCursor, 0)
+	for rows.Next() {
+		var i InstanceSummary
+		var value, labels string
+		if err = rows.Scan(&i.Id, &i.Name, &i.PlanId, &i.Plan, &i.Engine, &i.EngineVersion, &i.Status, &i.Owner, &labels, &i.Created, &i.Updated, &value); err != nil {
+			return nil, "", err
+		}
+		if err = json.Unmarshal([]byte(labels), &i.Labels); err != nil {
+			return nil, "", err
+		}
+		instances = append(instances, i)
+		cursors = append(cursors, listCursor{Value: value, Id: i.Id})
+	}
+	next := nextCursor(q, len(instances), cursors)
+	if len(instances) > q.Limit {
+		instances = instances[0:q.Limit]
+	}
+	return instances, next, nil
+}
+
+func (b *PostgresStorage) ListOperations(InstanceId string, q *ListQuery) ([]Operation, string, error) {
+	where, args := q.where(operationsListSpec, "tasks.task", []interface{}{InstanceId})
+	rows, err := b.db.Query("select tasks.task, tasks.action, tasks.status, tasks.retries, tasks.result, tasks.created, tasks.updated, tasks.started, tasks.finished, "+operationsListSpec.Sorts[q.Sort].Expr+"::text from tasks where tasks.resource = $1 and tasks.deleted = false"+where, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+	operations := make([]Operation, 0)
+	cursors := make([]listCursor, 0)
+	for rows.Next() {
+		var o Operation
+		var value string
+		if err = rows.Scan(&o.Id, &o.Action, &o.Status, &o.Retries, &o.Result, &o.Created, &o.Updated, &o.Started, &o.Finished, &value); err != nil {
+			return nil, "", err
+		}
+		operations = append(operations, o)
+		cursors = append(cursors, listCursor{Value: value, Id: o.Id})
+	}
+	next := nextCursor(q, len(operations), cursors)
+	if len(operations) > q.Limit {
+		operations = operations[0:q.Limit]
+	}
+	return operations, next, nil
+}
+
+func (b *PostgresStorage) ListBindings(InstanceId string, q *ListQuery) ([]BindingSummary, string, error) {
+	where, args := q.where(bindingsListSpec, "bindings.binding", []interface{}{InstanceId})
+	rows, err := b.db.Query("select bindings.binding, bindings.status, bindings.expires, bindings.created, bindings.updated, "+bindingsListSpec.Sorts[q.Sort].Expr+"::text from bindings where bindings.resource = $1 and bindings.deleted = false"+where, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+	bindings := make([]BindingSummary, 0)
+	cursors := make([]listCursor, 0)
+	for rows.Next() {
+		var binding BindingSummary
+		var value string
+		if err = rows.Scan(&binding.Id, &binding.Status, &binding.Expires, &binding.Created, &binding.Updated, &value); err != nil {
+			return nil, "", err
+		}
+		bindings = append(bindings, binding)
+		cursors = append(cursors, listCursor{Value: value, Id: binding.Id})
+	}
+	next := nextCursor(q, len(bindings), cursors)
+	if len(bindings) > q.Limit {
+		bindings = bindings[0:q.Limit]
+	}
+	return bindings, next, nil
+}
+
+func (b *PostgresStorage) GetExpires(Id string) (*time.Time, error) {
+	var expires *time.Time
+	err := b.db.QueryRow("select expires from resources where id = $1 and deleted = false", Id).Scan(&expires)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		return nil, errors.New("Cannot find resource instance")
+	} else if err != nil {
+		return nil, err
+	}
+	return expires, nil
+}
+
+func (b *PostgresStorage) SetExpires(Id string, expires *time.Time) error {
+	rows, err := b.db.Query("update resources set expires = $2 where id = $1 and deleted = false returning id", Id, expires)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return errors.New("Cannot find resource instance")
+	}
+	return nil
+}
+
+func (b *PostgresStorage) GetExpiredInstances() ([]string, error) {
+	rows, err := b.db.Query("select id from resources where expires < now() and deleted = false order by expires")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (b *PostgresStorage) SetInstanceParameters(Id string, parameters *InstanceParameters) error {
+	data, err := json.Marshal(parameters)
+	if err != nil {
+		return err
+	}
+	_, err = b.db.Exec("update resources set parameters = $2 where id = $1 and deleted = false", Id, string(data))
+	return err
+}
+
+func (b *PostgresStorage) GetRequestedParameters(Id string) (map[string]interface{}, error) {
+	var data sql.NullString
+	err := b.db.QueryRow("select requested_parameters from resources where id = $1 and deleted = false", Id).Scan(&data)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		return nil, errors.New("Cannot find resource instance")
+	} else if err != nil {
+		return nil, err
+	}
+	parameters := make(map[string]interface{})
+	if data.Valid {
+		if err = json.Unmarshal([]byte(data.String), &parameters); err != nil {
+			return nil, err
+		}
+	}
+	return parameters, nil
+}
+
+func (b *PostgresStorage) SetRequestedParameters(Id string, parameters map[string]interface{}) error {
+	data, err := json.Marshal(parameters)
+	if err != nil {
+		return err
+	}
+	_, err = b.db.Exec("update resources set requested_parameters = $2 where id = $1 and deleted = false", Id, string(data))
+	return err
+}
+
+func (b *PostgresStorage) AddBenchmark(result *BenchmarkResult) error {
+	return b.db.QueryRow("insert into benchmarks (resource, plan, documents, searches, index_throughput, search_latency_p50, search_latency_p90) values ($1, $2, $3, $4, $5, $6, $7) returning id, created", result.InstanceId, result.Plan, result.Documents, result.Searches, result.IndexThroughput, result.SearchLatencyP50, result.SearchLatencyP90).Scan(&result.Id, &result.Created)
+}
+
+func (b *PostgresStorage) GetBenchmarks(Id string) ([]BenchmarkResult, error) {
+	rows, err := b.db.Query("select id, resource, plan, documents, searches, index_throughput, search_latency_p50, search_latency_p90, created from benchmarks where resource = $1 order by created desc", Id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := make([]BenchmarkResult, 0)
+	for rows.Next() {
+		var r BenchmarkResult
+		if err = rows.Scan(&r.Id, &r.InstanceId, &r.Plan, &r.Documents, &r.Searches, &r.IndexThroughput, &r.SearchLatencyP50, &r.SearchLatencyP90, &r.Created); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// GetPlanBenchmarks returns the average of the benchmarks of each plan over the last 90 days.
+func (b *PostgresStorage) GetPlanBenchmarks() (map[string]PlanBenchmark, error) {
+	rows, err := b.db.Query("select plan, count(*), round(avg(index_throughput)::numeric, 2)::float, round(avg(search_latency_p50)::numeric, 2)::float, round(avg(search_latency_p90)::numeric, 2)::float from benchmarks where created > now() - interval '90 days' group by plan")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	benchmarks := make(map[string]PlanBenchmark)
+	for rows.Next() {
+		var plan string
+		var benchmark PlanBenchmark
+		if err = rows.Scan(&plan, &benchmark.Runs, &benchmark.IndexThroughput, &benchmark.SearchLatencyP50, &benchmark.SearchLatencyP90); err != nil {
+			return nil, err
+		}
+		benchmarks[plan] = benchmark
+	}
+	return benchmarks, nil
+}
+
+const snapshotExportsQuery string = `
+select
+    resource,
+    bucket,
+    region,
+    base_path,
+    status,
+    message,
+    last_snapshot,
+    last_exported
+from snapshot_exports `
+
+func (b *PostgresStorage) getSnapshotExports(subquery string, args ...interface{}) ([]SnapshotExport, error) {
+	rows, err := b.db.Query(snapshotExportsQuery+subquery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	exports := make([]SnapshotExport, 0)
+	for rows.Next() {
+		var e SnapshotExport
+		if err = rows.Scan(&e.InstanceId, &e.Bucket, &e.Region, &e.BasePath, &e.Status, &e.Message, &e.LastSnapshot, &e.LastExported); err != nil {
+			return nil, err
+		}
+		exports = append(exports, e)
+	}
+	return exports, nil
+}
+
+func (b *PostgresStorage) GetSnapshotExport(InstanceId string) (*SnapshotExport, error) {
+	exports, err := b.getSnapshotExports("where resource = $1", InstanceId)
+	if err != nil {
+		return nil, err
+	}
+	if len(exports) == 0 {
+		return nil, errors.New("Not found")
+	}
+	return &exports[0], nil
+}
+
+func (b *PostgresStorage) GetSnapshotExports() ([]SnapshotExport, error) {
+	return b.getSnapshotExports("order by created")
+}
+
+func (b *PostgresStorage) SetSnapshotExport(e *SnapshotExport) error {
+	_, err := b.db.Exec(`
+		insert into snapshot_exports (resource, bucket, region, base_path, status, message, last_snapshot, last_exported) values ($1, $2, $3, $4, $5, $6, $7, $8)
+		on conflict (resource) do update set bucket = $2, region = $3, base_path = $4, status = $5, message = $6, last_snapshot = $7, last_exported = $8`,
+		e.InstanceId, e.Bucket, e.Region, e.BasePath, e.Status, e.Message, e.LastSnapshot, e.LastExported)
+	return err
+}
+
+func (b *PostgresStorage) DeleteSnapshotExport(InstanceId string) error {
+	_, err := b.db.Exec("delete from snapshot_exports where resource = $1", InstanceId)
+	return err
+}
+
+// RotateCredentials re-encrypts the credentials of every instance and the details of every
+// encrypted plan that are not encrypted with the current key.
+func (b *PostgresStorage) RotateCredentials() (int, int, error) {
+	rows, err := b.db.Query("select id, coalesce(username, ''), coalesce(password, '') from resources where deleted = false")
+	if err != nil {
+		return 0, 0, err
+	}
+	entries := make([]Entry, 0)
+	for rows.Next() {
+		var entry Entry
+		if err = rows.Scan(&entry.Id, &entry.Username, &entry.Password); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		if needsRotation(entry.Username) || needsRotation(entry.Password) {
+			entries = append(entries, entry)
+		}
+	}
+	rows.Close()
+	for _, entry := range entries {
+		if err = decryptEntry(&entry); err != nil {
+			return 0, 0, errors.New("Unable to decrypt the credentials of " + entry.Id + ": " + err.Error())
+		}
+		username, password, err := encryptCredentials(&Instance{Username: entry.Username, Password: entry.Password})
+		if err != nil {
+			return 0, 0, err
+		}
+		if _, err = b.db.Exec("update resources set username = $2, password = $3 where id = $1", entry.Id, username, password); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	rows, err = b.db.Query("select plan, provider_private_details::text from plans where deleted = false")
+	if err != nil {
+		return len(entries), 0, err
+	}
+	details := make(map[string]string)
+	for rows.Next() {
+		var plan, value string
+		if err = rows.Scan(&plan, &value); err != nil {
+			rows.Close()
+			return len(entries), 0, err
+		}
+		var encrypted string
+		if strings.HasPrefix(value, "\""+encryptedPrefix) && json.Unmarshal([]byte(value), &encrypted) == nil && needsRotation(encrypted) {
+			details[plan] = encrypted
+		}
+	}
+	rows.Close()
+	for plan, encrypted := range details {
+		plain, err := DecryptCredential(encrypted)
+		if err != nil {
+			return len(entries), 0, errors.New("Unable to decrypt the details of plan " + plan + ": " + err.Error())
+		}
+		if encrypted, err = EncryptCredential(plain); err != nil {
+			return len(entries), 0, err
+		}
+		if _, err = b.db.Exec("update plans set provider_private_details = to_json($2::text) where plan = $1", plan, encrypted); err != nil {
+			return len(entries), 0, err
+		}
+	}
+	return len(entries), len(details), nil
+}
+
+// RotateBindingCredentials re-encrypts the stored credentials of every binding that are not
+// encrypted with the current key.
+func (b *PostgresStorage) RotateBindingCredentials() (int, error) {
+	rows, err := b.db.Query("select binding, credentials from bindings where deleted = false and credentials is not null")
+	if err != nil {
+		return 0, err
+	}
+	credentials := make(map[string]string)
+	for rows.Next() {
+		var binding, value string
+		if err = rows.Scan(&binding, &value); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if needsRotation(value) {
+			credentials[binding] = value
+		}
+	}
+	rows.Close()
+	for binding, value := range credentials {
+		plain, err := DecryptCredential(value)
+		if err != nil {
+			return 0, errors.New("Unable to decrypt the credentials of binding " + binding + ": " + err.Error())
+		}
+		encrypted, err := EncryptCredential(plain)
+		if err != nil {
+			return 0, err
+		}
+		if _, err = b.db.Exec("update bindings set credentials = $2 where binding = $1", binding, encrypted); err != nil {
+			return 0, err
+		}
+	}
+	return len(credentials), nil
+}
+
+// RecordHeartbeat records a run of a background loop, failure is empty if the run succeeded.
+func (b *PostgresStorage) RecordHeartbeat(name string, interval time.Duration, failure string, backlog int) error {
+	_, err := b.db.Exec(`
+		insert into heartbeats (name, interval_seconds, last_run, last_success, last_error, backlog)
+		values ($1, $2, now(), case when $3::text = '' then now() else null end, $3, $4)
+		on conflict (name) do update set
+			interval_seconds = excluded.interval_seconds,
+			last_run = excluded.last_run,
+			last_success = coalesce(excluded.last_success, heartbeats.last_success),
+			last_error = excluded.last_error,
+			backlog = excluded.backlog`, name, int(interval.Seconds()), failure, backlog)
+	return err
+}
+
+func (b *PostgresStorage) GetHeartbeats() ([]Heartbeat, error) {
+	rows, err := b.db.Query("select name, interval_seconds, last_run, last_success, last_error, backlog from heartbeats order by name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	heartbeats := make([]Heartbeat, 0)
+	for rows.Next() {
+		var h Heartbeat
+		if err = rows.Scan(&h.Name, &h.Interval, &h.LastRun, &h.LastSuccess, &h.LastError, &h.Backlog); err != nil {
+			return nil, err
+		}
+		heartbeats = append(heartbeats, h)
+	}
+	return heartbeats, rows.Err()
+}
+
+func (b *PostgresStorage) CountPendingTasks() (int, error) {
+	var count int
+	err := b.db.QueryRow("select count(*) from tasks where status = 'pending' and deleted = false").Scan(&count)
+	return count, err
+}
+
+func (b *PostgresStorage) getVisibilityRules(query string, args ...interface{}) ([]VisibilityRule, error) {
+	rows, err := b.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rules := make([]VisibilityRule, 0)
+	for rows.Next() {
+		var rule VisibilityRule
+		if err = rows.Scan(&rule.Plan, &rule.Type, &rule.Id, &rule.Effect); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+func (b *PostgresStorage) GetVisibilityRules() ([]VisibilityRule, error) {
+	return b.getVisibilityRules("select plan, type, id, effect from plan_visibility order by plan, type, id")
+}
+
+func (b *PostgresStorage) GetPlanVisibility(plan string) ([]VisibilityRule, error) {
+	return b.getVisibilityRules("select plan, type, id, effect from plan_visibility where plan = $1 order by type, id", plan)
+}
+
+// SetPlanVisibility replaces the visibility rules of the plan.
+func (b *PostgresStorage) SetPlanVisibility(plan string, rules []VisibilityRule) error {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec("delete from plan_visibility where plan = $1", plan); err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, rule := range rules {
+		if _, err = tx.Exec("insert into plan_visibility (plan, type, id, effect) values ($1, $2, $3, $4) on conflict (plan, type, id) do update set effect = $4", plan, rule.Type, rule.Id, rule.Effect); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetSimulatedDomain returns the domain of the simulated provider, nil if there is none.
+func (b *PostgresStorage) GetSimulatedDomain(name string) (*SimulatedDomain, error) {
+	var tags string
+	domain := SimulatedDomain{Name: name}
+	err := b.db.QueryRow("select plan, engine_version, tags, operation, changed, created from simulated_domains where name = $1", name).Scan(&domain.Plan, &domain.EngineVersion, &tags, &domain.Operation, &domain.Changed, &domain.Created)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal([]byte(tags), &domain.Tags); err != nil {
+		return nil, err
+	}
+	if domain.Tags == nil {
+		domain.Tags = make(map[string]string)
+	}
+	return &domain, nil
+}
+
+func (b *PostgresStorage) AddSimulatedDomain(domain *SimulatedDomain) error {
+	tags, err := json.Marshal(domain.Tags)
+	if err != nil {
+		return err
+	}
+	_, err = b.db.Exec("insert into simulated_domains (name, plan, engine_version, tags, operation, changed, created) values ($1, $2, $3, $4, $5, $6, $7)", domain.Name, domain.Plan, domain.EngineVersion, string(tags), domain.Operation, domain.Changed, domain.Created)
+	return err
+}
+
+func (b *PostgresStorage) UpdateSimulatedDomain(domain *SimulatedDomain) error {
+	tags, err := json.Marshal(domain.Tags)
+	if err != nil {
+		return err
+	}
+	_, err = b.db.Exec("update simulated_domains set plan = $2, engine_version = $3, tags = $4, operation = $5, changed = $6 where name = $1", domain.Name, domain.Plan, domain.EngineVersion, string(tags), domain.Operation, domain.Changed)
+	return err
+}
+
+func (b *PostgresStorage) DeleteSimulatedDomain(name string) error {
+	_, err := b.db.Exec("delete from simulated_domains where name = $1", name)
+	return err
+}
+
+func InitStorage(ctx context.Context, o Options) (*PostgresStorage, error) {
+	// Sanity checks
+	if o.DatabaseUrl == "" && os.Getenv("DATABASE_URL") != "" {
+		o.DatabaseUrl = os.Getenv("DATABASE_URL")
+	}
+	if o.DatabaseUrl == "" {
+		return nil, errors.New("Unable to connect to resource, none was specified in the environment via resource_URL or through the -resource cli option.")
+	}
+	db, err := sql.Open("postgres", o.DatabaseUrl)
+	if err != nil {
+		glog.Errorf("Unable to create resource schema: %s\n", err.Error())
+		return nil, errors.New("Unable to create resource schema: " + err.Error())
+	}
+
+	_, err = db.Exec(sqlCreateScript)
+	if err != nil {
+		return nil, err
+	}
+
+	go cancelOnInterrupt(ctx, db)
+
+	storage := &PostgresStorage{
+		db: db,
+	}
+	if SimulationMode() {
+		simulatedStorage = storage
+	}
+	return storage, nil
 }
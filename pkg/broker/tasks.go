@@ -26,6 +26,16 @@ const (
 	ChangePlansTask						 TaskAction = "change-plans"
 	RestoreDbTask						 TaskAction = "restore-database"
 	PerformPostProvisionTask			 TaskAction = "perform-post-provision"
+	RunHookTask							 TaskAction = "run-hook"
+	RunPreDeprovisionHookTask			 TaskAction = "run-pre-deprovision-hook"
+	BootstrapLoggingTask				 TaskAction = "bootstrap-logging"
+	RemediateReadOnlyTask				 TaskAction = "remediate-read-only"
+	ExpireTask							 TaskAction = "expire"
+	BindTask							 TaskAction = "bind"
+	UnbindTask							 TaskAction = "unbind"
+	BenchmarkTask						 TaskAction = "benchmark"
+	// Reported as restoring by LastOperation (see IsRestoring) until the indices are restored.
+	RestoreTask							 TaskAction = "restore-resource"
 )
 
 type Task struct {
@@ -50,7 +60,11 @@ type ChangeProvidersTaskMetadata struct {
 }
 
 type ChangePlansTaskMetadata struct {
-	Plan string `json:"plan"`
+	Plan       string              `json:"plan"`
+	// The instance parameters to apply with the plan, merged with the existing ones.
+	Parameters *InstanceParameters `json:"parameters,omitempty"`
+	// A maintenance update upgrades the engine to the version of the plan.
+	Maintenance bool               `json:"maintenance,omitempty"`
 }
 
 type RestoreDbTaskMetadata struct {
@@ -77,8 +91,10 @@ func RunPreprovisionTasks(ctx context.Context, o Options, namePrefix string, sto
 	dbEntries, err := storage.StartProvisioningTasks()
 	if err != nil {
 		glog.Errorf("Get pending tasks failed: %s\n", err.Error())
+		RecordHeartbeat(storage, PreprovisionerLoop, preprovisionInterval, err, 0)
 		return
 	}
+	RecordHeartbeat(storage, PreprovisionerLoop, preprovisionInterval, nil, len(dbEntries))
 	for _, entry := range dbEntries {
 		glog.Infof("Starting preprovisioning database: %s with plan: %s\n", entry.Id, entry.PlanId)
 
@@ -95,7 +111,7 @@ func RunPreprovisionTasks(ctx context.Context, o Options, namePrefix string, sto
 			continue
 		}
 
-		Instance, err := provider.Provision(entry.Id, plan, "preprovisioned")
+		Instance, name, err := ProvisionWithRandomName(storage, provider, entry.Id, plan, entry.Owner)
 		if err != nil {
 			glog.Errorf("Error provisioning database (%s): %s\n", plan.ID, err.Error())
 			storage.NukeInstance(entry.Id)
@@ -106,13 +122,13 @@ func RunPreprovisionTasks(ctx context.Context, o Options, namePrefix string, sto
 			glog.Errorf("Error inserting record into provisioned table: %s\n", err.Error())
 
 			if err = provider.Deprovision(Instance, false); err != nil {
-				glog.Errorf("Error cleaning up (deprovision failed) after insert record failed but provision succeeded (Database Id:%s Name: %s) %s\n", Instance.Id, Instance.Name, err.Error())
-				if _, err = storage.AddTask(Instance.Id, DeleteTask, Instance.Name); err != nil {
-					glog.Errorf("Error: Unable to add task to delete instance, WE HAVE AN ORPHAN! (%s): %s\n", Instance.Name, err.Error())
-				}
+				glog.Errorf("Error cleaning up (deprovision failed) after insert record failed but provision succeeded, the reconciler will delete it (Database Id:%s Name: %s) %s\n", Instance.Id, Instance.Name, err.Error())
 			}
 			continue
 		}
+		if err = storage.RemoveDomainIntent(name); err != nil {
+			glog.Errorf("Unable to remove the recorded domain %s (the reconciler will remove it): %s\n", name, err.Error())
+		}
 		if !IsAvailable(Instance.Status) {
 			if _, err = storage.AddTask(Instance.Id, ResyncFromProviderUntilAvailableTask, ""); err != nil {
 				glog.Errorf("Error: Unable to schedule resync from provider! (%s): %s\n", Instance.Name, err.Error())
@@ -123,15 +139,21 @@ func RunPreprovisionTasks(ctx context.Context, o Options, namePrefix string, sto
 	}
 }
 
+// PreprovisionedOwner is the owner of preprovisioned instances until they are claimed.
+const PreprovisionedOwner = "preprovisioned"
+
+// preprovisionInterval is how often the preprovisioner checks for plans missing preprovisioned instances.
+const preprovisionInterval = time.Second * 60 * 5
+
 func TickTocPreprovisionTasks(ctx context.Context, o Options, namePrefix string, storage Storage) {
-	next_check := time.NewTicker(time.Second * 60 * 5)
+	next_check := time.NewTicker(preprovisionInterval)
 	for {
 		RunPreprovisionTasks(ctx, o, namePrefix, storage, 60)
 		<-next_check.C
 	}
 }
 
-func UpgradeWithinProviders(storage Storage, fromDb *Instance, toPlanId string, namePrefix string) (string, error) {
+func UpgradeWithinProviders(storage Storage, fromDb *Instance, toPlanId string, namePrefix string, maintenance bool) (string, error) {
 	toPlan, err := storage.GetPlanByID(toPlanId)
 	if err != nil {
 		return "", err
@@ -140,7 +162,7 @@ func UpgradeWithinProviders(storage Storage, fromDb *Instance, toPlanId string,
 	if err != nil {
 		return "", err
 	}
-	if toPlanId == fromDb.Plan.ID {
+	if toPlanId == fromDb.Plan.ID && fromDb.Parameters == nil && !maintenance {
 		return "", errors.New("Cannot upgrade to the same plan")
 	}
 	if toPlan.Provider != fromDb.Plan.Provider {
@@ -148,13 +170,20 @@ func UpgradeWithinProviders(storage Storage, fromDb *Instance, toPlanId string,
 	}
 
 	// This could take a very long time.
-	Instance, err := fromProvider.Modify(fromDb, toPlan)
+	Instance, err := fromProvider.Modify(fromDb, toPlan, maintenance)
 	if err != nil && err.Error() == "This feature is not available on this plan." {
 		return UpgradeAcrossProviders(storage, fromDb, toPlanId, namePrefix)
 	}
 	if err != nil {
 		return "", err
 	}
+	// Providers that only return the credentials when an instance is created keep the stored ones.
+	if Instance.Username == "" {
+		Instance.Username = fromDb.Username
+	}
+	if Instance.Password == "" {
+		Instance.Password = fromDb.Password
+	}
 
 	if err = storage.UpdateInstance(Instance, Instance.Plan.ID); err != nil {
 		glog.Errorf("ERROR: Cannot update instance in database after upgrade change %s (to plan: %s) %s\n", Instance.Name, Instance.Plan.ID, err.Error())
@@ -173,18 +202,33 @@ func UpgradeAcrossProviders(storage Storage, fromDb *Instance, toPlanId string,
 	return "", errors.New("Memcached and redis instances cannot be upgraded across providers.")
 }
 
+// taskWorkerInterval is how often the task worker is expected to pick up a task, it checks
+// every minute but a task (e.g., an upgrade) can hold it for many minutes.
+const taskWorkerInterval = time.Minute * 15
+
 func RunWorkerTasks(ctx context.Context, o Options, namePrefix string, storage Storage) error {
 
+	cluster := NewClusterClient()
 	t := time.NewTicker(time.Second * 60)
 	for {
 		<-t.C
 		storage.WarnOnUnfinishedTasks()
+		FailStuckOperations(storage)
+		ExpireInstances(namePrefix, storage)
+		ExpireBindings(namePrefix, storage)
 
+		pending, err := storage.CountPendingTasks()
+		if err != nil {
+			glog.Errorf("Unable to count the pending tasks: %s\n", err.Error())
+		}
 		task, err := storage.PopPendingTask()
 		if err != nil && err.Error() != "sql: no rows in result set" {
 			glog.Errorf("Getting a pending task failed: %s\n", err.Error())
+			RecordHeartbeat(storage, TaskWorkerLoop, taskWorkerInterval, err, pending)
 			return err
-		} else if err != nil && err.Error() == "sql: no rows in result set" {
+		}
+		RecordHeartbeat(storage, TaskWorkerLoop, taskWorkerInterval, nil, pending)
+		if err != nil {
 			// Nothing to do...
 			continue
 		}
@@ -194,32 +238,74 @@ func RunWorkerTasks(ctx context.Context, o Options, namePrefix string, storage S
 		if task.Action == DeleteTask {
 			glog.Infof("Delete and deprovision database for task: %s\n", task.Id)
 
-			if task.Retries >= 10 {
+			// the domain is polled until aws no longer knows of it, deleting takes many minutes
+			if task.Retries >= 90 {
 				glog.Infof("Retry limit was reached for task: %s %d\n", task.Id, task.Retries)
 				FinishedTask(storage, task.Id, task.Retries, "Unable to delete database "+task.ResourceId+" as it failed multiple times ("+task.Result+")", "failed")
 				continue
 			}
 
-			Instance, err := GetInstanceById(namePrefix, storage, task.ResourceId)
-
+			Entry, err := storage.GetInstance(task.ResourceId)
 			if err != nil {
-				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot get Instance: "+err.Error(), "pending")
+				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot get Entry: "+err.Error(), "pending")
 				continue
 			}
-			provider, err := GetProviderByPlan(namePrefix, Instance.Plan)
+			plan, err := storage.GetPlanByID(Entry.PlanId)
+			if err != nil {
+				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot get plan: "+err.Error(), "pending")
+				continue
+			}
+			provider, err := GetProviderByPlan(namePrefix, plan)
 			if err != nil {
 				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot get provider: "+err.Error(), "pending")
 				continue
 			}
-			if err = provider.Deprovision(Instance, true); err != nil {
-				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Failed to deprovision: "+err.Error(), "pending")
+			Instance := &Instance{Id: Entry.Id, Name: Entry.Name, Plan: plan, Owner: Entry.Owner}
+			deleted, err := provider.IsDeleted(Instance)
+			if err != nil {
+				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot determine if the domain was deleted: "+err.Error(), "pending")
 				continue
 			}
+			if !deleted {
+				current, err := GetInstanceById(namePrefix, storage, task.ResourceId)
+				if err != nil {
+					UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot get Instance: "+err.Error(), "pending")
+					continue
+				}
+				if current.Status != "deleting" {
+					preserved, err := preserveHeldSnapshots(storage, cluster, current)
+					if err != nil {
+						UpdateTaskStatus(storage, task.Id, task.Retries+1, "Unable to preserve the held snapshots: "+err.Error(), "pending")
+						continue
+					}
+					if !preserved {
+						UpdateTaskStatus(storage, task.Id, task.Retries+1, "Waiting on the legal hold snapshot "+legalHoldSnapshotName(current), "pending")
+						continue
+					}
+					if err = provider.Deprovision(current, true); err != nil {
+						UpdateTaskStatus(storage, task.Id, task.Retries+1, "Failed to deprovision: "+err.Error(), "pending")
+						continue
+					}
+					current.Status = "deleting"
+				}
+				if err = storage.UpdateInstance(current, current.Plan.ID); err != nil {
+					glog.Errorf("Unable to record that %s is being deleted: %s\n", current.Name, err.Error())
+				}
+				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Waiting for the domain to be deleted", "pending")
+				continue
+			}
+			// The contacts are read now as the instance is marked deleted once deprovisioned.
+			contacts, contactsErr := storage.GetContacts(Instance.Id)
 			if err = storage.DeleteInstance(Instance); err != nil {
 				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Failed to delete: "+err.Error(), "pending")
 				continue
 			}
 			FinishedTask(storage, task.Id, task.Retries, "", "finished")
+			if contactsErr != nil {
+				glog.Errorf("Unable to get the contacts of %s to notify of its deletion: %s\n", Instance.Name, contactsErr.Error())
+			} else if err = SendEmail(storage, DeletedNotification, contacts, newInstanceNotification(Instance)); err != nil {
+				glog.Errorf("Unable to email the contacts of %s: %s\n", Instance.Name, err.Error())
+			}
 		} else if task.Action == ResyncFromProviderTask {
 			glog.Infof("Resyncing from provider for task: %s\n", task.Id)
 			if task.Retries >= 60 {
@@ -273,6 +359,22 @@ func RunWorkerTasks(ctx context.Context, o Options, namePrefix string, storage S
 				UpdateTaskStatus(storage, task.Id, task.Retries+1, "No change in status since last check (" + Instance.Status + ")", "pending")
 				continue
 			}
+			if err = CheckReachable(cluster, Instance); err != nil {
+				glog.Infof("The endpoint of %s is not reachable for task: %s, %s\n", Instance.Name, task.Id, err.Error())
+				UpdateTaskStatus(storage, task.Id, task.Retries+1, err.Error(), "pending")
+				continue
+			}
+			if provider, err := GetProviderByPlan(namePrefix, Instance.Plan); err != nil {
+				glog.Errorf("Error: Unable to tag %s, cannot get provider: %s\n", Instance.Name, err.Error())
+			} else if err = provider.Tag(Instance, "billingcode", Instance.Owner); err != nil {
+				glog.Errorf("Error: Unable to tag %s with its billing code: %s\n", Instance.Name, err.Error())
+			} else if err = TagInstanceId(provider, Instance); err != nil {
+				glog.Errorf("Error: Unable to tag %s with its instance id: %s\n", Instance.Name, err.Error())
+			}
+			if err = SchedulePostProvisionHooks(storage, Instance); err != nil {
+				glog.Errorf("Error: Unable to schedule post provision hooks! (%s): %s\n", Instance.Name, err.Error())
+			}
+			RecordProvisionDuration(storage, Instance)
 			FinishedTask(storage, task.Id, task.Retries, "", "finished")
 		} else if task.Action == PerformPostProvisionTask {
 			glog.Infof("Resyncing from provider until available (for perform post provision) for task: %s\n", task.Id)
@@ -296,6 +398,11 @@ func RunWorkerTasks(ctx context.Context, o Options, namePrefix string, storage S
 				UpdateTaskStatus(storage, task.Id, task.Retries+1, "No change in status since last check (" + Instance.Status + ")", "pending")
 				continue
 			}
+			if err = CheckReachable(cluster, Instance); err != nil {
+				glog.Infof("The endpoint of %s is not reachable for task: %s, %s\n", Instance.Name, task.Id, err.Error())
+				UpdateTaskStatus(storage, task.Id, task.Retries+1, err.Error(), "pending")
+				continue
+			}
 
 			provider, err := GetProviderByPlan(namePrefix, Instance.Plan)
 			if err != nil {
@@ -308,11 +415,27 @@ func RunWorkerTasks(ctx context.Context, o Options, namePrefix string, storage S
 				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Failed to update instance: " + err.Error(), "pending")
 				continue
 			}
+			if err = TagCreatedBy(storage, provider, newInstance); err != nil {
+				glog.Errorf("Error: Unable to tag the creator of the instance (%s): %s\n", newInstance.Name, err.Error())
+			}
+			if err = TagContext(storage, provider, newInstance); err != nil {
+				glog.Errorf("Error: Unable to tag the context of the instance (%s): %s\n", newInstance.Name, err.Error())
+			}
+			if err = TagLabels(storage, provider, newInstance, nil); err != nil {
+				glog.Errorf("Error: Unable to tag the labels of the instance (%s): %s\n", newInstance.Name, err.Error())
+			}
+			RecordProvisionDuration(storage, newInstance)
 
 			if err = storage.UpdateInstance(newInstance, newInstance.Plan.ID); err != nil {
 				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Failed to update instance after post provision: "+err.Error(), "pending")
 				continue
 			}
+			if err = SchedulePostProvisionHooks(storage, newInstance); err != nil {
+				glog.Errorf("Error: Unable to schedule post provision hooks! (%s): %s\n", newInstance.Name, err.Error())
+			}
+			if err = ScheduleLoggingBootstrap(storage, newInstance, task.Metadata); err != nil {
+				glog.Errorf("Error: Unable to schedule bootstrapping logging! (%s): %s\n", newInstance.Name, err.Error())
+			}
 
 			FinishedTask(storage, task.Id, task.Retries, "", "finished")
 		} else if task.Action == NotifyCreateServiceWebhookTask {
@@ -401,12 +524,20 @@ func RunWorkerTasks(ctx context.Context, o Options, namePrefix string, storage S
 				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot unmarshal task metadata to change providers: "+err.Error(), "pending")
 				continue
 			}
-			output, err := UpgradeWithinProviders(storage, Instance, taskMetaData.Plan, namePrefix)
+			if taskMetaData.Parameters != nil {
+				Instance.Parameters = taskMetaData.Parameters
+			}
+			output, err := UpgradeWithinProviders(storage, Instance, taskMetaData.Plan, namePrefix, taskMetaData.Maintenance)
 			if err != nil {
 				glog.Infof("Cannot change plans for: %s, %s\n", task.Id, err.Error())
 				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot change plans: " + err.Error(), "pending")
 				continue
 			}
+			if taskMetaData.Parameters != nil {
+				if err = storage.SetInstanceParameters(Instance.Id, taskMetaData.Parameters); err != nil {
+					glog.Errorf("Error: Unable to save the parameters of %s: %s\n", Instance.Name, err.Error())
+				}
+			}
 
 			FinishedTask(storage, task.Id, task.Retries, output, "finished")
 		} else if task.Action == ChangeProvidersTask {
@@ -437,6 +568,27 @@ func RunWorkerTasks(ctx context.Context, o Options, namePrefix string, storage S
 			}
 
 			FinishedTask(storage, task.Id, task.Retries, output, "finished")
+		} else if task.Action == RunHookTask || task.Action == RunPreDeprovisionHookTask {
+			glog.Infof("Running hook for database: %s\n", task.ResourceId)
+			RunHookTaskFromQueue(storage, namePrefix, cluster, task)
+		} else if task.Action == BootstrapLoggingTask {
+			glog.Infof("Bootstrapping logging for database: %s\n", task.ResourceId)
+			RunBootstrapLoggingTaskFromQueue(storage, namePrefix, cluster, task)
+		} else if task.Action == RemediateReadOnlyTask {
+			glog.Infof("Remediating read-only indices for database: %s\n", task.ResourceId)
+			RunRemediateReadOnlyTaskFromQueue(storage, namePrefix, cluster, task)
+		} else if task.Action == ExpireTask {
+			glog.Infof("Expiring database: %s\n", task.ResourceId)
+			RunExpireTaskFromQueue(storage, namePrefix, cluster, task)
+		} else if task.Action == RestoreTask {
+			glog.Infof("Restoring: %s\n", task.ResourceId)
+			RunRestoreTaskFromQueue(storage, namePrefix, cluster, task)
+		} else if task.Action == BindTask || task.Action == UnbindTask {
+			glog.Infof("Running %s for database: %s\n", task.Action, task.ResourceId)
+			RunBindingTaskFromQueue(storage, namePrefix, task)
+		} else if task.Action == BenchmarkTask {
+			glog.Infof("Benchmarking database: %s\n", task.ResourceId)
+			RunBenchmarkTaskFromQueue(storage, namePrefix, cluster, task)
 		}
 		// TODO: create binding NotifyCreateBindingWebhookTask
 
@@ -452,5 +604,6 @@ func RunBackgroundTasks(ctx context.Context, o Options) error {
 	}
 
 	go TickTocPreprovisionTasks(ctx, o, namePrefix, storage)
+	go NewMetricsCollector(namePrefix, storage).Run(ctx)
 	return RunWorkerTasks(ctx, o, namePrefix, storage)
 }
@@ -0,0 +1,110 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const timedOutResultPrefix = "Operation timed out"
+
+// An operation is made up of one or more task actions, if any of them have been
+// pending or running for longer than the timeout the operation is considered stuck.
+type OperationTimeout struct {
+	Operation string
+	Actions   []TaskAction
+	Env       string
+	Default   int64
+}
+
+var operationTimeouts = []OperationTimeout{
+	{Operation: "provision", Actions: []TaskAction{PerformPostProvisionTask, ResyncFromProviderUntilAvailableTask}, Env: "PROVISION_TIMEOUT", Default: 120},
+	{Operation: "modify", Actions: []TaskAction{ChangePlansTask, ResyncFromProviderTask}, Env: "MODIFY_TIMEOUT", Default: 240},
+	{Operation: "upgrade", Actions: []TaskAction{ChangeProvidersTask}, Env: "UPGRADE_TIMEOUT", Default: 480},
+}
+
+// Minutes returns the configured timeout, zero (or less) disables the timeout.
+func (o OperationTimeout) Minutes() int64 {
+	if os.Getenv(o.Env) == "" {
+		return o.Default
+	}
+	minutes, err := strconv.ParseInt(os.Getenv(o.Env), 10, 64)
+	if err != nil {
+		glog.Errorf("Invalid value for %s, using the default of %d minutes: %s\n", o.Env, o.Default, err.Error())
+		return o.Default
+	}
+	return minutes
+}
+
+func timedOutActions() []TaskAction {
+	actions := make([]TaskAction, 0)
+	for _, o := range operationTimeouts {
+		actions = append(actions, o.Actions...)
+	}
+	return actions
+}
+
+func alertStuckOperation(operation string, task *Task) {
+	glog.Errorf("ALERT: The %s operation for %s is stuck, task %s (%s) was marked as failed: %s\n", operation, task.ResourceId, task.Id, task.Action, task.Result)
+	if os.Getenv("STUCK_OPERATION_WEBHOOK") == "" {
+		return
+	}
+	byteData, err := json.Marshal(map[string]interface{}{
+		"operation": operation,
+		"instance":  task.ResourceId,
+		"task":      task.Id,
+		"action":    task.Action,
+		"result":    task.Result,
+	})
+	if err != nil {
+		glog.Errorf("Unable to marshal stuck operation alert: %s\n", err.Error())
+		return
+	}
+	client := &http.Client{Timeout: time.Second * 30}
+	resp, err := client.Post(os.Getenv("STUCK_OPERATION_WEBHOOK"), "application/json", bytes.NewReader(byteData))
+	if err != nil {
+		glog.Errorf("Unable to send stuck operation alert: %s\n", err.Error())
+		return
+	}
+	resp.Body.Close()
+}
+
+// FailStuckOperations marks any tasks that have exceeded their operations timeout as failed
+// so they are no longer retried, and so the platform is no longer told they're in progress.
+func FailStuckOperations(storage Storage) {
+	for _, o := range operationTimeouts {
+		minutes := o.Minutes()
+		if minutes <= 0 {
+			continue
+		}
+		for _, action := range o.Actions {
+			tasks, err := storage.FailStuckTasks(action, minutes, timedOutResultPrefix+" after "+strconv.FormatInt(minutes, 10)+" minutes")
+			if err != nil {
+				glog.Errorf("Unable to fail stuck %s tasks: %s\n", action, err.Error())
+				continue
+			}
+			for _, task := range tasks {
+				alertStuckOperation(o.Operation, &task)
+			}
+		}
+	}
+}
+
+// IsTimedOut returns the timed out task if the most recent provision, modify or upgrade
+// operation for the instance timed out.
+func IsTimedOut(storage Storage, Id string) (*Task, bool) {
+	task, err := storage.GetLastTaskIn(Id, timedOutActions())
+	if err != nil {
+		return nil, false
+	}
+	if task.Status == "failed" && strings.HasPrefix(task.Result, timedOutResultPrefix) {
+		return task, true
+	}
+	return nil, false
+}
@@ -0,0 +1,216 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// Instances of plans with a ttl (e.g., previews for review apps) are deleted once it
+// passes unless they are renewed, a final snapshot is taken first if
+// TTL_SNAPSHOT_REPOSITORY names a snapshot repository registered on the domains.
+
+type InstanceExpiry struct {
+	TTL     string     `json:"ttl,omitempty"`
+	Expires *time.Time `json:"expires"`
+}
+
+type snapshotStatus struct {
+	Snapshots []struct {
+		State string `json:"state"`
+	} `json:"snapshots"`
+}
+
+// ExpiresAt is when an instance of the plan created (or renewed) at now expires, nil if
+// the plan has no ttl.
+func ExpiresAt(plan *ProviderPlan, now time.Time) *time.Time {
+	if plan.TTL <= 0 {
+		return nil
+	}
+	expires := now.Add(plan.TTL)
+	return &expires
+}
+
+func finalSnapshotName(instance *Instance) string {
+	return "final-" + strings.ToLower(instance.Name)
+}
+
+// ExpireInstances schedules the deletion of instances past their ttl.
+func ExpireInstances(namePrefix string, storage Storage) {
+	if claimed, err := storage.ClaimSchedule("expire-instances", time.Minute*5); err != nil || !claimed {
+		return
+	}
+	ids, err := storage.GetExpiredInstances()
+	if err != nil {
+		glog.Errorf("Unable to get expired instances: %s\n", err.Error())
+		return
+	}
+	for _, id := range ids {
+		if task, err := storage.GetLastTask(id, ExpireTask); err == nil && (task.Status == "pending" || task.Status == "started") {
+			continue
+		}
+		if deleting, err := storage.IsDeleting(id); err != nil || deleting {
+			continue
+		}
+		if isFrozen(storage, id) {
+			glog.Infof("The ttl of %s has passed but it is frozen, it will not be deleted until it is unfrozen\n", id)
+			continue
+		}
+		instance, err := GetInstanceById(namePrefix, storage, id)
+		if err != nil {
+			glog.Errorf("Unable to expire %s: %s\n", id, err.Error())
+			continue
+		}
+		if DryRun(ExpireAction) {
+			ReportDryRun(storage, ExpireAction, instance.Id, "Would delete "+instance.Name+" as its ttl has passed")
+			continue
+		}
+		glog.Infof("The ttl of %s has passed, scheduling its deletion\n", instance.Name)
+		if _, err = storage.AddTask(instance.Id, ExpireTask, ""); err != nil {
+			glog.Errorf("Error: Unable to schedule the expiry of %s: %s\n", instance.Name, err.Error())
+			continue
+		}
+		if err = EmailContacts(storage, DeletionScheduledNotification, instance.Id, newInstanceNotification(instance)); err != nil {
+			glog.Errorf("Unable to email the contacts of %s: %s\n", instance.Name, err.Error())
+		}
+	}
+}
+
+// finalSnapshot starts (or checks on) the final snapshot (named name) of an instance, it
+// returns true once the snapshot has succeeded.
+func finalSnapshot(cluster *ClusterClient, instance *Instance, repository string, name string) (bool, error) {
+	path := "/_snapshot/" + url.PathEscape(repository) + "/" + url.PathEscape(name)
+	response, status, err := cluster.Do(instance, "GET", path, nil)
+	if err != nil {
+		return false, err
+	}
+	if status == 404 {
+		if _, status, err = cluster.Do(instance, "PUT", path, nil); err != nil {
+			return false, err
+		}
+		if status != 200 {
+			return false, errors.New("Unable to start the final snapshot, " + path + " returned " + strconv.Itoa(status))
+		}
+		return false, nil
+	}
+	if status != 200 {
+		return false, errors.New(path + " returned " + strconv.Itoa(status))
+	}
+	var snapshot snapshotStatus
+	if err = json.Unmarshal(response, &snapshot); err != nil {
+		return false, err
+	}
+	if len(snapshot.Snapshots) == 0 {
+		return false, errors.New(path + " did not return the snapshot")
+	}
+	switch snapshot.Snapshots[0].State {
+	case "SUCCESS":
+		return true, nil
+	case "IN_PROGRESS":
+		return false, nil
+	}
+	return false, errors.New("The final snapshot " + strings.ToLower(snapshot.Snapshots[0].State))
+}
+
+func RunExpireTaskFromQueue(storage Storage, namePrefix string, cluster *ClusterClient, task *Task) {
+	// the final snapshot has two hours to finish
+	if task.Retries >= 120 {
+		FinishedTask(storage, task.Id, task.Retries, "Unable to expire the instance ("+task.Result+")", "failed")
+		return
+	}
+	instance, err := GetInstanceById(namePrefix, storage, task.ResourceId)
+	if err != nil {
+		UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot get Instance: "+err.Error(), "pending")
+		return
+	}
+	expires, err := storage.GetExpires(instance.Id)
+	if err != nil {
+		UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot get the expiry: "+err.Error(), "pending")
+		return
+	}
+	if expires == nil || expires.After(time.Now()) {
+		FinishedTask(storage, task.Id, task.Retries, "The instance was renewed", "finished")
+		return
+	}
+	if repository := os.Getenv("TTL_SNAPSHOT_REPOSITORY"); repository != "" {
+		done, err := finalSnapshot(cluster, instance, repository, finalSnapshotName(instance))
+		if err != nil {
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, err.Error(), "pending")
+			return
+		}
+		if !done {
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "Waiting on the final snapshot "+finalSnapshotName(instance), "pending")
+			return
+		}
+	} else {
+		glog.Infof("TTL_SNAPSHOT_REPOSITORY is not set, %s expires without a final snapshot\n", instance.Name)
+	}
+	hookTask, err := ScheduleHooksThen(storage, instance, PreDeprovisionHook, DeleteTask, instance.Name)
+	if err != nil {
+		UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot schedule the pre-deprovision hooks: "+err.Error(), "pending")
+		return
+	}
+	if hookTask == "" {
+		if _, err = storage.AddTask(instance.Id, DeleteTask, instance.Name); err != nil {
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot schedule the delete: "+err.Error(), "pending")
+			return
+		}
+	}
+	FinishedTask(storage, task.Id, task.Retries, "Expired, the delete has been scheduled", "finished")
+}
+
+// GET /v2/service_instances/{instance_id}/actions/expiry
+func (b *BusinessLogic) GetExpiryAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	instance, err := b.GetInstanceById(InstanceID)
+	if err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Error finding instance id (during get expiry): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	expires, err := b.storage.GetExpires(InstanceID)
+	if err != nil {
+		glog.Errorf("Unable to get the expiry of %s: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	expiry := InstanceExpiry{Expires: expires}
+	if instance.Plan.TTL > 0 {
+		expiry.TTL = instance.Plan.TTL.String()
+	}
+	return expiry, nil
+}
+
+// POST /v2/service_instances/{instance_id}/actions/renew pushes the expiry of an
+// instance back by its plans ttl.
+func (b *BusinessLogic) RenewAction(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	instance, err := b.GetInstanceById(InstanceID)
+	if err != nil && err.Error() == "Cannot find resource instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Error finding instance id (during renew): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	if instance.Plan.TTL <= 0 {
+		return nil, UnprocessableEntityWithMessage("NoTTL", "The plan of this instance does not have a ttl.")
+	}
+	if deleting, err := b.storage.IsDeleting(InstanceID); err != nil {
+		glog.Errorf("Unable to determine if %s is being deleted: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	} else if deleting {
+		return nil, UnprocessableEntityWithMessage("Expired", "The instance has expired and is being deleted.")
+	}
+	expires := ExpiresAt(instance.Plan, time.Now())
+	if err = b.storage.SetExpires(InstanceID, expires); err != nil {
+		glog.Errorf("Unable to renew %s: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	glog.Infof("Renewed %s until %s\n", instance.Name, expires.Format(time.RFC3339))
+	return InstanceExpiry{TTL: instance.Plan.TTL.String(), Expires: expires}, nil
+}
@@ -0,0 +1,221 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elasticsearchservice"
+	"github.com/golang/glog"
+)
+
+// PlanValidationError is returned when a plan asks aws for a configuration it
+// is known to reject, the message is safe to return to the platform.
+type PlanValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *PlanValidationError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+func invalidPlan(field string, format string, args ...interface{}) error {
+	return &PlanValidationError{Field: field, Message: fmt.Sprintf(format, args...)}
+}
+
+// configuredSubnets returns the subnets instances are placed in, or nil if
+// instances are not created inside of a vpc.
+func configuredSubnets() []string {
+	if os.Getenv("AWS_SECURITY_GROUP_ID") == "" || os.Getenv("AWS_SUBNET_ID") == "" {
+		return nil
+	}
+	subnetIds := make([]string, 0)
+	for _, subnetId := range strings.Split(os.Getenv("AWS_SUBNET_ID"), ",") {
+		if strings.TrimSpace(subnetId) != "" {
+			subnetIds = append(subnetIds, strings.TrimSpace(subnetId))
+		}
+	}
+	return subnetIds
+}
+
+// ValidateZoneAwareness checks the zone awareness of a cluster against its data
+// node count and the subnets available. Harmless mistakes are corrected in place
+// (a missing availability zone count defaults to 2 as it does in aws, and a zone
+// awareness config without zone awareness enabled is dropped), anything aws would
+// reject is returned as a PlanValidationError.
+func ValidateZoneAwareness(config *elasticsearchservice.ElasticsearchClusterConfig, subnetIds []string) error {
+	if config == nil {
+		return nil
+	}
+	if !aws.BoolValue(config.ZoneAwarenessEnabled) {
+		if config.ZoneAwarenessConfig != nil {
+			glog.Infof("Ignoring the ZoneAwarenessConfig of a plan as ZoneAwarenessEnabled is false.\n")
+			config.ZoneAwarenessConfig = nil
+		}
+		return nil
+	}
+	if config.ZoneAwarenessConfig == nil || config.ZoneAwarenessConfig.AvailabilityZoneCount == nil {
+		config.ZoneAwarenessConfig = &elasticsearchservice.ZoneAwarenessConfig{AvailabilityZoneCount: aws.Int64(2)}
+	}
+	zones := aws.Int64Value(config.ZoneAwarenessConfig.AvailabilityZoneCount)
+	if zones != 2 && zones != 3 {
+		return invalidPlan("ElasticsearchClusterConfig.ZoneAwarenessConfig.AvailabilityZoneCount", "must be 2 or 3, not %d", zones)
+	}
+	instances := aws.Int64Value(config.InstanceCount)
+	if instances < zones {
+		return invalidPlan("ElasticsearchClusterConfig.InstanceCount", "%d data nodes cannot be spread across %d availability zones, at least %d are required", instances, zones, zones)
+	}
+	if zones == 2 && instances%2 != 0 {
+		return invalidPlan("ElasticsearchClusterConfig.InstanceCount", "an even number of data nodes is required for two availability zones, not %d", instances)
+	}
+	if zones == 3 && instances%3 != 0 {
+		glog.Infof("Warning: %d data nodes will be unevenly spread across three availability zones.\n", instances)
+	}
+	if subnetIds != nil && int64(len(subnetIds)) < zones {
+		return invalidPlan("ElasticsearchClusterConfig.ZoneAwarenessConfig.AvailabilityZoneCount", "%d availability zones requires %d subnets, only %d are configured in AWS_SUBNET_ID", zones, zones, len(subnetIds))
+	}
+	return nil
+}
+
+// domainSubnets picks one subnet per availability zone the cluster uses, aws
+// rejects domains given more subnets than zones.
+func domainSubnets(config *elasticsearchservice.ElasticsearchClusterConfig, subnetIds []string) []string {
+	zones := 1
+	if config != nil && aws.BoolValue(config.ZoneAwarenessEnabled) && config.ZoneAwarenessConfig != nil {
+		zones = int(aws.Int64Value(config.ZoneAwarenessConfig.AvailabilityZoneCount))
+	}
+	if zones > len(subnetIds) {
+		zones = len(subnetIds)
+	}
+	return subnetIds[0:zones]
+}
+
+// burstableUnsupported lists the features aws does not support on burstable (t2
+// and t3) instance types, its errors for these are rarely clear about why.
+var burstableUnsupported = map[string][]string{
+	"t2": []string{"EncryptionAtRestOptions", "UltraWarm", "AutoTuneOptions"},
+	"t3": []string{"EncryptionAtRestOptions", "UltraWarm", "AutoTuneOptions"},
+}
+
+// autoTuneEnabled reads the auto-tune options from the raw plan details, they
+// are not part of the create domain input in the aws sdk.
+func autoTuneEnabled(details []byte) bool {
+	var options struct {
+		AutoTuneOptions *struct {
+			DesiredState string
+		}
+	}
+	if err := json.Unmarshal(details, &options); err != nil || options.AutoTuneOptions == nil {
+		return false
+	}
+	return strings.ToUpper(options.AutoTuneOptions.DesiredState) == "ENABLED"
+}
+
+// ValidateInstanceTypes rejects burstable instance types (for data or dedicated
+// master nodes) combined with features aws does not support on them.
+func ValidateInstanceTypes(settings *elasticsearchservice.CreateElasticsearchDomainInput, details []byte) error {
+	config := settings.ElasticsearchClusterConfig
+	if config == nil {
+		return nil
+	}
+	enabled := map[string]bool{
+		"EncryptionAtRestOptions": settings.EncryptionAtRestOptions != nil && aws.BoolValue(settings.EncryptionAtRestOptions.Enabled),
+		"UltraWarm":               aws.BoolValue(config.WarmEnabled),
+		"AutoTuneOptions":         autoTuneEnabled(details),
+	}
+	descriptions := map[string]string{
+		"EncryptionAtRestOptions": "encryption at rest",
+		"UltraWarm":               "UltraWarm storage",
+		"AutoTuneOptions":         "Auto-Tune",
+	}
+	nodes := []struct {
+		field        string
+		instanceType *string
+	}{
+		{"ElasticsearchClusterConfig.InstanceType", config.InstanceType},
+		{"ElasticsearchClusterConfig.DedicatedMasterType", config.DedicatedMasterType},
+	}
+	for _, node := range nodes {
+		if node.instanceType == nil || (node.field == "ElasticsearchClusterConfig.DedicatedMasterType" && !aws.BoolValue(config.DedicatedMasterEnabled)) {
+			continue
+		}
+		family := strings.SplitN(aws.StringValue(node.instanceType), ".", 2)[0]
+		for _, feature := range burstableUnsupported[family] {
+			if enabled[feature] {
+				return invalidPlan(node.field, "%s instances do not support %s, use a larger instance type (e.g., m5 or r5) or disable %s", aws.StringValue(node.instanceType), descriptions[feature], feature)
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateDomainInput validates (and where safe corrects) the settings rendered
+// from a plan before they are sent to aws.
+func ValidateDomainInput(settings *elasticsearchservice.CreateElasticsearchDomainInput, details []byte) error {
+	if err := ValidateInstanceTypes(settings, details); err != nil {
+		return err
+	}
+	return ValidateZoneAwareness(settings.ElasticsearchClusterConfig, configuredSubnets())
+}
+
+// planDomainInput renders the details of a plan with placeholder values into the
+// request that would be sent to aws.
+func planDomainInput(plan *ProviderPlan) (*elasticsearchservice.CreateElasticsearchDomainInput, error) {
+	details, err := plan.RenderPrivateDetails(NewPlanTemplateData("00000000-0000-0000-0000-000000000000", "validation", ""))
+	if err != nil {
+		return nil, err
+	}
+	return BuildCreateDomainInput("validation", details)
+}
+
+// ValidatePlan validates the details of a plan (including against the limits of its
+// instance type), so a plan aws would reject is refused before any work is scheduled.
+func ValidatePlan(plan *ProviderPlan) error {
+	if plan.Provider == AWSServerlessInstance {
+		return validateServerlessPlan(plan)
+	} else if plan.Provider == ElasticCloudInstance {
+		return validateElasticCloudPlan(plan)
+	} else if plan.Provider == ECKInstance {
+		return validateECKPlan(plan)
+	} else if plan.Provider == OpenSearchOperatorInstance {
+		return validateOpenSearchOperatorPlan(plan)
+	} else if plan.Provider == DockerInstance {
+		return validateDockerPlan(plan)
+	}
+	if !plan.Provider.IsAWSDomain() {
+		return nil
+	}
+	settings, err := planDomainInput(plan)
+	if err != nil {
+		return err
+	}
+	if SimulationMode() {
+		return nil
+	}
+	return ValidateLimits(settings)
+}
+
+// ReportInvalidPlans logs every plan that would be rejected when provisioned,
+// this never prevents the broker from starting.
+func ReportInvalidPlans(storage Storage) {
+	services, err := storage.GetServices()
+	if err != nil {
+		glog.Errorf("Unable to validate plans, cannot get services: %s\n", err.Error())
+		return
+	}
+	for _, service := range services {
+		plans, err := storage.GetPlans(service.ID)
+		if err != nil {
+			glog.Errorf("Unable to validate plans, cannot get the plans of %s: %s\n", service.Name, err.Error())
+			continue
+		}
+		for _, plan := range plans {
+			if invalid, ok := ValidatePlan(&plan).(*PlanValidationError); ok {
+				glog.Errorf("Warning: The plan %s (%s) is invalid and will be rejected: %s\n", plan.basePlan.Name, plan.ID, invalid.Error())
+			}
+		}
+	}
+}
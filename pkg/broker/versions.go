@@ -0,0 +1,129 @@
+package broker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+// EngineVersions are the engine versions aws offers for new domains and the versions each
+// can be upgraded to, they are refreshed (for elasticsearch from the elasticsearch service
+// api, for opensearch from the OpenSearch api) at most every ENGINE_VERSIONS_REFRESH_INTERVAL
+// minutes (default 60) as the catalog is requested.
+type EngineVersions struct {
+	Offered   []string
+	Upgrades  map[string][]string
+	Refreshed time.Time
+}
+
+// PlanEngineVersions is published in the metadata of each plan as engine_versions.
+type PlanEngineVersions struct {
+	// The versions new instances can be created with, versions past their end of support are left out.
+	Offered []string `json:"offered"`
+	// Whether the plans own version is still offered.
+	Available      bool      `json:"available"`
+	UpgradeTargets []string  `json:"upgrade_targets"`
+	RefreshedAt    time.Time `json:"refreshed_at"`
+}
+
+var engineVersions struct {
+	sync.Mutex
+	versions map[string]*EngineVersions
+	checked  map[string]time.Time
+}
+
+func engineVersionsRefreshInterval() time.Duration {
+	return time.Duration(envInt("ENGINE_VERSIONS_REFRESH_INTERVAL", 60)) * time.Minute
+}
+
+func refreshEngineVersions(domains DomainService) (*EngineVersions, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), domainRequestTimeout())
+	defer cancel()
+	offered, err := domains.ListVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	upgrades, err := domains.CompatibleVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &EngineVersions{Offered: offered, Upgrades: upgrades, Refreshed: time.Now()}, nil
+}
+
+// GetEngineVersions returns the versions of the engine (elasticsearch or opensearch),
+// refreshing them if they are stale. If they can't be refreshed the last versions are kept
+// (nil if there are none) and the refresh is not tried again until the next interval. There
+// are none in simulation mode.
+func GetEngineVersions(engine string) *EngineVersions {
+	engineVersions.Lock()
+	defer engineVersions.Unlock()
+	if engineVersions.versions == nil {
+		engineVersions.versions = make(map[string]*EngineVersions)
+		engineVersions.checked = make(map[string]time.Time)
+	}
+	if time.Now().Sub(engineVersions.checked[engine]) < engineVersionsRefreshInterval() {
+		return engineVersions.versions[engine]
+	}
+	engineVersions.checked[engine] = time.Now()
+	if SimulationMode() {
+		return nil
+	}
+	domains := NewDomainService()
+	if engine == "opensearch" {
+		domains = NewOpenSearchDomainService()
+	}
+	versions, err := refreshEngineVersions(domains)
+	if err != nil {
+		glog.Errorf("Unable to refresh the %s engine versions: %s\n", engine, err.Error())
+		return engineVersions.versions[engine]
+	}
+	engineVersions.versions[engine] = versions
+	return versions
+}
+
+func notEOL(supports []EngineSupport, engine string, versions []string, now time.Time) []string {
+	filtered := make([]string, 0)
+	for _, version := range versions {
+		if GetEngineSupportStatus(supports, engine, version, now).Status != EngineEOL {
+			filtered = append(filtered, version)
+		}
+	}
+	return filtered
+}
+
+// PlanVersions returns the engine versions of a plan running the engine at version.
+func (v *EngineVersions) PlanVersions(supports []EngineSupport, engine string, version string) PlanEngineVersions {
+	now := time.Now()
+	offered := notEOL(supports, engine, v.Offered, now)
+	available := false
+	for _, o := range offered {
+		if o == version {
+			available = true
+		}
+	}
+	return PlanEngineVersions{
+		Offered:        offered,
+		Available:      available,
+		UpgradeTargets: notEOL(supports, engine, v.Upgrades[version], now),
+		RefreshedAt:    v.Refreshed,
+	}
+}
+
+// AddEngineVersions adds the engine_versions of each plan to its catalog metadata, plans are
+// left without them if the versions of their engine were never fetched.
+func AddEngineVersions(services []osb.Service, supports []EngineSupport) {
+	for _, service := range services {
+		for _, plan := range service.Plans {
+			engine, ok := plan.Metadata["engine"].(map[string]string)
+			if !ok || engine["version"] == "" {
+				continue
+			}
+			if versions := GetEngineVersions(engine["type"]); versions != nil {
+				plan.Metadata["engine_versions"] = versions.PlanVersions(supports, engine["type"], engine["version"])
+			}
+		}
+	}
+}
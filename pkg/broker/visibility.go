@@ -0,0 +1,155 @@
+package broker
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// Plans can be limited to specific organizations or spaces (e.g., expensive or experimental
+// plans) with visibility rules, managed with the admin catalog api. A plan with allow rules
+// is only visible to the organizations and spaces they list, deny rules hide a plan from an
+// organization or space even if it is allowed. Platforms that pass organization_guid and
+// space_guid on the catalog request get only the plans visible to them, the full catalog is
+// returned otherwise (platforms cache it), and provisions and plan changes to a plan that is
+// not visible to the organization or space are rejected.
+
+const (
+	VisibilityOrganization = "organization"
+	VisibilitySpace        = "space"
+	VisibilityAllow        = "allow"
+	VisibilityDeny         = "deny"
+)
+
+type VisibilityRule struct {
+	Plan   string `json:"-"`
+	Type   string `json:"type"`
+	Id     string `json:"id"`
+	Effect string `json:"effect"`
+}
+
+func (r *VisibilityRule) matches(org string, space string) bool {
+	return (r.Type == VisibilityOrganization && org != "" && r.Id == org) || (r.Type == VisibilitySpace && space != "" && r.Id == space)
+}
+
+func validateVisibilityRule(rule *VisibilityRule) error {
+	if rule.Type != VisibilityOrganization && rule.Type != VisibilitySpace {
+		return UnprocessableEntityWithMessage("InvalidVisibility", "The type must be organization or space.")
+	}
+	if rule.Effect != VisibilityAllow && rule.Effect != VisibilityDeny {
+		return UnprocessableEntityWithMessage("InvalidVisibility", "The effect must be allow or deny.")
+	}
+	if rule.Id == "" {
+		return UnprocessableEntityWithMessage("InvalidVisibility", "The id of the organization or space is required.")
+	}
+	return nil
+}
+
+// planVisible returns whether the rules of a plan let the organization or space see it.
+func planVisible(rules []VisibilityRule, org string, space string) bool {
+	restricted := false
+	allowed := false
+	for _, rule := range rules {
+		if rule.Effect == VisibilityAllow {
+			restricted = true
+		}
+		if !rule.matches(org, space) {
+			continue
+		}
+		if rule.Effect == VisibilityDeny {
+			return false
+		}
+		allowed = true
+	}
+	return !restricted || allowed
+}
+
+// filterCatalog removes the plans that are not visible to the organization or space.
+func filterCatalog(services []osb.Service, rules []VisibilityRule, org string, space string) []osb.Service {
+	byPlan := make(map[string][]VisibilityRule)
+	for _, rule := range rules {
+		byPlan[rule.Plan] = append(byPlan[rule.Plan], rule)
+	}
+	for i, service := range services {
+		plans := make([]osb.Plan, 0)
+		for _, plan := range service.Plans {
+			if planVisible(byPlan[plan.ID], org, space) {
+				plans = append(plans, plan)
+			}
+		}
+		services[i].Plans = plans
+	}
+	return services
+}
+
+// catalogCaller is the organization and space a catalog request is made for, if the platform
+// passed them.
+func catalogCaller(c *broker.RequestContext) (string, string) {
+	if c == nil || c.Request == nil {
+		return "", ""
+	}
+	return c.Request.URL.Query().Get("organization_guid"), c.Request.URL.Query().Get("space_guid")
+}
+
+// checkPlanVisible rejects provisioning (or changing to) a plan that is not visible to the
+// organization or space.
+func (b *BusinessLogic) checkPlanVisible(plan *ProviderPlan, org string, space string) error {
+	rules, err := b.storage.GetPlanVisibility(plan.ID)
+	if err != nil {
+		glog.Errorf("Unable to get the visibility of the plan %s: %s\n", plan.ID, err.Error())
+		return InternalServerError()
+	}
+	if !planVisible(rules, org, space) {
+		glog.Infof("Rejected the plan %s for the organization %s and space %s, it is not visible to them\n", plan.ID, org, space)
+		return UnprocessableEntityWithMessage("PlanNotAvailable", "The plan "+plan.basePlan.Name+" is not available to this organization or space.")
+	}
+	return nil
+}
+
+// routePlanVisibility adds GET and PUT (replace) /v2/admin/catalog/plans/{plan_id}/visibility
+// to the admin catalog api.
+func (b *BusinessLogic) routePlanVisibility(router *mux.Router) {
+	router.HandleFunc("/v2/admin/catalog/plans/{plan_id}/visibility", catalogHandler(func(actor string, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		if _, err := b.storage.GetCatalogPlan(mux.Vars(r)["plan_id"]); err != nil && err.Error() == "Not found" {
+			return nil, NotFound()
+		} else if err != nil {
+			glog.Errorf("Unable to get the plan %s: %s\n", mux.Vars(r)["plan_id"], err.Error())
+			return nil, InternalServerError()
+		}
+		rules, err := b.storage.GetPlanVisibility(mux.Vars(r)["plan_id"])
+		if err != nil {
+			glog.Errorf("Unable to get the visibility of the plan %s: %s\n", mux.Vars(r)["plan_id"], err.Error())
+			return nil, InternalServerError()
+		}
+		return rules, nil
+	})).Methods("GET")
+	router.HandleFunc("/v2/admin/catalog/plans/{plan_id}/visibility", catalogHandler(func(actor string, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		plan, err := b.storage.GetCatalogPlan(mux.Vars(r)["plan_id"])
+		if err != nil && err.Error() == "Not found" {
+			return nil, NotFound()
+		} else if err != nil {
+			glog.Errorf("Unable to get the plan %s: %s\n", mux.Vars(r)["plan_id"], err.Error())
+			return nil, InternalServerError()
+		}
+		var rules []VisibilityRule
+		if err = json.NewDecoder(r.Body).Decode(&rules); err != nil {
+			return nil, UnprocessableEntityWithMessage("InvalidVisibility", "The body must be a list of visibility rules.")
+		}
+		for i := range rules {
+			if err = validateVisibilityRule(&rules[i]); err != nil {
+				return nil, err
+			}
+			rules[i].Plan = plan.Id
+		}
+		if err = b.storage.SetPlanVisibility(plan.Id, rules); err != nil {
+			glog.Errorf("Unable to set the visibility of the plan %s: %s\n", plan.Id, err.Error())
+			return nil, InternalServerError()
+		}
+		glog.Infof("%s set the visibility of the plan %s (%s) to %d rules\n", actor, plan.Name, plan.Id, len(rules))
+		return b.storage.GetPlanVisibility(plan.Id)
+	})).Methods("PUT")
+}